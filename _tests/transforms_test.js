@@ -0,0 +1,57 @@
+// Tests of the router's library of njs transformations, run with the njs CLI by `make test-njs`.
+// Each handler is passed a stand-in for the request, holding only the variables and headers it reads.
+
+import transforms from "transforms.js";
+
+var failures = 0;
+
+function expect(ok, message) {
+    if (!ok) {
+        failures++;
+        console.log("FAIL: " + message);
+    }
+}
+
+function request(variables, headersOut) {
+    return {
+        variables: variables,
+        headersOut: headersOut || {}
+    };
+}
+
+function testAllowResponseHeaders() {
+    var r = request({deis_allowed_response_headers: "content-type,x-request-id"}, {
+        "Content-Type": "text/plain",
+        "X-Request-ID": "abc123",
+        "X-Powered-By": "PHP",
+        "Server": "Apache"
+    });
+    transforms.deisAllowResponseHeaders(r);
+    var names = Object.keys(r.headersOut).sort().join(",");
+    expect(names == "Content-Type,X-Request-ID", "expected only the allowed headers, matched whatever their case, but got " + names);
+
+    // Nothing is allowed unless it is listed.
+    r = request({}, {"X-Powered-By": "PHP"});
+    transforms.deisAllowResponseHeaders(r);
+    expect(Object.keys(r.headersOut).length == 0, "expected every header to be removed, but got " + Object.keys(r.headersOut));
+}
+
+function testTLSFingerprint() {
+    var r = request({ssl_protocol: "TLSv1.3", ssl_ciphers: "TLS_AES_128_GCM_SHA256:TLS_AES_256_GCM_SHA384", ssl_curves: "X25519:prime256v1"});
+    var fingerprint = transforms.deisTLSFingerprint(r);
+    expect(/^[0-9a-f]{32}$/.test(fingerprint), "expected a hex MD5 hash, but got " + fingerprint);
+    r.variables.ssl_curves = "prime256v1";
+    expect(transforms.deisTLSFingerprint(r) != fingerprint, "expected the offered curves to change the fingerprint");
+    r.variables.ssl_curves = "X25519:prime256v1";
+    expect(transforms.deisTLSFingerprint(r) == fingerprint, "expected the same handshake to have the same fingerprint");
+
+    // Connections without TLS have no fingerprint.
+    expect(transforms.deisTLSFingerprint(request({})) === "", "expected no fingerprint without TLS");
+}
+
+testAllowResponseHeaders();
+testTLSFingerprint();
+if (failures > 0) {
+    throw new Error(failures + " transformation test(s) failed");
+}
+console.log("ok");
@@ -0,0 +1,402 @@
+package acme
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/deis/router/model"
+	"github.com/deis/router/utils"
+	xacme "golang.org/x/crypto/acme"
+	"k8s.io/client-go/1.4/kubernetes"
+	"k8s.io/client-go/1.4/pkg/api/errors"
+	"k8s.io/client-go/1.4/pkg/api/v1"
+)
+
+const (
+	// ChallengeAddr is the address on which pending HTTP-01 challenges are answered.  nginx proxies
+	// requests for /.well-known/acme-challenge/ to it.
+	ChallengeAddr = "127.0.0.1:9092"
+	// accountSecretName is the name of the secret, in the router's own namespace, in which the ACME
+	// account key is stored.
+	accountSecretName = "deis-router-acme-account"
+	// checkInterval is how often certificates are checked for impending expiry in the absence of any
+	// change to the router's configuration.
+	checkInterval = time.Hour
+	// retryInterval is how long to wait before again attempting to obtain a certificate for a domain
+	// after failing to do so.  Certificate authorities limit the rate of failed validations.
+	retryInterval = time.Hour
+	// leaseDuration bounds how long a single replica may hold the exclusive right to request
+	// certificates without renewing it.  It is renewed before each certificate is ordered, so it must
+	// exceed orderTimeout.
+	leaseDuration = 10 * time.Minute
+	// orderTimeout bounds how long to wait for any one certificate to be issued.
+	orderTimeout = 5 * time.Minute
+)
+
+// Manager obtains and renews certificates for the domains of applications that have opted in, and
+// stores them in k8s secrets where the router's model will find them.
+type Manager struct {
+	kubeClient   *kubernetes.Clientset
+	namespace    string
+	identity     string
+	store        stateStore
+	configs      chan *model.RouterConfig
+	client       *xacme.Client
+	clientConfig model.ACMEConfig
+	nextAttempts map[string]time.Time
+}
+
+// NewManager returns a Manager that stores its own state in the router's namespace.
+func NewManager(kubeClient *kubernetes.Clientset) *Manager {
+	namespace := utils.GetOpt("POD_NAMESPACE", "default")
+	identity, err := os.Hostname()
+	if err != nil {
+		identity = fmt.Sprintf("deis-router-%d", os.Getpid())
+	}
+	return &Manager{
+		kubeClient:   kubeClient,
+		namespace:    namespace,
+		identity:     identity,
+		store:        &configMapStateStore{kubeClient: kubeClient, namespace: namespace},
+		configs:      make(chan *model.RouterConfig, 1),
+		nextAttempts: map[string]time.Time{},
+	}
+}
+
+// ServeChallenges starts an HTTP server in the background that answers pending HTTP-01 challenges.
+// Every router replica must do so, since the certificate authority's validation request may reach
+// any of them.
+func (m *Manager) ServeChallenges() {
+	mux := http.NewServeMux()
+	mux.Handle(challengePathPrefix, challengeHandler(m.store))
+	go func() {
+		if err := http.ListenAndServe(ChallengeAddr, mux); err != nil {
+			log.Printf("WARN: ACME challenge server stopped: %v", err)
+		}
+	}()
+}
+
+// Update provides the Manager with the router's latest configuration.  It never blocks.  If an
+// earlier configuration has not yet been acted upon, it is discarded.
+func (m *Manager) Update(routerConfig *model.RouterConfig) {
+	select {
+	case <-m.configs:
+	default:
+	}
+	m.configs <- routerConfig
+}
+
+// Run obtains and renews certificates as needed, whenever the router's configuration changes and
+// periodically thereafter, until stopCh is closed.
+func (m *Manager) Run(stopCh <-chan struct{}) {
+	var routerConfig *model.RouterConfig
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case routerConfig = <-m.configs:
+		case <-ticker.C:
+		case <-stopCh:
+			return
+		}
+		if routerConfig != nil {
+			m.sync(routerConfig)
+		}
+	}
+}
+
+// domain is a single domain for which a certificate is to be obtained.
+type domain struct {
+	name      string
+	namespace string
+}
+
+func (m *Manager) sync(routerConfig *model.RouterConfig) {
+	acmeConfig := routerConfig.ACMEConfig
+	if acmeConfig == nil || !acmeConfig.Enabled {
+		return
+	}
+	now := time.Now()
+	renewBefore := time.Duration(acmeConfig.RenewBeforeDays) * 24 * time.Hour
+	domains := []domain{}
+	for _, d := range domainsNeedingCerts(routerConfig, now.Add(renewBefore)) {
+		if now.Before(m.nextAttempts[d.name]) {
+			continue
+		}
+		domains = append(domains, d)
+	}
+	if len(domains) == 0 {
+		return
+	}
+	if !m.holdLease() {
+		return
+	}
+	defer func() {
+		if err := m.store.releaseLease(m.identity); err != nil {
+			log.Printf("WARN: Failed to release ACME lease: %v", err)
+		}
+	}()
+	client, err := m.getClient(acmeConfig)
+	if err != nil {
+		log.Printf("WARN: Failed to register ACME account: %v", err)
+		return
+	}
+	for _, d := range domains {
+		// Registering and each earlier order may each have taken up to orderTimeout, so the lease is
+		// renewed before every order, and no more are placed once another replica has taken it over.
+		if !m.holdLease() {
+			return
+		}
+		log.Printf("INFO: Obtaining a certificate for %s.", d.name)
+		if err := m.obtainCertificate(client, d); err != nil {
+			log.Printf("WARN: Failed to obtain a certificate for %s; retrying in %s: %v", d.name, retryInterval, err)
+			m.nextAttempts[d.name] = time.Now().Add(retryInterval)
+			continue
+		}
+		delete(m.nextAttempts, d.name)
+		log.Printf("INFO: Obtained a certificate for %s.", d.name)
+	}
+}
+
+// holdLease acquires, or renews, the exclusive right to request certificates for another
+// leaseDuration, and returns whether it is held.
+func (m *Manager) holdLease() bool {
+	acquired, err := m.store.acquireLease(m.identity, leaseDuration)
+	if err != nil {
+		log.Printf("WARN: Failed to acquire ACME lease: %v", err)
+		return false
+	}
+	if !acquired {
+		log.Println("INFO: Another router replica is obtaining certificates.")
+	}
+	return acquired
+}
+
+// domainsNeedingCerts returns all domains that are managed by ACME and that either lack a
+// certificate or have one that expires before the specified time.  Domains are returned in a
+// stable order.
+func domainsNeedingCerts(routerConfig *model.RouterConfig, renewBy time.Time) []domain {
+	domains := []domain{}
+	seen := map[string]bool{}
+	for _, appConfig := range routerConfig.AppConfigs {
+		for _, name := range appConfig.ACMEDomains {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			certificate := appConfig.Certificates[name]
+			if certificate != nil {
+				notAfter, err := certificateExpiry(certificate.Cert)
+				if err != nil {
+					log.Printf("WARN: Failed to parse the certificate for %s: %v", name, err)
+				} else if notAfter.After(renewBy) {
+					continue
+				}
+			}
+			domains = append(domains, domain{name: name, namespace: appConfig.Namespace})
+		}
+	}
+	sort.Sort(domainsByName(domains))
+	return domains
+}
+
+type domainsByName []domain
+
+func (d domainsByName) Len() int           { return len(d) }
+func (d domainsByName) Less(i, j int) bool { return d[i].name < d[j].name }
+func (d domainsByName) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// certificateExpiry returns the time at which the first certificate in the provided PEM-encoded
+// chain expires.
+func certificateExpiry(certPEM string) (time.Time, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return time.Time{}, fmt.Errorf("no PEM data found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
+
+// getClient returns an ACME client for the configured certificate authority, registering an account
+// if necessary.
+func (m *Manager) getClient(acmeConfig *model.ACMEConfig) (*xacme.Client, error) {
+	if m.client != nil && m.clientConfig == *acmeConfig {
+		return m.client, nil
+	}
+	key, err := m.getAccountKey()
+	if err != nil {
+		return nil, err
+	}
+	client := &xacme.Client{Key: key, DirectoryURL: acmeConfig.DirectoryURL}
+	account := &xacme.Account{}
+	if acmeConfig.Email != "" {
+		account.Contact = []string{"mailto:" + acmeConfig.Email}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), orderTimeout)
+	defer cancel()
+	if _, err := client.Register(ctx, account, xacme.AcceptTOS); err != nil && err != xacme.ErrAccountAlreadyExists {
+		return nil, err
+	}
+	m.client = client
+	m.clientConfig = *acmeConfig
+	return client, nil
+}
+
+// getAccountKey returns the ACME account key stored in the router's namespace, generating and
+// storing one first if necessary.
+func (m *Manager) getAccountKey() (crypto.Signer, error) {
+	secrets := m.kubeClient.Secrets(m.namespace)
+	secret, err := secrets.Get(accountSecretName)
+	if err == nil {
+		block, _ := pem.Decode(secret.Data["key.pem"])
+		if block == nil {
+			return nil, fmt.Errorf("secret %s contains no PEM-encoded key", accountSecretName)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+	key, keyPEM, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+	secret = &v1.Secret{Data: map[string][]byte{"key.pem": keyPEM}}
+	secret.Name = accountSecretName
+	secret.Namespace = m.namespace
+	if _, err := secrets.Create(secret); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// obtainCertificate orders a certificate for the specified domain, answers the resulting HTTP-01
+// challenges, and stores the issued certificate and its key in a secret in the domain's
+// application's namespace.
+func (m *Manager) obtainCertificate(client *xacme.Client, d domain) error {
+	ctx, cancel := context.WithTimeout(context.Background(), orderTimeout)
+	defer cancel()
+	order, err := client.AuthorizeOrder(ctx, xacme.DomainIDs(d.name))
+	if err != nil {
+		return err
+	}
+	for _, authzURL := range order.AuthzURLs {
+		if err := m.authorize(ctx, client, authzURL); err != nil {
+			return err
+		}
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return err
+	}
+	key, keyPEM, err := generateKey()
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: d.name},
+		DNSNames: []string{d.name},
+	}, key)
+	if err != nil {
+		return err
+	}
+	chain, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return err
+	}
+	certPEM := []byte{}
+	for _, der := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return m.storeCertificate(d, certPEM, keyPEM)
+}
+
+func (m *Manager) authorize(ctx context.Context, client *xacme.Client, authzURL string) error {
+	authz, err := client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return err
+	}
+	if authz.Status == xacme.StatusValid {
+		return nil
+	}
+	var challenge *xacme.Challenge
+	for _, c := range authz.Challenges {
+		if c.Type == "http-01" {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return fmt.Errorf("no http-01 challenge offered for %s", authz.Identifier.Value)
+	}
+	keyAuth, err := client.HTTP01ChallengeResponse(challenge.Token)
+	if err != nil {
+		return err
+	}
+	if err := m.store.putChallenge(challenge.Token, keyAuth); err != nil {
+		return err
+	}
+	defer func() {
+		if err := m.store.deleteChallenge(challenge.Token); err != nil {
+			log.Printf("WARN: Failed to clean up ACME challenge \"%s\": %v", challenge.Token, err)
+		}
+	}()
+	if _, err := client.Accept(ctx, challenge); err != nil {
+		return err
+	}
+	_, err = client.WaitAuthorization(ctx, authz.URI)
+	return err
+}
+
+func (m *Manager) storeCertificate(d domain, certPEM []byte, keyPEM []byte) error {
+	secrets := m.kubeClient.Secrets(d.namespace)
+	name := model.ACMESecretName(d.name)
+	secret, err := secrets.Get(name)
+	if errors.IsNotFound(err) {
+		secret = &v1.Secret{Data: map[string][]byte{}}
+		secret.Name = name
+		secret.Namespace = d.namespace
+		secret.Data["tls.crt"] = certPEM
+		secret.Data["tls.key"] = keyPEM
+		_, err = secrets.Create(secret)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data["tls.crt"] = certPEM
+	secret.Data["tls.key"] = keyPEM
+	_, err = secrets.Update(secret)
+	return err
+}
+
+// generateKey returns a new ECDSA private key along with its PEM encoding.
+func generateKey() (*ecdsa.PrivateKey, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,198 @@
+package acme
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deis/router/model"
+)
+
+func TestChallengeHandler(t *testing.T) {
+	store := newMemoryStateStore()
+	store.putChallenge("abc", "abc.xyz")
+	server := httptest.NewServer(challengeHandler(store))
+	defer server.Close()
+
+	for path, expectedStatus := range map[string]int{
+		"/.well-known/acme-challenge/abc":     http.StatusOK,
+		"/.well-known/acme-challenge/def":     http.StatusNotFound,
+		"/.well-known/acme-challenge/":        http.StatusNotFound,
+		"/.well-known/acme-challenge/abc/def": http.StatusNotFound,
+		"/abc":                                http.StatusNotFound,
+	} {
+		res, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != expectedStatus {
+			t.Errorf("Expected status %d for %s, but got %d.", expectedStatus, path, res.StatusCode)
+		}
+		if expectedStatus == http.StatusOK && string(body) != "abc.xyz" {
+			t.Errorf("Expected key authorization \"abc.xyz\" for %s, but got \"%s\".", path, body)
+		}
+	}
+}
+
+func TestDomainsNeedingCerts(t *testing.T) {
+	now := time.Date(2016, time.November, 1, 12, 0, 0, 0, time.UTC)
+	renewBy := now.Add(30 * 24 * time.Hour)
+	routerConfig := &model.RouterConfig{
+		AppConfigs: []*model.AppConfig{
+			&model.AppConfig{
+				Namespace:   "foo",
+				ACMEDomains: []string{"missing.example.com", "fresh.example.com"},
+				Certificates: map[string]*model.Certificate{
+					"fresh.example.com": &model.Certificate{Cert: generateCert(t, now.Add(60*24*time.Hour))},
+				},
+			},
+			&model.AppConfig{
+				Namespace:   "bar",
+				ACMEDomains: []string{"expiring.example.com"},
+				Certificates: map[string]*model.Certificate{
+					"expiring.example.com": &model.Certificate{Cert: generateCert(t, now.Add(10*24*time.Hour))},
+					"manual.example.com":   &model.Certificate{Cert: generateCert(t, now)},
+				},
+			},
+		},
+	}
+
+	expected := []domain{
+		{name: "expiring.example.com", namespace: "bar"},
+		{name: "missing.example.com", namespace: "foo"},
+	}
+	if actual := domainsNeedingCerts(routerConfig, renewBy); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected domains %+v, but got %+v.", expected, actual)
+	}
+}
+
+func TestUpdateDoesNotBlock(t *testing.T) {
+	manager := &Manager{configs: make(chan *model.RouterConfig, 1)}
+	first := &model.RouterConfig{}
+	second := &model.RouterConfig{}
+	manager.Update(first)
+	manager.Update(second)
+	if routerConfig := <-manager.configs; routerConfig != second {
+		t.Errorf("Expected only the latest router configuration to be retained.")
+	}
+}
+
+func TestMemoryStateStoreLease(t *testing.T) {
+	store := newMemoryStateStore()
+	if acquired, _ := store.acquireLease("a", time.Minute); !acquired {
+		t.Errorf("Expected an unheld lease to be acquired.")
+	}
+	if acquired, _ := store.acquireLease("b", time.Minute); acquired {
+		t.Errorf("Expected a lease held by another holder not to be acquired.")
+	}
+	store.releaseLease("a")
+	if acquired, _ := store.acquireLease("b", time.Minute); !acquired {
+		t.Errorf("Expected a released lease to be acquired.")
+	}
+}
+
+func TestHoldLease(t *testing.T) {
+	store := newMemoryStateStore()
+	manager := &Manager{identity: "a", store: store}
+	if !manager.holdLease() {
+		t.Fatal("Expected an unheld lease to be held.")
+	}
+	// The holder renews its lease.
+	store.expires = time.Now().Add(time.Second)
+	if !manager.holdLease() || store.expires.Sub(time.Now()) < leaseDuration-time.Minute {
+		t.Errorf("Expected the lease to be renewed for %s, but it expires at %s.", leaseDuration, store.expires)
+	}
+	other := &Manager{identity: "b", store: store}
+	if other.holdLease() {
+		t.Errorf("Expected a lease held by another replica not to be held.")
+	}
+}
+
+func generateCert(t *testing.T, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// memoryStateStore is a stateStore that is not shared with anything else.
+type memoryStateStore struct {
+	mutex      sync.Mutex
+	challenges map[string]string
+	holder     string
+	expires    time.Time
+}
+
+func newMemoryStateStore() *memoryStateStore {
+	return &memoryStateStore{challenges: map[string]string{}}
+}
+
+func (s *memoryStateStore) getChallenge(token string) (string, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	keyAuth, ok := s.challenges[token]
+	return keyAuth, ok, nil
+}
+
+func (s *memoryStateStore) putChallenge(token string, keyAuth string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.challenges[token] = keyAuth
+	return nil
+}
+
+func (s *memoryStateStore) deleteChallenge(token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.challenges, token)
+	return nil
+}
+
+func (s *memoryStateStore) acquireLease(holder string, duration time.Duration) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	if s.holder != holder && now.Before(s.expires) {
+		return false, nil
+	}
+	s.holder = holder
+	s.expires = now.Add(duration)
+	return true, nil
+}
+
+func (s *memoryStateStore) releaseLease(holder string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.holder == holder {
+		s.holder = ""
+		s.expires = time.Time{}
+	}
+	return nil
+}
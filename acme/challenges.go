@@ -0,0 +1,168 @@
+package acme
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/1.4/kubernetes"
+	"k8s.io/client-go/1.4/pkg/api/errors"
+	"k8s.io/client-go/1.4/pkg/api/v1"
+)
+
+const (
+	challengePathPrefix = "/.well-known/acme-challenge/"
+	// stateConfigMapName is the name of the config map, in the router's own namespace, through which
+	// all router replicas share pending HTTP-01 challenge responses and coordinate which of them may
+	// request certificates at any given time.
+	stateConfigMapName = "deis-router-acme"
+	holderAnnotation   = "router.deis.io/acme.holder"
+	expiresAnnotation  = "router.deis.io/acme.expires"
+	maxUpdateAttempts  = 5
+)
+
+// stateStore is the shared state of all router replicas' ACME subsystems.
+type stateStore interface {
+	// getChallenge returns the key authorization with which to respond to the specified challenge
+	// token, or false if the token is unknown.
+	getChallenge(token string) (string, bool, error)
+	putChallenge(token string, keyAuth string) error
+	deleteChallenge(token string) error
+	// acquireLease attempts to claim the exclusive right to request certificates for the specified
+	// duration on behalf of the specified holder.  It returns false if another holder's lease has not
+	// yet expired.
+	acquireLease(holder string, duration time.Duration) (bool, error)
+	releaseLease(holder string) error
+}
+
+// configMapStateStore is a stateStore backed by a k8s config map.  Challenge responses are stored as
+// the config map's data, keyed by token.  The lease is stored in its annotations.  Optimistic
+// concurrency guards against replicas clobbering one another's changes.
+type configMapStateStore struct {
+	kubeClient *kubernetes.Clientset
+	namespace  string
+}
+
+func (s *configMapStateStore) get() (*v1.ConfigMap, error) {
+	configMap, err := s.kubeClient.ConfigMaps(s.namespace).Get(stateConfigMapName)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	return configMap, err
+}
+
+// update applies the provided mutation to the config map, creating the config map if necessary, and
+// retries if the config map was concurrently modified.  A mutation may return false to abandon the
+// update.
+func (s *configMapStateStore) update(mutate func(configMap *v1.ConfigMap) bool) (bool, error) {
+	configMaps := s.kubeClient.ConfigMaps(s.namespace)
+	for attempt := 1; ; attempt++ {
+		configMap, err := s.get()
+		if err != nil {
+			return false, err
+		}
+		create := configMap == nil
+		if create {
+			configMap = &v1.ConfigMap{}
+			configMap.Name = stateConfigMapName
+			configMap.Namespace = s.namespace
+		}
+		if configMap.Data == nil {
+			configMap.Data = map[string]string{}
+		}
+		if configMap.Annotations == nil {
+			configMap.Annotations = map[string]string{}
+		}
+		if !mutate(configMap) {
+			return false, nil
+		}
+		if create {
+			_, err = configMaps.Create(configMap)
+		} else {
+			_, err = configMaps.Update(configMap)
+		}
+		if err == nil {
+			return true, nil
+		}
+		if (!errors.IsConflict(err) && !errors.IsAlreadyExists(err)) || attempt == maxUpdateAttempts {
+			return false, err
+		}
+	}
+}
+
+func (s *configMapStateStore) getChallenge(token string) (string, bool, error) {
+	configMap, err := s.get()
+	if err != nil || configMap == nil {
+		return "", false, err
+	}
+	keyAuth, ok := configMap.Data[token]
+	return keyAuth, ok, nil
+}
+
+func (s *configMapStateStore) putChallenge(token string, keyAuth string) error {
+	_, err := s.update(func(configMap *v1.ConfigMap) bool {
+		configMap.Data[token] = keyAuth
+		return true
+	})
+	return err
+}
+
+func (s *configMapStateStore) deleteChallenge(token string) error {
+	_, err := s.update(func(configMap *v1.ConfigMap) bool {
+		delete(configMap.Data, token)
+		return true
+	})
+	return err
+}
+
+func (s *configMapStateStore) acquireLease(holder string, duration time.Duration) (bool, error) {
+	return s.update(func(configMap *v1.ConfigMap) bool {
+		now := time.Now()
+		if configMap.Annotations[holderAnnotation] != holder {
+			expires, err := time.Parse(time.RFC3339, configMap.Annotations[expiresAnnotation])
+			if err == nil && now.Before(expires) {
+				return false
+			}
+		}
+		configMap.Annotations[holderAnnotation] = holder
+		configMap.Annotations[expiresAnnotation] = now.Add(duration).UTC().Format(time.RFC3339)
+		return true
+	})
+}
+
+func (s *configMapStateStore) releaseLease(holder string) error {
+	_, err := s.update(func(configMap *v1.ConfigMap) bool {
+		if configMap.Annotations[holderAnnotation] != holder {
+			return false
+		}
+		delete(configMap.Annotations, holderAnnotation)
+		delete(configMap.Annotations, expiresAnnotation)
+		return true
+	})
+	return err
+}
+
+// challengeHandler returns an http.Handler that responds to HTTP-01 challenges with the key
+// authorizations found in the provided stateStore.
+func challengeHandler(store stateStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.URL.Path, challengePathPrefix)
+		if token == r.URL.Path || token == "" || strings.Contains(token, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		keyAuth, ok, err := store.getChallenge(token)
+		if err != nil {
+			log.Printf("WARN: Failed to look up ACME challenge \"%s\": %v", token, err)
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(keyAuth))
+	})
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/deis/router/model"
+	"github.com/deis/router/utils"
+)
+
+// drainPollInterval is how often connections are counted while waiting for them to drain.
+const drainPollInterval = time.Second
+
+// drainStatus is the outcome of waiting for connections to drain, as served by drainReport.
+type drainStatus struct {
+	Drained     bool           `json:"drained"`
+	Waited      string         `json:"waited"`
+	Threshold   int            `json:"threshold"`
+	Connections int            `json:"connections"`
+	Apps        map[string]int `json:"apps"`
+}
+
+// drainReport counts the connections open from nginx to each application in the configuration most
+// recently applied.  Requested, typically by a preStop hook, it waits until the total falls to a
+// threshold, or a timeout elapses, and then serves the counts as JSON, so that the pod is not
+// terminated while long-lived connections, such as WebSockets, are still being served.
+type drainReport struct {
+	mutex     sync.Mutex
+	addresses map[string]string
+	// defaultTimeout is how long requests wait unless they ask for less.
+	defaultTimeout time.Duration
+}
+
+// update records the addresses of the upstreams in the provided configuration.
+func (r *drainReport) update(routerConfig *model.RouterConfig) {
+	addresses := routerConfig.UpstreamAddresses()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.addresses = addresses
+}
+
+// count returns the number of connections open to each application, and their total.
+func (r *drainReport) count() (map[string]int, int, error) {
+	connections, err := utils.EstablishedConnections()
+	if err != nil {
+		return nil, 0, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	apps := map[string]int{}
+	total := 0
+	for address, count := range connections {
+		if app, ok := r.addresses[address]; ok {
+			apps[app] += count
+			total += count
+		}
+	}
+	return apps, total, nil
+}
+
+func (r *drainReport) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	threshold := 0
+	if value := req.URL.Query().Get("threshold"); value != "" {
+		var err error
+		if threshold, err = strconv.Atoi(value); err != nil || threshold < 0 {
+			http.Error(w, fmt.Sprintf("Invalid threshold %s.", value), http.StatusBadRequest)
+			return
+		}
+	}
+	timeout := r.defaultTimeout
+	if value := req.URL.Query().Get("timeout"); value != "" {
+		var err error
+		if timeout, err = time.ParseDuration(value); err != nil || timeout < 0 {
+			http.Error(w, fmt.Sprintf("Invalid timeout %s.", value), http.StatusBadRequest)
+			return
+		}
+		if timeout > r.defaultTimeout {
+			timeout = r.defaultTimeout
+		}
+	}
+	start := time.Now()
+	for {
+		apps, total, err := r.count()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to count connections: %v", err), http.StatusInternalServerError)
+			return
+		}
+		drained := total <= threshold
+		if drained || time.Since(start) >= timeout {
+			status := drainStatus{
+				Drained:     drained,
+				Waited:      (time.Since(start) / time.Millisecond * time.Millisecond).String(),
+				Threshold:   threshold,
+				Connections: total,
+				Apps:        apps,
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if !drained {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			json.NewEncoder(w).Encode(status)
+			return
+		}
+		select {
+		case <-time.After(drainPollInterval):
+		case <-closeNotify(w):
+			return
+		}
+	}
+}
+
+// closeNotify returns a channel that is closed if the client goes away, so that abandoned requests
+// stop counting connections.
+func closeNotify(w http.ResponseWriter) <-chan bool {
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return nil
+}
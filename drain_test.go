@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newDrainTestReport returns a drainReport that counts a connection it opens itself as foo's,
+// along with a function that closes it.
+func newDrainTestReport(t *testing.T, defaultTimeout time.Duration) (*drainReport, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		listener.Close()
+		t.Fatal(err)
+	}
+	report := &drainReport{
+		addresses:      map[string]string{listener.Addr().String(): "foo"},
+		defaultTimeout: defaultTimeout,
+	}
+	return report, func() {
+		conn.Close()
+		listener.Close()
+	}
+}
+
+func getDrainStatus(t *testing.T, url string) (int, drainStatus) {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var status drainStatus
+	if resp.StatusCode != http.StatusBadRequest {
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, status
+}
+
+func TestDrainReport(t *testing.T) {
+	report, closeConn := newDrainTestReport(t, time.Minute)
+	defer closeConn()
+	server := httptest.NewServer(report)
+	defer server.Close()
+
+	code, status := getDrainStatus(t, server.URL+"/drain?timeout=0s")
+	if code != http.StatusServiceUnavailable || status.Drained || status.Connections != 1 || status.Apps["foo"] != 1 {
+		t.Errorf("Expected a 503 reporting foo's connection, but got %d: %+v", code, status)
+	}
+	code, status = getDrainStatus(t, server.URL+"/drain?threshold=1")
+	if code != http.StatusOK || !status.Drained || status.Threshold != 1 {
+		t.Errorf("Expected a 200 reporting the connections drained to the threshold, but got %d: %+v", code, status)
+	}
+
+	for _, query := range []string{"threshold=-1", "threshold=few", "timeout=-1s", "timeout=soon"} {
+		if code, _ := getDrainStatus(t, server.URL+"/drain?"+query); code != http.StatusBadRequest {
+			t.Errorf("Expected a 400 for %s, but got %d", query, code)
+		}
+	}
+}
+
+func TestDrainReportTimeout(t *testing.T) {
+	report, closeConn := newDrainTestReport(t, 0)
+	defer closeConn()
+	server := httptest.NewServer(report)
+	defer server.Close()
+
+	// Requests wait no longer than the default timeout.
+	start := time.Now()
+	code, status := getDrainStatus(t, server.URL+"/drain?timeout=1h")
+	if code != http.StatusServiceUnavailable || status.Drained {
+		t.Errorf("Expected a 503 once the default timeout elapsed, but got %d: %+v", code, status)
+	}
+	if elapsed := time.Since(start); elapsed > drainPollInterval {
+		t.Errorf("Expected the timeout to be capped, but the response took %s", elapsed)
+	}
+}
+
+func TestDrainReportDisconnect(t *testing.T) {
+	report, closeConn := newDrainTestReport(t, time.Minute)
+	defer closeConn()
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report.ServeHTTP(w, r)
+		close(done)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Timeout: 100 * time.Millisecond}
+	if resp, err := client.Get(server.URL + "/drain"); err == nil {
+		resp.Body.Close()
+		t.Fatalf("Expected the request to time out, but got %d", resp.StatusCode)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the handler to stop waiting once its client went away, but it did not.")
+	}
+}
@@ -0,0 +1,80 @@
+// Package faults supports the injection of faults into applications' requests, and the backoff
+// between retries of requests that could not be proxied.  nginx cannot itself delay a request, so
+// it asks the router to do so: each request to be delayed is first authorized by a subrequest, or
+// proxied to the router, which responds only once the delay has elapsed.
+package faults
+
+import (
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/deis/router/utils/modeler"
+)
+
+const (
+	// DelayAddr is the address at which delays are served.  nginx sends the subrequests that delay
+	// requests to it.
+	DelayAddr = "127.0.0.1:9093"
+	// maxDelay bounds how long any request may be delayed, whatever is asked.
+	maxDelay = 30 * time.Second
+	// retryLocationPrefix begins the name of every named location to which nginx may be redirected
+	// once a delay has elapsed.
+	retryLocationPrefix = "@deis_retry_"
+)
+
+// ServeDelays starts an HTTP server in the background that serves delays.
+func ServeDelays() {
+	go func() {
+		if err := http.ListenAndServe(DelayAddr, DelayHandler()); err != nil {
+			log.Printf("WARN: Fault injection server stopped: %v", err)
+		}
+	}()
+}
+
+// DelayHandler returns an http.Handler that responds with a 204 once the nginx time specified by
+// the "duration" query parameter has elapsed.  Durations longer than maxDelay are shortened to it.
+// If the "jitter" query parameter is "true", a random delay between half the duration and all of it
+// elapses instead, so that requests retried together are spread out.  If the "redirect" query
+// parameter names a retry's named location, nginx is redirected to it by way of X-Accel-Redirect.
+func DelayHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		delay, err := modeler.ParseDuration(query.Get("duration"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		redirect := query.Get("redirect")
+		if redirect != "" && !strings.HasPrefix(redirect, retryLocationPrefix) {
+			http.Error(w, "redirect must name a retry location", http.StatusBadRequest)
+			return
+		}
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+		if query.Get("jitter") == "true" && delay > 1 {
+			delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)))
+		}
+		select {
+		case <-time.After(delay):
+		case <-closeNotify(w):
+			return
+		}
+		if redirect != "" {
+			w.Header().Set("X-Accel-Redirect", redirect)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// closeNotify returns a channel that is closed if the client goes away, so that delays of
+// abandoned requests don't hold onto their connections.
+func closeNotify(w http.ResponseWriter) <-chan bool {
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return nil
+}
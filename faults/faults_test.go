@@ -0,0 +1,70 @@
+package faults
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDelayHandler(t *testing.T) {
+	server := httptest.NewServer(DelayHandler())
+	defer server.Close()
+
+	start := time.Now()
+	resp, err := http.Get(server.URL + "/?duration=100ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected a 204, but got %d", resp.StatusCode)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected the response to be delayed by at least 100ms, but it took %s", elapsed)
+	}
+
+	for _, duration := range []string{"", "forever", "-1s"} {
+		resp, err := http.Get(server.URL + "/?duration=" + duration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected a 400 for duration \"%s\", but got %d", duration, resp.StatusCode)
+		}
+	}
+}
+
+func TestDelayHandlerRetry(t *testing.T) {
+	server := httptest.NewServer(DelayHandler())
+	defer server.Close()
+
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		resp, err := http.Get(server.URL + "/?duration=100ms&jitter=true&redirect=%40deis_retry_0123abcd_1_proxy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		elapsed := time.Since(start)
+		if resp.StatusCode != http.StatusNoContent {
+			t.Errorf("Expected a 204, but got %d", resp.StatusCode)
+		}
+		if redirect := resp.Header.Get("X-Accel-Redirect"); redirect != "@deis_retry_0123abcd_1_proxy" {
+			t.Errorf("Expected a redirect to the retry's location, but got \"%s\"", redirect)
+		}
+		if elapsed < 50*time.Millisecond || elapsed > time.Second {
+			t.Errorf("Expected a jittered delay of between 50ms and 100ms, but it took %s", elapsed)
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/?duration=1ms&redirect=%2Fadmin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected a 400 for a redirect to anything but a retry's location, but got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,212 @@
+// Package grpcweb translates gRPC-Web requests, which browsers make over HTTP/1.1 without access to
+// trailers, into gRPC.  nginx cannot translate them itself, so it proxies each to the router, which
+// passes it on as a gRPC request to a gateway that nginx serves on the loopback interface, and which
+// proxies it, with nginx's grpc module, to the application.  The gRPC response, whose trailers the
+// gateway passes back in a chunked HTTP/1.1 response, is translated back into gRPC-Web, with the
+// trailers encoded at the end of its body.
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const (
+	// Addr is the address at which gRPC-Web requests are translated.  nginx proxies them to it.
+	Addr = "127.0.0.1:9096"
+	// GatewayAddr is the address at which nginx proxies translated requests to applications.
+	GatewayAddr = "127.0.0.1:9097"
+	// UpstreamHeader names the header in which nginx names the upstream, with its grpc or grpcs
+	// scheme, to which the gateway proxies a translated request.  It is passed on untouched.
+	UpstreamHeader = "X-Deis-Grpc-Web-Upstream"
+	// trailerFlag marks the frame of a gRPC-Web response's body in which its trailers are encoded.
+	trailerFlag = 0x80
+)
+
+// hopHeaders are the headers that describe a single connection, and are never passed on.
+var hopHeaders = []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade", "Content-Length"}
+
+// Serve starts an HTTP server in the background that translates gRPC-Web requests.
+func Serve() {
+	go func() {
+		if err := http.ListenAndServe(Addr, Handler("http://"+GatewayAddr)); err != nil {
+			log.Printf("WARN: gRPC-Web translation server stopped: %v", err)
+		}
+	}()
+}
+
+// Handler returns an http.Handler that translates each gRPC-Web request into a gRPC request to the
+// gateway at the specified URL, and the gateway's response back into gRPC-Web.  Requests in the
+// grpc-web-text format, whose messages are base64 encoded, are answered in the same format.
+func Handler(gatewayURL string) http.Handler {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType := r.Header.Get("Content-Type")
+		if !strings.HasPrefix(contentType, "application/grpc-web") {
+			http.Error(w, "Content-Type must be a gRPC-Web type", http.StatusUnsupportedMediaType)
+			return
+		}
+		text := strings.HasPrefix(contentType, "application/grpc-web-text")
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if text {
+			if body, err = decodeText(body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		req, err := http.NewRequest(r.Method, gatewayURL+r.URL.RequestURI(), bytes.NewReader(body))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		copyHeaders(req.Header, r.Header)
+		req.Header.Set("Content-Type", grpcContentType(contentType))
+		req.Header.Set("Te", "trailers")
+		req.Host = r.Host
+		resp, err := client.Do(req.WithContext(r.Context()))
+		if err != nil {
+			log.Printf("WARN: Failed to proxy translated gRPC-Web request for %s: %v", r.URL.Path, err)
+			// Unavailable, which clients may retry.
+			w.Header().Set("Grpc-Status", "14")
+			w.Header().Set("Grpc-Message", "upstream unavailable")
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		copyHeaders(w.Header(), resp.Header)
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/grpc") {
+			w.Header().Set("Content-Type", grpcWebContentType(resp.Header.Get("Content-Type"), text))
+		}
+		// Responses are streamed, so nginx must pass them on as they arrive.
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(resp.StatusCode)
+		out := io.Writer(w)
+		var encoder io.WriteCloser
+		if text {
+			encoder = base64.NewEncoder(base64.StdEncoding, w)
+			out = encoder
+		}
+		if err := copyFlushing(out, resp.Body, w); err != nil {
+			log.Printf("WARN: Failed to pass on the response to a gRPC-Web request for %s: %v", r.URL.Path, err)
+			return
+		}
+		if len(resp.Trailer) > 0 {
+			out.Write(trailerFrame(resp.Trailer))
+		}
+		if encoder != nil {
+			encoder.Close()
+		}
+	})
+}
+
+// decodeText decodes the body of a grpc-web-text request.  Clients may encode each message
+// separately, so the body may consist of several padded base64 strings one after another.
+func decodeText(body []byte) ([]byte, error) {
+	decoded := []byte{}
+	for len(body) > 0 {
+		end := bytes.IndexByte(body, '=')
+		if end < 0 {
+			end = len(body)
+		} else {
+			for end < len(body) && body[end] == '=' {
+				end++
+			}
+		}
+		chunk, err := base64.StdEncoding.DecodeString(string(body[:end]))
+		if err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, chunk...)
+		body = body[end:]
+	}
+	return decoded, nil
+}
+
+// grpcContentType returns the gRPC content type corresponding to the provided gRPC-Web content
+// type, e.g. "application/grpc+proto" for "application/grpc-web-text+proto".
+func grpcContentType(contentType string) string {
+	contentType = strings.TrimPrefix(contentType, "application/grpc-web-text")
+	contentType = strings.TrimPrefix(contentType, "application/grpc-web")
+	return "application/grpc" + contentType
+}
+
+// grpcWebContentType returns the gRPC-Web content type, in the text format if text is true,
+// corresponding to the provided gRPC content type.
+func grpcWebContentType(contentType string, text bool) string {
+	if text {
+		return "application/grpc-web-text" + strings.TrimPrefix(contentType, "application/grpc")
+	}
+	return "application/grpc-web" + strings.TrimPrefix(contentType, "application/grpc")
+}
+
+// copyHeaders adds each of the headers in src, other than those describing a single connection, to
+// dst.
+func copyHeaders(dst http.Header, src http.Header) {
+	for name, values := range src {
+		dst[name] = append(dst[name], values...)
+	}
+	for _, name := range hopHeaders {
+		dst.Del(name)
+	}
+}
+
+// copyFlushing copies src to dst, flushing the response after each read, so that the messages of a
+// streamed response reach the client as they arrive.
+func copyFlushing(dst io.Writer, src io.Reader, w http.ResponseWriter) error {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// trailerFrame encodes the provided trailers as the final frame of a gRPC-Web response's body: a
+// flag byte marking it as trailers, its length as a big-endian 32-bit integer, and the trailers
+// themselves, formatted as HTTP/1.1 headers with lowercase names.
+func trailerFrame(trailer http.Header) []byte {
+	names := make([]string, 0, len(trailer))
+	for name := range trailer {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var trailers bytes.Buffer
+	for _, name := range names {
+		for _, value := range trailer[name] {
+			fmt.Fprintf(&trailers, "%s: %s\r\n", strings.ToLower(name), value)
+		}
+	}
+	frame := make([]byte, 5, 5+trailers.Len())
+	frame[0] = trailerFlag
+	binary.BigEndian.PutUint32(frame[1:], uint32(trailers.Len()))
+	return append(frame, trailers.Bytes()...)
+}
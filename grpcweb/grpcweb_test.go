@@ -0,0 +1,106 @@
+package grpcweb
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// message frames the provided payload as a single gRPC message.
+func message(payload string) []byte {
+	return append([]byte{0, 0, 0, 0, byte(len(payload))}, payload...)
+}
+
+// newTestGateway returns a server that stands in for nginx's gateway, answering each request with a
+// message echoing its path and payload, followed by trailers.
+func newTestGateway(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if contentType := r.Header.Get("Content-Type"); contentType != "application/grpc+proto" {
+			t.Errorf("Expected the translated request to be application/grpc+proto, but got %s", contentType)
+		}
+		if upstream := r.Header.Get(UpstreamHeader); upstream != "grpc://foo" {
+			t.Errorf("Expected the upstream header to be passed on, but got %q", upstream)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.Write(message(r.URL.Path))
+		w.Write(body)
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "OK")
+	}))
+}
+
+func TestHandler(t *testing.T) {
+	gateway := newTestGateway(t)
+	defer gateway.Close()
+	server := httptest.NewServer(Handler(gateway.URL))
+	defer server.Close()
+
+	expectedTrailers := "grpc-message: OK\r\ngrpc-status: 0\r\n"
+	expectedBody := append(append(message("/foo.Bar/Baz"), message("hello")...), append([]byte{trailerFlag, 0, 0, 0, byte(len(expectedTrailers))}, expectedTrailers...)...)
+
+	req, _ := http.NewRequest("POST", server.URL+"/foo.Bar/Baz", bytes.NewReader(message("hello")))
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	req.Header.Set(UpstreamHeader, "grpc://foo")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/grpc-web+proto" {
+		t.Errorf("Expected the response to be application/grpc-web+proto, but got %s", contentType)
+	}
+	if !bytes.Equal(body, expectedBody) {
+		t.Errorf("Expected the response's body to be %q, but got %q", expectedBody, body)
+	}
+
+	// Text requests may encode each message separately, and are answered in text.
+	text := base64.StdEncoding.EncodeToString([]byte("he")) + base64.StdEncoding.EncodeToString([]byte("llo"))
+	req, _ = http.NewRequest("POST", server.URL+"/foo.Bar/Baz", strings.NewReader(base64.StdEncoding.EncodeToString(message("")[:4])+base64.StdEncoding.EncodeToString([]byte{5})+text))
+	req.Header.Set("Content-Type", "application/grpc-web-text+proto")
+	req.Header.Set(UpstreamHeader, "grpc://foo")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/grpc-web-text+proto" {
+		t.Errorf("Expected the response to be application/grpc-web-text+proto, but got %s", contentType)
+	}
+	if decoded, err := decodeText(body); err != nil || !bytes.Equal(decoded, expectedBody) {
+		t.Errorf("Expected the response's body to decode to %q, but got %q (%v)", expectedBody, decoded, err)
+	}
+
+	// Requests that are not gRPC-Web are refused.
+	resp, err = http.Post(server.URL+"/foo.Bar/Baz", "application/grpc", bytes.NewReader(message("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected a 415 for a request that is not gRPC-Web, but got %d", resp.StatusCode)
+	}
+}
+
+func TestHandlerGatewayDown(t *testing.T) {
+	gateway := newTestGateway(t)
+	gateway.Close()
+	server := httptest.NewServer(Handler(gateway.URL))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/foo.Bar/Baz", "application/grpc-web", bytes.NewReader(message("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || resp.Header.Get("Grpc-Status") != "14" {
+		t.Errorf("Expected a 502 with gRPC status 14, but got %d with gRPC status %q", resp.StatusCode, resp.Header.Get("Grpc-Status"))
+	}
+}
@@ -0,0 +1,230 @@
+// Package healthcheck actively probes the endpoints of applications that ask for it, so that an
+// endpoint that stops answering is taken out of rotation before Kubernetes removes it from the
+// application's endpoints.  nginx itself only detects failing endpoints passively, from the
+// requests it proxies to them.
+package healthcheck
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/deis/router/model"
+	"github.com/deis/router/utils/modeler"
+)
+
+// target is an endpoint to be probed, and how.
+type target struct {
+	endpoint           string
+	scheme             string
+	path               string
+	interval           time.Duration
+	timeout            time.Duration
+	unhealthyThreshold int
+	healthyThreshold   int
+}
+
+// probe tracks the health of one target.  Endpoints are presumed healthy until found otherwise,
+// since Kubernetes only lists an endpoint once its pod is ready.
+type probe struct {
+	healthy bool
+	// streak counts the consecutive checks whose outcome differs from the endpoint's current health.
+	streak int
+	stop   chan struct{}
+}
+
+// Checker probes the endpoints of applications whose health checks have a path, and filters those
+// found unhealthy out of the router's configuration.
+type Checker struct {
+	mutex   sync.Mutex
+	probes  map[target]*probe
+	changes chan struct{}
+	// check requests the target's path of its endpoint, and reports whether the endpoint is healthy.
+	check func(target) bool
+}
+
+// NewChecker returns a new Checker that is not yet probing any endpoint.
+func NewChecker() *Checker {
+	return &Checker{
+		probes:  map[target]*probe{},
+		changes: make(chan struct{}, 1),
+		check:   get,
+	}
+}
+
+// Changes returns a channel that receives a value whenever an endpoint's health changes.  Changes
+// that occur while a value is already pending are coalesced with it.
+func (c *Checker) Changes() <-chan struct{} {
+	return c.changes
+}
+
+// Apply starts probing the endpoints of the provided configuration's applications that are not
+// already being probed, stops probing those that are no longer in it, and removes the endpoints
+// found unhealthy from it.  If none of a backend's endpoints are healthy, they are all kept, since
+// proxying requests to them is no worse than refusing them all.
+func (c *Checker) Apply(routerConfig *model.RouterConfig) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	wanted := map[target]bool{}
+	for _, appConfig := range routerConfig.AppConfigs {
+		appTarget, ok := newTarget(appConfig)
+		if !ok {
+			continue
+		}
+		healthy := func(endpoints []string) []string {
+			filtered := []string{}
+			for _, endpoint := range endpoints {
+				t := appTarget
+				t.endpoint = endpoint
+				wanted[t] = true
+				p, ok := c.probes[t]
+				if !ok {
+					p = &probe{healthy: true, stop: make(chan struct{})}
+					c.probes[t] = p
+					go c.run(t, p)
+				}
+				if p.healthy {
+					filtered = append(filtered, endpoint)
+				}
+			}
+			if len(filtered) == 0 {
+				return endpoints
+			}
+			return filtered
+		}
+		appConfig.Endpoints = healthy(appConfig.Endpoints)
+		if appConfig.Canary != nil {
+			appConfig.Canary.Endpoints = healthy(appConfig.Canary.Endpoints)
+		}
+		for _, location := range appConfig.Locations {
+			location.Endpoints = healthy(location.Endpoints)
+			if location.Canary != nil && location.Canary != appConfig.Canary {
+				location.Canary.Endpoints = healthy(location.Canary.Endpoints)
+			}
+			for _, weightedBackend := range location.WeightedBackends {
+				weightedBackend.Endpoints = healthy(weightedBackend.Endpoints)
+			}
+		}
+	}
+	for t, p := range c.probes {
+		if !wanted[t] {
+			close(p.stop)
+			delete(c.probes, t)
+		}
+	}
+}
+
+// Unhealthy returns the number of endpoints currently found unhealthy.
+func (c *Checker) Unhealthy() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	unhealthy := 0
+	for _, p := range c.probes {
+		if !p.healthy {
+			unhealthy++
+		}
+	}
+	return unhealthy
+}
+
+// newTarget returns a target, without an endpoint, describing how the provided application's
+// endpoints are probed, and whether they are actively probed at all.
+func newTarget(appConfig *model.AppConfig) (target, bool) {
+	healthCheckConfig := appConfig.HealthCheckConfig
+	if healthCheckConfig == nil || healthCheckConfig.Path == "" {
+		return target{}, false
+	}
+	interval, err := modeler.ParseDuration(healthCheckConfig.Interval)
+	if err != nil || interval <= 0 {
+		return target{}, false
+	}
+	timeout, err := modeler.ParseDuration(healthCheckConfig.Timeout)
+	if err != nil || timeout <= 0 {
+		return target{}, false
+	}
+	scheme := "http"
+	if appConfig.BackendProtocol == "https" {
+		scheme = "https"
+	}
+	return target{
+		scheme:             scheme,
+		path:               healthCheckConfig.Path,
+		interval:           interval,
+		timeout:            timeout,
+		unhealthyThreshold: healthCheckConfig.UnhealthyThreshold,
+		healthyThreshold:   healthCheckConfig.HealthyThreshold,
+	}, true
+}
+
+// run probes the provided target every interval until the probe is stopped, recording the
+// endpoint's health and signaling any change in it.
+func (c *Checker) run(t target, p *probe) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+		healthy := c.check(t)
+		c.mutex.Lock()
+		changed := c.record(t, p, healthy)
+		c.mutex.Unlock()
+		if changed {
+			select {
+			case c.changes <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// record records the outcome of one check of the provided target, and returns whether the
+// endpoint's health changed as a result.
+func (c *Checker) record(t target, p *probe, healthy bool) bool {
+	if healthy == p.healthy {
+		p.streak = 0
+		return false
+	}
+	p.streak++
+	threshold := t.unhealthyThreshold
+	if healthy {
+		threshold = t.healthyThreshold
+	}
+	if p.streak < threshold {
+		return false
+	}
+	p.healthy = healthy
+	p.streak = 0
+	if healthy {
+		log.Printf("INFO: Endpoint %s is healthy again; returning it to rotation.", t.endpoint)
+	} else {
+		log.Printf("INFO: Endpoint %s failed %d health checks in a row; taking it out of rotation.", t.endpoint, threshold)
+	}
+	return true
+}
+
+// get requests the target's path of its endpoint, which is healthy if it answers with a status below
+// 400 before the timeout.  Endpoints spoken to over TLS are not asked to prove their identity, since
+// an endpoint's certificate names its application rather than its address.
+func get(t target) bool {
+	client := &http.Client{
+		Timeout: t.timeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		// Redirects are answers in their own right, which need not be followed.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Get(t.scheme + "://" + t.endpoint + t.path)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 400
+}
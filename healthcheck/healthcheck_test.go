@@ -0,0 +1,155 @@
+package healthcheck
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deis/router/model"
+)
+
+func newTestAppConfig(endpoints ...string) *model.AppConfig {
+	return &model.AppConfig{
+		Name:      "foo",
+		Endpoints: endpoints,
+		HealthCheckConfig: &model.HealthCheckConfig{
+			Path:               "/healthz",
+			Interval:           "10ms",
+			Timeout:            "1s",
+			UnhealthyThreshold: 2,
+			HealthyThreshold:   1,
+		},
+	}
+}
+
+func TestApply(t *testing.T) {
+	var mutex sync.Mutex
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.URL.Path != "/healthz" || !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	good := strings.TrimPrefix(server.URL, "http://")
+	// Nothing listens on the discard port, so connections to it are refused.
+	bad := "127.0.0.1:9"
+
+	checker := NewChecker()
+	routerConfig := &model.RouterConfig{AppConfigs: []*model.AppConfig{newTestAppConfig(good, bad)}}
+	checker.Apply(routerConfig)
+	// Endpoints are healthy until they have been checked.
+	if expected := []string{good, bad}; !reflect.DeepEqual(expected, routerConfig.AppConfigs[0].Endpoints) {
+		t.Errorf("Expected endpoints %v, but got %v", expected, routerConfig.AppConfigs[0].Endpoints)
+	}
+
+	waitForChange(t, checker)
+	routerConfig = &model.RouterConfig{AppConfigs: []*model.AppConfig{newTestAppConfig(good, bad)}}
+	checker.Apply(routerConfig)
+	if expected := []string{good}; !reflect.DeepEqual(expected, routerConfig.AppConfigs[0].Endpoints) {
+		t.Errorf("Expected endpoints %v, but got %v", expected, routerConfig.AppConfigs[0].Endpoints)
+	}
+	if unhealthy := checker.Unhealthy(); unhealthy != 1 {
+		t.Errorf("Expected 1 unhealthy endpoint, but got %d", unhealthy)
+	}
+
+	// If every endpoint is unhealthy, all are kept.
+	mutex.Lock()
+	healthy = false
+	mutex.Unlock()
+	waitForChange(t, checker)
+	routerConfig = &model.RouterConfig{AppConfigs: []*model.AppConfig{newTestAppConfig(good, bad)}}
+	checker.Apply(routerConfig)
+	if expected := []string{good, bad}; !reflect.DeepEqual(expected, routerConfig.AppConfigs[0].Endpoints) {
+		t.Errorf("Expected endpoints %v, but got %v", expected, routerConfig.AppConfigs[0].Endpoints)
+	}
+
+	// Endpoints no longer in the configuration are no longer checked.
+	checker.Apply(&model.RouterConfig{})
+	if unhealthy := checker.Unhealthy(); unhealthy != 0 {
+		t.Errorf("Expected no unhealthy endpoints, but got %d", unhealthy)
+	}
+}
+
+func TestApplyWithoutPath(t *testing.T) {
+	checker := NewChecker()
+	checker.check = func(target) bool {
+		t.Error("Expected no endpoints to be checked")
+		return false
+	}
+	appConfig := newTestAppConfig("10.0.0.1:8000")
+	appConfig.HealthCheckConfig.Path = ""
+	checker.Apply(&model.RouterConfig{AppConfigs: []*model.AppConfig{appConfig}})
+	time.Sleep(50 * time.Millisecond)
+	if len(checker.probes) != 0 {
+		t.Errorf("Expected no probes, but got %d", len(checker.probes))
+	}
+}
+
+func TestGet(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+
+	for _, test := range []struct {
+		scheme   string
+		endpoint string
+		path     string
+		healthy  bool
+	}{
+		{"http", strings.TrimPrefix(server.URL, "http://"), "/healthz", true},
+		{"http", strings.TrimPrefix(server.URL, "http://"), "/missing", false},
+		// Endpoints spoken to over TLS are checked over TLS, whatever their certificates.
+		{"https", strings.TrimPrefix(tlsServer.URL, "https://"), "/healthz", true},
+		{"http", strings.TrimPrefix(tlsServer.URL, "https://"), "/healthz", false},
+	} {
+		if healthy := get(target{endpoint: test.endpoint, scheme: test.scheme, path: test.path, timeout: time.Second}); healthy != test.healthy {
+			t.Errorf("Expected %s://%s%s to be healthy %t, but got %t", test.scheme, test.endpoint, test.path, test.healthy, healthy)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	checker := NewChecker()
+	target := target{unhealthyThreshold: 3, healthyThreshold: 2}
+	p := &probe{healthy: true}
+	for i, outcome := range []struct {
+		healthy         bool
+		expectedChanged bool
+		expectedHealthy bool
+	}{
+		{false, false, true},
+		{false, false, true},
+		// A success resets the streak of failures.
+		{true, false, true},
+		{false, false, true},
+		{false, false, true},
+		{false, true, false},
+		{true, false, false},
+		{true, true, true},
+	} {
+		if changed := checker.record(target, p, outcome.healthy); changed != outcome.expectedChanged || p.healthy != outcome.expectedHealthy {
+			t.Errorf("Check %d: expected changed %t and healthy %t, but got %t and %t", i, outcome.expectedChanged, outcome.expectedHealthy, changed, p.healthy)
+		}
+	}
+}
+
+func waitForChange(t *testing.T, checker *Checker) {
+	select {
+	case <-checker.Changes():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an endpoint's health to change")
+	}
+}
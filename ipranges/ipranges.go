@@ -0,0 +1,171 @@
+// Package ipranges keeps the IP ranges that providers publish, such as those from which a CDN
+// connects to its origins, up to date, so that the applications that whitelist them, and the
+// trusted proxies whose reports of clients' addresses the router believes, follow the ranges as
+// they change.
+package ipranges
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/deis/router/metrics"
+	"github.com/deis/router/model"
+	"github.com/deis/router/utils/modeler"
+)
+
+const (
+	// fetchTimeout bounds how long fetching a source's ranges may take.
+	fetchTimeout = 30 * time.Second
+	// maxRangesSize bounds the size of a published list of ranges that is read.
+	maxRangesSize = 4 << 20
+)
+
+// target is a URL at which ranges are published, and how often they are fetched.
+type target struct {
+	url      string
+	interval time.Duration
+}
+
+// feed holds the ranges most recently fetched from one target.  A feed has no ranges until they
+// have been fetched successfully, and keeps those last fetched while fetching them fails.
+type feed struct {
+	name    string
+	ranges  []string
+	updated time.Time
+	stop    chan struct{}
+}
+
+// Syncer fetches the ranges of the IP range sources that are published at URLs, and fills them in
+// to the router's configuration.
+type Syncer struct {
+	mutex   sync.Mutex
+	feeds   map[target]*feed
+	changes chan struct{}
+	// fetch requests the URL and returns the ranges published there.
+	fetch func(url string) ([]string, error)
+}
+
+// NewSyncer returns a new Syncer that is not yet fetching any ranges.
+func NewSyncer() *Syncer {
+	return &Syncer{
+		feeds:   map[target]*feed{},
+		changes: make(chan struct{}, 1),
+		fetch:   get,
+	}
+}
+
+// Changes returns a channel that receives a value whenever the ranges of any source change.  Changes
+// that occur while a value is already pending are coalesced with it.
+func (s *Syncer) Changes() <-chan struct{} {
+	return s.changes
+}
+
+// Apply starts fetching the ranges of the provided configuration's sources that are published at
+// URLs and are not already being fetched, stops fetching those that are no longer in it, and fills
+// in the ranges most recently fetched for each.
+func (s *Syncer) Apply(routerConfig *model.RouterConfig) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	wanted := map[target]bool{}
+	for _, source := range routerConfig.IPRangeSources {
+		if source.URL == "" {
+			continue
+		}
+		interval, err := modeler.ParseDuration(source.Interval)
+		if err != nil || interval <= 0 {
+			continue
+		}
+		t := target{url: source.URL, interval: interval}
+		wanted[t] = true
+		f, ok := s.feeds[t]
+		if !ok {
+			f = &feed{stop: make(chan struct{})}
+			s.feeds[t] = f
+			go s.run(t, f)
+		}
+		f.name = source.Name
+		source.Ranges = f.ranges
+	}
+	metrics.IPRangeSourceUpdated.Reset()
+	for t, f := range s.feeds {
+		if !wanted[t] {
+			close(f.stop)
+			delete(s.feeds, t)
+			continue
+		}
+		if !f.updated.IsZero() {
+			metrics.IPRangeSourceUpdated.With(f.name).Set(float64(f.updated.Unix()))
+		}
+	}
+}
+
+// run fetches the target's ranges at once, and then every interval until the feed is stopped,
+// recording them and signaling any change in them.
+func (s *Syncer) run(t target, f *feed) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		ranges, err := s.fetch(t.url)
+		s.mutex.Lock()
+		changed := false
+		if err != nil {
+			log.Printf("WARN: Failed to fetch the IP ranges of source \"%s\" from %s; keeping the ranges last fetched: %v", f.name, t.url, err)
+		} else {
+			changed = s.record(f, ranges, time.Now())
+		}
+		s.mutex.Unlock()
+		if changed {
+			select {
+			case s.changes <- struct{}{}:
+			default:
+			}
+		}
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// record records ranges freshly fetched for the provided feed, and returns whether they differ
+// from those previously recorded.
+func (s *Syncer) record(f *feed, ranges []string, now time.Time) bool {
+	f.updated = now
+	metrics.IPRangeSourceUpdated.With(f.name).Set(float64(now.Unix()))
+	if reflect.DeepEqual(ranges, f.ranges) {
+		return false
+	}
+	log.Printf("INFO: The IP ranges of source \"%s\" have changed; %d ranges are now known.", f.name, len(ranges))
+	f.ranges = ranges
+	return true
+}
+
+// get requests the provided URL and returns the ranges published there.  A response that lists no
+// ranges at all is taken to be an error, rather than a reason to whitelist no one.
+func get(url string) ([]string, error) {
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRangesSize))
+	if err != nil {
+		return nil, err
+	}
+	ranges := model.ParseIPRanges(data)
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no IP ranges found")
+	}
+	return ranges, nil
+}
@@ -0,0 +1,98 @@
+package ipranges
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/deis/router/model"
+)
+
+func newTestRouterConfig() *model.RouterConfig {
+	return &model.RouterConfig{
+		IPRangeSources: []*model.IPRangeSource{
+			&model.IPRangeSource{Name: "cdn", URL: "https://cdn.example.com/ips", Interval: "1h"},
+			&model.IPRangeSource{Name: "office", ConfigMap: "office-ranges", Ranges: []string{"198.51.100.0/24"}},
+		},
+	}
+}
+
+func TestApply(t *testing.T) {
+	syncer := NewSyncer()
+	syncer.fetch = func(url string) ([]string, error) {
+		return []string{"192.0.2.0/24"}, nil
+	}
+	routerConfig := newTestRouterConfig()
+	syncer.Apply(routerConfig)
+	// Until the ranges have been fetched, the source has none.
+	if ranges := routerConfig.IPRangeSources[0].Ranges; ranges != nil {
+		t.Errorf("Expected no ranges before they were fetched, but got %v", ranges)
+	}
+	select {
+	case <-syncer.Changes():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a change once the ranges were fetched.")
+	}
+
+	routerConfig = newTestRouterConfig()
+	syncer.Apply(routerConfig)
+	if expected := []string{"192.0.2.0/24"}; !reflect.DeepEqual(routerConfig.IPRangeSources[0].Ranges, expected) {
+		t.Errorf("Expected ranges %v, but got %v", expected, routerConfig.IPRangeSources[0].Ranges)
+	}
+	// Sources held in config maps are left as the model read them.
+	if expected := []string{"198.51.100.0/24"}; !reflect.DeepEqual(routerConfig.IPRangeSources[1].Ranges, expected) {
+		t.Errorf("Expected ranges %v, but got %v", expected, routerConfig.IPRangeSources[1].Ranges)
+	}
+
+	// Sources that are no longer configured are no longer fetched.
+	syncer.Apply(&model.RouterConfig{})
+	if len(syncer.feeds) != 0 {
+		t.Errorf("Expected no feeds, but got %v", syncer.feeds)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	syncer := NewSyncer()
+	f := &feed{name: "cdn"}
+	if !syncer.record(f, []string{"192.0.2.0/24"}, time.Now()) {
+		t.Error("Expected the first ranges fetched to be a change.")
+	}
+	if syncer.record(f, []string{"192.0.2.0/24"}, time.Now()) {
+		t.Error("Expected the same ranges fetched again not to be a change.")
+	}
+	if !syncer.record(f, []string{"192.0.2.0/24", "2001:db8::/32"}, time.Now()) {
+		t.Error("Expected new ranges to be a change.")
+	}
+}
+
+func TestGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/ips":
+			fmt.Fprintln(w, "192.0.2.0/24")
+			fmt.Fprintln(w, "2001:db8::/32")
+		case "/empty":
+			fmt.Fprintln(w, "<html>Moved</html>")
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	ranges, err := get(server.URL + "/ips")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"192.0.2.0/24", "2001:db8::/32"}; !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("Expected ranges %v, but got %v", expected, ranges)
+	}
+	if _, err := get(server.URL + "/empty"); err == nil {
+		t.Error("Expected a response listing no ranges to be an error.")
+	}
+	if _, err := get(server.URL + "/missing"); err == nil {
+		t.Error("Expected a 404 to be an error.")
+	}
+}
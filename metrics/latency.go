@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// LatencyAddr is the UDP address at which the router receives the timing of each request, which
+	// nginx logs to it in the syslog protocol.
+	LatencyAddr = "127.0.0.1:9094"
+	// LatencyTag tags the syslog messages in which nginx logs the timing of each request.
+	LatencyTag = "deis_latency"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the buckets into which requests are counted by
+// how long they took.  They are finer than DefaultBuckets at the low end, where most requests fall.
+var LatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+var (
+	// RequestDuration tracks how long nginx took to handle each application's requests, labeled by
+	// application.
+	RequestDuration = NewHistogramVec("app", LatencyBuckets)
+	// UpstreamResponseDuration tracks how long each application's endpoints took to respond to the
+	// requests proxied to them, labeled by application.
+	UpstreamResponseDuration = NewHistogramVec("app", LatencyBuckets)
+)
+
+// ServeLatencies starts listening in the background for the timing of requests logged by nginx, and
+// observes each in the latency histograms.
+func ServeLatencies() {
+	serveSyslog(LatencyAddr, "request latencies", observeLatency)
+}
+
+// serveSyslog starts listening in the background, at the specified UDP address, for messages that
+// nginx logs in the syslog protocol, and passes each to the provided function.
+func serveSyslog(addr string, description string, handle func(message []byte)) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		log.Printf("WARN: Failed to listen for %s: %v", description, err)
+		return
+	}
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				log.Printf("WARN: Stopped receiving %s: %v", description, err)
+				return
+			}
+			handle(buf[:n])
+		}
+	}()
+}
+
+// observeLatency parses a syslog message logged in the "deis_latency" format, which is
+// "<app>|<request time>|<upstream response times>", and observes the request's timing.  Messages
+// that cannot be parsed are ignored.
+func observeLatency(message []byte) {
+	marker := []byte(LatencyTag + ": ")
+	i := bytes.Index(message, marker)
+	if i < 0 {
+		return
+	}
+	fields := strings.Split(strings.TrimSpace(string(message[i+len(marker):])), "|")
+	if len(fields) != 3 || fields[0] == "" {
+		return
+	}
+	requestTime, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return
+	}
+	RequestDuration.With(fields[0]).Observe(requestTime)
+	if upstreamResponseTime, ok := sumUpstreamTimes(fields[2]); ok {
+		UpstreamResponseDuration.With(fields[0]).Observe(upstreamResponseTime)
+	}
+}
+
+// sumUpstreamTimes returns the total of the times nginx logs for each upstream server it tried, and
+// whether there were any.  nginx separates the times of servers in one upstream with commas, and
+// those of upstreams reached through internal redirects with colons, and logs "-" for servers that
+// never responded.
+func sumUpstreamTimes(times string) (float64, bool) {
+	sum := 0.0
+	found := false
+	for _, field := range strings.FieldsFunc(times, func(r rune) bool { return r == ',' || r == ':' }) {
+		t, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			continue
+		}
+		sum += t
+		found = true
+	}
+	return sum, found
+}
@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestObserveLatency(t *testing.T) {
+	for _, message := range []string{
+		"<190>Oct 16 12:00:00 deis_latency: foo|0.020|0.015",
+		// Retried requests log the time of every endpoint tried, and of every upstream redirected to.
+		"<190>Oct 16 12:00:00 deis_latency: foo|3.500|-, 1.000 : 2.000",
+		// Requests that were never proxied log no upstream time.
+		"<190>Oct 16 12:00:00 deis_latency: bar|0.001|-",
+		// Unparseable messages are ignored.
+		"<190>Oct 16 12:00:00 deis_latency: foo|-|0.015",
+		"<190>Oct 16 12:00:00 other: foo|0.020|0.015",
+	} {
+		observeLatency([]byte(message))
+	}
+
+	var buffer bytes.Buffer
+	writeMetric(&buffer, "app_request_duration_seconds", "Test.", "histogram", RequestDuration.samples()...)
+	writeMetric(&buffer, "app_upstream_response_duration_seconds", "Test.", "histogram", UpstreamResponseDuration.samples()...)
+	output := buffer.String()
+	for _, expected := range []string{
+		"deis_router_app_request_duration_seconds_bucket{app=\"foo\",le=\"0.025\"} 1\n",
+		"deis_router_app_request_duration_seconds_bucket{app=\"foo\",le=\"5\"} 2\n",
+		"deis_router_app_request_duration_seconds_count{app=\"foo\"} 2\n",
+		"deis_router_app_request_duration_seconds_count{app=\"bar\"} 1\n",
+		"deis_router_app_upstream_response_duration_seconds_bucket{app=\"foo\",le=\"0.025\"} 1\n",
+		"deis_router_app_upstream_response_duration_seconds_bucket{app=\"foo\",le=\"2.5\"} 1\n",
+		"deis_router_app_upstream_response_duration_seconds_sum{app=\"foo\"} 3.015\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected histograms to contain %q, but they did not:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, "deis_router_app_upstream_response_duration_seconds_count{app=\"bar\"}") {
+		t.Errorf("Expected no upstream response time to be observed for requests that were never proxied:\n%s", output)
+	}
+}
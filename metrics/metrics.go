@@ -0,0 +1,533 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	namespace = "deis_router"
+	// trafficStatusURL is the location at which nginx's vhost traffic status module reports its
+	// statistics in JSON format.  It is only accessible from localhost.
+	trafficStatusURL = "http://127.0.0.1:9090/stats"
+)
+
+var (
+	// ModelBuildDuration tracks how long it takes to build the router's model from k8s resources.
+	ModelBuildDuration = &Summary{}
+	// ModelBuildFailures counts attempts to build the router's model that failed.
+	ModelBuildFailures = &Counter{}
+	// ValidationFailures counts generated configurations that nginx rejected.
+	ValidationFailures = &Counter{}
+	// Reloads counts attempts to reload nginx with new configuration.
+	Reloads = &Counter{}
+	// ReloadFailures counts attempts to reload nginx with new configuration that failed.
+	ReloadFailures = &Counter{}
+	// ReloadsSkipped counts changes in Kubernetes that yielded exactly the configuration and
+	// certificates already in effect, so that nginx was not reloaded.
+	ReloadsSkipped = &Counter{}
+	// StagedPromotions counts new configurations applied after faring well on a staged instance of
+	// nginx, and StagedRollbacks those withheld after faring worse than the configuration in effect.
+	StagedPromotions = &Counter{}
+	StagedRollbacks  = &Counter{}
+	// NginxRestarts counts restarts of nginx after it exited unexpectedly.
+	NginxRestarts = &Counter{}
+	// StageDuration tracks how long each stage of the router's control loop takes, labeled by stage.
+	StageDuration = NewHistogramVec("stage", DefaultBuckets)
+	// Somaxconn reports the kernel's cap on the length of every socket's queue of pending
+	// connections.
+	Somaxconn = &Gauge{}
+	// Apps tracks the number of applications in nginx's current configuration.
+	Apps = &Gauge{}
+	// Domains, Locations, Endpoints, Certificates, WhitelistedApps, UnavailableApps,
+	// MaintenanceApps, and ACMEDomains track the composition of nginx's current configuration.
+	Domains         = &Gauge{}
+	Locations       = &Gauge{}
+	Endpoints       = &Gauge{}
+	Certificates    = &Gauge{}
+	WhitelistedApps = &Gauge{}
+	UnavailableApps = &Gauge{}
+	MaintenanceApps = &Gauge{}
+	ACMEDomains     = &Gauge{}
+	// Streams tracks the number of TCP and UDP ports proxied to applications.
+	Streams = &Gauge{}
+	// UnhealthyEndpoints tracks the number of endpoints left out of nginx's configuration because they
+	// failed their applications' health checks.
+	UnhealthyEndpoints = &Gauge{}
+	// QuarantinedApps tracks the number of applications whose changes were left out of nginx's
+	// current configuration because nginx rejected the configuration generated for them.
+	QuarantinedApps = &Gauge{}
+	// ShadowDiffLines tracks, for a router running in shadow mode, the number of lines by which its
+	// configuration differs from the active router's.
+	ShadowDiffLines = &Gauge{}
+	// ShadowFailures counts attempts, by a router running in shadow mode, to render its configuration
+	// and compare it with the active router's that failed.
+	ShadowFailures = &Counter{}
+	// CertificateExpiry reports the time, in seconds since the epoch, after which each certificate
+	// in nginx's current configuration is no longer valid, labeled by certificate.
+	CertificateExpiry = NewGaugeVec("certificate")
+	// ProbeUp reports whether each application was reachable through nginx when last probed, and
+	// ProbeDuration how long, in seconds, that probe took, labeled by app.
+	ProbeUp       = NewGaugeVec("app")
+	ProbeDuration = NewGaugeVec("app")
+	// IPRangeSourceUpdated reports the time, in seconds since the epoch, at which the ranges of each
+	// IP range source published at a URL were last fetched successfully, labeled by source.
+	IPRangeSourceUpdated = NewGaugeVec("source")
+)
+
+// Counter is a metric whose value only ever increases.
+type Counter struct {
+	mutex sync.Mutex
+	value float64
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increments the counter by the specified amount.
+func (c *Counter) Add(value float64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.value += value
+}
+
+// Value returns the counter's current value.
+func (c *Counter) Value() float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.value
+}
+
+// Gauge is a metric whose value may arbitrarily increase or decrease.
+type Gauge struct {
+	mutex sync.Mutex
+	value float64
+}
+
+// Set sets the gauge to the specified value.
+func (g *Gauge) Set(value float64) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.value = value
+}
+
+// Value returns the gauge's current value.
+func (g *Gauge) Value() float64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.value
+}
+
+// Summary is a metric that tracks the sum and count of a series of observations.
+type Summary struct {
+	mutex sync.Mutex
+	sum   float64
+	count uint64
+}
+
+// Observe adds a single observation to the summary.
+func (s *Summary) Observe(value float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sum += value
+	s.count++
+}
+
+// Values returns the sum and count of all observations made so far.
+func (s *Summary) Values() (float64, uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.sum, s.count
+}
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets into which durations are counted.
+// They span the range from trivial operations to those that are slow even on large clusters.
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// Histogram is a metric that counts observations in buckets, in addition to tracking their sum and
+// count.
+type Histogram struct {
+	mutex   sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+// NewHistogram returns a histogram with the specified bucket upper bounds, which must be sorted in
+// increasing order.
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// Observe adds a single observation to the histogram.
+func (h *Histogram) Observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// samples returns the histogram's cumulative bucket counts, sum, and count as samples, each bearing
+// the provided labels.
+func (h *Histogram) samples(labels []label) []sample {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	samples := make([]sample, 0, len(h.buckets)+3)
+	for i, upperBound := range h.buckets {
+		samples = append(samples, sample{
+			suffix: "_bucket",
+			labels: append(append([]label{}, labels...), label{"le", fmt.Sprint(upperBound)}),
+			value:  float64(h.counts[i]),
+		})
+	}
+	samples = append(samples,
+		sample{suffix: "_bucket", labels: append(append([]label{}, labels...), label{"le", "+Inf"}), value: float64(h.count)},
+		sample{suffix: "_sum", labels: labels, value: h.sum},
+		sample{suffix: "_count", labels: labels, value: float64(h.count)},
+	)
+	return samples
+}
+
+// HistogramVec is a set of histograms that share bucket bounds and are distinguished by the value
+// of a single label.
+type HistogramVec struct {
+	mutex      sync.Mutex
+	labelName  string
+	buckets    []float64
+	histograms map[string]*Histogram
+}
+
+// NewHistogramVec returns an empty set of histograms distinguished by the named label.
+func NewHistogramVec(labelName string, buckets []float64) *HistogramVec {
+	return &HistogramVec{labelName: labelName, buckets: buckets, histograms: map[string]*Histogram{}}
+}
+
+// With returns the histogram for the specified label value, creating it if necessary.
+func (v *HistogramVec) With(labelValue string) *Histogram {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	histogram, ok := v.histograms[labelValue]
+	if !ok {
+		histogram = NewHistogram(v.buckets)
+		v.histograms[labelValue] = histogram
+	}
+	return histogram
+}
+
+func (v *HistogramVec) samples() []sample {
+	v.mutex.Lock()
+	labelValues := make([]string, 0, len(v.histograms))
+	for labelValue := range v.histograms {
+		labelValues = append(labelValues, labelValue)
+	}
+	v.mutex.Unlock()
+	sort.Strings(labelValues)
+	samples := []sample{}
+	for _, labelValue := range labelValues {
+		samples = append(samples, v.With(labelValue).samples([]label{{v.labelName, labelValue}})...)
+	}
+	return samples
+}
+
+// CounterVec is a set of counters distinguished by the values of one or more labels.
+type CounterVec struct {
+	mutex      sync.Mutex
+	labelNames []string
+	counters   map[string]*Counter
+	values     map[string][]string
+}
+
+// NewCounterVec returns an empty set of counters distinguished by the named labels.
+func NewCounterVec(labelNames ...string) *CounterVec {
+	return &CounterVec{labelNames: labelNames, counters: map[string]*Counter{}, values: map[string][]string{}}
+}
+
+// With returns the counter for the specified label values, which must be as many as the set's label
+// names and in the same order, creating it if necessary.
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	key := strings.Join(labelValues, "\x00")
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	counter, ok := v.counters[key]
+	if !ok {
+		counter = &Counter{}
+		v.counters[key] = counter
+		v.values[key] = labelValues
+	}
+	return counter
+}
+
+func (v *CounterVec) samples() []sample {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	keys := make([]string, 0, len(v.counters))
+	for key := range v.counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	samples := make([]sample, 0, len(keys))
+	for _, key := range keys {
+		labels := make([]label, len(v.labelNames))
+		for i, labelName := range v.labelNames {
+			labels[i] = label{labelName, v.values[key][i]}
+		}
+		samples = append(samples, sample{labels: labels, value: v.counters[key].Value()})
+	}
+	return samples
+}
+
+// GaugeVec is a set of gauges distinguished by the values of one or more labels.
+type GaugeVec struct {
+	mutex      sync.Mutex
+	labelNames []string
+	gauges     map[string]*Gauge
+	values     map[string][]string
+}
+
+// NewGaugeVec returns an empty set of gauges distinguished by the named labels.
+func NewGaugeVec(labelNames ...string) *GaugeVec {
+	return &GaugeVec{labelNames: labelNames, gauges: map[string]*Gauge{}, values: map[string][]string{}}
+}
+
+// With returns the gauge for the specified label values, which must be as many as the set's label
+// names and in the same order, creating it if necessary.
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	key := strings.Join(labelValues, "\x00")
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	gauge, ok := v.gauges[key]
+	if !ok {
+		gauge = &Gauge{}
+		v.gauges[key] = gauge
+		v.values[key] = labelValues
+	}
+	return gauge
+}
+
+// Reset removes every gauge from the set, so that those describing things that no longer exist stop
+// being reported.
+func (v *GaugeVec) Reset() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.gauges = map[string]*Gauge{}
+	v.values = map[string][]string{}
+}
+
+func (v *GaugeVec) samples() []sample {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	keys := make([]string, 0, len(v.gauges))
+	for key := range v.gauges {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	samples := make([]sample, 0, len(keys))
+	for _, key := range keys {
+		labels := make([]label, len(v.labelNames))
+		for i, labelName := range v.labelNames {
+			labels[i] = label{labelName, v.values[key][i]}
+		}
+		samples = append(samples, sample{labels: labels, value: v.gauges[key].Value()})
+	}
+	return samples
+}
+
+// ObserveStage records the time elapsed since start as the duration of the named control loop stage.
+func ObserveStage(stage string, start time.Time) {
+	StageDuration.With(stage).Observe(time.Since(start).Seconds())
+}
+
+// Serve starts an HTTP server in the background that exposes metrics in the Prometheus text format
+// at /metrics on the specified address.  The server also serves any other provided handlers, keyed
+// by the patterns at which they are served.
+func Serve(addr string, handlers map[string]http.Handler) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler(trafficStatusURL))
+	for pattern, handler := range handlers {
+		mux.Handle(pattern, handler)
+	}
+	go func() {
+		log.Printf("INFO: Serving metrics on %s.", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("WARN: Metrics server stopped: %v", err)
+		}
+	}()
+}
+
+// Handler returns an http.Handler that writes the router's internal metrics and the traffic
+// statistics reported by nginx at the specified URL in the Prometheus text format.
+func Handler(trafficStatusURL string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeInternalMetrics(w)
+		trafficStatus, err := getTrafficStatus(trafficStatusURL)
+		if err != nil {
+			log.Printf("WARN: Failed to retrieve nginx traffic status: %v", err)
+		}
+		writeTrafficStatusMetrics(w, trafficStatus)
+	})
+}
+
+func writeInternalMetrics(w io.Writer) {
+	sum, count := ModelBuildDuration.Values()
+	writeMetric(w, "model_build_duration_seconds", "Time spent building the router's model from k8s resources.", "summary",
+		sample{suffix: "_sum", value: sum},
+		sample{suffix: "_count", value: float64(count)},
+	)
+	writeMetric(w, "stage_duration_seconds", "Time spent in each stage of the router's control loop.", "histogram",
+		StageDuration.samples()...,
+	)
+	writeMetric(w, "model_build_failures_total", "Number of failed attempts to build the router's model.", "counter",
+		sample{value: ModelBuildFailures.Value()},
+	)
+	writeMetric(w, "validation_failures_total", "Number of generated configurations that nginx rejected.", "counter",
+		sample{value: ValidationFailures.Value()},
+	)
+	writeMetric(w, "reloads_total", "Number of attempts to reload nginx with new configuration.", "counter",
+		sample{value: Reloads.Value()},
+	)
+	writeMetric(w, "reload_failures_total", "Number of failed attempts to reload nginx with new configuration.", "counter",
+		sample{value: ReloadFailures.Value()},
+	)
+	writeMetric(w, "reloads_skipped_total", "Number of changes that left nginx configuration and certificates unchanged, so nginx was not reloaded.", "counter",
+		sample{value: ReloadsSkipped.Value()},
+	)
+	writeMetric(w, "staged_promotions_total", "Number of new configurations applied after being served by a staged nginx instance.", "counter",
+		sample{value: StagedPromotions.Value()},
+	)
+	writeMetric(w, "staged_rollbacks_total", "Number of new configurations withheld because a staged nginx instance served more server errors with them.", "counter",
+		sample{value: StagedRollbacks.Value()},
+	)
+	writeMetric(w, "nginx_restarts_total", "Number of restarts of nginx after it exited unexpectedly.", "counter",
+		sample{value: NginxRestarts.Value()},
+	)
+	writeMetric(w, "somaxconn", "The kernel's cap on the length of every socket's queue of pending connections.", "gauge",
+		sample{value: Somaxconn.Value()},
+	)
+	writeMetric(w, "apps", "Number of applications in nginx's current configuration.", "gauge",
+		sample{value: Apps.Value()},
+	)
+	writeMetric(w, "domains", "Number of domains routed to applications in nginx's current configuration.", "gauge",
+		sample{value: Domains.Value()},
+	)
+	writeMetric(w, "locations", "Number of additional locations in nginx's current configuration.", "gauge",
+		sample{value: Locations.Value()},
+	)
+	writeMetric(w, "endpoints", "Number of pod endpoints proxied to in nginx's current configuration.", "gauge",
+		sample{value: Endpoints.Value()},
+	)
+	writeMetric(w, "certificates", "Number of certificates written for nginx's current configuration.", "gauge",
+		sample{value: Certificates.Value()},
+	)
+	writeMetric(w, "whitelisted_apps", "Number of applications whose access is restricted by a whitelist.", "gauge",
+		sample{value: WhitelistedApps.Value()},
+	)
+	writeMetric(w, "unavailable_apps", "Number of applications without any ready pods.", "gauge",
+		sample{value: UnavailableApps.Value()},
+	)
+	writeMetric(w, "maintenance_apps", "Number of applications in maintenance mode.", "gauge",
+		sample{value: MaintenanceApps.Value()},
+	)
+	writeMetric(w, "streams", "Number of TCP and UDP ports proxied to applications.", "gauge",
+		sample{value: Streams.Value()},
+	)
+	writeMetric(w, "acme_domains", "Number of domains whose certificates are managed by ACME.", "gauge",
+		sample{value: ACMEDomains.Value()},
+	)
+	writeMetric(w, "app_request_duration_seconds", "Time nginx spent handling each application's requests, if latency histograms are enabled.", "histogram",
+		RequestDuration.samples()...,
+	)
+	writeMetric(w, "app_upstream_response_duration_seconds", "Time each application's endpoints spent responding to requests, if latency histograms are enabled.", "histogram",
+		UpstreamResponseDuration.samples()...,
+	)
+	writeMetric(w, "tls_handshake_failures_total", "Number of TLS handshakes that failed, by listener and reason, if TLS metrics are enabled.", "counter",
+		TLSHandshakeFailures.samples()...,
+	)
+	writeMetric(w, "tls_unknown_sni_total", "Number of TLS connections that named a server for which no application has a certificate, by listener, if TLS metrics are enabled.", "counter",
+		TLSUnknownSNI.samples()...,
+	)
+	writeMetric(w, "unhealthy_endpoints", "Number of endpoints left out of nginx's current configuration because they failed their applications' health checks.", "gauge",
+		sample{value: UnhealthyEndpoints.Value()},
+	)
+	writeMetric(w, "quarantined_apps", "Number of applications whose changes were left out of nginx's current configuration because it rejected theirs.", "gauge",
+		sample{value: QuarantinedApps.Value()},
+	)
+	writeMetric(w, "shadow_diff_lines", "Number of lines by which a shadow router's configuration differs from the active router's.", "gauge",
+		sample{value: ShadowDiffLines.Value()},
+	)
+	writeMetric(w, "shadow_failures_total", "Number of failed attempts by a shadow router to render its configuration and compare it with the active router's.", "counter",
+		sample{value: ShadowFailures.Value()},
+	)
+	writeMetric(w, "certificate_expiry_timestamp_seconds", "Time, in seconds since the epoch, after which each certificate in nginx's current configuration is no longer valid.", "gauge",
+		CertificateExpiry.samples()...,
+	)
+	writeMetric(w, "probe_up", "Whether each application was reachable through nginx when last probed.", "gauge",
+		ProbeUp.samples()...,
+	)
+	writeMetric(w, "probe_duration_seconds", "Time taken by the last probe of each application through nginx.", "gauge",
+		ProbeDuration.samples()...,
+	)
+	writeMetric(w, "ip_range_source_update_timestamp_seconds", "Time, in seconds since the epoch, at which the ranges of each IP range source published at a URL were last fetched.", "gauge",
+		IPRangeSourceUpdated.samples()...,
+	)
+}
+
+// label is a single name/value pair that distinguishes one sample of a metric from another.
+type label struct {
+	name  string
+	value string
+}
+
+// sample is a single value of a metric.  The suffix, if any, is appended to the metric's name, as
+// is necessary for the constituent parts of a summary.
+type sample struct {
+	suffix string
+	labels []label
+	value  float64
+}
+
+// writeMetric writes a metric and all its samples in the Prometheus text format.
+func writeMetric(w io.Writer, name string, help string, metricType string, samples ...sample) {
+	name = fmt.Sprintf("%s_%s", namespace, name)
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+	for _, s := range samples {
+		fmt.Fprintf(w, "%s%s%s %v\n", name, s.suffix, formatLabels(s.labels), s.value)
+	}
+}
+
+func formatLabels(labels []label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(labels))
+	for i, l := range labels {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", l.name, labelValueEscaper.Replace(l.value))
+	}
+	return fmt.Sprintf("{%s}", strings.Join(pairs, ","))
+}
+
+var labelValueEscaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const trafficStatusJSON = `{
+	"connections": {"active": 3, "reading": 0, "writing": 1, "waiting": 2, "accepted": 10, "handled": 10, "requests": 42},
+	"filterZones": {
+		"application::*": {
+			"foo": {"requestCounter": 5, "inBytes": 100, "outBytes": 200, "responses": {"2xx": 4, "5xx": 1}},
+			"bar": {"requestCounter": 1, "inBytes": 10, "outBytes": 20, "responses": {"3xx": 1}}
+		}
+	}
+}`
+
+func TestServerErrors(t *testing.T) {
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, trafficStatusJSON)
+	}))
+	defer nginx.Close()
+
+	requests, errors, err := ServerErrors(nginx.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 6 || errors != 1 {
+		t.Errorf("Expected 1 server error among 6 requests, but got %d among %d.", errors, requests)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, trafficStatusJSON)
+	}))
+	defer nginx.Close()
+	Reloads.Inc()
+
+	output := scrape(t, Handler(nginx.URL))
+	for _, expected := range []string{
+		"deis_router_reloads_total 1\n",
+		"deis_router_nginx_up 1\n",
+		"deis_router_nginx_connections{state=\"active\"} 3\n",
+		"deis_router_nginx_requests_total 42\n",
+		"deis_router_app_requests_total{app=\"bar\",code=\"3xx\"} 1\n",
+		"deis_router_app_requests_total{app=\"foo\",code=\"5xx\"} 1\n",
+		"deis_router_app_bytes_total{app=\"foo\",direction=\"out\"} 200\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected metrics to contain %q, but they did not:\n%s", expected, output)
+		}
+	}
+	// Applications should be listed in a stable order.
+	if strings.Index(output, "app=\"bar\"") > strings.Index(output, "app=\"foo\"") {
+		t.Errorf("Expected application metrics to be sorted by application name:\n%s", output)
+	}
+}
+
+func TestHandlerNginxDown(t *testing.T) {
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer nginx.Close()
+
+	output := scrape(t, Handler(nginx.URL))
+	if !strings.Contains(output, "deis_router_nginx_up 0\n") {
+		t.Errorf("Expected nginx to be reported down:\n%s", output)
+	}
+	if !strings.Contains(output, "deis_router_apps ") {
+		t.Errorf("Expected internal metrics to be reported even when nginx is down:\n%s", output)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	histograms := NewHistogramVec("stage", []float64{0.1, 1})
+	histograms.With("reload").Observe(0.5)
+	histograms.With("reload").Observe(2)
+	histograms.With("list").Observe(0.05)
+
+	var buffer bytes.Buffer
+	writeMetric(&buffer, "test_duration_seconds", "Test.", "histogram", histograms.samples()...)
+	output := buffer.String()
+	for _, expected := range []string{
+		"deis_router_test_duration_seconds_bucket{stage=\"list\",le=\"0.1\"} 1\n",
+		"deis_router_test_duration_seconds_bucket{stage=\"reload\",le=\"0.1\"} 0\n",
+		"deis_router_test_duration_seconds_bucket{stage=\"reload\",le=\"1\"} 1\n",
+		"deis_router_test_duration_seconds_bucket{stage=\"reload\",le=\"+Inf\"} 2\n",
+		"deis_router_test_duration_seconds_sum{stage=\"reload\"} 2.5\n",
+		"deis_router_test_duration_seconds_count{stage=\"reload\"} 2\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected histogram to contain %q, but it did not:\n%s", expected, output)
+		}
+	}
+	if strings.Index(output, "stage=\"list\"") > strings.Index(output, "stage=\"reload\"") {
+		t.Errorf("Expected histograms to be sorted by label value:\n%s", output)
+	}
+}
+
+func TestGaugeVec(t *testing.T) {
+	gauges := NewGaugeVec("certificate")
+	gauges.With("platform").Set(1792310400)
+	gauges.With("example.com").Set(1790000000)
+
+	var buffer bytes.Buffer
+	writeMetric(&buffer, "test_timestamp_seconds", "Test.", "gauge", gauges.samples()...)
+	output := buffer.String()
+	for _, expected := range []string{
+		"deis_router_test_timestamp_seconds{certificate=\"example.com\"} 1.79e+09\n",
+		"deis_router_test_timestamp_seconds{certificate=\"platform\"} 1.7923104e+09\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected gauges to contain %q, but they did not:\n%s", expected, output)
+		}
+	}
+
+	// Gauges for things that no longer exist are no longer reported.
+	gauges.Reset()
+	gauges.With("platform").Set(1792310400)
+	if samples := gauges.samples(); len(samples) != 1 {
+		t.Errorf("Expected 1 gauge after resetting, but got %d.", len(samples))
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	if got := formatLabels(nil); got != "" {
+		t.Errorf("Expected no labels to format as an empty string, but got %q.", got)
+	}
+	got := formatLabels([]label{{"app", "a\"b\\c\nd"}, {"code", "2xx"}})
+	want := `{app="a\"b\\c\nd",code="2xx"}`
+	if got != want {
+		t.Errorf("Expected %s, but got %s.", want, got)
+	}
+}
+
+func scrape(t *testing.T, handler http.Handler) string {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bytes.TrimSpace(body)) + "\n"
+}
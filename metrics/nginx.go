@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// appFilterPrefix identifies the traffic status filter zones that nginx maintains per application.
+const appFilterPrefix = "application::"
+
+var trafficStatusClient = &http.Client{Timeout: 5 * time.Second}
+
+// trafficStatus is the subset of the vhost traffic status module's JSON report that the router
+// exposes as metrics.
+type trafficStatus struct {
+	Connections struct {
+		Active   uint64 `json:"active"`
+		Reading  uint64 `json:"reading"`
+		Writing  uint64 `json:"writing"`
+		Waiting  uint64 `json:"waiting"`
+		Accepted uint64 `json:"accepted"`
+		Handled  uint64 `json:"handled"`
+		Requests uint64 `json:"requests"`
+	} `json:"connections"`
+	FilterZones map[string]map[string]*trafficStatusZone `json:"filterZones"`
+}
+
+type trafficStatusZone struct {
+	RequestCounter uint64            `json:"requestCounter"`
+	InBytes        uint64            `json:"inBytes"`
+	OutBytes       uint64            `json:"outBytes"`
+	Responses      map[string]uint64 `json:"responses"`
+}
+
+func getTrafficStatus(url string) (*trafficStatus, error) {
+	res, err := trafficStatusClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d from %s", res.StatusCode, url)
+	}
+	status := &trafficStatus{}
+	if err := json.NewDecoder(res.Body).Decode(status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// writeTrafficStatusMetrics writes metrics derived from nginx's traffic status.  A nil status
+// indicates nginx could not be reached, which is reported as such.
+func writeTrafficStatusMetrics(w io.Writer, status *trafficStatus) {
+	if status == nil {
+		writeMetric(w, "nginx_up", "Whether nginx's traffic status could be retrieved.", "gauge", sample{value: 0})
+		return
+	}
+	writeMetric(w, "nginx_up", "Whether nginx's traffic status could be retrieved.", "gauge", sample{value: 1})
+	connections := status.Connections
+	writeMetric(w, "nginx_connections", "Number of client connections to nginx by state.", "gauge",
+		sample{labels: []label{{"state", "active"}}, value: float64(connections.Active)},
+		sample{labels: []label{{"state", "reading"}}, value: float64(connections.Reading)},
+		sample{labels: []label{{"state", "writing"}}, value: float64(connections.Writing)},
+		sample{labels: []label{{"state", "waiting"}}, value: float64(connections.Waiting)},
+	)
+	writeMetric(w, "nginx_connections_accepted_total", "Number of client connections accepted by nginx.", "counter",
+		sample{value: float64(connections.Accepted)},
+	)
+	writeMetric(w, "nginx_connections_handled_total", "Number of client connections handled by nginx.", "counter",
+		sample{value: float64(connections.Handled)},
+	)
+	writeMetric(w, "nginx_requests_total", "Number of client requests handled by nginx.", "counter",
+		sample{value: float64(connections.Requests)},
+	)
+
+	apps := []string{}
+	appZones := map[string]*trafficStatusZone{}
+	for filter, zones := range status.FilterZones {
+		if !strings.HasPrefix(filter, appFilterPrefix) {
+			continue
+		}
+		for app, zone := range zones {
+			apps = append(apps, app)
+			appZones[app] = zone
+		}
+	}
+	sort.Strings(apps)
+	requestSamples := []sample{}
+	byteSamples := []sample{}
+	for _, app := range apps {
+		zone := appZones[app]
+		for _, code := range sortedKeys(zone.Responses) {
+			requestSamples = append(requestSamples, sample{
+				labels: []label{{"app", app}, {"code", code}},
+				value:  float64(zone.Responses[code]),
+			})
+		}
+		byteSamples = append(byteSamples,
+			sample{labels: []label{{"app", app}, {"direction", "in"}}, value: float64(zone.InBytes)},
+			sample{labels: []label{{"app", app}, {"direction", "out"}}, value: float64(zone.OutBytes)},
+		)
+	}
+	writeMetric(w, "app_requests_total", "Number of requests handled per application by response code class.", "counter", requestSamples...)
+	writeMetric(w, "app_bytes_total", "Number of bytes received from and sent to clients per application.", "counter", byteSamples...)
+}
+
+// ServerErrors returns the number of requests for applications that nginx has answered, and the
+// number of those answered with a server error, as reported by nginx at the specified URL.
+func ServerErrors(url string) (uint64, uint64, error) {
+	status, err := getTrafficStatus(url)
+	if err != nil {
+		return 0, 0, err
+	}
+	var requests, errors uint64
+	for filter, zones := range status.FilterZones {
+		if !strings.HasPrefix(filter, appFilterPrefix) {
+			continue
+		}
+		for _, zone := range zones {
+			for code, count := range zone.Responses {
+				requests += count
+				if code == "5xx" {
+					errors += count
+				}
+			}
+		}
+	}
+	return requests, errors, nil
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+)
+
+const (
+	// TLSAddr is the UDP address at which the router receives nginx's reports of failed TLS handshakes
+	// and of connections for unknown server names, which nginx logs to it in the syslog protocol.
+	TLSAddr = "127.0.0.1:9095"
+	// TLSHandshakeTag tags the syslog messages in which nginx logs errors, among them failed TLS
+	// handshakes.
+	TLSHandshakeTag = "deis_tls"
+	// TLSUnknownSNITag tags the syslog messages in which nginx logs requests that arrived over TLS
+	// connections for server names that no application claims.
+	TLSUnknownSNITag = "deis_unknown_sni"
+)
+
+var (
+	// TLSHandshakeFailures counts the TLS handshakes that failed, labeled by the port on which the
+	// connection was accepted and the reason it failed.
+	TLSHandshakeFailures = NewCounterVec("listener", "reason")
+	// TLSUnknownSNI counts the requests that arrived over TLS connections for server names that no
+	// application claims, labeled by the port on which the connection was accepted.
+	TLSUnknownSNI = NewCounterVec("listener")
+)
+
+// handshakeFailureReasons map fragments of the OpenSSL errors that nginx logs for failed handshakes
+// to the reasons by which failures are counted.  Fragments are tried in order, and handshakes that
+// match none fail for reason "other".
+var handshakeFailureReasons = []struct {
+	fragment string
+	reason   string
+}{
+	{"no shared cipher", "cipher_mismatch"},
+	{"no ciphers", "cipher_mismatch"},
+	{"unsupported protocol", "protocol_mismatch"},
+	{"wrong version number", "protocol_mismatch"},
+	{"unknown protocol", "protocol_mismatch"},
+	{"version too low", "protocol_mismatch"},
+	{"alert protocol version", "protocol_mismatch"},
+	{"http request", "plaintext_http"},
+	{"alert certificate unknown", "certificate_rejected"},
+	{"alert bad certificate", "certificate_rejected"},
+	{"alert unknown ca", "certificate_rejected"},
+	{"peer closed connection", "peer_closed"},
+}
+
+// ServeTLSEvents starts listening in the background for the failed TLS handshakes and the unknown
+// server names logged by nginx, and counts each.
+func ServeTLSEvents() {
+	serveSyslog(TLSAddr, "TLS events", observeTLSEvent)
+}
+
+// observeTLSEvent counts the failed handshake or unknown server name reported by a syslog message.
+// nginx logs the former among its errors, as "... while SSL handshaking, client: <address>, server:
+// <listener>", and the latter in the "deis_unknown_sni" format, which is "<port>".  Other messages
+// are ignored.
+func observeTLSEvent(message []byte) {
+	if i := bytes.Index(message, []byte(TLSUnknownSNITag+": ")); i >= 0 {
+		port := strings.TrimSpace(string(message[i+len(TLSUnknownSNITag)+2:]))
+		if port != "" {
+			TLSUnknownSNI.With(port).Inc()
+		}
+		return
+	}
+	text := string(message)
+	if !strings.Contains(text, "while SSL handshaking") {
+		return
+	}
+	listener := ""
+	if i := strings.LastIndex(text, "server: "); i >= 0 {
+		listener = listenerPort(strings.TrimSpace(text[i+len("server: "):]))
+	}
+	TLSHandshakeFailures.With(listener, handshakeFailureReason(text)).Inc()
+}
+
+// handshakeFailureReason returns the reason for which the handshake whose failure nginx logged with
+// the provided error failed.
+func handshakeFailureReason(text string) string {
+	for _, r := range handshakeFailureReasons {
+		if strings.Contains(text, r.fragment) {
+			return r.reason
+		}
+	}
+	return "other"
+}
+
+// listenerPort returns the public port of the listener at the address nginx logs for a connection.
+// nginx listens inside the pod on 6443 for connections to the SSL port, 443, and accepts those that
+// the stream module relays to it on a unix socket.
+func listenerPort(addr string) string {
+	if strings.HasPrefix(addr, "unix:") {
+		return "443"
+	}
+	port := addr[strings.LastIndex(addr, ":")+1:]
+	switch port {
+	case "6443":
+		return "443"
+	case "8080":
+		return "80"
+	}
+	return port
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestObserveTLSEvent(t *testing.T) {
+	for _, message := range []string{
+		"<190>Oct 16 12:00:00 deis_tls: 2026/10/16 12:00:00 [info] 12#0: *1 SSL_do_handshake() failed (SSL: error:1408A0C1:SSL routines:ssl3_get_client_hello:no shared cipher) while SSL handshaking, client: 10.0.0.1, server: 0.0.0.0:6443",
+		"<190>Oct 16 12:00:00 deis_tls: 2026/10/16 12:00:00 [info] 12#0: *2 SSL_do_handshake() failed (SSL: error:1408A0C1:SSL routines:ssl3_get_client_hello:no shared cipher) while SSL handshaking, client: 10.0.0.2, server: unix:/tmp/deis-ssl.sock",
+		"<190>Oct 16 12:00:00 deis_tls: 2026/10/16 12:00:00 [info] 12#0: *3 SSL_do_handshake() failed (SSL: error:1408A10B:SSL routines:ssl3_get_client_hello:wrong version number) while SSL handshaking, client: 10.0.0.3, server: 0.0.0.0:6443",
+		"<190>Oct 16 12:00:00 deis_tls: 2026/10/16 12:00:00 [info] 12#0: *4 peer closed connection in SSL handshake while SSL handshaking, client: 10.0.0.4, server: 0.0.0.0:6443",
+		"<190>Oct 16 12:00:00 deis_tls: 2026/10/16 12:00:00 [info] 12#0: *5 SSL_do_handshake() failed (SSL: error:14094418:SSL routines:ssl3_read_bytes:tlsv1 alert unknown ca:SSL alert number 48) while SSL handshaking, client: 10.0.0.5, server: 0.0.0.0:6443",
+		"<190>Oct 16 12:00:00 deis_tls: 2026/10/16 12:00:00 [info] 12#0: *6 SSL_do_handshake() failed (SSL: error:00000000:lib(0):func(0):reason(0)) while SSL handshaking, client: 10.0.0.6, server: 0.0.0.0:6443",
+		"<190>Oct 16 12:00:00 deis_unknown_sni: 443",
+		"<190>Oct 16 12:00:00 deis_unknown_sni: 443",
+		// Other errors are ignored.
+		"<190>Oct 16 12:00:00 deis_tls: 2026/10/16 12:00:00 [info] 12#0: *7 client closed connection while waiting for request, client: 10.0.0.7, server: 0.0.0.0:8080",
+	} {
+		observeTLSEvent([]byte(message))
+	}
+
+	var buffer bytes.Buffer
+	writeMetric(&buffer, "tls_handshake_failures_total", "Test.", "counter", TLSHandshakeFailures.samples()...)
+	writeMetric(&buffer, "tls_unknown_sni_total", "Test.", "counter", TLSUnknownSNI.samples()...)
+	output := buffer.String()
+	for _, expected := range []string{
+		"deis_router_tls_handshake_failures_total{listener=\"443\",reason=\"cipher_mismatch\"} 2\n",
+		"deis_router_tls_handshake_failures_total{listener=\"443\",reason=\"protocol_mismatch\"} 1\n",
+		"deis_router_tls_handshake_failures_total{listener=\"443\",reason=\"peer_closed\"} 1\n",
+		"deis_router_tls_handshake_failures_total{listener=\"443\",reason=\"certificate_rejected\"} 1\n",
+		"deis_router_tls_handshake_failures_total{listener=\"443\",reason=\"other\"} 1\n",
+		"deis_router_tls_unknown_sni_total{listener=\"443\"} 2\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected TLS metrics to contain %q, but they did not:\n%s", expected, output)
+		}
+	}
+	if strings.Contains(output, "listener=\"80\"") {
+		t.Errorf("Expected errors other than failed handshakes to be ignored:\n%s", output)
+	}
+}
@@ -0,0 +1,51 @@
+package model
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/1.4/pkg/api/v1"
+)
+
+// cdnClientIPHeaders maps each CDN preset to the request header in which that CDN reports the
+// address of the client on whose behalf it made a request.
+var cdnClientIPHeaders = map[string]string{
+	"cloudflare": "CF-Connecting-IP",
+	"cloudfront": "X-Forwarded-For",
+	"fastly":     "Fastly-Client-IP",
+}
+
+// CDNClientIPHeader returns the header in which the CDN the application sits behind reports
+// clients' addresses, or an empty string if it sits behind none.
+func (appConfig *AppConfig) CDNClientIPHeader() string {
+	return cdnClientIPHeaders[appConfig.CDN]
+}
+
+// ProxyProtocolRealIP returns whether the router takes clients' addresses from the PROXY protocol on
+// any of its http listeners, in which case a CDN's header cannot also be used to find them.
+func (routerConfig *RouterConfig) ProxyProtocolRealIP() bool {
+	return routerConfig.RealIPHeader == "proxy_protocol" ||
+		routerConfig.ProxyProtocolHTTP() ||
+		routerConfig.ProxyProtocolHTTPS() ||
+		(routerConfig.SSLConfig != nil && routerConfig.SSLConfig.SNIConnectionLimit > 0)
+}
+
+// validateCDN warns if the router defines no IP range source named for the CDN the application
+// sits behind, since the addresses from which that CDN connects are taken from it.  Until one is
+// defined, no request is trusted to report its client's address by way of the CDN's header.
+func validateCDN(routerConfig *RouterConfig, kind string, meta v1.ObjectMeta, appConfig *AppConfig) {
+	if appConfig.CDNClientIPHeader() == "" {
+		return
+	}
+	for _, source := range routerConfig.IPRangeSources {
+		if source.Name == appConfig.CDN {
+			return
+		}
+	}
+	routerConfig.warn(Warning{
+		Kind:      kind,
+		Namespace: meta.Namespace,
+		Name:      meta.Name,
+		Reason:    "InvalidAnnotation",
+		Message:   fmt.Sprintf("The router defines no IP range source named \"%s\", so the addresses from which that CDN connects are unknown and its reports of clients' addresses are not trusted.", appConfig.CDN),
+	})
+}
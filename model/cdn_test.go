@@ -0,0 +1,54 @@
+package model
+
+import (
+	"testing"
+
+	"k8s.io/client-go/1.4/pkg/api/v1"
+)
+
+func TestCDNClientIPHeader(t *testing.T) {
+	appConfig := newAppConfig(newRouterConfig())
+	if header := appConfig.CDNClientIPHeader(); header != "" {
+		t.Errorf("Expected no CDN header for an application behind no CDN, but got \"%s\"", header)
+	}
+	for cdn, expected := range map[string]string{"cloudflare": "CF-Connecting-IP", "cloudfront": "X-Forwarded-For", "fastly": "Fastly-Client-IP"} {
+		appConfig.CDN = cdn
+		if header := appConfig.CDNClientIPHeader(); header != expected {
+			t.Errorf("Expected the CDN header for %s to be \"%s\", but got \"%s\"", cdn, expected, header)
+		}
+	}
+}
+
+func TestProxyProtocolRealIP(t *testing.T) {
+	routerConfig := newRouterConfig()
+	if routerConfig.ProxyProtocolRealIP() {
+		t.Errorf("Expected clients' addresses not to be taken from the PROXY protocol by default.")
+	}
+	routerConfig.UseProxyProtocol = true
+	if !routerConfig.ProxyProtocolRealIP() {
+		t.Errorf("Expected clients' addresses to be taken from the PROXY protocol when the router uses it.")
+	}
+	routerConfig.UseProxyProtocol = false
+	routerConfig.SSLConfig.SNIConnectionLimit = 10
+	if !routerConfig.ProxyProtocolRealIP() {
+		t.Errorf("Expected clients' addresses to be taken from the PROXY protocol when SSL connections are relayed.")
+	}
+}
+
+func TestValidateCDN(t *testing.T) {
+	routerConfig := newRouterConfig()
+	routerConfig.IPRangeSources = []*IPRangeSource{&IPRangeSource{Name: "cloudflare"}}
+	appConfig := newAppConfig(routerConfig)
+	for _, cdn := range []string{"none", "cloudflare"} {
+		appConfig.CDN = cdn
+		validateCDN(routerConfig, "Service", v1.ObjectMeta{Name: "foo", Namespace: "bar"}, appConfig)
+	}
+	if len(routerConfig.Warnings) != 0 {
+		t.Errorf("Expected no warnings, but got %v", routerConfig.Warnings)
+	}
+	appConfig.CDN = "fastly"
+	validateCDN(routerConfig, "Service", v1.ObjectMeta{Name: "foo", Namespace: "bar"}, appConfig)
+	if len(routerConfig.Warnings) != 1 || routerConfig.Warnings[0].Reason != "InvalidAnnotation" {
+		t.Errorf("Expected 1 InvalidAnnotation warning, but got %v", routerConfig.Warnings)
+	}
+}
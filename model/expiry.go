@@ -0,0 +1,116 @@
+package model
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// certificateNotAfter returns the time after which the first certificate in the provided PEM bundle,
+// which is the one nginx presents, is no longer valid, and whether it could be parsed at all.
+func certificateNotAfter(cert string) (time.Time, bool) {
+	rest := []byte(cert)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return time.Time{}, false
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		parsed, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return parsed.NotAfter, true
+	}
+}
+
+// CertificateExpiries returns the times after which each of the certificates the router serves is
+// no longer valid, keyed by the base names of the files they are written to.  Certificates whose
+// expiry is unknown are left out.
+func (routerConfig *RouterConfig) CertificateExpiries() map[string]time.Time {
+	expiries := map[string]time.Time{}
+	if certificate := routerConfig.PlatformCertificate; certificate != nil && !certificate.NotAfter.IsZero() {
+		expiries[certificate.Name] = certificate.NotAfter
+	}
+	for _, appConfig := range routerConfig.AppConfigs {
+		for domain, certificate := range appConfig.Certificates {
+			if certificate == nil || certificate.NotAfter.IsZero() {
+				continue
+			}
+			name := certificate.Name
+			if name == "" {
+				name = domain
+			}
+			expiries[name] = certificate.NotAfter
+		}
+	}
+	return expiries
+}
+
+// checkCertificateExpiry warns of each certificate the router serves that expires within the
+// configured number of days of the provided time, or has already expired.  Warnings are logged and,
+// if so configured, also posted as events on the resources that requested the certificates.
+func checkCertificateExpiry(routerConfig *RouterConfig, now time.Time) {
+	certExpiryConfig := routerConfig.CertExpiryConfig
+	if certExpiryConfig == nil || certExpiryConfig.WarningDays == 0 {
+		return
+	}
+	deadline := now.Add(time.Duration(certExpiryConfig.WarningDays) * 24 * time.Hour)
+	report := func(warning Warning) {
+		if certExpiryConfig.Events {
+			routerConfig.warn(warning)
+		} else {
+			log.Printf("WARN: %s\n", warning)
+		}
+	}
+	if certificate := routerConfig.PlatformCertificate; certificate != nil && !certificate.NotAfter.IsZero() && certificate.NotAfter.Before(deadline) {
+		report(Warning{
+			Kind:      "Secret",
+			Namespace: namespace,
+			Name:      "deis-router-platform-cert",
+			Reason:    "CertificateExpiring",
+			Message:   expiryMessage("the platform domain", certificate.NotAfter, now, certExpiryConfig.WarningDays),
+		})
+	}
+	for _, appConfig := range routerConfig.AppConfigs {
+		// Domains that share a certificate are reported together.
+		domains := map[*Certificate][]string{}
+		for domain, certificate := range appConfig.Certificates {
+			if certificate != nil && !certificate.NotAfter.IsZero() && certificate.NotAfter.Before(deadline) {
+				domains[certificate] = append(domains[certificate], domain)
+			}
+		}
+		messages := []string{}
+		for certificate, certDomains := range domains {
+			sort.Strings(certDomains)
+			messages = append(messages, expiryMessage(strings.Join(certDomains, ", "), certificate.NotAfter, now, certExpiryConfig.WarningDays))
+		}
+		sort.Strings(messages)
+		for _, message := range messages {
+			report(Warning{
+				Kind:      appConfig.ResourceKind,
+				Namespace: appConfig.Namespace,
+				Name:      appConfig.ResourceName,
+				Reason:    "CertificateExpiring",
+				Message:   message,
+			})
+		}
+	}
+}
+
+// expiryMessage describes the expiry of the certificate for the named domains.  It names the date
+// rather than the days remaining, so that the warning, and the event posted for it, stays the same
+// from one day to the next.
+func expiryMessage(domains string, notAfter time.Time, now time.Time, warningDays int) string {
+	if notAfter.Before(now) {
+		return fmt.Sprintf("The certificate for %s expired on %s, so clients refuse it.", domains, notAfter.UTC().Format("2006-01-02"))
+	}
+	return fmt.Sprintf("The certificate for %s expires on %s, within %d days.", domains, notAfter.UTC().Format("2006-01-02"), warningDays)
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// newTestPEMCertificate returns a self-signed certificate, in PEM form, that expires at the provided
+// time.
+func newTestPEMCertificate(t *testing.T, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    notAfter.Add(-90 * 24 * time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestCertificateNotAfter(t *testing.T) {
+	expected := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC)
+	// Anything before the certificate, such as a key bundled with it, is skipped.
+	bundle := "-----BEGIN EC PARAMETERS-----\nBggqhkjOPQMBBw==\n-----END EC PARAMETERS-----\n" + newTestPEMCertificate(t, expected) + newTestPEMCertificate(t, expected.Add(time.Hour))
+	notAfter, ok := certificateNotAfter(bundle)
+	if !ok || !notAfter.Equal(expected) {
+		t.Errorf("Expected the first certificate to expire at %s, but got %s (%t).", expected, notAfter, ok)
+	}
+	if _, ok := certificateNotAfter("foo"); ok {
+		t.Errorf("Expected a certificate that is not PEM to be reported unparsable.")
+	}
+}
+
+func TestCheckCertificateExpiry(t *testing.T) {
+	now := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	routerConfig := newRouterConfig()
+	routerConfig.CertExpiryConfig.Events = true
+	routerConfig.PlatformCertificate = &Certificate{Name: "platform", NotAfter: now.Add(365 * 24 * time.Hour)}
+	shared := &Certificate{Name: "_.example.com", NotAfter: now.Add(10 * 24 * time.Hour)}
+	expired := &Certificate{NotAfter: now.Add(-time.Hour)}
+	appConfig := newLintTestAppConfig(routerConfig)
+	appConfig.Domains = []string{"a.example.com", "b.example.com", "old.example.org", "new.example.org", "plain.example.org"}
+	appConfig.Certificates = map[string]*Certificate{
+		"a.example.com":     shared,
+		"b.example.com":     shared,
+		"old.example.org":   expired,
+		"new.example.org":   &Certificate{NotAfter: now.Add(60 * 24 * time.Hour)},
+		"plain.example.org": nil,
+	}
+	routerConfig.AppConfigs = []*AppConfig{appConfig}
+
+	checkCertificateExpiry(routerConfig, now)
+	expected := []string{
+		"The certificate for a.example.com, b.example.com expires on 2026-10-26, within 30 days.",
+		"The certificate for old.example.org expired on 2026-10-15, so clients refuse it.",
+	}
+	if len(routerConfig.Warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, but got %v", len(expected), routerConfig.Warnings)
+	}
+	for i, warning := range routerConfig.Warnings {
+		if warning.Message != expected[i] || warning.Reason != "CertificateExpiring" || warning.Name != "bar" {
+			t.Errorf("Expected warning %d to be \"%s\", but got %+v", i, expected[i], warning)
+		}
+	}
+
+	// Without events, expiring certificates are only logged.
+	routerConfig.Warnings = nil
+	routerConfig.CertExpiryConfig.Events = false
+	checkCertificateExpiry(routerConfig, now)
+	if len(routerConfig.Warnings) != 0 {
+		t.Errorf("Expected no warnings to be posted, but got %v", routerConfig.Warnings)
+	}
+
+	expiries := routerConfig.CertificateExpiries()
+	for name, notAfter := range map[string]time.Time{"platform": routerConfig.PlatformCertificate.NotAfter, "_.example.com": shared.NotAfter, "old.example.org": expired.NotAfter} {
+		if !expiries[name].Equal(notAfter) {
+			t.Errorf("Expected %s to expire at %s, but got %s.", name, notAfter, expiries[name])
+		}
+	}
+	if len(expiries) != 4 {
+		t.Errorf("Expected 4 certificates' expiries, but got %v", expiries)
+	}
+}
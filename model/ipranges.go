@@ -0,0 +1,199 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"k8s.io/client-go/1.4/kubernetes"
+	"k8s.io/client-go/1.4/pkg/api/v1"
+)
+
+// IPRangeSource is a published list of IP ranges, such as those from which a CDN connects to its
+// origins, that applications may whitelist by name, and whose addresses may be trusted to report
+// clients' addresses.  The ranges are either fetched by the router from URL every Interval, or read
+// from ConfigMap, a config map in the router's namespace whose values list them.  Ranges holds those
+// most recently fetched or read.
+type IPRangeSource struct {
+	Name         string `key:"name" constraint:"^[a-z0-9]([-a-z0-9]*[a-z0-9])?$"`
+	URL          string `key:"url" constraint:"^https?://[^\\s]+$"`
+	ConfigMap    string `key:"configMap" constraint:"^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$"`
+	Interval     string `key:"interval" type:"duration" min:"1m" max:"24h"`
+	TrustProxies bool   `key:"trustProxies" constraint:"(?i)^(true|false)$"`
+	Ranges       []string
+}
+
+func newIPRangeSource() *IPRangeSource {
+	return &IPRangeSource{
+		Interval: "1h",
+	}
+}
+
+// buildIPRangeSources parses the structured IP range sources annotation, if present, into a slice of
+// IPRangeSources.  As with listeners, any problem found is logged and the offending source (or the
+// entire annotation, if it cannot be parsed at all) is skipped.
+func buildIPRangeSources(annotations map[string]string) []*IPRangeSource {
+	sourcesJSON, ok := annotations[fmt.Sprintf("%s/nginx.ipRangeSources", prefix)]
+	if !ok {
+		return nil
+	}
+	var rawSources []map[string]string
+	if err := json.Unmarshal([]byte(sourcesJSON), &rawSources); err != nil {
+		log.Printf("WARN: Failed to parse the router's IP range sources: %v -- skipping all IP range sources.\n", err)
+		return nil
+	}
+	sources := []*IPRangeSource{}
+	names := make(map[string]bool, len(rawSources))
+	for _, rawSource := range rawSources {
+		source := newIPRangeSource()
+		if err := locationModeler.MapToModel(rawSource, "", source); err != nil {
+			log.Printf("WARN: Failed to model an IP range source for the router: %v -- skipping this source.\n", err)
+			continue
+		}
+		if source.Name == "" {
+			log.Printf("WARN: An IP range source for the router has a missing or invalid name -- skipping this source.\n")
+			continue
+		}
+		if (source.URL == "") == (source.ConfigMap == "") {
+			log.Printf("WARN: The router's IP range source \"%s\" must have either a URL or a config map -- skipping this source.\n", source.Name)
+			continue
+		}
+		if names[source.Name] {
+			log.Printf("WARN: The router's IP range source \"%s\" is defined more than once -- skipping the duplicate.\n", source.Name)
+			continue
+		}
+		names[source.Name] = true
+		sources = append(sources, source)
+	}
+	return sources
+}
+
+// resolveIPRangeSources reads the ranges of each IP range source held in a config map.  A config
+// map that does not exist is warned about, and its source has no ranges.
+func resolveIPRangeSources(kubeClient *kubernetes.Clientset, routerConfig *RouterConfig) error {
+	for _, source := range routerConfig.IPRangeSources {
+		if source.ConfigMap == "" {
+			continue
+		}
+		configMap, err := getConfigMap(kubeClient, source.ConfigMap, namespace)
+		if err != nil {
+			return err
+		}
+		if configMap == nil {
+			routerConfig.warn(Warning{
+				Kind:      "Deployment",
+				Namespace: namespace,
+				Name:      "deis-router",
+				Reason:    "InvalidIPRangeSource",
+				Message:   fmt.Sprintf("The config map \"%s\" holding the IP range source \"%s\" does not exist, so the source has no ranges.", source.ConfigMap, source.Name),
+			})
+			continue
+		}
+		source.Ranges = configMapIPRanges(configMap)
+	}
+	return nil
+}
+
+// configMapIPRanges returns the IP ranges listed in all of the provided config map's values, in the
+// order of its keys.
+func configMapIPRanges(configMap *v1.ConfigMap) []string {
+	keys := make([]string, 0, len(configMap.Data))
+	for key := range configMap.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	ranges := []string{}
+	for _, key := range keys {
+		ranges = append(ranges, ParseIPRanges([]byte(configMap.Data[key]))...)
+	}
+	return ranges
+}
+
+// ParseIPRanges returns the IP addresses and CIDR ranges listed in the provided data.  If the data
+// is JSON, as many providers publish their ranges, every string in it that is an address or range
+// is taken.  Otherwise, every word, separated by whitespace or commas, that is an address or range
+// is taken.  Anything else, such as comments or a document's other fields, is ignored.
+func ParseIPRanges(data []byte) []string {
+	ranges := []string{}
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err == nil {
+		collectIPRanges(document, &ranges)
+		return ranges
+	}
+	words := strings.FieldsFunc(string(data), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	})
+	for _, word := range words {
+		if isAddressOrCIDR(word) {
+			ranges = append(ranges, word)
+		}
+	}
+	return ranges
+}
+
+// collectIPRanges appends every string within the provided JSON value that is an IP address or CIDR
+// range to ranges.
+func collectIPRanges(value interface{}, ranges *[]string) {
+	switch value := value.(type) {
+	case string:
+		if isAddressOrCIDR(value) {
+			*ranges = append(*ranges, value)
+		}
+	case []interface{}:
+		for _, element := range value {
+			collectIPRanges(element, ranges)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			collectIPRanges(value[key], ranges)
+		}
+	}
+}
+
+// IPRanges returns the ranges currently known of the named IP range sources, in the order they are
+// named.  Sources that do not exist, or whose ranges are not yet known, contribute none.
+func (routerConfig *RouterConfig) IPRanges(names []string) []string {
+	ranges := []string{}
+	for _, name := range names {
+		for _, source := range routerConfig.IPRangeSources {
+			if source.Name == name {
+				ranges = append(ranges, source.Ranges...)
+			}
+		}
+	}
+	return ranges
+}
+
+// Whitelisted returns whether the application has a whitelist of its own, whether of addresses or
+// of IP range sources.
+func (appConfig *AppConfig) Whitelisted() bool {
+	return len(appConfig.Whitelist) > 0 || len(appConfig.WhitelistSources) > 0
+}
+
+// validateWhitelistSources warns about each IP range source the application names that the router
+// does not define.  The name is kept, so that the application remains restricted to its other
+// whitelisted addresses rather than opened to all.
+func validateWhitelistSources(routerConfig *RouterConfig, kind string, meta v1.ObjectMeta, appConfig *AppConfig) {
+	for _, name := range appConfig.WhitelistSources {
+		defined := false
+		for _, source := range routerConfig.IPRangeSources {
+			defined = defined || source.Name == name
+		}
+		if !defined {
+			routerConfig.warn(Warning{
+				Kind:      kind,
+				Namespace: meta.Namespace,
+				Name:      meta.Name,
+				Reason:    "InvalidAnnotation",
+				Message:   fmt.Sprintf("The IP range source \"%s\" is not defined by the router, so no addresses are whitelisted from it.", name),
+			})
+		}
+	}
+}
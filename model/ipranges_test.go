@@ -0,0 +1,97 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/client-go/1.4/pkg/api/v1"
+)
+
+func TestBuildIPRangeSources(t *testing.T) {
+	annotations := map[string]string{
+		"router.deis.io/nginx.ipRangeSources": `[
+			{"name": "cloudflare", "url": "https://www.cloudflare.com/ips-v4", "interval": "6h", "trustProxies": "true"},
+			{"name": "office", "configMap": "office-ranges"},
+			{"name": "cloudflare", "url": "https://www.cloudflare.com/ips-v6"},
+			{"name": "both", "url": "https://example.com/ranges", "configMap": "ranges"},
+			{"name": "neither"},
+			{"name": "Bad Name", "url": "https://example.com/ranges"},
+			{"url": "https://example.com/ranges"}
+		]`,
+	}
+	cloudflare := newIPRangeSource()
+	cloudflare.Name = "cloudflare"
+	cloudflare.URL = "https://www.cloudflare.com/ips-v4"
+	cloudflare.Interval = "6h"
+	cloudflare.TrustProxies = true
+	office := newIPRangeSource()
+	office.Name = "office"
+	office.ConfigMap = "office-ranges"
+	// Sources with duplicate or missing names, or without exactly one of a URL and a config map,
+	// should be skipped.
+	expected := []*IPRangeSource{cloudflare, office}
+
+	if actual := buildIPRangeSources(annotations); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected IP range sources %+v, but got %+v.", expected, actual)
+	}
+
+	// Ensure unparseable JSON results in no sources rather than an error.
+	annotations["router.deis.io/nginx.ipRangeSources"] = `{"name": "cloudflare"`
+	if sources := buildIPRangeSources(annotations); sources != nil {
+		t.Errorf("Expected no IP range sources from unparseable JSON, but got %+v.", sources)
+	}
+}
+
+func TestParseIPRanges(t *testing.T) {
+	text := "# Published ranges\n173.245.48.0/20\n103.21.244.0/22, 2400:cb00::/32\r\nnot-a-range 1.2.3.4\n"
+	expected := []string{"173.245.48.0/20", "103.21.244.0/22", "2400:cb00::/32", "1.2.3.4"}
+	if actual := ParseIPRanges([]byte(text)); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected ranges %v from text, but got %v", expected, actual)
+	}
+
+	document := `{"syncToken": "1700000000", "prefixes": [{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2"}], "ipv6_prefixes": [{"ipv6_prefix": "2600:1f14::/35"}]}`
+	expected = []string{"2600:1f14::/35", "3.5.140.0/22"}
+	if actual := ParseIPRanges([]byte(document)); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected ranges %v from JSON, but got %v", expected, actual)
+	}
+
+	if actual := ParseIPRanges([]byte("<html>Not found</html>")); len(actual) != 0 {
+		t.Errorf("Expected no ranges, but got %v", actual)
+	}
+}
+
+func TestConfigMapIPRanges(t *testing.T) {
+	configMap := &v1.ConfigMap{Data: map[string]string{"v6": "2001:db8::/32", "v4": "192.0.2.0/24\n198.51.100.0/24"}}
+	expected := []string{"192.0.2.0/24", "198.51.100.0/24", "2001:db8::/32"}
+	if actual := configMapIPRanges(configMap); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected ranges %v, but got %v", expected, actual)
+	}
+}
+
+func TestIPRanges(t *testing.T) {
+	routerConfig := newRouterConfig()
+	routerConfig.IPRangeSources = []*IPRangeSource{
+		&IPRangeSource{Name: "cdn", Ranges: []string{"192.0.2.0/24", "2001:db8::/32"}},
+		&IPRangeSource{Name: "office", Ranges: []string{"198.51.100.0/24"}},
+		&IPRangeSource{Name: "pending"},
+	}
+	expected := []string{"198.51.100.0/24", "192.0.2.0/24", "2001:db8::/32"}
+	if actual := routerConfig.IPRanges([]string{"office", "pending", "unknown", "cdn"}); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected ranges %v, but got %v", expected, actual)
+	}
+}
+
+func TestValidateWhitelistSources(t *testing.T) {
+	routerConfig := newRouterConfig()
+	routerConfig.IPRangeSources = []*IPRangeSource{&IPRangeSource{Name: "cdn"}}
+	appConfig := newAppConfig(routerConfig)
+	appConfig.WhitelistSources = []string{"cdn", "cnd"}
+	validateWhitelistSources(routerConfig, "Service", v1.ObjectMeta{Name: "foo", Namespace: "bar"}, appConfig)
+	if len(routerConfig.Warnings) != 1 || routerConfig.Warnings[0].Reason != "InvalidAnnotation" {
+		t.Errorf("Expected 1 InvalidAnnotation warning, but got %v", routerConfig.Warnings)
+	}
+	// Unknown sources are kept, so that the application stays whitelisted.
+	if !reflect.DeepEqual(appConfig.WhitelistSources, []string{"cdn", "cnd"}) || !appConfig.Whitelisted() {
+		t.Errorf("Expected the application to remain whitelisted by both sources, but got %v", appConfig.WhitelistSources)
+	}
+}
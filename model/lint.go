@@ -0,0 +1,476 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// appLintRule inspects the configuration of one application, as built, for settings that are
+// individually valid but that conflict with one another or with the router's own settings, and
+// returns a description of each problem found.
+type appLintRule func(routerConfig *RouterConfig, appConfig *AppConfig) []string
+
+// appLintRules are run over every application once the router's configuration has been built.
+var appLintRules = []appLintRule{
+	lintSSLEnforcement,
+	lintWhitelist,
+	lintCanary,
+	lintAffinity,
+	lintExternalAuth,
+	lintFaultInjection,
+	lintHTTP2,
+	lintWeightedLocations,
+	lintGRPC,
+	lintGeoIP,
+	lintEarlyData,
+	lintUpstreamTLS,
+	lintPrefixes,
+	lintExternalOrigin,
+	lintRedirects,
+	lintCORS,
+	lintBackupOrigin,
+	lintWAF,
+	lintCDN,
+	lintUpstreamKeepalive,
+	lintRequestBuffering,
+	lintCacheControl,
+	lintRanges,
+	lintGRPCWeb,
+	lintBodySizeResponse,
+	lintSnippets,
+	lintInterceptedSignin,
+}
+
+// lint records a warning about each conflicting combination of settings found in the provided
+// configuration.  Such configuration is still rendered as usual, since nginx accepts it, but it is
+// unlikely to behave as its owner intended.
+func lint(routerConfig *RouterConfig) {
+	for _, appConfig := range routerConfig.AppConfigs {
+		for _, rule := range appLintRules {
+			for _, problem := range rule(routerConfig, appConfig) {
+				routerConfig.warn(Warning{
+					Kind:      appConfig.ResourceKind,
+					Namespace: appConfig.Namespace,
+					Name:      appConfig.ResourceName,
+					Reason:    "ConflictingConfiguration",
+					Message:   problem,
+				})
+			}
+		}
+	}
+}
+
+// lintSSLEnforcement flags domains to which requests are redirected over HTTPS even though there is
+// no certificate, nor any pending ACME order, with which to serve them.
+func lintSSLEnforcement(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	if appConfig.SSLConfig == nil || routerConfig.SSLConfig == nil {
+		return nil
+	}
+	if appConfig.SSLConfig.Enforce != "true" && routerConfig.SSLConfig.Enforce != "true" {
+		return nil
+	}
+	acmeDomains := make(map[string]bool, len(appConfig.ACMEDomains))
+	for _, domain := range appConfig.ACMEDomains {
+		acmeDomains[domain] = true
+	}
+	uncovered := []string{}
+	for _, domain := range appConfig.Domains {
+		if appConfig.Certificates[domain] == nil && !acmeDomains[domain] {
+			uncovered = append(uncovered, domain)
+		}
+	}
+	if len(uncovered) == 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("SSL is enforced, but there is no certificate for %s, so requests will be redirected to HTTPS that cannot be served.", strings.Join(uncovered, ", "))}
+}
+
+// lintWhitelist flags whitelists that may be matched against the address of a load balancer rather
+// than that of the client.
+func lintWhitelist(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	if !appConfig.Whitelisted() || routerConfig.ProxyProtocolHTTP() || routerConfig.ProxyProtocolHTTPS() || len(routerConfig.ProxyRealIPCIDRs) > 0 {
+		return nil
+	}
+	return []string{"The application is whitelisted, but the router neither uses the PROXY protocol nor trusts any proxy's X-Forwarded-For header, so the whitelist may be matched against the address of a load balancer rather than the client's."}
+}
+
+// lintCanary flags canary settings that send no traffic to a canary, or traffic to no canary.
+func lintCanary(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	if appConfig.CanaryWeight > 0 && appConfig.CanaryService == "" {
+		return []string{fmt.Sprintf("A canary weight of %d%% is set, but no canary service is, so no traffic is diverted.", appConfig.CanaryWeight)}
+	}
+	if appConfig.CanaryService != "" && appConfig.CanaryWeight == 0 {
+		return []string{fmt.Sprintf("The canary service \"%s\" is set, but its weight is 0%%, so it receives no traffic.", appConfig.CanaryService)}
+	}
+	return nil
+}
+
+// lintAffinity flags load balancing algorithms that are overridden by cookie-based affinity.
+func lintAffinity(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	if appConfig.Affinity == "cookie" && appConfig.LoadBalancingAlgorithm != "" && appConfig.LoadBalancingAlgorithm != "round_robin" {
+		return []string{fmt.Sprintf("The %s load balancing algorithm is ignored, since cookie-based affinity determines which endpoint serves each request.", appConfig.LoadBalancingAlgorithm)}
+	}
+	return nil
+}
+
+// lintExternalAuth flags external authentication settings that have no effect because no
+// authentication service is set.
+func lintExternalAuth(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	externalAuthConfig := appConfig.ExternalAuthConfig
+	if externalAuthConfig == nil || externalAuthConfig.URL != "" {
+		return nil
+	}
+	if externalAuthConfig.SigninURL != "" || len(externalAuthConfig.ResponseHeaders) > 0 || externalAuthConfig.Method != "" || externalAuthConfig.CacheTTL != "" {
+		return []string{"External authentication settings are present, but no authentication service URL is set, so requests are not authenticated."}
+	}
+	return nil
+}
+
+// lintFaultInjection flags faults that are not injected, either because the router does not permit
+// it, or because a delay cannot be combined with external authentication.
+func lintFaultInjection(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	faultInjectionConfig := appConfig.FaultInjectionConfig
+	if faultInjectionConfig == nil || (faultInjectionConfig.DelayPercent == 0 && faultInjectionConfig.AbortPercent == 0) {
+		return nil
+	}
+	if !routerConfig.FaultInjectionEnabled {
+		return []string{"Fault injection is configured, but the router does not permit it, so no faults are injected."}
+	}
+	if faultInjectionConfig.DelayPercent > 0 && appConfig.ExternalAuthConfig != nil && appConfig.ExternalAuthConfig.URL != "" {
+		return []string{"Delays cannot be injected into requests that are authenticated by an external service, so none are."}
+	}
+	return nil
+}
+
+// lintHTTP2 flags applications that set HTTP/2 differently than the router.  nginx decides whether
+// to negotiate HTTP/2 for each port on which it listens, before it knows which domain a client will
+// request, so every application served on the router's SSL port necessarily shares its setting.
+func lintHTTP2(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	if appConfig.SSLConfig == nil || appConfig.SSLConfig.HTTP2 == "" || (appConfig.SSLConfig.HTTP2 == "true") == routerConfig.HTTP2() {
+		return nil
+	}
+	if routerConfig.HTTP2() {
+		return []string{"HTTP/2 is disabled for the application, but the router enables it on the SSL port that all applications share, so clients may still use HTTP/2."}
+	}
+	return []string{"HTTP/2 is enabled for the application, but the router disables it on the SSL port that all applications share, so clients use HTTP/1.1."}
+}
+
+// lintWeightedLocations flags locations that name a backend service, which is ignored in favor of
+// the services among which the location's requests are split by weight.
+func lintWeightedLocations(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	problems := []string{}
+	for _, location := range appConfig.Locations {
+		if len(location.Weights) > 0 && location.BackendService != "" {
+			problems = append(problems, fmt.Sprintf("The location \"%s\" names both a service and weighted services, so the service \"%s\" is ignored.", location.Path, location.BackendService))
+		}
+	}
+	return problems
+}
+
+// lintGRPC flags gRPC applications that clients cannot reach, since gRPC requires HTTP/2, which the
+// router negotiates only on its SSL port, unless their gRPC-Web requests, which browsers make in any
+// version of HTTP, are translated, and settings that do not apply to applications spoken to in
+// HTTP/2, whether gRPC or h2c.
+func lintGRPC(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	if !appConfig.HTTP2Backend() {
+		return nil
+	}
+	problems := []string{}
+	speaks := "The application speaks gRPC"
+	if appConfig.BackendProtocol == "h2c" {
+		speaks = "The application speaks HTTP/2 cleartext"
+	} else {
+		if !appConfig.GRPCWeb && !routerConfig.HTTP2() {
+			problems = append(problems, "The application speaks gRPC, but the router does not negotiate HTTP/2, which gRPC clients require.")
+		} else if !appConfig.GRPCWeb && len(appConfig.Certificates) == 0 {
+			problems = append(problems, "The application speaks gRPC, but none of its domains has a certificate, and HTTP/2, which gRPC clients require, is only negotiated over SSL.")
+		}
+		if appConfig.HTTP10Compatible {
+			problems = append(problems, "The application speaks gRPC, whose clients never use HTTP/1.0, so HTTP/1.0 compatibility has no effect.")
+		}
+	}
+	if appConfig.ProxyCacheConfig != nil && appConfig.ProxyCacheConfig.Enabled {
+		problems = append(problems, speaks+", whose responses are never cached.")
+	}
+	if appConfig.HealthCheckConfig != nil && appConfig.HealthCheckConfig.Path != "" {
+		problems = append(problems, speaks+", but its endpoints are health checked with plain HTTP requests, which they are unlikely to answer successfully.")
+	}
+	return problems
+}
+
+// lintGeoIP flags GeoIP settings that have no effect because the router has no GeoIP database, and
+// countries that are both allowed and blocked, which are blocked.
+func lintGeoIP(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	geoIPConfig := appConfig.GeoIPConfig
+	if geoIPConfig == nil || (!geoIPConfig.Filtered() && !geoIPConfig.Headers) {
+		return nil
+	}
+	if routerConfig.GeoIPDatabase == "" {
+		return []string{"GeoIP settings are present, but the router has no GeoIP database, so requests are neither filtered by country nor annotated with the client's location."}
+	}
+	problems := []string{}
+	for _, allowed := range geoIPConfig.AllowCountries {
+		for _, blocked := range geoIPConfig.BlockCountries {
+			if allowed == blocked {
+				problems = append(problems, fmt.Sprintf("The country %s is both allowed and blocked, so requests from it are refused.", allowed))
+			}
+		}
+	}
+	return problems
+}
+
+// lintEarlyData flags applications that accept early data, which only TLS 1.3 clients send, from a
+// router that does not negotiate TLS 1.3.
+func lintEarlyData(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	if appConfig.EarlyDataConfig == nil || !appConfig.EarlyDataConfig.Enabled || routerConfig.SSLConfig == nil {
+		return nil
+	}
+	if strings.Contains(routerConfig.SSLConfig.Protocols, "TLSv1.3") {
+		return nil
+	}
+	return []string{"Early data is enabled, but the router does not negotiate TLS 1.3, the only protocol in which clients send it, so no requests arrive in early data."}
+}
+
+// lintUpstreamTLS flags upstream TLS settings that have no effect because the application's
+// endpoints are spoken to without TLS.
+func lintUpstreamTLS(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	upstreamTLSConfig := appConfig.UpstreamTLSConfig
+	if upstreamTLSConfig == nil || appConfig.BackendProtocol == "https" || appConfig.BackendProtocol == "grpcs" || strings.HasPrefix(appConfig.ExternalOrigin, "https://") {
+		return nil
+	}
+	if upstreamTLSConfig.Name != "" || upstreamTLSConfig.Protocols != "" || upstreamTLSConfig.Verify {
+		return []string{fmt.Sprintf("Upstream TLS settings are present, but the application's endpoints are spoken to in %s, without TLS, so they have no effect.", appConfig.BackendProtocol)}
+	}
+	return nil
+}
+
+// lintPrefixes flags path prefixes that are not rewritten, either because the application speaks
+// gRPC, whose paths name the methods called, or h2c, or because the transformations' prefixes give
+// way to different ones set natively.
+func lintPrefixes(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	native := appConfig.StripPrefix != "" || appConfig.AddPrefix != ""
+	for _, location := range appConfig.Locations {
+		native = native || location.StripPrefix != "" || location.AddPrefix != ""
+	}
+	transformConfig := appConfig.TransformConfig
+	transformed := transformConfig != nil && transformConfig.RewritesURI()
+	if !native && !transformed {
+		return nil
+	}
+	if appConfig.BackendProtocol == "grpc" || appConfig.BackendProtocol == "grpcs" {
+		return []string{"Path prefixes are set, but the application speaks gRPC, whose paths name the methods called, so they are not rewritten."}
+	}
+	if appConfig.BackendProtocol == "h2c" {
+		return []string{"Path prefixes are set, but the application speaks HTTP/2 cleartext, whose requests are proxied with their paths as they are, so they are not rewritten."}
+	}
+	if transformed && (appConfig.StripPrefix != "" || appConfig.AddPrefix != "") && (appConfig.StripPrefix != transformConfig.StripPrefix || appConfig.AddPrefix != transformConfig.AddPrefix) {
+		return []string{"Path prefixes are set both natively and as transformations, so the transformations' prefixes are ignored."}
+	}
+	return nil
+}
+
+// lintExternalOrigin flags external origins that are not proxied to because the application speaks
+// gRPC or h2c.
+func lintExternalOrigin(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	if appConfig.ExternalOrigin == "" {
+		return nil
+	}
+	if appConfig.HTTP2Backend() {
+		speaks := "gRPC"
+		if appConfig.BackendProtocol == "h2c" {
+			speaks = "HTTP/2 cleartext"
+		}
+		return []string{fmt.Sprintf("The external origin %s is set, but the application speaks %s, which is never proxied to an external origin, so requests are routed to its service.", appConfig.ExternalOrigin, speaks)}
+	}
+	return nil
+}
+
+// lintBackupOrigin flags backup origins to which requests never fail over, since nginx does not
+// permit backup servers among those to which requests are hashed.
+func lintBackupOrigin(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	if appConfig.BackupOrigin == "" {
+		return nil
+	}
+	if appConfig.Affinity == "cookie" {
+		return []string{"A backup origin is set, but requests are routed by cookie-based affinity, so they fail over to it only while the application has no endpoints at all."}
+	}
+	if appConfig.LoadBalancingAlgorithm == "ip_hash" {
+		return []string{"A backup origin is set, but requests are routed by the ip_hash algorithm, so they fail over to it only while the application has no endpoints at all."}
+	}
+	return nil
+}
+
+// lintWAF flags custom WAF rules named for an application whose requests the WAF does not inspect.
+func lintWAF(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	wafConfig := appConfig.WAFConfig
+	if wafConfig == nil || wafConfig.Enabled || wafConfig.RulesConfigMap == "" {
+		return nil
+	}
+	return []string{fmt.Sprintf("Custom WAF rules are held in the config map \"%s\", but the WAF is not enabled, so the application's requests are not inspected.", wafConfig.RulesConfigMap)}
+}
+
+// lintCDN flags applications behind a CDN whose header cannot be used to find clients' addresses,
+// since the router takes them from the PROXY protocol, and those that the router also caches, so
+// that the CDN caches the router's copies rather than the application's responses.
+func lintCDN(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	header := appConfig.CDNClientIPHeader()
+	if header == "" {
+		return nil
+	}
+	problems := []string{}
+	if routerConfig.ProxyProtocolRealIP() {
+		problems = append(problems, fmt.Sprintf("The application sits behind %s, but the router takes clients' addresses from the PROXY protocol, so the %s header is passed on as sent rather than used.", appConfig.CDN, header))
+	}
+	if appConfig.ProxyCacheConfig != nil && appConfig.ProxyCacheConfig.Enabled {
+		problems = append(problems, fmt.Sprintf("The application sits behind %s, but its responses are also cached by the router, so the CDN may cache stale copies of them.", appConfig.CDN))
+	}
+	return problems
+}
+
+// lintUpstreamKeepalive flags applications whose endpoints' connections are to be kept alive, but
+// which are spoken to over HTTP/1.0, which closes every connection after its response.
+func lintUpstreamKeepalive(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	if !appConfig.UpstreamKeepaliveConfig.Enabled() || !appConfig.HTTP10Compatible {
+		return nil
+	}
+	return []string{"Connections to the application's endpoints are to be kept alive, but HTTP/1.0 compatibility makes requests of it over HTTP/1.0, so each connection is closed after its response."}
+}
+
+// lintRequestBuffering flags applications whose requests' bodies are to be streamed, but which are
+// spoken to over HTTP/1.0, so that nginx still buffers bodies that clients send in chunks.
+func lintRequestBuffering(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	if appConfig.RequestBuffering || !appConfig.HTTP10Compatible {
+		return nil
+	}
+	return []string{"Request bodies are to be streamed to the application, but HTTP/1.0 compatibility makes requests of it over HTTP/1.0, so bodies sent in chunks are still buffered."}
+}
+
+// lintCacheControl flags applications and locations that set both a Cache-Control header and an
+// expiry, which is ignored in favor of the header.
+func lintCacheControl(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	problems := []string{}
+	if cacheControlConfig := appConfig.CacheControlConfig; cacheControlConfig != nil && cacheControlConfig.Value != "" && cacheControlConfig.Expires != "" {
+		problems = append(problems, "The application sets both a Cache-Control header and an expiry, so the expiry is ignored.")
+	}
+	for _, location := range appConfig.Locations {
+		if location.CacheControl != "" && location.Expires != "" && (appConfig.CacheControlConfig == nil || location.CacheControl != appConfig.CacheControlConfig.Value || location.Expires != appConfig.CacheControlConfig.Expires) {
+			problems = append(problems, fmt.Sprintf("The location \"%s\" sets both a Cache-Control header and an expiry, so the expiry is ignored.", location.Path))
+		}
+	}
+	return problems
+}
+
+// lintRanges flags applications whose range requests are answered from complete responses even
+// though range requests are disabled.
+func lintRanges(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	rangesConfig := appConfig.RangesConfig
+	if rangesConfig == nil || !rangesConfig.Force || rangesConfig.Max != "0" {
+		return nil
+	}
+	return []string{"The application's range requests are answered from complete responses, but a maximum of 0 ranges disables range requests, so no such request is answered."}
+}
+
+// lintGRPCWeb flags gRPC-Web translation for applications that do not speak gRPC, whose requests
+// are never translated.
+func lintGRPCWeb(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	if !appConfig.GRPCWeb || appConfig.BackendProtocol == "grpc" || appConfig.BackendProtocol == "grpcs" {
+		return nil
+	}
+	return []string{fmt.Sprintf("gRPC-Web translation is enabled, but the application speaks %s rather than gRPC, so gRPC-Web requests are passed on to it untranslated.", appConfig.BackendProtocol)}
+}
+
+// lintBodySizeResponse flags CORS headers requested on 413 responses for applications that do not
+// enable CORS, and so have no headers to add.
+func lintBodySizeResponse(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	if appConfig.BodySizeResponseConfig == nil || !appConfig.BodySizeResponseConfig.CORS || (appConfig.CORSConfig != nil && appConfig.CORSConfig.Enabled) {
+		return nil
+	}
+	return []string{"CORS headers are requested on responses to requests whose bodies are too large, but CORS is not enabled, so none are sent."}
+}
+
+// lintCORS flags CORS policies that allow credentials from any origin, which lets any site's pages
+// make requests to the application as a signed-in user, and read the responses.
+func lintCORS(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	corsConfig := appConfig.CORSConfig
+	if corsConfig == nil || !corsConfig.Enabled || !corsConfig.AllowCredentials || !corsConfig.AllowsAnyOrigin() {
+		return nil
+	}
+	return []string{"CORS allows credentials from any origin, so pages from any site can make requests with users' cookies and read the responses."}
+}
+
+// lintRedirects flags redirects to the application's own domains, which clients follow endlessly, and
+// to www. subdomains, or from them, that the application does not serve, as well as exemptions from
+// HTTPS enforcement where none is enforced.
+func lintRedirects(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	redirectConfig := appConfig.RedirectConfig
+	if redirectConfig == nil {
+		return nil
+	}
+	domains := make(map[string]bool, len(appConfig.Domains))
+	for _, domain := range appConfig.Domains {
+		domains[domain] = true
+	}
+	problems := []string{}
+	if redirectConfig.To != "" {
+		to := redirectConfig.To
+		if i := strings.Index(to, "://"); i >= 0 {
+			to = to[i+3:]
+		}
+		if i := strings.Index(to, ":"); i >= 0 {
+			to = to[:i]
+		}
+		if domains[strings.ToLower(to)] {
+			problems = append(problems, fmt.Sprintf("Every request is redirected to %s, which is one of the application's own domains, so clients are redirected endlessly.", redirectConfig.To))
+		} else if redirectConfig.WWW != "" {
+			problems = append(problems, "Every request is redirected to another domain, so www. subdomains are neither forced nor stripped.")
+		}
+	} else if redirectConfig.WWW != "" {
+		unserved := []string{}
+		for _, domain := range appConfig.Domains {
+			if !strings.Contains(domain, ".") || strings.HasPrefix(domain, "*") {
+				continue
+			}
+			if redirectConfig.WWW == "force" && !strings.HasPrefix(domain, "www.") && !domains["www."+domain] {
+				unserved = append(unserved, "www."+domain)
+			} else if redirectConfig.WWW == "strip" && strings.HasPrefix(domain, "www.") && !domains[strings.TrimPrefix(domain, "www.")] {
+				unserved = append(unserved, strings.TrimPrefix(domain, "www."))
+			}
+		}
+		if len(unserved) > 0 {
+			problems = append(problems, fmt.Sprintf("Requests are redirected to %s, which the application does not serve.", strings.Join(unserved, ", ")))
+		}
+	}
+	if len(redirectConfig.SSLExemptPaths) > 0 {
+		enforced := false
+		for _, sslConfig := range []*SSLConfig{routerConfig.SSLConfig, appConfig.SSLConfig} {
+			enforced = enforced || (sslConfig != nil && (sslConfig.Enforce == "true" || sslConfig.Enforce == "external"))
+		}
+		if !enforced {
+			problems = append(problems, "Paths are exempt from HTTPS enforcement, but neither the application nor the router enforces HTTPS, so the exemptions have no effect.")
+		}
+	}
+	return problems
+}
+
+// lintSnippets flags applications' snippets that are ignored because the router does not permit
+// them.
+func lintSnippets(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	if routerConfig.SnippetsEnabled || (appConfig.ServerSnippet == "" && appConfig.LocationSnippet == "") {
+		return nil
+	}
+	return []string{"Configuration snippets are set, but the router does not permit them, so they are ignored."}
+}
+
+// lintInterceptedSignin flags applications whose own 401 responses are redirected to sign in, since
+// nginx cannot tell them from those of external authentication once the application's errors are
+// intercepted for its error pages.
+func lintInterceptedSignin(routerConfig *RouterConfig, appConfig *AppConfig) []string {
+	externalAuthConfig := appConfig.ExternalAuthConfig
+	if externalAuthConfig == nil || externalAuthConfig.URL == "" || externalAuthConfig.SigninURL == "" {
+		return nil
+	}
+	if len(appConfig.ErrorPages) > 0 && !appConfig.HTTP2Backend() {
+		return []string{"Custom error pages are set, so the application's own 401 responses are also redirected to the sign-in URL."}
+	}
+	return nil
+}
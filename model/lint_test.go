@@ -0,0 +1,157 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func newLintTestAppConfig(routerConfig *RouterConfig) *AppConfig {
+	appConfig := newAppConfig(routerConfig)
+	appConfig.Name = "foo/bar"
+	appConfig.Namespace = "foo"
+	appConfig.ResourceKind = "Service"
+	appConfig.ResourceName = "bar"
+	appConfig.Domains = []string{"bar.example.com"}
+	return appConfig
+}
+
+func TestLint(t *testing.T) {
+	routerConfig := newRouterConfig()
+	routerConfig.ProxyRealIPCIDRs = nil
+
+	sslApp := newLintTestAppConfig(routerConfig)
+	sslApp.SSLConfig.Enforce = "true"
+	whitelistApp := newLintTestAppConfig(routerConfig)
+	whitelistApp.Whitelist = []string{"1.2.3.4"}
+	canaryApp := newLintTestAppConfig(routerConfig)
+	canaryApp.CanaryWeight = 10
+	affinityApp := newLintTestAppConfig(routerConfig)
+	affinityApp.Affinity = "cookie"
+	affinityApp.LoadBalancingAlgorithm = "least_conn"
+	externalAuthApp := newLintTestAppConfig(routerConfig)
+	externalAuthApp.ExternalAuthConfig.SigninURL = "https://auth.example.com/start"
+	faultInjectionApp := newLintTestAppConfig(routerConfig)
+	faultInjectionApp.FaultInjectionConfig.AbortPercent = 5
+	http2App := newLintTestAppConfig(routerConfig)
+	http2App.SSLConfig.HTTP2 = "false"
+	weightedApp := newLintTestAppConfig(routerConfig)
+	weightedApp.Locations = []*LocationConfig{&LocationConfig{Path: "/shop", BackendService: "shop", Weights: map[string]string{"shop-v2": "10"}}}
+	grpcApp := newLintTestAppConfig(routerConfig)
+	grpcApp.BackendProtocol = "grpc"
+	grpcApp.HTTP10Compatible = true
+	grpcApp.HealthCheckConfig.Path = "/healthz"
+	geoIPApp := newLintTestAppConfig(routerConfig)
+	geoIPApp.GeoIPConfig.Headers = true
+	earlyDataApp := newLintTestAppConfig(routerConfig)
+	earlyDataApp.EarlyDataConfig.Enabled = true
+	upstreamTLSApp := newLintTestAppConfig(routerConfig)
+	upstreamTLSApp.UpstreamTLSConfig.Verify = true
+	prefixApp := newLintTestAppConfig(routerConfig)
+	prefixApp.StripPrefix = "/api"
+	prefixApp.TransformConfig.AddPrefix = "/v2"
+	redirectApp := newLintTestAppConfig(routerConfig)
+	redirectApp.Domains = []string{"bar.example.com", "bar"}
+	redirectApp.RedirectConfig.WWW = "force"
+	redirectApp.RedirectConfig.SSLExemptPaths = []string{"/healthz"}
+	loopApp := newLintTestAppConfig(routerConfig)
+	loopApp.RedirectConfig.To = "https://BAR.example.com"
+	corsApp := newLintTestAppConfig(routerConfig)
+	corsApp.CORSConfig.Enabled = true
+	corsApp.CORSConfig.AllowCredentials = true
+	backupApp := newLintTestAppConfig(routerConfig)
+	backupApp.BackupOrigin = "backup.example.net:80"
+	backupApp.LoadBalancingAlgorithm = "ip_hash"
+	wafApp := newLintTestAppConfig(routerConfig)
+	wafApp.WAFConfig.RulesConfigMap = "bar-waf-rules"
+	cdnApp := newLintTestAppConfig(routerConfig)
+	cdnApp.CDN = "fastly"
+	cdnApp.ProxyCacheConfig.Enabled = true
+	keepaliveApp := newLintTestAppConfig(routerConfig)
+	keepaliveApp.UpstreamKeepaliveConfig.Connections = "16"
+	keepaliveApp.HTTP10Compatible = true
+	keepaliveApp.RequestBuffering = false
+	cacheControlApp := newLintTestAppConfig(routerConfig)
+	cacheControlApp.CacheControlConfig.Value = "public, max-age=60"
+	cacheControlApp.CacheControlConfig.Expires = "1h"
+	rangesApp := newLintTestAppConfig(routerConfig)
+	rangesApp.RangesConfig = &RangesConfig{Force: true, Max: "0"}
+	h2cApp := newLintTestAppConfig(routerConfig)
+	h2cApp.BackendProtocol = "h2c"
+	h2cApp.HealthCheckConfig.Path = "/healthz"
+	grpcWebApp := newLintTestAppConfig(routerConfig)
+	grpcWebApp.GRPCWeb = true
+	bodySizeResponseApp := newLintTestAppConfig(routerConfig)
+	bodySizeResponseApp.BodySizeResponseConfig.CORS = true
+	snippetApp := newLintTestAppConfig(routerConfig)
+	snippetApp.ServerSnippet = "add_header X-Foo bar;"
+	signinApp := newLintTestAppConfig(routerConfig)
+	signinApp.ExternalAuthConfig.URL = "https://auth.example.com/verify"
+	signinApp.ExternalAuthConfig.SigninURL = "https://auth.example.com/signin"
+	signinApp.ErrorPages = map[string]string{"502": "<html></html>"}
+	routerConfig.GeoIPDatabase = ""
+	routerConfig.AppConfigs = []*AppConfig{sslApp, whitelistApp, canaryApp, affinityApp, externalAuthApp, faultInjectionApp, http2App, weightedApp, grpcApp, geoIPApp, earlyDataApp, upstreamTLSApp, prefixApp, redirectApp, loopApp, corsApp, backupApp, wafApp, cdnApp, keepaliveApp, cacheControlApp, rangesApp, h2cApp, grpcWebApp, bodySizeResponseApp, snippetApp, signinApp}
+
+	lint(routerConfig)
+	expected := []string{"no certificate for bar.example.com", "whitelisted", "no canary service", "least_conn", "no authentication service URL", "does not permit", "HTTP/2 is disabled", "service \"shop\" is ignored", "none of its domains has a certificate", "HTTP/1.0 compatibility has no effect", "plain HTTP requests", "no GeoIP database", "does not negotiate TLS 1.3", "without TLS", "transformations' prefixes are ignored", "redirected to www.bar.example.com", "exemptions have no effect", "redirected endlessly", "credentials from any origin", "ip_hash algorithm", "WAF is not enabled", "stale copies", "closed after its response", "still buffered", "expiry is ignored", "disables range requests", "HTTP/2 cleartext, but its endpoints are health checked", "gRPC-Web requests are passed on to it untranslated", "CORS is not enabled, so none are sent", "does not permit them, so they are ignored", "also redirected to the sign-in URL"}
+	if len(routerConfig.Warnings) != len(expected) {
+		t.Fatalf("Expected %d warnings, but got %d: %v", len(expected), len(routerConfig.Warnings), routerConfig.Warnings)
+	}
+	for i, warning := range routerConfig.Warnings {
+		if warning.Kind != "Service" || warning.Namespace != "foo" || warning.Name != "bar" || warning.Reason != "ConflictingConfiguration" {
+			t.Errorf("Expected a ConflictingConfiguration warning for service foo/bar, but got %+v", warning)
+		}
+		if !strings.Contains(warning.Message, expected[i]) {
+			t.Errorf("Expected warning %d to mention \"%s\", but got \"%s\"", i, expected[i], warning.Message)
+		}
+	}
+}
+
+func TestLintClean(t *testing.T) {
+	routerConfig := newRouterConfig()
+	appConfig := newLintTestAppConfig(routerConfig)
+	appConfig.SSLConfig.Enforce = "true"
+	appConfig.Certificates["bar.example.com"] = &Certificate{}
+	appConfig.Whitelist = []string{"1.2.3.4"}
+	appConfig.CanaryService = "bar-canary"
+	appConfig.CanaryWeight = 10
+	appConfig.FaultInjectionConfig.DelayPercent = 50
+	appConfig.SSLConfig.HTTP2 = "true"
+	appConfig.StripPrefix = "/api"
+	appConfig.TransformConfig.StripPrefix = "/api"
+	appConfig.Locations = []*LocationConfig{&LocationConfig{Path: "/shop", AddPrefix: "/store"}}
+	grpcApp := newLintTestAppConfig(routerConfig)
+	grpcApp.BackendProtocol = "grpcs"
+	grpcApp.Certificates["bar.example.com"] = &Certificate{}
+	grpcApp.GeoIPConfig.AllowCountries = []string{"US", "CA"}
+	grpcApp.GeoIPConfig.BlockCountries = []string{"CN"}
+	grpcApp.EarlyDataConfig.Enabled = true
+	grpcApp.UpstreamTLSConfig.Name = "grpc.example.com"
+	grpcApp.UpstreamTLSConfig.Verify = true
+	externalOriginApp := newLintTestAppConfig(routerConfig)
+	externalOriginApp.ExternalOrigin = "https://legacy.example.net"
+	externalOriginApp.UpstreamTLSConfig.Verify = true
+	externalOriginApp.AddPrefix = "/v2"
+	redirectApp := newLintTestAppConfig(routerConfig)
+	redirectApp.Domains = []string{"www.bar.example.com", "bar.example.com"}
+	redirectApp.SSLConfig.Enforce = "external"
+	redirectApp.RedirectConfig.WWW = "strip"
+	redirectApp.RedirectConfig.SSLExemptPaths = []string{"/healthz"}
+	movedApp := newLintTestAppConfig(routerConfig)
+	movedApp.RedirectConfig.To = "bar.example.net"
+	corsApp := newLintTestAppConfig(routerConfig)
+	corsApp.CORSConfig.Enabled = true
+	corsApp.CORSConfig.AllowOrigins = []string{"https://app.example.com"}
+	corsApp.CORSConfig.AllowCredentials = true
+	corsApp.BackupOrigin = "backup.example.net:80"
+	corsApp.LoadBalancingAlgorithm = "least_conn"
+	corsApp.WAFConfig.Enabled = true
+	corsApp.WAFConfig.RulesConfigMap = "bar-waf-rules"
+	routerConfig.SSLConfig.Protocols = "TLSv1.2 TLSv1.3"
+	routerConfig.FaultInjectionEnabled = true
+	routerConfig.AppConfigs = []*AppConfig{appConfig, grpcApp, externalOriginApp, redirectApp, movedApp, corsApp}
+
+	lint(routerConfig)
+	if len(routerConfig.Warnings) != 0 {
+		t.Errorf("Expected no warnings, but got %v", routerConfig.Warnings)
+	}
+}
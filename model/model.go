@@ -2,10 +2,19 @@ package model
 
 import (
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
+	"github.com/deis/router/metrics"
 	"github.com/deis/router/utils"
 	modelerUtility "github.com/deis/router/utils/modeler"
 	"k8s.io/client-go/1.4/kubernetes"
@@ -16,18 +25,24 @@ import (
 	v1beta1ext "k8s.io/client-go/1.4/pkg/apis/extensions/v1beta1"
 	"k8s.io/client-go/1.4/pkg/fields"
 	"k8s.io/client-go/1.4/pkg/labels"
+	"k8s.io/client-go/1.4/pkg/util/intstr"
 )
 
 const (
-	prefix               string = "router.deis.io"
-	modelerFieldTag      string = "key"
-	modelerConstraintTag string = "constraint"
+	prefix                 string = "router.deis.io"
+	modelerFieldTag        string = "key"
+	modelerConstraintTag   string = "constraint"
+	ingressClassAnnotation string = "kubernetes.io/ingress.class"
+	routingClassAnnotation string = prefix + "/routable.class"
 )
 
 var (
-	namespace   = utils.GetOpt("POD_NAMESPACE", "default")
-	modeler     = modelerUtility.NewModeler(prefix, modelerFieldTag, modelerConstraintTag, true)
-	listOptions api.ListOptions
+	namespace       = utils.GetOpt("POD_NAMESPACE", "default")
+	modeler         = modelerUtility.NewModeler(prefix, modelerFieldTag, modelerConstraintTag, true)
+	locationModeler = modelerUtility.NewModeler("", modelerFieldTag, modelerConstraintTag, true)
+	listOptions     api.ListOptions
+	// errorStatusRegexp matches the statuses for which custom error pages may be served.
+	errorStatusRegexp = regexp.MustCompile("^[45][0-9]{2}$")
 )
 
 func init() {
@@ -39,26 +54,124 @@ func init() {
 type RouterConfig struct {
 	WorkerProcesses          string      `key:"workerProcesses" constraint:"^(auto|[1-9]\\d*)$"`
 	MaxWorkerConnections     string      `key:"maxWorkerConnections" constraint:"^[1-9]\\d*$"`
-	TrafficStatusZoneSize    string      `key:"trafficStatusZoneSize" constraint:"^[1-9]\\d*[kKmM]?$"`
-	DefaultTimeout           string      `key:"defaultTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$"`
-	ServerNameHashMaxSize    string      `key:"serverNameHashMaxSize" constraint:"^[1-9]\\d*[kKmM]?$"`
-	ServerNameHashBucketSize string      `key:"serverNameHashBucketSize" constraint:"^[1-9]\\d*[kKmM]?$"`
+	TrafficStatusZoneSize    string      `key:"trafficStatusZoneSize" type:"size" min:"1"`
+	DefaultTimeout           string      `key:"defaultTimeout" type:"duration" min:"1ms"`
+	ServerNameHashMaxSize    string      `key:"serverNameHashMaxSize" type:"size" min:"1"`
+	ServerNameHashBucketSize string      `key:"serverNameHashBucketSize" type:"size" min:"1"`
 	GzipConfig               *GzipConfig `key:"gzip"`
-	BodySize                 string      `key:"bodySize" constraint:"^[0-9]\\d*[kKmM]?$"`
-	ProxyRealIPCIDRs         []string    `key:"proxyRealIpCidrs" constraint:"^((([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?(\\s*,\\s*)?)+$"`
-	ErrorLogLevel            string      `key:"errorLogLevel" constraint:"^(debug|info|notice|warn|error|crit|alert|emerg)$"`
+	BodySize                 string      `key:"bodySize" type:"size"`
+	ProxyRealIPCIDRs         []string    `key:"proxyRealIpCidrs" constraint:"^(((([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?|[0-9a-fA-F]*:[0-9a-fA-F:.]*(\\/([0-9]|[1-9][0-9]|1[01][0-9]|12[0-8]))?)(\\s*,\\s*)?)+$"`
+	ErrorLogLevel            string      `key:"errorLogLevel" enum:"debug|info|notice|warn|error|crit|alert|emerg"`
 	PlatformDomain           string      `key:"platformDomain" constraint:"(?i)^([a-z0-9]+(-[a-z0-9]+)*\\.)+[a-z0-9]+(-*[a-z0-9]+)+$"`
 	UseProxyProtocol         bool        `key:"useProxyProtocol" constraint:"(?i)^(true|false)$"`
 	EnforceWhitelists        bool        `key:"enforceWhitelists" constraint:"(?i)^(true|false)$"`
 	DefaultWhitelist         []string    `key:"defaultWhitelist" constraint:"^((([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?(\\s*,\\s*)?)+$"`
-	WhitelistMode            string      `key:"whitelistMode" constraint:"^(extend|override)$"`
+	WhitelistMode            string      `key:"whitelistMode" enum:"extend|override"`
 	RequestIDs               bool        `key:"requestIDs" constraint:"(?i)^(true|false)$"`
 	SSLConfig                *SSLConfig  `key:"ssl"`
+	ACMEConfig               *ACMEConfig `key:"acme"`
+	EmergencyConfig          *EmergencyConfig
 	AppConfigs               []*AppConfig
 	BuilderConfig            *BuilderConfig
+	StreamConfigs            []*StreamConfig
 	PlatformCertificate      *Certificate
 	HTTP2Enabled             bool     `key:"http2Enabled" constraint:"(?i)^(true|false)$"`
 	ClientCertificates       []string `key:"clientCertificates" constraint:"^[0-9a-zA-Z+\\/]+={0,2}(,[0-9a-zA-Z+\\/]+={0,2})*$"`
+	IngressClass             string   `key:"ingressClass" constraint:"^[a-z0-9]([-a-z0-9]*[a-z0-9])?$"`
+	// NamespaceSelector and Namespaces restrict the namespaces whose services and ingresses may
+	// publish routes through the router to those whose labels match the selector and those named,
+	// respectively.  When both are set, a namespace must satisfy both.
+	NamespaceSelector string   `key:"namespaceSelector" constraint:"^[-a-zA-Z0-9_./=!,() ]+$"`
+	Namespaces        []string `key:"namespaces" constraint:"^[a-z0-9]([-a-z0-9]*[a-z0-9])?(,[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$"`
+	// RoutingClass shards routable services and ingresses among router deployments.  The router only
+	// routes those whose routable.class annotation matches its class, or, without a class, those
+	// without the annotation.
+	RoutingClass string `key:"routingClass" constraint:"^[a-z0-9]([-a-z0-9]*[a-z0-9])?$"`
+	// ClientBodyTempPath and ProxyTempPath are the directories in which nginx buffers large request
+	// and response bodies, respectively.  When unset, nginx's defaults are used.
+	ClientBodyTempPath   string `key:"clientBodyTempPath" constraint:"^/[^\\s;{}'\"]*$"`
+	ProxyTempPath        string `key:"proxyTempPath" constraint:"^/[^\\s;{}'\"]*$"`
+	ProxyMaxTempFileSize string `key:"proxyMaxTempFileSize" type:"offset"`
+	// OpenFileCacheConfig tunes the caching of descriptors and metadata of files served directly by
+	// nginx, such as error pages.
+	OpenFileCacheConfig *OpenFileCacheConfig `key:"openFileCache"`
+	// HTTPSnippet is injected verbatim into nginx's http block.
+	HTTPSnippet string `key:"httpSnippet"`
+	// Sendfile, TCPNopush, TCPNodelay, AIO, and Directio tune how nginx moves data between files,
+	// sockets, and disk.
+	Sendfile   bool   `key:"sendfile" constraint:"(?i)^(true|false)$"`
+	TCPNopush  bool   `key:"tcpNopush" constraint:"(?i)^(true|false)$"`
+	TCPNodelay bool   `key:"tcpNodelay" constraint:"(?i)^(true|false)$"`
+	AIO        string `key:"aio" enum:"on|off|threads"`
+	Directio   string `key:"directio" constraint:"^(off|[1-9]\\d*[kKmMgG]?)$"`
+	// Backlog limits the length of the queue of pending connections on the router's HTTP and HTTPS
+	// ports.  When unset, nginx's default is used.  Either way, the kernel caps it at somaxconn.
+	Backlog     string `key:"backlog" constraint:"^[1-9]\\d*$"`
+	MultiAccept bool   `key:"multiAccept" constraint:"(?i)^(true|false)$"`
+	AcceptMutex bool   `key:"acceptMutex" constraint:"(?i)^(true|false)$"`
+	// FaultInjectionEnabled permits applications to have faults injected into their requests.  It is
+	// meant to be enabled only on routers in staging clusters.
+	FaultInjectionEnabled bool `key:"faultInjectionEnabled" constraint:"(?i)^(true|false)$"`
+	// SnippetsEnabled permits applications' server and location snippets, which are otherwise
+	// ignored.  Snippets are raw nginx configuration, with which an application's owner could read
+	// other applications' keys or proxy requests anywhere, so only clusters whose every application
+	// owner is trusted with the router should enable them.
+	SnippetsEnabled bool `key:"snippetsEnabled" constraint:"(?i)^(true|false)$"`
+	// DefaultBackendService names a service, as "<namespace>/<name>", to which requests for domains
+	// that no application claims are proxied rather than answered with a 404.  DefaultBackend is
+	// that service, once resolved.
+	DefaultBackendService string `key:"defaultBackendService" constraint:"^[a-z0-9]([-a-z0-9]*[a-z0-9])?/[a-z0-9]([-a-z0-9]*[a-z0-9])?$"`
+	DefaultBackendPort    string `key:"defaultBackendPort" constraint:"^([1-9]\\d*|[a-z]([-a-z0-9]*[a-z0-9])?)$"`
+	DefaultBackend        *DefaultBackend
+	// LogConfig determines the format of nginx's access log.
+	LogConfig *LogConfig `key:"log"`
+	// PropagateRequestIDs identifies requests by the X-Request-Id header they arrive with, if it is
+	// valid, rather than by an ID of the router's own.
+	PropagateRequestIDs bool `key:"propagateRequestIDs" constraint:"(?i)^(true|false)$"`
+	// ProxyProtocolConfig overrides UseProxyProtocol for each kind of listener.
+	ProxyProtocolConfig *ProxyProtocolConfig `key:"proxyProtocol"`
+	// RealIPHeader names the header, or "proxy_protocol", from which the address of a client is taken
+	// when a request arrives from one of ProxyRealIPCIDRs.  By default, the address is taken from the
+	// PROXY protocol if any http listener uses it, and from X-Forwarded-For otherwise.
+	RealIPHeader string `key:"realIpHeader" constraint:"^(proxy_protocol|[A-Za-z0-9-]+)$"`
+	// LatencyHistograms logs the timing of each request to the router, which exports histograms of
+	// each application's latencies as metrics.
+	LatencyHistograms bool `key:"latencyHistograms" constraint:"(?i)^(true|false)$"`
+	// TLSMetrics logs failed TLS handshakes, and requests for server names that no application
+	// claims, to the router, which exports counts of them as metrics.
+	TLSMetrics bool `key:"tlsMetrics" constraint:"(?i)^(true|false)$"`
+	// TracingConfig reports spans for a sample of requests to a Zipkin-compatible collector.
+	TracingConfig *TracingConfig `key:"tracing"`
+	// GeoIPDatabase is the path of a MaxMind GeoIP2 or GeoLite2 database by which clients' countries
+	// and regions are looked up.  It is cleared while building the model if no database is mounted
+	// there, in which case applications' GeoIP settings have no effect.
+	GeoIPDatabase string `key:"geoipDatabase" constraint:"^/[^\\s;{}'\"]+\\.mmdb$"`
+	// ListenerConfigs are SSL ports on which the router listens in addition to its own, each with a
+	// TLS policy of its own.  They are parsed from the structured listeners annotation.
+	ListenerConfigs []*ListenerConfig
+	// IPRangeSources are published lists of IP ranges that applications may whitelist by name.  They
+	// are parsed from the structured IP range sources annotation.
+	IPRangeSources []*IPRangeSource
+	// CertExpiryConfig determines when the router warns of certificates that are about to expire.
+	CertExpiryConfig *CertExpiryConfig `key:"certExpiry"`
+	// ProxyBind is the local address from which connections to applications' endpoints, and to the
+	// builder, are made, for nodes with several addresses whose backends admit only some.
+	ProxyBind string `key:"proxyBind" constraint:"^((([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])|[0-9a-fA-F]*:[0-9a-fA-F:.]*)$"`
+	// StagedRolloutConfig determines whether new configuration is first tried on a fraction of
+	// connections before it is applied to all of them.
+	StagedRolloutConfig *StagedRolloutConfig `key:"stagedRollout"`
+	// ProbeConfig determines whether, and how often, the router requests each application through
+	// its own nginx to verify that the application is reachable as routed.
+	ProbeConfig *ProbeConfig `key:"probes"`
+	// WAFConfig determines whether applications' requests are inspected by ModSecurity, unless an
+	// application's own annotations say otherwise.
+	WAFConfig *WAFConfig `key:"waf"`
+	// UpstreamKeepaliveConfig determines how many idle connections to each application's endpoints
+	// are kept open for reuse, unless an application's own annotations say otherwise.
+	UpstreamKeepaliveConfig *UpstreamKeepaliveConfig `key:"upstreamKeepalive"`
+	// Warnings are the problems found with the configuration of individual resources while building
+	// the model.
+	Warnings []Warning
 }
 
 func newRouterConfig() *RouterConfig {
@@ -80,6 +193,115 @@ func newRouterConfig() *RouterConfig {
 		SSLConfig:                newSSLConfig(),
 		HTTP2Enabled:             true,
 		ClientCertificates:       make([]string, 0),
+		ACMEConfig:               newACMEConfig(),
+		EmergencyConfig:          newEmergencyConfig(),
+		OpenFileCacheConfig:      newOpenFileCacheConfig(),
+		ProxyProtocolConfig:      newProxyProtocolConfig(),
+		Sendfile:                 true,
+		TCPNopush:                true,
+		TCPNodelay:               true,
+		AIO:                      "off",
+		Directio:                 "off",
+		DefaultBackendPort:       "80",
+		LogConfig:                newLogConfig(),
+		TracingConfig:            newTracingConfig(),
+		GeoIPDatabase:            "/opt/router/geoip/GeoLite2-City.mmdb",
+		CertExpiryConfig:         newCertExpiryConfig(),
+		StagedRolloutConfig:      newStagedRolloutConfig(),
+		ProbeConfig:              newProbeConfig(),
+		WAFConfig:                newWAFConfig(),
+		UpstreamKeepaliveConfig:  newUpstreamKeepaliveConfig(),
+	}
+}
+
+// DefaultBackend is a service to which requests for domains that no application claims are proxied.
+type DefaultBackend struct {
+	ServiceIP   string
+	ServicePort int
+	Available   bool
+	Endpoints   []string
+}
+
+// EmergencyConfig encapsulates a protective posture that can be applied to all applications at once
+// during an incident.  Unlike other router options, these are not nginx-specific and so are not
+// namespaced as such.
+type EmergencyConfig struct {
+	Mode      string   `key:"emergencyMode" enum:"off|static-503|allowlist-only"`
+	Allowlist []string `key:"emergencyAllowlist" constraint:"^((([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?(\\s*,\\s*)?)+$"`
+}
+
+func newEmergencyConfig() *EmergencyConfig {
+	return &EmergencyConfig{
+		Mode: "off",
+	}
+}
+
+// CertExpiryConfig encapsulates options for warning of the certificates the router serves that
+// expire within WarningDays days.  Zero days disables the warnings.  Warnings are always logged, and
+// if Events is set, they are also posted as events on the services, ingresses, or secrets that
+// requested the certificates.
+type CertExpiryConfig struct {
+	WarningDays int  `key:"warningDays" constraint:"^(0|[1-9]\\d*)$"`
+	Events      bool `key:"events" constraint:"(?i)^(true|false)$"`
+}
+
+func newCertExpiryConfig() *CertExpiryConfig {
+	return &CertExpiryConfig{
+		WarningDays: 30,
+	}
+}
+
+// StagedRolloutConfig encapsulates options for the experimental staged rollout of new
+// configuration.  When enabled, new configuration is served for Period by a second nginx instance
+// with Workers worker processes, which shares the router's HTTP and HTTPS ports with the instance
+// serving the configuration in effect, so that the kernel hands it a proportionate share of new
+// connections.  The new configuration is applied to all connections only if the share of its
+// responses that are server errors exceeds that of the configuration in effect by no more than
+// MaxErrorPercent percentage points.
+type StagedRolloutConfig struct {
+	Enabled         bool   `key:"enabled" constraint:"(?i)^(true|false)$"`
+	Workers         int    `key:"workers" constraint:"^[1-9]\\d*$"`
+	Period          string `key:"period" type:"duration" min:"1s"`
+	MaxErrorPercent int    `key:"maxErrorPercent" constraint:"^([0-9]|[1-9][0-9]|100)$"`
+}
+
+func newStagedRolloutConfig() *StagedRolloutConfig {
+	return &StagedRolloutConfig{
+		Workers:         1,
+		Period:          "60s",
+		MaxErrorPercent: 1,
+	}
+}
+
+// ProbeConfig encapsulates options for synthetic probes.  When enabled, the router requests each
+// application's health check path, or "/" if it has none, through its own nginx every Interval,
+// allowing each request Timeout to complete.
+type ProbeConfig struct {
+	Enabled  bool   `key:"enabled" constraint:"(?i)^(true|false)$"`
+	Interval string `key:"interval" type:"duration" min:"5s" max:"1h"`
+	Timeout  string `key:"timeout" type:"duration" min:"100ms" max:"1m"`
+}
+
+func newProbeConfig() *ProbeConfig {
+	return &ProbeConfig{
+		Interval: "30s",
+		Timeout:  "5s",
+	}
+}
+
+// ACMEConfig encapsulates options for automatically obtaining and renewing certificates from an
+// ACME certificate authority, such as Let's Encrypt.
+type ACMEConfig struct {
+	Enabled         bool   `key:"enabled" constraint:"(?i)^(true|false)$"`
+	Email           string `key:"email" constraint:"^[^@\\s,]+@[^@\\s,]+$"`
+	DirectoryURL    string `key:"directoryURL" constraint:"^https://\\S+$"`
+	RenewBeforeDays int    `key:"renewBeforeDays" constraint:"^[1-9]\\d*$"`
+}
+
+func newACMEConfig() *ACMEConfig {
+	return &ACMEConfig{
+		DirectoryURL:    "https://acme-v02.api.letsencrypt.org/directory",
+		RenewBeforeDays: 30,
 	}
 }
 
@@ -88,11 +310,11 @@ type GzipConfig struct {
 	Enabled     bool   `key:"enabled" constraint:"(?i)^(true|false)$"`
 	CompLevel   string `key:"compLevel" constraint:"^[1-9]$"`
 	Disable     string `key:"disable"`
-	HTTPVersion string `key:"httpVersion" constraint:"^(1\\.0|1\\.1)$"`
+	HTTPVersion string `key:"httpVersion" enum:"1.0|1.1"`
 	MinLength   string `key:"minLength" constraint:"^\\d+$"`
 	Proxied     string `key:"proxied" constraint:"^((off|expired|no-cache|no-store|private|no_last_modified|no_etag|auth|any)\\s*)+$"`
 	Types       string `key:"types" constraint:"(?i)^([a-z\\d]+/[a-z\\d][a-z\\d+\\-\\.]*[a-z\\d]\\s*)+$"`
-	Vary        string `key:"vary" constraint:"^(on|off)$"`
+	Vary        string `key:"vary" enum:"on|off"`
 }
 
 func newGzipConfig() *GzipConfig {
@@ -108,34 +330,848 @@ func newGzipConfig() *GzipConfig {
 	}
 }
 
+// LogConfig encapsulates access log configuration.  In the "json" format, each request is logged as
+// a JSON object whose members are the named fields.  If a syslog server is set, access and error logs
+// are also sent to it, with the given facility.
+type LogConfig struct {
+	Format         string   `key:"format" enum:"text|json"`
+	Syslog         string   `key:"syslog" constraint:"^(udp://([A-Za-z0-9]([-A-Za-z0-9]*[A-Za-z0-9])?(\\.[A-Za-z0-9]([-A-Za-z0-9]*[A-Za-z0-9])?)*|\\[[0-9A-Fa-f:.]+\\])(:[0-9]{1,5})?|unix:/[^\\s,;'\"{}]+)$"`
+	SyslogFacility string   `key:"syslogFacility" enum:"kern|user|mail|daemon|auth|intern|lpr|news|uucp|clock|authpriv|ftp|ntp|audit|alert|cron|local0|local1|local2|local3|local4|local5|local6|local7"`
+	Fields         []string `key:"fields" constraint:"^\\s*(time|request_id|app|remote_addr|remote_user|status|method|uri|protocol|request|bytes_sent|referer|user_agent|server_name|host|upstream_addr|upstream_status|upstream_response_time|request_time)(\\s*,\\s*(time|request_id|app|remote_addr|remote_user|status|method|uri|protocol|request|bytes_sent|referer|user_agent|server_name|host|upstream_addr|upstream_status|upstream_response_time|request_time))*\\s*$"`
+}
+
+func newLogConfig() *LogConfig {
+	return &LogConfig{
+		Format:         "text",
+		SyslogFacility: "local7",
+		Fields:         []string{"time", "request_id", "app", "remote_addr", "remote_user", "status", "request", "bytes_sent", "referer", "user_agent", "server_name", "upstream_addr", "host", "upstream_response_time", "request_time"},
+	}
+}
+
+// TracingConfig encapsulates distributed tracing configuration.  Spans for the share of requests
+// given by SampleRate, between 0 and 1, are reported to the collector at Collector, given as
+// "<host>:<port>", under the service name ServiceName.
+type TracingConfig struct {
+	Enabled     bool   `key:"enabled" constraint:"(?i)^(true|false)$"`
+	Collector   string `key:"collector" constraint:"^[A-Za-z0-9]([-A-Za-z0-9]*[A-Za-z0-9])?(\\.[A-Za-z0-9]([-A-Za-z0-9]*[A-Za-z0-9])?)*:[0-9]{1,5}$"`
+	SampleRate  string `key:"sampleRate" constraint:"^(0(\\.[0-9]+)?|1(\\.0+)?)$"`
+	ServiceName string `key:"serviceName" constraint:"^[A-Za-z0-9_.-]+$"`
+}
+
+func newTracingConfig() *TracingConfig {
+	return &TracingConfig{
+		SampleRate:  "0.01",
+		ServiceName: "deis-router",
+	}
+}
+
+// OpenFileCacheConfig encapsulates open file cache configuration.
+type OpenFileCacheConfig struct {
+	Enabled  bool   `key:"enabled" constraint:"(?i)^(true|false)$"`
+	Max      string `key:"max" constraint:"^[1-9]\\d*$"`
+	Inactive string `key:"inactive" type:"duration" min:"1ms"`
+	Valid    string `key:"valid" type:"duration" min:"1ms"`
+	MinUses  string `key:"minUses" constraint:"^[1-9]\\d*$"`
+	Errors   bool   `key:"errors" constraint:"(?i)^(true|false)$"`
+}
+
+func newOpenFileCacheConfig() *OpenFileCacheConfig {
+	return &OpenFileCacheConfig{
+		Enabled:  false,
+		Max:      "1000",
+		Inactive: "60s",
+		Valid:    "60s",
+		MinUses:  "1",
+		Errors:   false,
+	}
+}
+
 // AppConfig encapsulates the configuration for all routes to a single back end.
 type AppConfig struct {
 	Name           string
-	Domains        []string `key:"domains" constraint:"(?i)^((([a-z0-9]+(-*[a-z0-9]+)*)|((\\*\\.)?[a-z0-9]+(-*[a-z0-9]+)*\\.)+[a-z0-9]+(-*[a-z0-9]+)+)(\\s*,\\s*)?)+$"`
+	Namespace      string
+	Domains        []string `key:"domains" constraint:"(?i)^(([a-z0-9]+(-*[a-z0-9]+)*)|((\\*\\.)?[a-z0-9]+(-*[a-z0-9]+)*\\.)+[a-z0-9]+(-*[a-z0-9]+)+)\\.?(:[0-9]+)?(\\s*,\\s*(([a-z0-9]+(-*[a-z0-9]+)*)|((\\*\\.)?[a-z0-9]+(-*[a-z0-9]+)*\\.)+[a-z0-9]+(-*[a-z0-9]+)+)\\.?(:[0-9]+)?)*(\\s*,\\s*)?$"`
 	Whitelist      []string `key:"whitelist" constraint:"^((([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?(\\s*,\\s*)?)+$"`
-	ConnectTimeout string   `key:"connectTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$"`
-	TCPTimeout     string   `key:"tcpTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$"`
+	ConnectTimeout string   `key:"connectTimeout" type:"duration" min:"1ms"`
+	TCPTimeout     string   `key:"tcpTimeout" type:"duration" min:"1ms"`
 	ServiceIP      string
-	CertMappings   map[string]string `key:"certificates" constraint:"(?i)^((([a-z0-9]+(-*[a-z0-9]+)*)|((\\*\\.)?[a-z0-9]+(-*[a-z0-9]+)*\\.)+[a-z0-9]+(-*[a-z0-9]+)+):([a-z0-9]+(-*[a-z0-9]+)*)(\\s*,\\s*)?)+$"`
+	ServicePort    int
+	Endpoints      []string
+	CertMappings   map[string]string `key:"certificates" constraint:"(?i)^((([a-z0-9]+(-*[a-z0-9]+)*)|((\\*\\.)?[a-z0-9]+(-*[a-z0-9]+)*\\.)+[a-z0-9]+(-*[a-z0-9]+)+)\\.?:([a-z0-9]+(-*[a-z0-9]+)*)(\\s*,\\s*)?)+$"`
 	Certificates   map[string]*Certificate
 	Available      bool
 	Maintenance    bool       `key:"maintenance" constraint:"(?i)^(true|false)$"`
 	SSLConfig      *SSLConfig `key:"ssl"`
+	Locations      []*LocationConfig
+	// ProxyProtocolTLVHeaders maps the names of request headers to the PROXY protocol v2 TLVs whose
+	// values they should convey to the application.  TLVs are named as nginx's open source edition
+	// names them, which excludes the vendor-specific names only NGINX Plus knows.
+	ProxyProtocolTLVHeaders map[string]string `key:"nginx.proxyProtocolTLVHeaders" constraint:"^([A-Za-z0-9-]+\\s*:\\s*(alpn|authority|unique_id|netns|ssl|ssl_version|ssl_cn|ssl_cipher|ssl_sig_alg|ssl_key_alg|ssl_verify|(ssl_)?0x[0-9a-fA-F]{2})(\\s*,\\s*)?)+$"`
+	TLSHeadersConfig        *TLSHeadersConfig `key:"nginx.tlsHeaders"`
+	DebugBodyConfig         *DebugBodyConfig  `key:"nginx.debugBody"`
+	// ACME indicates whether certificates for the application's fully qualified domains should be
+	// obtained automatically.  Domains for which a certificate has been explicitly mapped are excluded.
+	ACME        bool `key:"acme" constraint:"(?i)^(true|false)$"`
+	ACMEDomains []string
+	// TLSSecrets maps domains, like CertMappings, to the full names of standard kubernetes.io/tls
+	// secrets, such as those cert-manager issues, in the application's namespace.
+	TLSSecrets map[string]string `key:"tlsSecrets" constraint:"(?i)^((([a-z0-9]+(-*[a-z0-9]+)*)|((\\*\\.)?[a-z0-9]+(-*[a-z0-9]+)*\\.)+[a-z0-9]+(-*[a-z0-9]+)+)\\.?:([a-z0-9]([-a-z0-9.]*[a-z0-9])?)(\\s*,\\s*)?)+$"`
+	// RateLimitResponseConfig determines how requests rejected by rate or connection limiting are
+	// answered.
+	RateLimitResponseConfig *RateLimitResponseConfig `key:"nginx.rateLimitResponse"`
+	// LoadBalancingAlgorithm determines how requests are balanced across the endpoints backing the
+	// application.
+	LoadBalancingAlgorithm string `key:"nginx.loadBalancingAlgorithm" enum:"round_robin|least_conn|ip_hash"`
+	// Affinity determines whether a client's requests are consistently routed to the same endpoint.
+	// When set to "cookie", the endpoint is chosen by hashing the value of the named cookie, which is
+	// issued to clients that do not already have one.  This takes precedence over the load-balancing
+	// algorithm.
+	Affinity       string `key:"nginx.affinity" enum:"none|cookie"`
+	AffinityCookie string `key:"nginx.affinityCookie" constraint:"^[A-Za-z0-9_]+$"`
+	// CanaryService names a service in the application's namespace to which CanaryWeight percent of
+	// the application's requests are diverted.  Canary is the resolved service, if it is ready.
+	CanaryService     string `key:"canaryService" constraint:"^[a-z0-9]([-a-z0-9]*[a-z0-9])?$"`
+	CanaryServicePort string `key:"canaryServicePort" constraint:"^([1-9]\\d*|[a-z]([-a-z0-9]*[a-z0-9])?)$"`
+	CanaryWeight      int    `key:"canaryWeight" constraint:"^([0-9]|[1-9][0-9]|100)$"`
+	Canary            *CanaryBackend
+	// ServerSnippet and LocationSnippet are injected verbatim into each of the application's server
+	// blocks and each of its locations that proxies requests, respectively.
+	ServerSnippet   string `key:"nginx.serverSnippet"`
+	LocationSnippet string `key:"nginx.locationSnippet"`
+	// ResourceKind and ResourceName identify the service or ingress from which the application's
+	// configuration was built.
+	ResourceKind string
+	ResourceName string
+	// ClientCertConfig requires clients to present certificates issued by one of the CAs bundled in
+	// a secret.  ClientVerifications holds the resulting verification settings for each of the
+	// application's domains that requires them.
+	ClientCertConfig    *ClientCertConfig `key:"nginx.clientCert"`
+	ClientVerifications map[string]*ClientVerification
+	// BasicAuthSecret names a secret in the application's namespace holding the credentials with
+	// which clients must authenticate.  BasicAuthUsers holds those credentials as htpasswd entries,
+	// in which passwords that were not already hashed are marked with the {PLAIN} scheme, and which
+	// are never included in the router's status.
+	BasicAuthSecret string   `key:"nginx.basicAuthSecret" constraint:"^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$"`
+	BasicAuthRealm  string   `key:"nginx.basicAuthRealm" constraint:"^[^\"\\\\$]+$"`
+	BasicAuthUsers  []string `json:"-"`
+	// ExternalAuthConfig delegates the authentication of the application's clients to an external
+	// service.
+	ExternalAuthConfig *ExternalAuthConfig `key:"nginx.externalAuth"`
+	// Denylist holds the IP addresses and CIDR ranges from which requests are refused, whether or
+	// not they are whitelisted.
+	Denylist []string `key:"nginx.denylist"`
+	// WhitelistSources names IP range sources, defined by the router, whose ranges are whitelisted in
+	// addition to Whitelist.  Naming one restricts access even before its ranges are known.
+	WhitelistSources []string `key:"whitelistSources" constraint:"^([a-z0-9]([-a-z0-9]*[a-z0-9])?(\\s*,\\s*)?)+$"`
+	// RateLimitConfig limits the rate at which, and the number of connections with which, each client
+	// may make requests of the application.
+	RateLimitConfig *RateLimitConfig `key:"nginx.rateLimit"`
+	// ProxyCacheConfig caches the application's responses in a cache of its own.
+	ProxyCacheConfig *ProxyCacheConfig `key:"nginx.proxyCache"`
+	// ErrorPagesConfigMap names a config map in the application's namespace whose keys are HTTP
+	// statuses, such as "404" or "503", and whose values are the pages with which responses bearing
+	// those statuses are replaced.  A "503" page is also served while the application is under
+	// maintenance.  ErrorPages holds the valid pages, keyed by status.
+	ErrorPagesConfigMap string `key:"errorPages" constraint:"^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$"`
+	ErrorPages          map[string]string
+	// ErrorFormat determines the format of the errors the router itself responds with: nginx's HTML
+	// pages, or JSON bodies that API clients can parse.
+	ErrorFormat string `key:"nginx.errorFormat" enum:"html|json"`
+	// FaultInjectionConfig injects latency or errors into a share of the application's requests.
+	FaultInjectionConfig *FaultInjectionConfig `key:"nginx.faultInjection"`
+	// BackendProtocol is the protocol in which the application's endpoints are spoken to: HTTP or
+	// gRPC, with or without TLS, or HTTP/2 cleartext.
+	BackendProtocol string `key:"backendProtocol" enum:"http|https|grpc|grpcs|h2c"`
+	// GRPCWeb translates the gRPC-Web requests browsers make into gRPC for a gRPC application, so
+	// that no separate proxy is needed to serve them.
+	GRPCWeb bool `key:"nginx.grpcWeb" constraint:"(?i)^(true|false)$"`
+	// RetryConfig retries, with backoff, requests that could not be proxied because no endpoint
+	// accepted a connection.
+	RetryConfig *RetryConfig `key:"nginx.retry"`
+	// HTTP10Compatible answers the application's requests in a way that clients that speak only
+	// HTTP/1.0 understand: without chunked transfer encoding, and closing each connection after its
+	// response.
+	HTTP10Compatible bool `key:"nginx.http10Compatible" constraint:"(?i)^(true|false)$"`
+	// ReadTimeout and SendTimeout, if set, override TCPTimeout for reading responses from, and
+	// sending requests to, the application's endpoints respectively, so that an application that
+	// streams responses or holds long polls open may wait longer for them without raising the
+	// router's default for every application.  Locations inherit them.
+	ReadTimeout string `key:"readTimeout" type:"duration" min:"1ms"`
+	SendTimeout string `key:"sendTimeout" type:"duration" min:"1ms"`
+	// ProxyBuffering buffers the application's responses before they are sent on to clients, which
+	// frees the application's connections sooner at the expense of streaming them.  It is implied by
+	// the proxy cache.
+	ProxyBuffering bool `key:"nginx.proxyBuffering" constraint:"(?i)^(true|false)$"`
+	// Websockets passes clients' requests to upgrade their connections, e.g. to websockets, on to the
+	// application.  Applications that never upgrade connections may disable it.
+	Websockets bool `key:"nginx.websockets" constraint:"(?i)^(true|false)$"`
+	// AllowedResponseHeaders, if set, lists the only response headers the application may send to
+	// clients.  All others are removed, so that an application cannot leak, for instance, the
+	// versions of the software that serves it.
+	AllowedResponseHeaders []string `key:"nginx.allowedResponseHeaders" constraint:"^([A-Za-z0-9-]+(\\s*,\\s*)?)+$"`
+	// BodySize overrides the router's limit on the size of the application's request bodies, and
+	// locations may override it in turn.
+	BodySize string `key:"nginx.bodySize" type:"offset"`
+	// BodySizeResponseConfig determines how requests whose bodies exceed BodySize are answered.
+	BodySizeResponseConfig *BodySizeResponseConfig `key:"nginx.bodySizeResponse"`
+	// RequestBuffering reads each request's body in full before the request is proxied.  Disabling
+	// it streams bodies, such as large uploads, to the application as they arrive rather than first
+	// writing them to the router's disk.
+	RequestBuffering bool `key:"nginx.requestBuffering" constraint:"(?i)^(true|false)$"`
+	// ClientBodyBufferSize is the size of request bodies that are buffered in memory, and
+	// ClientBodyTempPath the directory in which those that are larger are written, overriding nginx's
+	// defaults and the router's.
+	ClientBodyBufferSize string `key:"nginx.clientBodyBufferSize" type:"size"`
+	ClientBodyTempPath   string `key:"nginx.clientBodyTempPath" constraint:"^/[^\\s;{}'\"]*$"`
+	// ConditionalConfig determines how conditional requests for the application's responses are
+	// answered.
+	ConditionalConfig *ConditionalConfig `key:"nginx.conditional"`
+	// RangesConfig determines how requests for parts of the application's responses are answered.
+	RangesConfig *RangesConfig `key:"nginx.ranges"`
+	// CacheControlConfig sets the Cache-Control or Expires headers of the application's responses.
+	CacheControlConfig *CacheControlConfig `key:"nginx.cacheControl"`
+	// CDN names the CDN, if any, that the application sits behind.  Requests from the addresses of
+	// the router's IP range source of the same name are trusted to report their clients' addresses
+	// in that CDN's header, which is passed on to the application.
+	CDN string `key:"nginx.cdn" enum:"none|cloudflare|cloudfront|fastly"`
+	// Syslog names a syslog server to which the application's access and error logs are sent in
+	// place of the router's.
+	Syslog string `key:"nginx.log.syslog" constraint:"^(udp://([A-Za-z0-9]([-A-Za-z0-9]*[A-Za-z0-9])?(\\.[A-Za-z0-9]([-A-Za-z0-9]*[A-Za-z0-9])?)*|\\[[0-9A-Fa-f:.]+\\])(:[0-9]{1,5})?|unix:/[^\\s,;'\"{}]+)$"`
+	// HealthCheckConfig determines how endpoints that fail are detected and taken out of rotation.
+	HealthCheckConfig *HealthCheckConfig `key:"nginx.healthCheck"`
+	// HealthcheckPaths are paths probed by load balancers and monitors, whose requests are proxied as
+	// usual but are neither logged, counted in the application's metrics, nor rate limited.
+	HealthcheckPaths []string `key:"nginx.healthcheckPaths" constraint:"^/[^\\s,;{}'\"]*(,/[^\\s,;{}'\"]*)*$"`
+	// GeoIPConfig restricts the countries from which the application may be reached, and passes the
+	// client's country and region to it, if the router has a GeoIP database.
+	GeoIPConfig *GeoIPConfig `key:"nginx.geoip"`
+	// EarlyDataConfig determines whether clients may send requests in TLS 1.3 early data.
+	EarlyDataConfig *EarlyDataConfig `key:"nginx.earlyData"`
+	// UpstreamTLSConfig determines how TLS connections to the application's endpoints are made, when
+	// the backend protocol is https or grpcs.
+	UpstreamTLSConfig *UpstreamTLSConfig `key:"nginx.upstreamTLS"`
+	// TransformConfig selects transformations, from the router's library of them, that are applied to
+	// the application's requests and to the errors with which they are answered.
+	TransformConfig *TransformConfig `key:"nginx.transforms"`
+	// RedirectConfig determines which of the application's requests are answered with a redirect
+	// rather than proxied.
+	RedirectConfig *RedirectConfig `key:"redirect"`
+	// CORSConfig determines which other origins' pages may make requests to the application.
+	CORSConfig *CORSConfig `key:"nginx.cors"`
+	// WAFConfig determines whether, and how, the application's requests are inspected by ModSecurity.
+	// It is inherited from the router.
+	WAFConfig *WAFConfig `key:"nginx.waf"`
+	// UpstreamKeepaliveConfig determines how many idle connections to the application's endpoints are
+	// kept open for reuse.  It is inherited from the router.
+	UpstreamKeepaliveConfig *UpstreamKeepaliveConfig `key:"nginx.upstreamKeepalive"`
+	// StripPrefix is removed from the paths of requests that begin with it, and AddPrefix then
+	// prepended to them, before requests are proxied, so that an application routed by path needs
+	// no changes to be served at a path other than its own.  Locations may override either.
+	StripPrefix string `key:"nginx.stripPrefix" constraint:"^/[-A-Za-z0-9._~%/]*$"`
+	AddPrefix   string `key:"nginx.addPrefix" constraint:"^/[-A-Za-z0-9._~%/]*$"`
+	// ProxyBind overrides the router's local address for connections to the application's endpoints.
+	// "off" makes them from whichever address the kernel chooses.
+	ProxyBind string `key:"nginx.proxyBind" constraint:"^(off|(([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])|[0-9a-fA-F]*:[0-9a-fA-F:.]*)$"`
+	// ExternalOrigin is a server outside the cluster, as a scheme, host, and optional port, to which
+	// the application's requests are proxied in place of its service, e.g. to front a legacy system
+	// with a platform domain during a migration.  Locations that name services of their own are
+	// still routed to them.
+	ExternalOrigin string `key:"nginx.externalOrigin" constraint:"^https?://[A-Za-z0-9]([-A-Za-z0-9.]*[A-Za-z0-9])?(:[1-9]\\d*)?$"`
+	// BackupOrigin is a server outside the cluster, as a host and optional port, to which requests
+	// are proxied, with the application's backend protocol, only while none of the application's
+	// endpoints is healthy, or while it has none.
+	BackupOrigin string `key:"nginx.backupOrigin" constraint:"^[A-Za-z0-9]([-A-Za-z0-9.]*[A-Za-z0-9])?(:[1-9]\\d*)?$"`
 }
 
 func newAppConfig(routerConfig *RouterConfig) *AppConfig {
 	return &AppConfig{
-		ConnectTimeout: "30s",
-		TCPTimeout:     routerConfig.DefaultTimeout,
-		Certificates:   make(map[string]*Certificate, 0),
-		SSLConfig:      newSSLConfig(),
+		ConnectTimeout:          "30s",
+		TCPTimeout:              routerConfig.DefaultTimeout,
+		ServicePort:             80,
+		Certificates:            make(map[string]*Certificate, 0),
+		SSLConfig:               newSSLConfig(),
+		TLSHeadersConfig:        newTLSHeadersConfig(),
+		DebugBodyConfig:         newDebugBodyConfig(),
+		RateLimitResponseConfig: newRateLimitResponseConfig(),
+		BodySizeResponseConfig:  &BodySizeResponseConfig{},
+		LoadBalancingAlgorithm:  "round_robin",
+		Affinity:                "none",
+		AffinityCookie:          "deis_affinity",
+		CanaryServicePort:       "80",
+		ClientCertConfig:        newClientCertConfig(),
+		BasicAuthRealm:          "Restricted",
+		ExternalAuthConfig:      newExternalAuthConfig(),
+		RateLimitConfig:         newRateLimitConfig(),
+		ProxyCacheConfig:        newProxyCacheConfig(),
+		FaultInjectionConfig:    newFaultInjectionConfig(),
+		BackendProtocol:         "http",
+		RetryConfig:             newRetryConfig(),
+		HealthCheckConfig:       newHealthCheckConfig(),
+		GeoIPConfig:             newGeoIPConfig(),
+		EarlyDataConfig:         newEarlyDataConfig(),
+		UpstreamTLSConfig:       newUpstreamTLSConfig(),
+		TransformConfig:         newTransformConfig(),
+		RedirectConfig:          newRedirectConfig(),
+		CORSConfig:              newCORSConfig(),
+		WAFConfig:               newAppWAFConfig(routerConfig),
+		UpstreamKeepaliveConfig: newAppUpstreamKeepaliveConfig(routerConfig),
+		Websockets:              true,
+		RequestBuffering:        true,
+		CacheControlConfig:      &CacheControlConfig{},
+		ConditionalConfig:       newConditionalConfig(),
+		RangesConfig:            &RangesConfig{},
+		CDN:                     "none",
+		ErrorFormat:             "html",
+	}
+}
+
+// CanaryBackend is a service to which a share of an application's requests are diverted.
+type CanaryBackend struct {
+	ServiceIP   string
+	ServicePort int
+	Endpoints   []string
+}
+
+// TLSHeadersConfig encapsulates options for conveying metadata about a client's TLS connection to
+// the application by way of request headers.
+type TLSHeadersConfig struct {
+	Protocol bool `key:"protocol" constraint:"(?i)^(true|false)$"`
+	Cipher   bool `key:"cipher" constraint:"(?i)^(true|false)$"`
+	SNI      bool `key:"sni" constraint:"(?i)^(true|false)$"`
+	JA3      bool `key:"ja3" constraint:"(?i)^(true|false)$"`
+}
+
+func newTLSHeadersConfig() *TLSHeadersConfig {
+	return &TLSHeadersConfig{}
+}
+
+// GeoIPConfig encapsulates options for controlling access to an application by the country of the
+// client, as looked up in the router's GeoIP database, and for passing the client's location to the
+// application.  Countries are ISO 3166-1 alpha-2 codes, such as "US".  When AllowCountries is set,
+// requests from any other country, or from addresses whose country is unknown, are refused.
+// Requests from BlockCountries are always refused.
+type GeoIPConfig struct {
+	AllowCountries []string `key:"allowCountries" constraint:"^[A-Z]{2}(,[A-Z]{2})*$"`
+	BlockCountries []string `key:"blockCountries" constraint:"^[A-Z]{2}(,[A-Z]{2})*$"`
+	Headers        bool     `key:"headers" constraint:"(?i)^(true|false)$"`
+}
+
+func newGeoIPConfig() *GeoIPConfig {
+	return &GeoIPConfig{}
+}
+
+// Filtered returns whether any requests are refused by the client's country.
+func (c *GeoIPConfig) Filtered() bool {
+	return len(c.AllowCountries) > 0 || len(c.BlockCountries) > 0
+}
+
+// EarlyDataConfig encapsulates options for accepting requests sent in TLS 1.3 early data, also
+// known as 0-RTT, which saves a client resuming a session a round trip.  An attacker can replay early
+// data, so only requests whose methods are listed in Methods are accepted in it; others are refused
+// with a 425, which asks the client to retry once the handshake is complete.  Requests that arrive
+// in early data are passed to the application with the Early-Data header set to "1".
+type EarlyDataConfig struct {
+	Enabled bool     `key:"enabled" constraint:"(?i)^(true|false)$"`
+	Methods []string `key:"methods" constraint:"^[A-Z]+(,[A-Z]+)*$"`
+}
+
+func newEarlyDataConfig() *EarlyDataConfig {
+	return &EarlyDataConfig{
+		Methods: []string{"GET", "HEAD", "OPTIONS"},
+	}
+}
+
+// UpstreamTLSConfig encapsulates options for the TLS connections made to an application's endpoints.
+// Name is the server name requested by SNI and, if Verify is set, checked against the certificate
+// the endpoint presents, which must be issued by a CA that the router's system trusts, through at
+// most VerifyDepth intermediates.  Protocols restricts the protocols offered to the endpoint.
+type UpstreamTLSConfig struct {
+	Name        string `key:"name" constraint:"(?i)^(\\$host|[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*)$"`
+	Protocols   string `key:"protocols" constraint:"^((SSLv2|SSLv3|TLSv1|TLSv1\\.1|TLSv1\\.2|TLSv1\\.3)\\s*)+$"`
+	Verify      bool   `key:"verify" constraint:"(?i)^(true|false)$"`
+	VerifyDepth int    `key:"verifyDepth" constraint:"^[1-9]\\d*$"`
+}
+
+func newUpstreamTLSConfig() *UpstreamTLSConfig {
+	return &UpstreamTLSConfig{
+		VerifyDepth: 1,
+	}
+}
+
+// TransformConfig encapsulates options for the pre-built transformations the router applies to an
+// application's requests.  StripPrefix and AddPrefix are the application's own path prefixes by
+// another name, and rewrite its requests' paths just as those do unless it sets either.  JSONErrors
+// sets the application's error format to JSON, so that the errors the router itself responds with
+// are answered with a JSON body rather than nginx's HTML page.  HeaderCase lists request headers
+// that are passed to the application with the casing given, whatever the casing in which the client
+// sent them; HTTP/2 clients send every header in lower case, which some applications do not expect.
+type TransformConfig struct {
+	StripPrefix string   `key:"stripPrefix" constraint:"^/[-A-Za-z0-9._~%/]*$"`
+	AddPrefix   string   `key:"addPrefix" constraint:"^/[-A-Za-z0-9._~%/]*$"`
+	JSONErrors  bool     `key:"jsonErrors" constraint:"(?i)^(true|false)$"`
+	HeaderCase  []string `key:"headerCase" constraint:"^[A-Za-z0-9-]+(\\s*,\\s*[A-Za-z0-9-]+)*$"`
+}
+
+func newTransformConfig() *TransformConfig {
+	return &TransformConfig{}
+}
+
+// RewritesURI returns whether either path prefix is set.
+func (c *TransformConfig) RewritesURI() bool {
+	return c.StripPrefix != "" || c.AddPrefix != ""
+}
+
+// RedirectConfig encapsulates options for redirecting an application's requests.  To, a domain, with
+// an optional scheme and port, redirects every request to the same path and query at that domain,
+// e.g. once the application has moved to it.  WWW redirects requests for bare domains to their www.
+// subdomains if it is "force", and the reverse if it is "strip".  Redirects are made with Status.
+// SSLExemptPaths lists path prefixes whose requests are served over plain HTTP even though HTTPS is
+// enforced, e.g. for legacy health checks that cannot follow a redirect.
+type RedirectConfig struct {
+	To             string   `key:"to" constraint:"^(https?://)?[A-Za-z0-9]([-A-Za-z0-9]*[A-Za-z0-9])?(\\.[A-Za-z0-9]([-A-Za-z0-9]*[A-Za-z0-9])?)*(:[1-9]\\d*)?$"`
+	Status         int      `key:"status" constraint:"^30[1278]$"`
+	WWW            string   `key:"www" enum:"force|strip"`
+	SSLExemptPaths []string `key:"sslExemptPaths" constraint:"^(/[-A-Za-z0-9._~%/]*(\\s*,\\s*)?)+$"`
+}
+
+func newRedirectConfig() *RedirectConfig {
+	return &RedirectConfig{
+		Status: 301,
+	}
+}
+
+// CORSConfig encapsulates options for cross-origin resource sharing, which the router handles on an
+// application's behalf.  Pages from AllowOrigins, each a scheme, host, and optional port, a host
+// whose first label is "*" to allow any subdomain, or "*" alone to allow any origin, may make
+// requests with AllowMethods and AllowHeaders, and read the response headers ExposeHeaders lists
+// along with the body.  AllowCredentials lets such requests carry cookies and authorization.
+// Browsers cache the answer to a preflight request for MaxAge seconds.
+type CORSConfig struct {
+	Enabled          bool     `key:"enabled" constraint:"(?i)^(true|false)$"`
+	AllowOrigins     []string `key:"allowOrigins" constraint:"^((\\*|https?://(\\*\\.)?[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*(:[1-9]\\d*)?)(\\s*,\\s*)?)+$"`
+	AllowMethods     []string `key:"allowMethods" constraint:"^([A-Z]+(\\s*,\\s*)?)+$"`
+	AllowHeaders     []string `key:"allowHeaders" constraint:"^([A-Za-z0-9-]+(\\s*,\\s*)?)+$"`
+	ExposeHeaders    []string `key:"exposeHeaders" constraint:"^([A-Za-z0-9-]+(\\s*,\\s*)?)+$"`
+	AllowCredentials bool     `key:"allowCredentials" constraint:"(?i)^(true|false)$"`
+	MaxAge           int      `key:"maxAge" constraint:"^(0|[1-9]\\d*)$"`
+}
+
+func newCORSConfig() *CORSConfig {
+	return &CORSConfig{
+		AllowOrigins: []string{"*"},
+		AllowMethods: []string{"GET", "PUT", "POST", "DELETE", "PATCH", "OPTIONS"},
+		AllowHeaders: []string{"Authorization", "Content-Type", "Accept", "Origin", "User-Agent", "Cache-Control", "Keep-Alive", "X-Requested-With", "If-Modified-Since"},
+		MaxAge:       86400,
+	}
+}
+
+// AllowsAnyOrigin returns whether pages from any origin may make requests to the application.
+func (c *CORSConfig) AllowsAnyOrigin() bool {
+	for _, origin := range c.AllowOrigins {
+		if origin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// WAFConfig encapsulates options for inspecting requests with the ModSecurity web application
+// firewall and the OWASP Core Rule Set.  In "detect" mode, requests the rules match are only logged;
+// in "block" mode, they are refused.  RulesConfigMap, which only applications may set, names a
+// config map in the application's namespace whose values are ModSecurity rules applied after the
+// Core Rule Set, in the order of their keys.  Rules holds them once they are resolved.
+type WAFConfig struct {
+	Enabled        bool   `key:"enabled" constraint:"(?i)^(true|false)$"`
+	Mode           string `key:"mode" enum:"detect|block"`
+	RulesConfigMap string `key:"rulesConfigMap" constraint:"^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$"`
+	Rules          map[string]string
+}
+
+func newWAFConfig() *WAFConfig {
+	return &WAFConfig{
+		Mode: "detect",
+	}
+}
+
+// newAppWAFConfig returns an application's WAF configuration, which is the router's until the
+// application's annotations say otherwise.
+func newAppWAFConfig(routerConfig *RouterConfig) *WAFConfig {
+	return &WAFConfig{
+		Enabled: routerConfig.WAFConfig.Enabled,
+		Mode:    routerConfig.WAFConfig.Mode,
+	}
+}
+
+// CacheControlConfig encapsulates options for setting the caching headers of an application's
+// successful and redirected responses, so that the caching of static-ish applications can be
+// standardized without changing them.  Value is a Cache-Control header, and Expires a duration from
+// which both an Expires header and a Cache-Control max-age are derived; Value takes precedence if
+// both are set.  Unless Force is set, either only applies to responses that lack the header.
+// Locations inherit both, and may override them.
+type CacheControlConfig struct {
+	Value   string `key:"value" constraint:"^[A-Za-z][-A-Za-z0-9=, ]*$"`
+	Expires string `key:"expires" type:"duration" min:"1s"`
+	Force   bool   `key:"force" constraint:"(?i)^(true|false)$"`
+}
+
+// UpstreamKeepaliveConfig encapsulates options for reusing connections to an application's
+// endpoints, rather than opening a new one, with a new TCP and TLS handshake, for every request.
+// Connections is the number of idle connections each nginx worker keeps open to each of the
+// application's upstreams; "0" opens a connection for every request.  A connection is closed once it
+// has served Requests requests, or has been idle for Timeout.
+type UpstreamKeepaliveConfig struct {
+	Connections string `key:"connections" constraint:"^(0|[1-9]\\d*)$"`
+	Requests    string `key:"requests" constraint:"^[1-9]\\d*$"`
+	Timeout     string `key:"timeout" type:"duration" min:"1s"`
+}
+
+func newUpstreamKeepaliveConfig() *UpstreamKeepaliveConfig {
+	return &UpstreamKeepaliveConfig{
+		Connections: "0",
+		Requests:    "100",
+		Timeout:     "60s",
+	}
+}
+
+// newAppUpstreamKeepaliveConfig returns an application's keepalive configuration, which is the
+// router's until the application's annotations say otherwise.
+func newAppUpstreamKeepaliveConfig(routerConfig *RouterConfig) *UpstreamKeepaliveConfig {
+	return &UpstreamKeepaliveConfig{
+		Connections: routerConfig.UpstreamKeepaliveConfig.Connections,
+		Requests:    routerConfig.UpstreamKeepaliveConfig.Requests,
+		Timeout:     routerConfig.UpstreamKeepaliveConfig.Timeout,
+	}
+}
+
+// Enabled returns whether idle connections to the application's endpoints are kept open for reuse.
+func (upstreamKeepaliveConfig *UpstreamKeepaliveConfig) Enabled() bool {
+	return upstreamKeepaliveConfig != nil && upstreamKeepaliveConfig.Connections != "" && upstreamKeepaliveConfig.Connections != "0"
+}
+
+// ExternalAuthConfig encapsulates options for authenticating each of an application's requests with
+// a subrequest to an external service, such as oauth2_proxy.  A request is proxied to the application
+// only if the service responds with a 2xx status.  If the service responds with a 401, the client is
+// redirected to the sign-in URL, if there is one, with the originally requested URL as the "rd" query
+// parameter.  The named headers of the service's response are passed on to the application.
+//
+// If CacheTTL is set, the service's answers are cached for that long, so that a client's requests
+// do not each wait on the service.  Answers are cached by the request headers ("header:<name>"),
+// cookies ("cookie:<name>"), and query parameters ("arg:<name>") that CacheKey lists, which must
+// include whatever credentials the service checks, and, for services that authorize requests by
+// their paths or methods, "uri" and "method".
+type ExternalAuthConfig struct {
+	URL             string   `key:"url" constraint:"^https?://[A-Za-z0-9.-]+(:[0-9]+)?(/[^\\s;{}'\"$]*)?$"`
+	SigninURL       string   `key:"signinURL" constraint:"^https?://[A-Za-z0-9.-]+(:[0-9]+)?(/[^\\s;{}'\"$?]*)?$"`
+	Method          string   `key:"method" enum:"GET|HEAD|POST"`
+	ResponseHeaders []string `key:"responseHeaders" constraint:"^[A-Za-z0-9-]+(\\s*,\\s*[A-Za-z0-9-]+)*$"`
+	CacheTTL        string   `key:"cacheTTL" type:"duration" min:"1s" max:"1h"`
+	CacheKey        []string `key:"cacheKey" constraint:"^\\s*(uri|method|(header|cookie|arg):[A-Za-z0-9_-]+)\\s*(,\\s*(uri|method|(header|cookie|arg):[A-Za-z0-9_-]+)\\s*)*$"`
+}
+
+func newExternalAuthConfig() *ExternalAuthConfig {
+	return &ExternalAuthConfig{
+		CacheKey: []string{"header:Authorization", "header:Cookie"},
+	}
+}
+
+// ClientCertConfig encapsulates options for verifying the certificates of an application's clients.
+// The named secret, in the application's namespace, must bundle the trusted CAs' certificates in
+// its "ca.crt" entry.  Verification applies to all of the application's domains unless overridden
+// for individual domains.
+type ClientCertConfig struct {
+	Secret       string            `key:"secret" constraint:"^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$"`
+	Verify       string            `key:"verify" enum:"on|off|optional|optional_no_ca"`
+	Depth        string            `key:"depth" constraint:"^[1-9]\\d?$"`
+	DomainVerify map[string]string `key:"domainVerify" constraint:"(?i)^(([a-z0-9]+(-*[a-z0-9]+)*\\.?)+\\s*:\\s*(on|off|optional|optional_no_ca)(\\s*,\\s*)?)+$"`
+	DomainDepth  map[string]string `key:"domainDepth" constraint:"(?i)^(([a-z0-9]+(-*[a-z0-9]+)*\\.?)+\\s*:\\s*[1-9]\\d?(\\s*,\\s*)?)+$"`
+}
+
+func newClientCertConfig() *ClientCertConfig {
+	return &ClientCertConfig{
+		Verify: "on",
+		Depth:  "1",
+	}
+}
+
+// ClientVerification is the client certificate verification in effect for one domain.
+type ClientVerification struct {
+	CABundle string
+	Verify   string
+	Depth    string
+}
+
+// DebugBodyConfig encapsulates options for temporarily logging the beginning of request bodies to
+// help diagnose misbehaving clients.  Logging is only ever enabled until a specified time.
+type DebugBodyConfig struct {
+	Path           string   `key:"path" constraint:"^/[^\\s;{}'\"]*$"`
+	Size           int      `key:"size" constraint:"^[1-9]\\d{0,3}$"`
+	Until          string   `key:"until" constraint:"^\\d{4}-\\d{2}-\\d{2}T\\d{2}:\\d{2}:\\d{2}(\\.\\d+)?(Z|[+-]\\d{2}:\\d{2})$"`
+	RedactPatterns []string `key:"redact" constraint:"^[^\\n]+$"`
+	Enabled        bool
+}
+
+func newDebugBodyConfig() *DebugBodyConfig {
+	return &DebugBodyConfig{
+		Path: "/",
+		Size: 1024,
+	}
+}
+
+// RateLimitResponseConfig encapsulates options for customizing the response to requests rejected by
+// rate or connection limiting.  By default, nginx responds with a 503 and an HTML error page, which
+// API clients cannot easily distinguish from other failures.
+type RateLimitResponseConfig struct {
+	Status     int    `key:"status" constraint:"^(429|503)$"`
+	RetryAfter int    `key:"retryAfter" constraint:"^\\d+$"`
+	Body       string `key:"body" constraint:"^[^$]+$"`
+}
+
+func newRateLimitResponseConfig() *RateLimitResponseConfig {
+	return &RateLimitResponseConfig{
+		Status: 503,
+	}
+}
+
+// BodySizeResponseConfig encapsulates options for customizing the 413 with which requests whose
+// bodies are too large are rejected.  By default, nginx sends an HTML error page without the CORS
+// headers a browser needs to let a page from another origin read it, so API clients, and browsers
+// uploading files, cannot tell why an upload failed.
+type BodySizeResponseConfig struct {
+	Body string `key:"body" constraint:"^[^$]+$"`
+	CORS bool   `key:"cors" constraint:"(?i)^(true|false)$"`
+}
+
+// RateLimitConfig encapsulates options for limiting the requests each client may make of an
+// application, so that no one client can starve the others, or other applications, of capacity.
+// Clients are told apart by IP address or by the value of a request header, such as an API key.
+// Requests without that header are not limited.  Neither limit applies unless it is set.
+type RateLimitConfig struct {
+	// Rate is the sustained rate at which each client may make requests, such as "10r/s" or
+	// "600r/m".
+	Rate string `key:"rate" constraint:"^[1-9]\\d*r/[sm]$"`
+	// Burst is the number of requests in excess of the rate that are queued, rather than rejected,
+	// and NoDelay determines whether those requests are proxied immediately rather than held to the
+	// rate.
+	Burst   int  `key:"burst" constraint:"^\\d+$"`
+	NoDelay bool `key:"noDelay" constraint:"(?i)^(true|false)$"`
+	// Connections is the number of connections each client may have open at once.
+	Connections int    `key:"connections" constraint:"^\\d+$"`
+	Key         string `key:"key" constraint:"^(ip|header:[A-Za-z0-9-]+)$"`
+	// ZoneSize is the size of the shared memory zones in which clients' usage is tracked.  About
+	// 16,000 clients can be tracked per megabyte.
+	ZoneSize string `key:"zoneSize" type:"size" min:"32k"`
+}
+
+func newRateLimitConfig() *RateLimitConfig {
+	return &RateLimitConfig{
+		Key:      "ip",
+		ZoneSize: "10m",
+	}
+}
+
+// ProxyCacheConfig encapsulates options for caching an application's responses.  Responses are
+// cached for as long as their Cache-Control or Expires headers permit, or, failing that, for as long
+// as Valid specifies for their status.
+type ProxyCacheConfig struct {
+	Enabled bool `key:"enabled" constraint:"(?i)^(true|false)$"`
+	// ZoneSize is the size of the shared memory zone in which cache keys are kept.  About 8,000 keys
+	// can be kept per megabyte.  MaxSize is the most the cache may hold on disk, and Inactive is how
+	// long a response that is not requested remains in the cache.
+	ZoneSize string `key:"zoneSize" type:"size" min:"32k"`
+	MaxSize  string `key:"maxSize" type:"offset" min:"1"`
+	Inactive string `key:"inactive" type:"duration" min:"1s"`
+	// Valid maps response statuses, or "any", to how long responses with that status are cached
+	// when they do not say themselves.
+	Valid map[string]string `key:"valid" constraint:"^\\s*([1-5][0-9]{2}|any)\\s*:\\s*[1-9]\\d*(ms|[smhdwMy])?\\s*(,\\s*([1-5][0-9]{2}|any)\\s*:\\s*[1-9]\\d*(ms|[smhdwMy])?\\s*)*$"`
+	Key   string            `key:"key" constraint:"^[$A-Za-z0-9_:/.?&=-]+$"`
+	// Bypass lists the request headers ("header:<name>"), cookies ("cookie:<name>"), and query
+	// parameters ("arg:<name>") that, when present and neither empty nor "0", cause a request to be
+	// answered by the application rather than from the cache, and its response not to be cached.
+	Bypass []string `key:"bypass" constraint:"^\\s*(header|cookie|arg):[A-Za-z0-9_-]+\\s*(,\\s*(header|cookie|arg):[A-Za-z0-9_-]+\\s*)*$"`
+	// NormalizeAcceptEncoding reduces each request's Accept-Encoding header to whether it accepts
+	// gzip, both in the request passed to the application and in the cache key, so that a response
+	// compressed for one client is never served from the cache to a client that cannot decode it.
+	NormalizeAcceptEncoding bool `key:"normalizeAcceptEncoding" constraint:"(?i)^(true|false)$"`
+	// Revalidate refreshes expired responses with conditional requests, so that the application need
+	// only answer that a response has not changed, by its ETag or Last-Modified headers, rather than
+	// send it again.
+	Revalidate bool `key:"revalidate" constraint:"(?i)^(true|false)$"`
+}
+
+// ConditionalConfig encapsulates options for answering conditional requests, whose If-None-Match or
+// If-Modified-Since headers let a client that already holds a response be told it has not changed
+// rather than be sent it again.  The router itself answers such requests for the responses it
+// serves, from its cache or from files, using their ETag and Last-Modified headers; those of the
+// application's responses are passed on unless ETag is disabled, e.g. because the application's
+// pods generate different ETags for the same response.  IfModifiedSince is as for nginx's directive.
+type ConditionalConfig struct {
+	ETag            bool   `key:"etag" constraint:"(?i)^(true|false)$"`
+	IfModifiedSince string `key:"ifModifiedSince" enum:"off|exact|before"`
+}
+
+// RangesConfig encapsulates options for answering range requests, whose Range headers ask for only
+// parts of a response, as clients do to resume interrupted downloads or to seek within videos.
+// nginx answers them itself, from the complete response, for responses served from its cache or
+// from files, and passes on the application's own answers to the rest.  Force has nginx answer them
+// from the application's complete responses too, for applications that ignore Range headers, and
+// Max limits how many ranges one request may ask for, "0" disabling range requests altogether,
+// since each range of a multi-range request is sent as a separate part.
+type RangesConfig struct {
+	Force bool   `key:"force" constraint:"(?i)^(true|false)$"`
+	Max   string `key:"max" constraint:"^[0-9]+$"`
+}
+
+func newConditionalConfig() *ConditionalConfig {
+	return &ConditionalConfig{
+		ETag:            true,
+		IfModifiedSince: "exact",
+	}
+}
+
+func newProxyCacheConfig() *ProxyCacheConfig {
+	return &ProxyCacheConfig{
+		ZoneSize:                "10m",
+		MaxSize:                 "1g",
+		Inactive:                "10m",
+		Key:                     "$scheme$host$request_uri",
+		NormalizeAcceptEncoding: true,
+	}
+}
+
+// FaultInjectionConfig encapsulates options for deliberately delaying or failing a share of an
+// application's requests, so that the resilience of its clients can be exercised.  Faults are only
+// injected if the router permits it.
+type FaultInjectionConfig struct {
+	Delay        string `key:"delay" type:"duration" min:"1ms" max:"30s"`
+	DelayPercent int    `key:"delayPercent" constraint:"^([0-9]|[1-9][0-9]|100)$"`
+	AbortPercent int    `key:"abortPercent" constraint:"^([0-9]|[1-9][0-9]|100)$"`
+	AbortStatus  int    `key:"abortStatus" constraint:"^[45][0-9]{2}$"`
+}
+
+func newFaultInjectionConfig() *FaultInjectionConfig {
+	return &FaultInjectionConfig{
+		Delay:       "1s",
+		AbortStatus: 503,
+	}
+}
+
+// RetryConfig encapsulates options for retrying requests that could not be proxied because no
+// endpoint accepted a connection, as when pods restart during a rolling update.  Each attempt
+// follows a backoff that doubles, up to MaxBackoff, from one attempt to the next.
+type RetryConfig struct {
+	Attempts   int    `key:"attempts" constraint:"^([0-9]|10)$"`
+	Backoff    string `key:"backoff" type:"duration" min:"1ms" max:"10s"`
+	MaxBackoff string `key:"maxBackoff" type:"duration" min:"1ms" max:"30s"`
+}
+
+func newRetryConfig() *RetryConfig {
+	return &RetryConfig{
+		Backoff:    "250ms",
+		MaxBackoff: "2s",
+	}
+}
+
+// HealthCheckConfig encapsulates options for detecting an application's failing endpoints.  nginx
+// passively stops proxying requests to an endpoint for FailTimeout once MaxFails requests to it have
+// failed within FailTimeout.  If Path is set, the router also actively requests it of each endpoint
+// every Interval, and takes the endpoint out of rotation once UnhealthyThreshold requests in a row
+// have failed, until HealthyThreshold requests in a row succeed.
+type HealthCheckConfig struct {
+	MaxFails           string `key:"maxFails" constraint:"^([0-9]|[1-9][0-9])$"`
+	FailTimeout        string `key:"failTimeout" type:"duration" min:"1s" max:"1h"`
+	Path               string `key:"path" constraint:"^/[^\\s;{}'\"#]*$"`
+	Interval           string `key:"interval" type:"duration" min:"1s" max:"5m"`
+	Timeout            string `key:"timeout" type:"duration" min:"100ms" max:"1m"`
+	UnhealthyThreshold int    `key:"unhealthyThreshold" constraint:"^([1-9]|10)$"`
+	HealthyThreshold   int    `key:"healthyThreshold" constraint:"^([1-9]|10)$"`
+}
+
+func newHealthCheckConfig() *HealthCheckConfig {
+	return &HealthCheckConfig{
+		Interval:           "10s",
+		Timeout:            "2s",
+		UnhealthyThreshold: 3,
+		HealthyThreshold:   2,
+	}
+}
+
+// LocationConfig encapsulates overrides of an application's configuration that apply only to
+// requests whose path begins with a given prefix.
+type LocationConfig struct {
+	Path           string   `key:"path" constraint:"^/[^\\s;{}'\"]+$"`
+	ConnectTimeout string   `key:"connectTimeout" type:"duration" min:"1ms"`
+	TCPTimeout     string   `key:"tcpTimeout" type:"duration" min:"1ms"`
+	ReadTimeout    string   `key:"readTimeout" type:"duration" min:"1ms"`
+	SendTimeout    string   `key:"sendTimeout" type:"duration" min:"1ms"`
+	BodySize       string   `key:"bodySize" type:"offset"`
+	CacheControl   string   `key:"cacheControl" constraint:"^[A-Za-z][-A-Za-z0-9=, ]*$"`
+	Expires        string   `key:"expires" type:"duration" min:"1s"`
+	Whitelist      []string `key:"whitelist" constraint:"^((([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])(\\/([0-9]|[1-2][0-9]|3[0-2]))?(\\s*,\\s*)?)+$"`
+	BackendService string   `key:"service" constraint:"^[a-z0-9]([-a-z0-9]*[a-z0-9])?$"`
+	BackendPort    string   `key:"servicePort" constraint:"^([1-9]\\d*|[a-z]([-a-z0-9]*[a-z0-9])?)$"`
+	ServiceIP      string
+	ServicePort    int
+	Endpoints      []string
+	Available      bool
+	// Canary is inherited from the application unless the location is routed to another service.
+	Canary *CanaryBackend
+	// Weights, if set, splits the location's requests among several services in the application's
+	// namespace, each named along with its relative weight, in place of a single backend service.
+	// WeightedBackends holds those of the services that are ready and have a weight above zero.
+	Weights          map[string]string `key:"weights" constraint:"^([a-z0-9]([-a-z0-9]*[a-z0-9])?\\s*:\\s*\\d+(\\s*,\\s*)?)+$"`
+	WeightedBackends []*WeightedBackend
+	// StripPrefix and AddPrefix rewrite the paths of the location's requests as the application's do,
+	// and are inherited from the application.
+	StripPrefix string `key:"stripPrefix" constraint:"^/[-A-Za-z0-9._~%/]*$"`
+	AddPrefix   string `key:"addPrefix" constraint:"^/[-A-Za-z0-9._~%/]*$"`
+	// ExternalOrigin and BackupOrigin are inherited from the application unless the location is
+	// routed to another service.
+	ExternalOrigin string
+	BackupOrigin   string
+	// Auth is whether the application's basic and external authentication apply to the location's
+	// requests, and ProxyCache whether the location's responses are cached in the application's
+	// cache.  Both are inherited from the application, so either may exempt a single location.
+	// ProxyCache may also cache a single location's responses with the application's cache settings.
+	Auth       bool `key:"auth" constraint:"(?i)^(true|false)$"`
+	ProxyCache bool `key:"proxyCache" constraint:"(?i)^(true|false)$"`
+}
+
+// WeightedBackend is one of several services among which a location's requests are split.
+type WeightedBackend struct {
+	Service     string
+	Weight      int
+	ServiceIP   string
+	ServicePort int
+	Endpoints   []string
+}
+
+func newLocationConfig(appConfig *AppConfig) *LocationConfig {
+	return &LocationConfig{
+		BackendPort:    "80",
+		ConnectTimeout: appConfig.ConnectTimeout,
+		TCPTimeout:     appConfig.TCPTimeout,
+		ReadTimeout:    appConfig.ReadTimeout,
+		SendTimeout:    appConfig.SendTimeout,
+		ServiceIP:      appConfig.ServiceIP,
+		ServicePort:    appConfig.ServicePort,
+		Endpoints:      appConfig.Endpoints,
+		Available:      appConfig.Available,
+		Canary:         appConfig.Canary,
+		StripPrefix:    appConfig.StripPrefix,
+		AddPrefix:      appConfig.AddPrefix,
+		ExternalOrigin: appConfig.ExternalOrigin,
+		BackupOrigin:   appConfig.BackupOrigin,
+		CacheControl:   appConfig.CacheControlConfig.Value,
+		Expires:        appConfig.CacheControlConfig.Expires,
+		Auth:           true,
+		ProxyCache:     appConfig.ProxyCacheConfig.Enabled,
 	}
 }
 
 // BuilderConfig encapsulates the configuration of the deis-builder-- if it's in use.
 type BuilderConfig struct {
-	ConnectTimeout string `key:"connectTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$"`
-	TCPTimeout     string `key:"tcpTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$"`
+	ConnectTimeout string `key:"connectTimeout" type:"duration" min:"1ms"`
+	TCPTimeout     string `key:"tcpTimeout" type:"duration" min:"1ms"`
 	ServiceIP      string
 }
 
@@ -146,10 +1182,128 @@ func newBuilderConfig() *BuilderConfig {
 	}
 }
 
+// StreamConfig encapsulates the configuration for proxying raw TCP or UDP traffic arriving on one of
+// the router's ports to a routable service.
+type StreamConfig struct {
+	Name           string
+	Protocol       string
+	ListenPort     int
+	ConnectTimeout string
+	TCPTimeout     string
+	ServiceIP      string
+	ServicePort    int
+	Endpoints      []string
+	Available      bool
+}
+
+// streamPortsConfig encapsulates the annotations with which a routable service requests that raw
+// TCP or UDP traffic be proxied to it.  Each maps a port on which the router should listen to the
+// number or name of the service port to which that traffic should be proxied.
+type streamPortsConfig struct {
+	TCPPorts       map[string]string `key:"tcpPorts" constraint:"^\\s*[1-9]\\d*\\s*:\\s*([1-9]\\d*|[a-z]([-a-z0-9]*[a-z0-9])?)\\s*(,\\s*[1-9]\\d*\\s*:\\s*([1-9]\\d*|[a-z]([-a-z0-9]*[a-z0-9])?)\\s*)*$"`
+	UDPPorts       map[string]string `key:"udpPorts" constraint:"^\\s*[1-9]\\d*\\s*:\\s*([1-9]\\d*|[a-z]([-a-z0-9]*[a-z0-9])?)\\s*(,\\s*[1-9]\\d*\\s*:\\s*([1-9]\\d*|[a-z]([-a-z0-9]*[a-z0-9])?)\\s*)*$"`
+	ConnectTimeout string            `key:"connectTimeout" type:"duration" min:"1ms"`
+	TCPTimeout     string            `key:"tcpTimeout" type:"duration" min:"1ms"`
+}
+
+func newStreamPortsConfig(routerConfig *RouterConfig) *streamPortsConfig {
+	return &streamPortsConfig{
+		ConnectTimeout: "30s",
+		TCPTimeout:     routerConfig.DefaultTimeout,
+	}
+}
+
+// reservedStreamPorts are ports on which the router itself listens and which, therefore, cannot be
+// used for stream routing.
+var reservedStreamPorts = map[int]bool{2222: true, 6443: true, 8080: true, 9090: true, 9091: true, 9092: true, 9093: true, 9094: true, 9095: true, 9096: true, 9097: true, 9098: true, 9099: true}
+
+// buildStreamConfigs returns a StreamConfig for each TCP or UDP port the provided service requests.
+// Ports that are invalid or reserved are logged and skipped.
+func buildStreamConfigs(kubeClient *kubernetes.Clientset, service v1.Service, routerConfig *RouterConfig) ([]*StreamConfig, error) {
+	streamPorts := newStreamPortsConfig(routerConfig)
+	if err := mapAnnotations(routerConfig, "Service", service.ObjectMeta, "", streamPorts); err != nil {
+		return nil, err
+	}
+	streamConfigs := []*StreamConfig{}
+	for _, protocol := range []string{"tcp", "udp"} {
+		ports := streamPorts.TCPPorts
+		if protocol == "udp" {
+			ports = streamPorts.UDPPorts
+		}
+		listenPorts := make([]string, 0, len(ports))
+		for listenPort := range ports {
+			listenPorts = append(listenPorts, listenPort)
+		}
+		sort.Strings(listenPorts)
+		for _, listenPortStr := range listenPorts {
+			listenPort, err := strconv.Atoi(listenPortStr)
+			if err != nil || listenPort < 1024 || listenPort > 65535 || reservedStreamPorts[listenPort] {
+				log.Printf("WARN: Service \"%s/%s\" requests %s port %s, which is unavailable -- skipping this port.\n", service.Namespace, service.Name, protocol, listenPortStr)
+				continue
+			}
+			streamConfig := &StreamConfig{
+				Name:           fmt.Sprintf("%s/%s", service.Namespace, service.Name),
+				Protocol:       protocol,
+				ListenPort:     listenPort,
+				ConnectTimeout: streamPorts.ConnectTimeout,
+				TCPTimeout:     streamPorts.TCPTimeout,
+			}
+			streamConfig.ServiceIP, streamConfig.ServicePort, streamConfig.Available, streamConfig.Endpoints, err = resolveBackend(kubeClient, service.Namespace, service.Name, parseServicePort(ports[listenPortStr]))
+			if err != nil {
+				return nil, err
+			}
+			if streamConfig.ServicePort == 0 {
+				continue
+			}
+			streamConfigs = append(streamConfigs, streamConfig)
+		}
+	}
+	return streamConfigs, nil
+}
+
+// addStreamConfigs adds the provided StreamConfigs to the router's configuration, unless another
+// service has already claimed the same port and protocol, or the router listens for SSL on the port.
+func addStreamConfigs(routerConfig *RouterConfig, streamConfigs []*StreamConfig) {
+	for _, streamConfig := range streamConfigs {
+		claimed := false
+		for _, listener := range routerConfig.ListenerConfigs {
+			if streamConfig.Protocol == "tcp" && listener.Port == streamConfig.ListenPort {
+				log.Printf("WARN: tcp port %d is requested by \"%s\", but the router listens for SSL on it -- skipping this port.\n", streamConfig.ListenPort, streamConfig.Name)
+				claimed = true
+				break
+			}
+		}
+		if claimed {
+			continue
+		}
+		for _, existing := range routerConfig.StreamConfigs {
+			if existing.Protocol == streamConfig.Protocol && existing.ListenPort == streamConfig.ListenPort {
+				log.Printf("WARN: %s port %d is requested by both \"%s\" and \"%s\" -- routing it to \"%s\".\n", streamConfig.Protocol, streamConfig.ListenPort, existing.Name, streamConfig.Name, existing.Name)
+				claimed = true
+				break
+			}
+		}
+		if !claimed {
+			routerConfig.StreamConfigs = append(routerConfig.StreamConfigs, streamConfig)
+		}
+	}
+}
+
 // Certificate represents an SSL certificate for use in securing routable applications.
 type Certificate struct {
 	Cert string
-	Key  string
+	// Key is the certificate's private key.  It is never included in the router's status.
+	Key string `json:"-"`
+	// CA is the certificate of the CA that issued the certificate, if its secret conveys one.  It is
+	// trusted to verify the OCSP responses stapled to the certificate.
+	CA string
+	// Name is the base name of the files holding the certificate, such as the domain, possibly a
+	// wildcard, for which it was mapped.  Every domain that shares the certificate is served from the
+	// same files.  When unset, each domain's copy is named after the domain.
+	Name string
+	// NotAfter is the time after which the certificate is no longer valid, or zero if it could not be
+	// parsed.
+	NotAfter time.Time
 }
 
 func newCertificate(cert string, key string) *Certificate {
@@ -162,14 +1316,34 @@ func newCertificate(cert string, key string) *Certificate {
 // SSLConfig represents SSL-related configuration options.
 type SSLConfig struct {
 	Enforce           string      `key:"enforce" constraint:"(?i)^(true|false|external)$"`
-	Protocols         string      `key:"protocols" constraint:"^((SSLv2|SSLv3|TLSv1|TLSv1\\.1|TLSv1\\.2)\\s*)+$"`
+	Protocols         string      `key:"protocols" constraint:"^((SSLv2|SSLv3|TLSv1|TLSv1\\.1|TLSv1\\.2|TLSv1\\.3)\\s*)+$"`
 	Ciphers           string      `key:"ciphers" constraint:"^(!?[A-Z][A-Z\\d\\+-]+:?)*$"`
 	SessionCache      string      `key:"sessionCache" constraint:"^(off|none|((builtin(:[1-9]\\d*)?|shared:\\w+:[1-9]\\d*[kKmM]?)\\s*){1,2})$"`
-	SessionTimeout    string      `key:"sessionTimeout" constraint:"^[1-9]\\d*(ms|[smhdwMy])?$"`
+	SessionTimeout    string      `key:"sessionTimeout" type:"duration" min:"1ms"`
 	UseSessionTickets bool        `key:"useSessionTickets" constraint:"(?i)^(true|false)$"`
-	BufferSize        string      `key:"bufferSize" constraint:"^[1-9]\\d*[kKmM]?$"`
+	BufferSize        string      `key:"bufferSize" type:"size" min:"1"`
+	HTTP2             string      `key:"http2" enum:"true|false"`
 	HSTSConfig        *HSTSConfig `key:"hsts"`
 	DHParam           string
+	// SNIConnectionLimit caps the connections each server name requested by SNI may have open on
+	// the SSL port.  Connections are counted before their handshakes, so that a flood of handshakes
+	// for one domain cannot exhaust the CPU that every domain's handshakes share.  Only the router's
+	// setting applies.
+	SNIConnectionLimit int `key:"sniConnectionLimit" constraint:"^(0|[1-9]\\d*)$"`
+	// ECH enables Encrypted Client Hello on the SSL port, with the keys in ECHKeys, which are taken
+	// from the deis-router-ech secret and keyed by file name.  Only the router's setting applies.  The
+	// keys are never included in the router's status.
+	ECH     bool              `key:"ech" constraint:"(?i)^(true|false)$"`
+	ECHKeys map[string]string `json:"-"`
+	// OCSPStapling attaches to each handshake the certificate's revocation status, as fetched from
+	// its CA's OCSP responder, whose name is looked up with OCSPResolver.  OCSPStaplingVerify checks
+	// the responder's answers before they are attached.  Only the router's settings apply.
+	OCSPStapling       bool     `key:"ocspStapling" constraint:"(?i)^(true|false)$"`
+	OCSPStaplingVerify bool     `key:"ocspStaplingVerify" constraint:"(?i)^(true|false)$"`
+	OCSPResolver       []string `key:"ocspResolver" constraint:"^([0-9.]+|\\[[0-9A-Fa-f:.]+\\]|[A-Za-z0-9]([-A-Za-z0-9.]*[A-Za-z0-9])?)(:[0-9]{1,5})?(,([0-9.]+|\\[[0-9A-Fa-f:.]+\\]|[A-Za-z0-9]([-A-Za-z0-9.]*[A-Za-z0-9])?)(:[0-9]{1,5})?)*$"`
+	// SessionCacheSize sizes a cache of SSL sessions shared by all of nginx's worker processes, which
+	// is used unless SessionCache sets the cache explicitly.  One megabyte holds about 4000 sessions.
+	SessionCacheSize string `key:"sessionCacheSize" type:"size" min:"32k"`
 }
 
 func newSSLConfig() *SSLConfig {
@@ -191,6 +1365,92 @@ func newSSLConfig() *SSLConfig {
 	}
 }
 
+// ProxyProtocolConfig determines which of the router's listeners expect connections to begin with a
+// PROXY protocol header, which conveys the address of the client on whose behalf a load balancer
+// connects.  Each listener that is not set follows the router's useProxyProtocol option.
+type ProxyProtocolConfig struct {
+	HTTP   string `key:"http" enum:"true|false"`
+	HTTPS  string `key:"https" enum:"true|false"`
+	Stream string `key:"stream" enum:"true|false"`
+}
+
+func newProxyProtocolConfig() *ProxyProtocolConfig {
+	return &ProxyProtocolConfig{}
+}
+
+// ProxyProtocolHTTP returns whether the router's plain HTTP listener expects the PROXY protocol.
+func (routerConfig *RouterConfig) ProxyProtocolHTTP() bool {
+	if routerConfig.ProxyProtocolConfig != nil && routerConfig.ProxyProtocolConfig.HTTP != "" {
+		return routerConfig.ProxyProtocolConfig.HTTP == "true"
+	}
+	return routerConfig.UseProxyProtocol
+}
+
+// ProxyProtocolHTTPS returns whether the router's SSL listener expects the PROXY protocol.
+func (routerConfig *RouterConfig) ProxyProtocolHTTPS() bool {
+	if routerConfig.ProxyProtocolConfig != nil && routerConfig.ProxyProtocolConfig.HTTPS != "" {
+		return routerConfig.ProxyProtocolConfig.HTTPS == "true"
+	}
+	return routerConfig.UseProxyProtocol
+}
+
+// ProxyProtocolStream returns whether the router's TCP listeners for the builder and for
+// applications' streams expect the PROXY protocol.  UDP listeners never do.
+func (routerConfig *RouterConfig) ProxyProtocolStream() bool {
+	if routerConfig.ProxyProtocolConfig != nil && routerConfig.ProxyProtocolConfig.Stream != "" {
+		return routerConfig.ProxyProtocolConfig.Stream == "true"
+	}
+	return routerConfig.UseProxyProtocol
+}
+
+// HTTP2 returns whether the router's SSL listeners negotiate HTTP/2.  The router's SSL option takes
+// precedence over the older http2Enabled option, which applies only if the former is unset.
+func (routerConfig *RouterConfig) HTTP2() bool {
+	if routerConfig.SSLConfig != nil && routerConfig.SSLConfig.HTTP2 != "" {
+		return routerConfig.SSLConfig.HTTP2 == "true"
+	}
+	return routerConfig.HTTP2Enabled
+}
+
+// ListenerConfig represents an SSL port on which the router listens in addition to its own, such as
+// one that negotiates older protocols and ciphers for legacy clients.  Since nginx negotiates SSL
+// before it knows which domain a client requests, each port has one set of protocols and ciphers,
+// shared by every domain served on it.
+type ListenerConfig struct {
+	Port      int    `key:"port" constraint:"^[1-9]\\d*$"`
+	Protocols string `key:"protocols" constraint:"^((SSLv2|SSLv3|TLSv1|TLSv1\\.1|TLSv1\\.2|TLSv1\\.3)\\s*)+$"`
+	Ciphers   string `key:"ciphers" constraint:"^(!?[A-Z][A-Z\\d\\+-]+:?)*$"`
+	HTTP2     string `key:"http2" enum:"true|false"`
+	// Domains restricts the domains served on the port to those listed, as applications list them.
+	// When empty, every domain with a certificate is served.
+	Domains []string `key:"domains" constraint:"(?i)^(([a-z0-9]+(-*[a-z0-9]+)*)|((\\*\\.)?[a-z0-9]+(-*[a-z0-9]+)*\\.)+[a-z0-9]+(-*[a-z0-9]+)+)\\.?(:[0-9]+)?(\\s*,\\s*(([a-z0-9]+(-*[a-z0-9]+)*)|((\\*\\.)?[a-z0-9]+(-*[a-z0-9]+)*\\.)+[a-z0-9]+(-*[a-z0-9]+)+)\\.?(:[0-9]+)?)*(\\s*,\\s*)?$"`
+}
+
+func newListenerConfig(routerConfig *RouterConfig) *ListenerConfig {
+	http2 := "false"
+	if routerConfig.HTTP2() {
+		http2 = "true"
+	}
+	return &ListenerConfig{
+		Protocols: routerConfig.SSLConfig.Protocols,
+		Ciphers:   routerConfig.SSLConfig.Ciphers,
+		HTTP2:     http2,
+	}
+}
+
+// Serves returns whether the provided domain, as an application lists it, is served on the port.
+func (listenerConfig *ListenerConfig) Serves(domain string) bool {
+	if len(listenerConfig.Domains) == 0 {
+		return true
+	}
+	for _, listenerDomain := range listenerConfig.Domains {
+		if normalizeDomain(listenerDomain) == domain {
+			return true
+		}
+	}
+	return false
+}
+
 // HSTSConfig represents configuration options having to do with HTTP Strict Transport Security.
 type HSTSConfig struct {
 	Enabled           bool `key:"enabled" constraint:"(?i)^(true|false)$"`
@@ -216,6 +1476,7 @@ func Build(kubeClient *kubernetes.Clientset) (*RouterConfig, error) {
 	//   All services with label "routable=true"
 	//   deis-builder service, if it exists
 	// These are used to construct a model...
+	listStart := time.Now()
 	routerDeployment, err := getDeployment(kubeClient)
 	if err != nil {
 		return nil, err
@@ -237,11 +1498,14 @@ func Build(kubeClient *kubernetes.Clientset) (*RouterConfig, error) {
 	if err != nil {
 		return nil, err
 	}
+	metrics.ObserveStage("list", listStart)
 	// Build the model...
+	buildStart := time.Now()
 	routerConfig, err := build(kubeClient, routerDeployment, platformCertSecret, dhParamSecret, appServices, builderService)
 	if err != nil {
 		return nil, err
 	}
+	metrics.ObserveStage("build", buildStart)
 	return routerConfig, nil
 }
 
@@ -262,22 +1526,98 @@ func getAppServices(kubeClient *kubernetes.Clientset) (*v1.ServiceList, error) {
 	return services, nil
 }
 
-// getBuilderService will return the service named "deis-builder" from the same namespace as
-// the router, but will return nil (without error) if no such service exists.
-func getBuilderService(kubeClient *kubernetes.Clientset) (*v1.Service, error) {
-	serviceClient := kubeClient.Services(namespace)
-	service, err := serviceClient.Get("deis-builder")
+// namespaceSet is a set of namespaces, in which a nil set is taken to include every namespace.
+type namespaceSet map[string]bool
+
+func (s namespaceSet) admits(ns string) bool {
+	return s == nil || s[ns]
+}
+
+// getPublishingNamespaces returns the namespaces whose services and ingresses may publish routes
+// through the router, as restricted by its namespace selector and allow-list, or nil if any
+// namespace may.
+func getPublishingNamespaces(kubeClient *kubernetes.Clientset, routerConfig *RouterConfig) (namespaceSet, error) {
+	if routerConfig.NamespaceSelector == "" && len(routerConfig.Namespaces) == 0 {
+		return nil, nil
+	}
+	allowed := namespaceSet{}
+	for _, ns := range routerConfig.Namespaces {
+		allowed[ns] = true
+	}
+	if routerConfig.NamespaceSelector == "" {
+		return allowed, nil
+	}
+	selector, err := labels.Parse(routerConfig.NamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace selector \"%s\": %v", routerConfig.NamespaceSelector, err)
+	}
+	namespaces, err := kubeClient.Namespaces().List(api.ListOptions{LabelSelector: selector})
 	if err != nil {
-		statusErr, ok := err.(*errors.StatusError)
-		// If the issue is just that no deis-builder was found, that's ok.
-		if ok && statusErr.Status().Code == 404 {
-			// We'll just return nil instead of a found *api.Service.
-			return nil, nil
-		}
 		return nil, err
 	}
-	return service, nil
-}
+	selected := namespaceSet{}
+	for _, ns := range namespaces.Items {
+		if len(routerConfig.Namespaces) == 0 || allowed[ns.Name] {
+			selected[ns.Name] = true
+		}
+	}
+	return selected, nil
+}
+
+func getIngresses(kubeClient *kubernetes.Clientset) (*v1beta1ext.IngressList, error) {
+	ingressClient := kubeClient.Extensions().Ingresses(api.NamespaceAll)
+	ingresses, err := ingressClient.List(api.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return ingresses, nil
+}
+
+// getService will return the named service from the specified namespace, but will return nil
+// (without error) if no such service exists.
+func getService(kubeClient *kubernetes.Clientset, name string, ns string) (*v1.Service, error) {
+	serviceClient := kubeClient.Services(ns)
+	service, err := serviceClient.Get(name)
+	if err != nil {
+		statusErr, ok := err.(*errors.StatusError)
+		// If the issue is just that no such service was found, that's ok.
+		if ok && statusErr.Status().Code == 404 {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return service, nil
+}
+
+// getBuilderService will return the service named "deis-builder" from the same namespace as
+// the router, but will return nil (without error) if no such service exists.
+func getBuilderService(kubeClient *kubernetes.Clientset) (*v1.Service, error) {
+	serviceClient := kubeClient.Services(namespace)
+	service, err := serviceClient.Get("deis-builder")
+	if err != nil {
+		statusErr, ok := err.(*errors.StatusError)
+		// If the issue is just that no deis-builder was found, that's ok.
+		if ok && statusErr.Status().Code == 404 {
+			// We'll just return nil instead of a found *api.Service.
+			return nil, nil
+		}
+		return nil, err
+	}
+	return service, nil
+}
+
+func getConfigMap(kubeClient *kubernetes.Clientset, name string, ns string) (*v1.ConfigMap, error) {
+	configMap, err := kubeClient.ConfigMaps(ns).Get(name)
+	if err != nil {
+		statusErr, ok := err.(*errors.StatusError)
+		// As with secrets, a config map that does not exist is not an error.
+		if ok && statusErr.Status().Code == 404 {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return configMap, nil
+}
 
 func getSecret(kubeClient *kubernetes.Clientset, name string, ns string) (*v1.Secret, error) {
 	secretClient := kubeClient.Secrets(ns)
@@ -299,14 +1639,63 @@ func build(kubeClient *kubernetes.Clientset, routerDeployment *v1beta1ext.Deploy
 	if err != nil {
 		return nil, err
 	}
+	if routerConfig.SSLConfig.ECH {
+		echSecret, err := getSecret(kubeClient, "deis-router-ech", namespace)
+		if err != nil {
+			return nil, err
+		}
+		routerConfig.SSLConfig.ECHKeys = buildECHKeys(echSecret)
+	}
+	if err := resolveIPRangeSources(kubeClient, routerConfig); err != nil {
+		return nil, err
+	}
+	publishingNamespaces, err := getPublishingNamespaces(kubeClient, routerConfig)
+	if err != nil {
+		return nil, err
+	}
 	for _, appService := range appServices.Items {
+		if !publishingNamespaces.admits(appService.Namespace) || !isInRoutingClass(appService.ObjectMeta, routerConfig.RoutingClass) {
+			continue
+		}
 		appConfig, err := buildAppConfig(kubeClient, appService, routerConfig)
-		if err != nil {
+		if invalidErr, ok := err.(*invalidResourceError); ok {
+			// Only the offending application is left out of the router's configuration.
+			routerConfig.warn(invalidErr.warning)
+			continue
+		} else if err != nil {
 			return nil, err
 		}
 		if appConfig != nil {
 			routerConfig.AppConfigs = append(routerConfig.AppConfigs, appConfig)
 		}
+		streamConfigs, err := buildStreamConfigs(kubeClient, appService, routerConfig)
+		if invalidErr, ok := err.(*invalidResourceError); ok {
+			routerConfig.warn(invalidErr.warning)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		addStreamConfigs(routerConfig, streamConfigs)
+	}
+	// Ingresses are only considered if the router has been configured to claim a class of them.
+	if routerConfig.IngressClass != "" {
+		ingresses, err := getIngresses(kubeClient)
+		if err != nil {
+			return nil, err
+		}
+		for _, ingress := range ingresses.Items {
+			if !isClaimedIngress(ingress, routerConfig.IngressClass) || !publishingNamespaces.admits(ingress.Namespace) || !isInRoutingClass(ingress.ObjectMeta, routerConfig.RoutingClass) {
+				continue
+			}
+			appConfigs, err := buildIngressAppConfigs(kubeClient, ingress, routerConfig)
+			if invalidErr, ok := err.(*invalidResourceError); ok {
+				routerConfig.warn(invalidErr.warning)
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			routerConfig.AppConfigs = append(routerConfig.AppConfigs, appConfigs...)
+		}
 	}
 	if builderService != nil {
 		builderConfig, err := buildBuilderConfig(builderService)
@@ -317,20 +1706,40 @@ func build(kubeClient *kubernetes.Clientset, routerDeployment *v1beta1ext.Deploy
 			routerConfig.BuilderConfig = builderConfig
 		}
 	}
+	if err := resolveDefaultBackend(kubeClient, routerConfig); err != nil {
+		return nil, err
+	}
+	lint(routerConfig)
+	checkCertificateExpiry(routerConfig, time.Now())
 	return routerConfig, nil
 }
 
 func buildRouterConfig(routerDeployment *v1beta1.Deployment, platformCertSecret *v1.Secret, dhParamSecret *v1.Secret) (*RouterConfig, error) {
 	routerConfig := newRouterConfig()
-	err := modeler.MapToModel(routerDeployment.Annotations, "nginx", routerConfig)
+	err := mapAnnotations(routerConfig, "Deployment", routerDeployment.ObjectMeta, "nginx", routerConfig)
 	if err != nil {
 		return nil, err
 	}
+	err = mapAnnotations(routerConfig, "Deployment", routerDeployment.ObjectMeta, "", routerConfig.EmergencyConfig)
+	if err != nil {
+		return nil, err
+	}
+	if routerConfig.EmergencyConfig.Mode == "allowlist-only" && len(routerConfig.EmergencyConfig.Allowlist) == 0 {
+		routerConfig.EmergencyConfig.Allowlist = routerConfig.DefaultWhitelist
+	}
+	if routerConfig.EmergencyConfig.Mode != "off" {
+		log.Printf("WARN: Emergency mode \"%s\" is in effect for all applications.\n", routerConfig.EmergencyConfig.Mode)
+	}
 	if platformCertSecret != nil {
 		platformCertificate, err := buildCertificate(platformCertSecret, "platform")
 		if err != nil {
 			return nil, err
 		}
+		if platformCertificate == nil {
+			warnInvalidCertificate(routerConfig, platformCertSecret, "the platform domain")
+		} else {
+			platformCertificate.Name = "platform"
+		}
 		routerConfig.PlatformCertificate = platformCertificate
 	}
 	if dhParamSecret != nil {
@@ -340,7 +1749,16 @@ func buildRouterConfig(routerDeployment *v1beta1.Deployment, platformCertSecret
 		}
 		routerConfig.SSLConfig.DHParam = dhParam
 	}
+	if routerConfig.GeoIPDatabase != "" {
+		if _, err := os.Stat(routerConfig.GeoIPDatabase); err != nil {
+			routerConfig.GeoIPDatabase = ""
+		}
+	}
 	routerConfig.SSLConfig.Enforce = strings.ToLower(routerConfig.SSLConfig.Enforce)
+	routerConfig.ListenerConfigs = buildListenerConfigs(routerDeployment.Annotations, routerConfig)
+	routerConfig.IPRangeSources = buildIPRangeSources(routerDeployment.Annotations)
+	routerConfig.HTTPSnippet = validateSnippet(routerConfig.HTTPSnippet, "router", "http")
+	routerConfig.PlatformDomain = normalizeDomain(routerConfig.PlatformDomain)
 	for i, certBase64ed := range routerConfig.ClientCertificates {
 		certBytes, err := base64.StdEncoding.DecodeString(certBase64ed)
 		if err != nil {
@@ -355,6 +1773,9 @@ func buildRouterConfig(routerDeployment *v1beta1.Deployment, platformCertSecret
 
 func buildAppConfig(kubeClient *kubernetes.Clientset, service v1.Service, routerConfig *RouterConfig) (*AppConfig, error) {
 	appConfig := newAppConfig(routerConfig)
+	appConfig.Namespace = service.Namespace
+	appConfig.ResourceKind = "Service"
+	appConfig.ResourceName = service.Name
 	appConfig.Name = service.Labels["app"]
 	// If we didn't get the app name from the app label, fall back to inferring the app name from
 	// the service's own name.
@@ -366,7 +1787,7 @@ func buildAppConfig(kubeClient *kubernetes.Clientset, service v1.Service, router
 	if appConfig.Name != service.Namespace {
 		appConfig.Name = service.Namespace + "/" + appConfig.Name
 	}
-	err := modeler.MapToModel(service.Annotations, "", appConfig)
+	err := mapAnnotations(routerConfig, "Service", service.ObjectMeta, "", appConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -375,26 +1796,42 @@ func buildAppConfig(kubeClient *kubernetes.Clientset, service v1.Service, router
 	if len(appConfig.Domains) == 0 {
 		return nil, nil
 	}
+	normalizeDomains(appConfig)
 	// Step through the domains, and decide which cert, if any, will be used for securing each.
 	// For each that is a FQDN, we'll look to see if a corresponding cert-bearing secret also
 	// exists.  If so, that will be used.  If a domain isn't an FQDN we will use the default cert--
 	// even if that is nil.
+	// A secret mapped to several domains, whether individually or by a wildcard, is fetched once,
+	// and its certificate is shared by all of them.
+	mappedCertificates := map[string]*Certificate{}
 	for _, domain := range appConfig.Domains {
 		if strings.Contains(domain, ".") {
-			// Look for a cert-bearing secret for this domain.
-			if certMapping, ok := appConfig.CertMappings[domain]; ok {
-				secretName := fmt.Sprintf("%s-cert", certMapping)
-				certSecret, err := getSecret(kubeClient, secretName, service.Namespace)
-				if err != nil {
-					return nil, err
-				}
-				if certSecret != nil {
-					certificate, err := buildCertificate(certSecret, domain)
+			// Look for a cert-bearing secret for this domain, or for a wildcard covering it.
+			if certDomain, secretName, ok := findCertSecret(appConfig, domain); ok {
+				certificate, fetched := mappedCertificates[secretName]
+				if !fetched {
+					certSecret, err := getSecret(kubeClient, secretName, service.Namespace)
 					if err != nil {
 						return nil, err
 					}
+					if certSecret != nil {
+						certificate, err = buildCertificate(certSecret, certDomain)
+						if err != nil {
+							return nil, err
+						}
+						if certificate == nil {
+							warnInvalidCertificate(routerConfig, certSecret, certDomain)
+						} else {
+							certificate.Name = certDomain
+						}
+					}
+					mappedCertificates[secretName] = certificate
+				}
+				if certificate != nil {
 					appConfig.Certificates[domain] = certificate
 				}
+			} else if err := addACMECertificate(kubeClient, appConfig, domain); err != nil {
+				return nil, err
 			}
 		} else {
 			appConfig.Certificates[domain] = routerConfig.PlatformCertificate
@@ -407,10 +1844,937 @@ func buildAppConfig(kubeClient *kubernetes.Clientset, service v1.Service, router
 		return nil, err
 	}
 	appConfig.Available = len(endpoints.Subsets) > 0 && len(endpoints.Subsets[0].Addresses) > 0
+	appConfig.Endpoints = buildEndpoints(&service, endpoints, appConfig.ServicePort)
+	if err := resolveCanary(kubeClient, service.Namespace, appConfig); err != nil {
+		return nil, err
+	}
+	frontExternalOrigin(appConfig)
+	resolveBackupOrigin(appConfig)
+	if err := resolveClientVerifications(kubeClient, "Service", service.ObjectMeta, appConfig); err != nil {
+		return nil, err
+	}
+	if err := resolveBasicAuth(kubeClient, "Service", service.ObjectMeta, appConfig); err != nil {
+		return nil, err
+	}
+	if err := resolveErrorPages(kubeClient, routerConfig, "Service", service.ObjectMeta, appConfig); err != nil {
+		return nil, err
+	}
+	if err := resolveWAFRules(kubeClient, routerConfig, "Service", service.ObjectMeta, appConfig); err != nil {
+		return nil, err
+	}
 	appConfig.SSLConfig.Enforce = strings.ToLower(appConfig.SSLConfig.Enforce)
+	activateDebugBody(appConfig, time.Now())
+	validateRateLimitResponse(appConfig)
+	validateBodySizeResponse(appConfig)
+	validateSnippets(appConfig)
+	validateDenylist(routerConfig, "Service", service.ObjectMeta, appConfig)
+	validateWhitelistSources(routerConfig, "Service", service.ObjectMeta, appConfig)
+	validateCDN(routerConfig, "Service", service.ObjectMeta, appConfig)
+	resolveTransforms(appConfig)
+	appConfig.Locations = buildLocationConfigs(service.Annotations, appConfig)
+	if err := resolveLocationBackends(kubeClient, service.Namespace, appConfig); err != nil {
+		return nil, err
+	}
 	return appConfig, nil
 }
 
+// resolveTransforms carries the options of the provided application's transformations that nginx
+// implements natively over to the application's own.  Its path prefixes become the application's,
+// unless it sets either of its own, so that its locations inherit them, and JSON errors set its
+// error format.
+func resolveTransforms(appConfig *AppConfig) {
+	transformConfig := appConfig.TransformConfig
+	if transformConfig.JSONErrors {
+		appConfig.ErrorFormat = "json"
+	}
+	if !transformConfig.RewritesURI() || appConfig.StripPrefix != "" || appConfig.AddPrefix != "" {
+		return
+	}
+	appConfig.StripPrefix = transformConfig.StripPrefix
+	appConfig.AddPrefix = transformConfig.AddPrefix
+}
+
+// activateDebugBody enables request body logging for the provided application if it was requested
+// and the requested time limit has not yet passed.  Redaction patterns that are not valid regular
+// expressions are logged and skipped, since even one would prevent nginx from loading its
+// configuration.
+func activateDebugBody(appConfig *AppConfig, now time.Time) {
+	debugBodyConfig := appConfig.DebugBodyConfig
+	if debugBodyConfig.Until == "" {
+		return
+	}
+	until, err := time.Parse(time.RFC3339, debugBodyConfig.Until)
+	if err != nil {
+		log.Printf("WARN: Failed to parse debug body expiry \"%s\" for app \"%s\": %v -- not logging request bodies.\n", debugBodyConfig.Until, appConfig.Name, err)
+		return
+	}
+	debugBodyConfig.Enabled = now.Before(until)
+	redactPatterns := []string{}
+	for _, pattern := range debugBodyConfig.RedactPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			log.Printf("WARN: Invalid debug body redaction pattern \"%s\" for app \"%s\": %v -- skipping this pattern.\n", pattern, appConfig.Name, err)
+			continue
+		}
+		redactPatterns = append(redactPatterns, pattern)
+	}
+	debugBodyConfig.RedactPatterns = redactPatterns
+}
+
+// validateSnippets discards any of the application's configuration snippets that are malformed.
+func validateSnippets(appConfig *AppConfig) {
+	appConfig.ServerSnippet = validateSnippet(appConfig.ServerSnippet, fmt.Sprintf("app \"%s\"", appConfig.Name), "server")
+	appConfig.LocationSnippet = validateSnippet(appConfig.LocationSnippet, fmt.Sprintf("app \"%s\"", appConfig.Name), "location")
+}
+
+// validateSnippet returns the provided configuration snippet if it is well formed, or an empty string
+// otherwise.  A well formed snippet has balanced braces and quotes and ends with a complete
+// directive, so that it cannot disturb the structure of the configuration around it.  Whether its
+// directives are valid is left to nginx.
+func validateSnippet(snippet string, owner string, context string) string {
+	if err := checkSnippet(snippet); err != nil {
+		log.Printf("WARN: The %s snippet for %s is malformed: %v -- ignoring it.\n", context, owner, err)
+		return ""
+	}
+	return snippet
+}
+
+func checkSnippet(snippet string) error {
+	depth := 0
+	var quote rune
+	escaped := false
+	comment := false
+	last := ' '
+	for _, c := range snippet {
+		switch {
+		case comment:
+			if c == '\n' {
+				comment = false
+			}
+			continue
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			comment = true
+			continue
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth < 0 {
+				return fmt.Errorf("unexpected \"}\"")
+			}
+		}
+		if !unicode.IsSpace(c) {
+			last = c
+		}
+	}
+	if quote != 0 {
+		return fmt.Errorf("unterminated quoted string")
+	}
+	if depth > 0 {
+		return fmt.Errorf("unterminated block")
+	}
+	if last != ' ' && last != ';' && last != '}' {
+		return fmt.Errorf("incomplete directive")
+	}
+	return nil
+}
+
+// validateDenylist discards, with a warning, each entry of the application's denylist that is
+// neither an IP address nor a CIDR range, since even one would prevent nginx from loading its
+// configuration.  The remaining entries are still honored.
+func validateDenylist(routerConfig *RouterConfig, kind string, meta v1.ObjectMeta, appConfig *AppConfig) {
+	if appConfig.Denylist == nil {
+		return
+	}
+	denylist := []string{}
+	for _, entry := range appConfig.Denylist {
+		if entry == "" {
+			continue
+		}
+		if !isAddressOrCIDR(entry) {
+			routerConfig.warn(Warning{
+				Kind:      kind,
+				Namespace: meta.Namespace,
+				Name:      meta.Name,
+				Reason:    "InvalidAnnotation",
+				Message:   fmt.Sprintf("Denylist entry \"%s\" is not an IP address or CIDR range and was ignored.", entry),
+			})
+			continue
+		}
+		denylist = append(denylist, entry)
+	}
+	appConfig.Denylist = denylist
+}
+
+// isAddressOrCIDR returns a bool indicating whether the provided value is an IPv4 or IPv6 address
+// or CIDR range.
+func isAddressOrCIDR(value string) bool {
+	if _, _, err := net.ParseCIDR(value); err == nil {
+		return true
+	}
+	return net.ParseIP(value) != nil
+}
+
+// validateRateLimitResponse ensures that any custom body to be sent in response to requests rejected
+// by rate or connection limiting is valid JSON.  If it is not, the body is logged and discarded.
+func validateRateLimitResponse(appConfig *AppConfig) {
+	rateLimitResponseConfig := appConfig.RateLimitResponseConfig
+	if rateLimitResponseConfig.Body == "" {
+		return
+	}
+	var body interface{}
+	if err := json.Unmarshal([]byte(rateLimitResponseConfig.Body), &body); err != nil {
+		log.Printf("WARN: Rate limit response body for app \"%s\" is not valid JSON: %v -- using the default body.\n", appConfig.Name, err)
+		rateLimitResponseConfig.Body = ""
+	}
+}
+
+// validateBodySizeResponse ensures that any custom body to be sent in response to requests whose
+// bodies are too large is valid JSON.  If it is not, the body is logged and discarded.
+func validateBodySizeResponse(appConfig *AppConfig) {
+	bodySizeResponseConfig := appConfig.BodySizeResponseConfig
+	if bodySizeResponseConfig.Body == "" {
+		return
+	}
+	var body interface{}
+	if err := json.Unmarshal([]byte(bodySizeResponseConfig.Body), &body); err != nil {
+		log.Printf("WARN: Body size response body for app \"%s\" is not valid JSON: %v -- using the default body.\n", appConfig.Name, err)
+		bodySizeResponseConfig.Body = ""
+	}
+}
+
+// buildLocationConfigs parses the structured locations annotation, if present, into a slice of
+// LocationConfigs.  Any problem found is logged and the offending location (or the entire
+// annotation, if it cannot be parsed at all) is skipped so that one typo cannot break routing for
+// the application as a whole.
+func buildLocationConfigs(annotations map[string]string, appConfig *AppConfig) []*LocationConfig {
+	locationsJSON, ok := annotations[fmt.Sprintf("%s/nginx.locations", prefix)]
+	if !ok {
+		return nil
+	}
+	var rawLocations []map[string]string
+	if err := json.Unmarshal([]byte(locationsJSON), &rawLocations); err != nil {
+		log.Printf("WARN: Failed to parse locations for app \"%s\": %v -- skipping all locations.\n", appConfig.Name, err)
+		return nil
+	}
+	locations := []*LocationConfig{}
+	paths := make(map[string]bool, len(rawLocations))
+	for _, rawLocation := range rawLocations {
+		location := newLocationConfig(appConfig)
+		if err := locationModeler.MapToModel(rawLocation, "", location); err != nil {
+			log.Printf("WARN: Failed to model location for app \"%s\": %v -- skipping this location.\n", appConfig.Name, err)
+			continue
+		}
+		if location.Path == "" {
+			log.Printf("WARN: A location for app \"%s\" has a missing or invalid path -- skipping this location.\n", appConfig.Name)
+			continue
+		}
+		if paths[location.Path] {
+			log.Printf("WARN: The location \"%s\" for app \"%s\" is defined more than once -- skipping the duplicate.\n", location.Path, appConfig.Name)
+			continue
+		}
+		paths[location.Path] = true
+		locations = append(locations, location)
+	}
+	return locations
+}
+
+// buildListenerConfigs parses the structured listeners annotation, if present, into a slice of
+// ListenerConfigs.  As with locations, any problem found is logged and the offending listener (or
+// the entire annotation, if it cannot be parsed at all) is skipped.
+func buildListenerConfigs(annotations map[string]string, routerConfig *RouterConfig) []*ListenerConfig {
+	listenersJSON, ok := annotations[fmt.Sprintf("%s/nginx.listeners", prefix)]
+	if !ok {
+		return nil
+	}
+	var rawListeners []map[string]string
+	if err := json.Unmarshal([]byte(listenersJSON), &rawListeners); err != nil {
+		log.Printf("WARN: Failed to parse the router's listeners: %v -- skipping all listeners.\n", err)
+		return nil
+	}
+	listeners := []*ListenerConfig{}
+	ports := make(map[int]bool, len(rawListeners))
+	for _, rawListener := range rawListeners {
+		listener := newListenerConfig(routerConfig)
+		if err := locationModeler.MapToModel(rawListener, "", listener); err != nil {
+			log.Printf("WARN: Failed to model a listener for the router: %v -- skipping this listener.\n", err)
+			continue
+		}
+		if listener.Port < 1024 || listener.Port > 65535 || reservedStreamPorts[listener.Port] {
+			log.Printf("WARN: A listener for the router has a missing or unavailable port -- skipping this listener.\n")
+			continue
+		}
+		if ports[listener.Port] {
+			log.Printf("WARN: The router's listener on port %d is defined more than once -- skipping the duplicate.\n", listener.Port)
+			continue
+		}
+		ports[listener.Port] = true
+		listeners = append(listeners, listener)
+	}
+	return listeners
+}
+
+// resolveLocationBackends resolves the backend of each of an application's locations that routes to
+// a service other than the application's own.
+func resolveLocationBackends(kubeClient *kubernetes.Clientset, ns string, appConfig *AppConfig) error {
+	for _, location := range appConfig.Locations {
+		if len(location.Weights) > 0 {
+			location.ExternalOrigin = ""
+			location.BackupOrigin = ""
+			if err := resolveWeightedBackends(kubeClient, ns, appConfig, location); err != nil {
+				return err
+			}
+			continue
+		}
+		if location.BackendService == "" {
+			continue
+		}
+		var err error
+		location.ServiceIP, location.ServicePort, location.Available, location.Endpoints, err = resolveBackend(kubeClient, ns, location.BackendService, parseServicePort(location.BackendPort))
+		if err != nil {
+			return err
+		}
+		location.Canary = nil
+		location.ExternalOrigin = ""
+		location.BackupOrigin = ""
+	}
+	return nil
+}
+
+// resolveWeightedBackends resolves each of the services among which a location's requests are split.
+// Services that are not ready, or whose weight is zero, receive none of them.  The location is only
+// available if at least one service is to receive its requests.
+func resolveWeightedBackends(kubeClient *kubernetes.Clientset, ns string, appConfig *AppConfig, location *LocationConfig) error {
+	services := make([]string, 0, len(location.Weights))
+	for service := range location.Weights {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	location.WeightedBackends = []*WeightedBackend{}
+	for _, service := range services {
+		weight, err := strconv.Atoi(location.Weights[service])
+		if err != nil || weight == 0 {
+			continue
+		}
+		serviceIP, servicePort, available, endpoints, err := resolveBackend(kubeClient, ns, service, parseServicePort(location.BackendPort))
+		if err != nil {
+			return err
+		}
+		if !available {
+			log.Printf("WARN: Service \"%s/%s\" for location \"%s\" of app \"%s\" is not ready -- sending it no traffic.\n", ns, service, location.Path, appConfig.Name)
+			continue
+		}
+		location.WeightedBackends = append(location.WeightedBackends, &WeightedBackend{
+			Service:     service,
+			Weight:      weight,
+			ServiceIP:   serviceIP,
+			ServicePort: servicePort,
+			Endpoints:   endpoints,
+		})
+	}
+	location.Available = len(location.WeightedBackends) > 0
+	location.Endpoints = nil
+	location.Canary = nil
+	return nil
+}
+
+// resolveClientVerifications determines how clients of each of the application's domains are to be
+// verified.  An application that requires verification, but whose CA bundle cannot be found, is
+// invalid, since routing to it without verifying clients would bypass its protection.
+func resolveClientVerifications(kubeClient *kubernetes.Clientset, kind string, meta v1.ObjectMeta, appConfig *AppConfig) error {
+	clientCertConfig := appConfig.ClientCertConfig
+	if clientCertConfig == nil || clientCertConfig.Secret == "" {
+		return nil
+	}
+	caSecret, err := getSecret(kubeClient, clientCertConfig.Secret, appConfig.Namespace)
+	if err != nil {
+		return err
+	}
+	var caBundle []byte
+	if caSecret != nil {
+		caBundle = caSecret.Data["ca.crt"]
+	}
+	if len(caBundle) == 0 {
+		return &invalidResourceError{warning: Warning{
+			Kind:      kind,
+			Namespace: meta.Namespace,
+			Name:      meta.Name,
+			Reason:    "InvalidClientCA",
+			Message:   fmt.Sprintf("Client certificates are required, but the secret \"%s\" does not exist or has no \"ca.crt\" entry, so the resource is not routable.", clientCertConfig.Secret),
+		}}
+	}
+	appConfig.ClientVerifications = buildClientVerifications(appConfig, string(caBundle))
+	return nil
+}
+
+// buildClientVerifications returns the client certificate verification in effect for each of the
+// application's domains, omitting those for which verification is off.
+func buildClientVerifications(appConfig *AppConfig, caBundle string) map[string]*ClientVerification {
+	clientCertConfig := appConfig.ClientCertConfig
+	domainVerify := map[string]string{}
+	for domain, verify := range clientCertConfig.DomainVerify {
+		domainVerify[normalizeDomain(domain)] = verify
+	}
+	domainDepth := map[string]string{}
+	for domain, depth := range clientCertConfig.DomainDepth {
+		domainDepth[normalizeDomain(domain)] = depth
+	}
+	clientVerifications := map[string]*ClientVerification{}
+	for _, domain := range appConfig.Domains {
+		clientVerification := &ClientVerification{
+			CABundle: caBundle,
+			Verify:   clientCertConfig.Verify,
+			Depth:    clientCertConfig.Depth,
+		}
+		if verify, ok := domainVerify[domain]; ok {
+			clientVerification.Verify = verify
+		}
+		if depth, ok := domainDepth[domain]; ok {
+			clientVerification.Depth = depth
+		}
+		if clientVerification.Verify != "off" {
+			clientVerifications[domain] = clientVerification
+		}
+	}
+	return clientVerifications
+}
+
+// resolveBasicAuth resolves the credentials with which clients of the application must
+// authenticate, if any.  An application that requires authentication, but whose credentials
+// cannot be found, is invalid, since routing to it without authentication would expose it.
+func resolveBasicAuth(kubeClient *kubernetes.Clientset, kind string, meta v1.ObjectMeta, appConfig *AppConfig) error {
+	if appConfig.BasicAuthSecret == "" {
+		return nil
+	}
+	authSecret, err := getSecret(kubeClient, appConfig.BasicAuthSecret, appConfig.Namespace)
+	if err != nil {
+		return err
+	}
+	var users []string
+	if authSecret != nil {
+		users = buildBasicAuthUsers(authSecret)
+	}
+	if len(users) == 0 {
+		return &invalidResourceError{warning: Warning{
+			Kind:      kind,
+			Namespace: meta.Namespace,
+			Name:      meta.Name,
+			Reason:    "InvalidBasicAuth",
+			Message:   fmt.Sprintf("Basic authentication is required, but the secret \"%s\" does not exist or holds no valid credentials, so the resource is not routable.", appConfig.BasicAuthSecret),
+		}}
+	}
+	appConfig.BasicAuthUsers = users
+	return nil
+}
+
+// buildBasicAuthUsers returns htpasswd entries for the credentials held by the provided secret.  A
+// secret with an "auth" entry is taken to hold an htpasswd file.  Otherwise, each of the secret's
+// entries is taken to be a username and password.  Malformed entries are logged and skipped.
+func buildBasicAuthUsers(authSecret *v1.Secret) []string {
+	users := []string{}
+	if htpasswd, ok := authSecret.Data["auth"]; ok {
+		for _, line := range strings.Split(string(htpasswd), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if i := strings.Index(line, ":"); i < 1 || i == len(line)-1 {
+				log.Printf("WARN: The k8s secret \"%s/%s\" contains a malformed htpasswd entry -- skipping this entry.\n", authSecret.Namespace, authSecret.Name)
+				continue
+			}
+			users = append(users, line)
+		}
+		return users
+	}
+	usernames := []string{}
+	for username := range authSecret.Data {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	for _, username := range usernames {
+		password := string(authSecret.Data[username])
+		if strings.Contains(username, ":") || password == "" || strings.ContainsAny(password, "\r\n") {
+			log.Printf("WARN: The k8s secret \"%s/%s\" contains invalid credentials for user \"%s\" -- skipping this user.\n", authSecret.Namespace, authSecret.Name, username)
+			continue
+		}
+		users = append(users, fmt.Sprintf("%s:{PLAIN}%s", username, password))
+	}
+	return users
+}
+
+// resolveErrorPages resolves the pages with which the application's error responses are replaced,
+// if any.  Since the application remains routable without them, a missing config map is warned
+// about, and nginx's own error pages are served instead.
+func resolveErrorPages(kubeClient *kubernetes.Clientset, routerConfig *RouterConfig, kind string, meta v1.ObjectMeta, appConfig *AppConfig) error {
+	if appConfig.ErrorPagesConfigMap == "" {
+		return nil
+	}
+	configMap, err := getConfigMap(kubeClient, appConfig.ErrorPagesConfigMap, appConfig.Namespace)
+	if err != nil {
+		return err
+	}
+	if configMap == nil {
+		routerConfig.warn(Warning{
+			Kind:      kind,
+			Namespace: meta.Namespace,
+			Name:      meta.Name,
+			Reason:    "InvalidErrorPages",
+			Message:   fmt.Sprintf("The config map \"%s\" holding custom error pages does not exist, so default error pages are served.", appConfig.ErrorPagesConfigMap),
+		})
+		return nil
+	}
+	appConfig.ErrorPages = buildErrorPages(routerConfig, kind, meta, configMap)
+	return nil
+}
+
+// buildErrorPages returns the error pages held by the provided config map, keyed by status.  Keys
+// that are not 4xx or 5xx statuses are warned about and skipped.
+func buildErrorPages(routerConfig *RouterConfig, kind string, meta v1.ObjectMeta, configMap *v1.ConfigMap) map[string]string {
+	errorPages := make(map[string]string, len(configMap.Data))
+	for status, page := range configMap.Data {
+		if !errorStatusRegexp.MatchString(status) {
+			routerConfig.warn(Warning{
+				Kind:      kind,
+				Namespace: meta.Namespace,
+				Name:      meta.Name,
+				Reason:    "InvalidErrorPages",
+				Message:   fmt.Sprintf("The config map \"%s\" holds a page for \"%s\", which is not a 4xx or 5xx status, so it is ignored.", configMap.Name, status),
+			})
+			continue
+		}
+		errorPages[status] = page
+	}
+	return errorPages
+}
+
+// resolveWAFRules resolves the custom ModSecurity rules applied to the application's requests, if
+// it names a config map holding them and its requests are inspected at all.
+func resolveWAFRules(kubeClient *kubernetes.Clientset, routerConfig *RouterConfig, kind string, meta v1.ObjectMeta, appConfig *AppConfig) error {
+	wafConfig := appConfig.WAFConfig
+	if !wafConfig.Enabled || wafConfig.RulesConfigMap == "" {
+		return nil
+	}
+	configMap, err := getConfigMap(kubeClient, wafConfig.RulesConfigMap, appConfig.Namespace)
+	if err != nil {
+		return err
+	}
+	if configMap == nil {
+		routerConfig.warn(Warning{
+			Kind:      kind,
+			Namespace: meta.Namespace,
+			Name:      meta.Name,
+			Reason:    "InvalidWAFRules",
+			Message:   fmt.Sprintf("The config map \"%s\" holding custom WAF rules does not exist, so only the Core Rule Set is applied.", wafConfig.RulesConfigMap),
+		})
+		return nil
+	}
+	wafConfig.Rules = configMap.Data
+	return nil
+}
+
+// resolveDefaultBackend resolves the service to which requests for unclaimed domains are proxied, if
+// one is named.  If it cannot be resolved, such requests are answered with a 404 as usual.
+func resolveDefaultBackend(kubeClient *kubernetes.Clientset, routerConfig *RouterConfig) error {
+	if routerConfig.DefaultBackendService == "" {
+		return nil
+	}
+	parts := strings.SplitN(routerConfig.DefaultBackendService, "/", 2)
+	serviceIP, servicePort, available, endpoints, err := resolveBackend(kubeClient, parts[0], parts[1], parseServicePort(routerConfig.DefaultBackendPort))
+	if err != nil {
+		return err
+	}
+	if serviceIP == "" {
+		routerConfig.warn(Warning{
+			Kind:      "Deployment",
+			Namespace: namespace,
+			Name:      "deis-router",
+			Reason:    "InvalidDefaultBackend",
+			Message:   fmt.Sprintf("The default backend service \"%s\" does not exist or exposes no port matching \"%s\", so requests for unclaimed domains are answered with a 404.", routerConfig.DefaultBackendService, routerConfig.DefaultBackendPort),
+		})
+		return nil
+	}
+	routerConfig.DefaultBackend = &DefaultBackend{
+		ServiceIP:   serviceIP,
+		ServicePort: servicePort,
+		Available:   available,
+		Endpoints:   endpoints,
+	}
+	return nil
+}
+
+// HTTP2Backend returns whether the application's endpoints are spoken to in HTTP/2, as those of gRPC
+// and h2c applications are, in which case its requests are proxied with nginx's grpc module.
+func (appConfig *AppConfig) HTTP2Backend() bool {
+	return appConfig.BackendProtocol == "grpc" || appConfig.BackendProtocol == "grpcs" || appConfig.BackendProtocol == "h2c"
+}
+
+// frontExternalOrigin routes the application's requests to its external origin, if it has one,
+// rather than to its service, which typically has no pods at all.  The origin is presumed
+// available, and a canary, whose share of requests would otherwise bypass the origin, is dropped, as
+// is any backup origin.  Applications spoken to in HTTP/2 are never fronted this way.
+func frontExternalOrigin(appConfig *AppConfig) {
+	if appConfig.ExternalOrigin == "" || appConfig.HTTP2Backend() {
+		return
+	}
+	appConfig.Available = true
+	appConfig.Endpoints = nil
+	appConfig.Canary = nil
+	appConfig.BackupOrigin = ""
+}
+
+// resolveBackupOrigin completes the application's backup origin, if it has one, with the default
+// port of its backend protocol.  Since requests fail over to the origin, the application is
+// available even if none of its endpoints is ready.
+func resolveBackupOrigin(appConfig *AppConfig) {
+	if appConfig.BackupOrigin == "" {
+		return
+	}
+	if !strings.Contains(appConfig.BackupOrigin, ":") {
+		port := "80"
+		if appConfig.BackendProtocol == "https" || appConfig.BackendProtocol == "grpcs" {
+			port = "443"
+		}
+		appConfig.BackupOrigin = net.JoinHostPort(appConfig.BackupOrigin, port)
+	}
+	appConfig.Available = true
+}
+
+// resolveCanary resolves the application's canary service, if it has one and is to receive any
+// traffic.  A canary without ready endpoints receives no traffic.
+func resolveCanary(kubeClient *kubernetes.Clientset, ns string, appConfig *AppConfig) error {
+	if appConfig.CanaryService == "" || appConfig.CanaryWeight == 0 {
+		return nil
+	}
+	serviceIP, servicePort, available, endpoints, err := resolveBackend(kubeClient, ns, appConfig.CanaryService, parseServicePort(appConfig.CanaryServicePort))
+	if err != nil {
+		return err
+	}
+	if !available {
+		log.Printf("WARN: Canary service \"%s/%s\" for app \"%s\" is not ready -- sending it no traffic.\n", ns, appConfig.CanaryService, appConfig.Name)
+		return nil
+	}
+	appConfig.Canary = &CanaryBackend{
+		ServiceIP:   serviceIP,
+		ServicePort: servicePort,
+		Endpoints:   endpoints,
+	}
+	return nil
+}
+
+// parseServicePort interprets the provided string as either a service port's number or its name.
+func parseServicePort(servicePort string) intstr.IntOrString {
+	if port, err := strconv.Atoi(servicePort); err == nil {
+		return intstr.FromInt(port)
+	}
+	return intstr.FromString(servicePort)
+}
+
+func hasLocation(appConfig *AppConfig, path string) bool {
+	for _, location := range appConfig.Locations {
+		if location.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// findCertSecret returns the domain whose certificate mapping applies to the provided domain of the
+// provided application, and the name of the secret it maps to.  A mapping for the domain itself
+// takes precedence over one for a wildcard that covers it, and a standard TLS secret over a Deis
+// certificate secret.
+func findCertSecret(appConfig *AppConfig, domain string) (string, string, bool) {
+	for _, certDomain := range []string{domain, wildcardDomain(domain)} {
+		if certDomain == "" {
+			continue
+		}
+		if secretName, ok := appConfig.TLSSecrets[certDomain]; ok {
+			return certDomain, secretName, true
+		}
+		if certMapping, ok := appConfig.CertMappings[certDomain]; ok {
+			return certDomain, fmt.Sprintf("%s-cert", certMapping), true
+		}
+	}
+	return "", "", false
+}
+
+// wildcardDomain returns the wildcard domain that covers the provided domain, as a certificate for
+// it would, or the empty string if there is none.  A wildcard covers exactly one label, so
+// "*.example.com" covers "foo.example.com", but neither "example.com" nor "foo.bar.example.com".
+func wildcardDomain(domain string) string {
+	if strings.HasPrefix(domain, "*.") {
+		return ""
+	}
+	i := strings.Index(domain, ".")
+	if i <= 0 || !strings.Contains(domain[i+1:], ".") {
+		return ""
+	}
+	return "*" + domain[i:]
+}
+
+// normalizeDomains normalizes all of an application's domains and the domains in its certificate
+// mappings, discarding any domains that become duplicates in the process.
+func normalizeDomains(appConfig *AppConfig) {
+	domains := []string{}
+	seen := make(map[string]bool, len(appConfig.Domains))
+	for _, domain := range appConfig.Domains {
+		domain = normalizeDomain(domain)
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+	appConfig.Domains = domains
+	if appConfig.CertMappings != nil {
+		certMappings := make(map[string]string, len(appConfig.CertMappings))
+		for domain, certMapping := range appConfig.CertMappings {
+			certMappings[normalizeDomain(domain)] = certMapping
+		}
+		appConfig.CertMappings = certMappings
+	}
+	if appConfig.TLSSecrets != nil {
+		tlsSecrets := make(map[string]string, len(appConfig.TLSSecrets))
+		for domain, secretName := range appConfig.TLSSecrets {
+			tlsSecrets[normalizeDomain(domain)] = secretName
+		}
+		appConfig.TLSSecrets = tlsSecrets
+	}
+}
+
+// normalizeDomain lower-cases the provided domain and strips any port and trailing dot so that it
+// may be compared to the Host of an incoming request, which nginx normalizes in the same manner.
+func normalizeDomain(domain string) string {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if i := strings.LastIndex(domain, ":"); i != -1 {
+		domain = domain[:i]
+	}
+	return strings.TrimSuffix(domain, ".")
+}
+
+// isClaimedIngress returns a bool indicating whether the provided ingress is annotated as belonging
+// to the specified ingress class.
+func isClaimedIngress(ingress v1beta1ext.Ingress, ingressClass string) bool {
+	return ingress.Annotations[ingressClassAnnotation] == ingressClass
+}
+
+// isInRoutingClass returns a bool indicating whether the provided resource's routable.class
+// annotation places it in the specified routing class.  Resources without the annotation belong to
+// the empty class of routers that have none.
+func isInRoutingClass(meta v1.ObjectMeta, routingClass string) bool {
+	return meta.Annotations[routingClassAnnotation] == routingClass
+}
+
+// buildIngressAppConfigs builds one AppConfig for each host-bearing rule of the provided ingress.
+// Paths within each rule become locations, except for the root path, which (falling back to the
+// ingress's default backend) determines where the rest of the host's requests are routed.
+// router.deis.io annotations on the ingress are honored as they would be on a routable service.
+func buildIngressAppConfigs(kubeClient *kubernetes.Clientset, ingress v1beta1ext.Ingress, routerConfig *RouterConfig) ([]*AppConfig, error) {
+	appConfigs := []*AppConfig{}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host == "" {
+			log.Printf("WARN: Ingress \"%s/%s\" has a rule with no host -- skipping this rule.\n", ingress.Namespace, ingress.Name)
+			continue
+		}
+		appConfig := newAppConfig(routerConfig)
+		appConfig.Namespace = ingress.Namespace
+		appConfig.Name = ingress.Namespace + "/" + ingress.Name
+		appConfig.ResourceKind = "Ingress"
+		appConfig.ResourceName = ingress.Name
+		err := mapAnnotations(routerConfig, "Ingress", ingress.ObjectMeta, "", appConfig)
+		if err != nil {
+			return nil, err
+		}
+		appConfig.Domains = []string{rule.Host}
+		appConfig.CertMappings = nil
+		normalizeDomains(appConfig)
+		appConfig.SSLConfig.Enforce = strings.ToLower(appConfig.SSLConfig.Enforce)
+		activateDebugBody(appConfig, time.Now())
+		validateRateLimitResponse(appConfig)
+		validateBodySizeResponse(appConfig)
+		validateSnippets(appConfig)
+		validateDenylist(routerConfig, "Ingress", ingress.ObjectMeta, appConfig)
+		validateWhitelistSources(routerConfig, "Ingress", ingress.ObjectMeta, appConfig)
+		validateCDN(routerConfig, "Ingress", ingress.ObjectMeta, appConfig)
+		resolveTransforms(appConfig)
+		rootBackend := ingress.Spec.Backend
+		paths := []v1beta1ext.HTTPIngressPath{}
+		if rule.HTTP != nil {
+			for _, path := range rule.HTTP.Paths {
+				if path.Path == "" || path.Path == "/" {
+					backend := path.Backend
+					rootBackend = &backend
+				} else {
+					paths = append(paths, path)
+				}
+			}
+		}
+		if rootBackend != nil {
+			appConfig.ServiceIP, appConfig.ServicePort, appConfig.Available, appConfig.Endpoints, err = resolveBackend(kubeClient, ingress.Namespace, rootBackend.ServiceName, rootBackend.ServicePort)
+			if err != nil {
+				return nil, err
+			}
+			if err := resolveCanary(kubeClient, ingress.Namespace, appConfig); err != nil {
+				return nil, err
+			}
+		}
+		frontExternalOrigin(appConfig)
+		resolveBackupOrigin(appConfig)
+		// Locations defined by annotation take precedence over those defined by the rule's paths.
+		appConfig.Locations = buildLocationConfigs(ingress.Annotations, appConfig)
+		if err := resolveLocationBackends(kubeClient, ingress.Namespace, appConfig); err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			location := newLocationConfig(appConfig)
+			err := locationModeler.MapToModel(map[string]string{"path": path.Path}, "", location)
+			if err != nil || location.Path == "" {
+				log.Printf("WARN: Ingress \"%s/%s\" has an invalid path \"%s\" -- skipping this path.\n", ingress.Namespace, ingress.Name, path.Path)
+				continue
+			}
+			if hasLocation(appConfig, location.Path) {
+				log.Printf("WARN: The location \"%s\" for app \"%s\" is defined more than once -- skipping the duplicate.\n", location.Path, appConfig.Name)
+				continue
+			}
+			location.ServiceIP, location.ServicePort, location.Available, location.Endpoints, err = resolveBackend(kubeClient, ingress.Namespace, path.Backend.ServiceName, path.Backend.ServicePort)
+			if err != nil {
+				return nil, err
+			}
+			location.Canary = nil
+			location.ExternalOrigin = ""
+			location.BackupOrigin = ""
+			appConfig.Locations = append(appConfig.Locations, location)
+		}
+		covered := false
+		for _, tls := range ingress.Spec.TLS {
+			if !tlsCoversHost(tls, appConfig.Domains[0]) {
+				continue
+			}
+			covered = true
+			certSecret, err := getSecret(kubeClient, tls.SecretName, ingress.Namespace)
+			if err != nil {
+				return nil, err
+			}
+			if certSecret != nil {
+				certificate, err := buildCertificate(certSecret, appConfig.Domains[0])
+				if err != nil {
+					return nil, err
+				}
+				if certificate == nil {
+					warnInvalidCertificate(routerConfig, certSecret, appConfig.Domains[0])
+				}
+				appConfig.Certificates[appConfig.Domains[0]] = certificate
+			}
+		}
+		if !covered && strings.Contains(appConfig.Domains[0], ".") {
+			if err := addACMECertificate(kubeClient, appConfig, appConfig.Domains[0]); err != nil {
+				return nil, err
+			}
+		}
+		if err := resolveClientVerifications(kubeClient, "Ingress", ingress.ObjectMeta, appConfig); err != nil {
+			return nil, err
+		}
+		if err := resolveBasicAuth(kubeClient, "Ingress", ingress.ObjectMeta, appConfig); err != nil {
+			return nil, err
+		}
+		if err := resolveErrorPages(kubeClient, routerConfig, "Ingress", ingress.ObjectMeta, appConfig); err != nil {
+			return nil, err
+		}
+		if err := resolveWAFRules(kubeClient, routerConfig, "Ingress", ingress.ObjectMeta, appConfig); err != nil {
+			return nil, err
+		}
+		appConfigs = append(appConfigs, appConfig)
+	}
+	return appConfigs, nil
+}
+
+// resolveBackend returns the cluster IP and port of the specified service, whether that service has
+// any endpoints available, and the addresses of those endpoints.  A reference to a service or port
+// that doesn't exist is simply reported as unavailable.
+func resolveBackend(kubeClient *kubernetes.Clientset, ns string, serviceName string, servicePort intstr.IntOrString) (string, int, bool, []string, error) {
+	service, err := getService(kubeClient, serviceName, ns)
+	if err != nil {
+		return "", 0, false, nil, err
+	}
+	if service == nil {
+		log.Printf("WARN: Backend service \"%s/%s\" does not exist.\n", ns, serviceName)
+		return "", 0, false, nil, nil
+	}
+	port := 0
+	for _, candidatePort := range service.Spec.Ports {
+		if (servicePort.Type == intstr.Int && candidatePort.Port == servicePort.IntVal) ||
+			(servicePort.Type == intstr.String && candidatePort.Name == servicePort.StrVal) {
+			port = int(candidatePort.Port)
+			break
+		}
+	}
+	if port == 0 {
+		log.Printf("WARN: Backend service \"%s/%s\" exposes no port matching \"%s\".\n", ns, serviceName, servicePort.String())
+		return "", 0, false, nil, nil
+	}
+	endpoints, err := kubeClient.Endpoints(ns).Get(serviceName)
+	if err != nil {
+		statusErr, ok := err.(*errors.StatusError)
+		if ok && statusErr.Status().Code == 404 {
+			return service.Spec.ClusterIP, port, false, nil, nil
+		}
+		return "", 0, false, nil, err
+	}
+	available := len(endpoints.Subsets) > 0 && len(endpoints.Subsets[0].Addresses) > 0
+	return service.Spec.ClusterIP, port, available, buildEndpoints(service, endpoints, port), nil
+}
+
+// buildEndpoints returns the addresses, in "ip:port" form and in a stable order, of all ready
+// endpoints backing the specified port of the provided service.
+func buildEndpoints(service *v1.Service, endpoints *v1.Endpoints, servicePort int) []string {
+	portName := ""
+	found := false
+	for _, candidatePort := range service.Spec.Ports {
+		if int(candidatePort.Port) == servicePort {
+			portName = candidatePort.Name
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+	addresses := []string{}
+	for _, subset := range endpoints.Subsets {
+		for _, endpointPort := range subset.Ports {
+			if endpointPort.Name != portName {
+				continue
+			}
+			for _, address := range subset.Addresses {
+				addresses = append(addresses, net.JoinHostPort(address.IP, strconv.Itoa(int(endpointPort.Port))))
+			}
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// tlsCoversHost returns a bool indicating whether the provided ingress TLS configuration applies to
+// the specified (normalized) host.  A configuration listing no hosts applies to all of them.
+func tlsCoversHost(tls v1beta1ext.IngressTLS, host string) bool {
+	if len(tls.Hosts) == 0 {
+		return true
+	}
+	for _, tlsHost := range tls.Hosts {
+		if tlsHost := normalizeDomain(tlsHost); tlsHost == host || tlsHost == wildcardDomain(host) {
+			return true
+		}
+	}
+	return false
+}
+
 func buildBuilderConfig(service *v1.Service) (*BuilderConfig, error) {
 	builderConfig := newBuilderConfig()
 	builderConfig.ServiceIP = service.Spec.ClusterIP
@@ -421,6 +2785,35 @@ func buildBuilderConfig(service *v1.Service) (*BuilderConfig, error) {
 	return builderConfig, nil
 }
 
+// ACMESecretName returns the name of the secret in which a certificate automatically obtained for the
+// specified domain is stored.
+func ACMESecretName(domain string) string {
+	return fmt.Sprintf("%s-acme-cert", domain)
+}
+
+// addACMECertificate marks the specified domain as one for which a certificate should be obtained
+// automatically, if the application has opted in to that, and uses any such certificate that has
+// already been obtained.  Wildcard domains are never eligible, since they cannot be validated over
+// HTTP.
+func addACMECertificate(kubeClient *kubernetes.Clientset, appConfig *AppConfig, domain string) error {
+	if !appConfig.ACME || strings.HasPrefix(domain, "*.") {
+		return nil
+	}
+	appConfig.ACMEDomains = append(appConfig.ACMEDomains, domain)
+	certSecret, err := getSecret(kubeClient, ACMESecretName(domain), appConfig.Namespace)
+	if err != nil {
+		return err
+	}
+	if certSecret != nil {
+		certificate, err := buildCertificate(certSecret, domain)
+		if err != nil {
+			return err
+		}
+		appConfig.Certificates[domain] = certificate
+	}
+	return nil
+}
+
 func buildCertificate(certSecret *v1.Secret, context string) (*Certificate, error) {
 	cert, ok := certSecret.Data["tls.crt"]
 	// If no cert is found in the secret, warn and return nil
@@ -436,7 +2829,38 @@ func buildCertificate(certSecret *v1.Secret, context string) (*Certificate, erro
 	}
 	certStr := string(cert[:])
 	keyStr := string(key[:])
-	return newCertificate(certStr, keyStr), nil
+	certificate := newCertificate(certStr, keyStr)
+	if notAfter, ok := certificateNotAfter(certStr); ok {
+		certificate.NotAfter = notAfter
+	} else {
+		log.Printf("WARN: The %s certificate could not be parsed, so its expiry is not monitored.\n", context)
+	}
+	// Standard TLS secrets, such as those cert-manager issues, may also convey the issuing CA.
+	if ca, ok := certSecret.Data["ca.crt"]; ok {
+		certificate.CA = string(ca)
+	}
+	return certificate, nil
+}
+
+// buildECHKeys returns the Encrypted Client Hello keys conveyed by the provided secret, keyed by the
+// names of the files they are written to.  Each entry whose name ends in ".ech" holds, in PEM form,
+// a private key and the ECHConfigList that clients use to encrypt their handshakes with it.
+func buildECHKeys(echSecret *v1.Secret) map[string]string {
+	if echSecret == nil {
+		log.Println("WARN: Encrypted Client Hello is enabled, but there is no k8s secret \"deis-router-ech\" to convey its keys.")
+		return nil
+	}
+	echKeys := map[string]string{}
+	for name, data := range echSecret.Data {
+		if strings.HasSuffix(name, ".ech") {
+			echKeys[name] = string(data)
+		}
+	}
+	if len(echKeys) == 0 {
+		log.Println("WARN: The k8s secret intended to convey the Encrypted Client Hello keys contained no entries ending in \".ech\".")
+		return nil
+	}
+	return echKeys
 }
 
 func buildDHParam(dhParamSecret *v1.Secret) (string, error) {
@@ -1,8 +1,10 @@
 package model
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"k8s.io/client-go/1.4/pkg/api/v1"
 	"k8s.io/client-go/1.4/pkg/apis/extensions/v1beta1"
@@ -89,6 +91,7 @@ func TestBuildRouterConfig(t *testing.T) {
 	sslConfig := newSSLConfig()
 	hstsConfig := newHSTSConfig()
 	platformCert := newCertificate("foo", "bar")
+	platformCert.Name = "platform"
 	clientCerts := []string{"asdf", "qwerty"}
 
 	// A value not set in the deployment annotations (should be default value).
@@ -111,6 +114,9 @@ func TestBuildRouterConfig(t *testing.T) {
 	expectedConfig.PlatformCertificate = platformCert
 	expectedConfig.ClientCertificates = clientCerts
 
+	// No GeoIP database is mounted where the router looks for one by default.
+	expectedConfig.GeoIPDatabase = ""
+
 	actualConfig, err := buildRouterConfig(&routerDeployment, &platformCertSecret, &dhParamSecret)
 	if err != nil {
 		t.Error(err)
@@ -126,6 +132,52 @@ func TestBuildRouterConfig(t *testing.T) {
 	}
 }
 
+func TestBuildRouterConfigEmergencyMode(t *testing.T) {
+	routerDeployment := v1beta1.Deployment{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      routerName,
+			Namespace: deisNamespace,
+			Annotations: map[string]string{
+				"router.deis.io/emergencyMode":          "allowlist-only",
+				"router.deis.io/nginx.defaultWhitelist": "10.0.0.0/8",
+			},
+		},
+	}
+
+	routerConfig, err := buildRouterConfig(&routerDeployment, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if routerConfig.EmergencyConfig.Mode != "allowlist-only" {
+		t.Errorf("Expected emergency mode \"allowlist-only\", but got \"%s\".", routerConfig.EmergencyConfig.Mode)
+	}
+	// With no emergency allowlist specified, the default whitelist should be used.
+	if expected := []string{"10.0.0.0/8"}; !reflect.DeepEqual(expected, routerConfig.EmergencyConfig.Allowlist) {
+		t.Errorf("Expected emergency allowlist %v, but got %v.", expected, routerConfig.EmergencyConfig.Allowlist)
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	routerConfig := newRouterConfig()
+	if routerConfig.ProxyProtocolHTTP() || routerConfig.ProxyProtocolHTTPS() || routerConfig.ProxyProtocolStream() {
+		t.Errorf("Expected no listener to expect the PROXY protocol by default.")
+	}
+	routerConfig.UseProxyProtocol = true
+	if !routerConfig.ProxyProtocolHTTP() || !routerConfig.ProxyProtocolHTTPS() || !routerConfig.ProxyProtocolStream() {
+		t.Errorf("Expected every listener to follow useProxyProtocol unless overridden.")
+	}
+	routerConfig.ProxyProtocolConfig.HTTP = "false"
+	routerConfig.ProxyProtocolConfig.Stream = "false"
+	if routerConfig.ProxyProtocolHTTP() || !routerConfig.ProxyProtocolHTTPS() || routerConfig.ProxyProtocolStream() {
+		t.Errorf("Expected only the HTTPS listener to expect the PROXY protocol.")
+	}
+	routerConfig.UseProxyProtocol = false
+	routerConfig.ProxyProtocolConfig.HTTPS = "true"
+	if !routerConfig.ProxyProtocolHTTPS() {
+		t.Errorf("Expected the HTTPS listener to expect the PROXY protocol when enabled for it alone.")
+	}
+}
+
 func TestBuildBuilderConfig(t *testing.T) {
 	// Ensure a Builder Service with annotations returns the expected BuilderConfig.
 	builderService := v1.Service{
@@ -208,6 +260,27 @@ func TestBuildCertificate(t *testing.T) {
 		t.Errorf("%+v\n", actualCert)
 	}
 
+	// Ensure a standard TLS secret's CA is kept alongside its certificate.
+	tlsSecret := v1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "example-com-tls",
+			Namespace: "example",
+		},
+		Type: v1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"tls.crt": []byte("foo"),
+			"tls.key": []byte("bar"),
+			"ca.crt":  []byte("baz"),
+		},
+	}
+	tlsCert, err := buildCertificate(&tlsSecret, "example.com")
+	if err != nil {
+		t.Error(err)
+	}
+	if tlsCert == nil || tlsCert.Cert != "foo" || tlsCert.Key != "bar" || tlsCert.CA != "baz" {
+		t.Errorf("Expected the TLS secret's certificate, key, and CA, but got %+v.", tlsCert)
+	}
+
 	// Ensure an invalid Cert Secret returns nil.
 	invalidCertSecret := v1.Secret{
 		ObjectMeta: v1.ObjectMeta{
@@ -271,3 +344,631 @@ func TestBuildDHParam(t *testing.T) {
 		t.Errorf("Invalid DHParam Secret should have returned empty string.")
 	}
 }
+
+func TestBuildECHKeys(t *testing.T) {
+	echSecret := v1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "deis-router-ech",
+			Namespace: deisNamespace,
+		},
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"example.com.ech": []byte("key"),
+			"README":          []byte("ignored"),
+		},
+	}
+	expected := map[string]string{"example.com.ech": "key"}
+	if actual := buildECHKeys(&echSecret); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected ECH keys %v, but got %v", expected, actual)
+	}
+
+	// Without a secret, or keys in it, there are no keys.
+	if actual := buildECHKeys(nil); actual != nil {
+		t.Errorf("Expected no ECH keys without a secret, but got %v", actual)
+	}
+	delete(echSecret.Data, "example.com.ech")
+	if actual := buildECHKeys(&echSecret); actual != nil {
+		t.Errorf("Expected no ECH keys from a secret without any, but got %v", actual)
+	}
+}
+
+func TestBuildLocationConfigs(t *testing.T) {
+	appConfig := newAppConfig(newRouterConfig())
+	appConfig.Name = "foo"
+	annotations := map[string]string{
+		"router.deis.io/nginx.locations": `[
+			{"path": "/uploads", "bodySize": "1g"},
+			{"path": "/api", "tcpTimeout": "30s", "whitelist": "10.0.0.0/8, 1.2.3.4"},
+			{"path": "/admin", "service": "foo-admin", "servicePort": "http"},
+			{"path": "/shop", "weights": "shop-v1:90, shop-v2:10"},
+			{"path": "/cart", "weights": "cart:fifty"},
+			{"path": "/login", "auth": "false", "proxyCache": "true"},
+			{"path": "bogus", "bodySize": "2m"},
+			{"path": "/api", "tcpTimeout": "60s"}
+		]`,
+	}
+
+	uploads := newLocationConfig(appConfig)
+	uploads.Path = "/uploads"
+	uploads.BodySize = "1g"
+	api := newLocationConfig(appConfig)
+	api.Path = "/api"
+	api.TCPTimeout = "30s"
+	api.Whitelist = []string{"10.0.0.0/8", "1.2.3.4"}
+	admin := newLocationConfig(appConfig)
+	admin.Path = "/admin"
+	admin.BackendService = "foo-admin"
+	admin.BackendPort = "http"
+	shop := newLocationConfig(appConfig)
+	shop.Path = "/shop"
+	shop.Weights = map[string]string{"shop-v1": "90", "shop-v2": "10"}
+	// Invalid weights are ignored, like any other invalid setting.
+	cart := newLocationConfig(appConfig)
+	cart.Path = "/cart"
+	login := newLocationConfig(appConfig)
+	login.Path = "/login"
+	login.Auth = false
+	login.ProxyCache = true
+	// Locations with invalid paths and duplicate paths should be skipped.
+	expectedLocations := []*LocationConfig{uploads, api, admin, shop, cart, login}
+
+	actualLocations := buildLocationConfigs(annotations, appConfig)
+	if !reflect.DeepEqual(expectedLocations, actualLocations) {
+		t.Errorf("Expected locations do not match actual.")
+
+		t.Errorf("Expected:\n")
+		t.Errorf("%+v\n", expectedLocations)
+		t.Errorf("Actual:\n")
+		t.Errorf("%+v\n", actualLocations)
+	}
+
+	// Ensure unparseable JSON results in no locations rather than an error.
+	annotations["router.deis.io/nginx.locations"] = "{foo"
+	if locations := buildLocationConfigs(annotations, appConfig); locations != nil {
+		t.Errorf("Expected invalid locations JSON to return nil, but got %+v.", locations)
+	}
+}
+
+func TestBuildListenerConfigs(t *testing.T) {
+	routerConfig := newRouterConfig()
+	routerConfig.SSLConfig.Protocols = "TLSv1.2"
+	annotations := map[string]string{
+		"router.deis.io/nginx.listeners": `[
+			{"port": "8443", "protocols": "TLSv1 TLSv1.1 TLSv1.2", "ciphers": "AES128-SHA:DES-CBC3-SHA", "domains": "legacy.example.com,foo"},
+			{"port": "9443", "http2": "false"},
+			{"port": "8443"},
+			{"port": "443"},
+			{"port": "9090"},
+			{"port": "10443", "protocols": "TLSv9"},
+			{"http2": "true"}
+		]`,
+	}
+
+	legacy := newListenerConfig(routerConfig)
+	legacy.Port = 8443
+	legacy.Protocols = "TLSv1 TLSv1.1 TLSv1.2"
+	legacy.Ciphers = "AES128-SHA:DES-CBC3-SHA"
+	legacy.Domains = []string{"legacy.example.com", "foo"}
+	plain := newListenerConfig(routerConfig)
+	plain.Port = 9443
+	plain.HTTP2 = "false"
+	// Invalid protocols are ignored, like any other invalid setting, so the router's apply.
+	modern := newListenerConfig(routerConfig)
+	modern.Port = 10443
+	// Listeners with duplicate, privileged, reserved, or missing ports should be skipped.
+	expectedListeners := []*ListenerConfig{legacy, plain, modern}
+
+	actualListeners := buildListenerConfigs(annotations, routerConfig)
+	if !reflect.DeepEqual(expectedListeners, actualListeners) {
+		t.Errorf("Expected listeners %+v, but got %+v.", expectedListeners, actualListeners)
+	}
+	if modern.Protocols != "TLSv1.2" || modern.HTTP2 != "true" {
+		t.Errorf("Expected listeners to default to the router's SSL settings, but got protocols %q and HTTP/2 %q.", modern.Protocols, modern.HTTP2)
+	}
+	if !legacy.Serves("foo") || legacy.Serves("bar.example.com") || !modern.Serves("bar.example.com") {
+		t.Errorf("Expected listeners to serve only the domains they list, if any.")
+	}
+
+	// Ensure unparseable JSON results in no listeners rather than an error.
+	annotations["router.deis.io/nginx.listeners"] = "{foo"
+	if listeners := buildListenerConfigs(annotations, routerConfig); listeners != nil {
+		t.Errorf("Expected invalid listeners JSON to return nil, but got %+v.", listeners)
+	}
+}
+
+func TestActivateDebugBody(t *testing.T) {
+	now := time.Date(2016, time.November, 1, 12, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		until   string
+		enabled bool
+	}{
+		{"", false},
+		{"2016-11-01T11:59:59Z", false},
+		{"2016-11-01T12:30:00Z", true},
+		{"2016-11-01T12:30:00+01:00", false},
+		{"2016-13-01T12:30:00Z", false},
+	} {
+		appConfig := newAppConfig(newRouterConfig())
+		appConfig.DebugBodyConfig.Until = test.until
+		activateDebugBody(appConfig, now)
+		if appConfig.DebugBodyConfig.Enabled != test.enabled {
+			t.Errorf("Expected debug body logging until \"%s\" to be enabled=%t, but got %t.", test.until, test.enabled, appConfig.DebugBodyConfig.Enabled)
+		}
+	}
+
+	// Invalid redaction patterns should be dropped.
+	appConfig := newAppConfig(newRouterConfig())
+	appConfig.DebugBodyConfig.Until = "2016-11-01T12:30:00Z"
+	appConfig.DebugBodyConfig.RedactPatterns = []string{"password=\\w+", "(unclosed"}
+	activateDebugBody(appConfig, now)
+	if expected := []string{"password=\\w+"}; !reflect.DeepEqual(expected, appConfig.DebugBodyConfig.RedactPatterns) {
+		t.Errorf("Expected redaction patterns %v, but got %v.", expected, appConfig.DebugBodyConfig.RedactPatterns)
+	}
+}
+
+func TestValidateRateLimitResponse(t *testing.T) {
+	appConfig := newAppConfig(newRouterConfig())
+	appConfig.RateLimitResponseConfig.Body = `{"error": "rate_limited"}`
+	validateRateLimitResponse(appConfig)
+	if appConfig.RateLimitResponseConfig.Body != `{"error": "rate_limited"}` {
+		t.Errorf("Expected a valid JSON body to be retained, but got \"%s\".", appConfig.RateLimitResponseConfig.Body)
+	}
+	appConfig.RateLimitResponseConfig.Body = `{"error": `
+	validateRateLimitResponse(appConfig)
+	if appConfig.RateLimitResponseConfig.Body != "" {
+		t.Errorf("Expected an invalid JSON body to be discarded, but got \"%s\".", appConfig.RateLimitResponseConfig.Body)
+	}
+}
+
+func TestValidateBodySizeResponse(t *testing.T) {
+	appConfig := newAppConfig(newRouterConfig())
+	appConfig.BodySizeResponseConfig.Body = `{"error": "body_too_large"}`
+	validateBodySizeResponse(appConfig)
+	if appConfig.BodySizeResponseConfig.Body != `{"error": "body_too_large"}` {
+		t.Errorf("Expected a valid JSON body to be retained, but got \"%s\".", appConfig.BodySizeResponseConfig.Body)
+	}
+	appConfig.BodySizeResponseConfig.Body = `{"error": `
+	validateBodySizeResponse(appConfig)
+	if appConfig.BodySizeResponseConfig.Body != "" {
+		t.Errorf("Expected an invalid JSON body to be discarded, but got \"%s\".", appConfig.BodySizeResponseConfig.Body)
+	}
+}
+
+func TestResolveBackupOrigin(t *testing.T) {
+	appConfig := newAppConfig(newRouterConfig())
+	appConfig.BackupOrigin = "backup.example.net"
+	appConfig.BackendProtocol = "https"
+	resolveBackupOrigin(appConfig)
+	if appConfig.BackupOrigin != "backup.example.net:443" || !appConfig.Available {
+		t.Errorf("Expected an available application failing over to backup.example.net:443, but got \"%s\" (%t).", appConfig.BackupOrigin, appConfig.Available)
+	}
+	appConfig.BackupOrigin = "backup.example.net:8080"
+	resolveBackupOrigin(appConfig)
+	if appConfig.BackupOrigin != "backup.example.net:8080" {
+		t.Errorf("Expected the backup origin's own port to be kept, but got \"%s\".", appConfig.BackupOrigin)
+	}
+
+	// An external origin takes the place of the application's endpoints, and so of any backup.
+	appConfig.ExternalOrigin = "https://legacy.example.net"
+	frontExternalOrigin(appConfig)
+	resolveBackupOrigin(appConfig)
+	if appConfig.BackupOrigin != "" {
+		t.Errorf("Expected no backup origin for an application fronting an external origin, but got \"%s\".", appConfig.BackupOrigin)
+	}
+}
+
+func TestResolveTransforms(t *testing.T) {
+	appConfig := newAppConfig(newRouterConfig())
+	appConfig.TransformConfig.StripPrefix = "/api"
+	appConfig.TransformConfig.AddPrefix = "/v2"
+	appConfig.TransformConfig.JSONErrors = true
+	resolveTransforms(appConfig)
+	if appConfig.ErrorFormat != "json" {
+		t.Errorf("Expected JSON errors to set the error format to json, but got \"%s\".", appConfig.ErrorFormat)
+	}
+	if appConfig.StripPrefix != "/api" || appConfig.AddPrefix != "/v2" {
+		t.Errorf("Expected the transformations' prefixes to be the application's, but got \"%s\" and \"%s\".", appConfig.StripPrefix, appConfig.AddPrefix)
+	}
+	location := newLocationConfig(appConfig)
+	if location.StripPrefix != "/api" || location.AddPrefix != "/v2" {
+		t.Errorf("Expected locations to inherit the transformations' prefixes, but got \"%s\" and \"%s\".", location.StripPrefix, location.AddPrefix)
+	}
+
+	// Prefixes set natively take precedence over the transformations'.
+	appConfig = newAppConfig(newRouterConfig())
+	appConfig.AddPrefix = "/v3"
+	appConfig.TransformConfig.StripPrefix = "/api"
+	resolveTransforms(appConfig)
+	if appConfig.StripPrefix != "" || appConfig.AddPrefix != "/v3" {
+		t.Errorf("Expected only the application's own prefixes, but got \"%s\" and \"%s\".", appConfig.StripPrefix, appConfig.AddPrefix)
+	}
+}
+
+func TestParseServicePort(t *testing.T) {
+	if port := parseServicePort("8080"); port != intstr.FromInt(8080) {
+		t.Errorf("Expected port 8080 to be parsed as a number, but got %+v.", port)
+	}
+	if port := parseServicePort("http"); port != intstr.FromString("http") {
+		t.Errorf("Expected port http to be parsed as a name, but got %+v.", port)
+	}
+}
+
+func TestBuildEndpoints(t *testing.T) {
+	service := &v1.Service{
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{
+				{Name: "http", Port: 80},
+				{Name: "metrics", Port: 9090},
+			},
+		},
+	}
+	endpoints := &v1.Endpoints{
+		Subsets: []v1.EndpointSubset{
+			{
+				Addresses: []v1.EndpointAddress{{IP: "10.0.0.2"}, {IP: "10.0.0.1"}},
+				Ports:     []v1.EndpointPort{{Name: "http", Port: 3000}, {Name: "metrics", Port: 9100}},
+			},
+			{
+				Addresses:         []v1.EndpointAddress{{IP: "10.0.1.1"}},
+				NotReadyAddresses: []v1.EndpointAddress{{IP: "10.0.1.2"}},
+				Ports:             []v1.EndpointPort{{Name: "http", Port: 3000}},
+			},
+		},
+	}
+
+	expected := []string{"10.0.0.1:3000", "10.0.0.2:3000", "10.0.1.1:3000"}
+	if actual := buildEndpoints(service, endpoints, 80); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected endpoints %v, but got %v.", expected, actual)
+	}
+	expected = []string{"10.0.0.1:9100", "10.0.0.2:9100"}
+	if actual := buildEndpoints(service, endpoints, 9090); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected endpoints %v, but got %v.", expected, actual)
+	}
+	if actual := buildEndpoints(service, endpoints, 8080); actual != nil {
+		t.Errorf("Expected no endpoints for a port the service does not expose, but got %v.", actual)
+	}
+}
+
+func TestRouterConfigStats(t *testing.T) {
+	routerConfig := newRouterConfig()
+	routerConfig.PlatformCertificate = newCertificate("cert", "key")
+	routerConfig.StreamConfigs = []*StreamConfig{&StreamConfig{Protocol: "tcp", ListenPort: 5432}}
+	routerConfig.AppConfigs = []*AppConfig{
+		&AppConfig{
+			Domains:      []string{"foo", "foo.example.com"},
+			Endpoints:    []string{"10.0.0.1:80", "10.0.0.2:80"},
+			Whitelist:    []string{"1.2.3.4"},
+			Available:    true,
+			Certificates: map[string]*Certificate{"foo.example.com": newCertificate("cert", "key"), "foo": nil},
+			Locations: []*LocationConfig{
+				&LocationConfig{Path: "/api", Endpoints: []string{"10.0.1.1:80"}},
+			},
+		},
+		&AppConfig{
+			Domains:     []string{"bar.example.com"},
+			Maintenance: true,
+			ACMEDomains: []string{"bar.example.com"},
+		},
+	}
+
+	expected := RoutingTableStats{
+		Apps:            2,
+		Domains:         3,
+		Locations:       1,
+		Endpoints:       3,
+		Certificates:    2,
+		WhitelistedApps: 1,
+		UnavailableApps: 1,
+		MaintenanceApps: 1,
+		ACMEDomains:     1,
+		Streams:         1,
+	}
+	if actual := routerConfig.Stats(); actual != expected {
+		t.Errorf("Expected stats %+v, but got %+v.", expected, actual)
+	}
+
+	routerConfig.EnforceWhitelists = true
+	if actual := routerConfig.Stats().WhitelistedApps; actual != 2 {
+		t.Errorf("Expected all apps to be whitelisted when whitelists are enforced, but got %d.", actual)
+	}
+
+	// A certificate shared by several domains is written, and counted, once.
+	wildcard := newCertificate("cert", "key")
+	wildcard.Name = "*.example.com"
+	routerConfig.AppConfigs[1].Certificates = map[string]*Certificate{"bar.example.com": wildcard, "baz.example.com": wildcard}
+	if actual := routerConfig.Stats().Certificates; actual != 3 {
+		t.Errorf("Expected 3 certificates, but got %d.", actual)
+	}
+}
+
+func TestAddStreamConfigs(t *testing.T) {
+	routerConfig := newRouterConfig()
+	addStreamConfigs(routerConfig, []*StreamConfig{
+		&StreamConfig{Name: "db/postgres", Protocol: "tcp", ListenPort: 5432},
+		&StreamConfig{Name: "dns/coredns", Protocol: "udp", ListenPort: 5353},
+	})
+	addStreamConfigs(routerConfig, []*StreamConfig{
+		&StreamConfig{Name: "db/other", Protocol: "tcp", ListenPort: 5432},
+		&StreamConfig{Name: "db/other", Protocol: "udp", ListenPort: 5432},
+	})
+
+	// The router's additional SSL listeners cannot be claimed for TCP.
+	routerConfig.ListenerConfigs = []*ListenerConfig{&ListenerConfig{Port: 8443}}
+	addStreamConfigs(routerConfig, []*StreamConfig{
+		&StreamConfig{Name: "db/mongo", Protocol: "tcp", ListenPort: 8443},
+		&StreamConfig{Name: "dns/coredns", Protocol: "udp", ListenPort: 8443},
+	})
+
+	expected := []string{"db/postgres tcp 5432", "dns/coredns udp 5353", "db/other udp 5432", "dns/coredns udp 8443"}
+	actual := []string{}
+	for _, streamConfig := range routerConfig.StreamConfigs {
+		actual = append(actual, fmt.Sprintf("%s %s %d", streamConfig.Name, streamConfig.Protocol, streamConfig.ListenPort))
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected stream configs %v, but got %v.", expected, actual)
+	}
+}
+
+func TestCheckSnippet(t *testing.T) {
+	for _, snippet := range []string{
+		"",
+		"add_header X-Foo bar;",
+		"if ($http_x_foo) {\n\treturn 403;\n}",
+		"# A comment with an unbalanced { brace\nadd_header X-Foo \"}\";",
+		"add_header X-Foo 'it\\'s';",
+		"location /foo {\n\treturn 200 \"{}\";\n}\n",
+	} {
+		if err := checkSnippet(snippet); err != nil {
+			t.Errorf("Expected snippet %q to be well formed, but got: %v", snippet, err)
+		}
+	}
+	for _, snippet := range []string{
+		"add_header X-Foo bar",
+		"}\nserver {",
+		"location /foo {",
+		"add_header X-Foo \"bar;",
+		"return 200 'foo;",
+	} {
+		if err := checkSnippet(snippet); err == nil {
+			t.Errorf("Expected snippet %q to be malformed, but it was not.", snippet)
+		}
+	}
+}
+
+func TestValidateSnippets(t *testing.T) {
+	appConfig := newAppConfig(newRouterConfig())
+	appConfig.ServerSnippet = "add_header X-Foo bar;"
+	appConfig.LocationSnippet = "proxy_set_header X-Foo bar; }"
+	validateSnippets(appConfig)
+	if appConfig.ServerSnippet != "add_header X-Foo bar;" {
+		t.Errorf("Expected a well formed server snippet to be kept, but got \"%s\".", appConfig.ServerSnippet)
+	}
+	if appConfig.LocationSnippet != "" {
+		t.Errorf("Expected a malformed location snippet to be discarded, but got \"%s\".", appConfig.LocationSnippet)
+	}
+}
+
+func TestValidateDenylist(t *testing.T) {
+	routerConfig := newRouterConfig()
+	appConfig := newAppConfig(routerConfig)
+	appConfig.Denylist = []string{"10.0.0.0/8", "1.2.3.4", "2001:db8::/32", "1.2.3.256", ""}
+	validateDenylist(routerConfig, "Service", v1.ObjectMeta{Name: "foo", Namespace: "bar"}, appConfig)
+	if expected := []string{"10.0.0.0/8", "1.2.3.4", "2001:db8::/32"}; !reflect.DeepEqual(expected, appConfig.Denylist) {
+		t.Errorf("Expected denylist %v, but got %v", expected, appConfig.Denylist)
+	}
+	if len(routerConfig.Warnings) != 1 || routerConfig.Warnings[0].Reason != "InvalidAnnotation" {
+		t.Errorf("Expected 1 InvalidAnnotation warning, but got %v", routerConfig.Warnings)
+	}
+}
+
+func TestMapDenylistAnnotation(t *testing.T) {
+	routerConfig := newRouterConfig()
+	appConfig := newAppConfig(routerConfig)
+	meta := v1.ObjectMeta{
+		Name:        "foo",
+		Namespace:   "bar",
+		Annotations: map[string]string{"router.deis.io/nginx.denylist": "10.0.0.0/8,1.2.3.4"},
+	}
+	if err := mapAnnotations(routerConfig, "Service", meta, "", appConfig); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"10.0.0.0/8", "1.2.3.4"}; !reflect.DeepEqual(expected, appConfig.Denylist) {
+		t.Errorf("Expected denylist %v, but got %v", expected, appConfig.Denylist)
+	}
+}
+
+func TestBuildClientVerifications(t *testing.T) {
+	appConfig := newAppConfig(newRouterConfig())
+	appConfig.Domains = []string{"foo.example.com", "www.example.com", "api.example.com"}
+	appConfig.ClientCertConfig.Verify = "optional"
+	appConfig.ClientCertConfig.DomainVerify = map[string]string{"WWW.example.com.": "off", "api.example.com": "on"}
+	appConfig.ClientCertConfig.DomainDepth = map[string]string{"api.example.com": "3"}
+
+	expected := map[string]*ClientVerification{
+		"foo.example.com": &ClientVerification{CABundle: "ca", Verify: "optional", Depth: "1"},
+		"api.example.com": &ClientVerification{CABundle: "ca", Verify: "on", Depth: "3"},
+	}
+	actual := buildClientVerifications(appConfig, "ca")
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected client verifications %+v, but got %+v", expected, actual)
+	}
+}
+
+func TestBuildBasicAuthUsers(t *testing.T) {
+	htpasswdSecret := &v1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "auth", Namespace: "foo"},
+		Data: map[string][]byte{
+			"auth": []byte("# admins\nalice:$apr1$abc$def\nmalformed\n\nbob:{SHA}ghi=\n"),
+		},
+	}
+	expected := []string{"alice:$apr1$abc$def", "bob:{SHA}ghi="}
+	if actual := buildBasicAuthUsers(htpasswdSecret); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected users %v, but got %v", expected, actual)
+	}
+
+	credentialsSecret := &v1.Secret{
+		ObjectMeta: v1.ObjectMeta{Name: "auth", Namespace: "foo"},
+		Data: map[string][]byte{
+			"carol": []byte("secret"),
+			"alice": []byte("password"),
+			"dave":  []byte(""),
+		},
+	}
+	expected = []string{"alice:{PLAIN}password", "carol:{PLAIN}secret"}
+	if actual := buildBasicAuthUsers(credentialsSecret); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected users %v, but got %v", expected, actual)
+	}
+}
+
+func TestBuildErrorPages(t *testing.T) {
+	routerConfig := newRouterConfig()
+	configMap := &v1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "errors", Namespace: "foo"},
+		Data: map[string]string{
+			"404":        "<h1>Not here</h1>",
+			"503":        "<h1>Back soon</h1>",
+			"200":        "<h1>Fine</h1>",
+			"index.html": "<h1>Home</h1>",
+		},
+	}
+	expected := map[string]string{"404": "<h1>Not here</h1>", "503": "<h1>Back soon</h1>"}
+	actual := buildErrorPages(routerConfig, "Service", v1.ObjectMeta{Name: "foo", Namespace: "foo"}, configMap)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected error pages %v, but got %v", expected, actual)
+	}
+	if len(routerConfig.Warnings) != 2 || routerConfig.Warnings[0].Reason != "InvalidErrorPages" {
+		t.Errorf("Expected 2 InvalidErrorPages warnings, but got %v", routerConfig.Warnings)
+	}
+}
+
+func TestNormalizeDomains(t *testing.T) {
+	appConfig := newAppConfig(newRouterConfig())
+	appConfig.Domains = []string{"Example.COM:443", "example.com.", "foo", "WWW.Example.com"}
+	appConfig.CertMappings = map[string]string{"Example.COM.": "example-com"}
+
+	normalizeDomains(appConfig)
+
+	expectedDomains := []string{"example.com", "foo", "www.example.com"}
+	if !reflect.DeepEqual(expectedDomains, appConfig.Domains) {
+		t.Errorf("Expected domains %v do not match actual %v.", expectedDomains, appConfig.Domains)
+	}
+	expectedCertMappings := map[string]string{"example.com": "example-com"}
+	if !reflect.DeepEqual(expectedCertMappings, appConfig.CertMappings) {
+		t.Errorf("Expected cert mappings %v do not match actual %v.", expectedCertMappings, appConfig.CertMappings)
+	}
+}
+
+func TestIsClaimedIngress(t *testing.T) {
+	ingress := v1beta1.Ingress{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "bar",
+			Annotations: map[string]string{
+				"kubernetes.io/ingress.class": "deis",
+			},
+		},
+	}
+	if !isClaimedIngress(ingress, "deis") {
+		t.Errorf("Expected ingress of class \"deis\" to be claimed by the \"deis\" class.")
+	}
+	if isClaimedIngress(ingress, "nginx") {
+		t.Errorf("Expected ingress of class \"deis\" not to be claimed by the \"nginx\" class.")
+	}
+	delete(ingress.Annotations, "kubernetes.io/ingress.class")
+	if isClaimedIngress(ingress, "deis") {
+		t.Errorf("Expected ingress with no class not to be claimed.")
+	}
+}
+
+func TestIsInRoutingClass(t *testing.T) {
+	meta := v1.ObjectMeta{
+		Name:      "foo",
+		Namespace: "bar",
+		Annotations: map[string]string{
+			"router.deis.io/routable.class": "internal",
+		},
+	}
+	if !isInRoutingClass(meta, "internal") {
+		t.Errorf("Expected resource of routing class \"internal\" to be routed by the \"internal\" class.")
+	}
+	if isInRoutingClass(meta, "") {
+		t.Errorf("Expected resource of routing class \"internal\" not to be routed by a router without a class.")
+	}
+	delete(meta.Annotations, "router.deis.io/routable.class")
+	if !isInRoutingClass(meta, "") {
+		t.Errorf("Expected resource with no routing class to be routed by a router without a class.")
+	}
+	if isInRoutingClass(meta, "internal") {
+		t.Errorf("Expected resource with no routing class not to be routed by the \"internal\" class.")
+	}
+}
+
+func TestGetPublishingNamespaces(t *testing.T) {
+	routerConfig := newRouterConfig()
+	namespaces, err := getPublishingNamespaces(nil, routerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !namespaces.admits("foo") {
+		t.Errorf("Expected every namespace to be admitted when neither a selector nor an allow-list is set.")
+	}
+	// An allow-list alone is applied without listing namespaces.
+	routerConfig.Namespaces = []string{"foo", "bar"}
+	namespaces, err = getPublishingNamespaces(nil, routerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !namespaces.admits("foo") || !namespaces.admits("bar") {
+		t.Errorf("Expected allow-listed namespaces to be admitted.")
+	}
+	if namespaces.admits("baz") {
+		t.Errorf("Expected namespaces that are not allow-listed not to be admitted.")
+	}
+}
+
+func TestTLSCoversHost(t *testing.T) {
+	tls := v1beta1.IngressTLS{Hosts: []string{"Foo.Example.com", "bar.example.com"}, SecretName: "example"}
+	if !tlsCoversHost(tls, "foo.example.com") {
+		t.Errorf("Expected TLS configuration to cover foo.example.com.")
+	}
+	if tlsCoversHost(tls, "baz.example.com") {
+		t.Errorf("Expected TLS configuration not to cover baz.example.com.")
+	}
+	if !tlsCoversHost(v1beta1.IngressTLS{SecretName: "example"}, "baz.example.com") {
+		t.Errorf("Expected TLS configuration listing no hosts to cover baz.example.com.")
+	}
+	wildcard := v1beta1.IngressTLS{Hosts: []string{"*.Example.com"}, SecretName: "example"}
+	if !tlsCoversHost(wildcard, "baz.example.com") {
+		t.Errorf("Expected wildcard TLS configuration to cover baz.example.com.")
+	}
+	if tlsCoversHost(wildcard, "baz.qux.example.com") || tlsCoversHost(wildcard, "example.com") {
+		t.Errorf("Expected wildcard TLS configuration to cover only one label.")
+	}
+}
+
+func TestFindCertSecret(t *testing.T) {
+	appConfig := newAppConfig(newRouterConfig())
+	appConfig.CertMappings = map[string]string{"*.example.com": "wildcard", "foo.example.com": "foo", "*.bar.example.com": "bar", "qux.example.com": "qux"}
+	appConfig.TLSSecrets = map[string]string{"*.bar.example.com": "bar-tls", "*.example.org": "example-org-tls"}
+	for _, test := range []struct {
+		domain         string
+		expectedDomain string
+		expectedSecret string
+		expectedOK     bool
+	}{
+		// A mapping for the domain itself takes precedence over a wildcard's.
+		{"foo.example.com", "foo.example.com", "foo-cert", true},
+		{"baz.example.com", "*.example.com", "wildcard-cert", true},
+		{"*.example.com", "*.example.com", "wildcard-cert", true},
+		// A standard TLS secret takes precedence over a Deis certificate secret.
+		{"qux.bar.example.com", "*.bar.example.com", "bar-tls", true},
+		{"www.example.org", "*.example.org", "example-org-tls", true},
+		{"example.com", "", "", false},
+		{"qux.baz.example.com", "", "", false},
+	} {
+		actualDomain, actualSecret, ok := findCertSecret(appConfig, test.domain)
+		if actualDomain != test.expectedDomain || actualSecret != test.expectedSecret || ok != test.expectedOK {
+			t.Errorf("Expected %s to be mapped for %q to %q (%t), but got %q to %q (%t).", test.domain, test.expectedDomain, test.expectedSecret, test.expectedOK, actualDomain, actualSecret, ok)
+		}
+	}
+}
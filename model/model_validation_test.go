@@ -76,7 +76,7 @@ func TestInvalidProxyRealIPCIDRs(t *testing.T) {
 }
 
 func TestValidProxyRealIPCIDRs(t *testing.T) {
-	testValidValues(t, newTestRouterConfig, "ProxyRealIPCIDRs", "proxyRealIpCidrs", []string{"0.0.0.0/0", "10.0.0.0/16", "10.0.0.0/16,192.168.0.0/16", "10.0.0.0/16, 192.168.0.0/16", "10.0.0.0/16 ,192.168.0.0/16", "10.0.0.0/16 , 192.168.0.0/16"})
+	testValidValues(t, newTestRouterConfig, "ProxyRealIPCIDRs", "proxyRealIpCidrs", []string{"0.0.0.0/0", "10.0.0.0/16", "10.0.0.0/16,192.168.0.0/16", "10.0.0.0/16, 192.168.0.0/16", "10.0.0.0/16 ,192.168.0.0/16", "10.0.0.0/16 , 192.168.0.0/16", "2001:db8::/32", "::/0", "10.0.0.0/8,fd00::/8"})
 }
 
 func TestInvalidErrorLogLevel(t *testing.T) {
@@ -95,148 +95,1246 @@ func TestValidPlatformDomain(t *testing.T) {
 	testValidValues(t, newTestRouterConfig, "PlatformDomain", "platformDomain", []string{"foobar.com", "foo-bar.io"})
 }
 
+func TestInvalidProxyProtocol(t *testing.T) {
+	testInvalidValues(t, newTestProxyProtocolConfig, "HTTP", "http", []string{"0", "-1", "foobar", "TRUE"})
+	testInvalidValues(t, newTestProxyProtocolConfig, "HTTPS", "https", []string{"0", "-1", "foobar", "TRUE"})
+	testInvalidValues(t, newTestProxyProtocolConfig, "Stream", "stream", []string{"0", "-1", "foobar", "TRUE"})
+}
+
+func TestValidProxyProtocol(t *testing.T) {
+	testValidValues(t, newTestProxyProtocolConfig, "HTTP", "http", []string{"true", "false"})
+	testValidValues(t, newTestProxyProtocolConfig, "HTTPS", "https", []string{"true", "false"})
+	testValidValues(t, newTestProxyProtocolConfig, "Stream", "stream", []string{"true", "false"})
+}
+
+func TestInvalidRealIPHeader(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "RealIPHeader", "realIpHeader", []string{"X Forwarded For", "X-Forwarded-For;", "$remote_addr"})
+}
+
+func TestValidRealIPHeader(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "RealIPHeader", "realIpHeader", []string{"proxy_protocol", "X-Forwarded-For", "X-Real-IP", "CF-Connecting-IP"})
+}
+
 func TestInvalidUseProxyProtocol(t *testing.T) {
 	testInvalidValues(t, newTestRouterConfig, "UseProxyProtocol", "useProxyProtocol", []string{"0", "-1", "foobar"})
 }
 
-func TestValidUseProxyProtocol(t *testing.T) {
-	testValidValues(t, newTestRouterConfig, "UseProxyProtocol", "useProxyProtocol", []string{"true", "false", "TRUE", "FALSE"})
+func TestValidUseProxyProtocol(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "UseProxyProtocol", "useProxyProtocol", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidEnforceWhitelists(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "EnforceWhitelists", "enforceWhitelists", []string{"0", "-1", "foobar"})
+}
+
+func TestValidEnforceWhitelists(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "EnforceWhitelists", "enforceWhitelists", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidDefaultWhitelist(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "DefaultWhitelist", "defaultWhitelist", []string{"0", "-1", "foobar"})
+}
+
+func TestValidDefaultWhitelist(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "DefaultWhitelist", "defaultWhitelist", []string{"1.2.3.4", "0.0.0.0/0", "1.2.3.4,0.0.0.0/0", "1.2.3.4, 0.0.0.0/0"})
+}
+
+func TestInvalidWhitelistMode(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "WhitelistMode", "whitelistMode", []string{"0", "-1", "foobar"})
+}
+
+func TestValidWhitelistMode(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "WhitelistMode", "whitelistMode", []string{"extend", "override"})
+}
+
+func TestValidHTTP2Enabled(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "HttpEnabled", "http2Enabled", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidHTTP2Enabled(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "HTTP2Enabled", "http2Enabled", []string{"0", "-1", "foobar"})
+}
+
+func TestValidClientCerts(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "ClientCertificates", "clientCertificates", []string{"asdf", "two==", "one=", "z+/xcv", "poiu,lkjh==", "poiu,lkjh==,a+/s=,b"})
+}
+
+func TestInvalidClientCerts(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "ClientCertificates", "clientCertificates", []string{"asdf===", ",asdf==", "asdf=,", "asdf,,asdf", "", "=", "wi#a=="})
+}
+
+func TestInvalidClientBodyTempPath(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "ClientBodyTempPath", "clientBodyTempPath", []string{"tmp", "/tmp/foo bar", "/tmp;", "/tmp/{foo}"})
+}
+
+func TestValidClientBodyTempPath(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "ClientBodyTempPath", "clientBodyTempPath", []string{"/", "/tmp", "/var/cache/router/client_body"})
+}
+
+func TestInvalidProxyTempPath(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "ProxyTempPath", "proxyTempPath", []string{"tmp", "/tmp/foo bar", "/tmp;", "/tmp/'foo'"})
+}
+
+func TestValidProxyTempPath(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "ProxyTempPath", "proxyTempPath", []string{"/", "/tmp", "/var/cache/router/proxy"})
+}
+
+func TestInvalidProxyMaxTempFileSize(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "ProxyMaxTempFileSize", "proxyMaxTempFileSize", []string{"-1", "foobar", "1t", "1 m"})
+}
+
+func TestValidProxyMaxTempFileSize(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "ProxyMaxTempFileSize", "proxyMaxTempFileSize", []string{"0", "1024", "1k", "512m", "1G"})
+}
+
+func TestInvalidSendfile(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "Sendfile", "sendfile", []string{"0", "-1", "foobar", "on"})
+}
+
+func TestValidSendfile(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "Sendfile", "sendfile", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidTCPNopush(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "TCPNopush", "tcpNopush", []string{"0", "-1", "foobar", "on"})
+}
+
+func TestValidTCPNopush(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "TCPNopush", "tcpNopush", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidTCPNodelay(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "TCPNodelay", "tcpNodelay", []string{"0", "-1", "foobar", "on"})
+}
+
+func TestValidTCPNodelay(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "TCPNodelay", "tcpNodelay", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidAIO(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "AIO", "aio", []string{"0", "true", "foobar", "threads=default", "ON"})
+}
+
+func TestValidAIO(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "AIO", "aio", []string{"on", "off", "threads"})
+}
+
+func TestInvalidDirectio(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "Directio", "directio", []string{"0", "-1", "foobar", "on", "4t"})
+}
+
+func TestValidDirectio(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "Directio", "directio", []string{"off", "512", "4k", "4m", "1G"})
+}
+
+func TestInvalidBacklog(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "Backlog", "backlog", []string{"0", "-1", "foobar", "1k"})
+}
+
+func TestValidBacklog(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "Backlog", "backlog", []string{"1", "511", "65535"})
+}
+
+func TestInvalidMultiAccept(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "MultiAccept", "multiAccept", []string{"0", "-1", "foobar", "on"})
+}
+
+func TestValidMultiAccept(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "MultiAccept", "multiAccept", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidAcceptMutex(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "AcceptMutex", "acceptMutex", []string{"0", "-1", "foobar", "on"})
+}
+
+func TestValidAcceptMutex(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "AcceptMutex", "acceptMutex", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidIngressClass(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "IngressClass", "ingressClass", []string{"-1", "foo_bar", "Deis", "deis-"})
+}
+
+func TestValidIngressClass(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "IngressClass", "ingressClass", []string{"deis", "deis-public", "nginx2"})
+}
+
+func TestInvalidRoutingClass(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "RoutingClass", "routingClass", []string{"-1", "foo_bar", "Internal", "internal-"})
+}
+
+func TestValidRoutingClass(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "RoutingClass", "routingClass", []string{"internal", "public", "edge-2"})
+}
+
+func TestInvalidNamespaceSelector(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "NamespaceSelector", "namespaceSelector", []string{"team=web;", "team=\"web\"", "team={web}"})
+}
+
+func TestValidNamespaceSelector(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "NamespaceSelector", "namespaceSelector", []string{"router.deis.io/routable=true", "team in (web, api),tier!=internal", "!restricted"})
+}
+
+func TestInvalidNamespaces(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "Namespaces", "namespaces", []string{"Foo", "foo,", "foo, bar", "foo_bar"})
+}
+
+func TestValidNamespaces(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "Namespaces", "namespaces", []string{"foo", "foo,bar", "deis,team-web"})
+}
+
+func TestInvalidOpenFileCacheEnabled(t *testing.T) {
+	testInvalidValues(t, newTestOpenFileCacheConfig, "Enabled", "enabled", []string{"0", "-1", "foobar"})
+}
+
+func TestValidOpenFileCacheEnabled(t *testing.T) {
+	testValidValues(t, newTestOpenFileCacheConfig, "Enabled", "enabled", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidOpenFileCacheMax(t *testing.T) {
+	testInvalidValues(t, newTestOpenFileCacheConfig, "Max", "max", []string{"0", "-1", "foobar", "1k"})
+}
+
+func TestValidOpenFileCacheMax(t *testing.T) {
+	testValidValues(t, newTestOpenFileCacheConfig, "Max", "max", []string{"1", "1000", "200000"})
+}
+
+func TestInvalidOpenFileCacheInactive(t *testing.T) {
+	testInvalidValues(t, newTestOpenFileCacheConfig, "Inactive", "inactive", []string{"0", "-1", "foobar"})
+}
+
+func TestValidOpenFileCacheInactive(t *testing.T) {
+	testValidValues(t, newTestOpenFileCacheConfig, "Inactive", "inactive", []string{"1", "20s", "10m"})
+}
+
+func TestInvalidOpenFileCacheValid(t *testing.T) {
+	testInvalidValues(t, newTestOpenFileCacheConfig, "Valid", "valid", []string{"0", "-1", "foobar"})
+}
+
+func TestValidOpenFileCacheValid(t *testing.T) {
+	testValidValues(t, newTestOpenFileCacheConfig, "Valid", "valid", []string{"1", "30s", "2m"})
+}
+
+func TestInvalidOpenFileCacheMinUses(t *testing.T) {
+	testInvalidValues(t, newTestOpenFileCacheConfig, "MinUses", "minUses", []string{"0", "-1", "foobar"})
+}
+
+func TestValidOpenFileCacheMinUses(t *testing.T) {
+	testValidValues(t, newTestOpenFileCacheConfig, "MinUses", "minUses", []string{"1", "2", "10"})
+}
+
+func TestInvalidOpenFileCacheErrors(t *testing.T) {
+	testInvalidValues(t, newTestOpenFileCacheConfig, "Errors", "errors", []string{"0", "-1", "foobar"})
+}
+
+func TestValidOpenFileCacheErrors(t *testing.T) {
+	testValidValues(t, newTestOpenFileCacheConfig, "Errors", "errors", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidGzipEnabled(t *testing.T) {
+	testInvalidValues(t, newTestGzipConfig, "Enabled", "enabled", []string{"0", "-1", "foobar"})
+}
+
+func TestValidGzipEnabled(t *testing.T) {
+	testValidValues(t, newTestGzipConfig, "Enabled", "enabled", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidGzipCompLevel(t *testing.T) {
+	testInvalidValues(t, newTestGzipConfig, "CompLevel", "compLevel", []string{"0", "-1", "foobar"})
+}
+
+func TestValidGzipCompLevel(t *testing.T) {
+	testValidValues(t, newTestGzipConfig, "CompLevel", "compLevel", []string{"1", "2", "3", "4"})
+}
+
+func TestInvalidGzipHTTPVersion(t *testing.T) {
+	testInvalidValues(t, newTestGzipConfig, "HTTPVersion", "httpVersion", []string{"0", "-1", "foobar"})
+}
+
+func TestValidGzipHTTPVersion(t *testing.T) {
+	testValidValues(t, newTestGzipConfig, "HTTPVersion", "httpVersion", []string{"1.0", "1.1"})
+}
+
+func TestInvalidGzipMinLength(t *testing.T) {
+	testInvalidValues(t, newTestGzipConfig, "MinLength", "minLength", []string{"-1", "foobar"})
+}
+
+func TestValidGzipMinLength(t *testing.T) {
+	testValidValues(t, newTestGzipConfig, "MinLength", "minLength", []string{"0", "1", "2", "20"})
+}
+
+func TestInvalidGzipProxied(t *testing.T) {
+	testInvalidValues(t, newTestGzipConfig, "Proxied", "proxied", []string{"0", "-1", "foobar"})
+}
+
+func TestValidGzipProxied(t *testing.T) {
+	testValidValues(t, newTestGzipConfig, "Proxied", "proxied", []string{"off", "expired", "no-cache", "no-store private no_etag"})
+}
+
+func TestInvalidGzipTypes(t *testing.T) {
+	testInvalidValues(t, newTestGzipConfig, "Types", "types", []string{"0", "-1", "foobar"})
+}
+
+func TestValidGzipTypes(t *testing.T) {
+	testValidValues(t, newTestGzipConfig, "Types", "types", []string{"application/json", "application/json application/text"})
+}
+
+func TestInvalidGzipVary(t *testing.T) {
+	testInvalidValues(t, newTestGzipConfig, "Vary", "vary", []string{"0", "-1", "foobar"})
+}
+
+func TestValidGzipVary(t *testing.T) {
+	testValidValues(t, newTestGzipConfig, "Vary", "vary", []string{"on", "off"})
+}
+
+func TestInvalidAppLoadBalancingAlgorithm(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "LoadBalancingAlgorithm", "nginx.loadBalancingAlgorithm", []string{"0", "foobar", "least_time", "ROUND_ROBIN"})
+}
+
+func TestValidAppLoadBalancingAlgorithm(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "LoadBalancingAlgorithm", "nginx.loadBalancingAlgorithm", []string{"round_robin", "least_conn", "ip_hash"})
+}
+
+func TestInvalidAppBackendProtocol(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "BackendProtocol", "backendProtocol", []string{"0", "HTTPS", "H2C", "GRPC", "h2"})
+}
+
+func TestValidAppBackendProtocol(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "BackendProtocol", "backendProtocol", []string{"http", "https", "grpc", "grpcs", "h2c"})
+}
+
+func TestInvalidAppGRPCWeb(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "GRPCWeb", "nginx.grpcWeb", []string{"0", "-1", "foobar"})
+}
+
+func TestValidAppGRPCWeb(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "GRPCWeb", "nginx.grpcWeb", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidUpstreamTLSName(t *testing.T) {
+	testInvalidValues(t, newTestUpstreamTLSConfig, "Name", "name", []string{"", "-foo", "foo.example.com;", "$request_uri", "foo example.com"})
+}
+
+func TestValidUpstreamTLSName(t *testing.T) {
+	testValidValues(t, newTestUpstreamTLSConfig, "Name", "name", []string{"api.example.com", "gateway", "$host"})
+}
+
+func TestInvalidUpstreamTLSProtocols(t *testing.T) {
+	testInvalidValues(t, newTestUpstreamTLSConfig, "Protocols", "protocols", []string{"0", "TLSv9", "tlsv1.2"})
+}
+
+func TestValidUpstreamTLSProtocols(t *testing.T) {
+	testValidValues(t, newTestUpstreamTLSConfig, "Protocols", "protocols", []string{"TLSv1.2", "TLSv1.2 TLSv1.3"})
+}
+
+func TestInvalidUpstreamTLSVerify(t *testing.T) {
+	testInvalidValues(t, newTestUpstreamTLSConfig, "Verify", "verify", []string{"0", "-1", "foobar"})
+}
+
+func TestValidUpstreamTLSVerify(t *testing.T) {
+	testValidValues(t, newTestUpstreamTLSConfig, "Verify", "verify", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidUpstreamTLSVerifyDepth(t *testing.T) {
+	testInvalidValues(t, newTestUpstreamTLSConfig, "VerifyDepth", "verifyDepth", []string{"0", "-1", "foobar"})
+}
+
+func TestValidUpstreamTLSVerifyDepth(t *testing.T) {
+	testValidValues(t, newTestUpstreamTLSConfig, "VerifyDepth", "verifyDepth", []string{"1", "4"})
+}
+
+func TestInvalidTransformStripPrefix(t *testing.T) {
+	testInvalidValues(t, newTestTransformConfig, "StripPrefix", "stripPrefix", []string{"", "api", "/api;", "/api v1", "/$uri", "/api{"})
+}
+
+func TestValidTransformStripPrefix(t *testing.T) {
+	testValidValues(t, newTestTransformConfig, "StripPrefix", "stripPrefix", []string{"/", "/api", "/api/v1/", "/caf%C3%A9"})
+}
+
+func TestInvalidTransformAddPrefix(t *testing.T) {
+	testInvalidValues(t, newTestTransformConfig, "AddPrefix", "addPrefix", []string{"", "v2", "/v2\"", "/v2?x=1"})
+}
+
+func TestValidTransformAddPrefix(t *testing.T) {
+	testValidValues(t, newTestTransformConfig, "AddPrefix", "addPrefix", []string{"/", "/v2", "/legacy/app"})
+}
+
+func TestInvalidStripPrefix(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "StripPrefix", "nginx.stripPrefix", []string{"", "api", "/api;", "/api v1", "/$uri"})
+}
+
+func TestValidStripPrefix(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "StripPrefix", "nginx.stripPrefix", []string{"/", "/api", "/api/v1/"})
+}
+
+func TestInvalidAddPrefix(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "AddPrefix", "nginx.addPrefix", []string{"", "v2", "/v2\"", "/v2?x=1"})
+}
+
+func TestValidAddPrefix(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "AddPrefix", "nginx.addPrefix", []string{"/", "/v2", "/legacy/app"})
+}
+
+func TestInvalidAppProxyBind(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "ProxyBind", "nginx.proxyBind", []string{"OFF", "foobar", "1.2.3.4;", "1.2.3.4 transparent"})
+}
+
+func TestValidAppProxyBind(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "ProxyBind", "nginx.proxyBind", []string{"off", "10.0.0.5", "fd00::5"})
+}
+
+func TestInvalidExternalOrigin(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "ExternalOrigin", "nginx.externalOrigin", []string{"foobar", "ftp://legacy.example.net", "https://legacy.example.net/", "https://legacy.example.net/path", "https://legacy.example.net:0", "https://-legacy.example.net", "https://legacy.example.net; return 200"})
+}
+
+func TestValidExternalOrigin(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "ExternalOrigin", "nginx.externalOrigin", []string{"https://legacy.example.net", "http://legacy.example.net:8080", "http://10.0.0.5", "https://Legacy.Example.NET"})
+}
+
+func TestInvalidBackupOrigin(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "BackupOrigin", "nginx.backupOrigin", []string{"", "https://backup.example.net", "backup.example.net/", "backup.example.net:0", "-backup.example.net", "backup.example.net backup"})
+}
+
+func TestValidBackupOrigin(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "BackupOrigin", "nginx.backupOrigin", []string{"backup.example.net", "backup.example.net:8443", "10.0.0.5:80"})
+}
+
+func TestInvalidTransformJSONErrors(t *testing.T) {
+	testInvalidValues(t, newTestTransformConfig, "JSONErrors", "jsonErrors", []string{"0", "-1", "foobar"})
+}
+
+func TestValidTransformJSONErrors(t *testing.T) {
+	testValidValues(t, newTestTransformConfig, "JSONErrors", "jsonErrors", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidTransformHeaderCase(t *testing.T) {
+	testInvalidValues(t, newTestTransformConfig, "HeaderCase", "headerCase", []string{"", "X-API-Key;", "X API Key", "X-API-Key,,Accept"})
+}
+
+func TestValidTransformHeaderCase(t *testing.T) {
+	testValidValues(t, newTestTransformConfig, "HeaderCase", "headerCase", []string{"X-API-Key", "X-API-Key, SOAPAction"})
+}
+
+func TestInvalidRedirectTo(t *testing.T) {
+	testInvalidValues(t, newTestRedirectConfig, "To", "to", []string{"", "ftp://example.net", "example.net/path", "https://example.net/", "example..net", "-example.net", "example.net:0", "example.net; return 200"})
+}
+
+func TestValidRedirectTo(t *testing.T) {
+	testValidValues(t, newTestRedirectConfig, "To", "to", []string{"example.net", "www.Example.NET", "https://example.net", "http://example.net:8080", "localhost"})
+}
+
+func TestInvalidRedirectStatus(t *testing.T) {
+	testInvalidValues(t, newTestRedirectConfig, "Status", "status", []string{"200", "303", "404", "foobar"})
+}
+
+func TestValidRedirectStatus(t *testing.T) {
+	testValidValues(t, newTestRedirectConfig, "Status", "status", []string{"301", "302", "307", "308"})
+}
+
+func TestInvalidRedirectWWW(t *testing.T) {
+	testInvalidValues(t, newTestRedirectConfig, "WWW", "www", []string{"", "true", "foobar"})
+}
+
+func TestValidRedirectWWW(t *testing.T) {
+	testValidValues(t, newTestRedirectConfig, "WWW", "www", []string{"force", "strip"})
+}
+
+func TestInvalidRedirectSSLExemptPaths(t *testing.T) {
+	testInvalidValues(t, newTestRedirectConfig, "SSLExemptPaths", "sslExemptPaths", []string{"", "healthz", "/healthz;", "/health check", "/healthz,,/ping"})
+}
+
+func TestValidRedirectSSLExemptPaths(t *testing.T) {
+	testValidValues(t, newTestRedirectConfig, "SSLExemptPaths", "sslExemptPaths", []string{"/healthz", "/healthz, /legacy/ping.html"})
+}
+
+func TestInvalidCORSAllowOrigins(t *testing.T) {
+	testInvalidValues(t, newTestCORSConfig, "AllowOrigins", "allowOrigins", []string{"", "example.com", "https://example.com/", "https://Example.com", "https://*example.com", "https://foo.*.example.com", "https://example.com; add_header"})
+}
+
+func TestValidCORSAllowOrigins(t *testing.T) {
+	testValidValues(t, newTestCORSConfig, "AllowOrigins", "allowOrigins", []string{"*", "https://example.com", "http://localhost:3000, https://*.example.com"})
+}
+
+func TestInvalidCORSAllowMethods(t *testing.T) {
+	testInvalidValues(t, newTestCORSConfig, "AllowMethods", "allowMethods", []string{"", "get", "GET;", "GET,,POST"})
+}
+
+func TestValidCORSAllowMethods(t *testing.T) {
+	testValidValues(t, newTestCORSConfig, "AllowMethods", "allowMethods", []string{"GET", "GET, POST, OPTIONS"})
+}
+
+func TestInvalidCORSAllowHeaders(t *testing.T) {
+	testInvalidValues(t, newTestCORSConfig, "AllowHeaders", "allowHeaders", []string{"", "X API Key", "X-API-Key\"", "Content-Type,,Accept"})
+}
+
+func TestValidCORSAllowHeaders(t *testing.T) {
+	testValidValues(t, newTestCORSConfig, "AllowHeaders", "allowHeaders", []string{"Content-Type", "Content-Type, X-API-Key"})
+}
+
+func TestInvalidCORSExposeHeaders(t *testing.T) {
+	testInvalidValues(t, newTestCORSConfig, "ExposeHeaders", "exposeHeaders", []string{"", "X Total", "X-Total;"})
+}
+
+func TestValidCORSExposeHeaders(t *testing.T) {
+	testValidValues(t, newTestCORSConfig, "ExposeHeaders", "exposeHeaders", []string{"X-Total-Count", "X-Total-Count, ETag"})
+}
+
+func TestInvalidCORSMaxAge(t *testing.T) {
+	testInvalidValues(t, newTestCORSConfig, "MaxAge", "maxAge", []string{"-1", "010", "1h", "foobar"})
+}
+
+func TestValidCORSMaxAge(t *testing.T) {
+	testValidValues(t, newTestCORSConfig, "MaxAge", "maxAge", []string{"0", "600", "86400"})
+}
+
+func TestInvalidExternalAuthCacheTTL(t *testing.T) {
+	testInvalidValues(t, newTestExternalAuthConfig, "CacheTTL", "cacheTTL", []string{"0", "500ms", "2h", "foobar"})
+}
+
+func TestValidExternalAuthCacheTTL(t *testing.T) {
+	testValidValues(t, newTestExternalAuthConfig, "CacheTTL", "cacheTTL", []string{"1s", "30s", "5m", "1h"})
+}
+
+func TestInvalidExternalAuthCacheKey(t *testing.T) {
+	testInvalidValues(t, newTestExternalAuthConfig, "CacheKey", "cacheKey", []string{"", "Authorization", "header:", "body:token", "header:Authorization,,uri", "$http_authorization"})
+}
+
+func TestValidExternalAuthCacheKey(t *testing.T) {
+	testValidValues(t, newTestExternalAuthConfig, "CacheKey", "cacheKey", []string{"header:Authorization", "cookie:_oauth2_proxy, uri, method", "arg:token"})
+}
+
+func TestInvalidWAFMode(t *testing.T) {
+	testInvalidValues(t, newTestWAFConfig, "Mode", "mode", []string{"on", "DetectionOnly", "Block", "foobar"})
+}
+
+func TestValidWAFMode(t *testing.T) {
+	testValidValues(t, newTestWAFConfig, "Mode", "mode", []string{"detect", "block"})
+}
+
+func TestInvalidWAFRulesConfigMap(t *testing.T) {
+	testInvalidValues(t, newTestWAFConfig, "RulesConfigMap", "rulesConfigMap", []string{"-waf", "waf-", "WAF", "waf_rules", "waf rules"})
+}
+
+func TestValidWAFRulesConfigMap(t *testing.T) {
+	testValidValues(t, newTestWAFConfig, "RulesConfigMap", "rulesConfigMap", []string{"waf", "bar-waf-rules", "waf.rules"})
+}
+
+func TestInvalidUpstreamKeepaliveConnections(t *testing.T) {
+	testInvalidValues(t, newTestUpstreamKeepaliveConfig, "Connections", "connections", []string{"-1", "01", "foobar", "1.5"})
+}
+
+func TestValidUpstreamKeepaliveConnections(t *testing.T) {
+	testValidValues(t, newTestUpstreamKeepaliveConfig, "Connections", "connections", []string{"0", "1", "32", "1000"})
+}
+
+func TestInvalidUpstreamKeepaliveRequests(t *testing.T) {
+	testInvalidValues(t, newTestUpstreamKeepaliveConfig, "Requests", "requests", []string{"0", "-1", "foobar"})
+}
+
+func TestValidUpstreamKeepaliveRequests(t *testing.T) {
+	testValidValues(t, newTestUpstreamKeepaliveConfig, "Requests", "requests", []string{"1", "100", "10000"})
+}
+
+func TestInvalidUpstreamKeepaliveTimeout(t *testing.T) {
+	testInvalidValues(t, newTestUpstreamKeepaliveConfig, "Timeout", "timeout", []string{"0", "-1", "500ms", "foobar"})
+}
+
+func TestValidUpstreamKeepaliveTimeout(t *testing.T) {
+	testValidValues(t, newTestUpstreamKeepaliveConfig, "Timeout", "timeout", []string{"1s", "60s", "5m"})
+}
+
+func TestInvalidHealthCheckMaxFails(t *testing.T) {
+	testInvalidValues(t, newTestHealthCheckConfig, "MaxFails", "maxFails", []string{"-1", "100", "05", "foobar"})
+}
+
+func TestValidHealthCheckMaxFails(t *testing.T) {
+	testValidValues(t, newTestHealthCheckConfig, "MaxFails", "maxFails", []string{"0", "1", "3", "99"})
+}
+
+func TestInvalidHealthCheckFailTimeout(t *testing.T) {
+	testInvalidValues(t, newTestHealthCheckConfig, "FailTimeout", "failTimeout", []string{"0", "500ms", "2h", "foobar"})
+}
+
+func TestValidHealthCheckFailTimeout(t *testing.T) {
+	testValidValues(t, newTestHealthCheckConfig, "FailTimeout", "failTimeout", []string{"1s", "10s", "1h"})
+}
+
+func TestInvalidHealthCheckPath(t *testing.T) {
+	testInvalidValues(t, newTestHealthCheckConfig, "Path", "path", []string{"healthz", "/health z", "/healthz;", "/healthz#top"})
+}
+
+func TestValidHealthCheckPath(t *testing.T) {
+	testValidValues(t, newTestHealthCheckConfig, "Path", "path", []string{"/", "/healthz", "/status?verbose=1"})
+}
+
+func TestInvalidHealthCheckInterval(t *testing.T) {
+	testInvalidValues(t, newTestHealthCheckConfig, "Interval", "interval", []string{"0", "500ms", "10m", "foobar"})
+}
+
+func TestValidHealthCheckInterval(t *testing.T) {
+	testValidValues(t, newTestHealthCheckConfig, "Interval", "interval", []string{"1s", "10s", "5m"})
+}
+
+func TestInvalidHealthCheckTimeout(t *testing.T) {
+	testInvalidValues(t, newTestHealthCheckConfig, "Timeout", "timeout", []string{"0", "10ms", "2m", "foobar"})
+}
+
+func TestValidHealthCheckTimeout(t *testing.T) {
+	testValidValues(t, newTestHealthCheckConfig, "Timeout", "timeout", []string{"100ms", "2s", "1m"})
+}
+
+func TestInvalidHealthCheckThresholds(t *testing.T) {
+	testInvalidValues(t, newTestHealthCheckConfig, "UnhealthyThreshold", "unhealthyThreshold", []string{"0", "11", "-1", "foobar"})
+	testInvalidValues(t, newTestHealthCheckConfig, "HealthyThreshold", "healthyThreshold", []string{"0", "11", "-1", "foobar"})
+}
+
+func TestValidHealthCheckThresholds(t *testing.T) {
+	testValidValues(t, newTestHealthCheckConfig, "UnhealthyThreshold", "unhealthyThreshold", []string{"1", "3", "10"})
+	testValidValues(t, newTestHealthCheckConfig, "HealthyThreshold", "healthyThreshold", []string{"1", "2", "10"})
+}
+
+func TestInvalidRetryAttempts(t *testing.T) {
+	testInvalidValues(t, newTestRetryConfig, "Attempts", "attempts", []string{"-1", "11", "05", "foobar"})
+}
+
+func TestValidRetryAttempts(t *testing.T) {
+	testValidValues(t, newTestRetryConfig, "Attempts", "attempts", []string{"0", "3", "10"})
+}
+
+func TestInvalidRetryBackoff(t *testing.T) {
+	testInvalidValues(t, newTestRetryConfig, "Backoff", "backoff", []string{"0", "0ms", "11s", "foobar"})
+}
+
+func TestValidRetryBackoff(t *testing.T) {
+	testValidValues(t, newTestRetryConfig, "Backoff", "backoff", []string{"1ms", "250ms", "10s"})
+}
+
+func TestInvalidRetryMaxBackoff(t *testing.T) {
+	testInvalidValues(t, newTestRetryConfig, "MaxBackoff", "maxBackoff", []string{"0", "31s", "1m", "foobar"})
+}
+
+func TestValidRetryMaxBackoff(t *testing.T) {
+	testValidValues(t, newTestRetryConfig, "MaxBackoff", "maxBackoff", []string{"1ms", "2s", "30s"})
+}
+
+func TestInvalidAppHTTP10Compatible(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "HTTP10Compatible", "nginx.http10Compatible", []string{"0", "-1", "foobar"})
+}
+
+func TestValidAppHTTP10Compatible(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "HTTP10Compatible", "nginx.http10Compatible", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidAppHealthcheckPaths(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "HealthcheckPaths", "nginx.healthcheckPaths", []string{"healthz", "/healthz,", "/healthz, /ping", "/health z", "/healthz;"})
+}
+
+func TestValidAppHealthcheckPaths(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "HealthcheckPaths", "nginx.healthcheckPaths", []string{"/", "/healthz", "/healthz,/ping", "/_status/ready"})
+}
+
+func TestInvalidAppAffinity(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "Affinity", "nginx.affinity", []string{"0", "foobar", "COOKIE", "ip"})
+}
+
+func TestValidAppAffinity(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "Affinity", "nginx.affinity", []string{"none", "cookie"})
+}
+
+func TestInvalidAppAffinityCookie(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "AffinityCookie", "nginx.affinityCookie", []string{"foo-bar", "foo bar", "foo;bar", "$foo"})
+}
+
+func TestValidAppAffinityCookie(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "AffinityCookie", "nginx.affinityCookie", []string{"route", "deis_affinity", "SESSION1"})
+}
+
+func TestInvalidAppCanaryService(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "CanaryService", "canaryService", []string{"-foo", "foo_bar", "Foo", "foo.bar"})
+}
+
+func TestValidAppCanaryService(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "CanaryService", "canaryService", []string{"foo", "foo-canary", "v2"})
+}
+
+func TestInvalidAppCanaryServicePort(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "CanaryServicePort", "canaryServicePort", []string{"0", "-1", "8080-", "_http"})
+}
+
+func TestValidAppCanaryServicePort(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "CanaryServicePort", "canaryServicePort", []string{"80", "8080", "http", "web-1"})
+}
+
+func TestInvalidAppCanaryWeight(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "CanaryWeight", "canaryWeight", []string{"-1", "101", "05", "5%", "foobar"})
+}
+
+func TestValidAppCanaryWeight(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "CanaryWeight", "canaryWeight", []string{"0", "1", "5", "50", "100"})
+}
+
+func TestInvalidStreamTCPPorts(t *testing.T) {
+	testInvalidValues(t, newTestStreamPortsConfig, "TCPPorts", "tcpPorts", []string{"5432", "0:5432", "5432:", "5432:0", "5432:Postgres", "5432:5432,", "5432:5432:5432"})
+}
+
+func TestValidStreamTCPPorts(t *testing.T) {
+	testValidValues(t, newTestStreamPortsConfig, "TCPPorts", "tcpPorts", []string{"5432:5432", "15432:postgres", "1883:mqtt, 8883:mqtts"})
+}
+
+func TestInvalidStreamUDPPorts(t *testing.T) {
+	testInvalidValues(t, newTestStreamPortsConfig, "UDPPorts", "udpPorts", []string{"53", "-53:53", "5353:dns_udp"})
+}
+
+func TestValidStreamUDPPorts(t *testing.T) {
+	testValidValues(t, newTestStreamPortsConfig, "UDPPorts", "udpPorts", []string{"5353:53", "5353:dns", "5353:53,5354:54"})
+}
+
+func TestInvalidAppDomains(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "Domains", "domains", []string{"-1", "foo_bar", "foobar.c", "foo bar", "example.com:", "example.com:https", "example.com.."})
+}
+
+func TestValidAppDomains(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "Domains", "domains", []string{"foobar", "foo-bar", "foobar.com", "foobar,foobar.com", "foobar, foobar.com", "*.foobar.com", "xn--eckwd4c7c.xn--zckzah", "xn--80ahd1agd.ru", "xn--tst-qla.xn--knigsgsschen-lcb0w.de", "Example.COM", "example.com.", "example.com:443", "Example.COM.:443, foobar"})
+}
+
+func TestInvalidAppWhitelist(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "Whitelist", "whitelist", []string{"0", "-1", "foobar"})
+}
+
+func TestValidAppWhitelist(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "Whitelist", "whitelist", []string{"1.2.3.4", "0.0.0.0/0", "1.2.3.4,0.0.0.0/0", "1.2.3.4, 0.0.0.0/0"})
+}
+
+func TestInvalidAppWhitelistSources(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "WhitelistSources", "whitelistSources", []string{"-cdn", "CDN", "cdn_ranges", "1.2.3.4/32"})
+}
+
+func TestValidAppWhitelistSources(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "WhitelistSources", "whitelistSources", []string{"cdn", "cloudflare,office", "cloudflare, office-vpn"})
+}
+
+func TestInvalidAppConnectTimeout(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "ConnectTimeout", "connectTimeout", []string{"0", "-1", "foobar"})
+}
+
+func TestValidAppConnectTimeout(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "ConnectTimeout", "connectTimeout", []string{"1", "2", "10", "1ms", "2s", "10m"})
+}
+
+func TestInvalidAppTCPTimeout(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "TCPTimeout", "tcpTimeout", []string{"0", "-1", "foobar"})
+}
+
+func TestValidAppTCPTimeout(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "TCPTimeout", "tcpTimeout", []string{"1", "2", "10", "1ms", "2s", "10m"})
+}
+
+func TestInvalidAppReadTimeout(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "ReadTimeout", "readTimeout", []string{"0", "-1", "foobar"})
+}
+
+func TestValidAppReadTimeout(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "ReadTimeout", "readTimeout", []string{"1", "2", "10", "1ms", "2s", "10m", "1h"})
+}
+
+func TestInvalidAppSendTimeout(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "SendTimeout", "sendTimeout", []string{"0", "-1", "foobar"})
+}
+
+func TestValidAppSendTimeout(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "SendTimeout", "sendTimeout", []string{"1", "2", "10", "1ms", "2s", "10m", "1h"})
+}
+
+func TestInvalidAppProxyBuffering(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "ProxyBuffering", "nginx.proxyBuffering", []string{"0", "-1", "foobar"})
+}
+
+func TestValidAppProxyBuffering(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "ProxyBuffering", "nginx.proxyBuffering", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidAppWebsockets(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "Websockets", "nginx.websockets", []string{"0", "-1", "foobar"})
+}
+
+func TestValidAppWebsockets(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "Websockets", "nginx.websockets", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidAppAllowedResponseHeaders(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "AllowedResponseHeaders", "nginx.allowedResponseHeaders", []string{"", "X Request Id", "Location;", "Location,,Set-Cookie"})
+}
+
+func TestValidAppAllowedResponseHeaders(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "AllowedResponseHeaders", "nginx.allowedResponseHeaders", []string{"Location", "Location, Set-Cookie"})
+}
+
+func TestInvalidAppBodySize(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "BodySize", "nginx.bodySize", []string{"-1", "foobar", "1t"})
+}
+
+func TestValidAppBodySize(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "BodySize", "nginx.bodySize", []string{"0", "1", "20", "1k", "10m", "10M", "5g", "5G"})
+}
+
+func TestInvalidAppRequestBuffering(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "RequestBuffering", "nginx.requestBuffering", []string{"0", "-1", "foobar"})
+}
+
+func TestValidAppRequestBuffering(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "RequestBuffering", "nginx.requestBuffering", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidAppClientBodyBufferSize(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "ClientBodyBufferSize", "nginx.clientBodyBufferSize", []string{"-1", "foobar", "1g"})
+}
+
+func TestValidAppClientBodyBufferSize(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "ClientBodyBufferSize", "nginx.clientBodyBufferSize", []string{"8k", "16K", "1m", "1024"})
+}
+
+func TestInvalidAppClientBodyTempPath(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "ClientBodyTempPath", "nginx.clientBodyTempPath", []string{"uploads", "/var/uploads;", "/var/up loads"})
+}
+
+func TestValidAppClientBodyTempPath(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "ClientBodyTempPath", "nginx.clientBodyTempPath", []string{"/var/uploads", "/mnt/scratch/foo"})
+}
+
+func TestInvalidCacheControlValue(t *testing.T) {
+	testInvalidValues(t, newTestCacheControlConfig, "Value", "value", []string{"max-age=60;", "\"public\"", "private=\"Set-Cookie\"", "-public"})
+}
+
+func TestValidCacheControlValue(t *testing.T) {
+	testValidValues(t, newTestCacheControlConfig, "Value", "value", []string{"no-cache", "public, max-age=3600", "public, max-age=60, stale-while-revalidate=30"})
+}
+
+func TestInvalidCacheControlExpires(t *testing.T) {
+	testInvalidValues(t, newTestCacheControlConfig, "Expires", "expires", []string{"0", "-1", "500ms", "foobar"})
+}
+
+func TestValidCacheControlExpires(t *testing.T) {
+	testValidValues(t, newTestCacheControlConfig, "Expires", "expires", []string{"1s", "1h", "30d"})
+}
+
+func TestInvalidCacheControlForce(t *testing.T) {
+	testInvalidValues(t, newTestCacheControlConfig, "Force", "force", []string{"0", "-1", "foobar"})
+}
+
+func TestValidCacheControlForce(t *testing.T) {
+	testValidValues(t, newTestCacheControlConfig, "Force", "force", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidLocationCacheControl(t *testing.T) {
+	testInvalidValues(t, newTestLocationConfig, "CacheControl", "cacheControl", []string{"max-age=60;", "\"public\""})
+}
+
+func TestValidLocationCacheControl(t *testing.T) {
+	testValidValues(t, newTestLocationConfig, "CacheControl", "cacheControl", []string{"no-store", "public, max-age=31536000, immutable"})
+}
+
+func TestInvalidConditionalETag(t *testing.T) {
+	testInvalidValues(t, newTestConditionalConfig, "ETag", "etag", []string{"0", "-1", "foobar"})
+}
+
+func TestValidConditionalETag(t *testing.T) {
+	testValidValues(t, newTestConditionalConfig, "ETag", "etag", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidConditionalIfModifiedSince(t *testing.T) {
+	testInvalidValues(t, newTestConditionalConfig, "IfModifiedSince", "ifModifiedSince", []string{"on", "Exact", "after", "foobar"})
+}
+
+func TestValidConditionalIfModifiedSince(t *testing.T) {
+	testValidValues(t, newTestConditionalConfig, "IfModifiedSince", "ifModifiedSince", []string{"off", "exact", "before"})
+}
+
+func TestInvalidProxyCacheRevalidate(t *testing.T) {
+	testInvalidValues(t, newTestProxyCacheConfig, "Revalidate", "revalidate", []string{"0", "-1", "foobar"})
+}
+
+func TestValidProxyCacheRevalidate(t *testing.T) {
+	testValidValues(t, newTestProxyCacheConfig, "Revalidate", "revalidate", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidRangesForce(t *testing.T) {
+	testInvalidValues(t, newTestRangesConfig, "Force", "force", []string{"0", "-1", "foobar"})
+}
+
+func TestValidRangesForce(t *testing.T) {
+	testValidValues(t, newTestRangesConfig, "Force", "force", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidRangesMax(t *testing.T) {
+	testInvalidValues(t, newTestRangesConfig, "Max", "max", []string{"-1", "1.5", "unlimited"})
+}
+
+func TestValidRangesMax(t *testing.T) {
+	testValidValues(t, newTestRangesConfig, "Max", "max", []string{"0", "1", "16"})
+}
+
+func TestInvalidAppErrorFormat(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "ErrorFormat", "nginx.errorFormat", []string{"0", "xml", "JSON"})
+}
+
+func TestValidAppErrorFormat(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "ErrorFormat", "nginx.errorFormat", []string{"html", "json"})
+}
+
+func TestInvalidAppCDN(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "CDN", "nginx.cdn", []string{"0", "akamai", "Cloudflare"})
+}
+
+func TestValidAppCDN(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "CDN", "nginx.cdn", []string{"none", "cloudflare", "cloudfront", "fastly"})
+}
+
+func TestInvalidCertMappings(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "CertMappings", "certificates", []string{"0", "-1", "foobar"})
+}
+
+func TestValidCertMappings(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "CertMappings", "certificates", []string{"foobar.com:foobar,*.foobar.deis.ninja:foobar-deis-ninja", "FooBar.com.:foobar"})
+}
+
+func TestInvalidTLSSecrets(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "TLSSecrets", "tlsSecrets", []string{"0", "foobar", "foobar.com:-tls", "foobar.com:foo_tls"})
+}
+
+func TestValidTLSSecrets(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "TLSSecrets", "tlsSecrets", []string{"foobar.com:foobar-tls,*.foobar.deis.ninja:foobar.deis.ninja", "FooBar.com.:foobar-com-tls"})
+}
+
+func TestInvalidProxyProtocolTLVHeaders(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "ProxyProtocolTLVHeaders", "nginx.proxyProtocolTLVHeaders", []string{"0", "foobar", "X-Foo:", "X Foo:aws_vpce_id", "X-Foo:0xZZ", "X-Foo:$foo", "X-Vpce-Id:aws_vpce_id", "X-Link-Id:azure_pel_id", "X-Foo:ssl_foo"})
+}
+
+func TestValidProxyProtocolTLVHeaders(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "ProxyProtocolTLVHeaders", "nginx.proxyProtocolTLVHeaders", []string{"X-Vpce-Id:0xEA", "X-Authority:authority,X-Raw:0xEA", "X-Client-CN: ssl_cn, X-Client-Verify: ssl_verify", "X-Version:ssl_0x21"})
+}
+
+func TestInvalidEmergencyMode(t *testing.T) {
+	testInvalidValues(t, newTestEmergencyConfig, "Mode", "emergencyMode", []string{"on", "503", "foobar"})
+}
+
+func TestValidEmergencyMode(t *testing.T) {
+	testValidValues(t, newTestEmergencyConfig, "Mode", "emergencyMode", []string{"off", "static-503", "allowlist-only"})
+}
+
+func TestInvalidEmergencyAllowlist(t *testing.T) {
+	testInvalidValues(t, newTestEmergencyConfig, "Allowlist", "emergencyAllowlist", []string{"foobar", "1.2.3.4/33", "256.0.0.1"})
+}
+
+func TestValidEmergencyAllowlist(t *testing.T) {
+	testValidValues(t, newTestEmergencyConfig, "Allowlist", "emergencyAllowlist", []string{"1.2.3.4", "10.0.0.0/8", "1.2.3.4, 10.0.0.0/8"})
+}
+
+func TestInvalidProxyBind(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "ProxyBind", "proxyBind", []string{"off", "foobar", "256.0.0.1", "10.0.0.0/8", "$remote_addr"})
+}
+
+func TestValidProxyBind(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "ProxyBind", "proxyBind", []string{"10.0.0.5", "fd00::5"})
+}
+
+func TestInvalidCertExpiryWarningDays(t *testing.T) {
+	testInvalidValues(t, newTestCertExpiryConfig, "WarningDays", "warningDays", []string{"-1", "07", "foobar"})
+}
+
+func TestValidCertExpiryWarningDays(t *testing.T) {
+	testValidValues(t, newTestCertExpiryConfig, "WarningDays", "warningDays", []string{"0", "7", "30"})
+}
+
+func TestInvalidCertExpiryEvents(t *testing.T) {
+	testInvalidValues(t, newTestCertExpiryConfig, "Events", "events", []string{"0", "-1", "foobar"})
+}
+
+func TestInvalidStagedRolloutEnabled(t *testing.T) {
+	testInvalidValues(t, newTestStagedRolloutConfig, "Enabled", "enabled", []string{"0", "-1", "foobar"})
+}
+
+func TestValidStagedRolloutEnabled(t *testing.T) {
+	testValidValues(t, newTestStagedRolloutConfig, "Enabled", "enabled", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidStagedRolloutWorkers(t *testing.T) {
+	testInvalidValues(t, newTestStagedRolloutConfig, "Workers", "workers", []string{"0", "-1", "02", "foobar"})
+}
+
+func TestValidStagedRolloutWorkers(t *testing.T) {
+	testValidValues(t, newTestStagedRolloutConfig, "Workers", "workers", []string{"1", "2", "16"})
+}
+
+func TestInvalidStagedRolloutPeriod(t *testing.T) {
+	testInvalidValues(t, newTestStagedRolloutConfig, "Period", "period", []string{"0", "500ms", "foobar"})
+}
+
+func TestValidStagedRolloutPeriod(t *testing.T) {
+	testValidValues(t, newTestStagedRolloutConfig, "Period", "period", []string{"1s", "30s", "5m"})
 }
 
-func TestInvalidEnforceWhitelists(t *testing.T) {
-	testInvalidValues(t, newTestRouterConfig, "EnforceWhitelists", "enforceWhitelists", []string{"0", "-1", "foobar"})
+func TestInvalidStagedRolloutMaxErrorPercent(t *testing.T) {
+	testInvalidValues(t, newTestStagedRolloutConfig, "MaxErrorPercent", "maxErrorPercent", []string{"-1", "101", "foobar"})
 }
 
-func TestValidEnforceWhitelists(t *testing.T) {
-	testValidValues(t, newTestRouterConfig, "EnforceWhitelists", "enforceWhitelists", []string{"true", "false", "TRUE", "FALSE"})
+func TestValidStagedRolloutMaxErrorPercent(t *testing.T) {
+	testValidValues(t, newTestStagedRolloutConfig, "MaxErrorPercent", "maxErrorPercent", []string{"0", "1", "100"})
 }
 
-func TestInvalidDefaultWhitelist(t *testing.T) {
-	testInvalidValues(t, newTestRouterConfig, "DefaultWhitelist", "defaultWhitelist", []string{"0", "-1", "foobar"})
+func TestInvalidProbesEnabled(t *testing.T) {
+	testInvalidValues(t, newTestProbeConfig, "Enabled", "enabled", []string{"0", "-1", "foobar"})
 }
 
-func TestValidDefaultWhitelist(t *testing.T) {
-	testValidValues(t, newTestRouterConfig, "DefaultWhitelist", "defaultWhitelist", []string{"1.2.3.4", "0.0.0.0/0", "1.2.3.4,0.0.0.0/0", "1.2.3.4, 0.0.0.0/0"})
+func TestValidProbesEnabled(t *testing.T) {
+	testValidValues(t, newTestProbeConfig, "Enabled", "enabled", []string{"true", "false", "TRUE", "FALSE"})
 }
 
-func TestInvalidWhitelistMode(t *testing.T) {
-	testInvalidValues(t, newTestRouterConfig, "WhitelistMode", "whitelistMode", []string{"0", "-1", "foobar"})
+func TestInvalidProbesInterval(t *testing.T) {
+	testInvalidValues(t, newTestProbeConfig, "Interval", "interval", []string{"1s", "2h", "foobar"})
 }
 
-func TestValidWhitelistMode(t *testing.T) {
-	testValidValues(t, newTestRouterConfig, "WhitelistMode", "whitelistMode", []string{"extend", "override"})
+func TestValidProbesInterval(t *testing.T) {
+	testValidValues(t, newTestProbeConfig, "Interval", "interval", []string{"5s", "30s", "1h"})
 }
 
-func TestValidHTTP2Enabled(t *testing.T) {
-	testValidValues(t, newTestRouterConfig, "HttpEnabled", "http2Enabled", []string{"true", "false", "TRUE", "FALSE"})
+func TestInvalidProbesTimeout(t *testing.T) {
+	testInvalidValues(t, newTestProbeConfig, "Timeout", "timeout", []string{"10ms", "2m", "foobar"})
 }
 
-func TestInvalidHTTP2Enabled(t *testing.T) {
-	testInvalidValues(t, newTestRouterConfig, "HTTP2Enabled", "http2Enabled", []string{"0", "-1", "foobar"})
+func TestValidProbesTimeout(t *testing.T) {
+	testValidValues(t, newTestProbeConfig, "Timeout", "timeout", []string{"100ms", "5s", "1m"})
 }
 
-func TestValidClientCerts(t *testing.T) {
-	testValidValues(t, newTestRouterConfig, "ClientCertificates", "clientCertificates", []string{"asdf", "two==", "one=", "z+/xcv", "poiu,lkjh==", "poiu,lkjh==,a+/s=,b"})
+func TestInvalidACMEEnabled(t *testing.T) {
+	testInvalidValues(t, newTestACMEConfig, "Enabled", "enabled", []string{"0", "-1", "foobar"})
 }
 
-func TestInvalidClientCerts(t *testing.T) {
-	testInvalidValues(t, newTestRouterConfig, "ClientCertificates", "clientCertificates", []string{"asdf===", ",asdf==", "asdf=,", "asdf,,asdf", "", "=", "wi#a=="})
+func TestValidACMEEnabled(t *testing.T) {
+	testValidValues(t, newTestACMEConfig, "Enabled", "enabled", []string{"true", "false", "TRUE", "FALSE"})
 }
 
-func TestInvalidGzipEnabled(t *testing.T) {
-	testInvalidValues(t, newTestGzipConfig, "Enabled", "enabled", []string{"0", "-1", "foobar"})
+func TestInvalidACMEEmail(t *testing.T) {
+	testInvalidValues(t, newTestACMEConfig, "Email", "email", []string{"foobar", "foo@", "@example.com", "foo bar@example.com", "foo@example.com,bar@example.com"})
 }
 
-func TestValidGzipEnabled(t *testing.T) {
-	testValidValues(t, newTestGzipConfig, "Enabled", "enabled", []string{"true", "false", "TRUE", "FALSE"})
+func TestValidACMEEmail(t *testing.T) {
+	testValidValues(t, newTestACMEConfig, "Email", "email", []string{"foo@example.com", "foo+bar@example.com"})
 }
 
-func TestInvalidGzipCompLevel(t *testing.T) {
-	testInvalidValues(t, newTestGzipConfig, "CompLevel", "compLevel", []string{"0", "-1", "foobar"})
+func TestInvalidACMEDirectoryURL(t *testing.T) {
+	testInvalidValues(t, newTestACMEConfig, "DirectoryURL", "directoryURL", []string{"foobar", "http://example.com/directory", "https://"})
 }
 
-func TestValidGzipCompLevel(t *testing.T) {
-	testValidValues(t, newTestGzipConfig, "CompLevel", "compLevel", []string{"1", "2", "3", "4"})
+func TestValidACMEDirectoryURL(t *testing.T) {
+	testValidValues(t, newTestACMEConfig, "DirectoryURL", "directoryURL", []string{"https://acme-staging-v02.api.letsencrypt.org/directory", "https://example.com:14000/dir"})
 }
 
-func TestInvalidGzipHTTPVersion(t *testing.T) {
-	testInvalidValues(t, newTestGzipConfig, "HTTPVersion", "httpVersion", []string{"0", "-1", "foobar"})
+func TestInvalidACMERenewBeforeDays(t *testing.T) {
+	testInvalidValues(t, newTestACMEConfig, "RenewBeforeDays", "renewBeforeDays", []string{"0", "-1", "foobar"})
 }
 
-func TestValidGzipHTTPVersion(t *testing.T) {
-	testValidValues(t, newTestGzipConfig, "HTTPVersion", "httpVersion", []string{"1.0", "1.1"})
+func TestValidACMERenewBeforeDays(t *testing.T) {
+	testValidValues(t, newTestACMEConfig, "RenewBeforeDays", "renewBeforeDays", []string{"1", "30", "60"})
 }
 
-func TestInvalidGzipMinLength(t *testing.T) {
-	testInvalidValues(t, newTestGzipConfig, "MinLength", "minLength", []string{"-1", "foobar"})
+func TestInvalidAppACME(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "ACME", "acme", []string{"0", "-1", "foobar"})
 }
 
-func TestValidGzipMinLength(t *testing.T) {
-	testValidValues(t, newTestGzipConfig, "MinLength", "minLength", []string{"0", "1", "2", "20"})
+func TestValidAppACME(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "ACME", "acme", []string{"true", "false", "TRUE", "FALSE"})
 }
 
-func TestInvalidGzipProxied(t *testing.T) {
-	testInvalidValues(t, newTestGzipConfig, "Proxied", "proxied", []string{"0", "-1", "foobar"})
+func TestInvalidRateLimitResponseStatus(t *testing.T) {
+	testInvalidValues(t, newTestRateLimitResponseConfig, "Status", "status", []string{"0", "200", "404", "foobar"})
 }
 
-func TestValidGzipProxied(t *testing.T) {
-	testValidValues(t, newTestGzipConfig, "Proxied", "proxied", []string{"off", "expired", "no-cache", "no-store private no_etag"})
+func TestValidRateLimitResponseStatus(t *testing.T) {
+	testValidValues(t, newTestRateLimitResponseConfig, "Status", "status", []string{"429", "503"})
 }
 
-func TestInvalidGzipTypes(t *testing.T) {
-	testInvalidValues(t, newTestGzipConfig, "Types", "types", []string{"0", "-1", "foobar"})
+func TestInvalidRateLimitResponseRetryAfter(t *testing.T) {
+	testInvalidValues(t, newTestRateLimitResponseConfig, "RetryAfter", "retryAfter", []string{"-1", "1s", "foobar"})
 }
 
-func TestValidGzipTypes(t *testing.T) {
-	testValidValues(t, newTestGzipConfig, "Types", "types", []string{"application/json", "application/json application/text"})
+func TestValidRateLimitResponseRetryAfter(t *testing.T) {
+	testValidValues(t, newTestRateLimitResponseConfig, "RetryAfter", "retryAfter", []string{"0", "1", "60"})
 }
 
-func TestInvalidGzipVary(t *testing.T) {
-	testInvalidValues(t, newTestGzipConfig, "Vary", "vary", []string{"0", "-1", "foobar"})
+func TestInvalidRateLimitResponseBody(t *testing.T) {
+	testInvalidValues(t, newTestRateLimitResponseConfig, "Body", "body", []string{`{"error": "$foo"}`})
 }
 
-func TestValidGzipVary(t *testing.T) {
-	testValidValues(t, newTestGzipConfig, "Vary", "vary", []string{"on", "off"})
+func TestValidRateLimitResponseBody(t *testing.T) {
+	testValidValues(t, newTestRateLimitResponseConfig, "Body", "body", []string{`{"error": "rate_limited"}`, `"slow down"`})
 }
 
-func TestInvalidAppDomains(t *testing.T) {
-	testInvalidValues(t, newTestAppConfig, "Domains", "domains", []string{"-1", "foo_bar", "foobar.c", "foo bar"})
+func TestInvalidBodySizeResponseBody(t *testing.T) {
+	testInvalidValues(t, newTestBodySizeResponseConfig, "Body", "body", []string{`{"error": "$foo"}`})
 }
 
-func TestValidAppDomains(t *testing.T) {
-	testValidValues(t, newTestAppConfig, "Domains", "domains", []string{"foobar", "foo-bar", "foobar.com", "foobar,foobar.com", "foobar, foobar.com", "*.foobar.com", "xn--eckwd4c7c.xn--zckzah", "xn--80ahd1agd.ru", "xn--tst-qla.xn--knigsgsschen-lcb0w.de"})
+func TestValidBodySizeResponseBody(t *testing.T) {
+	testValidValues(t, newTestBodySizeResponseConfig, "Body", "body", []string{`{"error": "body_too_large"}`, `"too large"`})
 }
 
-func TestInvalidAppWhitelist(t *testing.T) {
-	testInvalidValues(t, newTestAppConfig, "Whitelist", "whitelist", []string{"0", "-1", "foobar"})
+func TestInvalidBodySizeResponseCORS(t *testing.T) {
+	testInvalidValues(t, newTestBodySizeResponseConfig, "CORS", "cors", []string{"0", "-1", "foobar"})
 }
 
-func TestValidAppWhitelist(t *testing.T) {
-	testValidValues(t, newTestAppConfig, "Whitelist", "whitelist", []string{"1.2.3.4", "0.0.0.0/0", "1.2.3.4,0.0.0.0/0", "1.2.3.4, 0.0.0.0/0"})
+func TestValidBodySizeResponseCORS(t *testing.T) {
+	testValidValues(t, newTestBodySizeResponseConfig, "CORS", "cors", []string{"true", "false", "TRUE", "FALSE"})
 }
 
-func TestInvalidAppConnectTimeout(t *testing.T) {
-	testInvalidValues(t, newTestAppConfig, "ConnectTimeout", "connectTimeout", []string{"0", "-1", "foobar"})
+func TestInvalidDebugBodyPath(t *testing.T) {
+	testInvalidValues(t, newTestDebugBodyConfig, "Path", "path", []string{"", "foo", "/foo bar", "/foo;bar"})
 }
 
-func TestValidAppConnectTimeout(t *testing.T) {
-	testValidValues(t, newTestAppConfig, "ConnectTimeout", "connectTimeout", []string{"1", "2", "10", "1ms", "2s", "10m"})
+func TestValidDebugBodyPath(t *testing.T) {
+	testValidValues(t, newTestDebugBodyConfig, "Path", "path", []string{"/", "/foo", "/foo/bar"})
 }
 
-func TestInvalidAppTCPTimeout(t *testing.T) {
-	testInvalidValues(t, newTestAppConfig, "TCPTimeout", "tcpTimeout", []string{"0", "-1", "foobar"})
+func TestInvalidDebugBodySize(t *testing.T) {
+	testInvalidValues(t, newTestDebugBodyConfig, "Size", "size", []string{"0", "-1", "foobar", "10000"})
 }
 
-func TestValidAppTCPTimeout(t *testing.T) {
-	testValidValues(t, newTestAppConfig, "TCPTimeout", "tcpTimeout", []string{"1", "2", "10", "1ms", "2s", "10m"})
+func TestValidDebugBodySize(t *testing.T) {
+	testValidValues(t, newTestDebugBodyConfig, "Size", "size", []string{"1", "512", "9999"})
 }
 
-func TestInvalidCertMappings(t *testing.T) {
-	testInvalidValues(t, newTestAppConfig, "CertMappings", "certificates", []string{"0", "-1", "foobar"})
+func TestInvalidDebugBodyUntil(t *testing.T) {
+	testInvalidValues(t, newTestDebugBodyConfig, "Until", "until", []string{"foobar", "2016-11-01", "2016-11-01 12:00:00", "2016-11-01T12:00:00"})
 }
 
-func TestValidCertMappings(t *testing.T) {
-	testValidValues(t, newTestAppConfig, "CertMappings", "certificates", []string{"foobar.com:foobar,*.foobar.deis.ninja:foobar-deis-ninja"})
+func TestValidDebugBodyUntil(t *testing.T) {
+	testValidValues(t, newTestDebugBodyConfig, "Until", "until", []string{"2016-11-01T12:00:00Z", "2016-11-01T12:00:00.5Z", "2016-11-01T12:00:00-07:00"})
+}
+
+func TestInvalidTLSHeadersProtocol(t *testing.T) {
+	testInvalidValues(t, newTestTLSHeadersConfig, "Protocol", "protocol", []string{"0", "-1", "foobar"})
+}
+
+func TestValidTLSHeadersProtocol(t *testing.T) {
+	testValidValues(t, newTestTLSHeadersConfig, "Protocol", "protocol", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidTLSHeadersCipher(t *testing.T) {
+	testInvalidValues(t, newTestTLSHeadersConfig, "Cipher", "cipher", []string{"0", "-1", "foobar"})
+}
+
+func TestValidTLSHeadersCipher(t *testing.T) {
+	testValidValues(t, newTestTLSHeadersConfig, "Cipher", "cipher", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidTLSHeadersSNI(t *testing.T) {
+	testInvalidValues(t, newTestTLSHeadersConfig, "SNI", "sni", []string{"0", "-1", "foobar"})
+}
+
+func TestValidTLSHeadersSNI(t *testing.T) {
+	testValidValues(t, newTestTLSHeadersConfig, "SNI", "sni", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidTLSHeadersJA3(t *testing.T) {
+	testInvalidValues(t, newTestTLSHeadersConfig, "JA3", "ja3", []string{"0", "-1", "foobar"})
+}
+
+func TestValidTLSHeadersJA3(t *testing.T) {
+	testValidValues(t, newTestTLSHeadersConfig, "JA3", "ja3", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidGeoIPDatabase(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "GeoIPDatabase", "geoipDatabase", []string{"GeoLite2-City.mmdb", "/opt/router/geoip/GeoLite2-City.dat", "/opt/geo ip/City.mmdb", "/opt/geoip/City.mmdb;"})
+}
+
+func TestValidGeoIPDatabase(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "GeoIPDatabase", "geoipDatabase", []string{"/opt/router/geoip/GeoLite2-City.mmdb", "/data/GeoIP2-Country.mmdb"})
+}
+
+func TestInvalidGeoIPAllowCountries(t *testing.T) {
+	testInvalidValues(t, newTestGeoIPConfig, "AllowCountries", "allowCountries", []string{"us", "USA", "US,", "US, CA", "1A"})
+}
+
+func TestValidGeoIPAllowCountries(t *testing.T) {
+	testValidValues(t, newTestGeoIPConfig, "AllowCountries", "allowCountries", []string{"US", "US,CA,GB"})
+}
+
+func TestInvalidGeoIPBlockCountries(t *testing.T) {
+	testInvalidValues(t, newTestGeoIPConfig, "BlockCountries", "blockCountries", []string{"cn", "CHN", ",CN", "CN;"})
+}
+
+func TestValidGeoIPBlockCountries(t *testing.T) {
+	testValidValues(t, newTestGeoIPConfig, "BlockCountries", "blockCountries", []string{"CN", "CN,RU"})
+}
+
+func TestInvalidGeoIPHeaders(t *testing.T) {
+	testInvalidValues(t, newTestGeoIPConfig, "Headers", "headers", []string{"0", "-1", "foobar"})
+}
+
+func TestValidGeoIPHeaders(t *testing.T) {
+	testValidValues(t, newTestGeoIPConfig, "Headers", "headers", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidEarlyDataEnabled(t *testing.T) {
+	testInvalidValues(t, newTestEarlyDataConfig, "Enabled", "enabled", []string{"0", "-1", "foobar"})
+}
+
+func TestValidEarlyDataEnabled(t *testing.T) {
+	testValidValues(t, newTestEarlyDataConfig, "Enabled", "enabled", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidEarlyDataMethods(t *testing.T) {
+	testInvalidValues(t, newTestEarlyDataConfig, "Methods", "methods", []string{"get", "GET,", "GET, HEAD", "GET;POST"})
+}
+
+func TestValidEarlyDataMethods(t *testing.T) {
+	testValidValues(t, newTestEarlyDataConfig, "Methods", "methods", []string{"GET", "GET,HEAD,OPTIONS", "PROPFIND"})
+}
+
+func TestInvalidLocationPath(t *testing.T) {
+	testInvalidValues(t, newTestLocationConfig, "Path", "path", []string{"/", "foo", "/foo bar", "/foo;bar", "/foo{"})
+}
+
+func TestValidLocationPath(t *testing.T) {
+	testValidValues(t, newTestLocationConfig, "Path", "path", []string{"/foo", "/foo/", "/foo/bar", "/foo-bar_baz.qux"})
+}
+
+func TestInvalidLocationBodySize(t *testing.T) {
+	testInvalidValues(t, newTestLocationConfig, "BodySize", "bodySize", []string{"-1", "foobar", "1t"})
+}
+
+func TestValidLocationBodySize(t *testing.T) {
+	testValidValues(t, newTestLocationConfig, "BodySize", "bodySize", []string{"0", "1", "20", "1k", "10m", "1g", "1G"})
+}
+
+func TestInvalidLocationService(t *testing.T) {
+	testInvalidValues(t, newTestLocationConfig, "BackendService", "service", []string{"Foo", "-foo", "foo-", "foo_bar"})
+}
+
+func TestValidLocationService(t *testing.T) {
+	testValidValues(t, newTestLocationConfig, "BackendService", "service", []string{"foo", "foo-bar", "foo1"})
+}
+
+func TestInvalidLocationServicePort(t *testing.T) {
+	testInvalidValues(t, newTestLocationConfig, "BackendPort", "servicePort", []string{"0", "-1", "Http", "http-"})
+}
+
+func TestValidLocationServicePort(t *testing.T) {
+	testValidValues(t, newTestLocationConfig, "BackendPort", "servicePort", []string{"1", "80", "8080", "http", "http-alt"})
+}
+
+func TestInvalidLocationStripPrefix(t *testing.T) {
+	testInvalidValues(t, newTestLocationConfig, "StripPrefix", "stripPrefix", []string{"", "api", "/api{"})
+}
+
+func TestValidLocationStripPrefix(t *testing.T) {
+	testValidValues(t, newTestLocationConfig, "StripPrefix", "stripPrefix", []string{"/", "/api", "/api/v1/"})
+}
+
+func TestInvalidLocationAddPrefix(t *testing.T) {
+	testInvalidValues(t, newTestLocationConfig, "AddPrefix", "addPrefix", []string{"", "v2", "/v2;"})
+}
+
+func TestValidLocationAddPrefix(t *testing.T) {
+	testValidValues(t, newTestLocationConfig, "AddPrefix", "addPrefix", []string{"/", "/v2"})
 }
 
 func TestInvalidBuilderConnectTimeout(t *testing.T) {
@@ -268,7 +1366,7 @@ func TestInvalidSSLProtocols(t *testing.T) {
 }
 
 func TestValidSSLProtocols(t *testing.T) {
-	testValidValues(t, newTestSSLConfig, "Protocols", "protocols", []string{"SSLv3", "TLSv1", "TLSv1 TLSv1.1"})
+	testValidValues(t, newTestSSLConfig, "Protocols", "protocols", []string{"SSLv3", "TLSv1", "TLSv1 TLSv1.1", "TLSv1.2 TLSv1.3"})
 }
 
 func TestInvalidSSLCiphers(t *testing.T) {
@@ -287,6 +1385,46 @@ func TestValidSSLSessionCache(t *testing.T) {
 	testValidValues(t, newTestSSLConfig, "SessionCache", "sessionCache", []string{"off", "none", "builtin", "builtin:1000", "builtin:1000 shared:SSL:16k"})
 }
 
+func TestInvalidSSLSessionCacheSize(t *testing.T) {
+	testInvalidValues(t, newTestSSLConfig, "SessionCacheSize", "sessionCacheSize", []string{"0", "16k", "-1", "foobar", "1g"})
+}
+
+func TestValidSSLSessionCacheSize(t *testing.T) {
+	testValidValues(t, newTestSSLConfig, "SessionCacheSize", "sessionCacheSize", []string{"32k", "1m", "50M", "65536"})
+}
+
+func TestInvalidSSLECH(t *testing.T) {
+	testInvalidValues(t, newTestSSLConfig, "ECH", "ech", []string{"0", "-1", "foobar"})
+}
+
+func TestValidSSLECH(t *testing.T) {
+	testValidValues(t, newTestSSLConfig, "ECH", "ech", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidSSLOCSPStapling(t *testing.T) {
+	testInvalidValues(t, newTestSSLConfig, "OCSPStapling", "ocspStapling", []string{"0", "-1", "foobar"})
+}
+
+func TestValidSSLOCSPStapling(t *testing.T) {
+	testValidValues(t, newTestSSLConfig, "OCSPStapling", "ocspStapling", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidSSLOCSPStaplingVerify(t *testing.T) {
+	testInvalidValues(t, newTestSSLConfig, "OCSPStaplingVerify", "ocspStaplingVerify", []string{"0", "-1", "foobar"})
+}
+
+func TestValidSSLOCSPStaplingVerify(t *testing.T) {
+	testValidValues(t, newTestSSLConfig, "OCSPStaplingVerify", "ocspStaplingVerify", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidSSLOCSPResolver(t *testing.T) {
+	testInvalidValues(t, newTestSSLConfig, "OCSPResolver", "ocspResolver", []string{"8.8.8.8;", "8.8.8.8, 8.8.4.4", "8.8.8.8,", "dns:53:53", "-dns"})
+}
+
+func TestValidSSLOCSPResolver(t *testing.T) {
+	testValidValues(t, newTestSSLConfig, "OCSPResolver", "ocspResolver", []string{"10.0.0.10", "8.8.8.8,8.8.4.4", "kube-dns.kube-system.svc.cluster.local:53", "[2001:4860:4860::8888]"})
+}
+
 func TestInvalidSSLSessionTimeout(t *testing.T) {
 	testInvalidValues(t, newTestSSLConfig, "SessionTimeout", "sessionTimeout", []string{"0", "-1", "foobar"})
 }
@@ -311,6 +1449,98 @@ func TestValidSSLBufferSize(t *testing.T) {
 	testValidValues(t, newTestSSLConfig, "BufferSize", "bufferSize", []string{"1", "2", "20", "1k", "2k", "10m", "10M"})
 }
 
+func TestInvalidSSLSNIConnectionLimit(t *testing.T) {
+	testInvalidValues(t, newTestSSLConfig, "SNIConnectionLimit", "sniConnectionLimit", []string{"-1", "01", "foobar"})
+}
+
+func TestValidSSLSNIConnectionLimit(t *testing.T) {
+	testValidValues(t, newTestSSLConfig, "SNIConnectionLimit", "sniConnectionLimit", []string{"0", "1", "1000"})
+}
+
+func TestInvalidPropagateRequestIDs(t *testing.T) {
+	testInvalidValues(t, newTestRouterConfig, "PropagateRequestIDs", "propagateRequestIDs", []string{"0", "-1", "foobar"})
+}
+
+func TestValidPropagateRequestIDs(t *testing.T) {
+	testValidValues(t, newTestRouterConfig, "PropagateRequestIDs", "propagateRequestIDs", []string{"true", "false", "TRUE", "FALSE"})
+}
+
+func TestInvalidTracingCollector(t *testing.T) {
+	testInvalidValues(t, newTestTracingConfig, "Collector", "collector", []string{"zipkin", "zipkin:", ":9411", "http://zipkin:9411", "zipkin:9411/api/v2/spans"})
+}
+
+func TestValidTracingCollector(t *testing.T) {
+	testValidValues(t, newTestTracingConfig, "Collector", "collector", []string{"zipkin:9411", "otel-collector.tracing.svc.cluster.local:9411", "10.0.0.1:9411"})
+}
+
+func TestInvalidTracingSampleRate(t *testing.T) {
+	testInvalidValues(t, newTestTracingConfig, "SampleRate", "sampleRate", []string{"-1", "1.5", "2", "10%", ".5", "foobar"})
+}
+
+func TestValidTracingSampleRate(t *testing.T) {
+	testValidValues(t, newTestTracingConfig, "SampleRate", "sampleRate", []string{"0", "0.01", "0.5", "1", "1.0"})
+}
+
+func TestInvalidTracingServiceName(t *testing.T) {
+	testInvalidValues(t, newTestTracingConfig, "ServiceName", "serviceName", []string{"deis router", "router;", "\"router\""})
+}
+
+func TestValidTracingServiceName(t *testing.T) {
+	testValidValues(t, newTestTracingConfig, "ServiceName", "serviceName", []string{"deis-router", "router_1", "edge.router"})
+}
+
+func TestInvalidLogSyslog(t *testing.T) {
+	testInvalidValues(t, newTestLogConfig, "Syslog", "syslog", []string{"foobar", "tcp://syslog:514", "udp://", "udp://syslog:", "udp://sys_log", "unix:", "unix:/dev/log;", "udp://syslog,tag=evil"})
+}
+
+func TestValidLogSyslog(t *testing.T) {
+	testValidValues(t, newTestLogConfig, "Syslog", "syslog", []string{"udp://syslog", "udp://syslog.logging.svc.cluster.local:514", "udp://10.0.0.1:5140", "udp://[::1]:514", "unix:/dev/log"})
+}
+
+func TestInvalidLogSyslogFacility(t *testing.T) {
+	testInvalidValues(t, newTestLogConfig, "SyslogFacility", "syslogFacility", []string{"0", "local8", "LOCAL7"})
+}
+
+func TestValidLogSyslogFacility(t *testing.T) {
+	testValidValues(t, newTestLogConfig, "SyslogFacility", "syslogFacility", []string{"local0", "local7", "daemon", "user"})
+}
+
+func TestInvalidAppSyslog(t *testing.T) {
+	testInvalidValues(t, newTestAppConfig, "Syslog", "nginx.log.syslog", []string{"foobar", "tcp://syslog:514", "unix:/dev/log foo"})
+}
+
+func TestValidAppSyslog(t *testing.T) {
+	testValidValues(t, newTestAppConfig, "Syslog", "nginx.log.syslog", []string{"udp://syslog:514", "unix:/var/run/syslog.sock"})
+}
+
+func TestInvalidLogFormat(t *testing.T) {
+	testInvalidValues(t, newTestLogConfig, "Format", "format", []string{"0", "JSON", "logfmt"})
+}
+
+func TestValidLogFormat(t *testing.T) {
+	testValidValues(t, newTestLogConfig, "Format", "format", []string{"text", "json"})
+}
+
+func TestInvalidLogFields(t *testing.T) {
+	testInvalidValues(t, newTestLogConfig, "Fields", "fields", []string{"", "time,", "time,bogus", "$request_time", "time;request_time"})
+}
+
+func TestValidLogFields(t *testing.T) {
+	testValidValues(t, newTestLogConfig, "Fields", "fields", []string{
+		"time",
+		"time, request_id,app",
+		"time,request_id,app,remote_addr,remote_user,status,method,uri,protocol,request,bytes_sent,referer,user_agent,server_name,host,upstream_addr,upstream_status,upstream_response_time,request_time",
+	})
+}
+
+func TestInvalidSSLHTTP2(t *testing.T) {
+	testInvalidValues(t, newTestSSLConfig, "HTTP2", "http2", []string{"0", "on", "TRUE", "foobar"})
+}
+
+func TestValidSSLHTTP2(t *testing.T) {
+	testValidValues(t, newTestSSLConfig, "HTTP2", "http2", []string{"true", "false"})
+}
+
 func TestInvalidHSTSEnabled(t *testing.T) {
 	testInvalidValues(t, newTestHSTSConfig, "Enabled", "enabled", []string{"0", "-1", "foobar"})
 }
@@ -370,6 +1600,14 @@ func newTestRouterConfig() interface{} {
 	return newRouterConfig()
 }
 
+func newTestLogConfig() interface{} {
+	return newLogConfig()
+}
+
+func newTestOpenFileCacheConfig() interface{} {
+	return newOpenFileCacheConfig()
+}
+
 func newTestGzipConfig() interface{} {
 	return newGzipConfig()
 }
@@ -378,6 +1616,114 @@ func newTestAppConfig() interface{} {
 	return newAppConfig(newRouterConfig())
 }
 
+func newTestUpstreamTLSConfig() interface{} {
+	return newUpstreamTLSConfig()
+}
+
+func newTestCertExpiryConfig() interface{} {
+	return newCertExpiryConfig()
+}
+
+func newTestStagedRolloutConfig() interface{} {
+	return newStagedRolloutConfig()
+}
+
+func newTestProbeConfig() interface{} {
+	return newProbeConfig()
+}
+
+func newTestRedirectConfig() interface{} {
+	return newRedirectConfig()
+}
+
+func newTestExternalAuthConfig() interface{} {
+	return newExternalAuthConfig()
+}
+
+func newTestCORSConfig() interface{} {
+	return newCORSConfig()
+}
+
+func newTestWAFConfig() interface{} {
+	return newWAFConfig()
+}
+
+func newTestConditionalConfig() interface{} {
+	return newConditionalConfig()
+}
+
+func newTestProxyCacheConfig() interface{} {
+	return newProxyCacheConfig()
+}
+
+func newTestRangesConfig() interface{} {
+	return &RangesConfig{}
+}
+
+func newTestCacheControlConfig() interface{} {
+	return &CacheControlConfig{}
+}
+
+func newTestUpstreamKeepaliveConfig() interface{} {
+	return newUpstreamKeepaliveConfig()
+}
+
+func newTestTransformConfig() interface{} {
+	return newTransformConfig()
+}
+
+func newTestTracingConfig() interface{} {
+	return newTracingConfig()
+}
+
+func newTestHealthCheckConfig() interface{} {
+	return newHealthCheckConfig()
+}
+
+func newTestRetryConfig() interface{} {
+	return newRetryConfig()
+}
+
+func newTestTLSHeadersConfig() interface{} {
+	return newTLSHeadersConfig()
+}
+
+func newTestGeoIPConfig() interface{} {
+	return newGeoIPConfig()
+}
+
+func newTestEarlyDataConfig() interface{} {
+	return newEarlyDataConfig()
+}
+
+func newTestEmergencyConfig() interface{} {
+	return newEmergencyConfig()
+}
+
+func newTestACMEConfig() interface{} {
+	return newACMEConfig()
+}
+
+func newTestRateLimitResponseConfig() interface{} {
+	return newRateLimitResponseConfig()
+}
+
+func newTestBodySizeResponseConfig() interface{} {
+	return &BodySizeResponseConfig{}
+}
+
+func newTestStreamPortsConfig() interface{} {
+	return newStreamPortsConfig(newRouterConfig())
+}
+
+func newTestDebugBodyConfig() interface{} {
+	return newDebugBodyConfig()
+}
+
+func newTestLocationConfig() interface{} {
+	return newLocationConfig(newAppConfig(newRouterConfig()))
+}
+
 func newTestBuilderConfig() interface{} {
 	return newBuilderConfig()
 }
@@ -386,6 +1732,10 @@ func newTestSSLConfig() interface{} {
 	return newSSLConfig()
 }
 
+func newTestProxyProtocolConfig() interface{} {
+	return newProxyProtocolConfig()
+}
+
 func newTestHSTSConfig() interface{} {
 	return newHSTSConfig()
 }
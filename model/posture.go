@@ -0,0 +1,97 @@
+package model
+
+// AppPosture summarizes the security-relevant settings with which an application is routed, so that
+// they can be reviewed across all applications at a glance.  It reflects the configuration as nginx
+// renders it, including settings inherited from the router.
+type AppPosture struct {
+	Name      string   `json:"name"`
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace"`
+	Resource  string   `json:"resource"`
+	Domains   []string `json:"domains"`
+	// SSLEnforced is "true" if all plain HTTP requests are redirected to HTTPS, "external" if only
+	// those from external clients are, or "false".
+	SSLEnforced string `json:"sslEnforced"`
+	// UncertifiedDomains are those of the application's domains that cannot be served over HTTPS.
+	UncertifiedDomains []string `json:"uncertifiedDomains"`
+	HSTS               bool     `json:"hsts"`
+	Compression        bool     `json:"compression"`
+	RateLimited        bool     `json:"rateLimited"`
+	ConnectionLimited  bool     `json:"connectionLimited"`
+	Whitelisted        bool     `json:"whitelisted"`
+	ClientCertificates bool     `json:"clientCertificates"`
+	// Authentication lists the means, "basic" or "external", by which clients must authenticate.
+	Authentication []string `json:"authentication"`
+	// SecurityHeaders are the security-related response headers added to the application's responses.
+	SecurityHeaders []string `json:"securityHeaders"`
+	// WAF is the mode, "detect" or "block", in which requests are inspected by ModSecurity, or "off".
+	WAF string `json:"waf"`
+}
+
+// Posture summarizes the security-relevant settings of each of the router's applications.
+func (routerConfig *RouterConfig) Posture() []AppPosture {
+	postures := make([]AppPosture, 0, len(routerConfig.AppConfigs))
+	hsts := routerConfig.SSLConfig != nil && routerConfig.SSLConfig.HSTSConfig != nil && routerConfig.SSLConfig.HSTSConfig.Enabled
+	for _, appConfig := range routerConfig.AppConfigs {
+		posture := AppPosture{
+			Name:               appConfig.Name,
+			Kind:               appConfig.ResourceKind,
+			Namespace:          appConfig.Namespace,
+			Resource:           appConfig.ResourceName,
+			Domains:            appConfig.Domains,
+			SSLEnforced:        sslEnforcement(routerConfig, appConfig),
+			UncertifiedDomains: []string{},
+			HSTS:               hsts,
+			Compression:        routerConfig.GzipConfig != nil && routerConfig.GzipConfig.Enabled,
+			Whitelisted:        routerConfig.EnforceWhitelists || len(routerConfig.DefaultWhitelist) > 0 || appConfig.Whitelisted(),
+			ClientCertificates: len(appConfig.ClientVerifications) > 0 || len(routerConfig.ClientCertificates) > 0,
+			Authentication:     []string{},
+			SecurityHeaders:    []string{},
+			WAF:                "off",
+		}
+		for _, domain := range appConfig.Domains {
+			if appConfig.Certificates[domain] == nil {
+				posture.UncertifiedDomains = append(posture.UncertifiedDomains, domain)
+			}
+		}
+		if rateLimitConfig := appConfig.RateLimitConfig; rateLimitConfig != nil {
+			posture.RateLimited = rateLimitConfig.Rate != ""
+			posture.ConnectionLimited = rateLimitConfig.Connections > 0
+		}
+		if len(appConfig.BasicAuthUsers) > 0 {
+			posture.Authentication = append(posture.Authentication, "basic")
+		}
+		if appConfig.ExternalAuthConfig != nil && appConfig.ExternalAuthConfig.URL != "" {
+			posture.Authentication = append(posture.Authentication, "external")
+		}
+		if appConfig.WAFConfig != nil && appConfig.WAFConfig.Enabled {
+			posture.WAF = appConfig.WAFConfig.Mode
+		}
+		if hsts {
+			posture.SecurityHeaders = append(posture.SecurityHeaders, "Strict-Transport-Security")
+		}
+		postures = append(postures, posture)
+	}
+	return postures
+}
+
+// sslEnforcement returns how the redirection of plain HTTP requests to HTTPS is enforced for the
+// provided application.  Enforcement of every request, by either the router or the application,
+// takes precedence over enforcement of external clients' requests only.
+func sslEnforcement(routerConfig *RouterConfig, appConfig *AppConfig) string {
+	enforce := []string{}
+	if routerConfig.SSLConfig != nil {
+		enforce = append(enforce, routerConfig.SSLConfig.Enforce)
+	}
+	if appConfig.SSLConfig != nil {
+		enforce = append(enforce, appConfig.SSLConfig.Enforce)
+	}
+	for _, mode := range []string{"true", "external"} {
+		for _, e := range enforce {
+			if e == mode {
+				return mode
+			}
+		}
+	}
+	return "false"
+}
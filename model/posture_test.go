@@ -0,0 +1,62 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPosture(t *testing.T) {
+	routerConfig := newRouterConfig()
+	routerConfig.SSLConfig.HSTSConfig.Enabled = true
+
+	plainApp := newLintTestAppConfig(routerConfig)
+	securedApp := newLintTestAppConfig(routerConfig)
+	securedApp.Domains = []string{"bar.example.com", "baz.example.com"}
+	securedApp.Certificates["bar.example.com"] = &Certificate{}
+	securedApp.SSLConfig.Enforce = "external"
+	securedApp.Whitelist = []string{"1.2.3.4"}
+	securedApp.RateLimitConfig = &RateLimitConfig{Rate: "10r/s"}
+	securedApp.BasicAuthUsers = []string{"user:{PLAIN}password"}
+	securedApp.ExternalAuthConfig.URL = "https://auth.example.com/check"
+	securedApp.WAFConfig.Enabled = true
+	securedApp.WAFConfig.Mode = "block"
+	routerConfig.AppConfigs = []*AppConfig{plainApp, securedApp}
+
+	postures := routerConfig.Posture()
+	if len(postures) != 2 {
+		t.Fatalf("Expected 2 postures, but got %d", len(postures))
+	}
+	expected := AppPosture{
+		Name:               "foo/bar",
+		Kind:               "Service",
+		Namespace:          "foo",
+		Resource:           "bar",
+		Domains:            []string{"bar.example.com"},
+		SSLEnforced:        "false",
+		UncertifiedDomains: []string{"bar.example.com"},
+		HSTS:               true,
+		Compression:        routerConfig.GzipConfig.Enabled,
+		Authentication:     []string{},
+		SecurityHeaders:    []string{"Strict-Transport-Security"},
+		WAF:                "off",
+	}
+	if !reflect.DeepEqual(expected, postures[0]) {
+		t.Errorf("Expected posture %+v, but got %+v", expected, postures[0])
+	}
+	expected.Domains = securedApp.Domains
+	expected.SSLEnforced = "external"
+	expected.UncertifiedDomains = []string{"baz.example.com"}
+	expected.RateLimited = true
+	expected.Whitelisted = true
+	expected.Authentication = []string{"basic", "external"}
+	expected.WAF = "block"
+	if !reflect.DeepEqual(expected, postures[1]) {
+		t.Errorf("Expected posture %+v, but got %+v", expected, postures[1])
+	}
+
+	// Enforcement of every request by the router takes precedence over the application's.
+	routerConfig.SSLConfig.Enforce = "true"
+	if sslEnforced := routerConfig.Posture()[1].SSLEnforced; sslEnforced != "true" {
+		t.Errorf("Expected SSL enforcement \"true\", but got \"%s\"", sslEnforced)
+	}
+}
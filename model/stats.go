@@ -0,0 +1,102 @@
+package model
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// RoutingTableStats summarizes the size and composition of the router's model.
+type RoutingTableStats struct {
+	Apps            int
+	Domains         int
+	Locations       int
+	Endpoints       int
+	Certificates    int
+	WhitelistedApps int
+	UnavailableApps int
+	MaintenanceApps int
+	ACMEDomains     int
+	Streams         int
+}
+
+// Stats tallies the applications, domains, certificates, and so on in the router's model.
+// Certificates are counted as the router writes them: once for the platform certificate, if any,
+// once for each certificate shared by several domains, and once for each other domain that has a
+// certificate.
+func (routerConfig *RouterConfig) Stats() RoutingTableStats {
+	stats := RoutingTableStats{Apps: len(routerConfig.AppConfigs), Streams: len(routerConfig.StreamConfigs)}
+	counted := map[string]bool{}
+	if routerConfig.PlatformCertificate != nil {
+		stats.Certificates++
+		counted[routerConfig.PlatformCertificate.Name] = true
+	}
+	for _, appConfig := range routerConfig.AppConfigs {
+		stats.Domains += len(appConfig.Domains)
+		stats.Locations += len(appConfig.Locations)
+		stats.Endpoints += len(appConfig.Endpoints)
+		for _, location := range appConfig.Locations {
+			stats.Endpoints += len(location.Endpoints)
+			for _, backend := range location.WeightedBackends {
+				stats.Endpoints += len(backend.Endpoints)
+			}
+		}
+		for _, certificate := range appConfig.Certificates {
+			if certificate == nil || (certificate.Name != "" && counted[certificate.Name]) {
+				continue
+			}
+			stats.Certificates++
+			if certificate.Name != "" {
+				counted[certificate.Name] = true
+			}
+		}
+		if routerConfig.EnforceWhitelists || len(routerConfig.DefaultWhitelist) > 0 || appConfig.Whitelisted() {
+			stats.WhitelistedApps++
+		}
+		if !appConfig.Available {
+			stats.UnavailableApps++
+		}
+		if appConfig.Maintenance {
+			stats.MaintenanceApps++
+		}
+		stats.ACMEDomains += len(appConfig.ACMEDomains)
+	}
+	return stats
+}
+
+func (stats RoutingTableStats) String() string {
+	return fmt.Sprintf("%d apps (%d unavailable, %d in maintenance, %d whitelisted), %d domains, %d locations, %d endpoints, %d certificates, %d ACME-managed domains, %d TCP/UDP streams",
+		stats.Apps, stats.UnavailableApps, stats.MaintenanceApps, stats.WhitelistedApps, stats.Domains, stats.Locations, stats.Endpoints, stats.Certificates, stats.ACMEDomains, stats.Streams)
+}
+
+// UpstreamAddresses maps the address, in host:port form, of each endpoint and service to which the
+// router proxies to the name of the application it serves, so that connections to it can be
+// attributed to that application.  Endpoints of TCP and UDP services are attributed to those
+// services, by namespace and name.
+func (routerConfig *RouterConfig) UpstreamAddresses() map[string]string {
+	addresses := map[string]string{}
+	add := func(app string, serviceIP string, servicePort int, endpoints []string) {
+		if serviceIP != "" && servicePort != 0 {
+			addresses[net.JoinHostPort(serviceIP, strconv.Itoa(servicePort))] = app
+		}
+		for _, endpoint := range endpoints {
+			addresses[endpoint] = app
+		}
+	}
+	for _, appConfig := range routerConfig.AppConfigs {
+		add(appConfig.Name, appConfig.ServiceIP, appConfig.ServicePort, appConfig.Endpoints)
+		if canary := appConfig.Canary; canary != nil {
+			add(appConfig.Name, canary.ServiceIP, canary.ServicePort, canary.Endpoints)
+		}
+		for _, location := range appConfig.Locations {
+			add(appConfig.Name, location.ServiceIP, location.ServicePort, location.Endpoints)
+			for _, backend := range location.WeightedBackends {
+				add(appConfig.Name, backend.ServiceIP, backend.ServicePort, backend.Endpoints)
+			}
+		}
+	}
+	for _, streamConfig := range routerConfig.StreamConfigs {
+		add(streamConfig.Name, streamConfig.ServiceIP, streamConfig.ServicePort, streamConfig.Endpoints)
+	}
+	return addresses
+}
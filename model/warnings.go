@@ -0,0 +1,160 @@
+package model
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	modelerUtility "github.com/deis/router/utils/modeler"
+	"k8s.io/client-go/1.4/kubernetes"
+	"k8s.io/client-go/1.4/pkg/api/unversioned"
+	"k8s.io/client-go/1.4/pkg/api/v1"
+)
+
+const eventSource = "deis-router"
+
+// Warning describes a problem with the router-related configuration of a k8s resource that the
+// router worked around, and which the owner of that resource should be made aware of.
+type Warning struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Reason    string
+	Message   string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s \"%s/%s\": %s", w.Kind, w.Namespace, w.Name, w.Message)
+}
+
+// invalidResourceError represents a resource whose router-related configuration is so malformed
+// that it must be left out of the router's configuration entirely.
+type invalidResourceError struct {
+	warning Warning
+}
+
+func (e *invalidResourceError) Error() string {
+	return e.warning.String()
+}
+
+// warn records the provided warning, unless an identical warning has already been recorded.
+func (routerConfig *RouterConfig) warn(warning Warning) {
+	for _, existing := range routerConfig.Warnings {
+		if existing == warning {
+			return
+		}
+	}
+	log.Printf("WARN: %s\n", warning)
+	routerConfig.Warnings = append(routerConfig.Warnings, warning)
+}
+
+// mapAnnotations populates the provided model from the annotations of the specified resource.
+// Annotations whose values fail validation are skipped in favor of defaults, and a warning is
+// recorded for each.  An annotation whose value cannot be parsed at all renders the resource
+// invalid.
+func mapAnnotations(routerConfig *RouterConfig, kind string, meta v1.ObjectMeta, context string, out interface{}) error {
+	validationErrs, err := modeler.MapToModelWithValidationErrors(meta.Annotations, context, out)
+	for _, validationErr := range validationErrs {
+		routerConfig.warn(Warning{
+			Kind:      kind,
+			Namespace: meta.Namespace,
+			Name:      meta.Name,
+			Reason:    "InvalidAnnotation",
+			Message:   fmt.Sprintf("Annotation \"%s\" was ignored: %s.", validationErr.Field(), validationErr),
+		})
+	}
+	if parseErr, ok := err.(modelerUtility.ModelParseError); ok {
+		return &invalidResourceError{warning: Warning{
+			Kind:      kind,
+			Namespace: meta.Namespace,
+			Name:      meta.Name,
+			Reason:    "InvalidAnnotation",
+			Message:   fmt.Sprintf("Annotation \"%s\" is invalid, so the resource is not routable: %s.", parseErr.Field(), parseErr),
+		}}
+	}
+	return err
+}
+
+// warnInvalidCertificate records a warning about a cert-bearing secret from which no certificate
+// could be built.
+func warnInvalidCertificate(routerConfig *RouterConfig, certSecret *v1.Secret, domain string) {
+	routerConfig.warn(Warning{
+		Kind:      "Secret",
+		Namespace: certSecret.Namespace,
+		Name:      certSecret.Name,
+		Reason:    "InvalidCertificate",
+		Message:   fmt.Sprintf("The secret has no \"tls.crt\" or \"tls.key\" entry, so no certificate is used for %s.", domain),
+	})
+}
+
+// WarningRecorder posts warnings as k8s events on the resources they concern, so that problems
+// with a resource's configuration are visible through kubectl describe.
+type WarningRecorder struct {
+	kubeClient *kubernetes.Clientset
+	host       string
+	// reported holds the warnings that have already been posted.  A warning is posted only once for
+	// as long as it persists, but again if it goes away and then recurs.
+	reported map[Warning]bool
+}
+
+// NewWarningRecorder returns a pointer to a new WarningRecorder.
+func NewWarningRecorder(kubeClient *kubernetes.Clientset) *WarningRecorder {
+	host, _ := os.Hostname()
+	return &WarningRecorder{
+		kubeClient: kubeClient,
+		host:       host,
+		reported:   map[Warning]bool{},
+	}
+}
+
+// Record posts each of the provided warnings that has not already been posted.
+func (r *WarningRecorder) Record(warnings []Warning) {
+	current := make(map[Warning]bool, len(warnings))
+	for _, warning := range warnings {
+		current[warning] = true
+		if r.reported[warning] {
+			continue
+		}
+		if err := r.post(warning); err != nil {
+			log.Printf("WARN: Failed to post event for %s %s/%s: %v\n", warning.Kind, warning.Namespace, warning.Name, err)
+			continue
+		}
+		r.reported[warning] = true
+	}
+	for warning := range r.reported {
+		if !current[warning] {
+			delete(r.reported, warning)
+		}
+	}
+}
+
+func (r *WarningRecorder) post(warning Warning) error {
+	now := unversioned.NewTime(time.Now())
+	event := &v1.Event{
+		InvolvedObject: v1.ObjectReference{
+			Kind:       warning.Kind,
+			Namespace:  warning.Namespace,
+			Name:       warning.Name,
+			APIVersion: apiVersion(warning.Kind),
+		},
+		Reason:         warning.Reason,
+		Message:        warning.Message,
+		Source:         v1.EventSource{Component: eventSource, Host: r.host},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           v1.EventTypeWarning,
+	}
+	event.GenerateName = warning.Name + "."
+	event.Namespace = warning.Namespace
+	_, err := r.kubeClient.Events(warning.Namespace).Create(event)
+	return err
+}
+
+func apiVersion(kind string) string {
+	if kind == "Ingress" || kind == "Deployment" {
+		return "extensions/v1beta1"
+	}
+	return "v1"
+}
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"testing"
+
+	"k8s.io/client-go/1.4/pkg/api/v1"
+	"k8s.io/client-go/1.4/pkg/apis/extensions/v1beta1"
+)
+
+func TestBuildRouterConfigWarnings(t *testing.T) {
+	routerDeployment := v1beta1.Deployment{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      routerName,
+			Namespace: deisNamespace,
+			Annotations: map[string]string{
+				"router.deis.io/nginx.errorLogLevel":   "loud",
+				"router.deis.io/nginx.workerProcesses": "4",
+			},
+		},
+	}
+	platformCertSecret := v1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      platformCertName,
+			Namespace: deisNamespace,
+		},
+		Data: map[string][]byte{
+			"tls.crt": []byte("foo"),
+		},
+	}
+
+	routerConfig, err := buildRouterConfig(&routerDeployment, &platformCertSecret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routerConfig.Warnings) != 2 {
+		t.Fatalf("Expected 2 warnings, but got %d: %v", len(routerConfig.Warnings), routerConfig.Warnings)
+	}
+	annotationWarning := routerConfig.Warnings[0]
+	if annotationWarning.Kind != "Deployment" || annotationWarning.Name != routerName || annotationWarning.Reason != "InvalidAnnotation" {
+		t.Errorf("Expected an InvalidAnnotation warning for the router's deployment, but got %+v", annotationWarning)
+	}
+	certWarning := routerConfig.Warnings[1]
+	if certWarning.Kind != "Secret" || certWarning.Name != platformCertName || certWarning.Reason != "InvalidCertificate" {
+		t.Errorf("Expected an InvalidCertificate warning for the platform cert secret, but got %+v", certWarning)
+	}
+	// The offending annotation falls back to its default, while the others are still honored.
+	if routerConfig.ErrorLogLevel != "error" {
+		t.Errorf("Expected the default error log level, but got \"%s\"", routerConfig.ErrorLogLevel)
+	}
+	if routerConfig.WorkerProcesses != "4" {
+		t.Errorf("Expected 4 worker processes, but got \"%s\"", routerConfig.WorkerProcesses)
+	}
+}
+
+func TestWarnDeduplicates(t *testing.T) {
+	routerConfig := newRouterConfig()
+	warning := Warning{Kind: "Ingress", Namespace: "foo", Name: "bar", Reason: "InvalidAnnotation", Message: "baz"}
+	routerConfig.warn(warning)
+	routerConfig.warn(warning)
+	if len(routerConfig.Warnings) != 1 {
+		t.Errorf("Expected 1 warning, but got %d", len(routerConfig.Warnings))
+	}
+}
+
+func TestMapAnnotationsInvalidResource(t *testing.T) {
+	routerConfig := newRouterConfig()
+	meta := v1.ObjectMeta{
+		Name:        "foo",
+		Namespace:   "bar",
+		Annotations: map[string]string{"router.deis.io/count": "many"},
+	}
+	out := &struct {
+		Count int `key:"count"`
+	}{}
+	err := mapAnnotations(routerConfig, "Service", meta, "", out)
+	invalidErr, ok := err.(*invalidResourceError)
+	if !ok {
+		t.Fatalf("Expected an invalidResourceError, but got %v", err)
+	}
+	if invalidErr.warning.Kind != "Service" || invalidErr.warning.Namespace != "bar" || invalidErr.warning.Name != "foo" {
+		t.Errorf("Expected the warning to concern service bar/foo, but got %+v", invalidErr.warning)
+	}
+}
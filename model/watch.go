@@ -0,0 +1,158 @@
+package model
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"k8s.io/client-go/1.4/kubernetes"
+	"k8s.io/client-go/1.4/pkg/api"
+	"k8s.io/client-go/1.4/pkg/api/v1"
+	"k8s.io/client-go/1.4/pkg/apis/extensions/v1beta1"
+	"k8s.io/client-go/1.4/pkg/fields"
+	"k8s.io/client-go/1.4/pkg/watch"
+)
+
+const (
+	// minWatchRetryInterval and maxWatchRetryInterval bound how long to wait before re-establishing
+	// a watch that could not be opened, or that ended sooner than minWatchRetryInterval after it was
+	// opened.  The interval doubles with each consecutive failure.
+	minWatchRetryInterval = 5 * time.Second
+	maxWatchRetryInterval = 5 * time.Minute
+)
+
+// Watch opens watches on all k8s resources that contribute to the router's model-- routable
+// services and their endpoints, secrets, ingresses, and the router's own deployment-- and returns a channel that
+// receives a value whenever any of them change.  Bursts of changes are coalesced into a single
+// notification, so consumers should rebuild the model in its entirety upon each receipt.  Watches
+// that end or fail are re-established automatically until stopCh is closed.  Secrets are watched
+// only in the router's namespace and the namespaces of routable services and ingresses, since no
+// others contribute to the model.
+func Watch(kubeClient *kubernetes.Clientset, stopCh <-chan struct{}) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	secrets := &secretWatches{kubeClient: kubeClient, changes: changes, stopCh: stopCh, namespaces: map[string]bool{}}
+	secrets.watch(namespace)
+	go watchResource("routable services", func() (watch.Interface, error) {
+		return kubeClient.Services(api.NamespaceAll).Watch(listOptions)
+	}, secrets.observe, changes, stopCh)
+	// The endpoints controller copies services' labels to their endpoints, so the same selector
+	// matches the endpoints of routable services.
+	go watchResource("routable endpoints", func() (watch.Interface, error) {
+		return kubeClient.Endpoints(api.NamespaceAll).Watch(listOptions)
+	}, nil, changes, stopCh)
+	go watchResource("ingresses", func() (watch.Interface, error) {
+		return kubeClient.Extensions().Ingresses(api.NamespaceAll).Watch(api.ListOptions{})
+	}, secrets.observe, changes, stopCh)
+	go watchResource("router deployment", func() (watch.Interface, error) {
+		return kubeClient.Extensions().Deployments(namespace).Watch(api.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", "deis-router"),
+		})
+	}, nil, changes, stopCh)
+	return changes
+}
+
+// secretWatches tracks the namespaces in which secrets are watched.  Namespaces are added as
+// routable services and ingresses are observed in them, and are never removed, since a namespace's
+// secrets are few next to the cluster's.
+type secretWatches struct {
+	kubeClient *kubernetes.Clientset
+	changes    chan<- struct{}
+	stopCh     <-chan struct{}
+	mutex      sync.Mutex
+	namespaces map[string]bool
+}
+
+// observe watches secrets in the namespace of the object in the provided event, if they are not
+// watched already.
+func (s *secretWatches) observe(event watch.Event) {
+	switch object := event.Object.(type) {
+	case *v1.Service:
+		s.watch(object.Namespace)
+	case *v1beta1.Ingress:
+		s.watch(object.Namespace)
+	}
+}
+
+// watch watches secrets in the specified namespace, if they are not watched already.
+func (s *secretWatches) watch(ns string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if ns == "" || s.namespaces[ns] {
+		return
+	}
+	s.namespaces[ns] = true
+	go watchResource(fmt.Sprintf("secrets in namespace %s", ns), func() (watch.Interface, error) {
+		return s.kubeClient.Secrets(ns).Watch(api.ListOptions{})
+	}, nil, s.changes, s.stopCh)
+}
+
+// watchResource relays events from watches opened by the provided function as change
+// notifications, passing each event to the provided observer, if any, until stopCh is closed.  A
+// watch that cannot be opened, or that ends soon after it was opened, is re-established only after
+// a delay that doubles with each consecutive failure, so that a misbehaving API server is not
+// flooded with requests.
+func watchResource(description string, watchFunc func() (watch.Interface, error), observe func(watch.Event), changes chan<- struct{}, stopCh <-chan struct{}) {
+	retryInterval := minWatchRetryInterval
+	for {
+		watcher, err := watchFunc()
+		if err != nil {
+			log.Printf("WARN: Failed to watch %s; retrying in %s: %v", description, retryInterval, err)
+		} else {
+			opened := time.Now()
+			if !consumeEvents(watcher, observe, changes, stopCh) {
+				return
+			}
+			if time.Since(opened) >= minWatchRetryInterval {
+				log.Printf("INFO: Watch on %s ended; re-establishing it.", description)
+				retryInterval = minWatchRetryInterval
+				continue
+			}
+			log.Printf("WARN: Watch on %s ended as soon as it was opened; re-establishing it in %s.", description, retryInterval)
+		}
+		select {
+		case <-time.After(retryInterval):
+			retryInterval = nextRetryInterval(retryInterval)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func nextRetryInterval(retryInterval time.Duration) time.Duration {
+	retryInterval *= 2
+	if retryInterval > maxWatchRetryInterval {
+		return maxWatchRetryInterval
+	}
+	return retryInterval
+}
+
+// consumeEvents relays events from the provided watcher as change notifications, passing each to
+// the provided observer, if any, until either the watcher's result channel is closed, in which case
+// it returns true, or stopCh is closed, in which case it returns false.
+func consumeEvents(watcher watch.Interface, observe func(watch.Event), changes chan<- struct{}, stopCh <-chan struct{}) bool {
+	defer watcher.Stop()
+	for {
+		select {
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return true
+			}
+			if observe != nil {
+				observe(event)
+			}
+			notify(changes)
+		case <-stopCh:
+			return false
+		}
+	}
+}
+
+// notify sends a change notification without blocking.  If a notification is already pending, there
+// is no need to send another.
+func notify(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
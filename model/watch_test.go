@@ -0,0 +1,76 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/1.4/pkg/watch"
+)
+
+type fakeWatcher struct {
+	result  chan watch.Event
+	stopped bool
+}
+
+func (w *fakeWatcher) Stop() {
+	w.stopped = true
+}
+
+func (w *fakeWatcher) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+func TestConsumeEvents(t *testing.T) {
+	// Ensure a burst of events is coalesced into a single notification and that a closed result
+	// channel is reported as such.
+	watcher := &fakeWatcher{result: make(chan watch.Event, 3)}
+	changes := make(chan struct{}, 1)
+	for i := 0; i < 3; i++ {
+		watcher.result <- watch.Event{Type: watch.Modified}
+	}
+	close(watcher.result)
+
+	if ended := consumeEvents(watcher, nil, changes, make(chan struct{})); !ended {
+		t.Errorf("Expected a closed result channel to be reported as a watch that ended.")
+	}
+	if !watcher.stopped {
+		t.Errorf("Expected the watcher to be stopped.")
+	}
+	if len(changes) != 1 {
+		t.Errorf("Expected exactly one pending change notification, but found %d.", len(changes))
+	}
+
+	// Ensure closing stopCh ends consumption.
+	watcher = &fakeWatcher{result: make(chan watch.Event)}
+	stopCh := make(chan struct{})
+	close(stopCh)
+	if ended := consumeEvents(watcher, nil, changes, stopCh); ended {
+		t.Errorf("Expected a closed stop channel to be reported as a stopped watch.")
+	}
+}
+
+func TestConsumeEventsObserve(t *testing.T) {
+	// Ensure every event is passed to the observer, even when notifications are coalesced.
+	watcher := &fakeWatcher{result: make(chan watch.Event, 2)}
+	changes := make(chan struct{}, 1)
+	watcher.result <- watch.Event{Type: watch.Added}
+	watcher.result <- watch.Event{Type: watch.Deleted}
+	close(watcher.result)
+
+	observed := []watch.EventType{}
+	consumeEvents(watcher, func(event watch.Event) {
+		observed = append(observed, event.Type)
+	}, changes, make(chan struct{}))
+	if len(observed) != 2 || observed[0] != watch.Added || observed[1] != watch.Deleted {
+		t.Errorf("Expected both events to be observed in order, but got %v.", observed)
+	}
+}
+
+func TestNextRetryInterval(t *testing.T) {
+	if actual := nextRetryInterval(minWatchRetryInterval); actual != 2*minWatchRetryInterval {
+		t.Errorf("Expected retry interval to double to %s, but got %s.", 2*minWatchRetryInterval, actual)
+	}
+	if actual := nextRetryInterval(4 * time.Minute); actual != maxWatchRetryInterval {
+		t.Errorf("Expected retry interval to be capped at %s, but got %s.", maxWatchRetryInterval, actual)
+	}
+}
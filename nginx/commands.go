@@ -1,37 +1,170 @@
 package nginx
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/deis/router/metrics"
 )
 
 const (
 	nginxBinary = "/opt/router/sbin/nginx"
 )
 
-// Start nginx.
-func Start() error {
+const (
+	// minRestartBackoff and maxRestartBackoff bound how long the supervisor waits before restarting
+	// nginx after it exits unexpectedly.  The wait doubles with each restart, and starts over once
+	// nginx has stayed up for longer than the maximum.
+	minRestartBackoff = time.Second
+	maxRestartBackoff = time.Minute
+)
+
+var (
+	// exited is closed once the nginx master process started by Start exits and is not restarted,
+	// as when Quit asks it to.
+	exited = make(chan struct{})
+	// supervisorMutex guards running and quitting.
+	supervisorMutex sync.Mutex
+	running         bool
+	quitting        bool
+)
+
+// Start nginx with the configuration at the specified path, and supervise it: if the nginx master
+// process exits for any reason other than Quit, it is restarted with the configuration at the
+// known-good path, where KeepKnownGood last copied configuration nginx was successfully reloaded
+// with.
+func Start(configPath string, knownGoodPath string) error {
 	log.Println("INFO: Starting nginx...")
+	cmd, err := startMaster()
+	if err != nil {
+		return err
+	}
+	go supervise(cmd, configPath, knownGoodPath)
+	log.Println("INFO: nginx started.")
+	return nil
+}
+
+// Running returns whether the nginx master process is running and has not been asked to quit.
+func Running() bool {
+	supervisorMutex.Lock()
+	defer supervisorMutex.Unlock()
+	return running && !quitting
+}
+
+// KeepKnownGood copies the configuration at the specified path, which nginx was just successfully
+// reloaded with, to the known-good path, from which nginx is restarted if it exits unexpectedly.
+func KeepKnownGood(configPath string, knownGoodPath string) error {
+	return Install(configPath, knownGoodPath)
+}
+
+func startMaster() (*exec.Cmd, error) {
 	cmd := exec.Command(nginxBinary)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if err := cmd.Start(); err != nil {
-		return err
+		return nil, err
 	}
-	log.Println("INFO: nginx started.")
-	return nil
+	supervisorMutex.Lock()
+	defer supervisorMutex.Unlock()
+	running = true
+	return cmd, nil
+}
+
+// supervise waits for the nginx master process to exit, and restarts it, with backoff, unless it
+// was asked to quit.
+func supervise(cmd *exec.Cmd, configPath string, knownGoodPath string) {
+	var backoff time.Duration
+	for {
+		started := time.Now()
+		err := cmd.Wait()
+		if !restarting() {
+			close(exited)
+			return
+		}
+		backoff = restartBackoff(backoff, time.Since(started))
+		log.Printf("WARN: nginx exited unexpectedly (%v); restarting it in %s.", err, backoff)
+		for {
+			time.Sleep(backoff)
+			if !restarting() {
+				close(exited)
+				return
+			}
+			if _, err := os.Stat(knownGoodPath); err == nil {
+				if err := Install(knownGoodPath, configPath); err != nil {
+					log.Printf("WARN: Failed to restore the known-good nginx configuration; restarting nginx with the existing configuration: %v", err)
+				}
+			}
+			if cmd, err = startMaster(); err == nil {
+				break
+			}
+			backoff = restartBackoff(backoff, 0)
+			log.Printf("WARN: Failed to restart nginx; trying again in %s: %v", backoff, err)
+		}
+		metrics.NginxRestarts.Inc()
+		log.Println("INFO: nginx restarted.")
+	}
+}
+
+// restarting records that the nginx master process has exited, and returns whether it should be
+// restarted, which it should unless it was asked to quit.
+func restarting() bool {
+	supervisorMutex.Lock()
+	defer supervisorMutex.Unlock()
+	running = false
+	return !quitting
+}
+
+// restartBackoff returns how long to wait before restarting nginx, given how long was waited before
+// it was last restarted, if it has been, and how long it then ran for.  Repeated crashes wait longer
+// each time, up to maxRestartBackoff, but nginx that ran for longer than that is restarted promptly.
+func restartBackoff(previous time.Duration, ranFor time.Duration) time.Duration {
+	if previous == 0 || ranFor > maxRestartBackoff {
+		return minRestartBackoff
+	}
+	backoff := 2 * previous
+	if backoff > maxRestartBackoff {
+		return maxRestartBackoff
+	}
+	return backoff
 }
 
-// Reload nginx configuration.
+// Reload nginx configuration.  It returns once nginx has been signaled, with an error if it could
+// not be, e.g. because it is not running.
 func Reload() error {
 	log.Println("INFO: Reloading nginx...")
 	cmd := exec.Command(nginxBinary, "-s", "reload")
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	if err := cmd.Start(); err != nil {
+	if err := cmd.Run(); err != nil {
 		return err
 	}
 	log.Println("INFO: nginx reloaded.")
 	return nil
 }
+
+// Quit shuts nginx down gracefully: it stops accepting connections, and its workers exit once the
+// requests in progress are answered and idle connections closed.  It returns once nginx has exited,
+// or with an error if it has not within the provided timeout.
+func Quit(timeout time.Duration) error {
+	log.Println("INFO: Shutting nginx down gracefully...")
+	supervisorMutex.Lock()
+	quitting = true
+	supervisorMutex.Unlock()
+	cmd := exec.Command(nginxBinary, "-s", "quit")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return err
+	}
+	select {
+	case <-exited:
+		log.Println("INFO: nginx shut down.")
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("nginx did not exit within %s", timeout)
+	}
+}
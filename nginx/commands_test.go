@@ -0,0 +1,27 @@
+package nginx
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRestartBackoff(t *testing.T) {
+	for _, test := range []struct {
+		previous time.Duration
+		ranFor   time.Duration
+		expected time.Duration
+	}{
+		// The first restart waits the minimum.
+		{0, 10 * time.Second, minRestartBackoff},
+		// Repeated crashes wait longer each time, up to the maximum.
+		{minRestartBackoff, 10 * time.Second, 2 * minRestartBackoff},
+		{40 * time.Second, 10 * time.Second, maxRestartBackoff},
+		{maxRestartBackoff, 0, maxRestartBackoff},
+		// nginx that stayed up for longer than the maximum is restarted promptly.
+		{maxRestartBackoff, 2 * maxRestartBackoff, minRestartBackoff},
+	} {
+		if backoff := restartBackoff(test.previous, test.ranFor); backoff != test.expected {
+			t.Errorf("Expected a backoff of %s after waiting %s and running for %s, but got %s.", test.expected, test.previous, test.ranFor, backoff)
+		}
+	}
+}
@@ -1,32 +1,63 @@
 package nginx
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"hash/fnv"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/Masterminds/sprig"
+	"github.com/deis/router/metrics"
 	"github.com/deis/router/model"
+	"github.com/deis/router/nginx/templatefuncs"
+	"github.com/deis/router/utils/modeler"
 )
 
 const (
-	confTemplate = `{{ $routerConfig := . }}daemon off;
-pid /tmp/nginx.pid;
+	// sslSocket is the socket over which SSL connections are relayed from the stream module to the
+	// http servers when they are limited by server name.
+	sslSocket = "unix:/tmp/deis-ssl.sock"
+
+	// appConfigDir is the directory, relative to the main configuration file, in which each
+	// application's configuration is written to a file of its own.
+	appConfigDir    = "conf.d"
+	appConfigPrefix = "app-"
+	confTemplate    = `{{ $routerConfig := . }}daemon off;
+{{ if stagedInstance }}# Staged instance, serving new configuration alongside the instance serving the configuration in effect.
+pid /tmp/nginx-staged.pid;
+worker_processes {{ $routerConfig.StagedRolloutConfig.Workers }};
+{{ else }}pid /tmp/nginx.pid;
 worker_processes {{ $routerConfig.WorkerProcesses }};
+{{ end }}
+{{ if tracingEnabled $routerConfig }}load_module modules/ngx_http_opentracing_module.so;{{ end }}
+{{ if wafEnabled $routerConfig }}load_module modules/ngx_http_modsecurity_module.so;{{ end }}
 
 events {
 	worker_connections {{ $routerConfig.MaxWorkerConnections }};
-	# multi_accept on;
+	multi_accept {{ if $routerConfig.MultiAccept }}on{{ else }}off{{ end }};
+	accept_mutex {{ if $routerConfig.AcceptMutex }}on{{ else }}off{{ end }};
 }
 
 http {
 	# basic settings
-	sendfile on;
-	tcp_nopush on;
-	tcp_nodelay on;
+	sendfile {{ if $routerConfig.Sendfile }}on{{ else }}off{{ end }};
+	tcp_nopush {{ if $routerConfig.TCPNopush }}on{{ else }}off{{ end }};
+	tcp_nodelay {{ if $routerConfig.TCPNodelay }}on{{ else }}off{{ end }};
+	{{ if $routerConfig.AIO }}aio {{ $routerConfig.AIO }};{{ end }}
+	{{ if $routerConfig.Directio }}directio {{ $routerConfig.Directio }};{{ end }}
 
 	vhost_traffic_status_zone shared:vhost_traffic_status:{{ $routerConfig.TrafficStatusZoneSize }};
 
@@ -48,27 +79,100 @@ http {
 	gzip_vary {{ $gzipConfig.Vary }};{{ end }}
 
 	client_max_body_size {{ $routerConfig.BodySize }};
+	{{ if $routerConfig.ClientBodyTempPath }}client_body_temp_path {{ $routerConfig.ClientBodyTempPath }};{{ end }}
+	{{ if $routerConfig.ProxyTempPath }}proxy_temp_path {{ $routerConfig.ProxyTempPath }};{{ end }}
+	{{ if $routerConfig.ProxyMaxTempFileSize }}proxy_max_temp_file_size {{ $routerConfig.ProxyMaxTempFileSize }};{{ end }}
+
+	{{ with $openFileCacheConfig := $routerConfig.OpenFileCacheConfig }}{{ if $openFileCacheConfig.Enabled }}open_file_cache max={{ $openFileCacheConfig.Max }} inactive={{ $openFileCacheConfig.Inactive }};
+	open_file_cache_valid {{ $openFileCacheConfig.Valid }};
+	open_file_cache_min_uses {{ $openFileCacheConfig.MinUses }};
+	open_file_cache_errors {{ if $openFileCacheConfig.Errors }}on{{ else }}off{{ end }};{{ end }}{{ end }}
 
-	{{ range $realIPCIDR := $routerConfig.ProxyRealIPCIDRs -}}
+	{{ range $realIPCIDR := realIPCIDRs $routerConfig -}}
 	set_real_ip_from {{ $realIPCIDR }};
 	{{ end -}}
+	{{ if sniLimited $routerConfig -}}
+	# SSL connections are relayed from the stream module, which conveys their clients' addresses.
+	set_real_ip_from unix:;
+	{{ end -}}
 	real_ip_recursive on;
-	{{ if $routerConfig.UseProxyProtocol -}}
-	real_ip_header proxy_protocol;
-	{{- else -}}
-	real_ip_header X-Forwarded-For;
-	{{- end }}
+	real_ip_header {{ realIPHeader $routerConfig }};
 
-	log_format upstreaminfo '[$time_iso8601] - $app_name - $remote_addr - $remote_user - $status - "$request" - $bytes_sent - "$http_referer" - "$http_user_agent" - "$server_name" - $upstream_addr - $http_host - $upstream_response_time - $request_time';
+	{{ with $jsonLogFormat := jsonLogFormat $routerConfig }}log_format upstreaminfo escape=json '{{ $jsonLogFormat }}';{{ else }}log_format upstreaminfo '[$time_iso8601] - $app_name - $remote_addr - $remote_user - $status - "$request" - $bytes_sent - "$http_referer" - "$http_user_agent" - "$server_name" - $upstream_addr - $http_host - $upstream_response_time - $request_time';{{ end }}
 
 	access_log /tmp/logpipe upstreaminfo;
 	error_log  /tmp/logpipe {{ $routerConfig.ErrorLogLevel }};
+	{{ if $routerConfig.LatencyHistograms }}# The timing of each request is logged to the router, which exports histograms of it as metrics.
+	log_format deis_latency '$app_name|$request_time|$upstream_response_time';
+	access_log {{ latencyLog }};
+	{{ end }}{{ if $routerConfig.TLSMetrics }}# Failed TLS handshakes, and requests for server names that no application claims, are logged to
+	# the router, which exports counts of them as metrics.
+	map "$app_name:$ssl_server_name" $deis_unknown_sni {
+		default 0;
+		"~^router-default-vhost:." 1;
+	}
+	log_format deis_unknown_sni '$standard_server_port';
+	access_log {{ unknownSNILog }} if=$deis_unknown_sni;
+	error_log {{ tlsErrorLog }};
+	{{ end }}{{ with $syslog := syslogTarget $routerConfig nil }}access_log {{ $syslog }} upstreaminfo;
+	error_log  {{ $syslog }} {{ $routerConfig.ErrorLogLevel }};{{ end }}
+
+	{{ $debugBody := debugBodyContext $routerConfig }}{{ if $debugBody.AppConfigs }}
+	# Request body logging for debugging.  The first bytes of each request body are captured and then
+	# passed through one map per redaction pattern before being logged.
+	map "$app_name:$uri" $debug_body_enabled {
+		default 0;
+		{{ range $appConfig := $debugBody.AppConfigs }}"~^{{ quoteMeta $appConfig.Name }}:{{ quoteMeta $appConfig.DebugBodyConfig.Path }}" 1;
+		{{ end }}
+	}
+	map "$app_name:$request_body" $debug_body_0 {
+		default "";
+		{{ range $appConfig := $debugBody.AppConfigs }}"~(?s)^{{ quoteMeta $appConfig.Name }}:(.{0,{{ $appConfig.DebugBodyConfig.Size }}})" $1;
+		{{ end }}
+	}
+	{{ range $redactionStep := $debugBody.RedactionSteps }}map "$app_name:$debug_body_{{ $redactionStep.Index }}" $debug_body_{{ $redactionStep.NextIndex }} {
+		default $debug_body_{{ $redactionStep.Index }};
+		{{ range $redaction := $redactionStep.Redactions }}"~(?s)^{{ quoteMeta $redaction.AppName }}:(?<debug_body_before>.*?){{ escapeString $redaction.Pattern }}(?<debug_body_after>.*)$" "${debug_body_before}[REDACTED]${debug_body_after}";
+		{{ end }}
+	}
+	{{ end }}
+	log_format debugbody '[$time_iso8601] - $app_name - $remote_addr - "$request" - $status - body: "$debug_body_{{ len $debugBody.RedactionSteps }}"';
+	{{ end }}
 
 	map $http_upgrade $connection_upgrade {
 		default upgrade;
 		'' close;
 	}
 
+	# Connections to applications whose endpoints' connections are kept alive are only closed by
+	# requests to upgrade them.
+	map $http_upgrade $connection_upgrade_keepalive {
+		default upgrade;
+		'' "";
+	}
+	{{ if grpcWebEnabled $routerConfig }}
+	# gRPC-Web requests are passed to the router to be translated into gRPC.
+	map $content_type $deis_grpc_web {
+		default "";
+		"~^application/grpc-web" 1;
+	}
+	{{ end }}
+
+	# Accept-Encoding headers are reduced to whether they accept gzip, so that caches hold one variant
+	# of each response per encoding, rather than one per distinct header.
+	map $http_accept_encoding $deis_accept_encoding {
+		default "";
+		"~*(^|,)\s*gzip\s*;\s*q\s*=\s*0(\.0*)?\s*(,|$)" "";
+		"~*(^|,)\s*(gzip|\*)\s*(;|,|$)" gzip;
+	}
+
+	# Compressed responses that do not say they vary by Accept-Encoding are made to, so that no cache
+	# serves them to clients that cannot decode them.
+	map "$upstream_http_content_encoding|$upstream_http_vary" $deis_vary_accept_encoding {
+		default "";
+		"~*^[^|]+\|(?!.*(accept-encoding|\*))" Accept-Encoding;
+	}
+
 	# The next two maps work together to determine the $access_scheme:
 	# 1. Determine if SSL may have been offloaded by the load balancer, in such cases, an HTTP request should be
 	# treated as if it were HTTPs.
@@ -94,6 +198,7 @@ http {
 		default $server_port;
 		8080 80;
 		6443 443;
+		{{ if sniLimited $routerConfig }}'' 443; # SSL connections relayed over a unix socket have no port{{ end }}
 	}
 	# 2. If the X-Forwarded-Port header has been set already (e.g. by a load balancer), use its
 	# value, otherwise, the port we're forwarding for is the $standard_server_port we determined
@@ -107,9 +212,38 @@ http {
 		default "https";
 		"ws"	"wss";
 	}
+	{{ if $routerConfig.GeoIPDatabase }}
+	# Clients' countries and regions are looked up by their addresses, once those are taken from any
+	# trusted proxy.
+	geoip2 {{ $routerConfig.GeoIPDatabase }} {
+		$geoip2_country_code country iso_code;
+		$geoip2_region subdivisions 0 iso_code;
+	}
+	{{ with $geoIPRules := geoIPRules $routerConfig }}map "$app_name:$geoip2_country_code" $geoip_blocked {
+		default 0;
+		{{ range $rule := $geoIPRules }}"{{ $rule.Key }}" {{ if $rule.Refused }}1{{ else }}0{{ end }};
+		{{ end }}
+	}
+	{{ end }}{{ end }}
+	{{ with $earlyDataRules := earlyDataRules $routerConfig }}# Requests sent in TLS 1.3 early data may be replayed, so they are refused unless their methods are
+	# safe to repeat.
+	map "$app_name:$ssl_early_data:$request_method" $too_early {
+		default 0;
+		{{ range $rule := $earlyDataRules }}"{{ $rule.Key }}" {{ if $rule.Refused }}1{{ else }}0{{ end }};
+		{{ end }}
+	}
+	{{ end }}
+	{{ if transformsEnabled $routerConfig }}# Applications' requests are transformed by the router's library of njs functions.
+	js_import /opt/router/njs/transforms.js;
+	js_set $deis_tls_fingerprint transforms.deisTLSFingerprint;
+	{{ end }}
 
 
+	{{ $emergencyMode := emergencyMode $routerConfig }}
 	{{ $sslConfig := $routerConfig.SSLConfig }}
+	{{ if $sslConfig.OCSPStapling }}{{ with $ocspResolver := $sslConfig.OCSPResolver }}# The names of CAs' OCSP responders are looked up with these resolvers.
+	resolver{{ range $resolver := $ocspResolver }} {{ $resolver }}{{ end }};
+	{{ end }}{{ end }}
 	{{ $hstsConfig := $sslConfig.HSTSConfig }}{{ if $hstsConfig.Enabled }}
 	# HSTS instructs the browser to replace all HTTP links with HTTPS links for this domain until maxAge seconds from now.
 	# The $sts variable is used later in each server block.
@@ -118,13 +252,34 @@ http {
 	}
 	{{ end }}
 
+	{{ if $routerConfig.PropagateRequestIDs }}
+		# Requests that arrive with a valid X-Request-Id keep it as their ID.
+		map $http_x_request_id $deis_request_id {
+			default $request_id;
+			"~^[A-Za-z0-9._:-]{1,128}$" $http_x_request_id;
+		}
+	{{ end }}
 	{{ if $routerConfig.RequestIDs }}
 		map $http_x_correlation_id $correlation_id {
-			default "$http_x_correlation_id,$request_id";
-			'' $request_id;
+			default "$http_x_correlation_id,{{ requestID $routerConfig }}";
+			'' {{ requestID $routerConfig }};
 		}
 	{{ end }}
 
+	{{ if tracingEnabled $routerConfig }}
+	# Spans are reported for applications' requests only, not the router's own endpoints.
+	opentracing_load_tracer /usr/local/lib/libzipkin_opentracing_plugin.so /opt/router/conf/tracer.json;
+	opentracing_trace_locations off;
+	opentracing_tag app $app_name;
+	opentracing_tag request_id {{ requestID $routerConfig }};
+	{{ end }}
+
+	{{ if wafEnabled $routerConfig }}
+	# The Core Rule Set is loaded once, and inspects only the requests of applications that enable the WAF.
+	modsecurity off;
+	modsecurity_rules_file /opt/router/waf/main.conf;
+	{{ end }}
+
 	geo $isInternalClient {
 		default         0;
 		127.0.0.1       1;
@@ -143,6 +298,13 @@ http {
 		"~^.:(https|wss)$"  0;
   }
 
+	# Preflight requests, which browsers send before some cross-origin requests, are answered by the
+	# router on behalf of applications that enable CORS.
+	map "$request_method:$http_access_control_request_method" $cors_preflight {
+		default    0;
+		"~^OPTIONS:." 1;
+	}
+
 	{{ $enforceSecure := $sslConfig.Enforce }}
 	{{/* Since HSTS headers are not permitted on HTTP requests, 301 redirects to HTTPS resources are also necessary. */}}
 	{{/* This means we force HTTPS if HSTS is enabled. */}}
@@ -150,20 +312,48 @@ http {
 		{{ $enforceSecure := "true" }}
 	{{ end }}
 
+	{{ if $routerConfig.HTTPSnippet }}# Router-wide snippet
+	{{ $routerConfig.HTTPSnippet }}
+
+	{{ end }}{{ template "http-extra" $routerConfig }}
+	{{ range $cookie := affinityCookies $routerConfig }}# Clients without an affinity cookie are issued one, and balanced according to its value.
+	map $cookie_{{ $cookie }} $affinity_key_{{ $cookie }} {
+		'' $request_id;
+		default $cookie_{{ $cookie }};
+	}
+	map $cookie_{{ $cookie }} $affinity_cookie_{{ $cookie }} {
+		'' "{{ $cookie }}=$request_id; Path=/; HttpOnly";
+		default '';
+	}
+
+	{{ end }}
+	{{ with $defaultBackend := $routerConfig.DefaultBackend }}{{ if $defaultBackend.Endpoints }}# Requests for domains that no application claims are proxied to the default backend.
+	upstream deis-default-backend {
+		{{ range $server := $defaultBackend.Endpoints }}server {{ $server }};
+		{{ end }}
+	}
+
+	{{ end }}{{ end }}
 	# Default server handles requests for unmapped hostnames, including healthchecks
-	server {
-		listen 8080 default_server reuseport{{ if $routerConfig.UseProxyProtocol }} proxy_protocol{{ end }};
-		listen 6443 default_server ssl {{ if $routerConfig.HTTP2Enabled }}http2{{ end }} {{ if $routerConfig.UseProxyProtocol }}proxy_protocol{{ end }};
+	{{ range $serverListeners := defaultServers $routerConfig }}server {
+		{{ if $serverListeners.HTTP }}listen 8080 default_server reuseport{{ if $routerConfig.Backlog }} backlog={{ $routerConfig.Backlog }}{{ end }}{{ if $routerConfig.ProxyProtocolHTTP }} proxy_protocol{{ end }};{{ end }}
+		{{ if $serverListeners.SSL }}listen {{ sslListener $routerConfig }} default_server ssl {{ if $routerConfig.HTTP2 }}http2{{ end }} {{ if or $routerConfig.ProxyProtocolHTTPS (sniLimited $routerConfig) }}proxy_protocol{{ end }}{{ if stagedRollout $routerConfig }} reuseport{{ end }}{{ if $routerConfig.Backlog }} backlog={{ $routerConfig.Backlog }}{{ end }};{{ end }}
+		{{ with $serverListeners.RealIPHeader }}real_ip_header {{ . }};{{ end }}
 		set $app_name "router-default-vhost";
 		{{ if $routerConfig.PlatformCertificate }}
 		ssl_protocols {{ $sslConfig.Protocols }};
 		ssl_certificate /opt/router/ssl/platform.crt;
 		ssl_certificate_key /opt/router/ssl/platform.key;
+		{{ if $sslConfig.OCSPStapling }}ssl_stapling on;
+		ssl_stapling_verify {{ if $sslConfig.OCSPStaplingVerify }}on{{ else }}off{{ end }};{{ end }}
 		{{ else }}
 		ssl_protocols TLSv1 TLSv1.1 TLSv1.2;
 		ssl_certificate /opt/router/ssl/default/default.crt;
 		ssl_certificate_key /opt/router/ssl/default/default.key;
 		{{ end }}
+		{{ with $sessionCache := sslSessionCache $sslConfig }}ssl_session_cache {{ $sessionCache }};{{ end }}
+		ssl_session_timeout {{ $sslConfig.SessionTimeout }};
+		{{ if echEnabled $routerConfig }}ssl_echkeydir /opt/router/ssl/ech;{{ end }}
 		{{ if $routerConfig.ClientCertificates }}
 		ssl_client_certificate /opt/router/ssl/client.ca.crt;
 		ssl_verify_client on;
@@ -175,14 +365,49 @@ http {
 			default_type 'text/plain';
 			return 200;
 		}
+		location / {
+			{{ with $defaultBackend := $routerConfig.DefaultBackend }}{{ if $defaultBackend.Available }}proxy_buffering off;
+			proxy_set_header Host $host;
+			proxy_set_header X-Forwarded-For $remote_addr;
+			proxy_set_header X-Forwarded-Proto $access_scheme;
+			proxy_set_header X-Forwarded-Port $forwarded_port;
+			proxy_redirect off;
+			proxy_http_version 1.1;
+			proxy_set_header Upgrade $http_upgrade;
+			proxy_set_header Connection $connection_upgrade;
+			proxy_pass http://{{ if $defaultBackend.Endpoints }}deis-default-backend{{ else }}{{ $defaultBackend.ServiceIP }}:{{ $defaultBackend.ServicePort }}{{ end }};
+			{{- else }}return 503;{{ end }}{{ else }}return 404;{{ end }}
+		}
+	}
+	{{ end }}
+
+	{{ range $listener := $routerConfig.ListenerConfigs }}# Additional SSL listener on {{ $listener.Port }}.  nginx negotiates SSL before it knows which domain a client
+	# requests, so this server's protocols and ciphers apply to every domain served on the port.
+	server {
+		listen {{ $listener.Port }} default_server ssl{{ if eq $listener.HTTP2 "true" }} http2{{ end }}{{ if $routerConfig.ProxyProtocolHTTPS }} proxy_protocol{{ end }}{{ if stagedRollout $routerConfig }} reuseport{{ end }}{{ if $routerConfig.Backlog }} backlog={{ $routerConfig.Backlog }}{{ end }};
+		set $app_name "router-default-vhost";
+		ssl_protocols {{ $listener.Protocols }};
+		{{ if ne $listener.Ciphers "" }}ssl_ciphers {{ $listener.Ciphers }};{{ end }}
+		ssl_prefer_server_ciphers on;
+		{{ if $routerConfig.PlatformCertificate }}ssl_certificate /opt/router/ssl/platform.crt;
+		ssl_certificate_key /opt/router/ssl/platform.key;
+		{{ if $sslConfig.OCSPStapling }}ssl_stapling on;
+		ssl_stapling_verify {{ if $sslConfig.OCSPStaplingVerify }}on{{ else }}off{{ end }};
+		{{ end }}{{ else }}ssl_certificate /opt/router/ssl/default/default.crt;
+		ssl_certificate_key /opt/router/ssl/default/default.key;
+		{{ end }}{{ with $sessionCache := sslSessionCache $sslConfig }}ssl_session_cache {{ $sessionCache }};{{ end }}
+		ssl_session_timeout {{ $sslConfig.SessionTimeout }};
+		{{ if ne $sslConfig.DHParam "" }}ssl_dhparam /opt/router/ssl/dhparam.pem;{{ end }}
+		server_name _;
 		location / {
 			return 404;
 		}
 	}
 
-	# Healthcheck on 9090 -- never uses proxy_protocol
+	{{ end }}# Healthcheck on 9090 -- never uses proxy_protocol.  A staged instance reports its traffic on
+	# a port of its own.
 	server {
-		listen 9090 default_server;
+		listen {{ if stagedInstance }}{{ stagedStatsPort }}{{ else }}9090{{ end }} default_server;
 		server_name _;
 		set $app_name "router-healthz";
 		location ~ ^/healthz/?$ {
@@ -201,187 +426,2625 @@ http {
 		}
 	}
 
-	{{range $appConfig := $routerConfig.AppConfigs}}{{range $domain := $appConfig.Domains}}server {
-		listen 8080{{ if $routerConfig.UseProxyProtocol }} proxy_protocol{{ end }};
+	{{ if and (not stagedInstance) (grpcWebEnabled $routerConfig) }}# gRPC-Web requests that the router has translated into gRPC are proxied to the upstreams named
+	# in them.  Their applications' timeouts apply to the requests' way to the router.
+	server {
+		listen 127.0.0.1:9097;
+		server_name _;
+		set $app_name "router-grpc-web";
+		access_log off;
+		vhost_traffic_status off;
+		location / {
+			grpc_set_header X-Deis-Grpc-Web-Upstream "";
+			grpc_read_timeout 1h;
+			grpc_send_timeout 1h;
+			grpc_pass $http_x_deis_grpc_web_upstream;
+		}
+	}
+
+	{{ end }}# Each application is configured in a file of its own.
+	include conf.d/*.conf;
+}
+
+{{ if and (not stagedInstance) (or $routerConfig.BuilderConfig $routerConfig.StreamConfigs (sniLimited $routerConfig)) }}stream {
+	{{ if or $routerConfig.ProxyProtocolStream (and (sniLimited $routerConfig) $routerConfig.ProxyProtocolHTTPS) }}{{ range $realIPCIDR := realIPCIDRs $routerConfig }}set_real_ip_from {{ $realIPCIDR }};
+	{{ end }}
+	{{ end }}{{ if sniLimited $routerConfig }}# SSL connections are counted by the server name they request before they are relayed to the
+	# http servers that perform their handshakes.
+	limit_conn_zone $ssl_preread_server_name zone=deis_sni_conn:10m;
+	server {
+		listen 6443{{ if $routerConfig.ProxyProtocolHTTPS }} proxy_protocol{{ end }}{{ if $routerConfig.Backlog }} backlog={{ $routerConfig.Backlog }}{{ end }};
+		ssl_preread on;
+		limit_conn deis_sni_conn {{ $routerConfig.SSLConfig.SNIConnectionLimit }};
+		proxy_protocol on;
+		proxy_pass {{ sslListener $routerConfig }};
+	}
+
+	{{ end }}{{ if $routerConfig.BuilderConfig }}{{ $builderConfig := $routerConfig.BuilderConfig }}server {
+		listen 2222 {{ if $routerConfig.ProxyProtocolStream }}proxy_protocol{{ end }};
+		{{ with $routerConfig.ProxyBind }}proxy_bind {{ . }};{{ end }}
+		proxy_connect_timeout {{ $builderConfig.ConnectTimeout }};
+		proxy_timeout {{ $builderConfig.TCPTimeout }};
+		proxy_pass {{$builderConfig.ServiceIP}}:2222;
+	}{{ end }}
+
+	{{ range $streamConfig := $routerConfig.StreamConfigs }}# {{ $streamConfig.Protocol }} port {{ $streamConfig.ListenPort }} for {{ $streamConfig.Name }}
+	{{ if $streamConfig.Endpoints }}upstream {{ $streamConfig.Protocol }}_{{ $streamConfig.ListenPort }} {
+		{{ range $server := $streamConfig.Endpoints }}server {{ $server }};
+		{{ end }}
+	}
+	{{ end }}server {
+		listen {{ $streamConfig.ListenPort }}{{ if eq $streamConfig.Protocol "udp" }} udp{{ else if $routerConfig.ProxyProtocolStream }} proxy_protocol{{ end }};
+		{{ with $routerConfig.ProxyBind }}proxy_bind {{ . }};{{ end }}
+		proxy_connect_timeout {{ $streamConfig.ConnectTimeout }};
+		proxy_timeout {{ $streamConfig.TCPTimeout }};
+		proxy_pass {{ if $streamConfig.Endpoints }}{{ $streamConfig.Protocol }}_{{ $streamConfig.ListenPort }}{{ else }}{{ $streamConfig.ServiceIP }}:{{ $streamConfig.ServicePort }}{{ end }};
+	}
+
+	{{ end }}
+}{{ end }}
+
+{{ define "app" }}{{ $routerConfig := . }}{{ $emergencyMode := emergencyMode $routerConfig }}{{ $sslConfig := $routerConfig.SSLConfig }}# Generated by deis-router for {{ range $i, $appConfig := $routerConfig.AppConfigs }}{{ if $i }}, {{ end }}{{ $appConfig.Name }}{{ end }}
+
+	{{ range $split := canarySplits $routerConfig }}split_clients $request_id ${{ $split.Variable }} {
+		{{ $split.Weight }}% {{ $split.CanaryBackend }};
+		* {{ $split.Backend }};
+	}
+
+	{{ end }}
+	{{ range $context := locationContexts $routerConfig }}{{ if $context.SplitVariable }}split_clients $request_id ${{ $context.SplitVariable }} {
+		{{ range $weightedBackend := $context.WeightedBackends }}{{ if $weightedBackend.Percent }}{{ $weightedBackend.Percent }}%{{ else }}*{{ end }} {{ $weightedBackend.Backend }};
+		{{ end }}
+	}
+
+	{{ end }}{{ end }}
+	{{ range $upstream := upstreams $routerConfig }}upstream {{ $upstream.Name }} {
+		{{ if $upstream.AffinityCookie }}hash $affinity_key_{{ $upstream.AffinityCookie }} consistent;{{ else if ne $upstream.Algorithm "round_robin" }}{{ $upstream.Algorithm }};{{ end }}
+		{{ range $server := $upstream.Servers }}server {{ $server }}{{ $upstream.Parameters }};
+		{{ end }}{{ with $upstream.Backup }}server {{ . }}{{ $upstream.Parameters }}{{ if $upstream.Servers }} backup{{ end }};
+		{{ end }}{{ with $keepalive := $upstream.Keepalive }}keepalive {{ $keepalive.Connections }};
+		keepalive_requests {{ $keepalive.Requests }};
+		keepalive_timeout {{ $keepalive.Timeout }};
+		{{ end }}
+	}
+
+	{{ end }}
+	{{ range $cacheControlMap := cacheControlMaps $routerConfig }}map {{ $cacheControlMap.Source }} ${{ $cacheControlMap.Variable }} {
+		{{ $cacheControlMap.Match }} {{ $cacheControlMap.Value }};
+		default {{ $cacheControlMap.Default }};
+	}
+
+	{{ end }}
+	{{ range $rateLimit := rateLimits $routerConfig }}{{ if $rateLimit.ExemptPaths }}map $uri {{ $rateLimit.Key }} {
+		{{ range $path := $rateLimit.ExemptPaths }}"{{ $path }}" "";
+		{{ end }}default {{ $rateLimit.ClientKey }};
+	}
+	{{ end }}{{ if $rateLimit.Rate }}limit_req_zone {{ $rateLimit.Key }} zone={{ $rateLimit.Zone }}_req:{{ $rateLimit.ZoneSize }} rate={{ $rateLimit.Rate }};{{ end }}
+	{{ if $rateLimit.Connections }}limit_conn_zone {{ $rateLimit.Key }} zone={{ $rateLimit.Zone }}_conn:{{ $rateLimit.ZoneSize }};{{ end }}
+
+	{{ end }}
+	{{ range $appConfig := $routerConfig.AppConfigs }}{{ with $corsConfig := $appConfig.CORSConfig }}{{ if and $corsConfig.Enabled (ne (corsOrigin $appConfig) "*") }}map $http_origin {{ corsOrigin $appConfig }} {
+		default {{ if $corsConfig.AllowsAnyOrigin }}$http_origin{{ else }}""{{ end }};
+		{{ range $origin := corsOrigins $corsConfig }}"{{ $origin }}" $http_origin;
+		{{ end }}
+	}
+	{{ end }}{{ end }}{{ end }}
+	{{ range $faultInjection := faultInjections $routerConfig }}{{ if $faultInjection.DelayPercent }}split_clients "${request_id}delay" $fault_delay_{{ $faultInjection.ID }} {
+		{{ if lt $faultInjection.DelayPercent 100 }}{{ $faultInjection.DelayPercent }}% 1;
+		* "";{{ else }}* 1;{{ end }}
+	}
+	{{ end }}{{ if $faultInjection.AbortPercent }}split_clients "${request_id}abort" $fault_abort_{{ $faultInjection.ID }} {
+		{{ if lt $faultInjection.AbortPercent 100 }}{{ $faultInjection.AbortPercent }}% 1;
+		* "";{{ else }}* 1;{{ end }}
+	}
+	{{ end }}
+	{{ end }}
+	{{ range $appConfig := $routerConfig.AppConfigs }}{{ if proxyCacheEnabled $appConfig }}{{ $proxyCacheConfig := $appConfig.ProxyCacheConfig }}{{ $zone := proxyCacheZone $appConfig }}proxy_cache_path /opt/router/cache/{{ $zone }} levels=1:2 keys_zone={{ $zone }}:{{ $proxyCacheConfig.ZoneSize }} max_size={{ $proxyCacheConfig.MaxSize }} inactive={{ $proxyCacheConfig.Inactive }} use_temp_path=off;
+	{{ end }}{{ with $externalAuthConfig := $appConfig.ExternalAuthConfig }}{{ if and $externalAuthConfig.URL $externalAuthConfig.CacheTTL }}{{ $zone := authCacheZone $appConfig }}proxy_cache_path /opt/router/cache/{{ $zone }} levels=1:2 keys_zone={{ $zone }}:1m max_size=64m inactive={{ $externalAuthConfig.CacheTTL }} use_temp_path=off;
+	{{ end }}{{ end }}{{ end }}
+	{{range $appConfig := $routerConfig.AppConfigs}}{{range $domain := $appConfig.Domains}}{{ range $serverListeners := domainServers $routerConfig $appConfig $domain }}server {
+		{{ if $serverListeners.HTTP }}listen 8080{{ if $routerConfig.ProxyProtocolHTTP }} proxy_protocol{{ end }};{{ end }}
 		server_name {{ if contains "." $domain }}{{ $domain }}{{ else if ne $routerConfig.PlatformDomain "" }}{{ $domain }}.{{ $routerConfig.PlatformDomain }}{{ else }}~^{{ $domain }}\.(?<domain>.+)${{ end }};
 		server_name_in_redirect off;
 		port_in_redirect off;
 		set $app_name "{{ $appConfig.Name }}";
+		{{ if $appConfig.BodySize }}client_max_body_size {{ $appConfig.BodySize }};{{ end }}
+		{{ if $appConfig.ClientBodyBufferSize }}client_body_buffer_size {{ $appConfig.ClientBodyBufferSize }};{{ end }}
+		{{ if $appConfig.ClientBodyTempPath }}client_body_temp_path {{ $appConfig.ClientBodyTempPath }};{{ end }}
+		{{ with $conditionalConfig := $appConfig.ConditionalConfig }}etag {{ if $conditionalConfig.ETag }}on{{ else }}off{{ end }};
+		if_modified_since {{ $conditionalConfig.IfModifiedSince }};{{ end }}
+		{{ with $rangesConfig := $appConfig.RangesConfig }}{{ if $rangesConfig.Force }}proxy_force_ranges on;{{ end }}
+		{{ if $rangesConfig.Max }}max_ranges {{ $rangesConfig.Max }};{{ end }}{{ end }}
+		{{ if tracingEnabled $routerConfig }}opentracing on;{{ end }}
+		{{ with $wafConfig := $appConfig.WAFConfig }}{{ if $wafConfig.Enabled }}modsecurity on;
+		modsecurity_rules 'SecRuleEngine {{ if eq $wafConfig.Mode "block" }}On{{ else }}DetectionOnly{{ end }}';
+		{{ if $wafConfig.Rules }}modsecurity_rules_file /opt/router/waf/{{ wafRulesFile $appConfig }};{{ end }}{{ end }}{{ end }}
+		{{ with cdnRealIPHeader $routerConfig $appConfig }}{{ range $realIPCIDR := cdnRealIPCIDRs $routerConfig $appConfig }}set_real_ip_from {{ $realIPCIDR }};
+		{{ end }}real_ip_header {{ . }};{{ else }}{{ with $serverListeners.RealIPHeader }}real_ip_header {{ . }};{{ end }}{{ end }}
 
-		{{ if index $appConfig.Certificates $domain }}
-		listen 6443 ssl {{ if $routerConfig.HTTP2Enabled }}http2{{ end }} {{ if $routerConfig.UseProxyProtocol }}proxy_protocol{{ end }};
-		ssl_protocols {{ $sslConfig.Protocols }};
+		{{ if or $serverListeners.SSL $serverListeners.Extra }}
+		{{ if $serverListeners.SSL }}listen {{ sslListener $routerConfig }} ssl {{ if $routerConfig.HTTP2 }}http2{{ end }} {{ if or $routerConfig.ProxyProtocolHTTPS (sniLimited $routerConfig) }}proxy_protocol{{ end }};{{ end }}
+		{{ if $serverListeners.Extra }}{{ range $listener := listenersFor $routerConfig $domain }}listen {{ $listener.Port }} ssl{{ if eq $listener.HTTP2 "true" }} http2{{ end }}{{ if $routerConfig.ProxyProtocolHTTPS }} proxy_protocol{{ end }};
+		{{ end }}{{ end }}ssl_protocols {{ $sslConfig.Protocols }};
 		{{ if ne $sslConfig.Ciphers "" }}ssl_ciphers {{ $sslConfig.Ciphers }};{{ end }}
 		ssl_prefer_server_ciphers on;
-		ssl_certificate /opt/router/ssl/{{ $domain }}.crt;
-		ssl_certificate_key /opt/router/ssl/{{ $domain }}.key;
-		{{ if ne $sslConfig.SessionCache "" }}ssl_session_cache {{ $sslConfig.SessionCache }};
-		ssl_session_timeout {{ $sslConfig.SessionTimeout }};{{ end }}
+		ssl_certificate /opt/router/ssl/{{ certFileName $appConfig $domain }}.crt;
+		ssl_certificate_key /opt/router/ssl/{{ certFileName $appConfig $domain }}.key;
+		{{ with $sessionCache := sslSessionCache $sslConfig }}ssl_session_cache {{ $sessionCache }};{{ end }}
+		ssl_session_timeout {{ $sslConfig.SessionTimeout }};
+		{{ if echEnabled $routerConfig }}ssl_echkeydir /opt/router/ssl/ech;{{ end }}
 		ssl_session_tickets {{ if $sslConfig.UseSessionTickets }}on{{ else }}off{{ end }};
 		ssl_buffer_size {{ $sslConfig.BufferSize }};
+		{{ if $sslConfig.OCSPStapling }}ssl_stapling on;
+		ssl_stapling_verify {{ if $sslConfig.OCSPStaplingVerify }}on{{ else }}off{{ end }};{{ end }}
+		{{ with $trustedCA := trustedCA $routerConfig $appConfig $domain }}ssl_trusted_certificate /opt/router/ssl/{{ $trustedCA }}.ca.crt;{{ end }}
+		{{ with $earlyDataConfig := $appConfig.EarlyDataConfig }}{{ if $earlyDataConfig.Enabled }}ssl_early_data on;{{ end }}{{ end }}
 		{{ if ne $sslConfig.DHParam "" }}ssl_dhparam /opt/router/ssl/dhparam.pem;{{ end }}
 
-		{{ if $routerConfig.ClientCertificates }}
+		{{ with $clientVerification := index $appConfig.ClientVerifications $domain }}
+		ssl_client_certificate /opt/router/ssl/{{ fileName $domain }}.client.ca.crt;
+		ssl_verify_client {{ $clientVerification.Verify }};
+		ssl_verify_depth {{ $clientVerification.Depth }};
+		{{ else }}{{ if $routerConfig.ClientCertificates }}
 		ssl_client_certificate /opt/router/ssl/client.ca.crt;
 		ssl_verify_client on;
-		{{ end }}
+		{{ end }}{{ end }}
 
 		{{ end }}
 
-		{{ if or $routerConfig.EnforceWhitelists (or (ne (len $routerConfig.DefaultWhitelist) 0) (ne (len $appConfig.Whitelist) 0)) }}
-		{{ if or (eq (len $appConfig.Whitelist) 0) (eq $routerConfig.WhitelistMode "extend") }}{{ range $whitelistEntry := $routerConfig.DefaultWhitelist }}allow {{ $whitelistEntry }};{{ end }}{{ end }}
-		{{ range $whitelistEntry := $appConfig.Whitelist }}allow {{ $whitelistEntry }};{{ end }}
+		{{ if eq $emergencyMode "allowlist-only" }}
+		{{ range $allowlistEntry := $routerConfig.EmergencyConfig.Allowlist }}allow {{ $allowlistEntry }};{{ end }}
+		deny all;
+		{{ else }}
+		{{ range $denylistEntry := $appConfig.Denylist }}deny {{ $denylistEntry }};{{ end }}
+		{{ if or $routerConfig.EnforceWhitelists (or (ne (len $routerConfig.DefaultWhitelist) 0) $appConfig.Whitelisted) }}
+		{{ if or (not $appConfig.Whitelisted) (eq $routerConfig.WhitelistMode "extend") }}{{ range $whitelistEntry := $routerConfig.DefaultWhitelist }}allow {{ $whitelistEntry }};{{ end }}{{ end }}
+		{{ range $whitelistEntry := appWhitelist $routerConfig $appConfig }}allow {{ $whitelistEntry }};{{ end }}
 		deny all;
+		{{ end }}{{ end }}
+		{{ if $routerConfig.GeoIPDatabase }}{{ with $geoIPConfig := $appConfig.GeoIPConfig }}{{ if $geoIPConfig.Filtered }}
+		if ($geoip_blocked) {
+			return 403;
+		}
+		{{ end }}{{ end }}{{ end }}
+		{{ with $earlyDataConfig := $appConfig.EarlyDataConfig }}{{ if $earlyDataConfig.Enabled }}
+		if ($too_early) {
+			return 425;
+		}
+		{{ end }}{{ end }}
+
+		{{ if $appConfig.BasicAuthUsers }}
+		auth_basic "{{ escapeString $appConfig.BasicAuthRealm }}";
+		auth_basic_user_file /opt/router/ssl/{{ fileName $domain }}.htpasswd;
 		{{ end }}
 
 		vhost_traffic_status_filter_by_set_key {{ $appConfig.Name }} application::*;
 
+		{{ range $accessLog := appAccessLogs $routerConfig $appConfig }}access_log {{ $accessLog }};
+		{{ end }}{{ with $syslog := syslogTarget $routerConfig $appConfig }}error_log /tmp/logpipe {{ $routerConfig.ErrorLogLevel }};
+		error_log {{ $syslog }} {{ $routerConfig.ErrorLogLevel }};{{ if $routerConfig.TLSMetrics }}
+		error_log {{ tlsErrorLog }};{{ end }}{{ end }}
+
+		{{ with $rateLimitConfig := $appConfig.RateLimitConfig }}{{ $zone := rateLimitZone $appConfig }}
+		{{ if $rateLimitConfig.Rate }}limit_req zone={{ $zone }}_req{{ if $rateLimitConfig.Burst }} burst={{ $rateLimitConfig.Burst }}{{ end }}{{ if $rateLimitConfig.NoDelay }} nodelay{{ end }};{{ end }}
+		{{ if $rateLimitConfig.Connections }}limit_conn {{ $zone }}_conn {{ $rateLimitConfig.Connections }};{{ end }}
+		{{ end }}
+
+		{{ with $rateLimitResponseConfig := rateLimitResponse $appConfig }}
+		{{/* Requests rejected by rate or connection limiting are marked with a status neither nginx nor
+		     applications use so that they can be answered distinctly, even if the configured status is
+		     503, and so that an application's own 429s pass through even when its errors are
+		     intercepted. */}}
+		limit_req_status 460;
+		limit_conn_status 460;
+		error_page 460 ={{ $rateLimitResponseConfig.Status }} @rate_limited;
+		location @rate_limited {
+			{{ if $rateLimitResponseConfig.RetryAfter }}add_header Retry-After {{ $rateLimitResponseConfig.RetryAfter }} always;{{ end }}
+			{{ if $rateLimitResponseConfig.Body }}default_type application/json;
+			return {{ $rateLimitResponseConfig.Status }} "{{ escapeString $rateLimitResponseConfig.Body }}";
+			{{- else if eq $appConfig.ErrorFormat "json" }}default_type application/json;
+			return {{ $rateLimitResponseConfig.Status }} "{{ escapeString (jsonErrorBody $routerConfig (print $rateLimitResponseConfig.Status)) }}";
+			{{- else }}return {{ $rateLimitResponseConfig.Status }};{{ end }}
+		}
+		{{ end }}
+
+		{{ with $bodySizeResponseConfig := bodySizeResponse $appConfig }}
+		{{/* nginx uses the first error page given for a status, so this precedes any the application has
+		     for 413. */}}
+		error_page 413 @body_too_large;
+		location @body_too_large {
+			{{ if $bodySizeResponseConfig.CORS }}{{ with $corsConfig := $appConfig.CORSConfig }}{{ if $corsConfig.Enabled }}{{ $corsOrigin := corsOrigin $appConfig }}add_header Access-Control-Allow-Origin {{ $corsOrigin }} always;
+			{{ if $corsConfig.AllowCredentials }}add_header Access-Control-Allow-Credentials true always;{{ end }}
+			{{ if $corsConfig.ExposeHeaders }}add_header Access-Control-Expose-Headers "{{ join ", " $corsConfig.ExposeHeaders }}" always;{{ end }}
+			{{ if ne $corsOrigin "*" }}add_header Vary Origin always;{{ end }}
+			{{ end }}{{ end }}{{ end }}
+			{{ if $bodySizeResponseConfig.Body }}default_type application/json;
+			return 413 "{{ escapeString $bodySizeResponseConfig.Body }}";
+			{{- else if eq $appConfig.ErrorFormat "json" }}default_type application/json;
+			return 413 "{{ escapeString (jsonErrorBody $routerConfig "413") }}";
+			{{- else }}return 413;{{ end }}
+		}
+		{{ end }}
+
+		{{ with $externalAuthConfig := $appConfig.ExternalAuthConfig }}{{ if $externalAuthConfig.URL }}
+		{{ if $externalAuthConfig.SigninURL }}error_page 401 = {{ $externalAuthConfig.SigninURL }}?rd=$access_scheme://$host$request_uri;{{ end }}
+		location = /_deis_external_auth {
+			internal;
+			{{ if $externalAuthConfig.Method }}proxy_method {{ $externalAuthConfig.Method }};{{ end }}
+			proxy_pass_request_body off;
+			proxy_set_header Content-Length "";
+			proxy_set_header X-Original-URI $request_uri;
+			proxy_set_header X-Original-Method $request_method;
+			proxy_set_header X-Original-URL $access_scheme://$host$request_uri;
+			proxy_set_header X-Forwarded-Host $host;
+			proxy_set_header X-Forwarded-Proto $access_scheme;
+			proxy_set_header X-Forwarded-For $remote_addr;
+			proxy_set_header X-Auth-Request-Redirect $request_uri;
+			proxy_ssl_server_name on;
+			{{ if $externalAuthConfig.CacheTTL }}{{/* Every method a subrequest may have that nginx can cache is cached, since the
+			     body, which alone makes the methods differ, is never passed. */}}proxy_cache {{ authCacheZone $appConfig }};
+			proxy_cache_key "{{ $externalAuthConfig.URL }}{{ authCacheKey $externalAuthConfig }}";
+			proxy_cache_methods GET HEAD POST;
+			proxy_cache_valid 200 202 204 401 403 {{ $externalAuthConfig.CacheTTL }};
+			proxy_ignore_headers Cache-Control Expires;
+			{{ end }}proxy_pass {{ $externalAuthConfig.URL }};
+		}
+		{{ end }}{{ end }}
+
+		{{ with $faultInjection := faultInjection $routerConfig $appConfig }}{{ if $faultInjection.DelayPercent }}
+		{{/* Every request is first authorized by this subrequest, which the router answers only
+		     after a delay, but only for the share of requests chosen to be delayed. */}}
+		location = /_deis_fault_delay {
+			internal;
+			if ($fault_delay_{{ $faultInjection.ID }} = "") {
+				return 204;
+			}
+			proxy_pass_request_body off;
+			proxy_set_header Content-Length "";
+			proxy_pass http://127.0.0.1:9093/?duration={{ $faultInjection.Delay }};
+		}
+		{{ end }}{{ end }}
+
+		{{ range $status := jsonErrorStatuses $routerConfig $appConfig }}error_page {{ $status }} {{ jsonErrorPage $status }};
+		location {{ jsonErrorPage $status }} {
+			default_type application/json;
+			return {{ $status }} "{{ escapeString (jsonErrorBody $routerConfig $status) }}";
+		}
+		{{ end }}
+
+		{{ if and $routerConfig.SnippetsEnabled $appConfig.ServerSnippet }}# Application snippet
+		{{ $appConfig.ServerSnippet }}
+		{{ end }}{{ template "server-extra" $appConfig }}
+
+		{{ if and $routerConfig.ACMEConfig $appConfig.ACMEDomains }}{{ if $routerConfig.ACMEConfig.Enabled }}
+		location /.well-known/acme-challenge/ {
+			allow all;
+			auth_basic off;
+			{{ with $externalAuthConfig := $appConfig.ExternalAuthConfig }}{{ if $externalAuthConfig.URL }}auth_request off;{{ end }}{{ end }}
+			proxy_pass http://127.0.0.1:9092;
+		}
+		{{ end }}{{ end }}
+		{{ range $path := $appConfig.HealthcheckPaths }}location = {{ $path }} {
+			{{/* Health probes are proxied like any other request, but kept out of the application's
+			     logs and metrics. */}}access_log off;
+			vhost_traffic_status off;
+			{{ template "location" (healthLocation $routerConfig $appConfig $path) }}
+		}
+		{{ end }}{{ range $location := $appConfig.Locations }}location {{ $location.Path }} {
+			{{ template "location" (locationContext $routerConfig $appConfig $location) }}
+		}
+		{{ template "retries" (locationContext $routerConfig $appConfig $location) }}{{ end }}
 		location / {
+			{{ template "location" (locationContext $routerConfig $appConfig nil) }}
+		}
+		{{ template "retries" (locationContext $routerConfig $appConfig nil) }}
+		{{ if $appConfig.ErrorPages }}{{ range $status, $page := $appConfig.ErrorPages }}error_page {{ $status }} /_deis_errors/{{ $status }}.html;
+		{{ end }}location ^~ /_deis_errors/ {
+			internal;
+			allow all;
+			auth_basic off;
+			{{ with $externalAuthConfig := $appConfig.ExternalAuthConfig }}{{ if $externalAuthConfig.URL }}auth_request off;{{ end }}{{ end }}
+			default_type text/html;
+			alias /opt/router/errors/{{ errorPagesDir $appConfig }}/;
+		}
+		{{ end }}
+		{{ if and (or $appConfig.Maintenance (eq $emergencyMode "static-503")) (not (index $appConfig.ErrorPages "503")) }}error_page 503 @maintenance;
+			location @maintenance {
+					root /;
+			    rewrite ^(.*)$ /www/maintenance.html break;
+			}
+		{{ end }}
+	}
+
+	{{end}}{{end}}{{end}}
+{{ end }}
+
+{{ define "retries" }}{{ $appConfig := .AppConfig }}{{ with grpcWebContext . }}location {{ grpcWebName . }} {
+			{{ template "location" . }}
+		}
+		{{ end }}{{ range $retry := retries . }}{{/* Only requests that failed because no endpoint accepted a connection are retried.  The
+		     router answers the request it is proxied after a backoff, redirecting nginx to retry. */}}
+		location {{ $retry.Name }} {
+			recursive_error_pages on;
+			if ($upstream_connect_time !~ "-$") {
+				return 502;
+			}
+			set $deis_retry "duration={{ $retry.Delay }}&jitter=true&redirect={{ urlquery $retry.ProxyName }}";
+			proxy_pass_request_body off;
+			proxy_set_header Content-Length "";
+			proxy_pass http://127.0.0.1:9093/?$deis_retry;
+		}
+		location {{ $retry.ProxyName }} {
+			{{ template "location" $retry.Context }}
+		}
+		{{ end }}{{ end }}
+
+{{ define "location" }}{{ $routerConfig := .RouterConfig }}{{ $appConfig := .AppConfig }}{{ $location := .Location }}{{ $emergencyMode := emergencyMode $routerConfig }}{{ $proxy := locationModule . }}
+			{{- $sslConfig := $routerConfig.SSLConfig }}{{ $hstsConfig := $sslConfig.HSTSConfig }}
+			{{ if $location.BodySize }}client_max_body_size {{ $location.BodySize }};{{ end }}
+			{{ if eq $emergencyMode "allowlist-only" }}
+			{{ range $allowlistEntry := $routerConfig.EmergencyConfig.Allowlist }}allow {{ $allowlistEntry }};{{ end }}
+			deny all;
+			{{ else if $location.Whitelist }}
+			{{ range $denylistEntry := $appConfig.Denylist }}deny {{ $denylistEntry }};{{ end }}
+			{{ range $whitelistEntry := $location.Whitelist }}allow {{ $whitelistEntry }};{{ end }}
+			deny all;
+			{{ end }}
+			{{ if and $appConfig.BasicAuthUsers (not $location.Auth) }}auth_basic off;{{ end }}
+
 			{{ if $routerConfig.RequestIDs }}
-			add_header X-Request-Id $request_id always;
+			add_header X-Request-Id {{ requestID $routerConfig }} always;
 			add_header X-Correlation-Id $correlation_id always;
 			{{end}}
 
-			{{ if $appConfig.Maintenance }}return 503;{{ else if $appConfig.Available }}proxy_buffering off;
-			proxy_set_header Host $host;
-			proxy_set_header X-Forwarded-For $remote_addr;
-			proxy_set_header X-Forwarded-Proto $access_scheme;
-			proxy_set_header X-Forwarded-Port $forwarded_port;
-			proxy_redirect off;
-			proxy_connect_timeout {{ $appConfig.ConnectTimeout }};
-			proxy_send_timeout {{ $appConfig.TCPTimeout }};
-			proxy_read_timeout {{ $appConfig.TCPTimeout }};
-			proxy_http_version 1.1;
+			{{ with redirectTarget $appConfig }}return {{ $appConfig.RedirectConfig.Status }} {{ . }}$request_uri;
+			{{ end }}{{ if or $appConfig.Maintenance (eq $emergencyMode "static-503") }}return 503;{{ else if $location.Available }}{{ if locationCached $appConfig $location }}{{ $proxyCacheConfig := $appConfig.ProxyCacheConfig }}proxy_buffering on;
+			proxy_cache {{ proxyCacheZone $appConfig }};
+			proxy_cache_key {{ $proxyCacheConfig.Key }}{{ if $proxyCacheConfig.NormalizeAcceptEncoding }}:$deis_accept_encoding{{ end }};
+			{{ if $proxyCacheConfig.Revalidate }}proxy_cache_revalidate on;
+			{{ end }}			{{ if $proxyCacheConfig.NormalizeAcceptEncoding }}proxy_set_header Accept-Encoding $deis_accept_encoding;
+			gzip_vary on;
+			add_header Vary $deis_vary_accept_encoding;
+			{{ end }}{{ range $status, $duration := $proxyCacheConfig.Valid }}proxy_cache_valid {{ $status }} {{ $duration }};
+			{{ end }}{{ with $bypass := cacheBypass $proxyCacheConfig }}proxy_cache_bypass {{ $bypass }};
+			proxy_no_cache {{ $bypass }};
+			{{ end }}add_header X-Cache-Status $upstream_cache_status always;
+			{{ else if eq $proxy "proxy" }}proxy_buffering {{ if $appConfig.ProxyBuffering }}on{{ else }}off{{ end }};{{ end }}
+			{{ if and $appConfig.ErrorPages (eq (proxyModule $appConfig) "proxy") }}proxy_intercept_errors on;{{ end }}
+			{{ if and (not $appConfig.RequestBuffering) (eq $proxy "proxy") }}proxy_request_buffering off;{{ end }}
+			{{ with cacheControlValue $appConfig $location }}{{ if cachingForced $appConfig }}{{ $proxy }}_hide_header Cache-Control;
+			add_header Cache-Control {{ . }} always;{{ else }}add_header Cache-Control {{ . }};{{ end }}{{ end }}
+			{{ with expires $appConfig $location }}expires {{ . }};{{ end }}
+			{{ with $conditionalConfig := $appConfig.ConditionalConfig }}{{ if not $conditionalConfig.ETag }}{{ $proxy }}_hide_header ETag;{{ end }}{{ end }}
+			{{ with allowedHeaders $appConfig }}{{/* Headers nginx adds itself, such as those of add_header,
+			     are added after this filter runs, and so are kept. */}}set $deis_allowed_response_headers "{{ . }}";
+			js_header_filter transforms.deisAllowResponseHeaders;
+			{{ end }}
+			{{ $proxy }}_set_header Host {{ if .ExternalOrigin }}$proxy_host{{ else }}$host{{ end }};
+			{{ $proxy }}_set_header X-Forwarded-For $remote_addr;
+			{{ $proxy }}_set_header X-Forwarded-Proto $access_scheme;
+			{{ $proxy }}_set_header X-Forwarded-Port $forwarded_port;
+			{{ with cdnRealIPHeader $routerConfig $appConfig }}{{/* Clients' addresses are passed on as found, so that those not sent by way of the
+			     CDN cannot be spoofed. */}}{{ $proxy }}_set_header True-Client-IP $remote_addr;
+			{{ if ne . "X-Forwarded-For" }}{{ $proxy }}_set_header {{ . }} $remote_addr;{{ end }}{{ end }}
+			{{ if eq $proxy "proxy" }}proxy_redirect off;{{ end }}
+			{{ with proxyBind $routerConfig $appConfig }}{{ $proxy }}_bind {{ . }};{{ end }}
+			{{ $proxy }}_connect_timeout {{ $location.ConnectTimeout }};
+			{{ $proxy }}_send_timeout {{ or $location.SendTimeout $location.TCPTimeout }};
+			{{ $proxy }}_read_timeout {{ or $location.ReadTimeout $location.TCPTimeout }};
+			{{ if $appConfig.HTTP10Compatible }}{{/* Asking the application for HTTP/1.0 responses leads it to delimit them by their
+			     length, which is then passed on to the client, rather than by chunks. */}}chunked_transfer_encoding off;
+			keepalive_timeout 0;
+			{{ if eq $proxy "proxy" }}proxy_http_version 1.0;{{ end }}
+			{{ else if eq $proxy "proxy" }}proxy_http_version 1.1;{{ if $appConfig.Websockets }}
 			proxy_set_header Upgrade $http_upgrade;
-			proxy_set_header Connection $connection_upgrade;
+			proxy_set_header Connection {{ if $appConfig.UpstreamKeepaliveConfig.Enabled }}$connection_upgrade_keepalive{{ else }}$connection_upgrade{{ end }};{{ else if $appConfig.UpstreamKeepaliveConfig.Enabled }}
+			proxy_set_header Connection "";{{ end }}{{ end }}
+			{{ if or $routerConfig.ProxyProtocolHTTP $routerConfig.ProxyProtocolHTTPS }}{{ range $header, $tlv := $appConfig.ProxyProtocolTLVHeaders }}
+			{{ $proxy }}_set_header {{ $header }} $proxy_protocol_tlv_{{ $tlv }};{{ end }}{{ end }}
+			{{ with $tlsHeadersConfig := $appConfig.TLSHeadersConfig }}
+			{{ if $tlsHeadersConfig.Protocol }}{{ $proxy }}_set_header X-SSL-Protocol $ssl_protocol;{{ end }}
+			{{ if $tlsHeadersConfig.Cipher }}{{ $proxy }}_set_header X-SSL-Cipher $ssl_cipher;{{ end }}
+			{{ if $tlsHeadersConfig.SNI }}{{ $proxy }}_set_header X-SSL-SNI $ssl_server_name;{{ end }}
+			{{ if $tlsHeadersConfig.JA3 }}{{ $proxy }}_set_header X-SSL-JA3 $deis_tls_fingerprint;{{ end }}
+			{{ end }}
+			{{ if $routerConfig.GeoIPDatabase }}{{ with $geoIPConfig := $appConfig.GeoIPConfig }}{{ if $geoIPConfig.Headers }}
+			{{ $proxy }}_set_header X-Country-Code $geoip2_country_code;
+			{{ $proxy }}_set_header X-Region $geoip2_region;
+			{{ end }}{{ end }}{{ end }}
+			{{ with $earlyDataConfig := $appConfig.EarlyDataConfig }}{{ if $earlyDataConfig.Enabled }}{{ $proxy }}_set_header Early-Data $ssl_early_data;{{ end }}{{ end }}
 			{{ if $routerConfig.RequestIDs }}
-			proxy_set_header X-Request-Id $request_id;
-			proxy_set_header X-Correlation-Id $correlation_id;
+			{{ $proxy }}_set_header X-Request-Id {{ requestID $routerConfig }};
+			{{ $proxy }}_set_header X-Correlation-Id $correlation_id;
 			{{ end }}
+			{{ if tracingEnabled $routerConfig }}opentracing_{{ if eq $proxy "grpc" }}grpc_{{ end }}propagate_context;{{ end }}
 
-			{{/* If either the app.ssl or the router.ssl is configured with $enforce:="true",
-			     then that overrides the $enforce:="external" setting */}}
-			{{ if or ( eq $enforceSecure "true" ) ( eq $appConfig.SSLConfig.Enforce "true" ) }}
+			{{ $sslEnforcement := sslEnforcement $routerConfig $appConfig }}{{ $sslExemptions := sslExemptions $appConfig }}
+			{{ if and $sslEnforcement $sslExemptions }}{{ if eq $sslEnforcement "true" }}set $deis_ssl_redirect 0;
+			if ($access_scheme !~* "^https|wss$") {
+				set $deis_ssl_redirect 1;
+			}
+			{{ else }}set $deis_ssl_redirect $external_enforce_secure;
+			{{ end }}{{/* Requests for the exempt paths are served over whichever scheme they arrive by. */}}if ($uri ~ "{{ $sslExemptions }}") {
+				set $deis_ssl_redirect 0;
+			}
+			if ($deis_ssl_redirect) {
+				return 301 $uri_scheme://$host$request_uri;
+			}
+			{{ else if eq $sslEnforcement "true" }}
 			if ($access_scheme !~* "^https|wss$") {
 				return 301 $uri_scheme://$host$request_uri;
 			}
-			{{ else if or ( eq $enforceSecure "external" ) (eq $appConfig.SSLConfig.Enforce "external" ) }}
+			{{ else if eq $sslEnforcement "external" }}
 			if ($external_enforce_secure) {
 				return 301 $uri_scheme://$host$request_uri;
 			}
 			{{ end }}
+			{{ with $redirectConfig := $appConfig.RedirectConfig }}{{ if eq $redirectConfig.WWW "force" }}if ($host !~* "^www\.") {
+				return {{ $redirectConfig.Status }} $access_scheme://www.$host$request_uri;
+			}
+			{{ else if eq $redirectConfig.WWW "strip" }}if ($host ~* "^www\.(?<deis_bare_host>.+)$") {
+				return {{ $redirectConfig.Status }} $access_scheme://$deis_bare_host$request_uri;
+			}
+			{{ end }}{{ end }}
 
+			{{ with $corsConfig := $appConfig.CORSConfig }}{{ if $corsConfig.Enabled }}{{ $corsOrigin := corsOrigin $appConfig }}if ($cors_preflight) {
+				add_header Access-Control-Allow-Origin {{ $corsOrigin }} always;
+				add_header Access-Control-Allow-Methods "{{ join ", " $corsConfig.AllowMethods }}" always;
+				add_header Access-Control-Allow-Headers "{{ join ", " $corsConfig.AllowHeaders }}" always;
+				{{ if $corsConfig.AllowCredentials }}add_header Access-Control-Allow-Credentials true always;
+				{{ end }}add_header Access-Control-Max-Age {{ $corsConfig.MaxAge }} always;
+				{{ if ne $corsOrigin "*" }}add_header Vary Origin always;
+				{{ end }}return 204;
+			}
+			add_header Access-Control-Allow-Origin {{ $corsOrigin }} always;
+			{{ if $corsConfig.AllowCredentials }}add_header Access-Control-Allow-Credentials true always;{{ end }}
+			{{ if $corsConfig.ExposeHeaders }}add_header Access-Control-Expose-Headers "{{ join ", " $corsConfig.ExposeHeaders }}" always;{{ end }}
+			{{ if ne $corsOrigin "*" }}add_header Vary Origin always;{{ end }}
+			{{ end }}{{ end }}
 			{{ if $hstsConfig.Enabled }}add_header Strict-Transport-Security $sts always;{{ end }}
+			{{ if and .Upstream (eq $appConfig.Affinity "cookie") }}add_header Set-Cookie $affinity_cookie_{{ $appConfig.AffinityCookie }};{{ end }}
 
-			proxy_pass http://{{$appConfig.ServiceIP}}:80;{{/* end of $appConfig.Available */}}{{ else }}return 503;{{ end }}
-		}
-		{{ if $appConfig.Maintenance }}error_page 503 @maintenance;
-			location @maintenance {
-					root /;
-			    rewrite ^(.*)$ /www/maintenance.html break;
+			{{ if $location.Auth }}{{ with $externalAuthConfig := $appConfig.ExternalAuthConfig }}{{ if $externalAuthConfig.URL }}auth_request /_deis_external_auth;
+			{{ range $i, $header := $externalAuthConfig.ResponseHeaders }}auth_request_set $external_auth_{{ $i }} $upstream_http_{{ $header | replace "-" "_" | lower }};
+			{{ $proxy }}_set_header {{ $header }} $external_auth_{{ $i }};
+			{{ end }}{{ end }}{{ end }}{{ end }}
+
+			{{ if not .Attempt }}{{ with $faultInjection := faultInjection $routerConfig $appConfig }}{{ if $faultInjection.AbortPercent }}if ($fault_abort_{{ $faultInjection.ID }}) {
+				return {{ $faultInjection.AbortStatus }};
 			}
-		{{ end }}
-	}
+			{{ end }}{{ if $faultInjection.DelayPercent }}auth_request /_deis_fault_delay;{{ end }}{{ end }}{{ end }}
 
-	{{end}}{{end}}
-}
+			{{ if or .NextRetry (grpcWebRedirect .) }}{{/* A location's own error pages replace all of those it would otherwise inherit from
+			     its server, so those are repeated here. */}}recursive_error_pages on;
+			{{ with $rateLimitResponseConfig := rateLimitResponse $appConfig }}error_page 460 ={{ $rateLimitResponseConfig.Status }} @rate_limited;{{ end }}
+			{{ with bodySizeResponse $appConfig }}error_page 413 @body_too_large;{{ end }}
+			{{ with $externalAuthConfig := $appConfig.ExternalAuthConfig }}{{ if and $externalAuthConfig.URL $externalAuthConfig.SigninURL }}error_page 401 = {{ $externalAuthConfig.SigninURL }}?rd=$access_scheme://$host$request_uri;{{ end }}{{ end }}
+			{{ range $status, $page := $appConfig.ErrorPages }}{{ if or (not $.NextRetry) (and (ne $status "502") (ne $status "504")) }}error_page {{ $status }} /_deis_errors/{{ $status }}.html;
+			{{ end }}{{ end }}{{ range $status := jsonErrorStatuses $routerConfig $appConfig }}{{ if or (not $.NextRetry) (and (ne $status "502") (ne $status "504")) }}error_page {{ $status }} {{ jsonErrorPage $status }};
+			{{ end }}{{ end }}{{ with .NextRetry }}error_page 502 504 = {{ . }};{{ end }}{{ with grpcWebRedirect . }}{{/* gRPC-Web requests are passed, before any access is checked, to the location from
+			     which they are proxied to the router to be translated. */}}error_page 418 = {{ . }};
+			if ($deis_grpc_web) {
+				return 418;
+			}{{ end }}{{ end }}
 
-{{ if $routerConfig.BuilderConfig }}{{ $builderConfig := $routerConfig.BuilderConfig }}stream {
-	server {
-		listen 2222 {{ if $routerConfig.UseProxyProtocol }}proxy_protocol{{ end }};
-		proxy_connect_timeout {{ $builderConfig.ConnectTimeout }};
-		proxy_timeout {{ $builderConfig.TCPTimeout }};
-		proxy_pass {{$builderConfig.ServiceIP}}:2222;
-	}
-}{{ end }}
+			{{ if locationTLS . }}{{ if sslServerName . }}{{ $proxy }}_ssl_server_name on;
+			{{ end }}{{ with $upstreamTLSConfig := $appConfig.UpstreamTLSConfig }}{{ if $upstreamTLSConfig.Name }}{{ $proxy }}_ssl_name {{ $upstreamTLSConfig.Name }};
+			{{ end }}{{ if $upstreamTLSConfig.Protocols }}{{ $proxy }}_ssl_protocols {{ $upstreamTLSConfig.Protocols }};
+			{{ end }}{{ if $upstreamTLSConfig.Verify }}{{ $proxy }}_ssl_verify on;
+			{{ $proxy }}_ssl_verify_depth {{ $upstreamTLSConfig.VerifyDepth }};
+			{{ $proxy }}_ssl_trusted_certificate /etc/ssl/certs/ca-certificates.crt;
+			{{ end }}{{ end }}{{ end }}
+			{{ range $header := transformHeaders $appConfig }}{{ $proxy }}_set_header {{ $header }} $http_{{ $header | replace "-" "_" | lower }};
+			{{ end }}{{ if and $routerConfig.SnippetsEnabled $appConfig.LocationSnippet }}# Application snippet
+			{{ $appConfig.LocationSnippet }}
+			{{ end }}{{/* Rewriting with break ends the rewrite module's directives, so it must follow them all. */}}
+			{{ range $rewrite := prefixRewrites . }}rewrite {{ $rewrite }} break;
+			{{ end }}{{ if .ExternalOrigin }}{{/* Without variables, the origin's name is resolved once, as the configuration is loaded,
+			     rather than by a resolver as each request is proxied. */}}proxy_pass {{ .ExternalOrigin }};
+			{{- else if .GRPCWeb }}{{/* The gateway to which the router passes translated requests proxies them to the upstream
+			     named here. */}}proxy_set_header X-Deis-Grpc-Web-Upstream {{ if upstreamTLS $appConfig }}grpcs{{ else }}grpc{{ end }}://{{ if .CanaryVariable }}${{ .CanaryVariable }}{{ else if .SplitVariable }}${{ .SplitVariable }}{{ else }}{{ .Backend }}{{ end }};
+			proxy_pass http://127.0.0.1:9096;
+			{{- else }}{{ if eq $proxy "grpc" }}grpc_pass {{ if upstreamTLS $appConfig }}grpcs{{ else }}grpc{{ end }}{{ else }}proxy_pass {{ if upstreamTLS $appConfig }}https{{ else }}http{{ end }}{{ end }}://{{ if .CanaryVariable }}${{ .CanaryVariable }}{{ else if .SplitVariable }}${{ .SplitVariable }}{{ else }}{{ .Backend }}{{ end }};{{ end }}{{/* end of $location.Available */}}{{ else }}return 503;{{ end }}
+{{ end }}
+{{/* Template overrides may define these to add directives to the http block and to each application's servers. */}}
+{{ define "http-extra" }}{{ end }}
+{{ define "server-extra" }}{{ end }}
 `
 )
 
-// WriteCerts writes SSL certs to file from router configuration.
-func WriteCerts(routerConfig *model.RouterConfig, sslPath string) error {
-	// Start by deleting all certs and their corresponding keys. This will ensure certs we no longer
-	// need are deleted. Certs that are still needed will simply be re-written.
-	allCertsGlob, err := filepath.Glob(filepath.Join(sslPath, "*.crt"))
-	if err != nil {
-		return err
+// wafMainRules configures ModSecurity with its recommended settings and the OWASP Core Rule Set, as
+// installed under the router's prefix.  Applications' own rules are loaded after these.
+const wafMainRules = `Include /opt/router/modsecurity/modsecurity.conf
+Include /opt/router/modsecurity/crs/crs-setup.conf
+Include /opt/router/modsecurity/crs/rules/*.conf
+`
+
+// locationContext is the data the "location" template is executed against.  It pairs a single
+// location with the application and router it belongs to.
+type locationContext struct {
+	RouterConfig *model.RouterConfig
+	AppConfig    *model.AppConfig
+	Location     *model.LocationConfig
+	// Upstream is the name of the upstream block listing the location's endpoints, if it has any.
+	Upstream string
+	// Backend is the upstream or address to which the location's requests are proxied.
+	Backend string
+	// CanaryUpstream and CanaryBackend are as above, but for the location's canary, if it has one.
+	CanaryUpstream string
+	CanaryBackend  string
+	// CanaryVariable names the variable through which requests are split between the backend and
+	// the canary's backend.
+	CanaryVariable string
+	// WeightedBackends are the backends among which the location's requests are split by weight, if
+	// it has more than one, through the variable SplitVariable names.
+	WeightedBackends []weightedBackend
+	SplitVariable    string
+	// Attempt numbers the retry of the location's requests that the context renders, or is zero for
+	// the location itself.  NextRetry names the location to which requests are passed to be retried
+	// if they cannot be proxied, if any attempts remain.
+	Attempt   int
+	NextRetry string
+	// GRPCWeb is whether the context renders the location from which the location's gRPC-Web
+	// requests are proxied to the router to be translated, rather than the location itself.
+	GRPCWeb bool
+	// ExternalOrigin is the server outside the cluster to which the location's requests are proxied
+	// in place of its backend, if any.
+	ExternalOrigin string
+}
+
+// retry is the data from which an attempt to retry a location's requests is rendered.  Requests are
+// passed to the location Name to await the backoff, and then to the location ProxyName, from which
+// they are proxied as they would be from the original location.
+type retry struct {
+	Name      string
+	ProxyName string
+	Delay     string
+	Context   locationContext
+}
+
+// weightedBackend is one of the backends among which a location's requests are split by weight.
+type weightedBackend struct {
+	// Upstream and Backend are as for the location itself.
+	Upstream string
+	Backend  string
+	Servers  []string
+	// Percent is the share of the location's requests proxied to the backend, with up to two
+	// decimal places, as split_clients requires.  It is empty for the last backend, which receives
+	// whatever share remains.
+	Percent string
+}
+
+// newLocationContext returns a locationContext for the given location.  A nil location denotes the
+// application's root location, whose settings are derived from the application itself.
+func newLocationContext(routerConfig *model.RouterConfig, appConfig *model.AppConfig, location *model.LocationConfig) locationContext {
+	if location == nil {
+		cacheControlConfig := appConfig.CacheControlConfig
+		if cacheControlConfig == nil {
+			cacheControlConfig = &model.CacheControlConfig{}
+		}
+		location = &model.LocationConfig{
+			Path:           "/",
+			ConnectTimeout: appConfig.ConnectTimeout,
+			TCPTimeout:     appConfig.TCPTimeout,
+			ReadTimeout:    appConfig.ReadTimeout,
+			SendTimeout:    appConfig.SendTimeout,
+			ServiceIP:      appConfig.ServiceIP,
+			ServicePort:    appConfig.ServicePort,
+			Endpoints:      appConfig.Endpoints,
+			Available:      appConfig.Available,
+			Canary:         appConfig.Canary,
+			StripPrefix:    appConfig.StripPrefix,
+			AddPrefix:      appConfig.AddPrefix,
+			ExternalOrigin: appConfig.ExternalOrigin,
+			BackupOrigin:   appConfig.BackupOrigin,
+			CacheControl:   cacheControlConfig.Value,
+			Expires:        cacheControlConfig.Expires,
+			Auth:           true,
+			ProxyCache:     appConfig.ProxyCacheConfig != nil && appConfig.ProxyCacheConfig.Enabled,
+		}
 	}
-	allKeysGlob, err := filepath.Glob(filepath.Join(sslPath, "*.key"))
-	if err != nil {
-		return err
+	context := locationContext{
+		RouterConfig: routerConfig,
+		AppConfig:    appConfig,
+		Location:     location,
+		Backend:      fmt.Sprintf("%s:%d", location.ServiceIP, location.ServicePort),
 	}
-	for _, cert := range allCertsGlob {
-		if err := os.Remove(cert); err != nil {
-			return err
-		}
+	id := locationID(appConfig, location.Path)
+	if len(location.Endpoints) > 0 || location.BackupOrigin != "" {
+		context.Upstream = upstreamName(appConfig, id)
+		context.Backend = context.Upstream
 	}
-	for _, key := range allKeysGlob {
-		if err := os.Remove(key); err != nil {
-			return err
+	if canary := location.Canary; canary != nil {
+		context.CanaryBackend = fmt.Sprintf("%s:%d", canary.ServiceIP, canary.ServicePort)
+		if len(canary.Endpoints) > 0 {
+			context.CanaryUpstream = upstreamName(appConfig, id+"-canary")
+			context.CanaryBackend = context.CanaryUpstream
 		}
+		context.CanaryVariable = "canary_" + id
 	}
-	if routerConfig.PlatformCertificate != nil {
-		err = writeCert("platform", routerConfig.PlatformCertificate, sslPath)
-		if err != nil {
-			return err
-		}
+	if retryAttempts(appConfig) > 0 {
+		context.NextRetry = retryName(id, 1)
 	}
-	for _, appConfig := range routerConfig.AppConfigs {
-		for domain, certificate := range appConfig.Certificates {
-			if certificate != nil {
-				err = writeCert(domain, certificate, sslPath)
-				if err != nil {
-					return err
-				}
-			}
-		}
+	// gRPC is never proxied to an external origin, whose URL names an HTTP server.
+	if location.ExternalOrigin != "" && proxyModule(appConfig) == "proxy" {
+		context.ExternalOrigin = location.ExternalOrigin
 	}
-
-	certPath := filepath.Join(sslPath, "client.ca.crt")
-	err = ioutil.WriteFile(certPath, []byte(strings.Join(routerConfig.ClientCertificates, "\n")), 0644)
-	if err != nil {
-		return err
+	if len(location.WeightedBackends) > 0 {
+		context.WeightedBackends = newWeightedBackends(appConfig, id, location.WeightedBackends)
+		if len(context.WeightedBackends) == 1 {
+			context.Backend = context.WeightedBackends[0].Backend
+		} else {
+			context.SplitVariable = "split_" + id
+		}
 	}
-	return nil
+	return context
 }
 
-func writeCert(context string, certificate *model.Certificate, sslPath string) error {
-	certPath := filepath.Join(sslPath, fmt.Sprintf("%s.crt", context))
-	keyPath := filepath.Join(sslPath, fmt.Sprintf("%s.key", context))
-	err := ioutil.WriteFile(certPath, []byte(certificate.Cert), 0644)
-	if err != nil {
-		return err
+// newWeightedBackends returns a weightedBackend for each of the backends among which the requests of
+// the location with the specified ID are split.  Shares are rounded down to a hundredth of a
+// percent, and the last backend receives whatever share remains.  Backends whose share rounds down
+// to nothing are left out, since split_clients does not accept a share of 0%.
+func newWeightedBackends(appConfig *model.AppConfig, id string, backends []*model.WeightedBackend) []weightedBackend {
+	total := 0
+	for _, backend := range backends {
+		total += backend.Weight
 	}
-	return ioutil.WriteFile(keyPath, []byte(certificate.Key), 0600)
-}
-
-// WriteDHParam writes router DHParam to file from router configuration.
-func WriteDHParam(routerConfig *model.RouterConfig, sslPath string) error {
-	dhParamPath := filepath.Join(sslPath, "dhparam.pem")
-	if routerConfig.SSLConfig.DHParam == "" {
-		err := os.RemoveAll(dhParamPath)
-		if err != nil {
-			return err
+	weightedBackends := make([]weightedBackend, 0, len(backends))
+	for i, backend := range backends {
+		weightedBackend := weightedBackend{
+			Backend: fmt.Sprintf("%s:%d", backend.ServiceIP, backend.ServicePort),
+			Servers: backend.Endpoints,
 		}
-	} else {
-		err := ioutil.WriteFile(dhParamPath, []byte(routerConfig.SSLConfig.DHParam), 0644)
-		if err != nil {
-			return err
+		if len(backend.Endpoints) > 0 {
+			weightedBackend.Upstream = upstreamName(appConfig, id+"-"+backend.Service)
+			weightedBackend.Backend = weightedBackend.Upstream
+		}
+		if i < len(backends)-1 {
+			hundredths := backend.Weight * 10000 / total
+			if hundredths == 0 {
+				continue
+			}
+			weightedBackend.Percent = fmt.Sprintf("%d.%02d", hundredths/100, hundredths%100)
 		}
+		weightedBackends = append(weightedBackends, weightedBackend)
 	}
-	return nil
+	return weightedBackends
 }
 
-// WriteConfig dynamically produces valid nginx configuration by combining a Router configuration
-// object with a data-driven template.
-func WriteConfig(routerConfig *model.RouterConfig, filePath string) error {
-	tmpl, err := template.New("nginx").Funcs(sprig.TxtFuncMap()).Parse(confTemplate)
-	if err != nil {
-		return err
+// syslogTarget returns the destination, in nginx's syntax, of logs sent to the syslog server of the
+// provided application, or of the router if the application is nil or does not name its own.  Logs
+// are tagged with the name of the application, or "deis_router" for the router's own.  If there is
+// no syslog server, an empty string is returned.
+func syslogTarget(routerConfig *model.RouterConfig, appConfig *model.AppConfig) string {
+	if routerConfig.LogConfig == nil {
+		return ""
+	}
+	server := routerConfig.LogConfig.Syslog
+	tag := "deis_router"
+	if appConfig != nil {
+		if appConfig.Syslog != "" {
+			server = appConfig.Syslog
+		}
+		tag = syslogTag(appConfig.Name)
+	}
+	if server == "" {
+		return ""
 	}
-	file, err := os.Create(filePath)
+	target := fmt.Sprintf("syslog:server=%s,tag=%s", strings.TrimPrefix(server, "udp://"), tag)
+	if routerConfig.LogConfig.SyslogFacility != "" {
+		target += ",facility=" + routerConfig.LogConfig.SyslogFacility
+	}
+	return target
+}
+
+// appAccessLogs returns the access logs, with their formats, to which the provided application's
+// requests are logged, if they differ from the router's.  Logs set for a server replace all of those
+// it would otherwise inherit, so the router's own log is repeated among them.
+func appAccessLogs(routerConfig *model.RouterConfig, appConfig *model.AppConfig) []string {
+	syslog := syslogTarget(routerConfig, appConfig)
+	debugBody := appConfig.DebugBodyConfig != nil && appConfig.DebugBodyConfig.Enabled
+	if syslog == "" && !debugBody {
+		return nil
+	}
+	accessLogs := []string{"/tmp/logpipe upstreaminfo"}
+	if debugBody {
+		accessLogs = append(accessLogs, "/tmp/logpipe debugbody if=$debug_body_enabled")
+	}
+	if syslog != "" {
+		accessLogs = append(accessLogs, syslog+" upstreaminfo")
+	}
+	if routerConfig.LatencyHistograms {
+		accessLogs = append(accessLogs, latencyLog())
+	}
+	return accessLogs
+}
+
+// latencyLog returns the access log, with its format, to which the timing of each request is logged
+// for the router to aggregate.
+func latencyLog() string {
+	return fmt.Sprintf("syslog:server=%s,tag=%s,nohostname deis_latency", metrics.LatencyAddr, metrics.LatencyTag)
+}
+
+// unknownSNILog returns the access log, with its format, to which requests for server
+// names that no application claims are logged for the router to count.
+func unknownSNILog() string {
+	return fmt.Sprintf("syslog:server=%s,tag=%s,nohostname deis_unknown_sni", metrics.TLSAddr, metrics.TLSUnknownSNITag)
+}
+
+// tlsErrorLog returns the error log, with its level, to which failed TLS handshakes are logged for
+// the router to count.  nginx logs most such failures at the info level, since they are usually the
+// client's doing.
+func tlsErrorLog() string {
+	return fmt.Sprintf("syslog:server=%s,tag=%s,nohostname info", metrics.TLSAddr, metrics.TLSHandshakeTag)
+}
+
+// sslSessionCache returns the cache in which nginx stores SSL sessions, if any.  A cache set
+// explicitly takes precedence over one that is only sized.
+func sslSessionCache(sslConfig *model.SSLConfig) string {
+	if sslConfig.SessionCache != "" {
+		return sslConfig.SessionCache
+	}
+	if sslConfig.SessionCacheSize != "" {
+		return "shared:SSL:" + sslConfig.SessionCacheSize
+	}
+	return ""
+}
+
+// refusalRule maps the name of an application and some property of a request, as matched by Key,
+// to whether the request is refused.
+type refusalRule struct {
+	Key     string
+	Refused bool
+}
+
+// geoIPRules returns the rules by which requests are refused by the client's country, for every
+// application that filters them.  nginx prefers exact matches to patterns, so each application's
+// allowed and blocked countries take precedence over the pattern that refuses every other country
+// when only some are allowed.  A country both allowed and blocked is blocked.
+func geoIPRules(routerConfig *model.RouterConfig) []refusalRule {
+	rules := []refusalRule{}
+	for _, appConfig := range routerConfig.AppConfigs {
+		geoIPConfig := appConfig.GeoIPConfig
+		if geoIPConfig == nil || !geoIPConfig.Filtered() {
+			continue
+		}
+		blocked := map[string]bool{}
+		for _, country := range geoIPConfig.BlockCountries {
+			blocked[country] = true
+		}
+		seen := map[string]bool{}
+		for _, country := range append(append([]string{}, geoIPConfig.AllowCountries...), geoIPConfig.BlockCountries...) {
+			if seen[country] {
+				continue
+			}
+			seen[country] = true
+			rules = append(rules, refusalRule{Key: appConfig.Name + ":" + country, Refused: blocked[country]})
+		}
+		if len(geoIPConfig.AllowCountries) > 0 {
+			rules = append(rules, refusalRule{Key: "~^" + regexp.QuoteMeta(appConfig.Name+":"), Refused: true})
+		}
+	}
+	return rules
+}
+
+// earlyDataRules returns the rules by which requests sent in early data are refused by their
+// methods, for every application that accepts early data.  Requests whose methods are listed match
+// exactly, in preference to the pattern that refuses every other request sent in early data.
+func earlyDataRules(routerConfig *model.RouterConfig) []refusalRule {
+	rules := []refusalRule{}
+	for _, appConfig := range routerConfig.AppConfigs {
+		earlyDataConfig := appConfig.EarlyDataConfig
+		if earlyDataConfig == nil || !earlyDataConfig.Enabled {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, method := range earlyDataConfig.Methods {
+			if seen[method] {
+				continue
+			}
+			seen[method] = true
+			rules = append(rules, refusalRule{Key: appConfig.Name + ":1:" + method})
+		}
+		rules = append(rules, refusalRule{Key: "~^" + regexp.QuoteMeta(appConfig.Name+":1:"), Refused: true})
+	}
+	return rules
+}
+
+// echEnabled returns whether the SSL port accepts Encrypted Client Hello, which requires that it be
+// enabled and that there be keys with which to decrypt it.
+func echEnabled(routerConfig *model.RouterConfig) bool {
+	return routerConfig.SSLConfig != nil && routerConfig.SSLConfig.ECH && len(routerConfig.SSLConfig.ECHKeys) > 0
+}
+
+// certFileName returns the base name of the files holding the certificate with which the provided
+// domain of the provided application is served.  Domains that share a certificate share its files.
+func certFileName(appConfig *model.AppConfig, domain string) (string, error) {
+	if certificate := appConfig.Certificates[domain]; certificate != nil && certificate.Name != "" {
+		return templatefuncs.FileName(certificate.Name)
+	}
+	return templatefuncs.FileName(domain)
+}
+
+// trustedCA returns the base name of the files holding the certificate with which the provided
+// domain of the provided application is served, if nginx should trust the CA conveyed with it to
+// verify the OCSP responses it staples, and the empty string otherwise.  nginx also trusts such CAs
+// to verify client certificates, so none is trusted for domains whose clients are verified.
+func trustedCA(routerConfig *model.RouterConfig, appConfig *model.AppConfig, domain string) (string, error) {
+	sslConfig := routerConfig.SSLConfig
+	if sslConfig == nil || !sslConfig.OCSPStapling || !sslConfig.OCSPStaplingVerify || len(routerConfig.ClientCertificates) > 0 || appConfig.ClientVerifications[domain] != nil {
+		return "", nil
+	}
+	if certificate := appConfig.Certificates[domain]; certificate == nil || certificate.CA == "" {
+		return "", nil
+	}
+	return certFileName(appConfig, domain)
+}
+
+// listenersFor returns the router's additional SSL listeners on which the provided domain, as an
+// application lists it, is served.
+func listenersFor(routerConfig *model.RouterConfig, domain string) []*model.ListenerConfig {
+	listeners := []*model.ListenerConfig{}
+	for _, listener := range routerConfig.ListenerConfigs {
+		if listener.Serves(domain) {
+			listeners = append(listeners, listener)
+		}
+	}
+	return listeners
+}
+
+// sniLimited returns whether the connections each server name may have open on the SSL port are
+// limited, in which case the stream module accepts them and relays them to the http servers.
+func sniLimited(routerConfig *model.RouterConfig) bool {
+	return routerConfig.SSLConfig != nil && routerConfig.SSLConfig.SNIConnectionLimit > 0
+}
+
+// realIPHeader returns the header from which the address of a client is taken when a request
+// arrives from a trusted proxy.  Unless the router sets it, this is the PROXY protocol if any http
+// listener receives it, since a load balancer that speaks it does not also set X-Forwarded-For.
+// Servers whose listeners disagree about the PROXY protocol are split, and set their own.
+func realIPHeader(routerConfig *model.RouterConfig) string {
+	if routerConfig.RealIPHeader != "" {
+		return routerConfig.RealIPHeader
+	}
+	if routerConfig.ProxyProtocolHTTP() || routerConfig.ProxyProtocolHTTPS() || sniLimited(routerConfig) {
+		return "proxy_protocol"
+	}
+	return "X-Forwarded-For"
+}
+
+// serverListeners are the router's http listeners on which one of the server blocks serving a domain
+// listens: the plain HTTP port, the SSL port, and the additional SSL listeners that serve the
+// domain.  RealIPHeader, if set, is the header from which the block's clients' addresses are taken.
+type serverListeners struct {
+	HTTP         bool
+	SSL          bool
+	Extra        bool
+	RealIPHeader string
+}
+
+// domainServers returns the server blocks in which the provided domain of the provided application
+// is served.  nginx takes the addresses of all of a server's clients from the same header, so unless
+// the router sets that header, listeners that expect the PROXY protocol and those that do not are
+// served by server blocks of their own, which take their clients' addresses from the PROXY protocol
+// and from X-Forwarded-For respectively.
+func domainServers(routerConfig *model.RouterConfig, appConfig *model.AppConfig, domain string) []serverListeners {
+	_, certified := appConfig.Certificates[domain]
+	return splitServerListeners(routerConfig, certified, certified && len(listenersFor(routerConfig, domain)) > 0)
+}
+
+// defaultServers returns the server blocks in which requests for unmapped hostnames are served, split
+// as the servers of applications' domains are.  Additional SSL listeners have default servers of
+// their own.
+func defaultServers(routerConfig *model.RouterConfig) []serverListeners {
+	return splitServerListeners(routerConfig, true, false)
+}
+
+// splitServerListeners returns server blocks for the plain HTTP port and, if specified, the SSL port
+// and the additional SSL listeners: one, if all of them agree on the PROXY protocol or the router
+// sets the header clients' addresses are taken from, or, otherwise, one for those that expect the
+// PROXY protocol and another for those that do not, the plain HTTP port's first.
+func splitServerListeners(routerConfig *model.RouterConfig, ssl bool, extra bool) []serverListeners {
+	all := serverListeners{HTTP: true, SSL: ssl, Extra: extra}
+	httpProxied := routerConfig.ProxyProtocolHTTP()
+	sslProxied := routerConfig.ProxyProtocolHTTPS() || sniLimited(routerConfig)
+	extraProxied := routerConfig.ProxyProtocolHTTPS()
+	if routerConfig.RealIPHeader != "" || ((!ssl || sslProxied == httpProxied) && (!extra || extraProxied == httpProxied)) {
+		return []serverListeners{all}
+	}
+	first := serverListeners{HTTP: true, SSL: ssl && sslProxied == httpProxied, Extra: extra && extraProxied == httpProxied}
+	second := serverListeners{SSL: ssl && !first.SSL, Extra: extra && !first.Extra}
+	first.RealIPHeader, second.RealIPHeader = "X-Forwarded-For", "proxy_protocol"
+	if httpProxied {
+		first.RealIPHeader, second.RealIPHeader = "proxy_protocol", "X-Forwarded-For"
+	}
+	return []serverListeners{first, second}
+}
+
+// sslListener returns the address on which the http servers accept SSL connections: the SSL port
+// itself, or, if connections are limited by server name, the socket they are relayed to.
+func sslListener(routerConfig *model.RouterConfig) string {
+	if sniLimited(routerConfig) {
+		return sslSocket
+	}
+	return "6443"
+}
+
+// syslogTag returns the provided application name as a syslog tag, which nginx limits to 32 letters,
+// digits, and underscores.
+func syslogTag(name string) string {
+	tag := nonSyslogTagCharacters.ReplaceAllString(name, "_")
+	if len(tag) > 32 {
+		tag = tag[:32]
+	}
+	return tag
+}
+
+// retryAttempts returns the number of times the provided application's requests are retried if
+// they cannot be proxied.  gRPC requests never are.
+func retryAttempts(appConfig *model.AppConfig) int {
+	if appConfig.RetryConfig == nil || proxyModule(appConfig) != "proxy" {
+		return 0
+	}
+	return appConfig.RetryConfig.Attempts
+}
+
+// retryName returns the name of the location in which the specified attempt to retry the requests
+// of the location with the specified ID awaits its backoff.
+func retryName(id string, attempt int) string {
+	return fmt.Sprintf("@deis_retry_%s_%d", id, attempt)
+}
+
+// newRetries returns a retry for each attempt to retry the requests of the provided location.  The
+// backoff doubles from each attempt to the next, up to the application's maximum.
+func newRetries(context locationContext) []retry {
+	appConfig := context.AppConfig
+	attempts := retryAttempts(appConfig)
+	if attempts == 0 || context.Attempt > 0 {
+		return nil
+	}
+	backoff, err := modeler.ParseDuration(appConfig.RetryConfig.Backoff)
+	if err != nil {
+		return nil
+	}
+	maxBackoff, err := modeler.ParseDuration(appConfig.RetryConfig.MaxBackoff)
+	if err != nil {
+		return nil
+	}
+	id := locationID(appConfig, context.Location.Path)
+	retries := make([]retry, 0, attempts)
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		retryContext := context
+		retryContext.Attempt = attempt
+		retryContext.NextRetry = ""
+		if attempt < attempts {
+			retryContext.NextRetry = retryName(id, attempt+1)
+		}
+		retries = append(retries, retry{
+			Name:      retryName(id, attempt),
+			ProxyName: retryName(id, attempt) + "_proxy",
+			Delay:     fmt.Sprintf("%dms", backoff/time.Millisecond),
+			Context:   retryContext,
+		})
+		backoff *= 2
+	}
+	return retries
+}
+
+// newHealthcheckLocationContext returns the locationContext of the location that would otherwise
+// serve requests for the provided health check path: that with the longest matching prefix, or
+// else the application's root location.
+func newHealthcheckLocationContext(routerConfig *model.RouterConfig, appConfig *model.AppConfig, path string) locationContext {
+	var match *model.LocationConfig
+	for _, location := range appConfig.Locations {
+		if strings.HasPrefix(path, location.Path) && (match == nil || len(location.Path) > len(match.Path)) {
+			match = location
+		}
+	}
+	return newLocationContext(routerConfig, appConfig, match)
+}
+
+// newLocationContexts returns a locationContext for every location, including each application's
+// root location, in the router's configuration.
+func newLocationContexts(routerConfig *model.RouterConfig) []locationContext {
+	contexts := []locationContext{}
+	for _, appConfig := range routerConfig.AppConfigs {
+		contexts = append(contexts, newLocationContext(routerConfig, appConfig, nil))
+		for _, location := range appConfig.Locations {
+			contexts = append(contexts, newLocationContext(routerConfig, appConfig, location))
+		}
+	}
+	return contexts
+}
+
+// upstream is the data an upstream block is rendered from.
+type upstream struct {
+	Name      string
+	Algorithm string
+	// AffinityCookie, if set, names the cookie by whose value requests are consistently hashed to
+	// servers, overriding the algorithm.
+	AffinityCookie string
+	Servers        []string
+	// Parameters are appended to each server, e.g. to tune how failures are detected.
+	Parameters string
+	// Backup is a server to which requests are passed only while none of the others is available, or
+	// the only server if there are no others.
+	Backup string
+	// Keepalive, if set, determines how many idle connections to the servers are kept open for reuse.
+	Keepalive *model.UpstreamKeepaliveConfig
+}
+
+// newUpstreams returns an upstream for every location, and every location's canary, whose
+// endpoints are known.
+func newUpstreams(routerConfig *model.RouterConfig) []upstream {
+	upstreams := []upstream{}
+	for _, context := range newLocationContexts(routerConfig) {
+		appConfig := context.AppConfig
+		algorithm := appConfig.LoadBalancingAlgorithm
+		if algorithm == "" {
+			algorithm = "round_robin"
+		}
+		affinityCookie := ""
+		if appConfig.Affinity == "cookie" {
+			affinityCookie = appConfig.AffinityCookie
+		}
+		parameters := serverParameters(appConfig)
+		var keepalive *model.UpstreamKeepaliveConfig
+		if appConfig.UpstreamKeepaliveConfig.Enabled() {
+			keepalive = appConfig.UpstreamKeepaliveConfig
+		}
+		if context.Upstream != "" {
+			backup := context.Location.BackupOrigin
+			if len(context.Location.Endpoints) > 0 && (affinityCookie != "" || algorithm == "ip_hash") {
+				// nginx does not permit backup servers among those to which requests are hashed.
+				backup = ""
+			}
+			upstreams = append(upstreams, upstream{
+				Name:           context.Upstream,
+				Algorithm:      algorithm,
+				AffinityCookie: affinityCookie,
+				Servers:        context.Location.Endpoints,
+				Parameters:     parameters,
+				Backup:         backup,
+				Keepalive:      keepalive,
+			})
+		}
+		if context.CanaryUpstream != "" {
+			upstreams = append(upstreams, upstream{
+				Name:           context.CanaryUpstream,
+				Algorithm:      algorithm,
+				AffinityCookie: affinityCookie,
+				Servers:        context.Location.Canary.Endpoints,
+				Parameters:     parameters,
+				Keepalive:      keepalive,
+			})
+		}
+		for _, weightedBackend := range context.WeightedBackends {
+			if weightedBackend.Upstream != "" {
+				upstreams = append(upstreams, upstream{
+					Name:           weightedBackend.Upstream,
+					Algorithm:      algorithm,
+					AffinityCookie: affinityCookie,
+					Servers:        weightedBackend.Servers,
+					Parameters:     parameters,
+					Keepalive:      keepalive,
+				})
+			}
+		}
+	}
+	return upstreams
+}
+
+// serverParameters returns the parameters, each preceded by a space, with which the provided
+// application's upstream servers are declared.  nginx's own defaults apply to any that are unset.
+func serverParameters(appConfig *model.AppConfig) string {
+	healthCheckConfig := appConfig.HealthCheckConfig
+	if healthCheckConfig == nil {
+		return ""
+	}
+	parameters := ""
+	if healthCheckConfig.MaxFails != "" {
+		parameters += " max_fails=" + healthCheckConfig.MaxFails
+	}
+	if healthCheckConfig.FailTimeout != "" {
+		parameters += " fail_timeout=" + healthCheckConfig.FailTimeout
+	}
+	return parameters
+}
+
+// cacheControlMap is the data a map, which derives the caching headers of a location's responses
+// from those the application sent, is rendered from.  Requests whose Source matches Match get Value,
+// and all others Default.
+type cacheControlMap struct {
+	Source   string
+	Variable string
+	Match    string
+	Value    string
+	Default  string
+}
+
+// newCacheControlMaps returns a cacheControlMap for every distinct caching header that locations
+// set, or set unless the application did.
+func newCacheControlMaps(routerConfig *model.RouterConfig) []cacheControlMap {
+	maps := []cacheControlMap{}
+	variables := map[string]bool{}
+	for _, context := range newLocationContexts(routerConfig) {
+		location := context.Location
+		var m cacheControlMap
+		switch {
+		case location.CacheControl != "" && cachingForced(context.AppConfig):
+			// Only successful and redirected responses have their header replaced, as add_header
+			// would by itself, but the application's header must be hidden from all of them.
+			m = cacheControlMap{Source: "$status", Match: "~^[23]", Value: fmt.Sprintf(`"%s"`, location.CacheControl), Default: "$upstream_http_cache_control"}
+		case location.CacheControl != "":
+			m = cacheControlMap{Source: "$upstream_http_cache_control", Match: `""`, Value: fmt.Sprintf(`"%s"`, location.CacheControl), Default: `""`}
+		case location.Expires != "" && !cachingForced(context.AppConfig):
+			m = cacheControlMap{Source: `"$upstream_http_expires$upstream_http_cache_control"`, Match: `""`, Value: location.Expires, Default: "off"}
+		default:
+			continue
+		}
+		m.Variable = cacheControlVariable(context.AppConfig, location)
+		if !variables[m.Variable] {
+			variables[m.Variable] = true
+			maps = append(maps, m)
+		}
+	}
+	return maps
+}
+
+// cachingForced returns whether the application's caching headers replace those it sends.
+func cachingForced(appConfig *model.AppConfig) bool {
+	return appConfig.CacheControlConfig != nil && appConfig.CacheControlConfig.Force
+}
+
+// cacheControlVariable returns the name of the variable that holds the location's Cache-Control
+// header, or, if it sets none, the duration its responses expire after.  Locations that set the
+// same header in the same way share a variable.
+func cacheControlVariable(appConfig *model.AppConfig, location *model.LocationConfig) string {
+	hash := fnv.New32a()
+	if location.CacheControl == "" {
+		hash.Write([]byte(location.Expires))
+		return fmt.Sprintf("deis_expires_%08x", hash.Sum32())
+	}
+	hash.Write([]byte(location.CacheControl))
+	if cachingForced(appConfig) {
+		return fmt.Sprintf("deis_cache_control_forced_%08x", hash.Sum32())
+	}
+	return fmt.Sprintf("deis_cache_control_%08x", hash.Sum32())
+}
+
+// cacheControlValue returns the value to which the Cache-Control header of the location's
+// responses is set, if any.
+func cacheControlValue(appConfig *model.AppConfig, location *model.LocationConfig) string {
+	if location.CacheControl == "" {
+		return ""
+	}
+	return "$" + cacheControlVariable(appConfig, location)
+}
+
+// expires returns the time after which the location's responses expire, if it sets no Cache-Control
+// header of its own, since nginx derives a Cache-Control header from it as well.
+func expires(appConfig *model.AppConfig, location *model.LocationConfig) string {
+	if location.CacheControl != "" || location.Expires == "" {
+		return ""
+	}
+	if cachingForced(appConfig) {
+		return location.Expires
+	}
+	return "$" + cacheControlVariable(appConfig, location)
+}
+
+// canarySplit is the data a split_clients block, which diverts a share of a location's requests to
+// its canary, is rendered from.
+type canarySplit struct {
+	Variable      string
+	Weight        int
+	Backend       string
+	CanaryBackend string
+}
+
+// newCanarySplits returns a canarySplit for every location that has a canary.
+func newCanarySplits(routerConfig *model.RouterConfig) []canarySplit {
+	splits := []canarySplit{}
+	for _, context := range newLocationContexts(routerConfig) {
+		if context.CanaryVariable != "" {
+			splits = append(splits, canarySplit{
+				Variable:      context.CanaryVariable,
+				Weight:        context.AppConfig.CanaryWeight,
+				Backend:       context.Backend,
+				CanaryBackend: context.CanaryBackend,
+			})
+		}
+	}
+	return splits
+}
+
+// rateLimit is the data the shared memory zones in which an application's rate and connection
+// limits are tracked are rendered from.
+type rateLimit struct {
+	Zone string
+	// Key is the variable by which requests are told apart in the zones.
+	Key         string
+	ZoneSize    string
+	Rate        string
+	Connections int
+	// ExemptPaths, if any, are paths whose requests are not limited.  Key is then mapped from them to
+	// an empty value, which nginx does not account, and from every other path to ClientKey.
+	ExemptPaths []string
+	ClientKey   string
+}
+
+// newRateLimits returns a rateLimit for every application that limits either the rate at which
+// its clients may make requests or the number of connections they may have open.
+func newRateLimits(routerConfig *model.RouterConfig) []rateLimit {
+	rateLimits := []rateLimit{}
+	for _, appConfig := range routerConfig.AppConfigs {
+		rateLimitConfig := appConfig.RateLimitConfig
+		if rateLimitConfig == nil || (rateLimitConfig.Rate == "" && rateLimitConfig.Connections == 0) {
+			continue
+		}
+		r := rateLimit{
+			Zone:        rateLimitZone(appConfig),
+			Key:         rateLimitKey(rateLimitConfig.Key),
+			ZoneSize:    rateLimitConfig.ZoneSize,
+			Rate:        rateLimitConfig.Rate,
+			Connections: rateLimitConfig.Connections,
+		}
+		if len(appConfig.HealthcheckPaths) > 0 {
+			r.ExemptPaths = appConfig.HealthcheckPaths
+			r.ClientKey = r.Key
+			r.Key = "$rate_limit_key_" + locationID(appConfig, "")
+		}
+		rateLimits = append(rateLimits, r)
+	}
+	return rateLimits
+}
+
+// faultInjection is the data from which the injection of faults into an application's requests is
+// rendered.
+type faultInjection struct {
+	// ID distinguishes the variables through which the requests into which faults are injected are
+	// chosen from those of other applications.
+	ID           string
+	Delay        string
+	DelayPercent int
+	AbortPercent int
+	AbortStatus  int
+}
+
+// newFaultInjections returns a faultInjection for every application into whose requests faults are
+// injected.
+func newFaultInjections(routerConfig *model.RouterConfig) []*faultInjection {
+	faultInjections := []*faultInjection{}
+	for _, appConfig := range routerConfig.AppConfigs {
+		if f := newFaultInjection(routerConfig, appConfig); f != nil {
+			faultInjections = append(faultInjections, f)
+		}
+	}
+	return faultInjections
+}
+
+// newFaultInjection returns the faults to inject into the provided application's requests, or nil
+// if there are none, or if the router does not permit faults to be injected.  Delays are injected
+// by way of an auth_request subrequest, so they cannot be injected into requests that are already
+// authenticated with one.
+func newFaultInjection(routerConfig *model.RouterConfig, appConfig *model.AppConfig) *faultInjection {
+	faultInjectionConfig := appConfig.FaultInjectionConfig
+	if !routerConfig.FaultInjectionEnabled || faultInjectionConfig == nil {
+		return nil
+	}
+	f := &faultInjection{
+		ID:           locationID(appConfig, ""),
+		Delay:        faultInjectionConfig.Delay,
+		DelayPercent: faultInjectionConfig.DelayPercent,
+		AbortPercent: faultInjectionConfig.AbortPercent,
+		AbortStatus:  faultInjectionConfig.AbortStatus,
+	}
+	if appConfig.ExternalAuthConfig != nil && appConfig.ExternalAuthConfig.URL != "" {
+		f.DelayPercent = 0
+	}
+	if f.DelayPercent == 0 && f.AbortPercent == 0 {
+		return nil
+	}
+	return f
+}
+
+// rateLimitZone returns the prefix of the names of the provided application's rate and connection
+// limiting zones.  Like an upstream's name, it is unique even among applications that share a
+// name.
+func rateLimitZone(appConfig *model.AppConfig) string {
+	return "limit_" + upstreamName(appConfig, locationID(appConfig, ""))
+}
+
+// rateLimitResponse returns the provided application's options for the response to requests rejected
+// by rate or connection limiting, or nil if it sets no limit by which any could be rejected.
+func rateLimitResponse(appConfig *model.AppConfig) *model.RateLimitResponseConfig {
+	rateLimitConfig := appConfig.RateLimitConfig
+	if rateLimitConfig == nil || (rateLimitConfig.Rate == "" && rateLimitConfig.Connections == 0) {
+		return nil
+	}
+	return appConfig.RateLimitResponseConfig
+}
+
+// rateLimitKey returns the nginx variable by which clients are told apart for the purposes of rate
+// and connection limiting.  A key of "ip" selects the client's address, and "header:<name>" the
+// value of the named request header.
+func rateLimitKey(key string) string {
+	if key == "ip" {
+		return "$binary_remote_addr"
+	}
+	return requestVariable(key)
+}
+
+// requestVariable returns the nginx variable holding the specified part of a request:
+// "header:<name>" for a request header, "cookie:<name>" for a cookie, or "arg:<name>" for a query
+// parameter.
+func requestVariable(ref string) string {
+	parts := strings.SplitN(ref, ":", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+	name := strings.ToLower(strings.Replace(parts[1], "-", "_", -1))
+	switch parts[0] {
+	case "header":
+		return "$http_" + name
+	case "cookie":
+		return "$cookie_" + name
+	case "arg":
+		return "$arg_" + name
+	}
+	return ""
+}
+
+// accessLogVariables maps the name of each field that may be included in the JSON access log to
+// the variable whose value it holds.
+var accessLogVariables = map[string]string{
+	"time":                   "$time_iso8601",
+	"request_id":             "$request_id",
+	"app":                    "$app_name",
+	"remote_addr":            "$remote_addr",
+	"remote_user":            "$remote_user",
+	"status":                 "$status",
+	"method":                 "$request_method",
+	"uri":                    "$request_uri",
+	"protocol":               "$server_protocol",
+	"request":                "$request",
+	"bytes_sent":             "$bytes_sent",
+	"referer":                "$http_referer",
+	"user_agent":             "$http_user_agent",
+	"server_name":            "$server_name",
+	"host":                   "$http_host",
+	"upstream_addr":          "$upstream_addr",
+	"upstream_status":        "$upstream_status",
+	"upstream_response_time": "$upstream_response_time",
+	"request_time":           "$request_time",
+}
+
+// jsonLogFormat returns the format of a JSON access log with the configured fields, in the order
+// in which they were configured, or an empty string if access is not to be logged as JSON.  Every
+// value is logged as a string, since some, such as $upstream_status, may list several values.
+func jsonLogFormat(routerConfig *model.RouterConfig) string {
+	logConfig := routerConfig.LogConfig
+	if logConfig == nil || logConfig.Format != "json" {
+		return ""
+	}
+	members := []string{}
+	seen := map[string]bool{}
+	for _, field := range logConfig.Fields {
+		variable, ok := accessLogVariables[field]
+		if !ok || seen[field] {
+			continue
+		}
+		seen[field] = true
+		if field == "request_id" {
+			variable = requestID(routerConfig)
+		}
+		members = append(members, fmt.Sprintf("\"%s\":\"%s\"", field, variable))
+	}
+	return "{" + strings.Join(members, ",") + "}"
+}
+
+// requestID returns the variable holding the ID of each request: the ID with which the request
+// arrived, if IDs are propagated and it has a valid one, or else the ID nginx generated for it.
+func requestID(routerConfig *model.RouterConfig) string {
+	if routerConfig.PropagateRequestIDs {
+		return "$deis_request_id"
+	}
+	return "$request_id"
+}
+
+// tracingEnabled returns a bool indicating whether spans are reported for applications' requests.
+func tracingEnabled(routerConfig *model.RouterConfig) bool {
+	return routerConfig.TracingConfig != nil && routerConfig.TracingConfig.Enabled && routerConfig.TracingConfig.Collector != ""
+}
+
+// appWhitelist returns the addresses whitelisted for the provided application: those it lists, and
+// the ranges currently known of the IP range sources it names.
+func appWhitelist(routerConfig *model.RouterConfig, appConfig *model.AppConfig) []string {
+	return append(append([]string{}, appConfig.Whitelist...), routerConfig.IPRanges(appConfig.WhitelistSources)...)
+}
+
+// realIPCIDRs returns the addresses trusted to report clients' addresses: the router's trusted
+// proxies, and the ranges currently known of the IP range sources trusted to.
+func realIPCIDRs(routerConfig *model.RouterConfig) []string {
+	trusted := []string{}
+	for _, source := range routerConfig.IPRangeSources {
+		if source.TrustProxies {
+			trusted = append(trusted, source.Name)
+		}
+	}
+	return append(append([]string{}, routerConfig.ProxyRealIPCIDRs...), routerConfig.IPRanges(trusted)...)
+}
+
+// cdnRealIPHeader returns the header from which the addresses of the application's clients are
+// taken, if it sits behind a CDN and the router does not take them from the PROXY protocol.
+func cdnRealIPHeader(routerConfig *model.RouterConfig, appConfig *model.AppConfig) string {
+	if routerConfig.ProxyProtocolRealIP() {
+		return ""
+	}
+	return appConfig.CDNClientIPHeader()
+}
+
+// cdnRealIPCIDRs returns the addresses trusted to report the application's clients' addresses: those
+// trusted by the router, and the ranges currently known of the CDN the application sits behind.
+func cdnRealIPCIDRs(routerConfig *model.RouterConfig, appConfig *model.AppConfig) []string {
+	return append(realIPCIDRs(routerConfig), routerConfig.IPRanges([]string{appConfig.CDN})...)
+}
+
+// wafEnabled returns a bool indicating whether any application's requests are inspected by
+// ModSecurity.
+func wafEnabled(routerConfig *model.RouterConfig) bool {
+	for _, appConfig := range routerConfig.AppConfigs {
+		if appConfig.WAFConfig != nil && appConfig.WAFConfig.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyCacheEnabled returns a bool indicating whether any of the provided application's responses
+// are cached, whether those of all its locations or of only some.  gRPC responses never are.
+func proxyCacheEnabled(appConfig *model.AppConfig) bool {
+	if appConfig.ProxyCacheConfig == nil || proxyModule(appConfig) != "proxy" {
+		return false
+	}
+	if appConfig.ProxyCacheConfig.Enabled {
+		return true
+	}
+	for _, location := range appConfig.Locations {
+		if location.ProxyCache {
+			return true
+		}
+	}
+	return false
+}
+
+// locationCached returns a bool indicating whether the responses of the provided location of the
+// application are cached.
+func locationCached(appConfig *model.AppConfig, location *model.LocationConfig) bool {
+	return location.ProxyCache && proxyCacheEnabled(appConfig)
+}
+
+// proxyModule returns the nginx module, and so the prefix of the directives, with which requests
+// are proxied to the provided application: "grpc" for applications spoken to in HTTP/2, whether
+// gRPC or h2c, or "proxy" otherwise.
+func proxyModule(appConfig *model.AppConfig) string {
+	if appConfig.HTTP2Backend() {
+		return "grpc"
+	}
+	return "proxy"
+}
+
+// locationModule returns the nginx module with which the provided location's requests are proxied:
+// "proxy" for the location from which gRPC-Web requests are proxied to the router, or the
+// application's module otherwise.
+func locationModule(context locationContext) string {
+	if context.GRPCWeb {
+		return "proxy"
+	}
+	return proxyModule(context.AppConfig)
+}
+
+// grpcWeb returns whether the gRPC-Web requests of the provided application are translated into
+// gRPC.  Only those of gRPC applications are.
+func grpcWeb(appConfig *model.AppConfig) bool {
+	return appConfig.GRPCWeb && (appConfig.BackendProtocol == "grpc" || appConfig.BackendProtocol == "grpcs")
+}
+
+// grpcWebEnabled returns whether any application's gRPC-Web requests are translated into gRPC.
+func grpcWebEnabled(routerConfig *model.RouterConfig) bool {
+	for _, appConfig := range routerConfig.AppConfigs {
+		if grpcWeb(appConfig) {
+			return true
+		}
+	}
+	return false
+}
+
+// grpcWebName returns the name of the location from which the provided location's gRPC-Web
+// requests are proxied to the router to be translated, or an empty string if they are not
+// translated.
+func grpcWebName(context locationContext) string {
+	if !grpcWeb(context.AppConfig) {
+		return ""
+	}
+	return fmt.Sprintf("@deis_grpc_web_%s", locationID(context.AppConfig, context.Location.Path))
+}
+
+// grpcWebRedirect returns the name of the location to which the provided location passes its
+// gRPC-Web requests, or an empty string if it passes none.
+func grpcWebRedirect(context locationContext) string {
+	if context.GRPCWeb {
+		return ""
+	}
+	return grpcWebName(context)
+}
+
+// grpcWebContext returns the context of the location from which the provided location's gRPC-Web
+// requests are proxied to the router to be translated, or nil if they are not translated.
+func grpcWebContext(context locationContext) *locationContext {
+	if context.GRPCWeb || grpcWebName(context) == "" {
+		return nil
+	}
+	grpcWebContext := context
+	grpcWebContext.GRPCWeb = true
+	return &grpcWebContext
+}
+
+// upstreamTLS returns whether the provided application's endpoints are spoken to over TLS.
+func upstreamTLS(appConfig *model.AppConfig) bool {
+	return appConfig.BackendProtocol == "https" || appConfig.BackendProtocol == "grpcs"
+}
+
+// sslEnforcement returns how HTTPS is enforced for the provided application: "true" if either the
+// application or the router enforces it for every request, which takes precedence over "external"
+// if either enforces it only for requests from outside the cluster, or an empty string if neither
+// enforces it.
+func sslEnforcement(routerConfig *model.RouterConfig, appConfig *model.AppConfig) string {
+	if routerConfig.SSLConfig.Enforce == "true" || appConfig.SSLConfig.Enforce == "true" {
+		return "true"
+	}
+	if routerConfig.SSLConfig.Enforce == "external" || appConfig.SSLConfig.Enforce == "external" {
+		return "external"
+	}
+	return ""
+}
+
+// corsOrigin returns the value of the Access-Control-Allow-Origin header with which the provided
+// application's responses are sent: "*" if pages from any origin may make requests without
+// credentials, or else a variable holding the requesting origin if it is allowed, which is empty,
+// so that no header is sent, if it is not.  Browsers refuse credentials for responses to any origin.
+func corsOrigin(appConfig *model.AppConfig) string {
+	corsConfig := appConfig.CORSConfig
+	if corsConfig.AllowsAnyOrigin() && !corsConfig.AllowCredentials {
+		return "*"
+	}
+	return "$cors_origin_" + locationID(appConfig, "")
+}
+
+// corsOrigins returns the values of the Origin header, or regular expressions matching them,
+// from which requests to an application with the provided CORS configuration are allowed.
+func corsOrigins(corsConfig *model.CORSConfig) []string {
+	patterns := []string{}
+	for _, origin := range corsConfig.AllowOrigins {
+		if origin == "*" {
+			continue
+		}
+		if i := strings.Index(origin, "://*."); i >= 0 {
+			patterns = append(patterns, "~*^"+regexp.QuoteMeta(origin[:i+3])+"([-a-z0-9]+\\.)+"+regexp.QuoteMeta(origin[i+5:])+"$")
+			continue
+		}
+		patterns = append(patterns, origin)
+	}
+	return patterns
+}
+
+// redirectTarget returns the scheme and domain to which every one of the provided application's
+// requests is redirected, if any.  Without a scheme of its own, the domain is redirected to over the
+// scheme by which the request arrived.
+func redirectTarget(appConfig *model.AppConfig) string {
+	if appConfig.RedirectConfig == nil || appConfig.RedirectConfig.To == "" {
+		return ""
+	}
+	if strings.HasPrefix(appConfig.RedirectConfig.To, "http://") || strings.HasPrefix(appConfig.RedirectConfig.To, "https://") {
+		return appConfig.RedirectConfig.To
+	}
+	return "$access_scheme://" + appConfig.RedirectConfig.To
+}
+
+// sslExemptions returns a regular expression matching the paths that begin with any of the provided
+// application's paths exempt from HTTPS enforcement, or an empty string if it has none.
+func sslExemptions(appConfig *model.AppConfig) string {
+	if appConfig.RedirectConfig == nil || len(appConfig.RedirectConfig.SSLExemptPaths) == 0 {
+		return ""
+	}
+	paths := make([]string, len(appConfig.RedirectConfig.SSLExemptPaths))
+	for i, path := range appConfig.RedirectConfig.SSLExemptPaths {
+		paths[i] = regexp.QuoteMeta(path)
+	}
+	return "^(" + strings.Join(paths, "|") + ")"
+}
+
+// locationTLS returns whether the provided location's requests are proxied over TLS, either to the
+// application's endpoints or to its external origin.
+func locationTLS(context locationContext) bool {
+	if context.ExternalOrigin != "" {
+		return strings.HasPrefix(context.ExternalOrigin, "https://")
+	}
+	return upstreamTLS(context.AppConfig)
+}
+
+// sslServerName returns whether the name of the server to which the provided location's requests
+// are proxied over TLS is sent in the TLS handshake: that of an external origin always is, since
+// such servers commonly host many names, and that of the application's endpoints is if it is set.
+func sslServerName(context locationContext) bool {
+	if context.ExternalOrigin != "" {
+		return true
+	}
+	return context.AppConfig.UpstreamTLSConfig != nil && context.AppConfig.UpstreamTLSConfig.Name != ""
+}
+
+// proxyBind returns the local address from which connections to the provided application's
+// endpoints are made, if any is specified.
+func proxyBind(routerConfig *model.RouterConfig, appConfig *model.AppConfig) string {
+	if appConfig.ProxyBind == "off" {
+		return ""
+	}
+	if appConfig.ProxyBind != "" {
+		return appConfig.ProxyBind
+	}
+	return routerConfig.ProxyBind
+}
+
+// transformsEnabled returns whether any application's requests or responses are transformed by the
+// router's library of njs functions, which is loaded only if so.
+func transformsEnabled(routerConfig *model.RouterConfig) bool {
+	for _, appConfig := range routerConfig.AppConfigs {
+		if len(appConfig.AllowedResponseHeaders) > 0 || (appConfig.TLSHeadersConfig != nil && appConfig.TLSHeadersConfig.JA3) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixRewrites returns the arguments of the rewrite directives with which the provided location's
+// prefix is stripped from the paths that begin with it, as a whole segment, and its prefix is then
+// added, before its requests are proxied.  Retries are proxied with the path as already rewritten.
+func prefixRewrites(context locationContext) []string {
+	location := context.Location
+	if context.Attempt > 0 || proxyModule(context.AppConfig) != "proxy" {
+		return nil
+	}
+	strip := strings.TrimRight(location.StripPrefix, "/")
+	add := strings.TrimRight(location.AddPrefix, "/")
+	if strip == "" {
+		if add == "" {
+			return nil
+		}
+		return []string{fmt.Sprintf("\"^(.*)$\" %s$1", add)}
+	}
+	pattern := regexp.QuoteMeta(strip)
+	return []string{
+		fmt.Sprintf("\"^%s$\" %s/", pattern, add),
+		fmt.Sprintf("\"^%s(/.*)$\" %s$1", pattern, add),
+	}
+}
+
+// bodySizeResponse returns the provided application's options for the 413 with which requests whose
+// bodies are too large are rejected, or nil if nginx's own response is left as it is.
+func bodySizeResponse(appConfig *model.AppConfig) *model.BodySizeResponseConfig {
+	bodySizeResponseConfig := appConfig.BodySizeResponseConfig
+	if bodySizeResponseConfig == nil || (bodySizeResponseConfig.Body == "" && !bodySizeResponseConfig.CORS) {
+		return nil
+	}
+	return bodySizeResponseConfig
+}
+
+// errorStatuses lists the statuses with which nginx may answer an application's requests itself.
+var errorStatuses = []string{"400", "403", "404", "405", "408", "413", "500", "502", "503", "504"}
+
+// errorMessages are the messages with which errors are described in JSON.
+var errorMessages = map[string]string{
+	"400": "Bad Request",
+	"403": "Forbidden",
+	"404": "Not Found",
+	"405": "Method Not Allowed",
+	"408": "Request Timeout",
+	"413": "Payload Too Large",
+	"429": "Too Many Requests",
+	"500": "Internal Server Error",
+	"502": "Bad Gateway",
+	"503": "Service Unavailable",
+	"504": "Gateway Timeout",
+}
+
+// jsonErrorStatuses returns the statuses of the errors that are answered in JSON for the provided
+// application, if its error format is JSON.  Statuses for which the application has error pages,
+// the 503 of maintenance, and a customized 413 keep their own pages.
+func jsonErrorStatuses(routerConfig *model.RouterConfig, appConfig *model.AppConfig) []string {
+	if appConfig.ErrorFormat != "json" {
+		return nil
+	}
+	maintenance := appConfig.Maintenance || emergencyMode(routerConfig) == "static-503"
+	statuses := []string{}
+	for _, status := range errorStatuses {
+		if _, ok := appConfig.ErrorPages[status]; ok || (status == "503" && maintenance) || (status == "413" && bodySizeResponse(appConfig) != nil) {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// jsonErrorPage returns the named location to which errors bearing the provided status are
+// redirected to be answered in JSON.
+func jsonErrorPage(status string) string {
+	return "@deis_error_" + status
+}
+
+// jsonErrorBody returns the JSON body with which an error bearing the provided status is answered
+// when an application's error format is JSON.  The request's ID is interpolated by nginx.
+func jsonErrorBody(routerConfig *model.RouterConfig, status string) string {
+	message, ok := errorMessages[status]
+	if !ok {
+		message = "Error"
+	}
+	return fmt.Sprintf(`{"code":%s,"message":"%s","request_id":"%s"}`, status, message, requestID(routerConfig))
+}
+
+// essentialResponseHeaders are the response headers, in lower case, without which a response cannot
+// be read, and which are never removed from the responses of applications that allow only some.
+var essentialResponseHeaders = []string{
+	"content-encoding", "content-length", "content-range", "content-type", "grpc-encoding",
+	"grpc-message", "grpc-status", "transfer-encoding",
+}
+
+// allowedResponseHeaders returns the response headers, in lower case and delimited by commas, that
+// the provided application allows to reach its clients, along with those that are essential, or ""
+// if it allows all of them.
+func allowedResponseHeaders(appConfig *model.AppConfig) string {
+	if len(appConfig.AllowedResponseHeaders) == 0 {
+		return ""
+	}
+	allowed := make([]string, 0, len(appConfig.AllowedResponseHeaders)+len(essentialResponseHeaders))
+	for _, header := range appConfig.AllowedResponseHeaders {
+		allowed = append(allowed, strings.ToLower(header))
+	}
+	return strings.Join(append(allowed, essentialResponseHeaders...), ",")
+}
+
+// routerRequestHeaders are the request headers, in lower case, that the router sets on every request
+// it proxies.
+var routerRequestHeaders = map[string]bool{
+	"host":              true,
+	"x-forwarded-for":   true,
+	"x-forwarded-proto": true,
+	"x-forwarded-port":  true,
+	"upgrade":           true,
+	"connection":        true,
+	"x-request-id":      true,
+	"x-correlation-id":  true,
+}
+
+// transformHeaders returns the request headers that are passed to the provided application with
+// the casing it expects.  Headers that the router sets itself are left alone, since setting them
+// again would send them twice.
+func transformHeaders(appConfig *model.AppConfig) []string {
+	if appConfig.TransformConfig == nil || proxyModule(appConfig) != "proxy" {
+		return nil
+	}
+	headers := []string{}
+	for _, header := range appConfig.TransformConfig.HeaderCase {
+		if !routerRequestHeaders[strings.ToLower(header)] {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
+
+// authCacheZone returns the name of the cache zone in which the external authentication service's
+// answers for the provided application are cached.
+func authCacheZone(appConfig *model.AppConfig) string {
+	return "auth_" + upstreamName(appConfig, locationID(appConfig, ""))
+}
+
+// authCacheKey returns the variables, each preceded by a separator, by whose values the external
+// authentication service's answers are cached.
+func authCacheKey(externalAuthConfig *model.ExternalAuthConfig) string {
+	key := ""
+	for _, ref := range externalAuthConfig.CacheKey {
+		switch ref {
+		case "uri":
+			key += "|$request_uri"
+		case "method":
+			key += "|$request_method"
+		default:
+			if variable := requestVariable(ref); variable != "" {
+				key += "|" + variable
+			}
+		}
+	}
+	return key
+}
+
+// proxyCacheZone returns the name of the provided application's cache, which is also the name of
+// the directory, within the router's cache directory, that holds it.  Like an upstream's name, it is
+// unique even among applications that share a name.
+func proxyCacheZone(appConfig *model.AppConfig) string {
+	return "cache_" + upstreamName(appConfig, locationID(appConfig, ""))
+}
+
+// cacheBypass returns the space-delimited nginx variables that, when any is neither empty nor "0",
+// cause a request to bypass the provided cache.
+func cacheBypass(proxyCacheConfig *model.ProxyCacheConfig) string {
+	variables := []string{}
+	for _, ref := range proxyCacheConfig.Bypass {
+		if variable := requestVariable(ref); variable != "" {
+			variables = append(variables, variable)
+		}
+	}
+	return strings.Join(variables, " ")
+}
+
+// errorPagesDir returns the name of the directory, unique to the provided application, in which its
+// custom error pages are written.
+func errorPagesDir(appConfig *model.AppConfig) string {
+	return "errors_" + upstreamName(appConfig, locationID(appConfig, ""))
+}
+
+// wafRulesFile returns the name of the file, unique to the provided application, in which its custom
+// WAF rules are written.
+func wafRulesFile(appConfig *model.AppConfig) string {
+	return "waf_" + upstreamName(appConfig, locationID(appConfig, "")) + ".conf"
+}
+
+// affinityCookies returns the distinct names, in a stable order, of all cookies used for
+// cookie-based session affinity.
+func affinityCookies(routerConfig *model.RouterConfig) []string {
+	seen := map[string]bool{}
+	cookies := []string{}
+	for _, appConfig := range routerConfig.AppConfigs {
+		if appConfig.Affinity == "cookie" && !seen[appConfig.AffinityCookie] {
+			seen[appConfig.AffinityCookie] = true
+			cookies = append(cookies, appConfig.AffinityCookie)
+		}
+	}
+	sort.Strings(cookies)
+	return cookies
+}
+
+// locationID returns an identifier for the specified path of the provided application.  It is a
+// hash of the application's name, domains, and the path, so it is unique even among applications
+// that share a name, such as those built from the rules of a single ingress.
+func locationID(appConfig *model.AppConfig, path string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(strings.Join(append([]string{appConfig.Name, path}, appConfig.Domains...), "\x00")))
+	return fmt.Sprintf("%08x", hash.Sum32())
+}
+
+// upstreamName returns a recognizable name for an upstream of the provided application, qualified
+// by the specified identifier.
+func upstreamName(appConfig *model.AppConfig, id string) string {
+	return fmt.Sprintf("%s-%s", upstreamNameSanitizer.ReplaceAllString(appConfig.Name, "-"), id)
+}
+
+var upstreamNameSanitizer = regexp.MustCompile("[^A-Za-z0-9_.-]")
+
+var nonSyslogTagCharacters = regexp.MustCompile("[^A-Za-z0-9_]")
+
+// debugBodyContext is the data used to render the maps that capture and redact request bodies for
+// all applications with request body logging enabled.
+type debugBodyContext struct {
+	AppConfigs     []*model.AppConfig
+	RedactionSteps []debugBodyRedactionStep
+}
+
+// debugBodyRedactionStep represents a single map in the chain of maps through which captured request
+// bodies are redacted.  The nth step applies each application's nth redaction pattern, if it has
+// one.
+type debugBodyRedactionStep struct {
+	Index      int
+	NextIndex  int
+	Redactions []debugBodyRedaction
+}
+
+type debugBodyRedaction struct {
+	AppName string
+	Pattern string
+}
+
+func newDebugBodyContext(routerConfig *model.RouterConfig) debugBodyContext {
+	context := debugBodyContext{}
+	for _, appConfig := range routerConfig.AppConfigs {
+		if appConfig.DebugBodyConfig == nil || !appConfig.DebugBodyConfig.Enabled {
+			continue
+		}
+		context.AppConfigs = append(context.AppConfigs, appConfig)
+		for i, pattern := range appConfig.DebugBodyConfig.RedactPatterns {
+			if i == len(context.RedactionSteps) {
+				context.RedactionSteps = append(context.RedactionSteps, debugBodyRedactionStep{Index: i, NextIndex: i + 1})
+			}
+			context.RedactionSteps[i].Redactions = append(context.RedactionSteps[i].Redactions, debugBodyRedaction{
+				AppName: appConfig.Name,
+				Pattern: pattern,
+			})
+		}
+	}
+	return context
+}
+
+// emergencyMode returns the emergency mode in effect for all applications.
+func emergencyMode(routerConfig *model.RouterConfig) string {
+	if routerConfig.EmergencyConfig == nil || routerConfig.EmergencyConfig.Mode == "" {
+		return "off"
+	}
+	return routerConfig.EmergencyConfig.Mode
+}
+
+// WriteCerts writes SSL certs to file from router configuration.  Applications' basic
+// authentication credentials are written alongside them.  Files are only rewritten if their contents
+// have changed, and certs, keys, and credentials that are no longer needed are deleted.
+func WriteCerts(routerConfig *model.RouterConfig, sslPath string) error {
+	written := map[string]bool{}
+	if routerConfig.PlatformCertificate != nil {
+		err := writeCert("platform", routerConfig.PlatformCertificate, sslPath)
+		if err != nil {
+			return err
+		}
+		markCertWritten(written, "platform", routerConfig.PlatformCertificate)
+	}
+	for _, appConfig := range routerConfig.AppConfigs {
+		for domain, certificate := range appConfig.Certificates {
+			if certificate != nil {
+				fileName, err := certFileName(appConfig, domain)
+				if err != nil {
+					return err
+				}
+				// Certificates shared by several domains are written once.
+				if written[fileName+".crt"] {
+					continue
+				}
+				err = writeCert(fileName, certificate, sslPath)
+				if err != nil {
+					return err
+				}
+				markCertWritten(written, fileName, certificate)
+			}
+		}
+		for domain, clientVerification := range appConfig.ClientVerifications {
+			fileName, err := templatefuncs.FileName(domain)
+			if err != nil {
+				return err
+			}
+			caFileName := fmt.Sprintf("%s.client.ca.crt", fileName)
+			err = writeFileIfChanged(filepath.Join(sslPath, caFileName), []byte(clientVerification.CABundle), 0644)
+			if err != nil {
+				return err
+			}
+			written[caFileName] = true
+		}
+		if len(appConfig.BasicAuthUsers) > 0 {
+			for _, domain := range appConfig.Domains {
+				fileName, err := templatefuncs.FileName(domain)
+				if err != nil {
+					return err
+				}
+				htpasswdFileName := fmt.Sprintf("%s.htpasswd", fileName)
+				htpasswdPath := filepath.Join(sslPath, htpasswdFileName)
+				// Passwords hashed for the existing file are reused, so that the file is unchanged
+				// unless the credentials are.
+				existing, _ := ioutil.ReadFile(htpasswdPath)
+				htpasswd, err := buildHtpasswd(appConfig.BasicAuthUsers, existing)
+				if err != nil {
+					return err
+				}
+				if err := writeFileIfChanged(htpasswdPath, htpasswd, 0600); err != nil {
+					return err
+				}
+				written[htpasswdFileName] = true
+			}
+		}
+	}
+
+	err := writeFileIfChanged(filepath.Join(sslPath, "client.ca.crt"), []byte(strings.Join(routerConfig.ClientCertificates, "\n")), 0644)
+	if err != nil {
+		return err
+	}
+	written["client.ca.crt"] = true
+	for _, pattern := range []string{"*.crt", "*.key", "*.htpasswd"} {
+		paths, err := filepath.Glob(filepath.Join(sslPath, pattern))
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if written[filepath.Base(path)] {
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeCert(context string, certificate *model.Certificate, sslPath string) error {
+	certPath := filepath.Join(sslPath, fmt.Sprintf("%s.crt", context))
+	keyPath := filepath.Join(sslPath, fmt.Sprintf("%s.key", context))
+	err := writeFileIfChanged(certPath, []byte(certificate.Cert), 0644)
+	if err != nil {
+		return err
+	}
+	if certificate.CA != "" {
+		caPath := filepath.Join(sslPath, fmt.Sprintf("%s.ca.crt", context))
+		if err := writeFileIfChanged(caPath, []byte(certificate.CA), 0644); err != nil {
+			return err
+		}
+	}
+	return writeFileIfChanged(keyPath, []byte(certificate.Key), 0600)
+}
+
+// markCertWritten records the names of the files writeCert wrote for the provided certificate.
+func markCertWritten(written map[string]bool, context string, certificate *model.Certificate) {
+	written[context+".crt"] = true
+	written[context+".key"] = true
+	if certificate.CA != "" {
+		written[context+".ca.crt"] = true
+	}
+}
+
+// buildHtpasswd returns the contents of an htpasswd file holding the provided entries.  Passwords
+// marked with the {PLAIN} scheme are hashed with a random salt so that they never reach the disk in
+// the clear, unless the provided existing file already holds a hash of the same password for the same
+// user, which is reused.
+func buildHtpasswd(users []string, existing []byte) ([]byte, error) {
+	existingHashes := map[string]string{}
+	for _, line := range strings.Split(string(existing), "\n") {
+		if i := strings.Index(line, ":"); i != -1 {
+			existingHashes[line[:i]] = line[i+1:]
+		}
+	}
+	var htpasswd bytes.Buffer
+	for _, user := range users {
+		if i := strings.Index(user, ":{PLAIN}"); i != -1 {
+			password := user[i+len(":{PLAIN}"):]
+			hash, ok := existingHashes[user[:i]]
+			if !ok || !verifyPassword(hash, password) {
+				var err error
+				hash, err = hashPassword(password)
+				if err != nil {
+					return nil, err
+				}
+			}
+			user = user[:i+1] + hash
+		}
+		htpasswd.WriteString(user)
+		htpasswd.WriteString("\n")
+	}
+	return htpasswd.Bytes(), nil
+}
+
+// hashPassword hashes the provided password using the salted SHA-1 scheme understood by nginx.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 8)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	digest := sha1.Sum(append([]byte(password), salt...))
+	return "{SSHA}" + base64.StdEncoding.EncodeToString(append(digest[:], salt...)), nil
+}
+
+// verifyPassword returns whether the provided hash, in the salted SHA-1 scheme, is of the provided
+// password.
+func verifyPassword(hash string, password string) bool {
+	if !strings.HasPrefix(hash, "{SSHA}") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(hash, "{SSHA}"))
+	if err != nil || len(decoded) <= sha1.Size {
+		return false
+	}
+	digest := sha1.Sum(append([]byte(password), decoded[sha1.Size:]...))
+	return bytes.Equal(digest[:], decoded[:sha1.Size])
+}
+
+// WriteDHParam writes router DHParam to file from router configuration.
+func WriteDHParam(routerConfig *model.RouterConfig, sslPath string) error {
+	dhParamPath := filepath.Join(sslPath, "dhparam.pem")
+	if routerConfig.SSLConfig.DHParam == "" {
+		err := os.RemoveAll(dhParamPath)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := writeFileIfChanged(dhParamPath, []byte(routerConfig.SSLConfig.DHParam), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteECHKeys writes the router's Encrypted Client Hello keys to the "ech" directory beneath
+// sslPath, from which nginx loads every file.  Keys that are no longer needed are deleted, as is the
+// directory if ECH is disabled.
+func WriteECHKeys(routerConfig *model.RouterConfig, sslPath string) error {
+	echPath := filepath.Join(sslPath, "ech")
+	if !echEnabled(routerConfig) {
+		return os.RemoveAll(echPath)
+	}
+	if err := os.MkdirAll(echPath, 0700); err != nil {
+		return err
+	}
+	existingKeys, err := filepath.Glob(filepath.Join(echPath, "*"))
+	if err != nil {
+		return err
+	}
+	for _, existingKey := range existingKeys {
+		if _, ok := routerConfig.SSLConfig.ECHKeys[filepath.Base(existingKey)]; !ok {
+			if err := os.Remove(existingKey); err != nil {
+				return err
+			}
+		}
+	}
+	for name, key := range routerConfig.SSLConfig.ECHKeys {
+		if err := writeFileIfChanged(filepath.Join(echPath, name), []byte(key), 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteTracerConfig writes the configuration of the tracer, with which spans are reported to the
+// router's tracing collector, to file from router configuration.  The file is removed if tracing is
+// disabled.
+func WriteTracerConfig(routerConfig *model.RouterConfig, confPath string) error {
+	tracerConfigPath := filepath.Join(confPath, "tracer.json")
+	if !tracingEnabled(routerConfig) {
+		return os.RemoveAll(tracerConfigPath)
+	}
+	tracingConfig := routerConfig.TracingConfig
+	separator := strings.LastIndex(tracingConfig.Collector, ":")
+	port, err := strconv.Atoi(tracingConfig.Collector[separator+1:])
+	if err != nil {
+		return err
+	}
+	sampleRate, err := strconv.ParseFloat(tracingConfig.SampleRate, 64)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"service_name":   tracingConfig.ServiceName,
+		"collector_host": tracingConfig.Collector[:separator],
+		"collector_port": port,
+		"sample_rate":    sampleRate,
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileIfChanged(tracerConfigPath, data, 0644)
+}
+
+// WriteErrorPages writes applications' custom error pages to file, each application's to a
+// directory of its own, from router configuration.  Only pages whose contents have changed are
+// rewritten, and none are deleted, since nginx serves them from the configuration in effect until
+// it is reloaded; see RemoveStaleErrorPages.
+func WriteErrorPages(routerConfig *model.RouterConfig, errorPagesPath string) error {
+	for dir, pages := range errorPages(routerConfig) {
+		dir = filepath.Join(errorPagesPath, dir)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		for fileName, page := range pages {
+			if err := writeFileIfChanged(filepath.Join(dir, fileName), []byte(page), 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RemoveStaleErrorPages deletes the error pages, and the directories holding them, that the
+// provided router configuration does not use.  It should be called only once nginx has been
+// reloaded with that configuration.
+func RemoveStaleErrorPages(routerConfig *model.RouterConfig, errorPagesPath string) error {
+	keep := errorPages(routerConfig)
+	dirs, err := filepath.Glob(filepath.Join(errorPagesPath, "errors_*"))
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		pages, ok := keep[filepath.Base(dir)]
+		if !ok {
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+			continue
+		}
+		pagePaths, err := filepath.Glob(filepath.Join(dir, "*"))
+		if err != nil {
+			return err
+		}
+		for _, pagePath := range pagePaths {
+			if _, ok := pages[filepath.Base(pagePath)]; !ok {
+				if err := os.Remove(pagePath); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// errorPages maps the name of the directory holding each application's custom error pages to the
+// pages, keyed by file name.  Directories are named as they are in the rendered configuration, in
+// which each application's domains are sorted.
+func errorPages(routerConfig *model.RouterConfig) map[string]map[string]string {
+	dirs := map[string]map[string]string{}
+	for _, appConfig := range sortedConfig(routerConfig).AppConfigs {
+		if len(appConfig.ErrorPages) == 0 {
+			continue
+		}
+		pages := map[string]string{}
+		for status, page := range appConfig.ErrorPages {
+			pages[status+".html"] = page
+		}
+		dirs[errorPagesDir(appConfig)] = pages
+	}
+	return dirs
+}
+
+// WriteWAFRules writes the rules with which ModSecurity is configured, and each application's custom
+// rules, to file from router configuration.  Files whose contents have not changed are left as they
+// are, and rules that are no longer needed are left for RemoveStaleWAFRules.
+func WriteWAFRules(routerConfig *model.RouterConfig, wafPath string) error {
+	if !wafEnabled(routerConfig) {
+		return nil
+	}
+	if err := os.MkdirAll(wafPath, 0755); err != nil {
+		return err
+	}
+	if err := writeFileIfChanged(filepath.Join(wafPath, "main.conf"), []byte(wafMainRules), 0644); err != nil {
+		return err
+	}
+	for fileName, rules := range wafRules(routerConfig) {
+		if err := writeFileIfChanged(filepath.Join(wafPath, fileName), []byte(rules), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveStaleWAFRules deletes the rules that the provided router configuration does not use,
+// including ModSecurity's own once no application enables the WAF.  It should be called only once
+// nginx has been reloaded with that configuration.
+func RemoveStaleWAFRules(routerConfig *model.RouterConfig, wafPath string) error {
+	if !wafEnabled(routerConfig) {
+		if err := os.RemoveAll(filepath.Join(wafPath, "main.conf")); err != nil {
+			return err
+		}
+	}
+	keep := wafRules(routerConfig)
+	rulesPaths, err := filepath.Glob(filepath.Join(wafPath, "waf_*.conf"))
+	if err != nil {
+		return err
+	}
+	for _, rulesPath := range rulesPaths {
+		if _, ok := keep[filepath.Base(rulesPath)]; !ok {
+			if err := os.Remove(rulesPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// wafRules maps the name of the file holding each application's custom WAF rules to the rules, in
+// the order of their names.  Files are named as they are in the rendered configuration, in which
+// each application's domains are sorted.  No rules are used unless the WAF is enabled.
+func wafRules(routerConfig *model.RouterConfig) map[string]string {
+	files := map[string]string{}
+	if !wafEnabled(routerConfig) {
+		return files
+	}
+	for _, appConfig := range sortedConfig(routerConfig).AppConfigs {
+		wafConfig := appConfig.WAFConfig
+		if wafConfig == nil || !wafConfig.Enabled || len(wafConfig.Rules) == 0 {
+			continue
+		}
+		names := make([]string, 0, len(wafConfig.Rules))
+		for name := range wafConfig.Rules {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var rules bytes.Buffer
+		for _, name := range names {
+			fmt.Fprintf(&rules, "# %s\n%s\n", name, wafConfig.Rules[name])
+		}
+		files[wafRulesFile(appConfig)] = rules.String()
+	}
+	return files
+}
+
+// WriteCacheDirs creates, within the specified directory, the directory holding each application's
+// cache, which nginx requires to exist before it will load configuration that uses the cache.
+func WriteCacheDirs(routerConfig *model.RouterConfig, cachePath string) error {
+	for zone := range cacheZones(routerConfig) {
+		if err := os.MkdirAll(filepath.Join(cachePath, zone), 0700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveStaleCacheDirs removes, from the specified directory, the directories holding the caches
+// of applications that no longer cache their responses.  It should only be called once nginx has
+// loaded the provided configuration, so that no cache still in use is removed.
+func RemoveStaleCacheDirs(routerConfig *model.RouterConfig, cachePath string) error {
+	keep := cacheZones(routerConfig)
+	cacheDirs, err := filepath.Glob(filepath.Join(cachePath, "cache_*"))
+	if err != nil {
+		return err
+	}
+	for _, cacheDir := range cacheDirs {
+		if !keep[filepath.Base(cacheDir)] {
+			if err := os.RemoveAll(cacheDir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cacheZones returns the names of the caches used by the provided configuration, as they are named
+// in the configuration rendered for it.
+func cacheZones(routerConfig *model.RouterConfig) map[string]bool {
+	zones := map[string]bool{}
+	for _, appConfig := range sortedConfig(routerConfig).AppConfigs {
+		if proxyCacheEnabled(appConfig) {
+			zones[proxyCacheZone(appConfig)] = true
+		}
+	}
+	return zones
+}
+
+// WriteConfig dynamically produces valid nginx configuration by combining a Router configuration
+// object with a data-driven template.  Each application's configuration is written to a file of its
+// own, in the appConfigDir directory alongside the specified file, which includes them all.  Files
+// whose contents are unchanged are left untouched, and those of applications that no longer exist
+// are removed.  Identical models always produce identical configuration, whatever the order of
+// their applications, domains, and upstream servers.
+func WriteConfig(routerConfig *model.RouterConfig, filePath string) error {
+	return writeConfig(routerConfig, filePath, false)
+}
+
+// WriteStagedConfig writes configuration, as WriteConfig does, for a staged instance of nginx that
+// serves the provided configuration alongside the instance serving the configuration in effect.
+// The staged instance listens only on the router's HTTP and HTTPS ports, which it shares, and
+// reports its traffic on a port of its own.
+func WriteStagedConfig(routerConfig *model.RouterConfig, filePath string) error {
+	return writeConfig(routerConfig, filePath, true)
+}
+
+// writeConfig renders configuration with the template overrides, if there are any that nginx has
+// not rejected, and falls back to the built-in template if the overrides cannot be rendered.
+func writeConfig(routerConfig *model.RouterConfig, filePath string, staged bool) error {
+	overrides, err := readTemplateOverrides()
+	if err != nil {
+		log.Printf("WARN: Failed to read template overrides from %s; using the built-in template: %v", TemplateOverridesDir, err)
+	} else if overrides != nil && !overrides.rejected() {
+		err := renderTemplate(routerConfig, filePath, staged, overrides)
+		if err == nil {
+			return nil
+		}
+		log.Printf("WARN: Failed to render nginx configuration with the template overrides in %s; using the built-in template: %v", TemplateOverridesDir, err)
+	}
+	return renderTemplate(routerConfig, filePath, staged, nil)
+}
+
+// renderTemplate renders configuration with the built-in template, as modified by the provided
+// overrides, if any.
+func renderTemplate(routerConfig *model.RouterConfig, filePath string, staged bool, overrides *templateOverrides) error {
+	tmpl, err := template.New("nginx").Funcs(sprig.TxtFuncMap()).Funcs(templatefuncs.FuncMap()).Funcs(template.FuncMap{
+		"locationContext":   newLocationContext,
+		"healthLocation":    newHealthcheckLocationContext,
+		"debugBodyContext":  newDebugBodyContext,
+		"emergencyMode":     emergencyMode,
+		"upstreams":         newUpstreams,
+		"cacheControlMaps":  newCacheControlMaps,
+		"cachingForced":     cachingForced,
+		"cacheControlValue": cacheControlValue,
+		"expires":           expires,
+		"locationContexts":  newLocationContexts,
+		"retries":           newRetries,
+		"syslogTarget":      syslogTarget,
+		"appAccessLogs":     appAccessLogs,
+		"latencyLog":        latencyLog,
+		"unknownSNILog":     unknownSNILog,
+		"tlsErrorLog":       tlsErrorLog,
+		"sslSessionCache":   sslSessionCache,
+		"geoIPRules":        geoIPRules,
+		"earlyDataRules":    earlyDataRules,
+		"echEnabled":        echEnabled,
+		"listenersFor":      listenersFor,
+		"certFileName":      certFileName,
+		"trustedCA":         trustedCA,
+		"upstreamTLS":       upstreamTLS,
+		"locationTLS":       locationTLS,
+		"redirectTarget":    redirectTarget,
+		"corsOrigin":        corsOrigin,
+		"corsOrigins":       corsOrigins,
+		"sslEnforcement":    sslEnforcement,
+		"sslExemptions":     sslExemptions,
+		"sslServerName":     sslServerName,
+		"transformsEnabled": transformsEnabled,
+		"prefixRewrites":    prefixRewrites,
+		"proxyBind":         proxyBind,
+		"stagedRollout":     StagedRollout,
+		"stagedInstance":    func() bool { return staged },
+		"stagedStatsPort":   func() int { return stagedStatsPort },
+		"jsonErrorStatuses": jsonErrorStatuses,
+		"bodySizeResponse":  bodySizeResponse,
+		"jsonErrorPage":     jsonErrorPage,
+		"jsonErrorBody":     jsonErrorBody,
+		"allowedHeaders":    allowedResponseHeaders,
+		"transformHeaders":  transformHeaders,
+		"sniLimited":        sniLimited,
+		"realIPHeader":      realIPHeader,
+		"domainServers":     domainServers,
+		"defaultServers":    defaultServers,
+		"sslListener":       sslListener,
+		"affinityCookies":   affinityCookies,
+		"canarySplits":      newCanarySplits,
+		"rateLimits":        newRateLimits,
+		"rateLimitZone":     rateLimitZone,
+		"rateLimitResponse": rateLimitResponse,
+		"proxyCacheEnabled": proxyCacheEnabled,
+		"locationCached":    locationCached,
+		"proxyModule":       proxyModule,
+		"locationModule":    locationModule,
+		"grpcWebEnabled":    grpcWebEnabled,
+		"grpcWebName":       grpcWebName,
+		"grpcWebRedirect":   grpcWebRedirect,
+		"grpcWebContext":    grpcWebContext,
+		"jsonLogFormat":     jsonLogFormat,
+		"requestID":         requestID,
+		"tracingEnabled":    tracingEnabled,
+		"wafEnabled":        wafEnabled,
+		"appWhitelist":      appWhitelist,
+		"realIPCIDRs":       realIPCIDRs,
+		"cdnRealIPHeader":   cdnRealIPHeader,
+		"cdnRealIPCIDRs":    cdnRealIPCIDRs,
+		"wafRulesFile":      wafRulesFile,
+		"errorPagesDir":     errorPagesDir,
+		"faultInjections":   newFaultInjections,
+		"faultInjection":    newFaultInjection,
+		"proxyCacheZone":    proxyCacheZone,
+		"authCacheZone":     authCacheZone,
+		"authCacheKey":      authCacheKey,
+		"cacheBypass":       cacheBypass,
+	}).Parse(confTemplate)
+	if err != nil {
+		return err
+	}
+	if err := overrides.apply(tmpl); err != nil {
+		return err
+	}
+	routerConfig = sortedConfig(routerConfig)
+	var config bytes.Buffer
+	if err := tmpl.Execute(&config, routerConfig); err != nil {
+		return err
+	}
+	// The configuration may be written to a directory of its own, such as the staging directory,
+	// which does not exist until it is first written to.
+	appsPath := filepath.Join(filepath.Dir(filePath), appConfigDir)
+	if err := os.MkdirAll(appsPath, 0755); err != nil {
+		return err
+	}
+	if err := writeFileIfChanged(filePath, config.Bytes(), 0644); err != nil {
+		return err
+	}
+	fileNames, appConfigsByFile := groupAppConfigs(routerConfig.AppConfigs)
+	written := make(map[string]bool, len(fileNames))
+	for _, fileName := range fileNames {
+		var appConfig bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&appConfig, "app", withApps(routerConfig, appConfigsByFile[fileName])); err != nil {
+			return err
+		}
+		if err := writeFileIfChanged(filepath.Join(appsPath, fileName), appConfig.Bytes(), 0644); err != nil {
+			return err
+		}
+		written[fileName] = true
+	}
+	return removeStaleAppConfigs(appsPath, written)
+}
+
+// appConfigFileName returns the name of the file to which the provided application's configuration
+// is written.  Since namespaces cannot contain underscores, replacing the slash that separates an
+// application's namespace from the rest of its name with one keeps names distinct.
+func appConfigFileName(appConfig *model.AppConfig) string {
+	name := upstreamNameSanitizer.ReplaceAllString(strings.Replace(appConfig.Name, "/", "_", -1), "-")
+	return fmt.Sprintf("%s%s.conf", appConfigPrefix, name)
+}
+
+// groupAppConfigs groups the provided applications by the file to which each is written.
+// Applications built from the rules of a single ingress share a name, and therefore a file.  File
+// names are returned in the order in which they were first encountered.
+func groupAppConfigs(appConfigs []*model.AppConfig) ([]string, map[string][]*model.AppConfig) {
+	fileNames := []string{}
+	appConfigsByFile := map[string][]*model.AppConfig{}
+	for _, appConfig := range appConfigs {
+		fileName := appConfigFileName(appConfig)
+		if _, ok := appConfigsByFile[fileName]; !ok {
+			fileNames = append(fileNames, fileName)
+		}
+		appConfigsByFile[fileName] = append(appConfigsByFile[fileName], appConfig)
+	}
+	return fileNames, appConfigsByFile
+}
+
+// removeStaleAppConfigs removes the application files in the specified directory other than those
+// to be kept.
+func removeStaleAppConfigs(appsPath string, keep map[string]bool) error {
+	appConfigPaths, err := filepath.Glob(filepath.Join(appsPath, appConfigPrefix+"*.conf"))
+	if err != nil {
+		return err
+	}
+	for _, appConfigPath := range appConfigPaths {
+		if keep[filepath.Base(appConfigPath)] {
+			continue
+		}
+		if err := os.Remove(appConfigPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileIfChanged writes the provided data to the specified file, unless the file already holds
+// exactly that data.  A file that does not yet exist is created with the provided permissions.
+func writeFileIfChanged(filePath string, data []byte, perm os.FileMode) error {
+	existing, err := ioutil.ReadFile(filePath)
+	if err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+	return ioutil.WriteFile(filePath, data, perm)
+}
+
+// Install replaces the configuration at livePath, including each application's file, with the
+// configuration at stagedPath.  Only files that differ are rewritten.
+func Install(stagedPath string, livePath string) error {
+	stagedAppsPath := filepath.Join(filepath.Dir(stagedPath), appConfigDir)
+	liveAppsPath := filepath.Join(filepath.Dir(livePath), appConfigDir)
+	if err := os.MkdirAll(liveAppsPath, 0755); err != nil {
+		return err
+	}
+	stagedAppConfigPaths, err := filepath.Glob(filepath.Join(stagedAppsPath, appConfigPrefix+"*.conf"))
+	if err != nil {
+		return err
+	}
+	installed := map[string]bool{}
+	for _, stagedAppConfigPath := range stagedAppConfigPaths {
+		fileName := filepath.Base(stagedAppConfigPath)
+		if err := copyFileIfChanged(stagedAppConfigPath, filepath.Join(liveAppsPath, fileName)); err != nil {
+			return err
+		}
+		installed[fileName] = true
+	}
+	if err := removeStaleAppConfigs(liveAppsPath, installed); err != nil {
+		return err
+	}
+	// The main file is installed last, so that it never includes files that are yet to be installed.
+	return copyFileIfChanged(stagedPath, livePath)
+}
+
+func copyFileIfChanged(srcPath string, dstPath string) error {
+	data, err := ioutil.ReadFile(srcPath)
 	if err != nil {
 		return err
 	}
-	err = tmpl.Execute(file, routerConfig)
-	return err
+	return writeFileIfChanged(dstPath, data, 0644)
 }
@@ -1,12 +1,18 @@
 package nginx
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/deis/router/model"
 )
@@ -40,6 +46,7 @@ func TestWriteCerts(t *testing.T) {
 	expectedExampleCrt := "examplecom-crt"
 	expectedExampleKey := "examplecom-key"
 	expectedClientCert := "qwert\nyuiop\nasd\nfgh\njkl"
+	expectedExampleClientCA := "examplecom-client-ca"
 	routerConfig := model.RouterConfig{
 		PlatformCertificate: &model.Certificate{
 			Cert: expectedPlatformCrt,
@@ -53,6 +60,9 @@ func TestWriteCerts(t *testing.T) {
 						Key:  expectedExampleKey,
 					},
 				},
+				ClientVerifications: map[string]*model.ClientVerification{
+					"example.com": &model.ClientVerification{CABundle: expectedExampleClientCA},
+				},
 			},
 		},
 		ClientCertificates: []string{
@@ -96,6 +106,60 @@ func TestWriteCerts(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
+
+	// example application client CA bundle should exist with correct permissions and contents.
+	exampleClientCAPath := filepath.Join(sslPath, "example.com.client.ca.crt")
+	err = checkCert(exampleClientCAPath, expectedExampleClientCA)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriteCertsUnchanged(t *testing.T) {
+	sslPath, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(sslPath)
+
+	routerConfig := model.RouterConfig{
+		PlatformCertificate: &model.Certificate{Cert: "platform-crt", Key: "platform-key"},
+		AppConfigs: []*model.AppConfig{
+			&model.AppConfig{
+				Domains:        []string{"example.com"},
+				Certificates:   map[string]*model.Certificate{"example.com": &model.Certificate{Cert: "example-crt", Key: "example-key"}},
+				BasicAuthUsers: []string{"alice:{PLAIN}password"},
+			},
+		},
+	}
+	if err := WriteCerts(&routerConfig, sslPath); err != nil {
+		t.Fatal(err)
+	}
+	// Files whose contents are unchanged are left untouched, so their modification times are kept.
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	paths, err := filepath.Glob(filepath.Join(sslPath, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		if err := os.Chtimes(path, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+	routerConfig.AppConfigs[0].Certificates["example.com"].Cert = "renewed-crt"
+	if err := WriteCerts(&routerConfig, sslPath); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rewritten := !info.ModTime().Equal(past)
+		if expected := filepath.Base(path) == "example.com.crt"; rewritten != expected {
+			t.Errorf("Expected %s to be rewritten %t, but it was %t.", filepath.Base(path), expected, rewritten)
+		}
+	}
 }
 
 func TestWriteCert(t *testing.T) {
@@ -187,78 +251,4166 @@ func TestWriteDHParam(t *testing.T) {
 	}
 }
 
+func TestWriteECHKeys(t *testing.T) {
+	sslPath, err := ioutil.TempDir("", "ech")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(sslPath)
+	echPath := filepath.Join(sslPath, "ech")
+	if err := os.MkdirAll(echPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(echPath, "stale.ech"), []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	routerConfig := model.RouterConfig{
+		SSLConfig: &model.SSLConfig{ECH: true, ECHKeys: map[string]string{"example.com.ech": "key"}},
+	}
+	if err := WriteECHKeys(&routerConfig, sslPath); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(echPath, "example.com.ech"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().String(); perm != "-rw-------" {
+		t.Errorf("Expected permission on the ECH key to be -rw-------, but got %s.", perm)
+	}
+	if _, err := os.Stat(filepath.Join(echPath, "stale.ech")); err == nil {
+		t.Errorf("Expected keys that are no longer needed to be deleted, but stale.ech was found.")
+	}
+
+	routerConfig.SSLConfig.ECH = false
+	if err := WriteECHKeys(&routerConfig, sslPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(echPath); err == nil {
+		t.Errorf("Expected the ECH directory to be deleted when ECH is disabled, but it was found.")
+	}
+}
+
 func TestWriteConfig(t *testing.T) {
 	routerConfig := model.RouterConfig{}
 	routerConfig.GzipConfig = &model.GzipConfig{}
 	routerConfig.SSLConfig = &model.SSLConfig{}
 	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
 
-	tmpFile, err := ioutil.TempFile("", "test")
+	dir, err := ioutil.TempDir("", "test")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-	defer os.Remove(tmpFile.Name())
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "nginx.conf")
 
-	err = WriteConfig(&routerConfig, tmpFile.Name())
+	err = WriteConfig(&routerConfig, filePath)
 	if err != nil {
 		t.Error("Config template engine failed:", err)
 	}
 
-	if _, err := os.Stat(tmpFile.Name()); os.IsNotExist(err) {
+	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		t.Errorf("Expected to find nginx config file. No file found.")
 	}
 }
 
-func checkCertAndKey(crtPath string, keyPath string, expectedCertContents string, expectedKeyContents string) error {
-	err := checkCert(crtPath, expectedCertContents)
+func TestWriteConfigNewDirectory(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{Name: "foo/bar", Domains: []string{"bar.example.com"}, SSLConfig: &model.SSLConfig{}},
+	}
+
+	dir, err := ioutil.TempDir("", "test")
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
-	err2 := checkKey(keyPath, expectedKeyContents)
-	if err2 != nil {
-		return err2
+	defer os.RemoveAll(dir)
+	// Staged and shadow configuration are written to directories that do not exist at first.
+	filePath := filepath.Join(dir, "staged", "nginx.conf")
+
+	if err := WriteConfig(&routerConfig, filePath); err != nil {
+		t.Fatal("Failed to write config to a new directory:", err)
 	}
+	for _, path := range []string{filePath, filepath.Join(dir, "staged", appConfigDir, appConfigFileName(routerConfig.AppConfigs[0]))} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected to find %s, but got %v", path, err)
+		}
+	}
+}
 
-	return nil
+func TestWriteConfigAppFiles(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{Name: "foo/bar", Domains: []string{"bar.example.com"}, SSLConfig: &model.SSLConfig{}},
+		&model.AppConfig{Name: "foo/ingress", Domains: []string{"a.example.com"}, SSLConfig: &model.SSLConfig{}},
+		&model.AppConfig{Name: "foo/ingress", Domains: []string{"b.example.com"}, SSLConfig: &model.SSLConfig{}},
+	}
+	dir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "nginx.conf")
+	staleAppPath := filepath.Join(dir, "conf.d", "app-foo_gone.conf")
+	if err := os.MkdirAll(filepath.Dir(staleAppPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(staleAppPath, []byte("server {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteConfig(&routerConfig, filePath); err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	config, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(config), "include conf.d/*.conf;") {
+		t.Errorf("Expected nginx config to include the applications' files.")
+	}
+	if strings.Contains(string(config), "server_name bar.example.com;") {
+		t.Errorf("Expected applications to be configured outside of the main file.")
+	}
+	barConfig, err := ioutil.ReadFile(filepath.Join(dir, "conf.d", "app-foo_bar.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(barConfig), "server_name bar.example.com;") {
+		t.Errorf("Expected the application's file to configure its domain.")
+	}
+	// Applications built from a single ingress share a file.
+	ingressConfig, err := ioutil.ReadFile(filepath.Join(dir, "conf.d", "app-foo_ingress.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := strings.Count(string(ingressConfig), "server {"); count != 2 {
+		t.Errorf("Expected 2 servers in the ingress's file, but found %d.", count)
+	}
+	if _, err := os.Stat(staleAppPath); !os.IsNotExist(err) {
+		t.Errorf("Expected the file of an application that no longer exists to be removed.")
+	}
 }
 
-func checkCert(crtPath string, expectedCertContents string) error {
-	actualCertContents, err := ioutil.ReadFile(crtPath)
+func TestInstall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test")
 	if err != nil {
-		return err
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stagedPath := filepath.Join(dir, "staged", "nginx.conf")
+	livePath := filepath.Join(dir, "nginx.conf")
+	files := map[string]string{
+		stagedPath: "http { include conf.d/*.conf; }",
+		filepath.Join(dir, "staged", "conf.d", "app-foo.conf"): "server { listen 8080; }",
+		filepath.Join(dir, "conf.d", "app-bar.conf"):           "server { listen 8080; }",
+	}
+	for path, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
 	}
 
-	if !reflect.DeepEqual(expectedCertContents, string(actualCertContents)) {
-		return fmt.Errorf("Expected test.crt contents, %s, does not match actual contents, %s.", expectedCertContents, string(actualCertContents))
+	if err := Install(stagedPath, livePath); err != nil {
+		t.Fatal(err)
 	}
+	if config, err := ioutil.ReadFile(livePath); err != nil || string(config) != files[stagedPath] {
+		t.Errorf("Expected the staged configuration to be installed, but got %q (%v).", config, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "conf.d", "app-foo.conf")); err != nil {
+		t.Errorf("Expected the staged application's file to be installed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "conf.d", "app-bar.conf")); !os.IsNotExist(err) {
+		t.Errorf("Expected the file of an application that is no longer staged to be removed.")
+	}
+}
 
-	expectedCertPerm := "-rw-r--r--" // 0644
+func TestWriteConfigLocations(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:           "foo",
+			Domains:        []string{"foo.example.com"},
+			ConnectTimeout: "30s",
+			TCPTimeout:     "1300s",
+			ServiceIP:      "1.2.3.4",
+			ServicePort:    80,
+			Available:      true,
+			SSLConfig:      &model.SSLConfig{},
+			Locations: []*model.LocationConfig{
+				&model.LocationConfig{
+					Path:           "/uploads",
+					ConnectTimeout: "30s",
+					TCPTimeout:     "1300s",
+					BodySize:       "1g",
+					ServiceIP:      "1.2.3.4",
+					ServicePort:    80,
+					Available:      true,
+				},
+				&model.LocationConfig{
+					Path:           "/api",
+					ConnectTimeout: "30s",
+					TCPTimeout:     "1300s",
+					ServiceIP:      "5.6.7.8",
+					ServicePort:    8080,
+					Available:      true,
+				},
+			},
+		},
+	}
 
-	crtInfo, _ := os.Stat(crtPath)
-	actualCertPerm := crtInfo.Mode().String()
-	if !reflect.DeepEqual(expectedCertPerm, actualCertPerm) {
-		return fmt.Errorf("Expected permission on test.crt, %s, does not match actual, %s.", expectedCertPerm, actualCertPerm)
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
 	}
 
-	return nil
+	for _, expected := range []string{"location /uploads {", "client_max_body_size 1g;", "location / {", "proxy_pass http://1.2.3.4:80;", "location /api {", "proxy_pass http://5.6.7.8:8080;"} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
 }
 
-func checkKey(keyPath string, expectedKeyContents string) error {
-	actualKeyContents, err := ioutil.ReadFile(keyPath)
+func TestWriteConfigDenylist(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.DefaultWhitelist = []string{"10.0.0.0/8"}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:        "foo",
+			Domains:     []string{"foo.example.com"},
+			ServiceIP:   "1.2.3.4",
+			ServicePort: 80,
+			Available:   true,
+			SSLConfig:   &model.SSLConfig{},
+			Denylist:    []string{"10.1.0.0/16", "2001:db8::/32"},
+			Locations: []*model.LocationConfig{
+				&model.LocationConfig{Path: "/admin", Whitelist: []string{"10.2.0.0/16"}, ServiceIP: "1.2.3.4", ServicePort: 80, Available: true},
+			},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
 	if err != nil {
-		return err
+		t.Fatal("Config template engine failed:", err)
 	}
-	if !reflect.DeepEqual(expectedKeyContents, string(actualKeyContents)) {
-		return fmt.Errorf("Expected test.key contents, %s, does not match actual contents, %s.", expectedKeyContents, string(actualKeyContents))
+	// Denied addresses must be refused before any whitelist can allow them, both for the server and
+	// for the location whose own whitelist replaces the server's access rules.
+	if count := strings.Count(config, "deny 2001:db8::/32;"); count != 2 {
+		t.Fatalf("Expected the denylist to appear twice, but found it %d times.", count)
+	}
+	serverDeny := strings.Index(config, "deny 10.1.0.0/16;")
+	locationDeny := strings.LastIndex(config, "deny 10.1.0.0/16;")
+	if serverDeny > strings.Index(config, "allow 10.0.0.0/8;") {
+		t.Error("Expected the denylist to precede the server's whitelist.")
+	}
+	if locationDeny < strings.Index(config, "location /admin {") || locationDeny > strings.Index(config, "allow 10.2.0.0/16;") {
+		t.Error("Expected the denylist to precede the location's whitelist.")
 	}
+}
 
-	expectedKeyPerm := "-rw-------" // 0600
+func TestWriteConfigRateLimit(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:        "foo",
+			Domains:     []string{"foo.example.com", "www.foo.example.com"},
+			ServiceIP:   "1.2.3.4",
+			ServicePort: 80,
+			Available:   true,
+			SSLConfig:   &model.SSLConfig{},
+			RateLimitConfig: &model.RateLimitConfig{
+				Rate:        "10r/s",
+				Burst:       20,
+				NoDelay:     true,
+				Connections: 5,
+				Key:         "ip",
+				ZoneSize:    "10m",
+			},
+		},
+		&model.AppConfig{
+			Name:            "bar",
+			Domains:         []string{"bar.example.com"},
+			SSLConfig:       &model.SSLConfig{},
+			RateLimitConfig: &model.RateLimitConfig{Rate: "600r/m", Key: "header:X-Api-Key", ZoneSize: "1m"},
+		},
+		&model.AppConfig{
+			Name:                    "baz",
+			Domains:                 []string{"baz.example.com"},
+			SSLConfig:               &model.SSLConfig{},
+			RateLimitConfig:         &model.RateLimitConfig{Key: "ip", ZoneSize: "10m"},
+			RateLimitResponseConfig: &model.RateLimitResponseConfig{Status: 429},
+		},
+	}
 
-	keyInfo, _ := os.Stat(keyPath)
-	actualKeyPerm := keyInfo.Mode().String()
-	if !reflect.DeepEqual(expectedKeyPerm, actualKeyPerm) {
-		return fmt.Errorf("Expected permission on test.key, %s, does not match actual, %s.", expectedKeyPerm, actualKeyPerm)
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	fooZone := rateLimitZone(routerConfig.AppConfigs[0])
+	barZone := rateLimitZone(routerConfig.AppConfigs[1])
+	for _, expected := range []string{
+		"limit_req_zone $binary_remote_addr zone=" + fooZone + "_req:10m rate=10r/s;",
+		"limit_conn_zone $binary_remote_addr zone=" + fooZone + "_conn:10m;",
+		"limit_req zone=" + fooZone + "_req burst=20 nodelay;",
+		"limit_conn " + fooZone + "_conn 5;",
+		"limit_req_zone $http_x_api_key zone=" + barZone + "_req:1m rate=600r/m;",
+		"limit_req zone=" + barZone + "_req;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q, but it did not.", expected)
+		}
+	}
+	// Zones are declared once per application, however many domains it has, and only by
+	// applications that limit anything.
+	if count := strings.Count(config, "limit_req_zone"); count != 2 {
+		t.Errorf("Expected 2 request limiting zones, but found %d.", count)
+	}
+	if count := strings.Count(config, "limit_req zone="+fooZone+"_req"); count != 2 {
+		t.Errorf("Expected the request limit to apply to both of foo's domains, but found %d.", count)
+	}
+	if strings.Contains(config, "limit_conn_zone $http_x_api_key") {
+		t.Error("Expected no connection limiting zone for an application that does not limit connections.")
 	}
+	if strings.Contains(config, "@rate_limited") {
+		t.Error("Expected no response to rate-limited requests for an application that limits nothing.")
+	}
+}
 
-	return nil
+func TestWriteConfigProxyCache(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:        "foo",
+			Domains:     []string{"foo.example.com"},
+			ServiceIP:   "1.2.3.4",
+			ServicePort: 80,
+			Available:   true,
+			SSLConfig:   &model.SSLConfig{},
+			ProxyCacheConfig: &model.ProxyCacheConfig{
+				Enabled:  true,
+				ZoneSize: "10m",
+				MaxSize:  "1g",
+				Inactive: "10m",
+				Valid:    map[string]string{"404": "1m", "200": "10m"},
+				Key:      "$scheme$host$request_uri",
+				Bypass:   []string{"cookie:session", "header:X-No-Cache"},
+			},
+		},
+		&model.AppConfig{
+			Name:             "bar",
+			Domains:          []string{"bar.example.com"},
+			ServiceIP:        "5.6.7.8",
+			ServicePort:      80,
+			Available:        true,
+			SSLConfig:        &model.SSLConfig{},
+			ProxyCacheConfig: &model.ProxyCacheConfig{},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	zone := proxyCacheZone(routerConfig.AppConfigs[0])
+	for _, expected := range []string{
+		"proxy_cache_path /opt/router/cache/" + zone + " levels=1:2 keys_zone=" + zone + ":10m max_size=1g inactive=10m use_temp_path=off;",
+		"proxy_buffering on;",
+		"proxy_cache " + zone + ";",
+		"proxy_cache_key $scheme$host$request_uri;",
+		"proxy_cache_valid 200 10m;\n\t\t\tproxy_cache_valid 404 1m;",
+		"proxy_cache_bypass $cookie_session $http_x_no_cache;",
+		"proxy_no_cache $cookie_session $http_x_no_cache;",
+		"add_header X-Cache-Status $upstream_cache_status always;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q, but it did not.", expected)
+		}
+	}
+	// Only one application caches its responses; the other's responses are not even buffered.
+	if count := strings.Count(config, "proxy_cache_path"); count != 1 {
+		t.Errorf("Expected 1 cache, but found %d.", count)
+	}
+	if !strings.Contains(config, "proxy_buffering off;") {
+		t.Error("Expected responses of applications that do not cache them to be unbuffered.")
+	}
+}
+
+func TestWriteConfigProxyCacheNormalizeAcceptEncoding(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{Enabled: true, Vary: "off"}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:        "foo",
+			Domains:     []string{"foo.example.com"},
+			ServiceIP:   "1.2.3.4",
+			ServicePort: 80,
+			Available:   true,
+			SSLConfig:   &model.SSLConfig{},
+			ProxyCacheConfig: &model.ProxyCacheConfig{
+				Enabled:                 true,
+				Key:                     "$scheme$host$request_uri",
+				NormalizeAcceptEncoding: true,
+			},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"map $http_accept_encoding $deis_accept_encoding {",
+		"proxy_cache_key $scheme$host$request_uri:$deis_accept_encoding;",
+		"proxy_set_header Accept-Encoding $deis_accept_encoding;",
+		"gzip_vary on;",
+		"add_header Vary $deis_vary_accept_encoding;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q, but it did not.", expected)
+		}
+	}
+
+	routerConfig.AppConfigs[0].ProxyCacheConfig.NormalizeAcceptEncoding = false
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, unexpected := range []string{"proxy_set_header Accept-Encoding", "gzip_vary on;", "add_header Vary"} {
+		if strings.Contains(config, unexpected) {
+			t.Errorf("Expected nginx config not to contain %q, but it did.", unexpected)
+		}
+	}
+}
+
+func TestWriteConfigConditionalRequests(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	appConfig := &model.AppConfig{
+		Name:              "foo",
+		Domains:           []string{"foo.example.com"},
+		ServiceIP:         "1.2.3.4",
+		ServicePort:       80,
+		Available:         true,
+		SSLConfig:         &model.SSLConfig{},
+		ConditionalConfig: &model.ConditionalConfig{ETag: true, IfModifiedSince: "before"},
+		ProxyCacheConfig: &model.ProxyCacheConfig{
+			Enabled:    true,
+			Key:        "$scheme$host$request_uri",
+			Revalidate: true,
+		},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{"etag on;", "if_modified_since before;", "proxy_cache_revalidate on;"} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q, but it did not.", expected)
+		}
+	}
+	if strings.Contains(config, "proxy_hide_header ETag;") {
+		t.Error("Expected the application's ETags to be passed on, but they were hidden.")
+	}
+
+	appConfig.ConditionalConfig.ETag = false
+	appConfig.ProxyCacheConfig.Revalidate = false
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{"etag off;", "proxy_hide_header ETag;"} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q, but it did not.", expected)
+		}
+	}
+	if strings.Contains(config, "proxy_cache_revalidate") {
+		t.Error("Expected expired responses not to be revalidated, but they were.")
+	}
+}
+
+func TestWriteConfigRanges(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	appConfig := &model.AppConfig{
+		Name:         "foo",
+		Domains:      []string{"foo.example.com"},
+		ServiceIP:    "1.2.3.4",
+		ServicePort:  80,
+		Available:    true,
+		SSLConfig:    &model.SSLConfig{},
+		RangesConfig: &model.RangesConfig{Force: true, Max: "4"},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{"proxy_force_ranges on;", "max_ranges 4;"} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q, but it did not.", expected)
+		}
+	}
+
+	appConfig.RangesConfig = &model.RangesConfig{}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, unexpected := range []string{"proxy_force_ranges", "max_ranges"} {
+		if strings.Contains(config, unexpected) {
+			t.Errorf("Expected nginx config not to contain %q, but it did.", unexpected)
+		}
+	}
+}
+
+func TestWriteConfigAllowedResponseHeaders(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	appConfig := &model.AppConfig{
+		Name:                   "foo",
+		Domains:                []string{"foo.example.com"},
+		ServiceIP:              "1.2.3.4",
+		ServicePort:            80,
+		Available:              true,
+		SSLConfig:              &model.SSLConfig{},
+		AllowedResponseHeaders: []string{"location", "Set-Cookie"},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	// Allowed headers are matched whatever their case, and those without which a response cannot be
+	// read are always allowed.
+	for _, expected := range []string{
+		"js_import /opt/router/njs/transforms.js;",
+		`set $deis_allowed_response_headers "location,set-cookie,content-encoding,content-length,content-range,content-type,grpc-encoding,grpc-message,grpc-status,transfer-encoding";`,
+		"js_header_filter transforms.deisAllowResponseHeaders;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q, but it did not.", expected)
+		}
+	}
+
+	// gRPC applications' headers are filtered alike.
+	appConfig.BackendProtocol = "grpc"
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "js_header_filter transforms.deisAllowResponseHeaders;") {
+		t.Error("Expected a gRPC application's headers to be filtered, but they were not.")
+	}
+
+	appConfig.AllowedResponseHeaders = nil
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "js_header_filter") || strings.Contains(config, "js_import") {
+		t.Error("Expected no headers to be filtered without an allowlist, but they were.")
+	}
+}
+
+func TestWriteConfigDefaultBackend(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "location / {\n\t\t\treturn 404;\n\t\t}") {
+		t.Error("Expected requests for unclaimed domains to be answered with a 404 without a default backend.")
+	}
+
+	routerConfig.DefaultBackend = &model.DefaultBackend{
+		ServiceIP:   "1.2.3.4",
+		ServicePort: 80,
+		Available:   true,
+		Endpoints:   []string{"10.0.0.2:8080", "10.0.0.1:8080"},
+	}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"upstream deis-default-backend {\n\t\tserver 10.0.0.1:8080;\n\t\tserver 10.0.0.2:8080;",
+		"proxy_pass http://deis-default-backend;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q, but it did not.", expected)
+		}
+	}
+
+	routerConfig.DefaultBackend = &model.DefaultBackend{ServiceIP: "1.2.3.4", ServicePort: 80}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "deis-default-backend") || !strings.Contains(config, "location / {\n\t\t\treturn 503;") {
+		t.Error("Expected requests for unclaimed domains to be answered with a 503 while the default backend is unavailable.")
+	}
+}
+
+func TestWriteConfigErrorPages(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:        "foo",
+			Domains:     []string{"foo.example.com"},
+			ServiceIP:   "1.2.3.4",
+			ServicePort: 80,
+			Available:   true,
+			Maintenance: true,
+			SSLConfig:   &model.SSLConfig{},
+			ErrorPages:  map[string]string{"404": "<h1>Not here</h1>", "503": "<h1>Back soon</h1>"},
+		},
+		&model.AppConfig{
+			Name:        "bar",
+			Domains:     []string{"bar.example.com"},
+			ServiceIP:   "5.6.7.8",
+			ServicePort: 80,
+			Available:   true,
+			Maintenance: true,
+			SSLConfig:   &model.SSLConfig{},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"error_page 404 /_deis_errors/404.html;",
+		"error_page 503 /_deis_errors/503.html;",
+		"alias /opt/router/errors/" + errorPagesDir(routerConfig.AppConfigs[0]) + "/;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q, but it did not.", expected)
+		}
+	}
+	// Only the application without a custom 503 page falls back to the default maintenance page.
+	if count := strings.Count(config, "error_page 503 @maintenance;"); count != 1 {
+		t.Errorf("Expected 1 application to serve the default maintenance page, but found %d.", count)
+	}
+	if count := strings.Count(config, "location ^~ /_deis_errors/"); count != 1 {
+		t.Errorf("Expected 1 application to serve custom error pages, but found %d.", count)
+	}
+}
+
+func TestWriteConfigFaultInjection(t *testing.T) {
+	routerConfig := model.RouterConfig{FaultInjectionEnabled: true}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:                 "foo",
+			Domains:              []string{"foo.example.com"},
+			ServiceIP:            "1.2.3.4",
+			ServicePort:          80,
+			Available:            true,
+			SSLConfig:            &model.SSLConfig{},
+			FaultInjectionConfig: &model.FaultInjectionConfig{Delay: "2s", DelayPercent: 10, AbortPercent: 100, AbortStatus: 502},
+		},
+		&model.AppConfig{
+			Name:                 "bar",
+			Domains:              []string{"bar.example.com"},
+			ServiceIP:            "5.6.7.8",
+			ServicePort:          80,
+			Available:            true,
+			SSLConfig:            &model.SSLConfig{},
+			ExternalAuthConfig:   &model.ExternalAuthConfig{URL: "http://auth.example.com/verify"},
+			FaultInjectionConfig: &model.FaultInjectionConfig{Delay: "2s", DelayPercent: 10},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	id := locationID(routerConfig.AppConfigs[0], "")
+	for _, expected := range []string{
+		"split_clients \"${request_id}delay\" $fault_delay_" + id + " {\n\t\t10% 1;\n\t\t* \"\";\n\t}",
+		"split_clients \"${request_id}abort\" $fault_abort_" + id + " {\n\t\t* 1;\n\t}",
+		"if ($fault_delay_" + id + " = \"\") {",
+		"proxy_pass http://127.0.0.1:9093/?duration=2s;",
+		"if ($fault_abort_" + id + ") {\n\t\t\t\treturn 502;",
+		"auth_request /_deis_fault_delay;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q, but it did not.", expected)
+		}
+	}
+	// Delays cannot be injected into the externally authenticated application's requests.
+	if count := strings.Count(config, "split_clients"); count != 2 {
+		t.Errorf("Expected 2 split_clients blocks, but found %d.", count)
+	}
+
+	routerConfig.FaultInjectionEnabled = false
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "$fault_") || strings.Contains(config, "_deis_fault_delay") {
+		t.Error("Expected no faults to be injected when the router does not permit it.")
+	}
+}
+
+func TestWriteErrorPages(t *testing.T) {
+	errorPagesPath, err := ioutil.TempDir("", "errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(errorPagesPath)
+	stalePath := filepath.Join(errorPagesPath, "errors_baz-01234567")
+	if err := os.MkdirAll(stalePath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	appConfig := &model.AppConfig{Name: "foo", Domains: []string{"foo.com"}, ErrorPages: map[string]string{"404": "<h1>Not here</h1>"}}
+	stalePagePath := filepath.Join(errorPagesPath, errorPagesDir(appConfig), "503.html")
+	if err := os.MkdirAll(filepath.Dir(stalePagePath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(stalePagePath, []byte("<h1>Down</h1>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	routerConfig := &model.RouterConfig{AppConfigs: []*model.AppConfig{appConfig, &model.AppConfig{Name: "bar", Domains: []string{"bar.com"}}}}
+	if err := WriteErrorPages(routerConfig, errorPagesPath); err != nil {
+		t.Fatal(err)
+	}
+	// Pages the configuration in effect may use are kept until nginx is reloaded.
+	if _, err := os.Stat(stalePath); err != nil {
+		t.Errorf("Expected the stale error pages directory to be kept until nginx is reloaded: %v", err)
+	}
+	if err := RemoveStaleErrorPages(routerConfig, errorPagesPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stalePagePath); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale 503 page to be removed, but got %v", err)
+	}
+	dirs, err := filepath.Glob(filepath.Join(errorPagesPath, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := filepath.Join(errorPagesPath, errorPagesDir(appConfig))
+	if len(dirs) != 1 || dirs[0] != expected {
+		t.Fatalf("Expected only the error pages directory %s, but got %v", expected, dirs)
+	}
+	page, err := ioutil.ReadFile(filepath.Join(expected, "404.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(page) != "<h1>Not here</h1>" {
+		t.Errorf("Expected the 404 page to be written, but got %q", page)
+	}
+}
+
+func TestWriteWAFRules(t *testing.T) {
+	wafPath, err := ioutil.TempDir("", "waf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wafPath)
+	stalePath := filepath.Join(wafPath, "waf_baz-01234567.conf")
+	if err := ioutil.WriteFile(stalePath, []byte("SecRuleRemoveById 942100"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	appConfig := &model.AppConfig{Name: "foo", Domains: []string{"foo.com"}, WAFConfig: &model.WAFConfig{Enabled: true, Rules: map[string]string{
+		"b.conf": "SecRuleRemoveById 920350",
+		"a.conf": "SecRule REQUEST_URI \"@beginsWith /admin\" \"id:10001,phase:1,deny\"",
+	}}}
+	disabledAppConfig := &model.AppConfig{Name: "bar", Domains: []string{"bar.com"}, WAFConfig: &model.WAFConfig{Rules: map[string]string{"a.conf": "SecRuleEngine Off"}}}
+	routerConfig := &model.RouterConfig{AppConfigs: []*model.AppConfig{appConfig, disabledAppConfig}}
+	if err := WriteWAFRules(routerConfig, wafPath); err != nil {
+		t.Fatal(err)
+	}
+	files, err := filepath.Glob(filepath.Join(wafPath, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rulesPath := filepath.Join(wafPath, wafRulesFile(appConfig))
+	mainRulesPath := filepath.Join(wafPath, "main.conf")
+	// Stale rules are kept until nginx has been reloaded without them.
+	if len(files) != 3 || files[0] != mainRulesPath || files[1] != stalePath || files[2] != rulesPath {
+		t.Fatalf("Expected %s, %s, and %s, but got %v", mainRulesPath, stalePath, rulesPath, files)
+	}
+	if err := RemoveStaleWAFRules(routerConfig, wafPath); err != nil {
+		t.Fatal(err)
+	}
+	if files, err = filepath.Glob(filepath.Join(wafPath, "*")); err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0] != mainRulesPath || files[1] != rulesPath {
+		t.Fatalf("Expected only %s and %s, but got %v", mainRulesPath, rulesPath, files)
+	}
+	rules, err := ioutil.ReadFile(rulesPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# a.conf\nSecRule REQUEST_URI \"@beginsWith /admin\" \"id:10001,phase:1,deny\"\n# b.conf\nSecRuleRemoveById 920350\n"
+	if string(rules) != expected {
+		t.Errorf("Expected the rules to be written in the order of their names, but got %q", rules)
+	}
+
+	// Once no application enables the WAF, no rules are left behind.
+	appConfig.WAFConfig.Enabled = false
+	if err := WriteWAFRules(routerConfig, wafPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveStaleWAFRules(routerConfig, wafPath); err != nil {
+		t.Fatal(err)
+	}
+	if files, err = filepath.Glob(filepath.Join(wafPath, "*")); err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("Expected no rules to be left, but got %v", files)
+	}
+}
+
+func TestCacheDirs(t *testing.T) {
+	cachePath, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cachePath)
+	routerConfig := &model.RouterConfig{
+		AppConfigs: []*model.AppConfig{
+			&model.AppConfig{Name: "foo", Domains: []string{"www.foo.com", "foo.com"}, ProxyCacheConfig: &model.ProxyCacheConfig{Enabled: true}},
+			&model.AppConfig{Name: "bar", Domains: []string{"bar.com"}, ProxyCacheConfig: &model.ProxyCacheConfig{}},
+		},
+	}
+	stalePath := filepath.Join(cachePath, "cache_baz-01234567")
+	if err := os.MkdirAll(stalePath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCacheDirs(routerConfig, cachePath); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveStaleCacheDirs(routerConfig, cachePath); err != nil {
+		t.Fatal(err)
+	}
+	cacheDirs, err := filepath.Glob(filepath.Join(cachePath, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cache is named as it is in the rendered configuration, in which domains are sorted.
+	expected := filepath.Join(cachePath, proxyCacheZone(&model.AppConfig{Name: "foo", Domains: []string{"foo.com", "www.foo.com"}}))
+	if len(cacheDirs) != 1 || cacheDirs[0] != expected {
+		t.Errorf("Expected only the cache directory %s, but got %v", expected, cacheDirs)
+	}
+}
+
+func TestWriteConfigDebugBody(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:            "foo",
+			Domains:         []string{"foo.example.com"},
+			SSLConfig:       &model.SSLConfig{},
+			DebugBodyConfig: &model.DebugBodyConfig{Path: "/api", Size: 512, RedactPatterns: []string{`"password":"[^"]*"`}, Enabled: true},
+		},
+		&model.AppConfig{
+			Name:            "bar",
+			Domains:         []string{"bar.example.com"},
+			SSLConfig:       &model.SSLConfig{},
+			DebugBodyConfig: &model.DebugBodyConfig{Path: "/", Size: 512, RedactPatterns: []string{"secret"}},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+
+	for _, expected := range []string{
+		`"~^foo:/api" 1;`,
+		`"~(?s)^foo:(.{0,512})" $1;`,
+		`map "$app_name:$debug_body_0" $debug_body_1 {`,
+		`(?<debug_body_before>.*?)\"password\":\"[^\"]*\"(?<debug_body_after>.*)$"`,
+		`body: "$debug_body_1"`,
+		"access_log /tmp/logpipe debugbody if=$debug_body_enabled;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	// Apps without request body logging enabled should not be referenced.
+	if strings.Contains(config, "^bar:") {
+		t.Errorf("Expected nginx config not to capture request bodies for disabled apps.")
+	}
+	if strings.Count(config, "debugbody if=") != 1 {
+		t.Errorf("Expected request body logging to be enabled for exactly one app.")
+	}
+}
+
+func TestWriteConfigACME(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.ACMEConfig = &model.ACMEConfig{Enabled: true}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:        "foo",
+			Domains:     []string{"foo.example.com"},
+			SSLConfig:   &model.SSLConfig{},
+			ACME:        true,
+			ACMEDomains: []string{"foo.example.com"},
+		},
+		&model.AppConfig{
+			Name:      "bar",
+			Domains:   []string{"bar.example.com"},
+			SSLConfig: &model.SSLConfig{},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if count := strings.Count(config, "location /.well-known/acme-challenge/ {"); count != 1 {
+		t.Errorf("Expected exactly one app to answer ACME challenges, but found %d.", count)
+	}
+
+	// Challenges should not be answered at all if ACME is disabled router-wide.
+	routerConfig.ACMEConfig.Enabled = false
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "acme-challenge") {
+		t.Errorf("Expected no app to answer ACME challenges when ACME is disabled.")
+	}
+}
+
+func TestWriteConfigRateLimitResponse(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:        "foo",
+			Domains:     []string{"foo.example.com"},
+			ServiceIP:   "1.2.3.4",
+			ServicePort: 80,
+			Available:   true,
+			SSLConfig:   &model.SSLConfig{},
+			RateLimitConfig: &model.RateLimitConfig{
+				Connections: 5,
+				Key:         "ip",
+				ZoneSize:    "10m",
+			},
+			RateLimitResponseConfig: &model.RateLimitResponseConfig{
+				Status:     429,
+				RetryAfter: 30,
+				Body:       `{"error": "rate_limited"}`,
+			},
+			ErrorPages: map[string]string{"502": "<html></html>"},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "proxy_intercept_errors on;") || strings.Contains(config, "error_page 429") {
+		t.Errorf("Expected the application's own 429s not to be intercepted, but they were:\n%s", config)
+	}
+	for _, expected := range []string{
+		"limit_req_status 460;",
+		"error_page 460 =429 @rate_limited;",
+		"add_header Retry-After 30 always;",
+		"default_type application/json;",
+		`return 429 "{\"error\": \"rate_limited\"}";`,
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+}
+
+func TestWriteConfigBodySizeResponse(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:       "foo",
+			Domains:    []string{"foo.example.com"},
+			SSLConfig:  &model.SSLConfig{},
+			BodySize:   "10m",
+			ErrorPages: map[string]string{"413": "<html></html>"},
+			CORSConfig: &model.CORSConfig{
+				Enabled:       true,
+				AllowOrigins:  []string{"https://app.example.com"},
+				AllowMethods:  []string{"GET", "POST"},
+				ExposeHeaders: []string{"X-Upload-Id"},
+			},
+			ErrorFormat: "json",
+			BodySizeResponseConfig: &model.BodySizeResponseConfig{
+				Body: `{"error": "body_too_large"}`,
+				CORS: true,
+			},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	origin := corsOrigin(routerConfig.AppConfigs[0])
+	for _, expected := range []string{
+		"error_page 413 @body_too_large;",
+		fmt.Sprintf("location @body_too_large {\n\t\t\tadd_header Access-Control-Allow-Origin %s always;", origin),
+		`add_header Access-Control-Expose-Headers "X-Upload-Id" always;`,
+		`return 413 "{\"error\": \"body_too_large\"}";`,
+		"error_page 502 @deis_error_502;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	// nginx uses the first page given for a status.
+	if strings.Index(config, "error_page 413 @body_too_large;") > strings.Index(config, "error_page 413 /_deis_errors/413.html;") {
+		t.Error("Expected the customized 413 to precede the application's error page for 413, but it did not.")
+	}
+
+	// Without a body or CORS headers, nginx's own response is left as it is.
+	routerConfig.AppConfigs[0].BodySizeResponseConfig = &model.BodySizeResponseConfig{}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "@body_too_large") {
+		t.Error("Expected nginx config not to customize the 413, but it did.")
+	}
+}
+
+func TestWriteConfigEmergencyMode(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:      "foo",
+			Domains:   []string{"foo.example.com"},
+			Whitelist: []string{"1.2.3.4"},
+			ServiceIP: "1.2.3.4",
+			Available: true,
+			SSLConfig: &model.SSLConfig{},
+			Locations: []*model.LocationConfig{
+				&model.LocationConfig{Path: "/admin", Whitelist: []string{"5.6.7.8"}, Available: true},
+			},
+		},
+	}
+
+	routerConfig.EmergencyConfig = &model.EmergencyConfig{Mode: "static-503"}
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "proxy_pass") || !strings.Contains(config, "error_page 503 @maintenance;") {
+		t.Errorf("Expected all locations to respond with the maintenance page in static-503 mode.")
+	}
+
+	routerConfig.EmergencyConfig = &model.EmergencyConfig{Mode: "allowlist-only", Allowlist: []string{"10.0.0.0/8"}}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "allow 1.2.3.4;") || strings.Contains(config, "allow 5.6.7.8;") {
+		t.Errorf("Expected app and location whitelists to be replaced in allowlist-only mode.")
+	}
+	// Once for the server and once for each of its two locations.
+	if count := strings.Count(config, "allow 10.0.0.0/8;"); count != 3 {
+		t.Errorf("Expected the emergency allowlist to be applied 3 times, but found %d.", count)
+	}
+
+	routerConfig.EmergencyConfig = &model.EmergencyConfig{Mode: "off"}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "allow 1.2.3.4;") || !strings.Contains(config, "proxy_pass") {
+		t.Errorf("Expected normal routing when emergency mode is off.")
+	}
+}
+
+func TestWriteConfigEndpoints(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:                   "foo",
+			Domains:                []string{"foo.example.com"},
+			ServiceIP:              "1.2.3.4",
+			ServicePort:            80,
+			Endpoints:              []string{"10.0.0.1:3000", "10.0.0.2:3000"},
+			LoadBalancingAlgorithm: "least_conn",
+			Available:              true,
+			SSLConfig:              &model.SSLConfig{},
+			Locations: []*model.LocationConfig{
+				&model.LocationConfig{Path: "/api", ServiceIP: "5.6.7.8", ServicePort: 80, Available: true},
+			},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	name := upstreamName(routerConfig.AppConfigs[0], locationID(routerConfig.AppConfigs[0], "/"))
+	for _, expected := range []string{
+		fmt.Sprintf("upstream %s {", name),
+		"least_conn;",
+		"server 10.0.0.1:3000;",
+		"server 10.0.0.2:3000;",
+		fmt.Sprintf("proxy_pass http://%s;", name),
+		// Locations whose endpoints are unknown fall back to the service's cluster IP.
+		"proxy_pass http://5.6.7.8:80;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	if strings.Contains(config, "proxy_pass http://1.2.3.4:80;") {
+		t.Errorf("Expected the root location to proxy to its endpoints rather than its cluster IP.")
+	}
+}
+
+func TestWriteConfigAffinity(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:                   "foo",
+			Domains:                []string{"foo.example.com"},
+			Endpoints:              []string{"10.0.0.1:3000", "10.0.0.2:3000"},
+			LoadBalancingAlgorithm: "least_conn",
+			Affinity:               "cookie",
+			AffinityCookie:         "route",
+			Available:              true,
+			SSLConfig:              &model.SSLConfig{},
+		},
+		&model.AppConfig{
+			Name:           "bar",
+			Domains:        []string{"bar.example.com"},
+			Endpoints:      []string{"10.0.1.1:3000"},
+			Affinity:       "cookie",
+			AffinityCookie: "route",
+			Available:      true,
+			SSLConfig:      &model.SSLConfig{},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"map $cookie_route $affinity_key_route {",
+		`'' "route=$request_id; Path=/; HttpOnly";`,
+		"hash $affinity_key_route consistent;",
+		"add_header Set-Cookie $affinity_cookie_route;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	if strings.Contains(config, "least_conn;") {
+		t.Errorf("Expected cookie affinity to take precedence over the load-balancing algorithm.")
+	}
+	// Apps sharing a cookie name share its maps.
+	if count := strings.Count(config, "map $cookie_route $affinity_key_route {"); count != 1 {
+		t.Errorf("Expected the affinity maps for a cookie to be declared once, but found %d.", count)
+	}
+}
+
+func TestWriteConfigCanary(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	canary := &model.CanaryBackend{ServiceIP: "5.6.7.8", ServicePort: 80, Endpoints: []string{"10.0.1.1:3000"}}
+	appConfig := &model.AppConfig{
+		Name:         "foo",
+		Domains:      []string{"foo.example.com"},
+		ServiceIP:    "1.2.3.4",
+		ServicePort:  80,
+		Endpoints:    []string{"10.0.0.1:3000"},
+		Available:    true,
+		SSLConfig:    &model.SSLConfig{},
+		CanaryWeight: 5,
+		Canary:       canary,
+		Locations: []*model.LocationConfig{
+			&model.LocationConfig{Path: "/api", ServiceIP: "1.2.3.4", ServicePort: 80, Available: true, Canary: canary},
+			&model.LocationConfig{Path: "/other", ServiceIP: "9.9.9.9", ServicePort: 80, Available: true},
+		},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	rootID := locationID(appConfig, "/")
+	apiID := locationID(appConfig, "/api")
+	for _, expected := range []string{
+		fmt.Sprintf("split_clients $request_id $canary_%s {", rootID),
+		fmt.Sprintf("5%% %s;", upstreamName(appConfig, rootID+"-canary")),
+		fmt.Sprintf("* %s;", upstreamName(appConfig, rootID)),
+		fmt.Sprintf("upstream %s {", upstreamName(appConfig, rootID+"-canary")),
+		fmt.Sprintf("proxy_pass http://$canary_%s;", rootID),
+		// Without endpoints, the location and its canary are addressed by cluster IP.
+		fmt.Sprintf("split_clients $request_id $canary_%s {", apiID),
+		"* 1.2.3.4:80;",
+		fmt.Sprintf("proxy_pass http://$canary_%s;", apiID),
+		"proxy_pass http://9.9.9.9:80;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+}
+
+func TestWriteConfigWeightedBackends(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	appConfig := &model.AppConfig{
+		Name:        "foo",
+		Domains:     []string{"foo.example.com"},
+		ServiceIP:   "1.2.3.4",
+		ServicePort: 80,
+		Available:   true,
+		SSLConfig:   &model.SSLConfig{},
+		Locations: []*model.LocationConfig{
+			&model.LocationConfig{Path: "/shop", Available: true, WeightedBackends: []*model.WeightedBackend{
+				&model.WeightedBackend{Service: "shop-v1", Weight: 2, ServiceIP: "5.6.7.8", ServicePort: 80, Endpoints: []string{"10.0.1.1:3000"}},
+				&model.WeightedBackend{Service: "shop-v2", Weight: 1, ServiceIP: "5.6.7.9", ServicePort: 80},
+			}},
+			&model.LocationConfig{Path: "/cart", Available: true, WeightedBackends: []*model.WeightedBackend{
+				&model.WeightedBackend{Service: "cart", Weight: 1, ServiceIP: "9.9.9.9", ServicePort: 8080},
+			}},
+		},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	shopID := locationID(appConfig, "/shop")
+	for _, expected := range []string{
+		fmt.Sprintf("split_clients $request_id $split_%s {", shopID),
+		fmt.Sprintf("66.66%% %s;", upstreamName(appConfig, shopID+"-shop-v1")),
+		"* 5.6.7.9:80;",
+		fmt.Sprintf("upstream %s {", upstreamName(appConfig, shopID+"-shop-v1")),
+		fmt.Sprintf("proxy_pass http://$split_%s;", shopID),
+		// A location with a single backend needs no split.
+		"proxy_pass http://9.9.9.9:8080;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	if strings.Contains(config, "$split_"+locationID(appConfig, "/cart")) {
+		t.Errorf("Expected no split for a location with a single backend.")
+	}
+
+	// Backends whose share rounds down to nothing are left out.
+	backends := newWeightedBackends(appConfig, shopID, []*model.WeightedBackend{
+		&model.WeightedBackend{Service: "a", Weight: 1},
+		&model.WeightedBackend{Service: "b", Weight: 99999},
+	})
+	if len(backends) != 1 || backends[0].Percent != "" {
+		t.Errorf("Expected only the last backend, with the remaining share, but got %+v", backends)
+	}
+}
+
+func TestWriteConfigGRPC(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.RequestIDs = true
+	appConfig := &model.AppConfig{
+		Name:             "foo",
+		Domains:          []string{"foo.example.com"},
+		ServiceIP:        "1.2.3.4",
+		ServicePort:      50051,
+		Endpoints:        []string{"10.0.0.1:50051"},
+		Available:        true,
+		SSLConfig:        &model.SSLConfig{},
+		TCPTimeout:       "1h",
+		BackendProtocol:  "grpcs",
+		ProxyCacheConfig: &model.ProxyCacheConfig{Enabled: true},
+		ErrorPages:       map[string]string{"503": "<html></html>"},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		fmt.Sprintf("grpc_pass grpcs://%s;", upstreamName(appConfig, locationID(appConfig, "/"))),
+		"grpc_set_header X-Forwarded-For $remote_addr;",
+		"grpc_set_header X-Request-Id $request_id;",
+		"grpc_read_timeout 1h;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	for _, unexpected := range []string{"proxy_pass", "proxy_set_header", "proxy_http_version", "proxy_buffering", "proxy_cache", "proxy_intercept_errors"} {
+		if strings.Contains(config, unexpected) {
+			t.Errorf("Expected nginx config for a gRPC application not to contain \"%s\", but it did.", unexpected)
+		}
+	}
+
+	appConfig.BackendProtocol = "grpc"
+	appConfig.Endpoints = nil
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "grpc_pass grpc://1.2.3.4:50051;") {
+		t.Errorf("Expected requests to be proxied to the application's cluster IP over gRPC.")
+	}
+
+	// Applications that speak HTTP/2 cleartext are proxied to with the grpc module, without TLS.
+	appConfig.BackendProtocol = "h2c"
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "grpc_pass grpc://1.2.3.4:50051;") {
+		t.Errorf("Expected requests to be proxied to the application's cluster IP over HTTP/2 cleartext.")
+	}
+	if strings.Contains(config, "proxy_pass") {
+		t.Errorf("Expected requests not to be proxied over HTTP/1.1, but they were.")
+	}
+}
+
+func TestWriteConfigGRPCWeb(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	appConfig := &model.AppConfig{
+		Name:            "foo",
+		Domains:         []string{"foo.example.com"},
+		ServiceIP:       "1.2.3.4",
+		ServicePort:     50051,
+		Endpoints:       []string{"10.0.0.1:50051"},
+		Available:       true,
+		SSLConfig:       &model.SSLConfig{},
+		TCPTimeout:      "1h",
+		BackendProtocol: "grpc",
+		GRPCWeb:         true,
+		ErrorPages:      map[string]string{"502": "<html></html>"},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	name := grpcWebName(newLocationContext(&routerConfig, appConfig, nil))
+	upstream := upstreamName(appConfig, locationID(appConfig, "/"))
+	for _, expected := range []string{
+		"\"~^application/grpc-web\" 1;",
+		"listen 127.0.0.1:9097;",
+		"grpc_pass $http_x_deis_grpc_web_upstream;",
+		fmt.Sprintf("error_page 418 = %s;", name),
+		// The server's error pages are repeated in the location, which has one of its own.
+		"error_page 502 /_deis_errors/502.html;\n\t\t\terror_page 418",
+		fmt.Sprintf("location %s {", name),
+		fmt.Sprintf("proxy_set_header X-Deis-Grpc-Web-Upstream grpc://%s;\n\t\t\tproxy_pass http://127.0.0.1:9096;", upstream),
+		"proxy_set_header X-Forwarded-For $remote_addr;",
+		"proxy_read_timeout 1h;",
+		fmt.Sprintf("grpc_pass grpc://%s;", upstream),
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q, but it did not.", expected)
+		}
+	}
+	if strings.Contains(config, "proxy_intercept_errors") {
+		t.Error("Expected errors from translated requests to be passed on as they are, but they were intercepted.")
+	}
+
+	// Only gRPC applications' requests are translated.
+	appConfig.BackendProtocol = "h2c"
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, unexpected := range []string{"deis_grpc_web", "9096", "9097"} {
+		if strings.Contains(config, unexpected) {
+			t.Errorf("Expected nginx config not to contain %q, but it did.", unexpected)
+		}
+	}
+}
+
+func TestWriteConfigHealthCheck(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	appConfig := &model.AppConfig{
+		Name:              "foo",
+		Domains:           []string{"foo.example.com"},
+		ServiceIP:         "1.2.3.4",
+		ServicePort:       80,
+		Endpoints:         []string{"10.0.0.1:8000", "10.0.0.2:8000"},
+		Available:         true,
+		SSLConfig:         &model.SSLConfig{},
+		TCPTimeout:        "30s",
+		HealthCheckConfig: &model.HealthCheckConfig{MaxFails: "3", FailTimeout: "30s"},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{"server 10.0.0.1:8000 max_fails=3 fail_timeout=30s;", "server 10.0.0.2:8000 max_fails=3 fail_timeout=30s;"} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q, but it did not.", expected)
+		}
+	}
+
+	appConfig.HealthCheckConfig = &model.HealthCheckConfig{MaxFails: "0"}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "server 10.0.0.1:8000 max_fails=0;") {
+		t.Errorf("Expected passive failure detection to be disabled.")
+	}
+}
+
+func TestWriteConfigRetry(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.FaultInjectionEnabled = true
+	appConfig := &model.AppConfig{
+		Name:                 "foo",
+		Domains:              []string{"foo.example.com"},
+		ServiceIP:            "1.2.3.4",
+		ServicePort:          80,
+		Endpoints:            []string{"10.0.0.1:8000"},
+		Available:            true,
+		SSLConfig:            &model.SSLConfig{},
+		TCPTimeout:           "30s",
+		ErrorPages:           map[string]string{"502": "<html></html>", "503": "<html></html>"},
+		RetryConfig:          &model.RetryConfig{Attempts: 3, Backoff: "500ms", MaxBackoff: "1500ms"},
+		FaultInjectionConfig: &model.FaultInjectionConfig{AbortPercent: 10, AbortStatus: 503},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	id := locationID(appConfig, "/")
+	for _, expected := range []string{
+		fmt.Sprintf("error_page 502 504 = @deis_retry_%s_1;", id),
+		"error_page 503 /_deis_errors/503.html;",
+		fmt.Sprintf("set $deis_retry \"duration=500ms&jitter=true&redirect=%%40deis_retry_%s_1_proxy\";", id),
+		fmt.Sprintf("set $deis_retry \"duration=1000ms&jitter=true&redirect=%%40deis_retry_%s_2_proxy\";", id),
+		fmt.Sprintf("set $deis_retry \"duration=1500ms&jitter=true&redirect=%%40deis_retry_%s_3_proxy\";", id),
+		fmt.Sprintf("location @deis_retry_%s_3_proxy {", id),
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	if strings.Contains(config, fmt.Sprintf("@deis_retry_%s_4", id)) {
+		t.Errorf("Expected no more than 3 retries.")
+	}
+	// The custom 502 error page is served by the server once retries are exhausted, but by no location
+	// from which requests are retried.
+	if count := strings.Count(config, "error_page 502 /_deis_errors/502.html;"); count != 1 {
+		t.Errorf("Expected the custom 502 error page to be set only for the server, but found it %d times.", count)
+	}
+	if count := strings.Count(config, "$fault_abort_"); count != 2 {
+		t.Errorf("Expected faults to be injected only into the original request, but found %d references to the abort variable.", count)
+	}
+
+	// gRPC requests are never retried.
+	appConfig.BackendProtocol = "grpc"
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "@deis_retry_") {
+		t.Errorf("Expected requests to a gRPC application not to be retried.")
+	}
+}
+
+func TestWriteConfigHTTP10Compatible(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	appConfig := &model.AppConfig{
+		Name:             "foo",
+		Domains:          []string{"foo.example.com"},
+		ServiceIP:        "1.2.3.4",
+		ServicePort:      80,
+		Available:        true,
+		SSLConfig:        &model.SSLConfig{},
+		TCPTimeout:       "30s",
+		HTTP10Compatible: true,
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{"chunked_transfer_encoding off;", "keepalive_timeout 0;", "proxy_http_version 1.0;"} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	for _, unexpected := range []string{"proxy_http_version 1.1;", "proxy_set_header Upgrade"} {
+		if strings.Contains(config, unexpected) {
+			t.Errorf("Expected nginx config for an HTTP/1.0 compatible application not to contain \"%s\", but it did.", unexpected)
+		}
+	}
+}
+
+func TestWriteConfigStreamingTimeouts(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	fooConfig := &model.AppConfig{
+		Name:           "foo",
+		Domains:        []string{"foo.example.com"},
+		ServiceIP:      "1.2.3.4",
+		ServicePort:    80,
+		Available:      true,
+		SSLConfig:      &model.SSLConfig{},
+		TCPTimeout:     "30s",
+		ReadTimeout:    "1h",
+		ProxyBuffering: true,
+		Websockets:     true,
+	}
+	barConfig := &model.AppConfig{
+		Name:        "bar",
+		Domains:     []string{"bar.example.com"},
+		ServiceIP:   "5.6.7.8",
+		ServicePort: 80,
+		Available:   true,
+		SSLConfig:   &model.SSLConfig{},
+		TCPTimeout:  "30s",
+		SendTimeout: "2m",
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{fooConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{"proxy_read_timeout 1h;", "proxy_send_timeout 30s;", "proxy_buffering on;", "proxy_set_header Upgrade $http_upgrade;"} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+
+	routerConfig.AppConfigs = []*model.AppConfig{barConfig}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{"proxy_read_timeout 30s;", "proxy_send_timeout 2m;", "proxy_buffering off;", "proxy_http_version 1.1;"} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	if strings.Contains(config, "proxy_set_header Upgrade $http_upgrade;") {
+		t.Errorf("Expected nginx config for an application without websockets not to pass upgrades on, but it did.")
+	}
+}
+
+func TestWriteConfigCDN(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.ProxyRealIPCIDRs = []string{"10.0.0.0/8"}
+	routerConfig.IPRangeSources = []*model.IPRangeSource{&model.IPRangeSource{Name: "cloudflare", Ranges: []string{"173.245.48.0/20"}}}
+	appConfig := &model.AppConfig{
+		Name:        "foo",
+		Domains:     []string{"foo.example.com"},
+		ServiceIP:   "1.2.3.4",
+		ServicePort: 80,
+		Available:   true,
+		SSLConfig:   &model.SSLConfig{},
+		TCPTimeout:  "30s",
+		CDN:         "cloudflare",
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{"set_real_ip_from 173.245.48.0/20;", "real_ip_header CF-Connecting-IP;", "proxy_set_header True-Client-IP $remote_addr;", "proxy_set_header CF-Connecting-IP $remote_addr;"} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	// The router's trusted proxies are trusted by the application's servers as well.
+	if strings.Count(config, "set_real_ip_from 10.0.0.0/8;") != 2 {
+		t.Errorf("Expected the router's trusted proxies to be trusted both router-wide and for the application, but they were not.")
+	}
+
+	// A CDN's header cannot be used when clients' addresses are taken from the PROXY protocol.
+	routerConfig.UseProxyProtocol = true
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, unexpected := range []string{"set_real_ip_from 173.245.48.0/20;", "real_ip_header CF-Connecting-IP;", "True-Client-IP"} {
+		if strings.Contains(config, unexpected) {
+			t.Errorf("Expected nginx config not to contain \"%s\", but it did.", unexpected)
+		}
+	}
+}
+
+func TestWriteConfigUpstreamKeepalive(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	appConfig := &model.AppConfig{
+		Name:                    "foo",
+		Domains:                 []string{"foo.example.com"},
+		ServiceIP:               "1.2.3.4",
+		ServicePort:             80,
+		Endpoints:               []string{"10.0.0.1:8080", "10.0.0.2:8080"},
+		Available:               true,
+		SSLConfig:               &model.SSLConfig{},
+		TCPTimeout:              "30s",
+		Websockets:              true,
+		UpstreamKeepaliveConfig: &model.UpstreamKeepaliveConfig{Connections: "16", Requests: "1000", Timeout: "30s"},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{"keepalive 16;", "keepalive_requests 1000;", "keepalive_timeout 30s;", "proxy_http_version 1.1;", "proxy_set_header Connection $connection_upgrade_keepalive;"} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+
+	appConfig.Websockets = false
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, `proxy_set_header Connection "";`) {
+		t.Errorf("Expected nginx config to clear the Connection header of requests to the application, but it did not.")
+	}
+
+	appConfig.UpstreamKeepaliveConfig.Connections = "0"
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, unexpected := range []string{"keepalive 16;", "keepalive_requests", `proxy_set_header Connection "";`} {
+		if strings.Contains(config, unexpected) {
+			t.Errorf("Expected nginx config not to contain \"%s\", but it did.", unexpected)
+		}
+	}
+}
+
+func TestWriteConfigRequestBuffering(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.BodySize = "1m"
+	appConfig := &model.AppConfig{
+		Name:                 "foo",
+		Domains:              []string{"foo.example.com"},
+		ServiceIP:            "1.2.3.4",
+		ServicePort:          80,
+		Available:            true,
+		SSLConfig:            &model.SSLConfig{},
+		TCPTimeout:           "30s",
+		BodySize:             "5g",
+		ClientBodyBufferSize: "64k",
+		ClientBodyTempPath:   "/mnt/uploads",
+		Locations:            []*model.LocationConfig{&model.LocationConfig{Path: "/avatars", BodySize: "2m", TCPTimeout: "30s"}},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{"client_max_body_size 1m;", "client_max_body_size 5g;", "client_max_body_size 2m;", "client_body_buffer_size 64k;", "client_body_temp_path /mnt/uploads;", "proxy_request_buffering off;"} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+
+	appConfig.RequestBuffering = true
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "proxy_request_buffering off;") {
+		t.Errorf("Expected the application's request bodies to be buffered, but they were not.")
+	}
+}
+
+func TestWriteConfigCacheControl(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	appConfig := &model.AppConfig{
+		Name:               "foo",
+		Domains:            []string{"foo.example.com"},
+		ServiceIP:          "1.2.3.4",
+		ServicePort:        80,
+		Available:          true,
+		SSLConfig:          &model.SSLConfig{},
+		TCPTimeout:         "30s",
+		CacheControlConfig: &model.CacheControlConfig{Value: "public, max-age=60"},
+		Locations: []*model.LocationConfig{
+			&model.LocationConfig{Path: "/assets", TCPTimeout: "30s", Available: true, Expires: "30d"},
+			&model.LocationConfig{Path: "/static", TCPTimeout: "30s", Available: true, CacheControl: "public, max-age=60"},
+		},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{appConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	variable := cacheControlVariable(appConfig, appConfig.Locations[1])
+	for _, expected := range []string{
+		fmt.Sprintf("map $upstream_http_cache_control $%s {\n\t\t\"\" \"public, max-age=60\";\n\t\tdefault \"\";", variable),
+		fmt.Sprintf("add_header Cache-Control $%s;", variable),
+		"default off;",
+		"expires $deis_expires_",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	// Locations that set the same header share a map.
+	if strings.Count(config, "$"+variable+" {") != 1 {
+		t.Errorf("Expected one map for the Cache-Control header, but there were %d.", strings.Count(config, "$"+variable+" {"))
+	}
+	if strings.Contains(config, "proxy_hide_header Cache-Control;") {
+		t.Errorf("Expected the application's own Cache-Control headers to be kept, but they were hidden.")
+	}
+
+	appConfig.CacheControlConfig.Force = true
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{"map $status $deis_cache_control_forced_", "proxy_hide_header Cache-Control;", "add_header Cache-Control $deis_cache_control_forced_", "expires 30d;"} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+}
+
+func TestWriteConfigSyslog(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.ErrorLogLevel = "warn"
+	routerConfig.LogConfig = &model.LogConfig{Format: "text", Syslog: "udp://syslog.logging:514", SyslogFacility: "local7"}
+	fooConfig := &model.AppConfig{
+		Name:        "examples/foo.bar",
+		Domains:     []string{"foo.example.com"},
+		ServiceIP:   "1.2.3.4",
+		ServicePort: 80,
+		Available:   true,
+		SSLConfig:   &model.SSLConfig{},
+		TCPTimeout:  "30s",
+	}
+	barConfig := &model.AppConfig{
+		Name:            "bar",
+		Domains:         []string{"bar.example.com"},
+		ServiceIP:       "1.2.3.5",
+		ServicePort:     80,
+		Available:       true,
+		SSLConfig:       &model.SSLConfig{},
+		TCPTimeout:      "30s",
+		Syslog:          "unix:/dev/log",
+		DebugBodyConfig: &model.DebugBodyConfig{Enabled: true},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{fooConfig, barConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"access_log syslog:server=syslog.logging:514,tag=deis_router,facility=local7 upstreaminfo;",
+		"error_log  syslog:server=syslog.logging:514,tag=deis_router,facility=local7 warn;",
+		"access_log syslog:server=syslog.logging:514,tag=examples_foo_bar,facility=local7 upstreaminfo;",
+		"error_log syslog:server=syslog.logging:514,tag=examples_foo_bar,facility=local7 warn;",
+		"access_log syslog:server=unix:/dev/log,tag=bar,facility=local7 upstreaminfo;",
+		"access_log /tmp/logpipe debugbody if=$debug_body_enabled;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	// Each application's servers repeat the router's own access log, alongside the http block's.
+	if count := strings.Count(config, "access_log /tmp/logpipe upstreaminfo;"); count != 3 {
+		t.Errorf("Expected the router's access log to be set 3 times, but found it %d times.", count)
+	}
+
+	// Without a syslog server, applications' servers inherit the router's logs.
+	routerConfig.LogConfig.Syslog = ""
+	barConfig.Syslog = ""
+	barConfig.DebugBodyConfig = nil
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "syslog:") {
+		t.Errorf("Expected no logs to be sent to syslog.")
+	}
+	if count := strings.Count(config, "access_log /tmp/logpipe upstreaminfo;"); count != 1 {
+		t.Errorf("Expected the router's access log to be set once, but found it %d times.", count)
+	}
+}
+
+func TestSyslogTag(t *testing.T) {
+	for name, expected := range map[string]string{
+		"foo":                                  "foo",
+		"examples/foo-bar.baz":                 "examples_foo_bar_baz",
+		"a-very-long-namespace/a-long-ingress": "a_very_long_namespace_a_long_ing",
+	} {
+		if tag := syslogTag(name); tag != expected {
+			t.Errorf("Expected tag \"%s\" for \"%s\", but got \"%s\"", expected, name, tag)
+		}
+	}
+}
+
+func TestWriteConfigTracing(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.RequestIDs = true
+	routerConfig.PropagateRequestIDs = true
+	routerConfig.LogConfig = &model.LogConfig{Format: "json", Fields: []string{"request_id"}}
+	routerConfig.TracingConfig = &model.TracingConfig{Enabled: true, Collector: "zipkin.tracing:9411", SampleRate: "0.5", ServiceName: "deis-router"}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:        "foo",
+			Domains:     []string{"foo.example.com"},
+			ServiceIP:   "1.2.3.4",
+			ServicePort: 80,
+			Available:   true,
+			SSLConfig:   &model.SSLConfig{},
+			TCPTimeout:  "30s",
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"load_module modules/ngx_http_opentracing_module.so;",
+		"map $http_x_request_id $deis_request_id {",
+		"default \"$http_x_correlation_id,$deis_request_id\";",
+		"log_format upstreaminfo escape=json '{\"request_id\":\"$deis_request_id\"}';",
+		"opentracing_load_tracer /usr/local/lib/libzipkin_opentracing_plugin.so /opt/router/conf/tracer.json;",
+		"opentracing_tag request_id $deis_request_id;",
+		"opentracing on;",
+		"opentracing_propagate_context;",
+		"add_header X-Request-Id $deis_request_id always;",
+		"proxy_set_header X-Request-Id $deis_request_id;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q, but it did not.", expected)
+		}
+	}
+
+	// Without a collector, or without propagation, neither is rendered.
+	routerConfig.PropagateRequestIDs = false
+	routerConfig.TracingConfig.Collector = ""
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, unexpected := range []string{"opentracing", "$deis_request_id"} {
+		if strings.Contains(config, unexpected) {
+			t.Errorf("Expected nginx config not to contain %q, but it did.", unexpected)
+		}
+	}
+}
+
+func TestWriteTracerConfig(t *testing.T) {
+	confPath, err := ioutil.TempDir("", "tracer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(confPath)
+	tracerConfigPath := filepath.Join(confPath, "tracer.json")
+
+	routerConfig := model.RouterConfig{
+		TracingConfig: &model.TracingConfig{Enabled: true, Collector: "zipkin.tracing:9411", SampleRate: "0.25", ServiceName: "router"},
+	}
+	if err := WriteTracerConfig(&routerConfig, confPath); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(tracerConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"collector_host":"zipkin.tracing","collector_port":9411,"sample_rate":0.25,"service_name":"router"}`
+	if string(data) != expected {
+		t.Errorf("Expected tracer configuration %s, but got %s", expected, data)
+	}
+
+	routerConfig.TracingConfig.Enabled = false
+	if err := WriteTracerConfig(&routerConfig, confPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tracerConfigPath); !os.IsNotExist(err) {
+		t.Errorf("Expected tracer configuration to be removed once tracing is disabled")
+	}
+}
+
+func TestWriteConfigJSONAccessLog(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.LogConfig = &model.LogConfig{Format: "text", Fields: []string{"time", "status"}}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "escape=json") || !strings.Contains(config, "log_format upstreaminfo '[$time_iso8601]") {
+		t.Errorf("Expected access to be logged as text.")
+	}
+
+	routerConfig.LogConfig.Format = "json"
+	routerConfig.LogConfig.Fields = []string{"status", "time", "app", "status", "upstream_response_time"}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	expected := `log_format upstreaminfo escape=json '{"status":"$status","time":"$time_iso8601","app":"$app_name","upstream_response_time":"$upstream_response_time"}';`
+	if !strings.Contains(config, expected) {
+		t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+	}
+	if !strings.Contains(config, "access_log /tmp/logpipe upstreaminfo;") {
+		t.Errorf("Expected access to be logged in the JSON format.")
+	}
+}
+
+func TestWriteConfigTempPaths(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "_temp_") {
+		t.Errorf("Expected nginx's default temporary file settings to be used when none are configured.")
+	}
+
+	routerConfig.ClientBodyTempPath = "/var/cache/router/client_body"
+	routerConfig.ProxyTempPath = "/var/cache/router/proxy"
+	routerConfig.ProxyMaxTempFileSize = "512m"
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"client_body_temp_path /var/cache/router/client_body;",
+		"proxy_temp_path /var/cache/router/proxy;",
+		"proxy_max_temp_file_size 512m;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+}
+
+func TestWriteConfigDataPath(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.Sendfile = true
+	routerConfig.TCPNodelay = true
+	routerConfig.AIO = "threads"
+	routerConfig.Directio = "4m"
+	routerConfig.Backlog = "4096"
+	routerConfig.MultiAccept = true
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"sendfile on;",
+		"tcp_nopush off;",
+		"tcp_nodelay on;",
+		"aio threads;",
+		"directio 4m;",
+		"multi_accept on;",
+		"accept_mutex off;",
+		"listen 8080 default_server reuseport backlog=4096;",
+		"backlog=4096;\n",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+}
+
+func TestWriteConfigOpenFileCache(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.OpenFileCacheConfig = &model.OpenFileCacheConfig{Max: "1000", Inactive: "20s", Valid: "30s", MinUses: "2"}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "open_file_cache") {
+		t.Errorf("Expected no open file cache when it is disabled.")
+	}
+
+	routerConfig.OpenFileCacheConfig.Enabled = true
+	routerConfig.OpenFileCacheConfig.Errors = true
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"open_file_cache max=1000 inactive=20s;",
+		"open_file_cache_valid 30s;",
+		"open_file_cache_min_uses 2;",
+		"open_file_cache_errors on;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+}
+
+func TestWriteConfigSnippets(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.HTTPSnippet = "map $http_x_debug $debug { default 0; 1 1; }"
+	routerConfig.SnippetsEnabled = true
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:            "foo",
+			Domains:         []string{"foo.example.com"},
+			ServiceIP:       "1.2.3.4",
+			ServicePort:     80,
+			Available:       true,
+			SSLConfig:       &model.SSLConfig{},
+			ServerSnippet:   "add_header X-Server-Snippet 1;",
+			LocationSnippet: "proxy_set_header X-Location-Snippet 1;",
+			Locations: []*model.LocationConfig{
+				&model.LocationConfig{Path: "/api", ServiceIP: "1.2.3.4", ServicePort: 80, Available: true},
+			},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "map $http_x_debug $debug { default 0; 1 1; }") {
+		t.Errorf("Expected nginx config to contain the http snippet.")
+	}
+	if count := strings.Count(config, "add_header X-Server-Snippet 1;"); count != 1 {
+		t.Errorf("Expected the server snippet once, but found it %d times.", count)
+	}
+	// Once for the root location and once for /api.
+	if count := strings.Count(config, "proxy_set_header X-Location-Snippet 1;"); count != 2 {
+		t.Errorf("Expected the location snippet twice, but found it %d times.", count)
+	}
+
+	// Applications' snippets are ignored unless the router permits them.
+	routerConfig.SnippetsEnabled = false
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "X-Server-Snippet") || strings.Contains(config, "X-Location-Snippet") {
+		t.Errorf("Expected applications' snippets to be ignored when the router does not permit them.")
+	}
+	if !strings.Contains(config, "map $http_x_debug $debug { default 0; 1 1; }") {
+		t.Errorf("Expected nginx config to contain the http snippet, which is the router's own.")
+	}
+}
+
+func TestWriteConfigClientVerification(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.ClientCertificates = []string{"platform-ca"}
+	certificate := &model.Certificate{Cert: "foo", Key: "bar"}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:      "foo",
+			Domains:   []string{"foo.example.com", "www.example.com"},
+			SSLConfig: &model.SSLConfig{},
+			Certificates: map[string]*model.Certificate{
+				"foo.example.com": certificate,
+				"www.example.com": certificate,
+			},
+			ClientVerifications: map[string]*model.ClientVerification{
+				"foo.example.com": &model.ClientVerification{CABundle: "foo-ca", Verify: "optional", Depth: "2"},
+			},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"ssl_client_certificate /opt/router/ssl/foo.example.com.client.ca.crt;",
+		"ssl_verify_client optional;",
+		"ssl_verify_depth 2;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q.", expected)
+		}
+	}
+	// Domains without verification of their own fall back to the platform's, as does the default
+	// server.
+	if count := strings.Count(config, "ssl_client_certificate /opt/router/ssl/client.ca.crt;"); count != 2 {
+		t.Errorf("Expected the default server and one domain to verify clients against the platform's CAs, but found %d.", count)
+	}
+}
+
+func TestWriteConfigLocationOverrides(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:           "foo",
+			Domains:        []string{"foo.example.com"},
+			ServiceIP:      "1.2.3.4",
+			ServicePort:    80,
+			Available:      true,
+			SSLConfig:      &model.SSLConfig{},
+			BasicAuthRealm: "Foo",
+			BasicAuthUsers: []string{"alice:{PLAIN}password"},
+			ProxyCacheConfig: &model.ProxyCacheConfig{
+				ZoneSize: "10m",
+				MaxSize:  "1g",
+				Inactive: "10m",
+				Key:      "$scheme$host$request_uri",
+			},
+			Locations: []*model.LocationConfig{
+				&model.LocationConfig{Path: "/static", ServiceIP: "1.2.3.4", ServicePort: 80, Available: true, Auth: true, ProxyCache: true},
+				&model.LocationConfig{Path: "/login", ServiceIP: "1.2.3.4", ServicePort: 80, Available: true},
+			},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	zone := proxyCacheZone(routerConfig.AppConfigs[0])
+	// The application caches nothing itself, but its cache is declared for the location that does.
+	if !strings.Contains(config, "proxy_cache_path /opt/router/cache/"+zone+" ") {
+		t.Errorf("Expected the application's cache to be declared.")
+	}
+	if count := strings.Count(config, "proxy_cache "+zone+";"); count != 1 {
+		t.Errorf("Expected only /static to be cached, but found %d cached locations.", count)
+	}
+	static := config[strings.Index(config, "location /static {"):]
+	if !strings.Contains(static[:strings.Index(static, "\n\t\t}")], "proxy_cache "+zone+";") {
+		t.Errorf("Expected /static to be cached.")
+	}
+	if count := strings.Count(config, "auth_basic off;"); count != 1 {
+		t.Errorf("Expected only /login to be exempt from basic authentication, but found %d exempt locations.", count)
+	}
+	login := config[strings.Index(config, "location /login {"):]
+	if !strings.Contains(login[:strings.Index(login, "\n\t\t}")], "auth_basic off;") {
+		t.Errorf("Expected /login to be exempt from basic authentication.")
+	}
+}
+
+func TestWriteConfigBasicAuth(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:           "foo",
+			Domains:        []string{"foo.example.com"},
+			SSLConfig:      &model.SSLConfig{},
+			BasicAuthRealm: `Foo "staff"`,
+			BasicAuthUsers: []string{"alice:{PLAIN}password"},
+		},
+		&model.AppConfig{
+			Name:      "bar",
+			Domains:   []string{"bar.example.com"},
+			SSLConfig: &model.SSLConfig{},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if count := strings.Count(config, `auth_basic "Foo \"staff\"";`); count != 1 {
+		t.Errorf("Expected exactly one app to require basic authentication, but found %d.", count)
+	}
+	if !strings.Contains(config, "auth_basic_user_file /opt/router/ssl/foo.example.com.htpasswd;") {
+		t.Errorf("Expected nginx config to refer to the app's htpasswd file.")
+	}
+}
+
+func TestBuildHtpasswd(t *testing.T) {
+	htpasswd, err := buildHtpasswd([]string{"alice:{PLAIN}password", "bob:$apr1$abc$def"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(htpasswd)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 htpasswd entries, but got %d", len(lines))
+	}
+	if lines[1] != "bob:$apr1$abc$def" {
+		t.Errorf("Expected an already hashed password to be written as is, but got %q.", lines[1])
+	}
+	if !strings.HasPrefix(lines[0], "alice:{SSHA}") {
+		t.Fatalf("Expected a plain password to be hashed, but got %q.", lines[0])
+	}
+	// The salted hash must verify against the original password.
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(lines[0], "alice:{SSHA}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha1.Sum(append([]byte("password"), decoded[sha1.Size:]...))
+	if !bytes.Equal(digest[:], decoded[:sha1.Size]) {
+		t.Errorf("Expected the hashed password to verify against the original password.")
+	}
+
+	// Hashes in the existing file are reused as long as they are of the same passwords.
+	rebuilt, err := buildHtpasswd([]string{"alice:{PLAIN}password", "bob:$apr1$abc$def"}, htpasswd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(htpasswd, rebuilt) {
+		t.Errorf("Expected the existing hash to be reused, but got %q.", rebuilt)
+	}
+	changed, err := buildHtpasswd([]string{"alice:{PLAIN}secret"}, htpasswd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash := strings.TrimSpace(strings.TrimPrefix(string(changed), "alice:")); hash == strings.TrimPrefix(lines[0], "alice:") || !verifyPassword(hash, "secret") {
+		t.Errorf("Expected a changed password to be hashed anew, but got %q.", changed)
+	}
+}
+
+func TestWriteConfigExternalAuth(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:        "foo",
+			Domains:     []string{"foo.example.com"},
+			ServiceIP:   "1.2.3.4",
+			ServicePort: 80,
+			Available:   true,
+			SSLConfig:   &model.SSLConfig{},
+			ExternalAuthConfig: &model.ExternalAuthConfig{
+				URL:             "http://oauth2-proxy.auth/oauth2/auth",
+				SigninURL:       "https://auth.example.com/oauth2/start",
+				ResponseHeaders: []string{"X-Auth-Request-User", "X-Auth-Request-Email"},
+			},
+			Locations: []*model.LocationConfig{
+				&model.LocationConfig{Path: "/api", ServiceIP: "1.2.3.4", ServicePort: 80, Available: true, Auth: true},
+				&model.LocationConfig{Path: "/healthz", ServiceIP: "1.2.3.4", ServicePort: 80, Available: true},
+			},
+		},
+		&model.AppConfig{
+			Name:               "bar",
+			Domains:            []string{"bar.example.com"},
+			SSLConfig:          &model.SSLConfig{},
+			ExternalAuthConfig: &model.ExternalAuthConfig{},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"location = /_deis_external_auth {",
+		"proxy_pass http://oauth2-proxy.auth/oauth2/auth;",
+		"error_page 401 = https://auth.example.com/oauth2/start?rd=$access_scheme://$host$request_uri;",
+		"auth_request_set $external_auth_0 $upstream_http_x_auth_request_user;",
+		"proxy_set_header X-Auth-Request-Email $external_auth_1;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q.", expected)
+		}
+	}
+	// Once for the root location and once for /api, but not for /healthz, which is exempt.
+	if count := strings.Count(config, "auth_request /_deis_external_auth;"); count != 2 {
+		t.Errorf("Expected 2 locations to require external authentication, but found %d.", count)
+	}
+	if count := strings.Count(config, "location = /_deis_external_auth {"); count != 1 {
+		t.Errorf("Expected only one app to use external authentication, but found %d.", count)
+	}
+	if strings.Contains(config, "proxy_cache") {
+		t.Errorf("Expected the authentication service's answers not to be cached without a TTL.")
+	}
+
+	// With a TTL, answers are cached by the credentials the key names.
+	externalAuthConfig := routerConfig.AppConfigs[0].ExternalAuthConfig
+	externalAuthConfig.CacheTTL = "30s"
+	externalAuthConfig.CacheKey = []string{"header:Authorization", "cookie:_oauth2_proxy", "uri"}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	zone := authCacheZone(routerConfig.AppConfigs[0])
+	for _, expected := range []string{
+		"proxy_cache_path /opt/router/cache/" + zone + " levels=1:2 keys_zone=" + zone + ":1m max_size=64m inactive=30s use_temp_path=off;",
+		"proxy_cache " + zone + ";",
+		"proxy_cache_key \"http://oauth2-proxy.auth/oauth2/auth|$http_authorization|$cookie__oauth2_proxy|$request_uri\";",
+		"proxy_cache_valid 200 202 204 401 403 30s;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q.", expected)
+		}
+	}
+}
+
+func TestWriteConfigStreams(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "stream {") {
+		t.Errorf("Expected no stream section without a builder or stream routes.")
+	}
+
+	routerConfig.UseProxyProtocol = true
+	routerConfig.StreamConfigs = []*model.StreamConfig{
+		&model.StreamConfig{
+			Name:           "db/postgres",
+			Protocol:       "tcp",
+			ListenPort:     5432,
+			ConnectTimeout: "10s",
+			TCPTimeout:     "1h",
+			ServiceIP:      "1.2.3.4",
+			ServicePort:    5432,
+			Endpoints:      []string{"10.0.0.1:5432"},
+		},
+		&model.StreamConfig{
+			Name:           "dns/coredns",
+			Protocol:       "udp",
+			ListenPort:     5353,
+			ConnectTimeout: "10s",
+			TCPTimeout:     "1m",
+			ServiceIP:      "5.6.7.8",
+			ServicePort:    53,
+		},
+	}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"stream {",
+		"upstream tcp_5432 {",
+		"server 10.0.0.1:5432;",
+		"listen 5432 proxy_protocol;",
+		"proxy_pass tcp_5432;",
+		"proxy_timeout 1h;",
+		"listen 5353 udp;",
+		"proxy_pass 5.6.7.8:53;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	if strings.Contains(config, "listen 2222") {
+		t.Errorf("Expected no builder server without a builder.")
+	}
+}
+
+func TestWriteConfigHTTP2(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.HTTP2Enabled = true
+	routerConfig.BuilderConfig = &model.BuilderConfig{ServiceIP: "1.2.3.4"}
+	routerConfig.StreamConfigs = []*model.StreamConfig{
+		&model.StreamConfig{Name: "db/postgres", Protocol: "tcp", ListenPort: 5432, ServiceIP: "1.2.3.4", ServicePort: 5432},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "listen 6443 default_server ssl http2") {
+		t.Errorf("Expected the SSL port to negotiate HTTP/2 when http2Enabled is set.")
+	}
+	for _, listen := range []string{"listen 2222", "listen 5432"} {
+		if strings.Contains(config, listen+" http2") {
+			t.Errorf("Expected \"%s\" not to negotiate HTTP/2.", listen)
+		}
+	}
+
+	// The router's SSL option takes precedence over http2Enabled.
+	routerConfig.SSLConfig.HTTP2 = "false"
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "http2") {
+		t.Errorf("Expected no listener to negotiate HTTP/2 when the router's SSL option disables it.")
+	}
+	routerConfig.HTTP2Enabled = false
+	routerConfig.SSLConfig.HTTP2 = "true"
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "listen 6443 default_server ssl http2") {
+		t.Errorf("Expected the SSL port to negotiate HTTP/2 when the router's SSL option enables it.")
+	}
+}
+
+func TestLocationID(t *testing.T) {
+	appConfig := &model.AppConfig{Name: "examples/foo", Domains: []string{"foo.example.com"}}
+	id := locationID(appConfig, "/")
+	if name := upstreamName(appConfig, id); name != "examples-foo-"+id {
+		t.Errorf("Expected upstream name to be derived from the app name, but got \"%s\".", name)
+	}
+	if id == locationID(appConfig, "/api") {
+		t.Errorf("Expected different locations to have different IDs.")
+	}
+	other := &model.AppConfig{Name: "examples/foo", Domains: []string{"bar.example.com"}}
+	if id == locationID(other, "/") {
+		t.Errorf("Expected apps with the same name but different domains to have different location IDs.")
+	}
+}
+
+// renderConfig returns the configuration written for the provided model, with each application's
+// file appended to the main file.
+func renderConfig(routerConfig *model.RouterConfig) (string, error) {
+	return renderInstanceConfig(routerConfig, false)
+}
+
+// renderInstanceConfig renders the configuration of either the instance of nginx serving the
+// configuration in effect or a staged instance, along with its applications' files.
+func renderInstanceConfig(routerConfig *model.RouterConfig, staged bool) (string, error) {
+	dir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	filePath := filepath.Join(dir, "nginx.conf")
+	if err := writeConfig(routerConfig, filePath, staged); err != nil {
+		return "", err
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "conf.d", "*.conf"))
+	if err != nil {
+		return "", err
+	}
+	config := []byte{}
+	for _, path := range append([]string{filePath}, paths...) {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		config = append(config, contents...)
+	}
+	return string(config), nil
+}
+
+func checkCertAndKey(crtPath string, keyPath string, expectedCertContents string, expectedKeyContents string) error {
+	err := checkCert(crtPath, expectedCertContents)
+	if err != nil {
+		return err
+	}
+	err2 := checkKey(keyPath, expectedKeyContents)
+	if err2 != nil {
+		return err2
+	}
+
+	return nil
+}
+
+func checkCert(crtPath string, expectedCertContents string) error {
+	actualCertContents, err := ioutil.ReadFile(crtPath)
+	if err != nil {
+		return err
+	}
+
+	if !reflect.DeepEqual(expectedCertContents, string(actualCertContents)) {
+		return fmt.Errorf("Expected test.crt contents, %s, does not match actual contents, %s.", expectedCertContents, string(actualCertContents))
+	}
+
+	expectedCertPerm := "-rw-r--r--" // 0644
+
+	crtInfo, _ := os.Stat(crtPath)
+	actualCertPerm := crtInfo.Mode().String()
+	if !reflect.DeepEqual(expectedCertPerm, actualCertPerm) {
+		return fmt.Errorf("Expected permission on test.crt, %s, does not match actual, %s.", expectedCertPerm, actualCertPerm)
+	}
+
+	return nil
+}
+
+func checkKey(keyPath string, expectedKeyContents string) error {
+	actualKeyContents, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(expectedKeyContents, string(actualKeyContents)) {
+		return fmt.Errorf("Expected test.key contents, %s, does not match actual contents, %s.", expectedKeyContents, string(actualKeyContents))
+	}
+
+	expectedKeyPerm := "-rw-------" // 0600
+
+	keyInfo, _ := os.Stat(keyPath)
+	actualKeyPerm := keyInfo.Mode().String()
+	if !reflect.DeepEqual(expectedKeyPerm, actualKeyPerm) {
+		return fmt.Errorf("Expected permission on test.key, %s, does not match actual, %s.", expectedKeyPerm, actualKeyPerm)
+	}
+
+	return nil
+}
+
+func TestWriteConfigLatencyHistograms(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.LogConfig = &model.LogConfig{Format: "text"}
+	routerConfig.LatencyHistograms = true
+	fooConfig := &model.AppConfig{
+		Name:        "foo",
+		Domains:     []string{"foo.example.com"},
+		ServiceIP:   "1.2.3.4",
+		ServicePort: 80,
+		Available:   true,
+		SSLConfig:   &model.SSLConfig{},
+		TCPTimeout:  "30s",
+		Syslog:      "unix:/dev/log",
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{fooConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	expected := "access_log syslog:server=127.0.0.1:9094,tag=deis_latency,nohostname deis_latency;"
+	if !strings.Contains(config, "log_format deis_latency '$app_name|$request_time|$upstream_response_time';") {
+		t.Errorf("Expected nginx config to define the deis_latency log format, but it did not.")
+	}
+	// Applications that set their own logs repeat the latency log, which they would otherwise not inherit.
+	if count := strings.Count(config, expected); count != 2 {
+		t.Errorf("Expected the latency log to be set 2 times, but found it %d times.", count)
+	}
+
+	routerConfig.LatencyHistograms = false
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "deis_latency") {
+		t.Errorf("Expected no latencies to be logged.")
+	}
+}
+
+func TestWriteConfigTLSMetrics(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.LogConfig = &model.LogConfig{Format: "text"}
+	routerConfig.TLSMetrics = true
+	fooConfig := &model.AppConfig{
+		Name:        "foo",
+		Domains:     []string{"foo.example.com"},
+		ServiceIP:   "1.2.3.4",
+		ServicePort: 80,
+		Available:   true,
+		SSLConfig:   &model.SSLConfig{},
+		TCPTimeout:  "30s",
+		Syslog:      "unix:/dev/log",
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{fooConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "access_log syslog:server=127.0.0.1:9095,tag=deis_unknown_sni,nohostname deis_unknown_sni if=$deis_unknown_sni;") {
+		t.Errorf("Expected requests for unknown server names to be logged to the router, but they were not.")
+	}
+	// Applications that set their own error logs repeat the TLS error log, which they would otherwise
+	// not inherit, since handshakes for their domains are logged to theirs.
+	expected := "error_log syslog:server=127.0.0.1:9095,tag=deis_tls,nohostname info;"
+	if count := strings.Count(config, expected); count != 2 {
+		t.Errorf("Expected the TLS error log to be set 2 times, but found it %d times.", count)
+	}
+
+	routerConfig.TLSMetrics = false
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "127.0.0.1:9095") {
+		t.Errorf("Expected no TLS events to be logged.")
+	}
+}
+
+func TestWriteConfigHealthcheckPaths(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	fooConfig := &model.AppConfig{
+		Name:             "foo",
+		Domains:          []string{"foo.example.com"},
+		ConnectTimeout:   "30s",
+		TCPTimeout:       "1300s",
+		ServiceIP:        "1.2.3.4",
+		ServicePort:      80,
+		Available:        true,
+		SSLConfig:        &model.SSLConfig{},
+		RateLimitConfig:  &model.RateLimitConfig{Rate: "10r/s", Key: "ip", ZoneSize: "10m"},
+		HealthcheckPaths: []string{"/healthz", "/api/ping"},
+		Locations: []*model.LocationConfig{
+			&model.LocationConfig{
+				Path:           "/api",
+				ConnectTimeout: "30s",
+				TCPTimeout:     "1300s",
+				ServiceIP:      "5.6.7.8",
+				ServicePort:    8080,
+				Available:      true,
+			},
+		},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{fooConfig}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	key := "$rate_limit_key_" + locationID(fooConfig, "")
+	for _, expected := range []string{
+		"map $uri " + key + " {",
+		"\"/healthz\" \"\";",
+		"\"/api/ping\" \"\";",
+		"default $binary_remote_addr;",
+		"limit_req_zone " + key + " zone=" + rateLimitZone(fooConfig) + "_req:10m rate=10r/s;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	// Each health check path is proxied to the backend of the location that would otherwise serve it.
+	for path, backend := range map[string]string{"/healthz": "1.2.3.4:80", "/api/ping": "5.6.7.8:8080"} {
+		start := strings.Index(config, "location = "+path+" {")
+		if start < 0 {
+			t.Errorf("Expected nginx config to contain a location for \"%s\", but it did not.", path)
+			continue
+		}
+		location := config[start:]
+		if end := strings.Index(location[1:], "location "); end >= 0 {
+			location = location[:end+1]
+		}
+		for _, expected := range []string{"access_log off;", "vhost_traffic_status off;", "proxy_pass http://" + backend + ";"} {
+			if !strings.Contains(location, expected) {
+				t.Errorf("Expected the location for \"%s\" to contain \"%s\", but it did not.", path, expected)
+			}
+		}
+	}
+
+	// Without health check paths, clients are keyed directly.
+	fooConfig.HealthcheckPaths = nil
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "map $uri") || strings.Contains(config, "location = /healthz") {
+		t.Errorf("Expected no health check paths to be configured.")
+	}
+}
+
+func TestWriteConfigSNIConnectionLimit(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{SNIConnectionLimit: 100}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.UseProxyProtocol = true
+	routerConfig.ProxyRealIPCIDRs = []string{"10.0.0.0/8"}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:         "foo",
+			Domains:      []string{"foo.example.com"},
+			ServiceIP:    "1.2.3.4",
+			ServicePort:  80,
+			Available:    true,
+			SSLConfig:    &model.SSLConfig{},
+			Certificates: map[string]*model.Certificate{"foo.example.com": &model.Certificate{}},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"limit_conn_zone $ssl_preread_server_name zone=deis_sni_conn:10m;",
+		"listen 6443 proxy_protocol;",
+		"ssl_preread on;",
+		"limit_conn deis_sni_conn 100;",
+		"proxy_pass unix:/tmp/deis-ssl.sock;",
+		"set_real_ip_from unix:;",
+		"real_ip_header proxy_protocol;",
+		"listen unix:/tmp/deis-ssl.sock default_server ssl",
+		"listen unix:/tmp/deis-ssl.sock ssl",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	// The stream module trusts the same proxies as the http module.
+	if count := strings.Count(config, "set_real_ip_from 10.0.0.0/8;"); count != 2 {
+		t.Errorf("Expected trusted proxies to be set 2 times, but found them %d times.", count)
+	}
+
+	routerConfig.SSLConfig.SNIConnectionLimit = 0
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "ssl_preread") || strings.Contains(config, "deis-ssl.sock") {
+		t.Errorf("Expected SSL connections not to be relayed from the stream module.")
+	}
+}
+
+func TestWriteConfigProxyProtocolListeners(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.ProxyRealIPCIDRs = []string{"10.0.0.0/8"}
+	routerConfig.HTTP2Enabled = true
+	routerConfig.ProxyProtocolConfig = &model.ProxyProtocolConfig{HTTPS: "true", Stream: "true"}
+	routerConfig.BuilderConfig = &model.BuilderConfig{ConnectTimeout: "10s", TCPTimeout: "1200s", ServiceIP: "1.2.3.4"}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:         "foo",
+			Domains:      []string{"foo.example.com"},
+			ServiceIP:    "1.2.3.4",
+			ServicePort:  80,
+			Available:    true,
+			SSLConfig:    &model.SSLConfig{},
+			Certificates: map[string]*model.Certificate{"foo.example.com": &model.Certificate{}},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"listen 8080;",
+		"listen 6443 ssl http2 proxy_protocol;",
+		"listen 2222 proxy_protocol;",
+		"real_ip_header proxy_protocol;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	// The stream module trusts the same proxies as the http module.
+	if count := strings.Count(config, "set_real_ip_from 10.0.0.0/8;"); count != 2 {
+		t.Errorf("Expected trusted proxies to be set 2 times, but found them %d times.", count)
+	}
+
+	// Listeners that disagree about the PROXY protocol are served by servers of their own, each of
+	// which takes its clients' addresses from what its listeners convey.
+	if count := strings.Count(config, "server_name foo.example.com;"); count != 2 {
+		t.Errorf("Expected foo.example.com to be served by 2 servers, but found %d.", count)
+	}
+	if count := strings.Count(config, "real_ip_header X-Forwarded-For;"); count != 2 {
+		t.Errorf("Expected the plain HTTP listener's 2 servers to take addresses from X-Forwarded-For, but found %d.", count)
+	}
+	if count := strings.Count(config, "real_ip_header proxy_protocol;"); count != 3 {
+		t.Errorf("Expected the SSL listener's 2 servers, and the http module, to take addresses from the PROXY protocol, but found %d.", count)
+	}
+
+	// The header clients' addresses are taken from can be set explicitly.
+	routerConfig.RealIPHeader = "X-Real-IP"
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "real_ip_header X-Real-IP;") {
+		t.Errorf("Expected nginx config to take clients' addresses from the X-Real-IP header.")
+	}
+	if count := strings.Count(config, "server_name foo.example.com;"); count != 1 {
+		t.Errorf("Expected foo.example.com to be served by 1 server when the header is set, but found %d.", count)
+	}
+}
+
+func TestWriteConfigProxyProtocolTLVHeaders(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.ProxyProtocolConfig = &model.ProxyProtocolConfig{HTTPS: "true"}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:                    "foo",
+			Domains:                 []string{"foo.example.com"},
+			ServiceIP:               "1.2.3.4",
+			ServicePort:             80,
+			Available:               true,
+			SSLConfig:               &model.SSLConfig{},
+			ProxyProtocolTLVHeaders: map[string]string{"X-Vpce-Id": "0xEA"},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "proxy_set_header X-Vpce-Id $proxy_protocol_tlv_0xEA;") {
+		t.Errorf("Expected the TLV to be passed on to the application, but it was not.")
+	}
+
+	// Without the PROXY protocol there are no TLVs to pass on.
+	routerConfig.ProxyProtocolConfig.HTTPS = "false"
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "$proxy_protocol_tlv_") {
+		t.Errorf("Expected no TLVs to be passed on without the PROXY protocol.")
+	}
+}
+
+func TestWriteConfigSSLSessionCache(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{SessionTimeout: "1h", SessionCacheSize: "50m"}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:         "foo",
+			Domains:      []string{"foo.example.com"},
+			ServiceIP:    "1.2.3.4",
+			ServicePort:  80,
+			Available:    true,
+			SSLConfig:    &model.SSLConfig{},
+			Certificates: map[string]*model.Certificate{"foo.example.com": &model.Certificate{}},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	// The default server shares the cache with every application's.
+	if count := strings.Count(config, "ssl_session_cache shared:SSL:50m;"); count != 2 {
+		t.Errorf("Expected the shared session cache to be set 2 times, but found it %d times.", count)
+	}
+	if count := strings.Count(config, "ssl_session_timeout 1h;"); count != 2 {
+		t.Errorf("Expected the session timeout to be set 2 times, but found it %d times.", count)
+	}
+
+	// A cache set explicitly takes precedence over its size.
+	routerConfig.SSLConfig.SessionCache = "builtin:1000 shared:SSL:10m"
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "shared:SSL:50m") || !strings.Contains(config, "ssl_session_cache builtin:1000 shared:SSL:10m;") {
+		t.Errorf("Expected the session cache set explicitly to be used.")
+	}
+
+	routerConfig.SSLConfig.SessionCache = ""
+	routerConfig.SSLConfig.SessionCacheSize = ""
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "ssl_session_cache") {
+		t.Errorf("Expected no session cache to be set.")
+	}
+}
+
+func TestWriteConfigGeoIP(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.GeoIPDatabase = "/opt/router/geoip/GeoLite2-City.mmdb"
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:        "foo",
+			Domains:     []string{"foo.example.com"},
+			ServiceIP:   "1.2.3.4",
+			ServicePort: 80,
+			Available:   true,
+			SSLConfig:   &model.SSLConfig{},
+			GeoIPConfig: &model.GeoIPConfig{AllowCountries: []string{"US", "CA"}, BlockCountries: []string{"CA"}, Headers: true},
+		},
+		&model.AppConfig{
+			Name:        "bar",
+			Domains:     []string{"bar.example.com"},
+			ServiceIP:   "1.2.3.4",
+			ServicePort: 80,
+			Available:   true,
+			SSLConfig:   &model.SSLConfig{},
+			GeoIPConfig: &model.GeoIPConfig{BlockCountries: []string{"CN"}},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"geoip2 /opt/router/geoip/GeoLite2-City.mmdb {",
+		"\"foo:US\" 0;",
+		"\"foo:CA\" 1;",
+		"\"~^foo:\" 1;",
+		"\"bar:CN\" 1;",
+		"proxy_set_header X-Country-Code $geoip2_country_code;",
+		"proxy_set_header X-Region $geoip2_region;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+	if strings.Contains(config, "\"~^bar:\"") {
+		t.Errorf("Expected countries to be refused only by those blocked when none are allowed.")
+	}
+	if count := strings.Count(config, "if ($geoip_blocked)"); count != 2 {
+		t.Errorf("Expected requests to be filtered by country in 2 servers, but found %d.", count)
+	}
+
+	// Without a database, nothing is looked up.
+	routerConfig.GeoIPDatabase = ""
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "geoip") || strings.Contains(config, "X-Country-Code") {
+		t.Errorf("Expected no GeoIP lookups without a database.")
+	}
+}
+
+func TestWriteConfigEarlyData(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{Protocols: "TLSv1.2 TLSv1.3"}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:            "foo",
+			Domains:         []string{"foo.example.com"},
+			ServiceIP:       "1.2.3.4",
+			ServicePort:     80,
+			Available:       true,
+			SSLConfig:       &model.SSLConfig{},
+			Certificates:    map[string]*model.Certificate{"foo.example.com": &model.Certificate{}},
+			EarlyDataConfig: &model.EarlyDataConfig{Enabled: true, Methods: []string{"GET", "HEAD"}},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"ssl_early_data on;",
+		"\"foo:1:GET\" 0;",
+		"\"foo:1:HEAD\" 0;",
+		"\"~^foo:1:\" 1;",
+		"return 425;",
+		"proxy_set_header Early-Data $ssl_early_data;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain \"%s\", but it did not.", expected)
+		}
+	}
+
+	routerConfig.AppConfigs[0].EarlyDataConfig.Enabled = false
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "early_data") {
+		t.Errorf("Expected no early data to be accepted.")
+	}
+}
+
+func TestWriteConfigECH(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{ECH: true, ECHKeys: map[string]string{"example.com.ech": "key"}}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:         "foo",
+			Domains:      []string{"foo.example.com"},
+			ServiceIP:    "1.2.3.4",
+			ServicePort:  80,
+			Available:    true,
+			SSLConfig:    &model.SSLConfig{},
+			Certificates: map[string]*model.Certificate{"foo.example.com": &model.Certificate{}},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	// The default server and every application's SSL server accept ECH.
+	if count := strings.Count(config, "ssl_echkeydir /opt/router/ssl/ech;"); count != 2 {
+		t.Errorf("Expected ECH keys to be loaded 2 times, but found them %d times.", count)
+	}
+
+	// Without keys, ECH cannot be accepted.
+	routerConfig.SSLConfig.ECHKeys = nil
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "ssl_echkeydir") {
+		t.Errorf("Expected ECH not to be accepted without keys.")
+	}
+}
+
+func TestWriteConfigOCSPStapling(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{OCSPStapling: true, OCSPStaplingVerify: true, OCSPResolver: []string{"10.0.0.10", "10.0.0.11:53"}}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.PlatformCertificate = &model.Certificate{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:         "foo",
+			Domains:      []string{"foo.example.com"},
+			ServiceIP:    "1.2.3.4",
+			ServicePort:  80,
+			Available:    true,
+			SSLConfig:    &model.SSLConfig{},
+			Certificates: map[string]*model.Certificate{"foo.example.com": &model.Certificate{}},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "resolver 10.0.0.10 10.0.0.11:53;") {
+		t.Errorf("Expected the OCSP resolvers to be set, but they were not.")
+	}
+	if strings.Contains(config, "ssl_trusted_certificate") {
+		t.Errorf("Expected no CA to be trusted for a certificate conveyed without one.")
+	}
+
+	// The CA conveyed with a certificate verifies its OCSP responses, unless it might also be trusted
+	// to verify client certificates.
+	routerConfig.AppConfigs[0].Certificates["foo.example.com"].CA = "ca"
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "ssl_trusted_certificate /opt/router/ssl/foo.example.com.ca.crt;") {
+		t.Errorf("Expected the certificate's CA to be trusted, but it was not.")
+	}
+	routerConfig.AppConfigs[0].ClientVerifications = map[string]*model.ClientVerification{"foo.example.com": &model.ClientVerification{Verify: "on", Depth: "1"}}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "ssl_trusted_certificate") {
+		t.Errorf("Expected no CA to be trusted for a domain whose clients are verified.")
+	}
+	routerConfig.AppConfigs[0].ClientVerifications = nil
+	// The platform certificate's server staples as every application's does.
+	if count := strings.Count(config, "ssl_stapling on;\n\t\tssl_stapling_verify on;"); count != 2 {
+		t.Errorf("Expected OCSP stapling to be enabled 2 times, but found it %d times.", count)
+	}
+
+	// The default certificate is self-signed, so it has no OCSP responder to staple from.
+	routerConfig.PlatformCertificate = nil
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if count := strings.Count(config, "ssl_stapling on;"); count != 1 {
+		t.Errorf("Expected OCSP stapling to be enabled 1 time, but found it %d times.", count)
+	}
+
+	routerConfig.SSLConfig.OCSPStapling = false
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "ssl_stapling") || strings.Contains(config, "resolver") {
+		t.Errorf("Expected OCSP stapling to be disabled.")
+	}
+}
+
+func TestWriteConfigListeners(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{Protocols: "TLSv1.2", Ciphers: "ECDHE-RSA-AES128-GCM-SHA256"}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.ListenerConfigs = []*model.ListenerConfig{
+		&model.ListenerConfig{Port: 8443, Protocols: "TLSv1 TLSv1.1 TLSv1.2", Ciphers: "AES128-SHA:DES-CBC3-SHA", HTTP2: "false", Domains: []string{"foo.example.com"}},
+		&model.ListenerConfig{Port: 9443, Protocols: "TLSv1.2", HTTP2: "true"},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:         "foo",
+			Domains:      []string{"foo.example.com", "bar.example.com", "baz.example.com"},
+			ServiceIP:    "1.2.3.4",
+			ServicePort:  80,
+			Available:    true,
+			SSLConfig:    &model.SSLConfig{},
+			Certificates: map[string]*model.Certificate{"foo.example.com": &model.Certificate{}, "bar.example.com": &model.Certificate{}},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	// Each listener's default server carries the port's TLS policy.
+	if !strings.Contains(config, "listen 8443 default_server ssl;\n\t\tset $app_name \"router-default-vhost\";\n\t\tssl_protocols TLSv1 TLSv1.1 TLSv1.2;\n\t\tssl_ciphers AES128-SHA:DES-CBC3-SHA;") {
+		t.Errorf("Expected a default server with the legacy TLS policy on port 8443, but found none.")
+	}
+	if !strings.Contains(config, "listen 9443 default_server ssl http2;") {
+		t.Errorf("Expected a default server negotiating HTTP/2 on port 9443, but found none.")
+	}
+	// Only domains with certificates are served on the listeners, and only on those that admit them.
+	if count := strings.Count(config, "listen 8443 ssl;"); count != 1 {
+		t.Errorf("Expected 1 domain to be served on port 8443, but found %d.", count)
+	}
+	if count := strings.Count(config, "listen 9443 ssl http2;"); count != 2 {
+		t.Errorf("Expected 2 domains to be served on port 9443, but found %d.", count)
+	}
+}
+
+func TestWriteCertsShared(t *testing.T) {
+	sslPath, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(sslPath)
+
+	wildcard := &model.Certificate{Cert: "wildcard-crt", Key: "wildcard-key", CA: "wildcard-ca", Name: "*.example.com"}
+	platform := &model.Certificate{Cert: "platform-crt", Key: "platform-key", Name: "platform"}
+	routerConfig := model.RouterConfig{
+		PlatformCertificate: platform,
+		AppConfigs: []*model.AppConfig{
+			&model.AppConfig{
+				Domains:      []string{"foo.example.com", "bar.example.com", "foo"},
+				Certificates: map[string]*model.Certificate{"foo.example.com": wildcard, "bar.example.com": wildcard, "foo": platform},
+			},
+		},
+	}
+	if err := WriteCerts(&routerConfig, sslPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// Domains that share a certificate share its files.
+	certs, err := filepath.Glob(filepath.Join(sslPath, "*.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCerts := []string{filepath.Join(sslPath, "*.example.com.ca.crt"), filepath.Join(sslPath, "*.example.com.crt"), filepath.Join(sslPath, "client.ca.crt"), filepath.Join(sslPath, "platform.crt")}
+	if !reflect.DeepEqual(expectedCerts, certs) {
+		t.Errorf("Expected certificates %v, but got %v.", expectedCerts, certs)
+	}
+	if actual, err := ioutil.ReadFile(filepath.Join(sslPath, "*.example.com.key")); err != nil || string(actual) != "wildcard-key" {
+		t.Errorf("Expected the wildcard certificate's key to be written, but got %q (%v).", actual, err)
+	}
+	// A certificate's CA, if any, is written alongside it.
+	if actual, err := ioutil.ReadFile(filepath.Join(sslPath, "*.example.com.ca.crt")); err != nil || string(actual) != "wildcard-ca" {
+		t.Errorf("Expected the wildcard certificate's CA to be written, but got %q (%v).", actual, err)
+	}
+}
+
+func TestWriteConfigSharedCertificates(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	wildcard := &model.Certificate{Name: "*.example.com"}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:         "foo",
+			Domains:      []string{"foo.example.com", "bar.example.com", "baz.example.org"},
+			ServiceIP:    "1.2.3.4",
+			ServicePort:  80,
+			Available:    true,
+			SSLConfig:    &model.SSLConfig{},
+			Certificates: map[string]*model.Certificate{"foo.example.com": wildcard, "bar.example.com": wildcard, "baz.example.org": &model.Certificate{}},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if count := strings.Count(config, "ssl_certificate /opt/router/ssl/*.example.com.crt;"); count != 2 {
+		t.Errorf("Expected 2 domains to be served with the wildcard certificate, but found %d.", count)
+	}
+	// Certificates without a name are written for each domain.
+	if !strings.Contains(config, "ssl_certificate /opt/router/ssl/baz.example.org.crt;") {
+		t.Errorf("Expected baz.example.org to be served with a certificate of its own, but it was not.")
+	}
+}
+
+func TestWriteConfigUpstreamTLS(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:              "foo",
+			Domains:           []string{"foo.example.com"},
+			ServiceIP:         "1.2.3.4",
+			ServicePort:       443,
+			Available:         true,
+			SSLConfig:         &model.SSLConfig{},
+			BackendProtocol:   "https",
+			UpstreamTLSConfig: &model.UpstreamTLSConfig{Name: "api.example.com", Protocols: "TLSv1.2", Verify: true, VerifyDepth: 2},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, directive := range []string{"proxy_ssl_name api.example.com;", "proxy_ssl_server_name on;", "proxy_ssl_protocols TLSv1.2;", "proxy_ssl_verify on;", "proxy_ssl_verify_depth 2;", "proxy_pass https://"} {
+		if !strings.Contains(config, directive) {
+			t.Errorf("Expected \"%s\" in the rendered config, but it was not found.", directive)
+		}
+	}
+
+	// gRPC applications are connected to with the grpc module's equivalents.
+	routerConfig.AppConfigs[0].BackendProtocol = "grpcs"
+	routerConfig.AppConfigs[0].UpstreamTLSConfig.Verify = false
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "grpc_ssl_name api.example.com;") || strings.Contains(config, "grpc_ssl_verify") {
+		t.Errorf("Expected the server name, but not verification, to be set for the gRPC application.")
+	}
+
+	// The settings do not apply to endpoints spoken to without TLS.
+	routerConfig.AppConfigs[0].BackendProtocol = "http"
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "_ssl_name") || strings.Contains(config, "proxy_pass https://") {
+		t.Errorf("Expected no upstream TLS settings for an application spoken to in plain HTTP.")
+	}
+}
+
+func TestWriteConfigExternalOrigin(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:              "legacy",
+			Domains:           []string{"legacy.example.com"},
+			ServiceIP:         "1.2.3.4",
+			ServicePort:       80,
+			Available:         true,
+			SSLConfig:         &model.SSLConfig{},
+			BackendProtocol:   "http",
+			ConnectTimeout:    "5s",
+			UpstreamTLSConfig: &model.UpstreamTLSConfig{Verify: true, VerifyDepth: 2},
+			ExternalOrigin:    "https://legacy.example.net",
+			Locations: []*model.LocationConfig{
+				&model.LocationConfig{Path: "/static", ServiceIP: "1.2.3.4", ServicePort: 80, Available: true, ExternalOrigin: "https://legacy.example.net"},
+				&model.LocationConfig{Path: "/api", BackendService: "api", ServiceIP: "5.6.7.8", ServicePort: 80, Available: true},
+			},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, directive := range []string{"proxy_pass https://legacy.example.net;", "proxy_set_header Host $proxy_host;", "proxy_ssl_server_name on;", "proxy_ssl_verify on;", "proxy_connect_timeout 5s;", "proxy_pass http://5.6.7.8:80;"} {
+		if !strings.Contains(config, directive) {
+			t.Errorf("Expected \"%s\" in the rendered config, but it was not found.", directive)
+		}
+	}
+	if strings.Count(config, "proxy_pass https://legacy.example.net;") != 2 {
+		t.Errorf("Expected both the application and its /static location to be proxied to the external origin.")
+	}
+	if strings.Contains(config, "proxy_pass http://1.2.3.4:80") {
+		t.Errorf("Expected no requests to be proxied to the placeholder service.")
+	}
+
+	// An origin spoken to in plain HTTP is not spoken to over TLS.
+	routerConfig.AppConfigs[0].ExternalOrigin = "http://legacy.example.net:8080"
+	routerConfig.AppConfigs[0].Locations = nil
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "proxy_pass http://legacy.example.net:8080;") || strings.Contains(config, "proxy_ssl_") {
+		t.Errorf("Expected requests to be proxied to the origin in plain HTTP.")
+	}
+}
+
+func TestWriteConfigRedirects(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{Enforce: "true"}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:           "foo",
+			Domains:        []string{"foo.example.com"},
+			ServiceIP:      "1.2.3.4",
+			ServicePort:    80,
+			Available:      true,
+			SSLConfig:      &model.SSLConfig{},
+			RedirectConfig: &model.RedirectConfig{Status: 308, WWW: "force", SSLExemptPaths: []string{"/healthz", "/legacy/ping.html"}},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, directive := range []string{
+		"set $deis_ssl_redirect 0;",
+		"if ($uri ~ \"^(/healthz|/legacy/ping\\.html)\") {",
+		"if ($deis_ssl_redirect) {",
+		"if ($host !~* \"^www\\.\") {\n\t\t\t\treturn 308 $access_scheme://www.$host$request_uri;",
+	} {
+		if !strings.Contains(config, directive) {
+			t.Errorf("Expected \"%s\" in the rendered config, but it was not found.", directive)
+		}
+	}
+
+	// Without exemptions, HTTPS is enforced as usual, and www. subdomains may be stripped instead.
+	routerConfig.SSLConfig.Enforce = "external"
+	routerConfig.AppConfigs[0].RedirectConfig = &model.RedirectConfig{Status: 301, WWW: "strip"}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "deis_ssl_redirect") || !strings.Contains(config, "if ($external_enforce_secure) {") {
+		t.Errorf("Expected HTTPS to be enforced for external requests without exemptions.")
+	}
+	if !strings.Contains(config, "return 301 $access_scheme://$deis_bare_host$request_uri;") {
+		t.Errorf("Expected requests for www. subdomains to be redirected to the bare domain.")
+	}
+
+	// Requests are redirected to another domain, over the scheme by which they arrived, even if the
+	// application has no endpoints.
+	routerConfig.AppConfigs[0].Available = false
+	routerConfig.AppConfigs[0].RedirectConfig = &model.RedirectConfig{Status: 302, To: "foo.example.net"}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "return 302 $access_scheme://foo.example.net$request_uri;") {
+		t.Errorf("Expected requests to be redirected to foo.example.net.")
+	}
+	routerConfig.AppConfigs[0].RedirectConfig.To = "https://foo.example.net"
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "return 302 https://foo.example.net$request_uri;") {
+		t.Errorf("Expected requests to be redirected to https://foo.example.net.")
+	}
+}
+
+func TestWriteConfigCORS(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:        "foo",
+			Domains:     []string{"foo.example.com"},
+			ServiceIP:   "1.2.3.4",
+			ServicePort: 80,
+			Available:   true,
+			SSLConfig:   &model.SSLConfig{},
+			CORSConfig: &model.CORSConfig{
+				Enabled:       true,
+				AllowOrigins:  []string{"*"},
+				AllowMethods:  []string{"GET", "POST"},
+				AllowHeaders:  []string{"Content-Type", "X-API-Key"},
+				ExposeHeaders: []string{"X-Total-Count"},
+				MaxAge:        600,
+			},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, directive := range []string{
+		"if ($cors_preflight) {",
+		"add_header Access-Control-Allow-Origin * always;",
+		"add_header Access-Control-Allow-Methods \"GET, POST\" always;",
+		"add_header Access-Control-Allow-Headers \"Content-Type, X-API-Key\" always;",
+		"add_header Access-Control-Max-Age 600 always;",
+		"return 204;",
+		"add_header Access-Control-Expose-Headers \"X-Total-Count\" always;",
+	} {
+		if !strings.Contains(config, directive) {
+			t.Errorf("Expected \"%s\" in the rendered config, but it was not found.", directive)
+		}
+	}
+	if strings.Contains(config, "Access-Control-Allow-Credentials") || strings.Contains(config, "map $http_origin") {
+		t.Errorf("Expected requests from any origin to be allowed, without credentials, by a wildcard.")
+	}
+
+	// Specific origins, or credentials, require the requesting origin to be echoed if it is allowed.
+	routerConfig.AppConfigs[0].CORSConfig.AllowOrigins = []string{"https://app.example.com", "https://*.example.net:8443"}
+	routerConfig.AppConfigs[0].CORSConfig.AllowCredentials = true
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	variable := "$cors_origin_" + locationID(routerConfig.AppConfigs[0], "")
+	for _, directive := range []string{
+		"map $http_origin " + variable + " {\n\t\tdefault \"\";",
+		"\"https://app.example.com\" $http_origin;",
+		"\"~*^https://([-a-z0-9]+\\.)+example\\.net:8443$\" $http_origin;",
+		"add_header Access-Control-Allow-Origin " + variable + " always;",
+		"add_header Access-Control-Allow-Credentials true always;",
+		"add_header Vary Origin always;",
+	} {
+		if !strings.Contains(config, directive) {
+			t.Errorf("Expected \"%s\" in the rendered config, but it was not found.", directive)
+		}
+	}
+
+	// Disabled, CORS is left to the application.
+	routerConfig.AppConfigs[0].CORSConfig.Enabled = false
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "Access-Control-") || strings.Contains(config, "if ($cors_preflight)") {
+		t.Errorf("Expected no CORS headers for an application that does not enable CORS.")
+	}
+}
+
+func TestWriteConfigBackupOrigin(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:                   "foo",
+			Domains:                []string{"foo.example.com"},
+			ServiceIP:              "1.2.3.4",
+			ServicePort:            80,
+			Endpoints:              []string{"10.0.0.1:8000", "10.0.0.2:8000"},
+			Available:              true,
+			SSLConfig:              &model.SSLConfig{},
+			LoadBalancingAlgorithm: "least_conn",
+			HealthCheckConfig:      &model.HealthCheckConfig{MaxFails: "3"},
+			BackupOrigin:           "backup.example.net:80",
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "server 10.0.0.2:8000 max_fails=3;\n\t\tserver backup.example.net:80 max_fails=3 backup;") {
+		t.Errorf("Expected the backup origin to follow the endpoints as a backup server.")
+	}
+
+	// Without endpoints, the backup origin serves every request.
+	routerConfig.AppConfigs[0].Endpoints = nil
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	upstream := upstreamName(routerConfig.AppConfigs[0], locationID(routerConfig.AppConfigs[0], "/"))
+	if !strings.Contains(config, "server backup.example.net:80 max_fails=3;") || !strings.Contains(config, "proxy_pass http://"+upstream+";") {
+		t.Errorf("Expected requests to be proxied to an upstream of only the backup origin.")
+	}
+
+	// nginx does not permit backup servers among those to which requests are hashed.
+	routerConfig.AppConfigs[0].Endpoints = []string{"10.0.0.1:8000"}
+	routerConfig.AppConfigs[0].LoadBalancingAlgorithm = "ip_hash"
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "backup.example.net") {
+		t.Errorf("Expected no backup server in an upstream whose requests are hashed.")
+	}
+}
+
+func TestWriteConfigWAF(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	blockingApp := &model.AppConfig{
+		Name:        "foo",
+		Domains:     []string{"foo.example.com"},
+		ServiceIP:   "1.2.3.4",
+		ServicePort: 80,
+		Available:   true,
+		SSLConfig:   &model.SSLConfig{},
+		TCPTimeout:  "30s",
+		WAFConfig:   &model.WAFConfig{Enabled: true, Mode: "block", Rules: map[string]string{"exclusions.conf": "SecRuleRemoveById 920350"}},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		blockingApp,
+		&model.AppConfig{
+			Name:        "bar",
+			Domains:     []string{"bar.example.com"},
+			ServiceIP:   "1.2.3.5",
+			ServicePort: 80,
+			Available:   true,
+			SSLConfig:   &model.SSLConfig{},
+			TCPTimeout:  "30s",
+			WAFConfig:   &model.WAFConfig{Enabled: true, Mode: "detect"},
+		},
+		&model.AppConfig{
+			Name:        "baz",
+			Domains:     []string{"baz.example.com"},
+			ServiceIP:   "1.2.3.6",
+			ServicePort: 80,
+			Available:   true,
+			SSLConfig:   &model.SSLConfig{},
+			TCPTimeout:  "30s",
+			WAFConfig:   &model.WAFConfig{Mode: "block"},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"load_module modules/ngx_http_modsecurity_module.so;",
+		"modsecurity off;\n\tmodsecurity_rules_file /opt/router/waf/main.conf;",
+		"modsecurity on;\n\t\tmodsecurity_rules 'SecRuleEngine On';\n\t\tmodsecurity_rules_file /opt/router/waf/" + wafRulesFile(blockingApp) + ";",
+		"modsecurity on;\n\t\tmodsecurity_rules 'SecRuleEngine DetectionOnly';\n",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q, but it did not.", expected)
+		}
+	}
+	if strings.Count(config, "modsecurity on;") != 2 {
+		t.Errorf("Expected only the two applications that enable the WAF to turn ModSecurity on.")
+	}
+
+	// Without any application enabling the WAF, the module is not loaded at all.
+	for _, appConfig := range routerConfig.AppConfigs {
+		appConfig.WAFConfig = nil
+	}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "modsecurity") {
+		t.Error("Expected ModSecurity not to be loaded when no application enables the WAF.")
+	}
+}
+
+func TestWriteConfigIPRangeSources(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.ProxyRealIPCIDRs = []string{"10.0.0.0/8"}
+	routerConfig.DefaultWhitelist = []string{"203.0.113.0/24"}
+	routerConfig.WhitelistMode = "override"
+	routerConfig.IPRangeSources = []*model.IPRangeSource{
+		&model.IPRangeSource{Name: "cdn", TrustProxies: true, Ranges: []string{"192.0.2.0/24", "2001:db8::/32"}},
+		&model.IPRangeSource{Name: "office", Ranges: []string{"198.51.100.0/24"}},
+		&model.IPRangeSource{Name: "pending", TrustProxies: true},
+	}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:             "foo",
+			Domains:          []string{"foo.example.com"},
+			ServiceIP:        "1.2.3.4",
+			ServicePort:      80,
+			Available:        true,
+			SSLConfig:        &model.SSLConfig{},
+			TCPTimeout:       "30s",
+			Whitelist:        []string{"1.2.3.4"},
+			WhitelistSources: []string{"office", "cdn"},
+		},
+		&model.AppConfig{
+			Name:             "bar",
+			Domains:          []string{"bar.example.com"},
+			ServiceIP:        "1.2.3.5",
+			ServicePort:      80,
+			Available:        true,
+			SSLConfig:        &model.SSLConfig{},
+			TCPTimeout:       "30s",
+			WhitelistSources: []string{"pending"},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, expected := range []string{
+		"set_real_ip_from 10.0.0.0/8;\n\tset_real_ip_from 192.0.2.0/24;\n\tset_real_ip_from 2001:db8::/32;\n\treal_ip_recursive on;",
+		"allow 1.2.3.4;allow 198.51.100.0/24;allow 192.0.2.0/24;allow 2001:db8::/32;\n\t\tdeny all;",
+	} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected nginx config to contain %q, but it did not.", expected)
+		}
+	}
+	// Applications' own whitelists replace the router's default whitelist.
+	if strings.Contains(config, "allow 203.0.113.0/24;") {
+		t.Errorf("Expected the default whitelist to be overridden, but it was not.")
+	}
+
+	// A source whose ranges are not yet known whitelists no one, rather than everyone.
+	routerConfig.DefaultWhitelist = nil
+	restricted, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	routerConfig.AppConfigs[1].WhitelistSources = nil
+	unrestricted, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Count(restricted, "deny all;") != strings.Count(unrestricted, "deny all;")+1 {
+		t.Errorf("Expected an application whitelisted by a source with no ranges to refuse all requests.")
+	}
+}
+
+func TestWriteConfigProxyBind(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.ProxyBind = "10.0.0.5"
+	routerConfig.BuilderConfig = &model.BuilderConfig{ConnectTimeout: "10s", TCPTimeout: "1200s", ServiceIP: "5.6.7.8"}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:            "foo",
+			Domains:         []string{"foo.example.com"},
+			ServiceIP:       "1.2.3.4",
+			ServicePort:     80,
+			Available:       true,
+			SSLConfig:       &model.SSLConfig{},
+			BackendProtocol: "http",
+		},
+		&model.AppConfig{
+			Name:            "bar",
+			Domains:         []string{"bar.example.com"},
+			ServiceIP:       "1.2.3.5",
+			ServicePort:     80,
+			Available:       true,
+			SSLConfig:       &model.SSLConfig{},
+			BackendProtocol: "http",
+			ProxyBind:       "10.0.0.6",
+		},
+		&model.AppConfig{
+			Name:            "baz",
+			Domains:         []string{"baz.example.com"},
+			ServiceIP:       "1.2.3.6",
+			ServicePort:     80,
+			Available:       true,
+			SSLConfig:       &model.SSLConfig{},
+			BackendProtocol: "http",
+			ProxyBind:       "off",
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	// The builder and foo are connected to from the router's address, and bar from its own.
+	if strings.Count(config, "proxy_bind 10.0.0.5;") != 2 || strings.Count(config, "proxy_bind 10.0.0.6;") != 1 {
+		t.Errorf("Expected the router's address to be bound for the builder and foo, and bar's own for bar:\n%s", config)
+	}
+	if strings.Contains(config, "proxy_bind off") {
+		t.Errorf("Expected baz to bind no address.")
+	}
+}
+
+func TestWriteConfigTransforms(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:            "foo",
+			Domains:         []string{"foo.example.com"},
+			ServiceIP:       "1.2.3.4",
+			ServicePort:     80,
+			Available:       true,
+			SSLConfig:       &model.SSLConfig{},
+			BackendProtocol: "http",
+			ErrorPages:      map[string]string{"404": "<h1>Not here</h1>"},
+			TransformConfig: &model.TransformConfig{HeaderCase: []string{"X-API-Key", "host"}},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, "proxy_set_header X-API-Key $http_x_api_key;") {
+		t.Errorf("Expected the X-API-Key header to be passed with its casing, but it was not.")
+	}
+	// Headers the router sets itself are not set twice.
+	if strings.Contains(config, "proxy_set_header host") {
+		t.Errorf("Expected the Host header to be left alone.")
+	}
+	// Header casing is set natively, so the transformations' library is not needed.
+	if strings.Contains(config, "js_import") {
+		t.Errorf("Expected the transformations' library not to be loaded.")
+	}
+}
+
+func TestWriteConfigTLSHeaders(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		{
+			Name:             "foo",
+			Domains:          []string{"foo.example.com"},
+			ServiceIP:        "1.2.3.4",
+			ServicePort:      80,
+			Available:        true,
+			SSLConfig:        &model.SSLConfig{},
+			TLSHeadersConfig: &model.TLSHeadersConfig{SNI: true, JA3: true},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, directive := range []string{
+		"js_set $deis_tls_fingerprint transforms.deisTLSFingerprint;",
+		"proxy_set_header X-SSL-SNI $ssl_server_name;",
+		"proxy_set_header X-SSL-JA3 $deis_tls_fingerprint;",
+	} {
+		if !strings.Contains(config, directive) {
+			t.Errorf("Expected \"%s\" in the rendered config, but it was not found.", directive)
+		}
+	}
+	// The fingerprint is never taken from a request header, which clients could set to anything.
+	if strings.Contains(config, "$http_ssl_ja3") {
+		t.Errorf("Expected the fingerprint not to be taken from a request header.")
+	}
+
+	routerConfig.AppConfigs[0].TLSHeadersConfig.JA3 = false
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if strings.Contains(config, "js_import") || strings.Contains(config, "X-SSL-JA3") {
+		t.Errorf("Expected no fingerprint when it is not enabled.")
+	}
+}
+
+func TestWriteConfigErrorFormat(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:                    "foo",
+			Domains:                 []string{"foo.example.com"},
+			ServiceIP:               "1.2.3.4",
+			ServicePort:             80,
+			Available:               true,
+			SSLConfig:               &model.SSLConfig{},
+			BackendProtocol:         "http",
+			ErrorPages:              map[string]string{"404": "<h1>Not here</h1>"},
+			ErrorFormat:             "json",
+			RateLimitConfig:         &model.RateLimitConfig{Rate: "10r/s", Key: "ip", ZoneSize: "10m"},
+			RateLimitResponseConfig: &model.RateLimitResponseConfig{Status: 429},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, directive := range []string{
+		"error_page 502 @deis_error_502;",
+		"location @deis_error_502 {\n\t\t\tdefault_type application/json;",
+		`return 502 "{\"code\":502,\"message\":\"Bad Gateway\",\"request_id\":\"$request_id\"}";`,
+		`return 413 "{\"code\":413,\"message\":\"Payload Too Large\",\"request_id\":\"$request_id\"}";`,
+		`return 429 "{\"code\":429,\"message\":\"Too Many Requests\",\"request_id\":\"$request_id\"}";`,
+		"error_page 404 /_deis_errors/404.html;",
+	} {
+		if !strings.Contains(config, directive) {
+			t.Errorf("Expected \"%s\" in the rendered config, but it was not found.", directive)
+		}
+	}
+	// Errors are answered natively, so the transformations are not needed, and the application's own
+	// error pages are kept.
+	for _, unexpected := range []string{"js_import", "@deis_error_404"} {
+		if strings.Contains(config, unexpected) {
+			t.Errorf("Expected \"%s\" not to be in the rendered config, but it was.", unexpected)
+		}
+	}
+
+	// Request IDs that are propagated are reported as such.
+	routerConfig.PropagateRequestIDs = true
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if !strings.Contains(config, `\"request_id\":\"$deis_request_id\"`) {
+		t.Errorf("Expected errors to report the propagated request ID.")
+	}
+}
+
+func TestWriteConfigPrefixes(t *testing.T) {
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:            "foo",
+			Domains:         []string{"foo.example.com"},
+			ServiceIP:       "1.2.3.4",
+			ServicePort:     80,
+			Available:       true,
+			SSLConfig:       &model.SSLConfig{},
+			BackendProtocol: "http",
+			StripPrefix:     "/api.v1/",
+			Locations: []*model.LocationConfig{
+				&model.LocationConfig{
+					Path:        "/shop",
+					ServiceIP:   "5.6.7.8",
+					ServicePort: 80,
+					Available:   true,
+					AddPrefix:   "/store/",
+				},
+			},
+		},
+	}
+
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	for _, directive := range []string{
+		`rewrite "^/api\.v1$" / break;`,
+		`rewrite "^/api\.v1(/.*)$" $1 break;`,
+		`rewrite "^(.*)$" /store$1 break;`,
+		"proxy_pass http://1.2.3.4:80;",
+		"proxy_pass http://5.6.7.8:80;",
+	} {
+		if !strings.Contains(config, directive) {
+			t.Errorf("Expected \"%s\" in the rendered config, but it was not found.", directive)
+		}
+	}
+	if strings.Contains(config, "js_import") {
+		t.Errorf("Expected path prefixes to be rewritten without the transformations.")
+	}
+}
+
+func TestPrefixRewrites(t *testing.T) {
+	appConfig := &model.AppConfig{BackendProtocol: "http"}
+	for _, test := range []struct {
+		strip, add, uri, expected string
+	}{
+		{"/api", "", "/api/users", "/users"},
+		{"/api/", "/v2", "/api", "/v2/"},
+		{"/api", "/v2", "/api/", "/v2/"},
+		// Prefixes are stripped only as whole segments.
+		{"/api", "/v2", "/apis", "/apis"},
+		{"/api.v1", "", "/apixv1/users", "/apixv1/users"},
+		{"", "/v2/", "/users", "/v2/users"},
+		{"/", "/", "/users", "/users"},
+	} {
+		context := locationContext{AppConfig: appConfig, Location: &model.LocationConfig{StripPrefix: test.strip, AddPrefix: test.add}}
+		uri := test.uri
+		// nginx applies the first rewrite that matches, and stops.
+		for _, rewrite := range prefixRewrites(context) {
+			i := strings.LastIndex(rewrite, "\" ")
+			replacement := rewrite[i+2:]
+			// A replacement without a query string of its own keeps the request's.
+			if strings.Contains(replacement, "?") {
+				t.Errorf("Expected the rewrite %s to keep the request's query string.", rewrite)
+			}
+			if match := regexp.MustCompile(rewrite[1:i]).FindStringSubmatch(uri); match != nil {
+				if len(match) > 1 {
+					replacement = strings.Replace(replacement, "$1", match[1], 1)
+				}
+				uri = replacement
+				break
+			}
+		}
+		if uri != test.expected {
+			t.Errorf("Expected %s to be proxied as %s when stripping \"%s\" and adding \"%s\", but got %s.", test.uri, test.expected, test.strip, test.add, uri)
+		}
+	}
+
+	// Retries are proxied with the path already rewritten, and gRPC paths never are.
+	context := locationContext{AppConfig: appConfig, Location: &model.LocationConfig{StripPrefix: "/api"}, Attempt: 1}
+	if rewrites := prefixRewrites(context); rewrites != nil {
+		t.Errorf("Expected no rewrites for a retry, but got %v.", rewrites)
+	}
+	context = locationContext{AppConfig: &model.AppConfig{BackendProtocol: "grpc"}, Location: &model.LocationConfig{StripPrefix: "/api"}}
+	if rewrites := prefixRewrites(context); rewrites != nil {
+		t.Errorf("Expected no rewrites for a gRPC application, but got %v.", rewrites)
+	}
+}
+
+func TestTransformsLibrary(t *testing.T) {
+	library, err := ioutil.ReadFile(filepath.Join("..", "rootfs", "opt", "router", "njs", "transforms.js"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every function the configuration names must be defined and exported by the library, or nginx
+	// will not start.
+	for _, function := range []string{"deisAllowResponseHeaders", "deisTLSFingerprint"} {
+		if !strings.Contains(string(library), "function "+function+"(r)") {
+			t.Errorf("Expected the transforms library to define %s.", function)
+		}
+		if !strings.Contains(string(library), function+": "+function) {
+			t.Errorf("Expected the transforms library to export %s.", function)
+		}
+	}
+}
+
+func TestImageNginxVersion(t *testing.T) {
+	dockerfile, err := ioutil.ReadFile(filepath.Join("..", "rootfs", "Dockerfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := strings.Index(string(dockerfile), "NGINX_VERSION=")
+	if i < 0 {
+		t.Fatal("Expected the router's image to name the nginx version it is built with.")
+	}
+	var major, minor, patch int
+	if _, err := fmt.Sscanf(string(dockerfile[i:]), "NGINX_VERSION=%d.%d.%d", &major, &minor, &patch); err != nil {
+		t.Fatal(err)
+	}
+	// The configuration uses these directives, which nginx rejects before the versions given, so
+	// the image must be built with an nginx at least as recent as each.
+	for _, required := range []struct {
+		directive           string
+		major, minor, patch int
+	}{
+		{"grpc_pass", 1, 13, 10},
+		{"ssl_early_data", 1, 15, 3},
+		{"$proxy_protocol_tlv_", 1, 23, 2},
+	} {
+		if major*1000000+minor*1000+patch < required.major*1000000+required.minor*1000+required.patch {
+			t.Errorf("Expected the router's image to be built with nginx %d.%d.%d or later, which %s requires, but it is built with %d.%d.%d.", required.major, required.minor, required.patch, required.directive, major, minor, patch)
+		}
+	}
+	// gRPC is proxied by a module nginx builds only alongside HTTP/2.
+	if !strings.Contains(string(dockerfile), "--with-http_v2_module") {
+		t.Error("Expected the router's image to be built with HTTP/2, without which nginx has no grpc_pass.")
+	}
+	// Early data is sent only over TLS 1.3, which OpenSSL supports from 1.1.1, but the base image's is
+	// older, so nginx is built with its own.
+	if !strings.Contains(string(dockerfile), "OPENSSL_VERSION=1_1_1") || !strings.Contains(string(dockerfile), "--with-openssl=") {
+		t.Error("Expected the router's image to be built with OpenSSL 1.1.1, without which nginx accepts no early data.")
+	}
+	// Modules the configuration loads, and the files it includes, must be built into the image.
+	for _, expected := range []string{
+		`--add-dynamic-module="$BUILD_PATH/ModSecurity-nginx-`,
+		`"$PREFIX/modsecurity/modsecurity.conf"`,
+		`"$PREFIX/modsecurity/crs/crs-setup.conf"`,
+		`--add-dynamic-module="$BUILD_PATH/nginx-opentracing-`,
+		"-DBUILD_PLUGIN=ON",
+	} {
+		if !strings.Contains(string(dockerfile), expected) {
+			t.Errorf("Expected the router's image to be built with %s, but it was not.", expected)
+		}
+	}
+}
+
+func TestWriteConfigStagedRollout(t *testing.T) {
+	routerConfig := model.RouterConfig{WorkerProcesses: "auto"}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.StagedRolloutConfig = &model.StagedRolloutConfig{Enabled: true, Workers: 2, Period: "60s", MaxErrorPercent: 1}
+	routerConfig.BuilderConfig = &model.BuilderConfig{ConnectTimeout: "10s", TCPTimeout: "1200s", ServiceIP: "5.6.7.8"}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:            "foo",
+			Domains:         []string{"foo.example.com"},
+			ServiceIP:       "1.2.3.4",
+			ServicePort:     80,
+			Available:       true,
+			SSLConfig:       &model.SSLConfig{},
+			BackendProtocol: "http",
+		},
+	}
+
+	config, err := renderInstanceConfig(&routerConfig, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"pid /tmp/nginx.pid;", "worker_processes auto;", "listen 6443 default_server ssl", "reuseport", "listen 9090 default_server;", "listen 2222"} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected the configuration in effect to contain \"%s\", but it did not.", expected)
+		}
+	}
+
+	config, err = renderInstanceConfig(&routerConfig, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"pid /tmp/nginx-staged.pid;", "worker_processes 2;", "listen 9095 default_server;", "server_name foo.example.com"} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected the staged configuration to contain \"%s\", but it did not.", expected)
+		}
+	}
+	for _, unexpected := range []string{"listen 9090", "stream {"} {
+		if strings.Contains(config, unexpected) {
+			t.Errorf("Expected the staged configuration not to contain \"%s\", but it did.", unexpected)
+		}
+	}
+
+	// Connections relayed over unix sockets cannot be shared, so nothing is staged.
+	routerConfig.SSLConfig.SNIConnectionLimit = 100
+	if StagedRollout(&routerConfig) {
+		t.Errorf("Expected staged rollout not to apply while SNI connections are limited.")
+	}
 }
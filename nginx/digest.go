@@ -0,0 +1,42 @@
+package nginx
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Digest returns a digest of the names and contents of the specified files, and of every file beneath
+// the specified directories.  Paths that do not exist are skipped.  Two digests are equal only if
+// nginx would find exactly the same files with exactly the same contents, so a reload can be skipped
+// if the digest of what nginx is about to load equals that of what it last loaded.
+func Digest(paths ...string) (string, error) {
+	hash := sha256.New()
+	for _, path := range paths {
+		err := filepath.Walk(path, func(filePath string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			data, err := ioutil.ReadFile(filePath)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hash, "%s\x00%d\x00", filePath, len(data))
+			hash.Write(data)
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,58 @@
+package nginx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDigest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	confPath := filepath.Join(dir, "conf")
+	sslPath := filepath.Join(dir, "ssl")
+	for _, path := range []string{filepath.Join(confPath, appConfigDir), sslPath} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write := func(path string, contents string) {
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(confPath, "nginx.conf"), "http {}")
+	write(filepath.Join(confPath, appConfigDir, "app-foo.conf"), "server {}")
+	write(filepath.Join(sslPath, "foo.crt"), "foo")
+	missingPath := filepath.Join(dir, "missing")
+
+	digest, err := Digest(confPath, sslPath, missingPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Rewriting a file with the same contents leaves the digest unchanged.
+	write(filepath.Join(sslPath, "foo.crt"), "foo")
+	if unchanged, err := Digest(confPath, sslPath, missingPath); err != nil || unchanged != digest {
+		t.Errorf("Expected the digest to be unchanged, but got %s (%v).", unchanged, err)
+	}
+
+	for _, change := range []func(){
+		func() { write(filepath.Join(sslPath, "foo.crt"), "renewed") },
+		func() { write(filepath.Join(sslPath, "bar.crt"), "") },
+		func() { os.Remove(filepath.Join(confPath, appConfigDir, "app-foo.conf")) },
+	} {
+		change()
+		changed, err := Digest(confPath, sslPath, missingPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if changed == digest {
+			t.Errorf("Expected the digest to change, but it did not.")
+		}
+		digest = changed
+	}
+}
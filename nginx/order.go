@@ -0,0 +1,117 @@
+package nginx
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/deis/router/model"
+)
+
+// sortedConfig returns a copy of the provided model whose applications, their domains, and all
+// upstream servers are sorted, so that identical models always render byte-identical
+// configuration, regardless of the order in which k8s happened to list the resources they were
+// built from.  Maps need no such treatment, since templates range over them in key order.  The
+// provided model is left untouched.
+func sortedConfig(routerConfig *model.RouterConfig) *model.RouterConfig {
+	sorted := *routerConfig
+	sorted.AppConfigs = make([]*model.AppConfig, 0, len(routerConfig.AppConfigs))
+	for _, appConfig := range routerConfig.AppConfigs {
+		sorted.AppConfigs = append(sorted.AppConfigs, sortedAppConfig(appConfig))
+	}
+	// The sort is stable so that, of applications that share a name and domains, the first to be
+	// listed still takes precedence.
+	sort.Stable(appConfigsByName(sorted.AppConfigs))
+	sorted.StreamConfigs = make([]*model.StreamConfig, 0, len(routerConfig.StreamConfigs))
+	for _, streamConfig := range routerConfig.StreamConfigs {
+		sortedStreamConfig := *streamConfig
+		sortedStreamConfig.Endpoints = sortedStrings(streamConfig.Endpoints)
+		sorted.StreamConfigs = append(sorted.StreamConfigs, &sortedStreamConfig)
+	}
+	sort.Stable(streamConfigsByPort(sorted.StreamConfigs))
+	if routerConfig.DefaultBackend != nil {
+		defaultBackend := *routerConfig.DefaultBackend
+		defaultBackend.Endpoints = sortedStrings(routerConfig.DefaultBackend.Endpoints)
+		sorted.DefaultBackend = &defaultBackend
+	}
+	return &sorted
+}
+
+// sortedAppConfig returns a copy of the provided application with its domains and upstream servers
+// sorted.  The order of its locations is left alone, since nginx gives precedence to the first
+// matching regular expression location.
+func sortedAppConfig(appConfig *model.AppConfig) *model.AppConfig {
+	sorted := *appConfig
+	sorted.Domains = sortedStrings(appConfig.Domains)
+	sorted.ACMEDomains = sortedStrings(appConfig.ACMEDomains)
+	sorted.Endpoints = sortedStrings(appConfig.Endpoints)
+	sorted.Canary = sortedCanary(appConfig.Canary)
+	if appConfig.Locations != nil {
+		sorted.Locations = make([]*model.LocationConfig, 0, len(appConfig.Locations))
+		for _, location := range appConfig.Locations {
+			sortedLocation := *location
+			sortedLocation.Endpoints = sortedStrings(location.Endpoints)
+			sortedLocation.Canary = sortedCanary(location.Canary)
+			sortedLocation.WeightedBackends = sortedWeightedBackends(location.WeightedBackends)
+			sorted.Locations = append(sorted.Locations, &sortedLocation)
+		}
+	}
+	return &sorted
+}
+
+func sortedCanary(canary *model.CanaryBackend) *model.CanaryBackend {
+	if canary == nil {
+		return nil
+	}
+	sorted := *canary
+	sorted.Endpoints = sortedStrings(canary.Endpoints)
+	return &sorted
+}
+
+func sortedWeightedBackends(backends []*model.WeightedBackend) []*model.WeightedBackend {
+	if backends == nil {
+		return nil
+	}
+	sorted := make([]*model.WeightedBackend, 0, len(backends))
+	for _, backend := range backends {
+		sortedBackend := *backend
+		sortedBackend.Endpoints = sortedStrings(backend.Endpoints)
+		sorted = append(sorted, &sortedBackend)
+	}
+	return sorted
+}
+
+// sortedStrings returns a sorted copy of the provided strings.
+func sortedStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	sorted := make([]string, len(values))
+	copy(sorted, values)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// appConfigsByName sorts applications by name and then by their (sorted) domains, which tells
+// apart the applications built from the rules of a single ingress.
+type appConfigsByName []*model.AppConfig
+
+func (a appConfigsByName) Len() int      { return len(a) }
+func (a appConfigsByName) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a appConfigsByName) Less(i, j int) bool {
+	if a[i].Name != a[j].Name {
+		return a[i].Name < a[j].Name
+	}
+	return strings.Join(a[i].Domains, ",") < strings.Join(a[j].Domains, ",")
+}
+
+// streamConfigsByPort sorts stream configurations by protocol and then by listening port.
+type streamConfigsByPort []*model.StreamConfig
+
+func (s streamConfigsByPort) Len() int      { return len(s) }
+func (s streamConfigsByPort) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s streamConfigsByPort) Less(i, j int) bool {
+	if s[i].Protocol != s[j].Protocol {
+		return s[i].Protocol < s[j].Protocol
+	}
+	return s[i].ListenPort < s[j].ListenPort
+}
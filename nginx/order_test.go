@@ -0,0 +1,90 @@
+package nginx
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/deis/router/model"
+)
+
+func newOrderTestConfig(appNames []string, domains []string, endpoints []string, listenPorts []int) *model.RouterConfig {
+	routerConfig := &model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	for _, name := range appNames {
+		routerConfig.AppConfigs = append(routerConfig.AppConfigs, &model.AppConfig{
+			Name:        name,
+			Domains:     domains,
+			ServiceIP:   "1.2.3.4",
+			ServicePort: 80,
+			Endpoints:   endpoints,
+			Available:   true,
+			SSLConfig:   &model.SSLConfig{},
+			Locations: []*model.LocationConfig{
+				&model.LocationConfig{Path: "/api", ServiceIP: "1.2.3.5", ServicePort: 80, Endpoints: endpoints, Available: true},
+			},
+		})
+	}
+	for _, listenPort := range listenPorts {
+		routerConfig.StreamConfigs = append(routerConfig.StreamConfigs, &model.StreamConfig{
+			Name:        "foo",
+			Protocol:    "tcp",
+			ListenPort:  listenPort,
+			ServiceIP:   "1.2.3.4",
+			ServicePort: 5432,
+			Endpoints:   endpoints,
+			Available:   true,
+		})
+	}
+	return routerConfig
+}
+
+func TestWriteConfigStableOrder(t *testing.T) {
+	config, err := renderConfig(newOrderTestConfig(
+		[]string{"foo", "bar", "baz"},
+		[]string{"www.example.com", "api.example.com"},
+		[]string{"10.0.0.2:80", "10.0.0.1:80"},
+		[]int{6000, 5432},
+	))
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	reordered, err := renderConfig(newOrderTestConfig(
+		[]string{"baz", "foo", "bar"},
+		[]string{"api.example.com", "www.example.com"},
+		[]string{"10.0.0.1:80", "10.0.0.2:80"},
+		[]int{5432, 6000},
+	))
+	if err != nil {
+		t.Fatal("Config template engine failed:", err)
+	}
+	if config != reordered {
+		t.Errorf("Expected identical config regardless of ordering, but got:\n%s\nand:\n%s", config, reordered)
+	}
+}
+
+func TestSortedConfigLeavesModelUntouched(t *testing.T) {
+	routerConfig := newOrderTestConfig(
+		[]string{"foo", "bar"},
+		[]string{"www.example.com", "api.example.com"},
+		[]string{"10.0.0.2:80", "10.0.0.1:80"},
+		[]int{6000, 5432},
+	)
+	sorted := sortedConfig(routerConfig)
+	if expected := []string{"bar", "foo"}; !reflect.DeepEqual(expected, appNames(sorted.AppConfigs)) {
+		t.Errorf("Expected apps %v, but got %v", expected, appNames(sorted.AppConfigs))
+	}
+	if expected := []string{"10.0.0.1:80", "10.0.0.2:80"}; !reflect.DeepEqual(expected, sorted.AppConfigs[0].Locations[0].Endpoints) {
+		t.Errorf("Expected location endpoints %v, but got %v", expected, sorted.AppConfigs[0].Locations[0].Endpoints)
+	}
+	if sorted.StreamConfigs[0].ListenPort != 5432 {
+		t.Errorf("Expected the stream on port 5432 first, but got port %d", sorted.StreamConfigs[0].ListenPort)
+	}
+	if expected := []string{"foo", "bar"}; !reflect.DeepEqual(expected, appNames(routerConfig.AppConfigs)) {
+		t.Errorf("Expected the original apps to remain %v, but got %v", expected, appNames(routerConfig.AppConfigs))
+	}
+	if expected := []string{"www.example.com", "api.example.com"}; !reflect.DeepEqual(expected, routerConfig.AppConfigs[0].Domains) {
+		t.Errorf("Expected the original domains to remain %v, but got %v", expected, routerConfig.AppConfigs[0].Domains)
+	}
+}
@@ -0,0 +1,107 @@
+package nginx
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/template"
+)
+
+// TemplateOverridesDir is the directory, typically a mounted ConfigMap, from which templates that
+// override or supplement the built-in template are read.
+var TemplateOverridesDir = "/opt/router/templates"
+
+var (
+	rejectedOverridesMutex sync.Mutex
+	// rejectedOverrides is the digest of the template overrides with which nginx last rejected the
+	// configuration rendered.  They are not used again until they change.
+	rejectedOverrides string
+)
+
+// templateOverrides holds the template files found in TemplateOverridesDir, in the order in which
+// they are applied.
+type templateOverrides struct {
+	names  []string
+	texts  []string
+	digest string
+}
+
+// readTemplateOverrides reads every file in TemplateOverridesDir whose name ends in ".tmpl", in
+// lexical order.  It returns nil if there are none, or the directory does not exist.
+func readTemplateOverrides() (*templateOverrides, error) {
+	paths, err := filepath.Glob(filepath.Join(TemplateOverridesDir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	overrides := &templateOverrides{}
+	hash := sha1.New()
+	for _, path := range paths {
+		text, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			// The ConfigMap is being updated.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Base(path)
+		overrides.names = append(overrides.names, name)
+		overrides.texts = append(overrides.texts, string(text))
+		fmt.Fprintf(hash, "%s\x00%d\x00%s", name, len(text), text)
+	}
+	if len(overrides.names) == 0 {
+		return nil, nil
+	}
+	overrides.digest = hex.EncodeToString(hash.Sum(nil))
+	return overrides, nil
+}
+
+// apply parses each of the overrides into the provided template.  Templates an override defines
+// replace those of the same name, and an override whose body, outside of any define action, is not
+// empty replaces the main template.  Applying nil overrides leaves the template as it is.
+func (o *templateOverrides) apply(tmpl *template.Template) error {
+	if o == nil {
+		return nil
+	}
+	for i, name := range o.names {
+		if _, err := tmpl.Parse(o.texts[i]); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// rejected returns whether nginx rejected configuration rendered with these overrides.
+func (o *templateOverrides) rejected() bool {
+	rejectedOverridesMutex.Lock()
+	defer rejectedOverridesMutex.Unlock()
+	return o.digest == rejectedOverrides
+}
+
+// TemplateOverridesInEffect returns whether configuration is currently rendered with template
+// overrides.
+func TemplateOverridesInEffect() bool {
+	overrides, err := readTemplateOverrides()
+	return err == nil && overrides != nil && !overrides.rejected()
+}
+
+// RejectTemplateOverrides stops rendering configuration with the current template overrides, since
+// nginx rejected the configuration rendered with them.  Overrides are used again once they change.
+func RejectTemplateOverrides() {
+	overrides, err := readTemplateOverrides()
+	if err != nil || overrides == nil {
+		return
+	}
+	log.Printf("WARN: Rendering configuration with the built-in template until the template overrides in %s change.", TemplateOverridesDir)
+	rejectedOverridesMutex.Lock()
+	defer rejectedOverridesMutex.Unlock()
+	rejectedOverrides = overrides.digest
+}
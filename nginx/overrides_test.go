@@ -0,0 +1,100 @@
+package nginx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/deis/router/model"
+)
+
+func TestWriteConfigTemplateOverrides(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(dir string) { TemplateOverridesDir = dir }(TemplateOverridesDir)
+	TemplateOverridesDir = dir
+	defer func() { rejectedOverrides = "" }()
+	writeOverride := func(name string, text string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	routerConfig := model.RouterConfig{}
+	routerConfig.GzipConfig = &model.GzipConfig{}
+	routerConfig.SSLConfig = &model.SSLConfig{}
+	routerConfig.SSLConfig.HSTSConfig = &model.HSTSConfig{}
+	routerConfig.AppConfigs = []*model.AppConfig{
+		&model.AppConfig{
+			Name:            "foo",
+			Domains:         []string{"foo.example.com"},
+			ServiceIP:       "1.2.3.4",
+			ServicePort:     80,
+			Available:       true,
+			SSLConfig:       &model.SSLConfig{},
+			BackendProtocol: "http",
+		},
+	}
+
+	// Supplemental templates add to the built-in template.
+	writeOverride("10-http.tmpl", `{{ define "http-extra" }}variables_hash_max_size 2048;{{ end }}`)
+	writeOverride("20-server.tmpl", `{{ define "server-extra" }}add_header X-App {{ .Name }};{{ end }}`)
+	writeOverride("README.md", `{{ this is not a template`)
+	config, err := renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"variables_hash_max_size 2048;", "add_header X-App foo;", "server_name foo.example.com;"} {
+		if !strings.Contains(config, expected) {
+			t.Errorf("Expected the configuration to contain \"%s\", but it did not.", expected)
+		}
+	}
+	if !TemplateOverridesInEffect() {
+		t.Errorf("Expected template overrides to be in effect.")
+	}
+
+	// Once nginx rejects the configuration, the built-in template is used until the overrides change.
+	RejectTemplateOverrides()
+	if TemplateOverridesInEffect() {
+		t.Errorf("Expected rejected template overrides not to be in effect.")
+	}
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(config, "variables_hash_max_size") {
+		t.Errorf("Expected the built-in template to be used once the overrides were rejected.")
+	}
+	writeOverride("10-http.tmpl", `{{ define "http-extra" }}variables_hash_max_size 4096;{{ end }}`)
+	if !TemplateOverridesInEffect() {
+		t.Errorf("Expected changed template overrides to be in effect.")
+	}
+
+	// Overrides that cannot be rendered give way to the built-in template.
+	writeOverride("30-broken.tmpl", `{{ define "server-extra" }}{{ .NoSuchField }}{{ end }}`)
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(config, "variables_hash_max_size") || !strings.Contains(config, "server_name foo.example.com;") {
+		t.Errorf("Expected the built-in template to be used in place of overrides that failed to render.")
+	}
+
+	// A template with a body replaces the main template.
+	os.Remove(filepath.Join(dir, "30-broken.tmpl"))
+	writeOverride("00-main.tmpl", `# Custom
+{{ range $appConfig := .AppConfigs }}# {{ $appConfig.Name }}
+{{ end }}`)
+	config, err = renderConfig(&routerConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(config, "# Custom\n# foo\n") {
+		t.Errorf("Expected the main template to be replaced, but got:\n%s", config)
+	}
+}
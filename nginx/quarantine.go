@@ -0,0 +1,167 @@
+package nginx
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/deis/router/model"
+)
+
+// QuarantinedApp is an application whose changes were left out of nginx's configuration because
+// nginx rejected the configuration generated for it.
+type QuarantinedApp struct {
+	AppConfig *model.AppConfig
+	// Err is the *ValidationError describing why nginx rejected the application's configuration.
+	Err error
+	// Retained is whether the application's configuration last in effect was kept in place of the
+	// rejected one.  If not, the application was left out altogether.
+	Retained bool
+}
+
+// validator renders configuration that includes only the provided applications and reports whether
+// nginx accepts it.
+type validator func(appConfigs []*model.AppConfig) error
+
+// Quarantine determines which applications are to blame for nginx rejecting the configuration
+// generated for the provided model, so that their changes alone can be left out of it.  Each such
+// application keeps the configuration it had in the model last applied, if any, so that it is still
+// routed as it was; otherwise it is left out altogether.  The file at the specified path is used to
+// validate candidate configurations and, if this succeeds, is left holding the valid configuration
+// for the returned model.  If the configuration is rejected even without any applications, or
+// cannot be made valid by excluding individual applications, the problem lies elsewhere and an
+// error is returned.
+func Quarantine(routerConfig *model.RouterConfig, lastApplied *model.RouterConfig, filePath string) (*model.RouterConfig, []QuarantinedApp, error) {
+	validate := func(appConfigs []*model.AppConfig) error {
+		if err := WriteConfig(withApps(routerConfig, appConfigs), filePath); err != nil {
+			return err
+		}
+		return Validate(filePath)
+	}
+	var previous []*model.AppConfig
+	if lastApplied != nil {
+		previous = lastApplied.AppConfigs
+	}
+	quarantined, remaining, err := quarantine(routerConfig.AppConfigs, previous, validate)
+	if err != nil {
+		return nil, nil, err
+	}
+	return withApps(routerConfig, remaining), quarantined, nil
+}
+
+// quarantine partitions the provided applications into those whose configuration nginx rejects and
+// those that remain.  Rejected applications found among the previous ones are retained in their
+// previous configuration, where nginx still accepts it.  The last configuration validated is always
+// that of the remaining applications.
+func quarantine(appConfigs []*model.AppConfig, previous []*model.AppConfig, validate validator) ([]QuarantinedApp, []*model.AppConfig, error) {
+	if err := validate(nil); err != nil {
+		return nil, nil, err
+	}
+	quarantined, err := findInvalidApps(appConfigs, validate)
+	if err != nil {
+		return nil, nil, err
+	}
+	previousByKey := make(map[string]*model.AppConfig, len(previous))
+	for _, appConfig := range previous {
+		previousByKey[retentionKey(appConfig)] = appConfig
+	}
+	// The previous configuration of a rejected application may itself have become invalid, e.g. if
+	// a certificate it refers to was removed, so it is validated in turn.
+	retainable := []*model.AppConfig{}
+	for _, quarantinedApp := range quarantined {
+		if appConfig, ok := previousByKey[retentionKey(quarantinedApp.AppConfig)]; ok {
+			retainable = append(retainable, appConfig)
+		}
+	}
+	invalidRetainable, err := findInvalidApps(retainable, validate)
+	if err != nil {
+		return nil, nil, err
+	}
+	invalid := make(map[*model.AppConfig]bool, len(invalidRetainable))
+	for _, invalidApp := range invalidRetainable {
+		invalid[invalidApp.AppConfig] = true
+	}
+	substitutes := make(map[*model.AppConfig]*model.AppConfig, len(quarantined))
+	for _, quarantinedApp := range quarantined {
+		substitutes[quarantinedApp.AppConfig] = nil
+		if appConfig, ok := previousByKey[retentionKey(quarantinedApp.AppConfig)]; ok && !invalid[appConfig] {
+			substitutes[quarantinedApp.AppConfig] = appConfig
+		}
+	}
+	remaining := withSubstitutes(appConfigs, substitutes)
+	if err := validate(remaining); err != nil {
+		// Applications retained as they were may conflict with others' changes, in which case they
+		// are left out instead.
+		for appConfig := range substitutes {
+			substitutes[appConfig] = nil
+		}
+		remaining = withSubstitutes(appConfigs, substitutes)
+		// Applications that are each valid on their own may still conflict with one another, in
+		// which case no application can be singled out.
+		if err := validate(remaining); err != nil {
+			return nil, nil, err
+		}
+	}
+	for i, quarantinedApp := range quarantined {
+		quarantined[i].Retained = substitutes[quarantinedApp.AppConfig] != nil
+	}
+	return quarantined, remaining, nil
+}
+
+// retentionKey identifies an application across models by its name and domains.  Applications
+// built from the rules of a single ingress share a name, so the name alone would confuse one rule
+// with another.
+func retentionKey(appConfig *model.AppConfig) string {
+	domains := append([]string{}, appConfig.Domains...)
+	sort.Strings(domains)
+	return strings.Join(append([]string{appConfig.Name}, domains...), "\x00")
+}
+
+// withSubstitutes returns the provided applications, in order, with each found among the
+// substitutes replaced by its substitute, or left out if that is nil.
+func withSubstitutes(appConfigs []*model.AppConfig, substitutes map[*model.AppConfig]*model.AppConfig) []*model.AppConfig {
+	result := []*model.AppConfig{}
+	for _, appConfig := range appConfigs {
+		substitute, ok := substitutes[appConfig]
+		if !ok {
+			result = append(result, appConfig)
+		} else if substitute != nil {
+			result = append(result, substitute)
+		}
+	}
+	return result
+}
+
+// findInvalidApps bisects the provided applications to find those whose configuration nginx
+// rejects, so that only a handful of validations are needed when few applications are at fault.
+func findInvalidApps(appConfigs []*model.AppConfig, validate validator) ([]QuarantinedApp, error) {
+	if len(appConfigs) == 0 {
+		return nil, nil
+	}
+	err := validate(appConfigs)
+	if err == nil {
+		return nil, nil
+	}
+	if _, ok := err.(*ValidationError); !ok {
+		return nil, err
+	}
+	if len(appConfigs) == 1 {
+		return []QuarantinedApp{{AppConfig: appConfigs[0], Err: err}}, nil
+	}
+	mid := len(appConfigs) / 2
+	quarantined, err := findInvalidApps(appConfigs[:mid], validate)
+	if err != nil {
+		return nil, err
+	}
+	more, err := findInvalidApps(appConfigs[mid:], validate)
+	if err != nil {
+		return nil, err
+	}
+	return append(quarantined, more...), nil
+}
+
+// withApps returns a copy of the provided model that includes only the specified applications.
+func withApps(routerConfig *model.RouterConfig, appConfigs []*model.AppConfig) *model.RouterConfig {
+	scoped := *routerConfig
+	scoped.AppConfigs = appConfigs
+	return &scoped
+}
@@ -0,0 +1,197 @@
+package nginx
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/deis/router/model"
+)
+
+// newTestValidator returns a validator that rejects any configuration including one of the named
+// applications, or both of a conflicting pair, and counts how many validations it performs.
+func newTestValidator(invalid map[string]bool, conflicting []string, validations *int) validator {
+	return func(appConfigs []*model.AppConfig) error {
+		*validations++
+		present := map[string]bool{}
+		for _, appConfig := range appConfigs {
+			if invalid[appConfig.Name] {
+				return &ValidationError{Output: "nginx: [emerg] invalid " + appConfig.Name}
+			}
+			present[appConfig.Name] = true
+		}
+		if len(conflicting) == 2 && present[conflicting[0]] && present[conflicting[1]] {
+			return &ValidationError{Output: "nginx: [emerg] conflict"}
+		}
+		return nil
+	}
+}
+
+func newTestApps(names ...string) []*model.AppConfig {
+	appConfigs := []*model.AppConfig{}
+	for _, name := range names {
+		appConfigs = append(appConfigs, &model.AppConfig{Name: name})
+	}
+	return appConfigs
+}
+
+func appNames(appConfigs []*model.AppConfig) []string {
+	names := []string{}
+	for _, appConfig := range appConfigs {
+		names = append(names, appConfig.Name)
+	}
+	return names
+}
+
+func TestQuarantine(t *testing.T) {
+	appConfigs := newTestApps("a", "b", "c", "d", "e", "f", "g", "h")
+	validations := 0
+	validate := newTestValidator(map[string]bool{"c": true, "h": true}, nil, &validations)
+
+	quarantined, remaining, err := quarantine(appConfigs, nil, validate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quarantinedApps := []*model.AppConfig{}
+	for _, quarantinedApp := range quarantined {
+		quarantinedApps = append(quarantinedApps, quarantinedApp.AppConfig)
+		if _, ok := quarantinedApp.Err.(*ValidationError); !ok {
+			t.Errorf("Expected a *ValidationError for app %s, but got %v", quarantinedApp.AppConfig.Name, quarantinedApp.Err)
+		}
+	}
+	if expected := []string{"c", "h"}; !reflect.DeepEqual(expected, appNames(quarantinedApps)) {
+		t.Errorf("Expected apps %v to be quarantined, but got %v", expected, appNames(quarantinedApps))
+	}
+	if expected := []string{"a", "b", "d", "e", "f", "g"}; !reflect.DeepEqual(expected, appNames(remaining)) {
+		t.Errorf("Expected apps %v to remain, but got %v", expected, appNames(remaining))
+	}
+}
+
+func TestQuarantineValidations(t *testing.T) {
+	names := []string{}
+	for i := 0; i < 64; i++ {
+		names = append(names, fmt.Sprintf("app%d", i))
+	}
+	validations := 0
+	validate := newTestValidator(map[string]bool{"app42": true}, nil, &validations)
+	if _, _, err := quarantine(newTestApps(names...), nil, validate); err != nil {
+		t.Fatal(err)
+	}
+	// Bisection should need far fewer validations than validating each application in turn.
+	if validations > 20 {
+		t.Errorf("Expected at most 20 validations, but got %d", validations)
+	}
+}
+
+func TestQuarantineGlobalProblem(t *testing.T) {
+	validate := func(appConfigs []*model.AppConfig) error {
+		return &ValidationError{Output: "nginx: [emerg] invalid http snippet"}
+	}
+	if _, _, err := quarantine(newTestApps("a", "b"), nil, validate); err == nil {
+		t.Error("Expected an error when configuration is invalid without any applications")
+	}
+}
+
+func TestQuarantineConflict(t *testing.T) {
+	validations := 0
+	validate := newTestValidator(nil, []string{"a", "d"}, &validations)
+	if _, _, err := quarantine(newTestApps("a", "b", "c", "d"), nil, validate); err == nil {
+		t.Error("Expected an error when applications are only invalid in combination")
+	}
+}
+
+func TestQuarantineRetainsPrevious(t *testing.T) {
+	appConfigs := newTestApps("a", "b", "c", "d")
+	previous := newTestApps("a", "b", "c")
+	// The new configuration of b and c is rejected, as is c's previous configuration.
+	validate := func(appConfigs []*model.AppConfig) error {
+		for _, appConfig := range appConfigs {
+			if (appConfig.Name == "b" && appConfig != previous[1]) || appConfig.Name == "c" {
+				return &ValidationError{Output: "nginx: [emerg] invalid " + appConfig.Name}
+			}
+		}
+		return nil
+	}
+	quarantined, remaining, err := quarantine(appConfigs, previous, validate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retained := map[string]bool{}
+	for _, quarantinedApp := range quarantined {
+		retained[quarantinedApp.AppConfig.Name] = quarantinedApp.Retained
+	}
+	if expected := map[string]bool{"b": true, "c": false}; !reflect.DeepEqual(expected, retained) {
+		t.Errorf("Expected quarantined apps to be retained as %v, but got %v", expected, retained)
+	}
+	if expected := []string{"a", "b", "d"}; !reflect.DeepEqual(expected, appNames(remaining)) {
+		t.Fatalf("Expected apps %v to remain, but got %v", expected, appNames(remaining))
+	}
+	if remaining[1] != previous[1] {
+		t.Error("Expected app b to remain in its previous configuration")
+	}
+}
+
+func TestQuarantineRetainedConflict(t *testing.T) {
+	appConfigs := newTestApps("a", "b", "c")
+	previous := newTestApps("b")
+	// b's new configuration is rejected, and its previous configuration conflicts with c.
+	validate := func(appConfigs []*model.AppConfig) error {
+		present := map[string]bool{}
+		for _, appConfig := range appConfigs {
+			if appConfig.Name == "b" && appConfig != previous[0] {
+				return &ValidationError{Output: "nginx: [emerg] invalid b"}
+			}
+			present[appConfig.Name] = true
+		}
+		if present["b"] && present["c"] {
+			return &ValidationError{Output: "nginx: [emerg] conflict"}
+		}
+		return nil
+	}
+	quarantined, remaining, err := quarantine(appConfigs, previous, validate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 1 || quarantined[0].Retained {
+		t.Errorf("Expected app b to be quarantined without being retained, but got %v", quarantined)
+	}
+	if expected := []string{"a", "c"}; !reflect.DeepEqual(expected, appNames(remaining)) {
+		t.Errorf("Expected apps %v to remain, but got %v", expected, appNames(remaining))
+	}
+}
+
+func TestQuarantineIngressRules(t *testing.T) {
+	// Rules of a single ingress share a name, and only the rejected rule's own previous
+	// configuration is retained.
+	appConfigs := []*model.AppConfig{
+		{Name: "ns/ingress", Domains: []string{"a.example.com"}},
+		{Name: "ns/ingress", Domains: []string{"b.example.com"}},
+	}
+	previous := []*model.AppConfig{
+		{Name: "ns/ingress", Domains: []string{"a.example.com"}},
+		{Name: "ns/ingress", Domains: []string{"b.example.com"}},
+	}
+	validate := func(appConfigs []*model.AppConfig) error {
+		domains := map[string]bool{}
+		for _, appConfig := range appConfigs {
+			if appConfig.Domains[0] == "a.example.com" && appConfig != previous[0] {
+				return &ValidationError{Output: "nginx: [emerg] invalid a"}
+			}
+			if domains[appConfig.Domains[0]] {
+				return &ValidationError{Output: "nginx: [emerg] duplicate upstream"}
+			}
+			domains[appConfig.Domains[0]] = true
+		}
+		return nil
+	}
+	quarantined, remaining, err := quarantine(appConfigs, previous, validate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 1 || !quarantined[0].Retained {
+		t.Fatalf("Expected the rule for a.example.com to be quarantined and retained, but got %v", quarantined)
+	}
+	if len(remaining) != 2 || remaining[0] != previous[0] || remaining[1] != appConfigs[1] {
+		t.Errorf("Expected the previous rule for a.example.com and the new rule for b.example.com to remain, but got %v", remaining)
+	}
+}
@@ -0,0 +1,107 @@
+package nginx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// snapshotTimeout bounds how long fetching another router's configuration may take.
+const snapshotTimeout = 10 * time.Second
+
+// ConfigSnapshot holds the contents of a main configuration file and the applications' files it
+// includes, keyed by their paths relative to the main file's directory, such as "nginx.conf" or
+// "conf.d/app-foo.conf".
+type ConfigSnapshot map[string]string
+
+// ReadConfigSnapshot reads the configuration at the specified path, and the applications' files
+// alongside it, into a snapshot.
+func ReadConfigSnapshot(filePath string) (ConfigSnapshot, error) {
+	config, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := ConfigSnapshot{filepath.Base(filePath): string(config)}
+	appsPath := filepath.Join(filepath.Dir(filePath), appConfigDir)
+	fileNames, err := appConfigFileNames(appsPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, fileName := range fileNames {
+		appConfig, err := ioutil.ReadFile(filepath.Join(appsPath, fileName))
+		if err != nil {
+			return nil, err
+		}
+		snapshot[filepath.Join(appConfigDir, fileName)] = string(appConfig)
+	}
+	return snapshot, nil
+}
+
+// Write replaces the configuration at the specified path, and the applications' files alongside it,
+// with the snapshot's.  The snapshot may only name the main file and applications' files, so that,
+// wherever it came from, it cannot write anywhere else.
+func (s ConfigSnapshot) Write(filePath string) error {
+	mainFileName := filepath.Base(filePath)
+	for name := range s {
+		if name != mainFileName && !isAppConfigFile(name) {
+			return fmt.Errorf("configuration snapshot contains unexpected file %s", name)
+		}
+	}
+	appsPath := filepath.Join(filepath.Dir(filePath), appConfigDir)
+	if err := os.RemoveAll(appsPath); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(appsPath, 0755); err != nil {
+		return err
+	}
+	for name, config := range s {
+		if err := ioutil.WriteFile(filepath.Join(filepath.Dir(filePath), name), []byte(config), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isAppConfigFile(name string) bool {
+	dir, fileName := filepath.Split(name)
+	return dir == appConfigDir+"/" && strings.HasPrefix(fileName, appConfigPrefix) && strings.HasSuffix(fileName, ".conf")
+}
+
+// ConfigHandler returns an http.Handler that responds with a JSON snapshot of the configuration at
+// the specified path, so that a router running in shadow mode can compare its own configuration
+// with it.
+func ConfigHandler(filePath string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		snapshot, err := ReadConfigSnapshot(filePath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(snapshot)
+	})
+}
+
+// FetchConfigSnapshot retrieves a snapshot of another router's configuration from the specified
+// URL, at which that router's ConfigHandler is served.
+func FetchConfigSnapshot(url string) (ConfigSnapshot, error) {
+	client := &http.Client{Timeout: snapshotTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %s", url, resp.Status)
+	}
+	snapshot := ConfigSnapshot{}
+	if err := json.NewDecoder(resp.Body).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
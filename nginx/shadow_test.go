@@ -0,0 +1,73 @@
+package nginx
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfigSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	activePath := filepath.Join(dir, "active", "nginx.conf")
+	shadowPath := filepath.Join(dir, "shadow", "nginx.conf")
+	for path, config := range map[string]string{
+		activePath: "http {\n\tinclude conf.d/*.conf;\n}\n",
+		filepath.Join(dir, "active", "conf.d", "app-foo.conf"): "server_name foo.example.com;\n",
+		filepath.Join(dir, "active", "conf.d", "other.txt"):    "ignored\n",
+		filepath.Join(dir, "shadow", "conf.d", "app-bar.conf"): "server_name bar.example.com;\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := httptest.NewServer(ConfigHandler(activePath))
+	defer server.Close()
+	snapshot, err := FetchConfigSnapshot(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ConfigSnapshot{
+		"nginx.conf":          "http {\n\tinclude conf.d/*.conf;\n}\n",
+		"conf.d/app-foo.conf": "server_name foo.example.com;\n",
+	}
+	if !reflect.DeepEqual(expected, snapshot) {
+		t.Fatalf("Expected snapshot %v, but got %v", expected, snapshot)
+	}
+
+	// Writing the snapshot replaces the configuration, including any applications' files it lacks.
+	if err := snapshot.Write(shadowPath); err != nil {
+		t.Fatal(err)
+	}
+	diff, err := Diff(activePath, shadowPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 0 {
+		t.Errorf("Expected written snapshot to match the original configuration, but got %v", diff)
+	}
+}
+
+func TestConfigSnapshotWriteRejectsUnexpectedFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"../nginx.conf", "conf.d/../../evil.conf", "conf.d/app-foo.txt", "ssl/platform.key"} {
+		snapshot := ConfigSnapshot{"nginx.conf": "", name: ""}
+		if err := snapshot.Write(filepath.Join(dir, "nginx.conf")); err == nil {
+			t.Errorf("Expected snapshot containing %s to be rejected", name)
+		}
+	}
+}
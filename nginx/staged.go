@@ -0,0 +1,62 @@
+package nginx
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/deis/router/model"
+)
+
+const (
+	// stagedStatsPort is the port on which a staged instance of nginx reports its traffic.
+	stagedStatsPort = 9095
+	// stagedStartupGrace is how long a staged instance is given to fail to start, as it does at once
+	// if it cannot listen on the ports it shares.
+	stagedStartupGrace = 2 * time.Second
+)
+
+// StagedStatsURL is the location at which a staged instance of nginx reports its traffic.
+var StagedStatsURL = fmt.Sprintf("http://127.0.0.1:%d/stats", stagedStatsPort)
+
+// StagedRollout returns whether new configuration is to be tried by a staged instance of nginx
+// before it is applied.  Connections relayed over a unix socket by the SNI connection limit cannot
+// be shared between instances, so staged rollout does not apply while that limit is in force.
+func StagedRollout(routerConfig *model.RouterConfig) bool {
+	return routerConfig.StagedRolloutConfig != nil && routerConfig.StagedRolloutConfig.Enabled && !sniLimited(routerConfig)
+}
+
+// StartStaged starts a staged instance of nginx with the configuration at the specified path, which
+// WriteStagedConfig wrote.
+func StartStaged(filePath string) error {
+	log.Println("INFO: Starting staged nginx...")
+	cmd := exec.Command(nginxBinary, "-c", filePath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return fmt.Errorf("staged nginx exited at once: %v", err)
+	case <-time.After(stagedStartupGrace):
+	}
+	log.Println("INFO: Staged nginx started.")
+	return nil
+}
+
+// QuitStaged shuts the staged instance of nginx with the configuration at the specified path down
+// gracefully.
+func QuitStaged(filePath string) error {
+	log.Println("INFO: Shutting staged nginx down...")
+	cmd := exec.Command(nginxBinary, "-c", filePath, "-s", "quit")
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,142 @@
+// Package templatefuncs provides the functions available to templates from which nginx
+// configuration is rendered.  Functions that can be handed values they cannot render return an
+// error rather than guess, which aborts rendering instead of producing configuration nginx might
+// misinterpret.
+package templatefuncs
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	durationRegexp = regexp.MustCompile("^[0-9]+(ms|[smhdwMy])?$")
+	sizeRegexp     = regexp.MustCompile("^[0-9]+[kKmMgG]?$")
+	fileNameRegexp = regexp.MustCompile("^(\\*\\.)?[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$")
+	stringEscaper  = strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+)
+
+// FuncMap returns the functions provided by this package, keyed by the names by which templates
+// refer to them.
+func FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"escapeString": EscapeString,
+		"quoteMeta":    regexp.QuoteMeta,
+		"duration":     Duration,
+		"size":         Size,
+		"fileName":     FileName,
+		"boolean":      Boolean,
+		"onOff":        OnOff,
+	}
+}
+
+// EscapeString escapes the provided value for inclusion within a double-quoted string in nginx
+// configuration.
+func EscapeString(value string) string {
+	return stringEscaper.Replace(value)
+}
+
+// Duration returns the provided value as an nginx time, such as "30s".  The value may be a string
+// that is already an nginx time, a string that time.ParseDuration understands, such as "1m30s", a
+// time.Duration, or an integral number of seconds.  Negative durations, and durations that nginx
+// cannot represent exactly, are errors.
+func Duration(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		if durationRegexp.MatchString(v) {
+			return v, nil
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return "", fmt.Errorf("\"%s\" is not a valid duration", v)
+		}
+		return formatDuration(d)
+	case time.Duration:
+		return formatDuration(v)
+	case int:
+		return formatDuration(time.Duration(v) * time.Second)
+	case int64:
+		return formatDuration(time.Duration(v) * time.Second)
+	}
+	return "", fmt.Errorf("cannot use %#v as a duration", value)
+}
+
+func formatDuration(d time.Duration) (string, error) {
+	switch {
+	case d < 0:
+		return "", fmt.Errorf("duration %s is negative", d)
+	case d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second), nil
+	case d%time.Millisecond == 0:
+		return fmt.Sprintf("%dms", d/time.Millisecond), nil
+	}
+	return "", fmt.Errorf("duration %s is more precise than a millisecond", d)
+}
+
+// Size returns the provided value as an nginx size, such as "1m".  The value may be a string that
+// is already an nginx size or a non-negative integral number of bytes.
+func Size(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		if sizeRegexp.MatchString(v) {
+			return v, nil
+		}
+		return "", fmt.Errorf("\"%s\" is not a valid size", v)
+	case int:
+		if v >= 0 {
+			return strconv.Itoa(v), nil
+		}
+	case int64:
+		if v >= 0 {
+			return strconv.FormatInt(v, 10), nil
+		}
+	}
+	return "", fmt.Errorf("cannot use %#v as a size", value)
+}
+
+// FileName returns the base name, without extension, of files holding configuration that pertains
+// to the provided domain, such as its certificate.  Domains are case-insensitive, so the name is
+// lower-cased.  Anything but a domain name, or a wildcard domain name, is an error, so that the
+// name can never refer to a file in another directory.
+func FileName(domain string) (string, error) {
+	name := strings.ToLower(domain)
+	if !fileNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("\"%s\" is not a valid domain", domain)
+	}
+	return name, nil
+}
+
+// Boolean coerces the provided value to a bool.  The value may be a bool, or one of the strings
+// "true", "on", "yes", "1", "false", "off", "no", "0", or "" in any case.
+func Boolean(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "on", "yes", "1":
+			return true, nil
+		case "false", "off", "no", "0", "":
+			return false, nil
+		}
+		return false, fmt.Errorf("\"%s\" is not a valid boolean", v)
+	}
+	return false, fmt.Errorf("cannot use %#v as a boolean", value)
+}
+
+// OnOff returns "on" or "off", the values nginx expects of flag directives, for the provided
+// value, which is coerced to a bool as by Boolean.
+func OnOff(value interface{}) (string, error) {
+	b, err := Boolean(value)
+	if err != nil {
+		return "", err
+	}
+	if b {
+		return "on", nil
+	}
+	return "off", nil
+}
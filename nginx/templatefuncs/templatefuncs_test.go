@@ -0,0 +1,149 @@
+package templatefuncs
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestEscapeString(t *testing.T) {
+	if actual := EscapeString(`say "hi" \ bye`); actual != `say \"hi\" \\ bye` {
+		t.Errorf("Expected the string to be escaped, but got %s", actual)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	valid := []struct {
+		value    interface{}
+		expected string
+	}{
+		{"30s", "30s"},
+		{"500ms", "500ms"},
+		{"2M", "2M"},
+		{"30", "30"},
+		{"1m30s", "90s"},
+		{"1.5s", "1500ms"},
+		{90 * time.Second, "90s"},
+		{10, "10s"},
+		{int64(0), "0s"},
+	}
+	for _, test := range valid {
+		actual, err := Duration(test.value)
+		if err != nil {
+			t.Errorf("Expected %#v to be a valid duration, but got %v", test.value, err)
+		} else if actual != test.expected {
+			t.Errorf("Expected %#v to be formatted as \"%s\", but got \"%s\"", test.value, test.expected, actual)
+		}
+	}
+	for _, value := range []interface{}{"", "thirty", "-5s", "1.5ms", -1, 1.5, nil} {
+		if actual, err := Duration(value); err == nil {
+			t.Errorf("Expected %#v to be an invalid duration, but got \"%s\"", value, actual)
+		}
+	}
+}
+
+func TestSize(t *testing.T) {
+	valid := []struct {
+		value    interface{}
+		expected string
+	}{
+		{"1m", "1m"},
+		{"512K", "512K"},
+		{"0", "0"},
+		{1024, "1024"},
+		{int64(2048), "2048"},
+	}
+	for _, test := range valid {
+		actual, err := Size(test.value)
+		if err != nil {
+			t.Errorf("Expected %#v to be a valid size, but got %v", test.value, err)
+		} else if actual != test.expected {
+			t.Errorf("Expected %#v to be formatted as \"%s\", but got \"%s\"", test.value, test.expected, actual)
+		}
+	}
+	for _, value := range []interface{}{"", "1mb", "1.5m", "-1", -1, true} {
+		if actual, err := Size(value); err == nil {
+			t.Errorf("Expected %#v to be an invalid size, but got \"%s\"", value, actual)
+		}
+	}
+}
+
+func TestFileName(t *testing.T) {
+	valid := map[string]string{
+		"www.example.com":   "www.example.com",
+		"WWW.Example.com":   "www.example.com",
+		"*.example.com":     "*.example.com",
+		"localhost":         "localhost",
+		"my-app.example.io": "my-app.example.io",
+	}
+	for domain, expected := range valid {
+		actual, err := FileName(domain)
+		if err != nil {
+			t.Errorf("Expected \"%s\" to be a valid domain, but got %v", domain, err)
+		} else if actual != expected {
+			t.Errorf("Expected \"%s\" to map to \"%s\", but got \"%s\"", domain, expected, actual)
+		}
+	}
+	for _, domain := range []string{"", "..", "../etc/passwd", "foo/bar", "foo..bar", "-foo.com", "foo.*.com", "foo.com:80"} {
+		if actual, err := FileName(domain); err == nil {
+			t.Errorf("Expected \"%s\" to be an invalid domain, but got \"%s\"", domain, actual)
+		}
+	}
+}
+
+func TestBoolean(t *testing.T) {
+	valid := map[interface{}]bool{
+		true:    true,
+		false:   false,
+		"true":  true,
+		"TRUE":  true,
+		"on":    true,
+		"yes":   true,
+		"1":     true,
+		"false": false,
+		"Off":   false,
+		"no":    false,
+		"0":     false,
+		"":      false,
+	}
+	for value, expected := range valid {
+		actual, err := Boolean(value)
+		if err != nil {
+			t.Errorf("Expected %#v to be a valid boolean, but got %v", value, err)
+		} else if actual != expected {
+			t.Errorf("Expected %#v to be coerced to %t, but got %t", value, expected, actual)
+		}
+	}
+	for _, value := range []interface{}{"maybe", 1, nil} {
+		if _, err := Boolean(value); err == nil {
+			t.Errorf("Expected %#v to be an invalid boolean", value)
+		}
+	}
+}
+
+func TestOnOff(t *testing.T) {
+	if actual, err := OnOff("yes"); err != nil || actual != "on" {
+		t.Errorf("Expected \"on\", but got \"%s\" (%v)", actual, err)
+	}
+	if actual, err := OnOff(false); err != nil || actual != "off" {
+		t.Errorf("Expected \"off\", but got \"%s\" (%v)", actual, err)
+	}
+	if _, err := OnOff("maybe"); err == nil {
+		t.Error("Expected an error for an invalid boolean")
+	}
+}
+
+func TestFuncMapErrorsAbortRendering(t *testing.T) {
+	tmpl := template.Must(template.New("test").Funcs(FuncMap()).Parse(`proxy_read_timeout {{ duration . }};`))
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, "1m"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "proxy_read_timeout 1m;" {
+		t.Errorf("Expected \"proxy_read_timeout 1m;\", but got \"%s\"", out.String())
+	}
+	if err := tmpl.Execute(&bytes.Buffer{}, "forever"); err == nil {
+		t.Error("Expected rendering to fail for an invalid duration")
+	}
+}
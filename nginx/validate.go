@@ -0,0 +1,190 @@
+package nginx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxDiffLines bounds how many added and removed lines are reported when configuration fails
+	// validation.
+	maxDiffLines = 50
+	// errorContextLines is the number of lines reported on either side of the line nginx blames for
+	// a validation failure.
+	errorContextLines = 3
+)
+
+// ValidationError describes configuration that nginx refused to load.
+type ValidationError struct {
+	// Output is nginx's own explanation of what is wrong with the configuration.
+	Output string
+	// Context is the offending region of the configuration, if nginx identified it.
+	Context []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("nginx rejected the configuration: %s", strings.TrimSpace(e.Output))
+}
+
+// Validate has nginx test the configuration at the specified path without loading it.  If nginx
+// rejects the configuration, the error is a *ValidationError.
+func Validate(filePath string) error {
+	output, err := exec.Command(nginxBinary, "-t", "-c", filePath).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*exec.ExitError); !ok {
+		return err
+	}
+	validationErr := &ValidationError{Output: string(output)}
+	// nginx may blame the main file or any application's file that it includes.
+	blamedPath := filePath
+	if match := blamedFileRegexp.FindStringSubmatch(validationErr.Output); match != nil {
+		blamedPath = match[1]
+	}
+	if config, err := ioutil.ReadFile(blamedPath); err == nil {
+		validationErr.Context = errorContext(string(config), blamedPath, validationErr.Output)
+	}
+	return validationErr
+}
+
+var blamedFileRegexp = regexp.MustCompile(` in (/\S+):\d+`)
+
+// errorContext returns the lines of the provided configuration surrounding the first line of the
+// file at the specified path that nginx's output refers to, each prefixed with its line number.
+func errorContext(config string, filePath string, output string) []string {
+	match := regexp.MustCompile(regexp.QuoteMeta(filePath) + `:(\d+)`).FindStringSubmatch(output)
+	if match == nil {
+		return nil
+	}
+	lineNumber, err := strconv.Atoi(match[1])
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(config, "\n")
+	first := lineNumber - errorContextLines
+	if first < 1 {
+		first = 1
+	}
+	last := lineNumber + errorContextLines
+	if last > len(lines) {
+		last = len(lines)
+	}
+	context := []string{}
+	for i := first; i <= last; i++ {
+		marker := " "
+		if i == lineNumber {
+			marker = ">"
+		}
+		context = append(context, fmt.Sprintf("%s%5d: %s", marker, i, lines[i-1]))
+	}
+	return context
+}
+
+// Diff returns the lines, prefixed with "-" or "+", that differ between the configuration files at
+// the specified paths.  Lines are compared without regard to their order or indentation, which
+// suffices to pinpoint what changed in generated configuration without the expense of a true diff.
+// At most maxDiffLines lines are returned in each direction.  A missing file is treated as empty.
+// Applications' files are compared as well, and the differences in each are preceded by its name.
+func Diff(oldPath string, newPath string) ([]string, error) {
+	diff, err := diffFiles(oldPath, newPath)
+	if err != nil {
+		return nil, err
+	}
+	oldAppsPath := filepath.Join(filepath.Dir(oldPath), appConfigDir)
+	newAppsPath := filepath.Join(filepath.Dir(newPath), appConfigDir)
+	fileNames, err := appConfigFileNames(oldAppsPath, newAppsPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, fileName := range fileNames {
+		appDiff, err := diffFiles(filepath.Join(oldAppsPath, fileName), filepath.Join(newAppsPath, fileName))
+		if err != nil {
+			return nil, err
+		}
+		if len(appDiff) > 0 {
+			diff = append(diff, fmt.Sprintf("# %s", filepath.Join(appConfigDir, fileName)))
+			diff = append(diff, appDiff...)
+		}
+	}
+	return diff, nil
+}
+
+func diffFiles(oldPath string, newPath string) ([]string, error) {
+	oldLines, err := readLines(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newLines, err := readLines(newPath)
+	if err != nil {
+		return nil, err
+	}
+	return append(subtractLines("-", oldLines, newLines), subtractLines("+", newLines, oldLines)...), nil
+}
+
+// appConfigFileNames returns the sorted names of the applications' files found in either of the
+// specified directories.
+func appConfigFileNames(dirs ...string) ([]string, error) {
+	seen := map[string]bool{}
+	fileNames := []string{}
+	for _, dir := range dirs {
+		paths, err := filepath.Glob(filepath.Join(dir, appConfigPrefix+"*.conf"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			if fileName := filepath.Base(path); !seen[fileName] {
+				seen[fileName] = true
+				fileNames = append(fileNames, fileName)
+			}
+		}
+	}
+	sort.Strings(fileNames)
+	return fileNames, nil
+}
+
+func readLines(filePath string) ([]string, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, nil
+}
+
+// subtractLines returns, each with the specified prefix, the lines of a that remain after removing
+// one occurrence of each line of b.
+func subtractLines(prefix string, a []string, b []string) []string {
+	counts := make(map[string]int, len(b))
+	for _, line := range b {
+		counts[line]++
+	}
+	difference := []string{}
+	for _, line := range a {
+		if counts[line] > 0 {
+			counts[line]--
+			continue
+		}
+		if len(difference) == maxDiffLines {
+			difference = append(difference, fmt.Sprintf("%s ...", prefix))
+			break
+		}
+		difference = append(difference, fmt.Sprintf("%s %s", prefix, line))
+	}
+	return difference
+}
@@ -0,0 +1,98 @@
+package nginx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestErrorContext(t *testing.T) {
+	config := "http {\n\tserver {\n\t\tlisten 8080;\n\t\tbogus on;\n\t}\n}\n"
+	output := "nginx: [emerg] unknown directive \"bogus\" in /opt/router/conf/nginx.conf.staged:4\n" +
+		"nginx: configuration file /opt/router/conf/nginx.conf.staged test failed\n"
+
+	expected := []string{
+		"     1: http {",
+		"     2: \tserver {",
+		"     3: \t\tlisten 8080;",
+		">    4: \t\tbogus on;",
+		"     5: \t}",
+		"     6: }",
+		"     7: ",
+	}
+	actual := errorContext(config, "/opt/router/conf/nginx.conf.staged", output)
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected context %q, but got %q.", expected, actual)
+	}
+	if actual := errorContext(config, "/opt/router/conf/nginx.conf.staged", "nginx: [emerg] out of memory"); actual != nil {
+		t.Errorf("Expected no context when nginx does not identify a line, but got %q.", actual)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldPath := filepath.Join(dir, "old.conf")
+	newPath := filepath.Join(dir, "new.conf")
+	if err := ioutil.WriteFile(oldPath, []byte("server {\n\tlisten 8080;\n\treturn 200;\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(newPath, []byte("server {\n    listen 8080;\n\tbogus on;\n\treturn 200;\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	diff, err := Diff(oldPath, newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"+ bogus on;"}; !reflect.DeepEqual(expected, diff) {
+		t.Errorf("Expected diff %q, but got %q.", expected, diff)
+	}
+
+	diff, err = Diff(filepath.Join(dir, "missing.conf"), oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 4 {
+		t.Errorf("Expected every line to be added when the old configuration is missing, but got %q.", diff)
+	}
+}
+
+func TestDiffAppFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldPath := filepath.Join(dir, "nginx.conf")
+	newPath := filepath.Join(dir, "staged", "nginx.conf")
+	files := map[string]string{
+		oldPath: "http {\n\tinclude conf.d/*.conf;\n}\n",
+		newPath: "http {\n\tinclude conf.d/*.conf;\n}\n",
+		filepath.Join(dir, "conf.d", "app-foo.conf"):           "server {\n\tlisten 8080;\n}\n",
+		filepath.Join(dir, "staged", "conf.d", "app-foo.conf"): "server {\n\tlisten 8080;\n\tbogus on;\n}\n",
+		filepath.Join(dir, "conf.d", "app-bar.conf"):           "server {\n\tlisten 8080;\n}\n",
+		filepath.Join(dir, "staged", "conf.d", "app-bar.conf"): "server {\n\tlisten 8080;\n}\n",
+	}
+	for path, contents := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	diff, err := Diff(oldPath, newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"# conf.d/app-foo.conf", "+ bogus on;"}; !reflect.DeepEqual(expected, diff) {
+		t.Errorf("Expected diff %q, but got %q.", expected, diff)
+	}
+}
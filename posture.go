@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/deis/router/model"
+)
+
+// postureReport holds the security posture of each application in the configuration most recently
+// applied, and serves it as JSON.
+type postureReport struct {
+	mutex    sync.Mutex
+	postures []model.AppPosture
+}
+
+// update records the posture of each application in the provided configuration.
+func (r *postureReport) update(routerConfig *model.RouterConfig) {
+	postures := routerConfig.Posture()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.postures = postures
+}
+
+func (r *postureReport) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.postures == nil {
+		http.Error(w, "No configuration has been applied yet.", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.postures)
+}
@@ -0,0 +1,223 @@
+// Package probe periodically requests each application through the router's own nginx, just as a
+// client would, so that routes that are broken, by configuration or by the application, are
+// noticed even where external monitoring does not reach.
+package probe
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deis/router/metrics"
+	"github.com/deis/router/model"
+	"github.com/deis/router/utils/modeler"
+)
+
+const (
+	// httpAddr and httpsAddr are the addresses at which nginx serves HTTP and HTTPS in the router's
+	// pod.
+	httpAddr  = "127.0.0.1:8080"
+	httpsAddr = "127.0.0.1:6443"
+	// wildcardLabel stands in for the wildcard of a wildcard domain when probing it.
+	wildcardLabel = "deis-router-probe"
+	userAgent     = "deis-router-probe"
+)
+
+// target is an application to be probed, and how.
+type target struct {
+	app    string
+	scheme string
+	host   string
+	path   string
+}
+
+// result is the outcome of probing one target.
+type result struct {
+	reachable bool
+	duration  time.Duration
+}
+
+// Prober probes the applications of the configuration most recently applied.
+type Prober struct {
+	mutex    sync.Mutex
+	targets  []target
+	interval time.Duration
+	timeout  time.Duration
+	running  bool
+	// reachable records whether each application was reachable when last probed.
+	reachable map[string]bool
+	// probe requests the target through nginx, and reports whether the application was reachable.
+	probe func(target, time.Duration) bool
+}
+
+// NewProber returns a new Prober that is not yet probing any application.
+func NewProber() *Prober {
+	return &Prober{reachable: map[string]bool{}, probe: get}
+}
+
+// Apply replaces the applications being probed with those of the provided configuration, and starts
+// probing them if probes are enabled and have not yet been started.
+func (p *Prober) Apply(routerConfig *model.RouterConfig) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	probeConfig := routerConfig.ProbeConfig
+	p.targets = nil
+	if probeConfig == nil || !probeConfig.Enabled {
+		return
+	}
+	interval, err := modeler.ParseDuration(probeConfig.Interval)
+	if err != nil || interval <= 0 {
+		return
+	}
+	timeout, err := modeler.ParseDuration(probeConfig.Timeout)
+	if err != nil || timeout <= 0 {
+		return
+	}
+	p.interval = interval
+	p.timeout = timeout
+	p.targets = newTargets(routerConfig)
+	if !p.running {
+		p.running = true
+		go p.run()
+	}
+}
+
+// newTargets returns a target for each application in the provided configuration that can be
+// probed.  An application is requested by its first domain, over HTTPS if it has a certificate for
+// that domain, and otherwise over HTTP, since nginx redirects HTTP requests for such domains to
+// HTTPS if SSL is enforced.  Applications in maintenance mode, which are answered by nginx itself,
+// and those on a listener that expects the PROXY protocol, are not probed.
+func newTargets(routerConfig *model.RouterConfig) []target {
+	targets := []target{}
+	for _, appConfig := range routerConfig.AppConfigs {
+		if appConfig.Maintenance || len(appConfig.Domains) == 0 {
+			continue
+		}
+		domain := appConfig.Domains[0]
+		t := target{app: appConfig.Name, scheme: "http", host: probeHost(routerConfig, domain), path: "/"}
+		if appConfig.Certificates[domain] != nil {
+			t.scheme = "https"
+		}
+		if (t.scheme == "http" && routerConfig.ProxyProtocolHTTP()) || (t.scheme == "https" && routerConfig.ProxyProtocolHTTPS()) {
+			continue
+		}
+		if appConfig.HealthCheckConfig != nil && appConfig.HealthCheckConfig.Path != "" {
+			t.path = appConfig.HealthCheckConfig.Path
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// probeHost returns a host name by which nginx routes requests to the provided domain.  Domains
+// without a dot are served under the platform domain, or under any domain if there is none.
+func probeHost(routerConfig *model.RouterConfig, domain string) string {
+	if strings.HasPrefix(domain, "*.") {
+		return wildcardLabel + domain[1:]
+	}
+	if strings.Contains(domain, ".") {
+		return domain
+	}
+	if routerConfig.PlatformDomain != "" {
+		return domain + "." + routerConfig.PlatformDomain
+	}
+	return domain + "." + wildcardLabel
+}
+
+// run probes every application each interval, records the outcomes as metrics, and logs each change
+// in an application's reachability, as well as any application that is unreachable when first
+// probed.
+func (p *Prober) run() {
+	for {
+		p.mutex.Lock()
+		targets := p.targets
+		interval := p.interval
+		timeout := p.timeout
+		p.mutex.Unlock()
+		results := p.probeAll(targets, timeout)
+		metrics.ProbeUp.Reset()
+		metrics.ProbeDuration.Reset()
+		reachable := map[string]bool{}
+		for _, t := range targets {
+			r := results[t.app]
+			reachable[t.app] = r.reachable
+			if was, ok := p.reachable[t.app]; r.reachable != was && (ok || !r.reachable) {
+				if r.reachable {
+					log.Printf("INFO: App \"%s\" is reachable through %s://%s%s again.", t.app, t.scheme, t.host, t.path)
+				} else {
+					log.Printf("WARN: App \"%s\" is not reachable through %s://%s%s.", t.app, t.scheme, t.host, t.path)
+				}
+			}
+			up := 0.0
+			if r.reachable {
+				up = 1
+			}
+			metrics.ProbeUp.With(t.app).Set(up)
+			metrics.ProbeDuration.With(t.app).Set(r.duration.Seconds())
+		}
+		p.reachable = reachable
+		time.Sleep(interval)
+	}
+}
+
+// probeAll probes the provided targets concurrently, so that an application that is slow to answer
+// does not delay the probes of others, and returns their outcomes by application.
+func (p *Prober) probeAll(targets []target, timeout time.Duration) map[string]result {
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	results := map[string]result{}
+	for _, t := range targets {
+		wg.Add(1)
+		go func(t target) {
+			defer wg.Done()
+			start := time.Now()
+			reachable := p.probe(t, timeout)
+			mutex.Lock()
+			defer mutex.Unlock()
+			results[t.app] = result{reachable: reachable, duration: time.Since(start)}
+		}(t)
+	}
+	wg.Wait()
+	return results
+}
+
+// get requests the target's path from nginx with the target's host, and reports whether the
+// application was reachable: whether nginx answered before the timeout with a status below 500.
+// Statuses of 500 and above are those with which nginx reports that it could not reach the
+// application, or that the application failed.  nginx's certificate is not verified, since the
+// point is to exercise the route rather than the certificate.
+func get(t target, timeout time.Duration) bool {
+	addr := httpAddr
+	if t.scheme == "https" {
+		addr = httpsAddr
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Dial: func(network, _ string) (net.Conn, error) {
+				return net.DialTimeout(network, addr, timeout)
+			},
+			TLSClientConfig:   &tls.Config{ServerName: t.host, InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+		// Redirects are answers in their own right, which need not be followed.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequest("GET", t.scheme+"://"+t.host+t.path, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 500
+}
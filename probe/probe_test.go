@@ -0,0 +1,69 @@
+package probe
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/deis/router/model"
+)
+
+func TestNewTargets(t *testing.T) {
+	routerConfig := &model.RouterConfig{
+		PlatformDomain: "example.com",
+		AppConfigs: []*model.AppConfig{
+			&model.AppConfig{Name: "foo", Domains: []string{"foo", "foo.example.org"}},
+			&model.AppConfig{
+				Name:              "bar",
+				Domains:           []string{"bar.example.org"},
+				Certificates:      map[string]*model.Certificate{"bar.example.org": &model.Certificate{}},
+				HealthCheckConfig: &model.HealthCheckConfig{Path: "/healthz"},
+			},
+			&model.AppConfig{Name: "baz", Domains: []string{"*.example.net"}},
+			&model.AppConfig{Name: "qux", Domains: []string{"qux.example.org"}, Maintenance: true},
+			&model.AppConfig{Name: "quux"},
+		},
+	}
+	expected := []target{
+		{app: "foo", scheme: "http", host: "foo.example.com", path: "/"},
+		{app: "bar", scheme: "https", host: "bar.example.org", path: "/healthz"},
+		{app: "baz", scheme: "http", host: "deis-router-probe.example.net", path: "/"},
+	}
+	if actual := newTargets(routerConfig); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected targets %v, but got %v", expected, actual)
+	}
+
+	// Listeners that expect the PROXY protocol cannot be probed.
+	routerConfig.ProxyProtocolConfig = &model.ProxyProtocolConfig{HTTP: "true"}
+	expected = []target{{app: "bar", scheme: "https", host: "bar.example.org", path: "/healthz"}}
+	if actual := newTargets(routerConfig); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected targets %v, but got %v", expected, actual)
+	}
+
+	// Without a platform domain, any domain will do.
+	if host := probeHost(&model.RouterConfig{}, "foo"); host != "foo.deis-router-probe" {
+		t.Errorf("Expected host foo.deis-router-probe, but got %s", host)
+	}
+}
+
+func TestProbeAll(t *testing.T) {
+	prober := NewProber()
+	prober.probe = func(t target, timeout time.Duration) bool {
+		return t.app == "foo"
+	}
+	results := prober.probeAll([]target{{app: "foo"}, {app: "bar"}}, time.Second)
+	if len(results) != 2 || !results["foo"].reachable || results["bar"].reachable {
+		t.Errorf("Expected only foo to be reachable, but got %v", results)
+	}
+}
+
+func TestApplyDisabled(t *testing.T) {
+	prober := NewProber()
+	prober.Apply(&model.RouterConfig{
+		ProbeConfig: &model.ProbeConfig{Enabled: false, Interval: "30s", Timeout: "5s"},
+		AppConfigs:  []*model.AppConfig{&model.AppConfig{Name: "foo", Domains: []string{"foo.example.com"}}},
+	})
+	if prober.running || len(prober.targets) != 0 {
+		t.Errorf("Expected nothing to be probed while probes are disabled.")
+	}
+}
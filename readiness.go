@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/deis/router/nginx"
+)
+
+// readinessAddr is the address at which the router reports its readiness, whether or not, and on
+// whichever port, metrics are served.
+const readinessAddr = ":9098"
+
+// readinessStatus describes whether the router is ready to route requests, and if not, why not.
+type readinessStatus struct {
+	Ready          bool     `json:"ready"`
+	Reasons        []string `json:"reasons,omitempty"`
+	NginxRunning   bool     `json:"nginxRunning"`
+	LastBuild      string   `json:"lastBuild,omitempty"`
+	ReloadFailures int      `json:"reloadFailures"`
+}
+
+// readinessReport tracks the router's main loop and nginx, and serves whether the router is ready to
+// route requests as JSON, with a 503 if it is not.  The router is not ready until it has applied
+// configuration for the first time, nor while nginx is not running, the main loop has not attempted
+// to build the model within the build timeout, or nginx has failed to be reloaded with new
+// configuration too many times in a row.
+type readinessReport struct {
+	mutex             sync.Mutex
+	buildTimeout      time.Duration
+	maxReloadFailures int
+	lastBuild         time.Time
+	applied           bool
+	reloadFailures    int
+}
+
+// build records an attempt to build the model, whether or not it succeeded, since a failure to
+// reach Kubernetes does not stop the configuration in effect from routing requests.
+func (r *readinessReport) build() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.lastBuild = time.Now()
+}
+
+// reload records an attempt to install and reload nginx with new configuration, which failed if err
+// is not nil.
+func (r *readinessReport) reload(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err != nil {
+		r.reloadFailures++
+		return
+	}
+	r.applied = true
+	r.reloadFailures = 0
+}
+
+func (r *readinessReport) status() readinessStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	status := readinessStatus{NginxRunning: nginx.Running(), ReloadFailures: r.reloadFailures}
+	if !r.lastBuild.IsZero() {
+		status.LastBuild = r.lastBuild.Format(time.RFC3339)
+	}
+	if !status.NginxRunning {
+		status.Reasons = append(status.Reasons, "nginx is not running")
+	}
+	if !r.applied {
+		status.Reasons = append(status.Reasons, "no configuration has been applied yet")
+	}
+	if !r.lastBuild.IsZero() && r.buildTimeout > 0 && time.Since(r.lastBuild) > r.buildTimeout {
+		status.Reasons = append(status.Reasons, "the model has not been built within "+r.buildTimeout.String())
+	}
+	if r.maxReloadFailures > 0 && r.reloadFailures >= r.maxReloadFailures {
+		status.Reasons = append(status.Reasons, "nginx has failed to reload repeatedly")
+	}
+	status.Ready = len(status.Reasons) == 0
+	return status
+}
+
+// serve starts an HTTP server in the background that reports the router's readiness.
+func (r *readinessReport) serve() {
+	go func() {
+		if err := http.ListenAndServe(readinessAddr, r); err != nil {
+			log.Printf("WARN: Readiness server stopped: %v", err)
+		}
+	}()
+}
+
+func (r *readinessReport) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	status := r.status()
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
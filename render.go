@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/deis/router/model"
+	"github.com/deis/router/nginx"
+	"k8s.io/client-go/1.4/kubernetes"
+	"k8s.io/client-go/1.4/rest"
+)
+
+// isRenderCommand returns whether the router was asked, by the provided arguments, to render its
+// configuration rather than to run.
+func isRenderCommand(args []string) bool {
+	return len(args) > 0 && (args[0] == "render" || args[0] == "--dry-run")
+}
+
+// runRender builds the router's model from the cluster, renders the nginx configuration it would
+// apply, and writes that configuration, with the applications' files it includes and the names of
+// the certificate files that would be written alongside it, to the provided writer.  Nothing the
+// router or nginx uses is touched: files are rendered to a temporary directory that is removed
+// afterward.  Warnings about the model are logged.
+func runRender(args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	server := flags.String("server", "", "Address of the Kubernetes API server, e.g. http://127.0.0.1:8001 by way of kubectl proxy.  Defaults to the cluster the router runs in.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	cfg, err := rest.InClusterConfig()
+	if *server != "" {
+		cfg, err = &rest.Config{Host: *server}, nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create config: %v", err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create client: %v", err)
+	}
+	routerConfig, err := model.Build(kubeClient)
+	if err != nil {
+		return fmt.Errorf("failed to build model: %v", err)
+	}
+	for _, warning := range routerConfig.Warnings {
+		log.Printf("WARN: %s", warning)
+	}
+	dir, err := ioutil.TempDir("", "router-render")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	renderSSLPath := filepath.Join(dir, "ssl")
+	if err := os.Mkdir(renderSSLPath, 0755); err != nil {
+		return err
+	}
+	if err := nginx.WriteCerts(routerConfig, renderSSLPath); err != nil {
+		return fmt.Errorf("failed to write certs: %v", err)
+	}
+	if err := nginx.WriteDHParam(routerConfig, renderSSLPath); err != nil {
+		return fmt.Errorf("failed to write dhparam: %v", err)
+	}
+	if err := nginx.WriteECHKeys(routerConfig, renderSSLPath); err != nil {
+		return fmt.Errorf("failed to write ECH keys: %v", err)
+	}
+	renderConfigPath := filepath.Join(dir, filepath.Base(configPath))
+	if err := nginx.WriteConfig(routerConfig, renderConfigPath); err != nil {
+		return fmt.Errorf("failed to write nginx configuration: %v", err)
+	}
+	snapshot, err := nginx.ReadConfigSnapshot(renderConfigPath)
+	if err != nil {
+		return err
+	}
+	// The main file comes first, followed by the applications' files in the order nginx includes
+	// them.
+	names := []string{}
+	for name := range snapshot {
+		if name != filepath.Base(configPath) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range append([]string{filepath.Base(configPath)}, names...) {
+		fmt.Fprintf(w, "# %s\n%s\n", filepath.Join(filepath.Dir(configPath), name), snapshot[name])
+	}
+	// Certificates and keys are only named, since keys are secret.
+	fmt.Fprintln(w, "# Certificate files")
+	return filepath.Walk(renderSSLPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(renderSSLPath, path)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "# %s\n", filepath.Join(sslPath, rel))
+		return err
+	})
+}
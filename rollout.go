@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/deis/router/metrics"
+	"github.com/deis/router/model"
+	"github.com/deis/router/nginx"
+	"github.com/deis/router/utils/modeler"
+)
+
+const (
+	stagedInstanceConfigPath = "/opt/router/conf/staged-instance/nginx.conf"
+	activeStatsURL           = "http://127.0.0.1:9090/stats"
+)
+
+// errStagedSuperseded is returned when changes arrive while configuration is being tried, which is
+// then abandoned in favor of configuration built from them.
+var errStagedSuperseded = errors.New("changes arrived while the staged configuration was being tried")
+
+// errStagedShutdown is returned when the router is asked to terminate while configuration is being
+// tried, which is then abandoned so that nginx can be drained without delay.
+var errStagedShutdown = errors.New("the router is shutting down")
+
+// tryStaged serves the provided configuration from a staged instance of nginx, alongside the
+// instance serving the configuration in effect, for the staged rollout period.  It returns an error
+// if the share of the staged instance's responses that were server errors exceeded that of the
+// instance in effect by more than the permitted margin, in which case the configuration should not
+// be applied.  If the staged instance cannot be run or its traffic cannot be compared, the
+// configuration is judged as if staged rollout were disabled, and no error is returned.  The trial
+// is cut short, and errStagedSuperseded returned, if a change notification is received meanwhile,
+// or errStagedShutdown, if the router is asked to terminate.  Either way, the staged instance is
+// shut down before tryStaged returns.
+func tryStaged(routerConfig *model.RouterConfig, changes <-chan struct{}, shuttingDown <-chan struct{}) error {
+	stagedRolloutConfig := routerConfig.StagedRolloutConfig
+	period, err := modeler.ParseDuration(stagedRolloutConfig.Period)
+	if err != nil {
+		log.Printf("WARN: Failed to parse the staged rollout period; applying configuration directly: %v", err)
+		return nil
+	}
+	if err := nginx.WriteStagedConfig(routerConfig, stagedInstanceConfigPath); err != nil {
+		log.Printf("WARN: Failed to write staged nginx configuration; applying configuration directly: %v", err)
+		return nil
+	}
+	activeRequests, activeErrors, err := metrics.ServerErrors(activeStatsURL)
+	if err != nil {
+		log.Printf("WARN: Failed to retrieve nginx traffic status; applying configuration directly: %v", err)
+		return nil
+	}
+	if err := nginx.StartStaged(stagedInstanceConfigPath); err != nil {
+		log.Printf("WARN: Failed to start staged nginx; applying configuration directly: %v", err)
+		return nil
+	}
+	defer func() {
+		if err := nginx.QuitStaged(stagedInstanceConfigPath); err != nil {
+			log.Printf("WARN: Failed to shut staged nginx down: %v", err)
+		}
+	}()
+	log.Printf("INFO: Serving new configuration from staged nginx for %s.", period)
+	select {
+	case <-time.After(period):
+	case <-changes:
+		return errStagedSuperseded
+	case <-shuttingDown:
+		return errStagedShutdown
+	}
+	stagedRequests, stagedErrors, err := metrics.ServerErrors(nginx.StagedStatsURL)
+	if err != nil {
+		log.Printf("WARN: Failed to retrieve staged nginx traffic status; applying configuration directly: %v", err)
+		return nil
+	}
+	requests, errors, err := metrics.ServerErrors(activeStatsURL)
+	if err != nil {
+		log.Printf("WARN: Failed to retrieve nginx traffic status; applying configuration directly: %v", err)
+		return nil
+	}
+	activeRate := errorPercent(requests-activeRequests, errors-activeErrors)
+	stagedRate := errorPercent(stagedRequests, stagedErrors)
+	log.Printf("INFO: %.1f%% of %d requests served with the new configuration were server errors, against %.1f%% of %d with the configuration in effect.", stagedRate, stagedRequests, activeRate, requests-activeRequests)
+	if stagedRate > activeRate+float64(stagedRolloutConfig.MaxErrorPercent) {
+		return fmt.Errorf("%.1f%% of the requests served with the new configuration were server errors, against %.1f%% with the configuration in effect", stagedRate, activeRate)
+	}
+	return nil
+}
+
+// errorPercent returns the percentage of the provided requests that were errors, or zero if there
+// were no requests.
+func errorPercent(requests uint64, errors uint64) float64 {
+	if requests == 0 {
+		return 0
+	}
+	return 100 * float64(errors) / float64(requests)
+}
@@ -0,0 +1,38 @@
+// Transformations that the router applies to the requests of applications that select them with the
+// router.deis.io/nginx.transforms annotations.  nginx imports them as a module with njs 0.7, which
+// passes each handler the request, and supports only a subset of ECMAScript.
+
+var crypto = require("crypto");
+
+// deisAllowResponseHeaders removes from the response every header that the location's
+// $deis_allowed_response_headers, a comma-delimited list of lower-case names, does not list.  It
+// runs before nginx adds headers of its own, which are therefore kept.
+function deisAllowResponseHeaders(r) {
+    var allowed = {};
+    var names = (r.variables.deis_allowed_response_headers || "").split(",");
+    for (var i = 0; i < names.length; i++) {
+        allowed[names[i]] = true;
+    }
+    var removed = [];
+    for (var name in r.headersOut) {
+        if (!allowed[name.toLowerCase()]) {
+            removed.push(name);
+        }
+    }
+    for (var j = 0; j < removed.length; j++) {
+        delete r.headersOut[removed[j]];
+    }
+}
+
+// deisTLSFingerprint returns a JA3-style fingerprint of the client's TLS handshake: the hex MD5 hash
+// of the protocol negotiated and the ciphers and curves the client offered, as nginx itself reports
+// them.  It is empty for connections without TLS.
+function deisTLSFingerprint(r) {
+    if (!r.variables.ssl_protocol) {
+        return "";
+    }
+    var handshake = [r.variables.ssl_protocol, r.variables.ssl_ciphers || "", r.variables.ssl_curves || ""].join(",");
+    return crypto.createHash("md5").update(handshake).digest("hex");
+}
+
+export default {deisAllowResponseHeaders: deisAllowResponseHeaders, deisTLSFingerprint: deisTLSFingerprint};
@@ -1,18 +1,81 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/deis/router/acme"
+	"github.com/deis/router/faults"
+	"github.com/deis/router/grpcweb"
+	"github.com/deis/router/healthcheck"
+	"github.com/deis/router/ipranges"
+	"github.com/deis/router/metrics"
 	"github.com/deis/router/model"
 	"github.com/deis/router/nginx"
+	"github.com/deis/router/probe"
+	"github.com/deis/router/utils"
 	"k8s.io/client-go/1.4/kubernetes"
 	"k8s.io/client-go/1.4/pkg/util/flowcontrol"
 	"k8s.io/client-go/1.4/rest"
 )
 
+const (
+	configPath       = "/opt/router/conf/nginx.conf"
+	stagedConfigPath = "/opt/router/conf/staged/nginx.conf"
+	cachePath        = "/opt/router/cache"
+	errorPagesPath   = "/opt/router/errors"
+	wafPath          = "/opt/router/waf"
+	sslPath          = "/opt/router/ssl"
+	tracerConfigPath = "/opt/router/conf/tracer.json"
+
+	// knownGoodConfigPath is where configuration that nginx was successfully reloaded with is kept,
+	// so that nginx can be restarted with it if it exits unexpectedly.
+	knownGoodConfigPath = "/opt/router/conf/known-good/nginx.conf"
+
+	// reportsAddr is the address at which the router reports its status, and, in shadow mode, how
+	// its configuration differs from the active router's.  It is reachable only from within the
+	// router's pod, e.g. through kubectl port-forward.
+	reportsAddr = "127.0.0.1:9099"
+
+	// minRetryInterval is how long to wait before retrying a pass through the main loop that failed,
+	// unless changes arrive sooner.  The interval doubles with each consecutive failure.
+	minRetryInterval = 5 * time.Second
+)
+
 func main() {
-	nginx.Start()
+	if isRenderCommand(os.Args[1:]) {
+		if err := runRender(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("Failed to render configuration: %v", err)
+		}
+		return
+	}
+	shadowEnabled, err := strconv.ParseBool(utils.GetOpt("SHADOW_ENABLED", "false"))
+	if err != nil {
+		log.Fatalf("Failed to parse SHADOW_ENABLED: %v", err)
+	}
+	activeConfigURL := utils.GetOpt("SHADOW_ACTIVE_CONFIG_URL", "")
+	if shadowEnabled && activeConfigURL == "" {
+		log.Fatal("SHADOW_ACTIVE_CONFIG_URL must be set when SHADOW_ENABLED is true.")
+	}
+	configSnapshotEnabled, err := strconv.ParseBool(utils.GetOpt("CONFIG_SNAPSHOT_ENABLED", "false"))
+	if err != nil {
+		log.Fatalf("Failed to parse CONFIG_SNAPSHOT_ENABLED: %v", err)
+	}
+	// A router running in shadow mode only renders configuration for comparison, so it never starts
+	// nginx.
+	if !shadowEnabled {
+		if err := nginx.Start(configPath, knownGoodConfigPath); err != nil {
+			log.Fatalf("Failed to start nginx: %v", err)
+		}
+	}
 	cfg, err := rest.InClusterConfig()
 	if err != nil {
 		log.Fatalf("Failed to create config: %v", err)
@@ -21,40 +84,514 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create client: %v.", err)
 	}
+	watchEnabled, err := strconv.ParseBool(utils.GetOpt("WATCH_ENABLED", "true"))
+	if err != nil {
+		log.Fatalf("Failed to parse WATCH_ENABLED: %v", err)
+	}
+	resyncPeriod, err := time.ParseDuration(utils.GetOpt("RESYNC_PERIOD", "5m"))
+	if err != nil {
+		log.Fatalf("Failed to parse RESYNC_PERIOD: %v", err)
+	}
+	debouncePeriod, err := time.ParseDuration(utils.GetOpt("DEBOUNCE_PERIOD", "1s"))
+	if err != nil {
+		log.Fatalf("Failed to parse DEBOUNCE_PERIOD: %v", err)
+	}
+	preStopDelay, err := time.ParseDuration(utils.GetOpt("PRE_STOP_DELAY", "0"))
+	if err != nil {
+		log.Fatalf("Failed to parse PRE_STOP_DELAY: %v", err)
+	}
+	drainTimeout, err := time.ParseDuration(utils.GetOpt("DRAIN_TIMEOUT", "25s"))
+	if err != nil {
+		log.Fatalf("Failed to parse DRAIN_TIMEOUT: %v", err)
+	}
+	metricsEnabled, err := strconv.ParseBool(utils.GetOpt("METRICS_ENABLED", "true"))
+	if err != nil {
+		log.Fatalf("Failed to parse METRICS_ENABLED: %v", err)
+	}
+	readinessBuildTimeout, err := time.ParseDuration(utils.GetOpt("READINESS_BUILD_TIMEOUT", "15m"))
+	if err != nil {
+		log.Fatalf("Failed to parse READINESS_BUILD_TIMEOUT: %v", err)
+	}
+	readinessMaxReloadFailures, err := strconv.Atoi(utils.GetOpt("READINESS_MAX_RELOAD_FAILURES", "3"))
+	if err != nil {
+		log.Fatalf("Failed to parse READINESS_MAX_RELOAD_FAILURES: %v", err)
+	}
+	// Routers serve a report of the connections still open to applications alongside their metrics,
+	// and, only if enabled, a snapshot of their configuration, so that a router running in shadow
+	// mode can compare its own configuration with it.  Their status, and a summary of each
+	// application's security posture, are served only within the router's pod.
+	shadowReport := &shadowReport{}
+	postureReport := &postureReport{}
+	statusReport := &statusReport{}
+	drainReport := &drainReport{defaultTimeout: drainTimeout}
+	readinessReport := &readinessReport{buildTimeout: readinessBuildTimeout, maxReloadFailures: readinessMaxReloadFailures}
+	handlers := map[string]http.Handler{"/drain": drainReport}
+	if configSnapshotEnabled {
+		handlers["/config"] = nginx.ConfigHandler(configPath)
+	}
+	reports := map[string]http.Handler{"/posture": postureReport, "/status": statusReport}
+	if shadowEnabled {
+		handlers = map[string]http.Handler{}
+		reports = map[string]http.Handler{"/shadow": shadowReport}
+	}
+	if metricsEnabled {
+		metrics.Serve(":"+utils.GetOpt("METRICS_PORT", "9091"), handlers)
+	}
+	serveReports(reports)
+	somaxconn, err := utils.Somaxconn()
+	if err != nil {
+		log.Printf("WARN: Failed to read somaxconn; connection backlogs cannot be checked: %v", err)
+	} else {
+		log.Printf("INFO: somaxconn is %d.", somaxconn)
+		metrics.Somaxconn.Set(float64(somaxconn))
+	}
+	// When not watching for changes, the model is simply rebuilt as often as the rate limiter
+	// permits.  When watching, the model is rebuilt when a change is observed, with periodic
+	// polling retained only as a fallback.
+	var changes <-chan struct{}
 	rateLimiter := flowcontrol.NewTokenBucketRateLimiter(0.1, 1)
+	if watchEnabled {
+		log.Printf("INFO: Watching k8s for changes; resyncing every %s.", resyncPeriod)
+		changes = model.Watch(kubeClient, nil)
+		rateLimiter = flowcontrol.NewTokenBucketRateLimiter(1, 1)
+	} else {
+		resyncPeriod = 0
+	}
+	// Published IP ranges are fetched in shadow mode too, so that a shadow router's whitelists match
+	// the active router's.
+	rangeSyncer := ipranges.NewSyncer()
+	if shadowEnabled {
+		runShadow(kubeClient, activeConfigURL, changes, rangeSyncer, resyncPeriod, debouncePeriod, rateLimiter, shadowReport)
+		return
+	}
+	// Certificates are only obtained if enabled in the router's configuration, but challenges are
+	// always answered, since another replica may be obtaining certificates.
+	acmeManager := acme.NewManager(kubeClient)
+	acmeManager.ServeChallenges()
+	go acmeManager.Run(nil)
+	faults.ServeDelays()
+	grpcweb.Serve()
+	readinessReport.serve()
+	metrics.ServeLatencies()
+	metrics.ServeTLSEvents()
+	healthChecker := healthcheck.NewChecker()
+	prober := probe.NewProber()
+	warningRecorder := model.NewWarningRecorder(kubeClient)
 	known := &model.RouterConfig{}
+	// builtStats summarizes the model last built.
+	var builtStats model.RoutingTableStats
+	// rejected is the most recent configuration nginx found invalid.  It is remembered so that the
+	// same configuration isn't validated, and its failure logged, over and over.
+	var rejected *model.RouterConfig
+	// quarantineWarnings describe the applications whose changes were left out of the configuration
+	// currently in effect.
+	var quarantineWarnings []model.Warning
+	// lastApplied is the model from which the configuration currently in effect was rendered,
+	// including the previous configuration of applications retained by quarantine.
+	var lastApplied *model.RouterConfig
+	// appliedDigest is the digest of the configuration, certificates, and other files nginx last
+	// loaded.  nginx is not reloaded when a changed model produces exactly the same files, since a
+	// reload closes long-lived connections.
+	appliedDigest := ""
+	// applying is held for the duration of each pass through the main loop, so that once the router
+	// is asked to terminate, no configuration is applied while nginx drains.
+	var applying sync.Mutex
+	shuttingDown := drainOnShutdown(&applying, preStopDelay, drainTimeout)
+	// superseded is set when a staged rollout is cut short by changes, so that the model is rebuilt
+	// from them without waiting for more.
+	superseded := false
+	// failed is set when a pass fails for reasons that may pass by themselves, such as the API being
+	// unreachable or a file failing to be written, so that it is retried before the resync period
+	// elapses.  Configuration that nginx rejects is not retried, since it would only be rejected
+	// again until the model changes.
+	failed := false
+	var retryInterval time.Duration
 	// Main loop
-	for {
+	for first := true; ; first = false {
+		if !first {
+			applying.Unlock()
+			retryInterval = nextRetryInterval(retryInterval, failed, resyncPeriod)
+			wait := resyncPeriod
+			if retryInterval > 0 && retryInterval < resyncPeriod {
+				wait = retryInterval
+			}
+			if !superseded {
+				waitForChanges(changes, healthChecker.Changes(), rangeSyncer.Changes(), wait, debouncePeriod)
+			}
+			superseded = false
+			failed = false
+		}
+		applying.Lock()
+		// The mutex may be taken again before the shutdown takes it, in which case it is handed over
+		// at once, and the main loop ends.
+		select {
+		case <-shuttingDown:
+			applying.Unlock()
+			select {}
+		default:
+		}
 		rateLimiter.Accept()
+		buildStart := time.Now()
 		routerConfig, err := model.Build(kubeClient)
+		metrics.ModelBuildDuration.Observe(time.Since(buildStart).Seconds())
+		statusReport.build(err)
+		readinessReport.build()
 		if err != nil {
+			metrics.ModelBuildFailures.Inc()
 			log.Printf("Error building model; not modifying certs or configuration: %v.", err)
+			failed = true
 			continue
 		}
-		if reflect.DeepEqual(routerConfig, known) {
+		// Endpoints that fail their applications' health checks are left out, but their applications
+		// are otherwise routed as built.
+		healthChecker.Apply(routerConfig)
+		rangeSyncer.Apply(routerConfig)
+		metrics.UnhealthyEndpoints.Set(float64(healthChecker.Unhealthy()))
+		warningRecorder.Record(joinWarnings(routerConfig.Warnings, quarantineWarnings))
+		// The model's summary is logged only when it changes, since the model is rebuilt on every
+		// change observed and at every resync, mostly to the same effect.
+		if stats := routerConfig.Stats(); stats != builtStats {
+			log.Printf("INFO: Built model: %s.", stats)
+			builtStats = stats
+		}
+		if reflect.DeepEqual(routerConfig, known) || reflect.DeepEqual(routerConfig, rejected) {
 			continue
 		}
 		log.Println("INFO: Router configuration has changed in k8s.")
-		err = nginx.WriteCerts(routerConfig, "/opt/router/ssl")
+		checkBacklog(routerConfig, somaxconn)
+		stageStart := time.Now()
+		err = nginx.WriteCerts(routerConfig, sslPath)
+		metrics.ObserveStage("write_certs", stageStart)
 		if err != nil {
 			log.Printf("Failed to write certs; continuing with existing certs, dhparam, and configuration: %v", err)
+			failed = true
 			continue
 		}
-		err = nginx.WriteDHParam(routerConfig, "/opt/router/ssl")
+		stageStart = time.Now()
+		err = nginx.WriteDHParam(routerConfig, sslPath)
+		metrics.ObserveStage("write_dhparam", stageStart)
 		if err != nil {
 			log.Printf("Failed to write dhparam; continuing with existing dhparam and configuration: %v", err)
+			failed = true
+			continue
+		}
+		stageStart = time.Now()
+		err = nginx.WriteECHKeys(routerConfig, sslPath)
+		metrics.ObserveStage("write_ech_keys", stageStart)
+		if err != nil {
+			log.Printf("Failed to write ECH keys; continuing with existing ECH keys and configuration: %v", err)
+			failed = true
+			continue
+		}
+		stageStart = time.Now()
+		err = nginx.WriteTracerConfig(routerConfig, filepath.Dir(tracerConfigPath))
+		metrics.ObserveStage("write_tracer_config", stageStart)
+		if err != nil {
+			log.Printf("Failed to write tracer configuration; continuing with existing tracer configuration and configuration: %v", err)
+			failed = true
+			continue
+		}
+		stageStart = time.Now()
+		err = nginx.WriteErrorPages(routerConfig, errorPagesPath)
+		metrics.ObserveStage("write_error_pages", stageStart)
+		if err != nil {
+			log.Printf("Failed to write error pages; continuing with existing error pages and configuration: %v", err)
+			failed = true
+			continue
+		}
+		stageStart = time.Now()
+		err = nginx.WriteWAFRules(routerConfig, wafPath)
+		metrics.ObserveStage("write_waf_rules", stageStart)
+		if err != nil {
+			log.Printf("Failed to write WAF rules; continuing with existing WAF rules and configuration: %v", err)
+			failed = true
+			continue
+		}
+		stageStart = time.Now()
+		err = nginx.WriteCacheDirs(routerConfig, cachePath)
+		metrics.ObserveStage("write_cache_dirs", stageStart)
+		if err != nil {
+			log.Printf("Failed to create cache directories; continuing with existing configuration: %v", err)
+			failed = true
 			continue
 		}
-		err = nginx.WriteConfig(routerConfig, "/opt/router/conf/nginx.conf")
+		// New configuration is staged and validated before it replaces the existing configuration, so
+		// that configuration nginx would refuse to load never interrupts routing.
+		stageStart = time.Now()
+		err = nginx.WriteConfig(routerConfig, stagedConfigPath)
+		metrics.ObserveStage("render", stageStart)
 		if err != nil {
 			log.Printf("Failed to write new nginx configuration; continuing with existing configuration: %v", err)
+			failed = true
+			continue
+		}
+		statusReport.render()
+		digest, err := nginx.Digest(filepath.Dir(stagedConfigPath), sslPath, errorPagesPath, wafPath, tracerConfigPath)
+		if err != nil {
+			log.Printf("WARN: Failed to digest new nginx configuration; reloading nginx regardless: %v", err)
+		} else if digest == appliedDigest {
+			log.Println("INFO: nginx configuration and certificates are unchanged; not reloading nginx.")
+			metrics.ReloadsSkipped.Inc()
+			known = routerConfig
+			continue
+		}
+		stageStart = time.Now()
+		err = nginx.Validate(stagedConfigPath)
+		metrics.ObserveStage("validate", stageStart)
+		if err != nil && nginx.TemplateOverridesInEffect() {
+			metrics.ValidationFailures.Inc()
+			logValidationFailure(err)
+			// Configuration rendered from operators' template overrides is abandoned in favor of the
+			// built-in template before any application is blamed.
+			nginx.RejectTemplateOverrides()
+			if err = nginx.WriteConfig(routerConfig, stagedConfigPath); err == nil {
+				err = nginx.Validate(stagedConfigPath)
+			}
+		}
+		appliedConfig := routerConfig
+		var quarantined []nginx.QuarantinedApp
+		if err != nil {
+			metrics.ValidationFailures.Inc()
+			logValidationFailure(err)
+			// Rather than let one application's bad configuration hold up every other application's
+			// changes, the applications to blame are found and left out.
+			stageStart = time.Now()
+			appliedConfig, quarantined, err = nginx.Quarantine(routerConfig, lastApplied, stagedConfigPath)
+			metrics.ObserveStage("quarantine", stageStart)
+			if err != nil {
+				log.Printf("Generated nginx configuration cannot be made valid by leaving out individual applications; continuing with existing configuration: %v", err)
+				statusReport.reload(err)
+				rejected = routerConfig
+				continue
+			}
+		}
+		// Once nginx is running, new configuration may first be tried on a share of the traffic, and
+		// is abandoned if it serves markedly more server errors than the configuration in effect.
+		if appliedDigest != "" && nginx.StagedRollout(appliedConfig) {
+			stageStart = time.Now()
+			err = tryStaged(appliedConfig, changes, shuttingDown)
+			metrics.ObserveStage("staged_rollout", stageStart)
+			if err == errStagedShutdown {
+				log.Printf("INFO: Abandoning staged nginx configuration: %v.", err)
+				continue
+			}
+			if err == errStagedSuperseded {
+				log.Printf("INFO: Abandoning staged nginx configuration: %v.", err)
+				superseded = true
+				continue
+			}
+			if err != nil {
+				metrics.StagedRollbacks.Inc()
+				log.Printf("Staged nginx configuration served too many server errors; continuing with existing configuration: %v", err)
+				statusReport.reload(err)
+				rejected = routerConfig
+				continue
+			}
+			metrics.StagedPromotions.Inc()
+		}
+		err = nginx.Install(stagedConfigPath, configPath)
+		if err != nil {
+			log.Printf("Failed to replace nginx configuration; continuing with existing configuration: %v", err)
+			statusReport.reload(err)
+			readinessReport.reload(err)
+			failed = true
 			continue
 		}
+		metrics.Reloads.Inc()
+		stageStart = time.Now()
 		err = nginx.Reload()
+		metrics.ObserveStage("reload", stageStart)
+		statusReport.reload(err)
+		readinessReport.reload(err)
 		if err != nil {
+			metrics.ReloadFailures.Inc()
 			log.Printf("Failed to reload nginx; continuing with existing configuration: %v", err)
+			failed = true
 			continue
 		}
 		known = routerConfig
+		lastApplied = appliedConfig
+		if err := nginx.KeepKnownGood(configPath, knownGoodConfigPath); err != nil {
+			log.Printf("WARN: Failed to keep a copy of the nginx configuration in effect; nginx will be restarted with the existing configuration if it exits: %v", err)
+		}
+		// Files that the configuration in effect no longer uses are removed only now, since the
+		// configuration nginx was running until it was reloaded may have used them.
+		if err := nginx.RemoveStaleErrorPages(appliedConfig, errorPagesPath); err != nil {
+			log.Printf("WARN: Failed to remove stale error pages: %v", err)
+		}
+		if err := nginx.RemoveStaleWAFRules(appliedConfig, wafPath); err != nil {
+			log.Printf("WARN: Failed to remove stale WAF rules: %v", err)
+		}
+		// The digest of the files nginx loaded is taken anew, since any applications that were
+		// quarantined have been left out of them, and stale files have been removed.
+		if appliedDigest, err = nginx.Digest(filepath.Dir(stagedConfigPath), sslPath, errorPagesPath, wafPath, tracerConfigPath); err != nil {
+			log.Printf("WARN: Failed to digest the nginx configuration in effect: %v", err)
+		}
+		if err := nginx.RemoveStaleCacheDirs(appliedConfig, cachePath); err != nil {
+			log.Printf("WARN: Failed to remove stale cache directories: %v", err)
+		}
+		quarantineWarnings = newQuarantineWarnings(quarantined)
+		warningRecorder.Record(joinWarnings(routerConfig.Warnings, quarantineWarnings))
+		metrics.QuarantinedApps.Set(float64(len(quarantined)))
+		recordRoutingTableStats(appliedConfig.Stats())
+		recordCertificateExpiries(appliedConfig.CertificateExpiries())
+		postureReport.update(appliedConfig)
+		statusReport.update(appliedConfig)
+		drainReport.update(appliedConfig)
+		prober.Apply(appliedConfig)
+		acmeManager.Update(appliedConfig)
+	}
+}
+
+// serveReports starts an HTTP server in the background, at the reports address, that serves the
+// provided handlers, keyed by the patterns at which they are served.
+func serveReports(handlers map[string]http.Handler) {
+	mux := http.NewServeMux()
+	for pattern, handler := range handlers {
+		mux.Handle(pattern, handler)
+	}
+	go func() {
+		if err := http.ListenAndServe(reportsAddr, mux); err != nil {
+			log.Printf("WARN: Reports server stopped: %v", err)
+		}
+	}()
+}
+
+// checkBacklog warns if the configured connection backlog exceeds somaxconn, in which case the
+// kernel silently caps it.  A somaxconn of zero indicates it is unknown.
+func checkBacklog(routerConfig *model.RouterConfig, somaxconn int) {
+	if routerConfig.Backlog == "" || somaxconn == 0 {
+		return
+	}
+	backlog, err := strconv.Atoi(routerConfig.Backlog)
+	if err == nil && backlog > somaxconn {
+		log.Printf("WARN: The configured backlog of %d exceeds somaxconn (%d); the kernel will cap it at %d.", backlog, somaxconn, somaxconn)
+	}
+}
+
+// logValidationFailure logs why new configuration failed validation, including, where possible,
+// the offending lines and how the configuration differs from the configuration still in effect.
+func logValidationFailure(err error) {
+	log.Printf("Generated nginx configuration is invalid; continuing with existing configuration: %v", err)
+	validationErr, ok := err.(*nginx.ValidationError)
+	if !ok {
+		return
+	}
+	if len(validationErr.Context) > 0 {
+		log.Printf("INFO: Offending configuration:\n%s", strings.Join(validationErr.Context, "\n"))
+	}
+	diff, err := nginx.Diff(configPath, stagedConfigPath)
+	if err != nil {
+		log.Printf("WARN: Failed to compare new nginx configuration to existing configuration: %v", err)
+		return
+	}
+	log.Printf("INFO: Changes to nginx configuration that were not applied:\n%s", strings.Join(diff, "\n"))
+}
+
+// newQuarantineWarnings logs each quarantined application and returns warnings to be posted on the
+// resources from which they were built.
+func newQuarantineWarnings(quarantined []nginx.QuarantinedApp) []model.Warning {
+	warnings := []model.Warning{}
+	for _, quarantinedApp := range quarantined {
+		appConfig := quarantinedApp.AppConfig
+		reason := quarantinedApp.Err.Error()
+		if validationErr, ok := quarantinedApp.Err.(*nginx.ValidationError); ok {
+			reason = strings.SplitN(strings.TrimSpace(validationErr.Output), "\n", 2)[0]
+		}
+		effect := "requests for it are not routed until this is fixed"
+		if quarantinedApp.Retained {
+			log.Printf("WARN: nginx rejected the configuration for app \"%s\"; its previous configuration has been kept: %s", appConfig.Name, reason)
+			effect = "requests for it are routed as they were before its latest changes until this is fixed"
+		} else {
+			log.Printf("WARN: nginx rejected the configuration for app \"%s\"; it has been left out of the configuration: %s", appConfig.Name, reason)
+		}
+		warnings = append(warnings, model.Warning{
+			Kind:      appConfig.ResourceKind,
+			Namespace: appConfig.Namespace,
+			Name:      appConfig.ResourceName,
+			Reason:    "Quarantined",
+			Message:   fmt.Sprintf("nginx rejected the configuration generated for %s, so %s: %s", strings.Join(appConfig.Domains, ", "), effect, reason),
+		})
+	}
+	return warnings
+}
+
+// joinWarnings returns a new slice containing all of the provided warnings.
+func joinWarnings(warnings ...[]model.Warning) []model.Warning {
+	joined := []model.Warning{}
+	for _, w := range warnings {
+		joined = append(joined, w...)
+	}
+	return joined
+}
+
+// nextRetryInterval returns how long to wait before retrying after a pass through the main loop,
+// given the interval returned before that pass and whether it failed.  The interval is zero after a
+// pass that did not fail, minRetryInterval after the first of consecutive failures, and doubles
+// after each that follows, up to the resync period.
+func nextRetryInterval(retryInterval time.Duration, failed bool, resyncPeriod time.Duration) time.Duration {
+	if !failed {
+		return 0
+	}
+	if retryInterval == 0 {
+		return minRetryInterval
+	}
+	if retryInterval *= 2; retryInterval > resyncPeriod {
+		return resyncPeriod
+	}
+	return retryInterval
+}
+
+// waitForChanges blocks until a change notification, a change in the health of any endpoint, or a
+// change in the ranges of any IP range source is received, or the resync period elapses, whichever
+// comes first.  Changes tend to arrive in bursts, such as while a deployment rolls its pods, so once
+// one is received, waiting continues until none has been received for the debounce period, but for
+// no more than ten such periods in all.
+func waitForChanges(changes <-chan struct{}, healthChanges <-chan struct{}, rangeChanges <-chan struct{}, resyncPeriod time.Duration, debouncePeriod time.Duration) {
+	select {
+	case <-changes:
+	case <-healthChanges:
+	case <-rangeChanges:
+	case <-time.After(resyncPeriod):
+		return
+	}
+	if debouncePeriod <= 0 {
+		return
+	}
+	deadline := time.After(10 * debouncePeriod)
+	for {
+		select {
+		case <-changes:
+		case <-healthChanges:
+		case <-rangeChanges:
+		case <-time.After(debouncePeriod):
+			return
+		case <-deadline:
+			return
+		}
+	}
+}
+
+// recordRoutingTableStats exposes the size and composition of nginx's current configuration as
+// metrics.
+func recordRoutingTableStats(stats model.RoutingTableStats) {
+	metrics.Apps.Set(float64(stats.Apps))
+	metrics.Domains.Set(float64(stats.Domains))
+	metrics.Locations.Set(float64(stats.Locations))
+	metrics.Endpoints.Set(float64(stats.Endpoints))
+	metrics.Certificates.Set(float64(stats.Certificates))
+	metrics.WhitelistedApps.Set(float64(stats.WhitelistedApps))
+	metrics.UnavailableApps.Set(float64(stats.UnavailableApps))
+	metrics.MaintenanceApps.Set(float64(stats.MaintenanceApps))
+	metrics.ACMEDomains.Set(float64(stats.ACMEDomains))
+	metrics.Streams.Set(float64(stats.Streams))
+}
+
+// recordCertificateExpiries exposes the expiry of each certificate in nginx's current configuration
+// as metrics.
+func recordCertificateExpiries(expiries map[string]time.Time) {
+	metrics.CertificateExpiry.Reset()
+	for name, notAfter := range expiries {
+		metrics.CertificateExpiry.With(name).Set(float64(notAfter.Unix()))
 	}
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deis/router/ipranges"
+	"github.com/deis/router/metrics"
+	"github.com/deis/router/model"
+	"github.com/deis/router/nginx"
+	"k8s.io/client-go/1.4/kubernetes"
+	"k8s.io/client-go/1.4/pkg/util/flowcontrol"
+)
+
+const (
+	shadowConfigPath = "/opt/router/conf/shadow/nginx.conf"
+	activeConfigPath = "/opt/router/conf/active/nginx.conf"
+)
+
+// shadowReport holds the most recent comparison of a shadow router's configuration with the active
+// router's, and serves it as plain text.
+type shadowReport struct {
+	mutex    sync.Mutex
+	diff     []string
+	compared time.Time
+}
+
+// update records the provided differences, and returns whether they differ from those previously
+// recorded.
+func (r *shadowReport) update(diff []string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	changed := r.compared.IsZero() || !reflect.DeepEqual(r.diff, diff)
+	r.diff = diff
+	r.compared = time.Now()
+	return changed
+}
+
+func (r *shadowReport) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if r.compared.IsZero() {
+		http.Error(w, "No comparison has been made yet.", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, "# Compared at %s\n", r.compared.UTC().Format(time.RFC3339))
+	for _, line := range r.diff {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// runShadow repeatedly renders configuration from the model, exactly as it would be rendered were
+// it to be applied, and compares it with the configuration of the active router whose snapshot is
+// served at the specified URL.  Nothing is ever applied: nginx is not started, and no certificates
+// are written or obtained.  The differences found are logged whenever they change and recorded in
+// the provided report.
+func runShadow(kubeClient *kubernetes.Clientset, activeConfigURL string, changes <-chan struct{}, rangeSyncer *ipranges.Syncer, resyncPeriod time.Duration, debouncePeriod time.Duration, rateLimiter flowcontrol.RateLimiter, report *shadowReport) {
+	log.Printf("INFO: Running in shadow mode; comparing configuration with %s without applying it.", activeConfigURL)
+	failed := false
+	var retryInterval time.Duration
+	for first := true; ; first = false {
+		if !first {
+			retryInterval = nextRetryInterval(retryInterval, failed, resyncPeriod)
+			wait := resyncPeriod
+			if retryInterval > 0 && retryInterval < resyncPeriod {
+				wait = retryInterval
+			}
+			waitForChanges(changes, nil, rangeSyncer.Changes(), wait, debouncePeriod)
+			failed = false
+		}
+		rateLimiter.Accept()
+		routerConfig, err := model.Build(kubeClient)
+		if err != nil {
+			metrics.ShadowFailures.Inc()
+			log.Printf("Error building model; not comparing configuration: %v.", err)
+			failed = true
+			continue
+		}
+		rangeSyncer.Apply(routerConfig)
+		diff, err := shadowDiff(routerConfig, activeConfigURL)
+		if err != nil {
+			metrics.ShadowFailures.Inc()
+			log.Printf("Failed to compare configuration with the active router's: %v", err)
+			failed = true
+			continue
+		}
+		metrics.ShadowDiffLines.Set(float64(countDiffLines(diff)))
+		if !report.update(diff) {
+			continue
+		}
+		if len(diff) == 0 {
+			log.Println("INFO: Shadow configuration matches the active router's.")
+		} else {
+			log.Printf("INFO: Shadow configuration differs from the active router's:\n%s", strings.Join(diff, "\n"))
+		}
+	}
+}
+
+// shadowDiff renders configuration from the provided model and returns how it differs from that of
+// the active router whose snapshot is served at the specified URL.
+func shadowDiff(routerConfig *model.RouterConfig, activeConfigURL string) ([]string, error) {
+	if err := nginx.WriteConfig(routerConfig, shadowConfigPath); err != nil {
+		return nil, err
+	}
+	snapshot, err := nginx.FetchConfigSnapshot(activeConfigURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := snapshot.Write(activeConfigPath); err != nil {
+		return nil, err
+	}
+	return nginx.Diff(activeConfigPath, shadowConfigPath)
+}
+
+// countDiffLines returns the number of added and removed lines in the provided differences, which
+// also name the files in which they were found.
+func countDiffLines(diff []string) int {
+	count := 0
+	for _, line := range diff {
+		if strings.HasPrefix(line, "-") || strings.HasPrefix(line, "+") {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/deis/router/nginx"
+)
+
+// drainOnShutdown shuts the router down gracefully once it is asked to terminate.  The provided
+// mutex is held while configuration is applied; it is taken, and never released, so that no further
+// configuration is applied.  The router then waits out the pre-stop delay, during which it continues
+// to route requests while load balancers and kube-proxy stop sending it new ones, asks nginx to quit
+// gracefully, and exits once nginx's workers have finished the requests in progress, or once the
+// drain timeout elapses.  The returned channel is closed as soon as the router is asked to
+// terminate, before the mutex is taken, so that work that holds it for long, such as a staged
+// rollout, can be cut short.
+func drainOnShutdown(applying *sync.Mutex, preStopDelay time.Duration, drainTimeout time.Duration) <-chan struct{} {
+	shuttingDown := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		log.Printf("INFO: Received %s; no longer applying configuration changes.", sig)
+		close(shuttingDown)
+		applying.Lock()
+		if preStopDelay > 0 {
+			log.Printf("INFO: Waiting %s before shutting nginx down.", preStopDelay)
+			time.Sleep(preStopDelay)
+		}
+		if err := nginx.Quit(drainTimeout); err != nil {
+			log.Printf("WARN: Failed to drain connections gracefully: %v", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}()
+	return shuttingDown
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/deis/router/model"
+)
+
+// routerStatus describes the router's most recent attempts to build, render, and apply its
+// configuration, along with the configuration in effect.
+type routerStatus struct {
+	// LastBuild is when the model was last built from Kubernetes, and BuildError why that failed, if
+	// it did.
+	LastBuild  time.Time `json:"lastBuild"`
+	BuildError string    `json:"buildError,omitempty"`
+	// LastRender is when nginx configuration was last rendered from a changed model.
+	LastRender time.Time `json:"lastRender"`
+	// LastReload is when nginx was last reloaded, or new configuration rejected, and ReloadError why
+	// the configuration did not take effect, if it did not.
+	LastReload  time.Time `json:"lastReload"`
+	ReloadError string    `json:"reloadError,omitempty"`
+	// Config is the configuration in effect, from which applications that were quarantined are left
+	// out.  Private keys and credentials are never included.
+	Config *model.RouterConfig `json:"config"`
+}
+
+// statusReport holds the router's status, and serves it as JSON.  If the "app" query parameter
+// names an application, only that application's configuration, with every setting resolved, is
+// served.
+type statusReport struct {
+	mutex  sync.Mutex
+	status routerStatus
+}
+
+// build records an attempt to build the model, which failed if err is not nil.
+func (r *statusReport) build(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.status.LastBuild = time.Now()
+	r.status.BuildError = ""
+	if err != nil {
+		r.status.BuildError = err.Error()
+	}
+}
+
+// render records that nginx configuration was rendered.
+func (r *statusReport) render() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.status.LastRender = time.Now()
+}
+
+// reload records an attempt to apply rendered configuration, which failed if err is not nil.
+func (r *statusReport) reload(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.status.LastReload = time.Now()
+	r.status.ReloadError = ""
+	if err != nil {
+		r.status.ReloadError = err.Error()
+	}
+}
+
+// update records the configuration in effect.
+func (r *statusReport) update(routerConfig *model.RouterConfig) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.status.Config = routerConfig
+}
+
+func (r *statusReport) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if name := req.URL.Query().Get("app"); name != "" {
+		if r.status.Config != nil {
+			for _, appConfig := range r.status.Config.AppConfigs {
+				if appConfig.Name == name {
+					w.Header().Set("Content-Type", "application/json")
+					json.NewEncoder(w).Encode(appConfig)
+					return
+				}
+			}
+		}
+		http.Error(w, "No such application is in effect.", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.status)
+}
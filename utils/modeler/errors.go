@@ -61,11 +61,13 @@ func (e NonStructPointerModelError) Error() string {
 }
 
 // ModelValidationError represents an error resulting from a field having a value that doesn't
-// satisfy a prescribed constraint.
+// satisfy a prescribed constraint, or isn't a valid value of the field's type.
 type ModelValidationError struct {
 	field      string
 	constraint string
 	value      string
+	// reason, if set, describes how the value is invalid for the field's type.
+	reason string
 }
 
 func newModelValidationError(field string, constraint string, value string) ModelValidationError {
@@ -76,6 +78,47 @@ func newModelValidationError(field string, constraint string, value string) Mode
 	}
 }
 
+func newModelTypeError(field string, value string, reason string) ModelValidationError {
+	return ModelValidationError{
+		field:  field,
+		value:  value,
+		reason: reason,
+	}
+}
+
 func (e ModelValidationError) Error() string {
+	if e.reason != "" {
+		return fmt.Sprintf("Field \"%s\" value \"%s\" %s", e.field, e.value, e.reason)
+	}
 	return fmt.Sprintf("Field \"%s\" value \"%s\" does not satisfy constraint /%s/", e.field, e.value, e.constraint)
 }
+
+// Field returns the key of the field whose value did not satisfy its constraint.
+func (e ModelValidationError) Field() string {
+	return e.field
+}
+
+// ModelParseError represents an error resulting from a field having a value that cannot be
+// converted to the field's type.
+type ModelParseError struct {
+	field string
+	value string
+	err   error
+}
+
+func newModelParseError(field string, value string, err error) ModelParseError {
+	return ModelParseError{
+		field: field,
+		value: value,
+		err:   err,
+	}
+}
+
+func (e ModelParseError) Error() string {
+	return fmt.Sprintf("Field \"%s\" value \"%s\" cannot be parsed: %s", e.field, e.value, e.err)
+}
+
+// Field returns the key of the field whose value could not be parsed.
+func (e ModelParseError) Field() string {
+	return e.field
+}
@@ -32,10 +32,22 @@ func NewModeler(prefix string, fieldTag string, constraintTag string, warnOnVali
 // MapToModel populates the provided model with values from the provided map.
 func (m *Modeler) MapToModel(data map[string]string, initialContext string, out interface{}) error {
 	rv := reflect.ValueOf(out)
-	return m.mapToModel(data, initialContext, rv)
+	return m.mapToModel(data, initialContext, rv, nil)
 }
 
-func (m *Modeler) mapToModel(data map[string]string, context string, rv reflect.Value) error {
+// MapToModelWithValidationErrors populates the provided model with values from the provided map,
+// just as MapToModel does, and additionally returns the validation errors for any fields that were
+// skipped, in favor of their default values, because their values did not satisfy a constraint.
+func (m *Modeler) MapToModelWithValidationErrors(data map[string]string, initialContext string, out interface{}) ([]ModelValidationError, error) {
+	rv := reflect.ValueOf(out)
+	validationErrs := []ModelValidationError{}
+	err := m.mapToModel(data, initialContext, rv, &validationErrs)
+	return validationErrs, err
+}
+
+// mapToModel does the work of populating a model.  If validationErrs is non-nil, validation errors
+// that were skipped are appended to it.
+func (m *Modeler) mapToModel(data map[string]string, context string, rv reflect.Value, validationErrs *[]ModelValidationError) error {
 	// If rv is invalid (represents a nil literal), we cannot proceed.
 	if rv.Kind() == reflect.Invalid {
 		return newNilLiteralModelError()
@@ -72,7 +84,7 @@ func (m *Modeler) mapToModel(data map[string]string, context string, rv reflect.
 			} else {
 				nestedContext = fmt.Sprintf("%s.%s", context, fieldTagValue)
 			}
-			err := m.mapToModel(data, nestedContext, elem.Field(i))
+			err := m.mapToModel(data, nestedContext, elem.Field(i), validationErrs)
 			if err != nil {
 				return err
 			}
@@ -90,31 +102,31 @@ func (m *Modeler) mapToModel(data map[string]string, context string, rv reflect.
 			}
 			stringVal, ok := data[key]
 			if ok {
-				constraintTagValue := rf.Tag.Get(m.constraintTag)
-				if constraintTagValue != "" {
-					constraint := regexp.MustCompile(constraintTagValue)
-					if !constraint.MatchString(stringVal) {
-						err := newModelValidationError(key, constraintTagValue, stringVal)
-						if m.warnOnValidationError {
-							log.Printf("WARNING: %s -- skipping this field and using default value \"%v\".", err, elem.Field(i))
-							continue
-						} else {
-							return err
+				validVal, err := m.validate(rf, key, stringVal)
+				if err != nil {
+					if m.warnOnValidationError {
+						log.Printf("WARNING: %s -- skipping this field and using default value \"%v\".", err, elem.Field(i))
+						if validationErrs != nil {
+							*validationErrs = append(*validationErrs, err.(ModelValidationError))
 						}
+						continue
+					} else {
+						return err
 					}
 				}
+				stringVal = validVal
 				if rf.Type.Kind() == reflect.String {
 					elem.Field(i).Set(reflect.ValueOf(stringVal))
 				} else if rf.Type.Kind() == reflect.Int {
 					intVal, err := strconv.Atoi(stringVal)
 					if err != nil {
-						return err
+						return newModelParseError(key, stringVal, err)
 					}
 					elem.Field(i).Set(reflect.ValueOf(intVal))
 				} else if rf.Type.Kind() == reflect.Bool {
 					boolVal, err := strconv.ParseBool(strings.ToLower(stringVal))
 					if err != nil {
-						return err
+						return newModelParseError(key, stringVal, err)
 					}
 					elem.Field(i).Set(reflect.ValueOf(boolVal))
 				} else if rf.Type.Kind() == reflect.Slice {
@@ -128,6 +140,9 @@ func (m *Modeler) mapToModel(data map[string]string, context string, rv reflect.
 					mapVal := make(map[string]string, len(sliceVal))
 					for _, kvStr := range sliceVal {
 						kvTokens := strings.Split(kvStr, ":")
+						if len(kvTokens) < 2 {
+							return newModelParseError(key, stringVal, fmt.Errorf("\"%s\" is not a key:value pair", kvStr))
+						}
 						key := strings.TrimSpace(kvTokens[0])
 						value := strings.TrimSpace(kvTokens[1])
 						mapVal[key] = value
@@ -141,3 +156,31 @@ func (m *Modeler) mapToModel(data map[string]string, context string, rv reflect.
 	}
 	return nil
 }
+
+// validate checks the provided value of the specified field against the field's constraint and any
+// type, range, or enumeration it is tagged with, and returns the value the field should be set to.
+func (m *Modeler) validate(rf reflect.StructField, key string, value string) (string, error) {
+	constraintTagValue := rf.Tag.Get(m.constraintTag)
+	if constraintTagValue != "" {
+		constraint := regexp.MustCompile(constraintTagValue)
+		if !constraint.MatchString(value) {
+			return "", newModelValidationError(key, constraintTagValue, value)
+		}
+	}
+	switch rf.Type.Kind() {
+	case reflect.String:
+		validVal, err := checkString(rf, value)
+		if err != nil {
+			return "", newModelTypeError(key, value, err.Error())
+		}
+		return validVal, nil
+	case reflect.Int:
+		// Values that are not integers at all are reported as parse errors when they are converted.
+		if intVal, err := strconv.Atoi(value); err == nil {
+			if err := checkInt(rf, intVal); err != nil {
+				return "", newModelTypeError(key, value, err.Error())
+			}
+		}
+	}
+	return value, nil
+}
@@ -90,6 +90,32 @@ func TestValidationError(t *testing.T) {
 	checkError(t, "modeler.ModelValidationError", err)
 }
 
+func TestValidationErrorsCollected(t *testing.T) {
+	warningModeler := NewModeler(prefix, fieldTag, constraintTag, true)
+	sampleModel := newSampleModel()
+	validationErrs, err := warningModeler.MapToModelWithValidationErrors(invalidSampleData, "", sampleModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(validationErrs) != 1 {
+		t.Fatalf("Expected 1 validation error, but got %d", len(validationErrs))
+	}
+	checkStringField(t, prefix+"/a_string", validationErrs[0].Field())
+	checkStringField(t, "", sampleModel.SampleString)
+}
+
+func TestParseError(t *testing.T) {
+	for _, data := range []map[string]string{
+		{prefix + "/an_int": "five"},
+		{prefix + "/a_bool": "maybe"},
+		{prefix + "/a_string_map": "foo:bar,baz"},
+	} {
+		sampleModel := newSampleModel()
+		err := m.MapToModel(data, "", sampleModel)
+		checkError(t, "modeler.ModelParseError", err)
+	}
+}
+
 func TestMapping(t *testing.T) {
 	sampleModel := newSampleModel()
 	err := m.MapToModel(sampleData, "", sampleModel)
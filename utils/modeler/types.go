@@ -0,0 +1,173 @@
+package modeler
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields may be further described by the following struct tags, in addition to their key and
+// constraint tags.  The type tag, one of "duration", "size", or "offset", requires that the field's
+// value be an nginx time, size (with an optional k or m suffix), or offset (with an optional k, m,
+// or g suffix), respectively.  The min and max tags bound the values, of the field's type, the
+// field may take; for int fields, these are plain integers.  The enum tag lists, delimited by "|",
+// the values the field may take.  A value that violates any of these is a validation error, just
+// as one that does not satisfy the field's constraint is.
+const (
+	typeTag = "type"
+	minTag  = "min"
+	maxTag  = "max"
+	enumTag = "enum"
+)
+
+var (
+	durationRegexp     = regexp.MustCompile("^([0-9]+|([0-9]+(ms|[smhdwMy]))+)$")
+	durationUnitRegexp = regexp.MustCompile("([0-9]+)(ms|[smhdwMy])")
+	sizeRegexp         = regexp.MustCompile("^([0-9]+)([kKmM]?)$")
+	offsetRegexp       = regexp.MustCompile("^([0-9]+)([kKmMgG]?)$")
+	durationUnits      = map[string]time.Duration{
+		"ms": time.Millisecond,
+		"s":  time.Second,
+		"m":  time.Minute,
+		"h":  time.Hour,
+		"d":  24 * time.Hour,
+		"w":  7 * 24 * time.Hour,
+		"M":  30 * 24 * time.Hour,
+		"y":  365 * 24 * time.Hour,
+	}
+	sizeUnits = map[string]int64{
+		"":  1,
+		"k": 1 << 10,
+		"m": 1 << 20,
+		"g": 1 << 30,
+	}
+)
+
+// ParseDuration parses an nginx time, such as "30s" or "1h30m": a sequence of numbers, each
+// followed by one of the units ms, s, m, h, d, w, M (30 days), or y (365 days).  A lone number is a
+// number of seconds.
+func ParseDuration(value string) (time.Duration, error) {
+	if !durationRegexp.MatchString(value) {
+		return 0, fmt.Errorf("\"%s\" is not a valid duration", value)
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return scale(value, seconds, int64(time.Second))
+	}
+	var total time.Duration
+	for _, match := range durationUnitRegexp.FindAllStringSubmatch(value, -1) {
+		n, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("\"%s\" is too long a duration", value)
+		}
+		d, err := scale(value, n, int64(durationUnits[match[2]]))
+		if err != nil {
+			return 0, err
+		}
+		total += d
+		if total < 0 {
+			return 0, fmt.Errorf("\"%s\" is too long a duration", value)
+		}
+	}
+	return total, nil
+}
+
+func scale(value string, n int64, unit int64) (time.Duration, error) {
+	if n > (1<<63-1)/unit {
+		return 0, fmt.Errorf("\"%s\" is too long a duration", value)
+	}
+	return time.Duration(n * unit), nil
+}
+
+// ParseSize parses an nginx size, such as "6k" or "1m": a number of bytes, optionally followed by
+// a k or m suffix, in either case.
+func ParseSize(value string) (int64, error) {
+	return parseBytes(value, sizeRegexp, "size")
+}
+
+// ParseOffset parses an nginx offset, which is a size that may also be expressed in gigabytes,
+// such as "1g".
+func ParseOffset(value string) (int64, error) {
+	return parseBytes(value, offsetRegexp, "offset")
+}
+
+func parseBytes(value string, re *regexp.Regexp, kind string) (int64, error) {
+	match := re.FindStringSubmatch(value)
+	if match == nil {
+		return 0, fmt.Errorf("\"%s\" is not a valid %s", value, kind)
+	}
+	n, err := strconv.ParseInt(match[1], 10, 64)
+	unit := sizeUnits[strings.ToLower(match[2])]
+	if err != nil || n > (1<<63-1)/unit {
+		return 0, fmt.Errorf("\"%s\" is too large a %s", value, kind)
+	}
+	return n * unit, nil
+}
+
+// checkString validates the provided value of a string field against the field's type, min, max,
+// and enum tags, and returns the value the field should be set to.
+func checkString(rf reflect.StructField, value string) (string, error) {
+	if enum := rf.Tag.Get(enumTag); enum != "" {
+		for _, candidate := range strings.Split(enum, "|") {
+			if value == candidate {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("is not one of %s", strings.Replace(enum, "|", ", ", -1))
+	}
+	var parse func(string) (int64, error)
+	switch rf.Tag.Get(typeTag) {
+	case "":
+		return value, nil
+	case "duration":
+		parse = func(value string) (int64, error) {
+			d, err := ParseDuration(value)
+			return int64(d), err
+		}
+	case "size":
+		parse = ParseSize
+	case "offset":
+		parse = ParseOffset
+	default:
+		return "", fmt.Errorf("has unsupported type %s", rf.Tag.Get(typeTag))
+	}
+	n, err := parse(value)
+	if err != nil {
+		return "", fmt.Errorf("is not a valid %s", rf.Tag.Get(typeTag))
+	}
+	if err := checkRange(rf, n, parse); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// checkInt validates the provided value of an int field against the field's min and max tags.
+func checkInt(rf reflect.StructField, value int) error {
+	return checkRange(rf, int64(value), func(value string) (int64, error) {
+		return strconv.ParseInt(value, 10, 64)
+	})
+}
+
+func checkRange(rf reflect.StructField, n int64, parse func(string) (int64, error)) error {
+	if min := rf.Tag.Get(minTag); min != "" {
+		bound, err := parse(min)
+		if err != nil {
+			return fmt.Errorf("has an invalid minimum %s", min)
+		}
+		if n < bound {
+			return fmt.Errorf("is less than the minimum of %s", min)
+		}
+	}
+	if max := rf.Tag.Get(maxTag); max != "" {
+		bound, err := parse(max)
+		if err != nil {
+			return fmt.Errorf("has an invalid maximum %s", max)
+		}
+		if n > bound {
+			return fmt.Errorf("is greater than the maximum of %s", max)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package modeler
+
+import (
+	"testing"
+	"time"
+)
+
+type TypedSampleModel struct {
+	SampleDuration string `sample:"a_duration" type:"duration" min:"1ms" max:"1h"`
+	SampleSize     string `sample:"a_size" type:"size" min:"32k"`
+	SampleOffset   string `sample:"an_offset" type:"offset"`
+	SampleEnum     string `sample:"an_enum" enum:"on|off"`
+	SampleInt      int    `sample:"an_int" min:"0" max:"100"`
+}
+
+func TestParseDuration(t *testing.T) {
+	valid := map[string]time.Duration{
+		"0":       0,
+		"30":      30 * time.Second,
+		"1500s":   1500 * time.Second,
+		"500ms":   500 * time.Millisecond,
+		"1h30m":   90 * time.Minute,
+		"2d":      48 * time.Hour,
+		"1w":      7 * 24 * time.Hour,
+		"1M":      30 * 24 * time.Hour,
+		"1y":      365 * 24 * time.Hour,
+		"1m500ms": time.Minute + 500*time.Millisecond,
+	}
+	for value, expected := range valid {
+		actual, err := ParseDuration(value)
+		if err != nil {
+			t.Errorf("Expected \"%s\" to be a valid duration, but got %v", value, err)
+		} else if actual != expected {
+			t.Errorf("Expected \"%s\" to be %s, but got %s", value, expected, actual)
+		}
+	}
+	for _, value := range []string{"", "s", "1.5s", "-1s", "1 s", "10x", "30s;", "99999999999999999999s", "9999999999y"} {
+		if _, err := ParseDuration(value); err == nil {
+			t.Errorf("Expected \"%s\" to be an invalid duration", value)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	valid := map[string]int64{
+		"0":   0,
+		"512": 512,
+		"6k":  6 << 10,
+		"6K":  6 << 10,
+		"1m":  1 << 20,
+		"1M":  1 << 20,
+	}
+	for value, expected := range valid {
+		actual, err := ParseSize(value)
+		if err != nil {
+			t.Errorf("Expected \"%s\" to be a valid size, but got %v", value, err)
+		} else if actual != expected {
+			t.Errorf("Expected \"%s\" to be %d, but got %d", value, expected, actual)
+		}
+	}
+	for _, value := range []string{"", "k", "1g", "1.5m", "-1", "1mb", "99999999999999999999"} {
+		if _, err := ParseSize(value); err == nil {
+			t.Errorf("Expected \"%s\" to be an invalid size", value)
+		}
+	}
+	if actual, err := ParseOffset("2G"); err != nil || actual != 2<<30 {
+		t.Errorf("Expected \"2G\" to be a valid offset of %d, but got %d (%v)", 2<<30, actual, err)
+	}
+}
+
+func TestTypedMapping(t *testing.T) {
+	typedModel := &TypedSampleModel{}
+	err := m.MapToModel(map[string]string{
+		prefix + "/a_duration": "1m30s",
+		prefix + "/a_size":     "1m",
+		prefix + "/an_offset":  "1g",
+		prefix + "/an_enum":    "off",
+		prefix + "/an_int":     "100",
+	}, "", typedModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStringField(t, "1m30s", typedModel.SampleDuration)
+	checkStringField(t, "1m", typedModel.SampleSize)
+	checkStringField(t, "1g", typedModel.SampleOffset)
+	checkStringField(t, "off", typedModel.SampleEnum)
+	checkIntField(t, "100", typedModel.SampleInt)
+}
+
+func TestTypedValidationErrors(t *testing.T) {
+	for key, value := range map[string]string{
+		"a_duration": "2h",
+		"a_size":     "1k",
+		"an_offset":  "1t",
+		"an_enum":    "OFF",
+		"an_int":     "101",
+	} {
+		typedModel := &TypedSampleModel{}
+		err := m.MapToModel(map[string]string{prefix + "/" + key: value}, "", typedModel)
+		checkError(t, "modeler.ModelValidationError", err)
+	}
+}
+
+func TestTypedValidationDefaults(t *testing.T) {
+	warningModeler := NewModeler(prefix, fieldTag, constraintTag, true)
+	typedModel := &TypedSampleModel{SampleDuration: "30s", SampleInt: 5}
+	validationErrs, err := warningModeler.MapToModelWithValidationErrors(map[string]string{
+		prefix + "/a_duration": "0ms",
+		prefix + "/an_int":     "-1",
+	}, "", typedModel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(validationErrs) != 2 {
+		t.Fatalf("Expected 2 validation errors, but got %d", len(validationErrs))
+	}
+	checkStringField(t, "30s", typedModel.SampleDuration)
+	checkIntField(t, "5", typedModel.SampleInt)
+}
@@ -1,9 +1,28 @@
 package utils
 
 import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"os"
+	"strconv"
+	"strings"
 )
 
+// somaxconnPath is the location of the kernel setting that caps the length of every socket's queue
+// of pending connections.
+var somaxconnPath = "/proc/sys/net/core/somaxconn"
+
+// tcpTablePaths are the locations of the kernel's tables of the IPv4 and IPv6 TCP sockets in the
+// pod's network namespace.
+var tcpTablePaths = []string{"/proc/net/tcp", "/proc/net/tcp6"}
+
+// tcpEstablished is the state, as the kernel's TCP tables name it, of an established connection.
+const tcpEstablished = "01"
+
 // GetOpt returns the specified environment variable's value or a default value if that
 // environment variable's value is the empty string.
 func GetOpt(name string, dfault string) string {
@@ -13,3 +32,75 @@ func GetOpt(name string, dfault string) string {
 	}
 	return value
 }
+
+// Somaxconn returns the kernel's cap on the length of every socket's queue of pending connections.
+func Somaxconn() (int, error) {
+	data, err := ioutil.ReadFile(somaxconnPath)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// EstablishedConnections returns the number of established TCP connections in the pod's network
+// namespace to each remote address, in host:port form.
+func EstablishedConnections() (map[string]int, error) {
+	connections := map[string]int{}
+	for _, path := range tcpTablePaths {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) {
+			// IPv6 may be disabled altogether.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = countEstablished(file, connections)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", path, err)
+		}
+	}
+	return connections, nil
+}
+
+// countEstablished tallies the established connections in the provided TCP table by remote address.
+func countEstablished(r io.Reader, connections map[string]int) error {
+	scanner := bufio.NewScanner(r)
+	// The first line names the columns.
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[3] != tcpEstablished {
+			continue
+		}
+		address, err := parseTCPTableAddress(fields[2])
+		if err != nil {
+			return err
+		}
+		connections[address]++
+	}
+	return scanner.Err()
+}
+
+// parseTCPTableAddress converts an address as the kernel's TCP tables write it, a hexadecimal IP
+// address in host byte order, 32 bits at a time, followed by a hexadecimal port, to host:port form.
+func parseTCPTableAddress(address string) (string, error) {
+	parts := strings.Split(address, ":")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("malformed address %s", address)
+	}
+	raw, err := hex.DecodeString(parts[0])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", fmt.Errorf("malformed address %s", address)
+	}
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("malformed address %s", address)
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		ip[i], ip[i+1], ip[i+2], ip[i+3] = raw[i+3], raw[i+2], raw[i+1], raw[i]
+	}
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port))), nil
+}
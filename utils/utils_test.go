@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"io/ioutil"
 	"os"
 	"reflect"
 	"testing"
@@ -30,3 +31,67 @@ func TestGetOpt(t *testing.T) {
 		t.Errorf("Expected %s, but got %s", expected, actual)
 	}
 }
+
+func TestSomaxconn(t *testing.T) {
+	file, err := ioutil.TempFile("", "somaxconn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString("4096\n"); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer func(path string) { somaxconnPath = path }(somaxconnPath)
+	somaxconnPath = file.Name()
+
+	actual, err := Somaxconn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual != 4096 {
+		t.Errorf("Expected 4096, but got %d", actual)
+	}
+}
+
+func TestEstablishedConnections(t *testing.T) {
+	tcp, err := ioutil.TempFile("", "tcp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tcp.Name())
+	// Connections to 10.0.0.5:8000 and from 127.0.0.1, and a socket listening on 8080.
+	if _, err := tcp.WriteString(`  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1 1 0000000000000000 100 0 0 10 0
+   1: 0A00000A:C350 0500000A:1F40 01 00000000:00000000 00:00000000 00000000     0        0 2 1 0000000000000000 20 4 30 10 -1
+   2: 0A00000A:C351 0500000A:1F40 01 00000000:00000000 00:00000000 00000000     0        0 3 1 0000000000000000 20 4 30 10 -1
+   3: 0100007F:1F90 0100007F:D431 01 00000000:00000000 00:00000000 00000000     0        0 4 1 0000000000000000 20 4 30 10 -1
+   4: 0A00000A:C352 0600000A:1F40 06 00000000:00000000 00:00000000 00000000     0        0 0 3 0000000000000000
+`); err != nil {
+		t.Fatal(err)
+	}
+	tcp.Close()
+	tcp6, err := ioutil.TempFile("", "tcp6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tcp6.Name())
+	// A connection to [fd00::5]:8000.
+	if _, err := tcp6.WriteString(`  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 000000FD000000000000000001000000:C350 000000FD000000000000000005000000:1F40 01 00000000:00000000 00:00000000 00000000     0        0 5 1 0000000000000000 20 4 30 10 -1
+`); err != nil {
+		t.Fatal(err)
+	}
+	tcp6.Close()
+	defer func(paths []string) { tcpTablePaths = paths }(tcpTablePaths)
+	tcpTablePaths = []string{tcp.Name(), tcp6.Name(), tcp6.Name() + ".missing"}
+
+	actual, err := EstablishedConnections()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{"10.0.0.5:8000": 2, "127.0.0.1:54321": 1, "[fd00::5]:8000": 1}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %v, but got %v", expected, actual)
+	}
+}